@@ -0,0 +1,78 @@
+// Package atomicfile writes output files through a temporary file in the same
+// directory that is renamed into place on success, so readers (and a crash midway)
+// never see a truncated report.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// File is an output file being written; nothing appears at its path until Commit.
+type File struct {
+	*os.File
+	path string
+	done bool
+}
+
+// Create starts writing path.
+func Create(path string) (*File, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: tmp, path: path}, nil
+}
+
+// Commit flushes the data to disk and renames the file into place.
+func (f *File) Commit() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	if err := f.File.Sync(); err != nil {
+		f.abort()
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	if err := os.Chmod(f.File.Name(), 0o644); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	if err := os.Rename(f.File.Name(), f.path); err != nil {
+		os.Remove(f.File.Name())
+		return err
+	}
+	return nil
+}
+
+// Close discards the file unless it was committed; it is meant to be deferred.
+func (f *File) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	f.abort()
+	return nil
+}
+
+func (f *File) abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+// WriteFile is the atomic counterpart of os.WriteFile.
+func WriteFile(path string, data []byte) error {
+	f, err := Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Commit()
+}
@@ -0,0 +1,275 @@
+// Package config loads scenario files: a YAML (or JSON) description of a simulation run
+// that stands in for the long list of command-line flags.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario mirrors the command-line flags, grouped by concern. Unset fields (nil
+// pointers, empty strings) leave the flag default in place.
+type Scenario struct {
+	Route    string  `yaml:"route"`    // -route_file
+	Corridor string  `yaml:"corridor"` // -route (id in the routes index)
+	Routes   string  `yaml:"routes"`   // -routes_index
+	Fleet    string  `yaml:"fleet"`    // -fleet_file
+	Vehicles string  `yaml:"vehicles"` // -vehicle_params
+	Seed     *int64  `yaml:"seed"`
+	Demand   Demand  `yaml:"demand"`
+	Run      Run     `yaml:"run"`
+	Reports  Reports `yaml:"reports"`
+	Logging  Logging `yaml:"logging"`
+}
+
+// Demand holds the passenger generation parameters.
+type Demand struct {
+	Period                *int     `yaml:"period"`  // 1..6
+	Profile               string   `yaml:"profile"` // flat | period
+	PassengerCap          *int     `yaml:"passenger_cap"`
+	ArrivalFactor         *float64 `yaml:"arrival_factor"`
+	Lambda                *float64 `yaml:"lambda"` // base arrivals per corridor per minute
+	MorningTowardKivukoni *bool    `yaml:"morning_toward_kivukoni"`
+	DirBias               *float64 `yaml:"dir_bias"`
+	SpatialGradient       *float64 `yaml:"spatial_gradient"`
+	BaselineDemand        *float64 `yaml:"baseline_demand"`
+	GroupSizes            string   `yaml:"group_sizes"` // e.g. "1:0.7,2:0.2,4:0.1"
+	Population            *int     `yaml:"population"`  // synthetic commuters (0 = Poisson arrivals)
+	Day                   string   `yaml:"day"`         // full-day run, e.g. "default" or "2@06:00,3@09:00,end@12:00"
+	Seeding               Seeding  `yaml:"seeding"`
+}
+
+// Seeding configures the backdating of initial passengers.
+type Seeding struct {
+	WindowMinutes *float64 `yaml:"window_minutes"`
+	Dist          string   `yaml:"dist"` // uniform | exponential | none
+	ExcludeWait   *bool    `yaml:"exclude_wait"`
+}
+
+// Run selects the driver and its pacing.
+type Run struct {
+	Driver        string   `yaml:"driver"` // sse | batch | memory | sweep
+	Addr          string   `yaml:"addr"`
+	GRPCAddr      string   `yaml:"grpc_addr"`
+	MaxStreams    *int     `yaml:"max_streams"`
+	TimeScale     *float64 `yaml:"time_scale"`
+	MaxSpeed      *float64 `yaml:"max_speed"`      // .inf lets streams run unpaced
+	EventThrottle string   `yaml:"event_throttle"` // e.g. "100ms"
+	Backpressure  string   `yaml:"backpressure"`   // block | drop_moves | disconnect | expand
+	EventBuffer   *int     `yaml:"event_buffer"`
+	SlowTimeout   string   `yaml:"slow_client_timeout"` // e.g. "5s"
+	Sink          string   `yaml:"sink"`                // redis://host:6379/key or kafka+http://proxy:8082/topic
+	StallMinutes  *float64 `yaml:"stall_minutes"`
+	SimHours      *float64 `yaml:"sim_hours"` // stop criterion: simulated horizon
+	MaxTrips      *int     `yaml:"max_trips"` // stop criterion: completed one-way trips
+	TrafficURL    string   `yaml:"traffic_url"`
+	StaticDir     string   `yaml:"static_dir"`
+	Sweep         string   `yaml:"sweep"` // e.g. "fleet=4:10:2;arrival_factor=0.5,1,1.5"
+	SweepOut      string   `yaml:"sweep_out"`
+	SweepParallel *int     `yaml:"sweep_parallel"` // deprecated: use workers
+	Workers       *int     `yaml:"workers"`
+	Replications  *int     `yaml:"replications"` // batch: consecutive seeds with confidence intervals
+	TargetWait    *float64 `yaml:"target_wait"`  // optimize: minutes the fleet must meet
+	TargetMetric  string   `yaml:"target_metric"`
+	MaxBuses      *int     `yaml:"max_buses"`
+	VaryTypes     *bool    `yaml:"vary_types"`
+	Eco           *bool    `yaml:"eco"` // eco-driving speed advisory
+	EcoTimeWeight *float64 `yaml:"eco_time_weight"`
+	Shifts        string   `yaml:"shifts"`           // e.g. "duty=8h,drive=4h,break=30m,relief=10m"
+	Start         string   `yaml:"start"`            // simulated start, e.g. "07:00" or "2024-03-04T06:30"
+	Observed      string   `yaml:"observed"`         // calibrate: observed boardings CSV
+	CalibParams   string   `yaml:"calibrate_params"` // e.g. "lambda,dir_bias"
+	CalibRounds   *int     `yaml:"calibrate_rounds"`
+	AVLFile       string   `yaml:"avl_file"` // GPS/AVL trace CSV for -driver avl and ?source=avl streams
+	AVLStopRadius *float64 `yaml:"avl_stop_radius_m"`
+	PlatformCap   *int     `yaml:"platform_capacity"` // default for stops without their own
+	PlatformSpill *bool    `yaml:"platform_spill"`
+	Berths        *int     `yaml:"berths"` // default for stops without their own
+	TurnBays      *int     `yaml:"turnaround_bays"`
+	TurnSeconds   *float64 `yaml:"turnaround_s"`
+	Recovery      string   `yaml:"recovery"` // e.g. "3,kivukoni=5,@2=8"
+}
+
+// Reports lists the outputs written at the end of a run.
+type Reports struct {
+	Report         string    `yaml:"report"`
+	Format         string    `yaml:"format"` // csv | xlsx | html | json
+	PassengerLog   string    `yaml:"passenger_log"`
+	DecisionLog    string    `yaml:"decision_log"`
+	TrajectoryLog  string    `yaml:"trajectory_log"`
+	DwellReport    string    `yaml:"dwell_report"`
+	LoadReport     string    `yaml:"load_report"`
+	SimplifyM      *float64  `yaml:"simplify_m"`
+	Export         string    `yaml:"export"` // matsim | sumo
+	ExportDir      string    `yaml:"export_dir"`
+	MetricsSeconds *float64  `yaml:"metrics_seconds"`
+	ETASeconds     *float64  `yaml:"eta_seconds"`
+	QualityWeights string    `yaml:"quality_weights"`
+	HeadwayTargets string    `yaml:"headway_targets"` // e.g. "2:4,5:4"
+	HeadwayTol     *float64  `yaml:"headway_tolerance"`
+	Precision      Precision `yaml:"precision"`
+}
+
+// Precision sets report rounding.
+type Precision struct {
+	KM       *int  `yaml:"km"`
+	Currency *int  `yaml:"currency"`
+	Minutes  *int  `yaml:"minutes"`
+	Full     *bool `yaml:"full"`
+}
+
+// Logging configures logs and traces.
+type Logging struct {
+	Level     string `yaml:"level"`
+	Format    string `yaml:"format"`
+	TraceBus  *int   `yaml:"trace_bus"`
+	OtelTrace string `yaml:"otel_trace"`
+}
+
+// Load reads a scenario from a YAML or JSON file. Unknown keys are rejected so that
+// typos do not silently fall back to defaults.
+func Load(path string) (*Scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	var s Scenario
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Setting is one flag assignment derived from a scenario.
+type Setting struct {
+	Flag  string
+	Value string
+}
+
+// Settings lists the flag assignments for every field the scenario sets.
+func (s *Scenario) Settings() []Setting {
+	var out []Setting
+	str := func(flag, v string) {
+		if v != "" {
+			out = append(out, Setting{flag, v})
+		}
+	}
+	num := func(flag string, v any) {
+		switch p := v.(type) {
+		case *int:
+			if p != nil {
+				out = append(out, Setting{flag, fmt.Sprint(*p)})
+			}
+		case *int64:
+			if p != nil {
+				out = append(out, Setting{flag, fmt.Sprint(*p)})
+			}
+		case *float64:
+			if p != nil {
+				out = append(out, Setting{flag, fmt.Sprint(*p)})
+			}
+		case *bool:
+			if p != nil {
+				out = append(out, Setting{flag, fmt.Sprint(*p)})
+			}
+		}
+	}
+	str("route_file", s.Route)
+	str("route", s.Corridor)
+	str("routes_index", s.Routes)
+	str("fleet_file", s.Fleet)
+	str("vehicle_params", s.Vehicles)
+	num("seed", s.Seed)
+
+	d := &s.Demand
+	num("period", d.Period)
+	str("demand_profile", d.Profile)
+	num("passenger_cap", d.PassengerCap)
+	num("arrival_factor", d.ArrivalFactor)
+	num("lambda", d.Lambda)
+	num("morning_toward_kivukoni", d.MorningTowardKivukoni)
+	num("dir_bias", d.DirBias)
+	num("spatial_gradient", d.SpatialGradient)
+	num("baseline_demand", d.BaselineDemand)
+	str("group_sizes", d.GroupSizes)
+	num("population", d.Population)
+	str("day", d.Day)
+	num("seed_window_minutes", d.Seeding.WindowMinutes)
+	str("seed_dist", d.Seeding.Dist)
+	num("exclude_seeded_wait", d.Seeding.ExcludeWait)
+
+	r := &s.Run
+	str("driver", r.Driver)
+	str("addr", r.Addr)
+	str("grpc_addr", r.GRPCAddr)
+	num("max_streams", r.MaxStreams)
+	num("time_scale", r.TimeScale)
+	num("max_speed", r.MaxSpeed)
+	str("event_throttle", r.EventThrottle)
+	str("backpressure", r.Backpressure)
+	num("event_buffer", r.EventBuffer)
+	str("slow_client_timeout", r.SlowTimeout)
+	str("sink", r.Sink)
+	num("stall_minutes", r.StallMinutes)
+	num("sim_hours", r.SimHours)
+	num("max_trips", r.MaxTrips)
+	str("traffic_url", r.TrafficURL)
+	str("static_dir", r.StaticDir)
+	str("sweep", r.Sweep)
+	str("sweep_out", r.SweepOut)
+	num("sweep_parallel", r.SweepParallel)
+	num("workers", r.Workers)
+	num("replications", r.Replications)
+	num("target_wait", r.TargetWait)
+	str("target_metric", r.TargetMetric)
+	num("max_buses", r.MaxBuses)
+	num("vary_types", r.VaryTypes)
+	num("eco", r.Eco)
+	num("eco_time_weight", r.EcoTimeWeight)
+	str("shifts", r.Shifts)
+	str("start", r.Start)
+	str("observed", r.Observed)
+	str("calibrate_params", r.CalibParams)
+	num("calibrate_rounds", r.CalibRounds)
+	str("avl_file", r.AVLFile)
+	num("avl_stop_radius_m", r.AVLStopRadius)
+	num("platform_capacity", r.PlatformCap)
+	num("platform_spill", r.PlatformSpill)
+	num("berths", r.Berths)
+	num("turnaround_bays", r.TurnBays)
+	num("turnaround_s", r.TurnSeconds)
+	str("recovery", r.Recovery)
+
+	o := &s.Reports
+	str("report", o.Report)
+	str("format", o.Format)
+	str("passenger_log", o.PassengerLog)
+	str("decision_log", o.DecisionLog)
+	str("trajectory_log", o.TrajectoryLog)
+	str("dwell_report", o.DwellReport)
+	str("load_report", o.LoadReport)
+	num("simplify_m", o.SimplifyM)
+	str("export", o.Export)
+	str("export_dir", o.ExportDir)
+	num("metrics_seconds", o.MetricsSeconds)
+	num("eta_seconds", o.ETASeconds)
+	str("quality_weights", o.QualityWeights)
+	str("headway_targets", o.HeadwayTargets)
+	num("headway_tolerance", o.HeadwayTol)
+	num("precision_km", o.Precision.KM)
+	num("precision_currency", o.Precision.Currency)
+	num("precision_minutes", o.Precision.Minutes)
+	num("full_precision", o.Precision.Full)
+
+	l := &s.Logging
+	str("log_level", l.Level)
+	str("log_format", l.Format)
+	num("trace_bus", l.TraceBus)
+	str("otel_trace", l.OtelTrace)
+	return out
+}
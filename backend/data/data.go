@@ -1,5 +1,10 @@
 package data
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // TimePeriodMultiplier maps a period id (1..n) to a demand multiplier.
 // Example semantics (assumed):
 // 1 = very early off-peak, 2 = morning peak, 3 = late morning, 4 = mid-day, 5 = evening peak, 6 = late evening.
@@ -10,4 +15,74 @@ var TimePeriodMultiplier = map[int]float64{
 	4: 0.8,
 	5: 1.4,
 	6: 0.5,
-}
\ No newline at end of file
+}
+
+// TimePeriod describes a demand period window as minutes after midnight.
+type TimePeriod struct {
+	ID       int
+	Name     string
+	StartMin int
+	EndMin   int
+}
+
+// TimePeriods lists the demand periods in chronological order, read from the bundled
+// time_periods.json.
+var TimePeriods []TimePeriod
+
+// TargetHeadwayMin maps a period id to the scheduled headway in minutes (between buses
+// at a stop in one direction) that headway adherence is measured against: the
+// target_headway_min of each period in time_periods.json.
+var TargetHeadwayMin map[int]float64
+
+func init() {
+	var err error
+	if TimePeriods, TargetHeadwayMin, err = loadTimePeriods(); err != nil {
+		panic("data: time_periods.json: " + err.Error())
+	}
+}
+
+// loadTimePeriods parses the bundled period windows and their target headways.
+func loadTimePeriods() ([]TimePeriod, map[int]float64, error) {
+	raw, err := files.ReadFile("time_periods.json")
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc struct {
+		Periods []struct {
+			ID        int     `json:"period_id"`
+			Name      string  `json:"period_name"`
+			Start     string  `json:"start_time"`
+			End       string  `json:"end_time"`
+			TargetMin float64 `json:"target_headway_min"`
+		} `json:"periods"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, err
+	}
+	periods := make([]TimePeriod, 0, len(doc.Periods))
+	targets := make(map[int]float64, len(doc.Periods))
+	for _, p := range doc.Periods {
+		start, err := clockMinutes(p.Start)
+		if err != nil {
+			return nil, nil, fmt.Errorf("period %d: %w", p.ID, err)
+		}
+		end, err := clockMinutes(p.End)
+		if err != nil {
+			return nil, nil, fmt.Errorf("period %d: %w", p.ID, err)
+		}
+		periods = append(periods, TimePeriod{ID: p.ID, Name: p.Name, StartMin: start, EndMin: end})
+		if p.TargetMin > 0 {
+			targets[p.ID] = p.TargetMin
+		}
+	}
+	return periods, targets, nil
+}
+
+// clockMinutes parses HH:MM as minutes after midnight.
+func clockMinutes(hhmm string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(hhmm, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("time %q: want HH:MM", hhmm)
+	}
+	return h*60 + m, nil
+}
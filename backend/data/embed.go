@@ -0,0 +1,48 @@
+package data
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// files are the bundled datasets, so the binary runs from any working directory.
+//
+//go:embed kimara_kivukoni_stops.json fleet.json vehicle_params.json routes.json time_periods.json
+var files embed.FS
+
+// FS returns the embedded datasets (kimara_kivukoni_stops.json, fleet.json,
+// vehicle_params.json, routes.json and time_periods.json).
+func FS() fs.FS { return files }
+
+// Open opens name on disk. When it does not exist and name is a bundled dataset under a
+// relative data/ directory (the default paths, e.g. "data/fleet.json"), the embedded
+// copy is returned instead; files on disk always win.
+func Open(name string) (fs.File, error) {
+	f, err := os.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	dir, base := path.Split(filepath.ToSlash(filepath.Clean(name)))
+	if dir != "data/" {
+		return nil, err
+	}
+	ef, eerr := files.Open(base)
+	if eerr != nil {
+		return nil, err
+	}
+	return ef, nil
+}
+
+// Embedded reports whether Open would serve name from the binary.
+func Embedded(name string) bool {
+	if _, err := os.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+		return false
+	}
+	dir, base := path.Split(filepath.ToSlash(filepath.Clean(name)))
+	_, err := fs.Stat(files, base)
+	return dir == "data/" && err == nil
+}
@@ -0,0 +1,206 @@
+package driver
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+// AVLComparison pairs the operations observed in an AVL trace with a batch run of the
+// same corridor, fleet size, start time and duration.
+type AVLComparison struct {
+	Points         int // position reports in the trace
+	OffRoute       int // reports dropped as too far from the route
+	Buses          int
+	Start          time.Time
+	Duration       time.Duration
+	Observed       sim.DoneEvent // the trace played through sim.AVLPlayer
+	Simulated      Summary
+	SimulatedDwell []sim.StopDwellStats
+}
+
+// CompareAVL plays trace on route and runs base on a fresh route with as many buses
+// as the trace has, starting when the trace starts and, unless base sets a stop
+// criterion, lasting as long. A zero base.Seed is fixed first.
+func CompareAVL(ctx context.Context, newRoute func() (*model.Route, error), fleet []*model.Bus, trace sim.AVLTrace, match sim.AVLOptions, base Options) (AVLComparison, error) {
+	route, err := newRoute()
+	if err != nil {
+		return AVLComparison{}, err
+	}
+	buses := trace.Buses(fleet)
+	cmp := AVLComparison{Points: len(trace.Points), Buses: len(buses), Start: trace.Start(), Duration: trace.Duration()}
+	player := sim.PlayAVL(route, buses, trace, base.PeriodID, base.Headway, match, func(e sim.Event) {
+		if d, ok := e.(sim.DoneEvent); ok {
+			cmp.Observed = d
+		}
+	})
+	cmp.OffRoute = player.OffRoute()
+
+	if base.Seed == 0 {
+		base.Seed = time.Now().UnixNano() % (1 << 40)
+	}
+	base.Quiet = true
+	base.StartTime = sim.StartTime{At: trace.Start()}
+	if !base.Criterion.Active() && base.PassengerCap <= 0 {
+		base.Criterion.Duration = trace.Duration()
+	}
+	base = base.withoutOutputs()
+	prev := base.OnEvent
+	base.OnEvent = func(e sim.Event) {
+		if d, ok := e.(sim.DoneEvent); ok {
+			cmp.SimulatedDwell = d.DwellStats
+		}
+		if prev != nil {
+			prev(e)
+		}
+	}
+	simRoute, err := newRoute()
+	if err != nil {
+		return cmp, err
+	}
+	if cmp.Simulated, err = Run(ctx, simRoute, ResizeFleet(fleet, len(buses)), base); err != nil {
+		return cmp, fmt.Errorf("simulated run: %w", err)
+	}
+	return cmp, nil
+}
+
+// avlOverall is a corridor-wide figure of both runs.
+type avlOverall struct {
+	name     string
+	observed float64
+	sim      float64
+}
+
+func (c AVLComparison) overall() []avlOverall {
+	headway := func(hs []sim.StopHeadway) (mean, cv float64) {
+		n := 0
+		for _, h := range hs {
+			mean += h.MeanMin * float64(h.Count)
+			cv += h.CV * float64(h.Count)
+			n += h.Count
+		}
+		if n == 0 {
+			return 0, 0
+		}
+		return mean / float64(n), cv / float64(n)
+	}
+	dwell := func(ds []sim.StopDwellStats) (mean float64, visits int) {
+		for _, d := range ds {
+			mean += d.MeanSec * float64(d.Visits)
+			visits += d.Visits
+		}
+		if visits == 0 {
+			return 0, 0
+		}
+		return mean / float64(visits), visits
+	}
+	km := 0.0
+	for _, d := range c.Observed.BusDistance {
+		km += d
+	}
+	oh, ocv := headway(c.Observed.StopHeadways)
+	sh, scv := headway(c.Simulated.StopHeadways)
+	od, ov := dwell(c.Observed.DwellStats)
+	sd, sv := dwell(c.SimulatedDwell)
+	return []avlOverall{{"bus_km", km, c.Simulated.TotalDistance}, {"stop_visits", float64(ov), float64(sv)}, {"mean_dwell_s", od, sd}, {"mean_headway_min", oh, sh}, {"mean_headway_cv", ocv, scv}}
+}
+
+// avlStopRow pairs one stop and direction of both runs; dwell has no direction.
+type avlStopRow struct {
+	stopID                 int
+	name, dir              string
+	obsHeadway, simHeadway sim.StopHeadway
+	obsDwell, simDwell     float64
+}
+
+func (c AVLComparison) stopRows() []avlStopRow {
+	type key struct {
+		stop int
+		dir  string
+	}
+	sims := make(map[key]sim.StopHeadway)
+	for _, h := range c.Simulated.StopHeadways {
+		sims[key{h.StopID, h.Direction}] = h
+	}
+	obs := make(map[key]sim.StopHeadway)
+	for _, h := range c.Observed.StopHeadways {
+		obs[key{h.StopID, h.Direction}] = h
+	}
+	dwell := func(ds []sim.StopDwellStats) map[int]float64 {
+		m := make(map[int]float64, len(ds))
+		for _, d := range ds {
+			m[d.StopID] = d.MeanSec
+		}
+		return m
+	}
+	od, sd := dwell(c.Observed.DwellStats), dwell(c.SimulatedDwell)
+	var out []avlStopRow
+	for _, dir := range []string{"outbound", "inbound"} {
+		for _, d := range c.Observed.DwellStats {
+			k := key{d.StopID, dir}
+			o, s := obs[k], sims[k]
+			if o.Count == 0 && s.Count == 0 {
+				continue
+			}
+			out = append(out, avlStopRow{stopID: d.StopID, name: d.Name, dir: dir, obsHeadway: o, simHeadway: s, obsDwell: od[d.StopID], simDwell: sd[d.StopID]})
+		}
+	}
+	return out
+}
+
+// PrintAVLReport prints the observed against the simulated operations.
+func PrintAVLReport(c AVLComparison) {
+	fmt.Println("=== AVL Playback vs Simulation ===")
+	fmt.Printf("  trace: %d reports (%d off route), %d buses, from %s for %s\n", c.Points, c.OffRoute, c.Buses, c.Start.Format(time.RFC3339), c.Duration.Round(time.Second))
+	fmt.Printf("  %-20s %12s %12s %10s\n", "", "observed", "simulated", "delta")
+	for _, r := range c.overall() {
+		fmt.Printf("  %-20s %12.2f %12.2f %+9.1f%%\n", r.name, r.observed, r.sim, pctDelta(r.observed, r.sim))
+	}
+	rows := c.stopRows()
+	if len(rows) == 0 {
+		return
+	}
+	fmt.Println("Per stop (headway mean min / CV, mean dwell s):")
+	fmt.Printf("  %-28s %-9s %15s %15s %9s %9s\n", "stop", "dir", "observed", "simulated", "obs_dwell", "sim_dwell")
+	for _, r := range rows {
+		fmt.Printf("  %-28.28s %-9s %8.2f / %4.2f %8.2f / %4.2f %9.1f %9.1f\n", r.name, r.dir, r.obsHeadway.MeanMin, r.obsHeadway.CV, r.simHeadway.MeanMin, r.simHeadway.CV, r.obsDwell, r.simDwell)
+	}
+}
+
+// WriteAVLCSV writes the comparison ("overall" and "stop" rows) to a file or directory
+// (avl-*.csv).
+func WriteAVLCSV(path string, c AVLComparison) (string, error) {
+	ts := time.Now().Format("20060102-150405")
+	outPath := sim.TimestampedPath(path, "avl", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"section", "metric", "stop_id", "stop_name", "direction", "observed", "simulated", "delta_pct", "observed_headways", "simulated_headways", "observed_cv", "simulated_cv", "observed_dwell_s", "simulated_dwell_s"})
+	num := func(x float64) string { return strconv.FormatFloat(x, 'f', 3, 64) }
+	for _, r := range c.overall() {
+		w.Write([]string{"overall", r.name, "", "", "", num(r.observed), num(r.sim), num(pctDelta(r.observed, r.sim)), "", "", "", "", "", ""})
+	}
+	for _, r := range c.stopRows() {
+		o, s := r.obsHeadway, r.simHeadway
+		w.Write([]string{"stop", "headway_min", strconv.Itoa(r.stopID), r.name, r.dir, num(o.MeanMin), num(s.MeanMin), num(pctDelta(o.MeanMin, s.MeanMin)), strconv.Itoa(o.Count), strconv.Itoa(s.Count), num(o.CV), num(s.CV), num(r.obsDwell), num(r.simDwell)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("avl comparison written", "path", outPath)
+	return outPath, nil
+}
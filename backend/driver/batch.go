@@ -2,18 +2,26 @@ package driver
 
 import (
 	"brt08/backend/data"
+	"brt08/backend/export"
 	"brt08/backend/model"
+	"brt08/backend/report"
 	"brt08/backend/sim"
 	"container/heap"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"math/rand"
-	"os"
-	"path/filepath"
+	"runtime/debug"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("brt08/backend/driver")
+
 // Options mirrors server.Options for reuse in headless mode.
 type Options struct {
 	PeriodID              int
@@ -22,28 +30,101 @@ type Options struct {
 	DirBias               float64
 	SpatialGradient       float64
 	BaselineDemand        float64
+	DemandProfile         string // "flat" (default) or "period": arrivals follow the multipliers across periods (see sim.PeriodProfile)
 	ArrivalFactor         float64
+	Lambda                float64 // base arrivals per corridor per minute (0 = sim.DefaultLambda)
 	ReportPath            string
 	Seed                  int64
 	Trace                 bool
 	TraceBusID            int
+	StallTimeout          time.Duration // abandon the run when waiting passengers see no boarding for this long (0 = never)
+	GroupSizes            sim.GroupSizeDist
+	Population            int // activity-based synthetic population size (0 = independent Poisson arrivals)
+	Seeding               sim.SeedConfig
+	PassengerLogPath      string                 // if set, write the passenger journey log (CSV or .jsonl)
+	DecisionLogPath       string                 // if set, write the dispatch decision audit trail (CSV or .jsonl)
+	TrajectoryLogPath     string                 // if set, write bus trajectories as GeoJSON
+	SimplifyToleranceM    float64                // Douglas-Peucker tolerance for trajectories and exported shapes (0 = keep all points)
+	DwellReportPath       string                 // if set, write per-stop dwell analytics CSV
+	LoadReportPath        string                 // if set, write the segment x direction occupancy heatmap CSV
+	Traffic               sim.TravelTimeProvider // optional external segment travel times
+	ExportFormat          string                 // "matsim" or "sumo": export corridor and generated demand after the run
+	ExportDir             string
+	MetricsInterval       time.Duration        // emit sim.MetricsEvent to OnEvent every this much sim time (0 = off)
+	QualityWeights        sim.QualityWeights   // service quality score weights (zero = defaults)
+	OnEvent               func(sim.Event)      // if set, receives the runner-equivalent event sequence in order (see RunEvents)
+	Quiet                 bool                 // skip the console report (sweeps print one table instead)
+	Energy                *sim.EnergyModel     // energy accounting model (nil = sim.DefaultEnergyModel)
+	ReportFormat          string               // "csv", "xlsx", "html" or "json" ("" = from the ReportPath extension, else csv); JSON replaces the console report
+	Criterion             sim.StopCriterion    // end after a sim-time horizon or trip count (zero = passenger cap only)
+	Headway               sim.HeadwayConfig    // per-period target headways for adherence (zero = defaults)
+	Anomaly               sim.AnomalyConfig    // thresholds of the anomaly detectors run on each heartbeat (zero = defaults)
+	Shifts                sim.ShiftConfig      // driver duty and driving limits, breaks and reliefs (zero = no shifts)
+	StartTime             sim.StartTime        // simulated date and time the run starts at (zero = today at the period's start)
+	Day                   sim.DaySchedule      // chain periods through one run; ends when the day does unless Criterion.Duration is set (zero = PeriodID only)
+	Platforms             sim.PlatformConfig   // platform capacities and spilling (zero = stops' own capacities, no spill)
+	Berths                sim.BerthConfig      // berths per station (zero = stops' own berths, else unlimited)
+	Turnaround            sim.TurnaroundConfig // terminal turnaround bays, time and recovery (zero = unlimited, sim.DefaultTurnaroundTime, no layover)
+}
+
+// withoutOutputs returns opt with every per-run output (reports, logs, exports) turned
+// off, for drivers that run several simulations and write their own summary.
+func (opt Options) withoutOutputs() Options {
+	opt.ReportPath, opt.PassengerLogPath, opt.DecisionLogPath, opt.TrajectoryLogPath, opt.DwellReportPath, opt.LoadReportPath, opt.ExportFormat = "", "", "", "", "", "", ""
+	return opt
+}
+
+// lambda is the base arrival rate of the run.
+func (opt Options) lambda() float64 {
+	if opt.Lambda > 0 {
+		return opt.Lambda
+	}
+	return sim.DefaultLambda
 }
 
 type Summary struct {
-	Generated     int
-	Served        int64
-	AvgWaitMin    float64
-	BusDistance   map[int]float64
-	TotalDistance float64
-	TotalCost     float64
+	Seed          int64                  `json:"seed"`  // effective seed (a random one when Options.Seed is 0)
+	Start         time.Time              `json:"start"` // simulated start of the run
+	Generated     int                    `json:"generated"`
+	Served        int64                  `json:"served"`
+	AvgWaitMin    float64                `json:"avg_wait_min"`
+	BusDistance   map[int]float64        `json:"bus_distance_km"`
+	TotalDistance float64                `json:"total_distance_km"`
+	TotalCost     float64                `json:"total_cost"`
+	EndedBy       string                 `json:"ended_by"` // what ended the run (see sim.EndReason)
+	Stalled       bool                   `json:"stalled"`
+	Diagnostic    string                 `json:"diagnostic,omitempty"`
+	Aborted       string                 `json:"aborted,omitempty"` // why the run ended early (cancelled, panic); figures are partial
+	Wait          sim.WaitDistribution   `json:"wait"`
+	Stops         []sim.StopStats        `json:"stops"`
+	Zones         []sim.ZoneStats        `json:"zones,omitempty"`     // per corridor zone (stops tagged with a zone)
+	Load          sim.LoadProfile        `json:"load_profile"`        // segment x direction occupancy with the peak load points
+	Headway       []sim.HeadwayAdherence `json:"headway"`             // headway adherence per period
+	StopHeadways  []sim.StopHeadway      `json:"stop_headways"`       // observed headway mean, stddev and CV per stop and direction
+	Anomalies     []sim.AnomalyEvent     `json:"anomalies,omitempty"` // queue growth spikes, wait doubling, stationary buses
+	Shifts        *sim.ShiftStats        `json:"shifts,omitempty"`    // driver breaks, reliefs and limit violations (with -shifts)
+	Buses         []sim.BusStats         `json:"buses"`
+	Types         []sim.BusTypeStats     `json:"bus_types"` // per-bus-type aggregates (distance, cost, carried, load)
+	Capacity      sim.CapacityCheck      `json:"capacity"`  // theoretical corridor capacity vs configured demand (zero with -population)
+	Quality       sim.QualityScore       `json:"quality"`
+	EnergyKWh     float64                `json:"energy_kwh"`            // traction + auxiliary energy while running, per sim.EnergyModel
+	RunningMin    float64                `json:"running_min"`           // total time buses spent moving between stops
+	CO2Kg         float64                `json:"co2_kg"`                // emissions per the bus types' vehicle parameters
+	Decisions     []sim.Decision         `json:"decisions,omitempty"`   // dispatch audit trail (when DecisionLogPath or OnEvent is set)
+	Periods       []sim.PeriodStats      `json:"periods,omitempty"`     // per-period sections of a full-day run (with -day)
+	Platforms     []sim.PlatformStats    `json:"platforms,omitempty"`   // overflows of the stops with a platform capacity
+	Berths        []sim.BerthStats       `json:"berths,omitempty"`      // berth use of the stations with limited berths
+	Turnarounds   []sim.TurnaroundStats  `json:"turnarounds,omitempty"` // bay use and recovery layovers of both terminals (with limited bays or a recovery time)
+	TripTimes     []sim.TripTimes        `json:"trip_times,omitempty"`  // the fleet's one-way trip and cycle times by direction
+	Directions    []sim.DirectionStats   `json:"directions"`            // generated, served, wait and passenger-km by direction
+	ExcessWait    sim.ExcessWaitStats    `json:"excess_wait"`           // average wait beyond half the scheduled headway, overall and per stop
 }
 
 // Timing constants mirrored from SSE to ensure identical semantics.
 // In batch mode these only affect simulated time progression (no real sleeps).
 const (
-	preBoardPause = 650 * time.Millisecond
+	preBoardPause = sim.PreBoardPause
 	travelStep    = 800 * time.Millisecond
-	terminalPause = 3 * time.Second
 )
 
 // Internal event and priority queue for bus arrivals (package scope for Go method declarations)
@@ -73,17 +154,25 @@ func clampFactor(v float64) float64 {
 
 // Run executes a fast, headless simulation (no SSE, no sleeps) and returns a summary.
 // Notes:
-// - Requires PassengerCap > 0; generates all passengers upfront using current demand config.
+// - Requires PassengerCap > 0 or a stop criterion (duration or trips); generates passengers as time advances.
 // - Buses start immediately at their terminal and operate until all passengers are served.
 // Run mirrors the SSE simulation logic exactly, but executes in fast-forward (no sleeps, no SSE output).
 // Only difference from SSE is wall-clock time (this is fast), not simulation results.
-func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
+// Cancelling ctx aborts the run between events; reports are still written (marked
+// partial) and ctx.Err() is returned with the summary so far. A panic in the event loop
+// is recovered the same way and returned as an error.
+func Run(ctx context.Context, route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 	if route == nil || len(route.Stops) == 0 {
 		return Summary{}, fmt.Errorf("route not loaded")
 	}
-	if opt.PassengerCap <= 0 {
-		return Summary{}, fmt.Errorf("batch driver requires -passenger_cap > 0")
+	if opt.Day.Enabled() && opt.Criterion.Duration == 0 {
+		opt.Criterion.Duration = opt.Day.Duration()
+	}
+	if opt.PassengerCap <= 0 && !opt.Criterion.Active() {
+		return Summary{}, fmt.Errorf("batch driver requires -passenger_cap > 0, -sim_hours or -max_trips")
 	}
+	ctx, runSpan := tracer.Start(ctx, "batch.run", trace.WithAttributes(attribute.Int("passenger_cap", opt.PassengerCap), attribute.Int("buses", len(fleet)), attribute.Int64("seed", opt.Seed)))
+	defer runSpan.End()
 
 	// Clone fleet to avoid mutating caller's instances
 	buses := make([]*model.Bus, 0, len(fleet))
@@ -103,21 +192,25 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 		}
 	}
 
-	start := time.Now()
+	start := opt.StartTime.Resolve(opt.PeriodID, opt.Day, time.Now())
 	baseSeed := opt.Seed
 	if baseSeed == 0 {
 		baseSeed = time.Now().UnixNano()
 	}
 	baseRNG := rand.New(rand.NewSource(baseSeed))
-	lambda := 1.2 // base arrivals per corridor per minute (same default as SSE)
+	lambda := opt.lambda() // base arrivals per corridor per minute (same default as SSE)
 	// Dummy bus for simulator
 	dummy := &model.Bus{ID: 0, Type: buses[0].Type, RouteID: route.ID, CurrentStopID: buses[0].CurrentStopID, Direction: buses[0].Direction, AverageSpeedKmph: buses[0].AverageSpeedKmph}
 	engine := sim.NewSimulator(route, dummy, baseSeed+1, lambda, start)
 	engine.PeriodID = opt.PeriodID
+	if opt.Day.Enabled() {
+		engine.PeriodID = opt.Day.Periods[0].ID
+	}
 	engine.TotalPassengerCap = opt.PassengerCap
 	engine.MorningTowardKivukoni = opt.MorningTowardKivukoni
 	engine.DirectionBiasFactor = opt.DirBias
-	engine.Now = start
+	engine.RecordPassengers = opt.PassengerLogPath != "" || opt.ExportFormat != ""
+	engine.Seeding = opt.Seeding
 
 	// Assign initial directions
 	favOut, favIn := sim.FavoredDirections(engine.PeriodID, opt.MorningTowardKivukoni)
@@ -138,11 +231,20 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 	}
 
 	// Demand configuration
-	cfg := sim.DemandConfig{FavoredOutbound: favOut, FavoredInbound: favIn, SpatialGradient: opt.SpatialGradient, BaselineDemand: opt.BaselineDemand, DirBias: opt.DirBias}
+	cfg := sim.DemandConfig{FavoredOutbound: favOut, FavoredInbound: favIn, SpatialGradient: opt.SpatialGradient, BaselineDemand: opt.BaselineDemand, DirBias: opt.DirBias, GroupSizes: opt.GroupSizes}
 	mult := data.TimePeriodMultiplier[engine.PeriodID]
 	if mult == 0 {
 		mult = 1
 	}
+	peakMult := mult
+	// the period profile varies the intensity continuously, sampled by thinning like the
+	// streaming runner; a full-day schedule keeps its own periods
+	var profile sim.DemandProfile
+	if opt.Day.Enabled() {
+		_, peakMult = opt.Day.Profile()
+	} else if opt.DemandProfile == "period" {
+		profile, peakMult = sim.NewDemandProfile(opt.DemandProfile, engine.PeriodID, start)
+	}
 
 	// Initial seed (5% of cap)
 	totalTarget := opt.PassengerCap
@@ -150,15 +252,128 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 	if totalTarget > 0 {
 		seedTarget = int(float64(totalTarget) * 0.05)
 	}
+	var pop *sim.Population
+	if opt.Population > 0 {
+		pop = sim.NewPopulation(engine.RNG, route, opt.Population, engine.PeriodID, opt.MorningTowardKivukoni, start, cfg)
+		seedTarget = 0
+	}
 	if seedTarget > 0 {
 		sim.SeedInitial(engine, route, start, seedTarget, totalTarget, cfg)
 	}
 
+	// Optional event sink mirroring the SSE runner's event types
+	emit := func(e sim.Event) {
+		if opt.OnEvent != nil {
+			opt.OnEvent(e)
+		}
+	}
+	geom := route.Geometry()
+	var traj *sim.TrajectoryRecorder
+	if opt.TrajectoryLogPath != "" {
+		traj = sim.NewTrajectoryRecorder()
+	}
+	emitStop := func(st *model.BusStop) {
+		emit(sim.StopUpdateEvent{StopID: st.ID, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+	}
+	emitMove := func(bus *model.Bus, from, to *model.BusStop, sstep, steps int, phase string) {
+		if opt.OnEvent == nil && traj == nil {
+			return
+		}
+		pos := geom.Samples(from.ID, to.ID, steps)[sstep-1]
+		traj.Add(bus.ID, engine.Now(), pos)
+		emit(sim.MoveEvent{BusID: bus.ID, Direction: bus.Direction, Lat: pos.Lat, Lng: pos.Lng, T: float64(sstep) / float64(steps), From: from.ID, To: to.ID, Phase: phase})
+	}
+	// nextStep returns the first travel step from sstep on (of steps, stepDur apart
+	// from leftAt) the loop has to take. When nobody records the moves, the steps
+	// between a segment's first and last, before the run's horizon, change nothing
+	// the end checks look at and are jumped over (their arrivals are generated at the
+	// next step taken).
+	nextStep := func(sstep, steps int, leftAt time.Time, stepDur time.Duration) int {
+		if opt.OnEvent != nil || traj != nil || sstep == 0 {
+			return sstep
+		}
+		next := steps - 1
+		if opt.Criterion.Duration > 0 {
+			// first step ending at or past the horizon
+			left := start.Add(opt.Criterion.Duration).Sub(leftAt)
+			next = min(next, int((left+stepDur-1)/stepDur)-1)
+		}
+		return max(next, sstep)
+	}
+	if opt.OnEvent != nil {
+		emit(sim.InitialState(route, engine))
+	}
+	platforms := sim.NewPlatformMonitor(route, opt.Platforms)
+	berths := sim.NewBerthTracker(route, opt.Berths)
+	yard := sim.NewTurnaroundYard(route, opt.Turnaround)
+	// checkPlatforms compares the updated stops with their platform capacity in route
+	// order, adding the stops passengers spilled to.
+	checkPlatforms := func(updated map[int]struct{}, now time.Time) {
+		for _, st := range route.Stops {
+			if _, ok := updated[st.ID]; !ok {
+				continue
+			}
+			ev, ok, touched := platforms.Check(st.ID, now)
+			if ok {
+				emit(ev)
+			}
+			for _, t := range touched {
+				updated[t] = struct{}{}
+			}
+		}
+	}
+	if seedTarget > 0 && platforms != nil {
+		seeded := make(map[int]struct{}, len(route.Stops))
+		for _, st := range route.Stops {
+			seeded[st.ID] = struct{}{}
+		}
+		checkPlatforms(seeded, start)
+	}
+	emit(sim.InitEvent{Time: start, ConnID: "batch", Generated: engine.GeneratedPassengers, OutboundGen: engine.OutboundGenerated, InboundGen: engine.InboundGenerated, ArrivalFactor: clampFactor(opt.ArrivalFactor)})
+	var capacity sim.CapacityCheck
+	if pop == nil {
+		capacity = sim.CheckCapacity(route, buses, lambda*peakMult*clampFactor(opt.ArrivalFactor), cfg)
+		if capacity.Exceeded {
+			if !opt.Quiet {
+				slog.Warn("corridor capacity exceeded", "note", capacity.Note())
+			}
+			emit(sim.CapacityWarningEvent{Check: capacity})
+		}
+	}
+	for _, b := range buses {
+		capacity := 0
+		if b.Type != nil {
+			capacity = b.Type.Capacity
+		}
+		emit(sim.BusAddEvent{BusID: b.ID, Direction: b.Direction, AvgSpeedKmph: b.AverageSpeedKmph, Capacity: capacity})
+	}
+
 	// Stats
 	var cumServed int64
 	var waitSumMin float64
 	var waitCount int64
 	busDistance := make(map[int]float64)
+	energyModel := sim.DefaultEnergyModel()
+	if opt.Energy != nil {
+		energyModel = *opt.Energy
+	}
+	energyKWh, runningMin, co2Kg := 0.0, 0.0, 0.0
+	dwellRec := sim.NewDwellRecorder()
+	zoneRec := sim.NewZoneRecorder(route)
+	dirRec := sim.NewDirectionRecorder(route)
+	loadRec := sim.NewLoadRecorder(route)
+	headwayRec := sim.NewHeadwayRecorder(opt.Headway, engine.PeriodID, start)
+	ewtRec := sim.NewExcessWaitRecorder(headwayRec)
+	dayRec := sim.NewDayRecorder(opt.Day, start, opt.MorningTowardKivukoni)
+	anomalies := sim.NewAnomalyDetector(opt.Anomaly)
+	shifts := sim.NewShiftTracker(opt.Shifts)
+	waitStats := sim.NewWaitStats()
+	stopWait := sim.NewRollingStopWait(sim.StopWaitWindow)
+	busStats := sim.NewBusStatsRecorder()
+	var decisions *sim.DecisionLog
+	if opt.DecisionLogPath != "" || opt.OnEvent != nil {
+		decisions = sim.NewDecisionLog()
+	}
 	// Helper to compute in-system passengers and stop condition like SSE
 	inSystemCount := func() int {
 		inSystem := 0
@@ -170,7 +385,15 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 		}
 		return inSystem
 	}
+	trips := 0    // completed one-way trips across the fleet
+	endedBy := "" // stop criterion met ("duration" or "trips")
 	isDone := func() bool {
+		if endedBy == "" {
+			endedBy = opt.Criterion.Reached(engine.Now().Sub(start), trips)
+		}
+		if endedBy != "" {
+			return true
+		}
 		if opt.PassengerCap <= 0 {
 			return false
 		}
@@ -181,7 +404,8 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 		if engine.GeneratedPassengers >= opt.PassengerCap && inSystem == 0 {
 			return true
 		}
-		if engine.GeneratedPassengers == int(cumServed) && inSystem == 0 {
+		// A population's next trips may be hours away, so an empty system is not the end
+		if pop == nil && engine.GeneratedPassengers == int(cumServed) && inSystem == 0 {
 			return true
 		}
 		return false
@@ -189,14 +413,8 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 
 	computeDwell := func(boardedN, alightedN int) time.Duration {
 		// Same as SSE computeDwell
-		base := 1200 * time.Millisecond
-		per := time.Duration(300*time.Millisecond) * time.Duration(boardedN+alightedN)
-		max := 4 * time.Second
-		d := base + per
-		if d > max {
-			d = max
-		}
-		return d
+		dead, service := sim.DwellTime(boardedN, alightedN)
+		return dead + service
 	}
 
 	// Helper to get stop by id and its index
@@ -284,7 +502,16 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 	}
 	schedule := append(makeSchedule(busesOutbound), makeSchedule(busesInbound)...)
 
-	// Priority queue of bus arrival events
+	// Priority queue of bus arrival events; launchDelay marks buses not yet dispatched
+	launchDelay := make(map[int]time.Duration)
+	// Wall-clock span per bus trip (dispatch or turnaround to the next terminal)
+	tripSpans := make(map[int]trace.Span)
+	startTrip := func(bus *model.Bus) {
+		if sp, ok := tripSpans[bus.ID]; ok {
+			sp.End()
+		}
+		_, tripSpans[bus.ID] = tracer.Start(ctx, "bus.trip", trace.WithAttributes(attribute.Int("bus_id", bus.ID), attribute.String("direction", bus.Direction)))
+	}
 	q := &eventPQ{}
 	heap.Init(q)
 	// Seed initial arrival events according to schedule
@@ -299,206 +526,407 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 			}
 		}
 		heap.Push(q, evt{t: start.Add(it.simDelay), bus: b, stopIdx: idx})
+		launchDelay[b.ID] = it.simDelay
 	}
 
-	// Passenger generator: advance in 1s steps up to target time (no sleeps)
+	// Passenger generator: jump straight to the target time, drawing each stretch's
+	// arrivals at once (a new period starts a new stretch)
 	lastGen := start
 	advanceGenTo := func(t time.Time) {
 		if engine.TotalPassengerCap > 0 && engine.GeneratedPassengers >= engine.TotalPassengerCap {
 			lastGen = t
 			return
 		}
-		for lastGen.Before(t) {
-			step := lastGen.Add(1 * time.Second)
-			if step.After(t) {
-				step = t
-			}
-			stepMin := step.Sub(lastGen).Minutes()
-			mean := lambda * float64(mult) * stepMin * clampFactor(opt.ArrivalFactor)
-			count := engine.PoissonPublic(mean)
-			if engine.TotalPassengerCap > 0 {
-				remain := engine.TotalPassengerCap - engine.GeneratedPassengers
-				if remain < 0 {
-					remain = 0
+		if ev, ok := dayRec.Advance(lastGen, engine, &cfg); ok {
+			mult = dayRec.Multiplier()
+			emit(ev)
+		}
+		if pop != nil {
+			if t.After(lastGen) {
+				updated := sim.GeneratePopulationTrips(engine, route, pop, lastGen, t, engine.TotalPassengerCap)
+				checkPlatforms(updated, t)
+				for _, st := range route.Stops {
+					if _, ok := updated[st.ID]; ok {
+						emitStop(st)
+					}
 				}
-				if count > remain {
-					count = remain
+				lastGen = t
+			}
+			return
+		}
+		for lastGen.Before(t) {
+			if ev, ok := dayRec.Advance(lastGen, engine, &cfg); ok {
+				mult = dayRec.Multiplier()
+				emit(ev)
+			}
+			end := t
+			if next := dayRec.Next(lastGen); !next.IsZero() && next.Before(end) {
+				end = next
+			}
+			var updated map[int]struct{}
+			count := 0
+			if profile != nil {
+				// continuous arrival instants by thinning, as the streaming generator draws them
+				perMult := lambda * clampFactor(opt.ArrivalFactor) / opt.GroupSizes.Mean()
+				proc := sim.NHPP{RNG: engine.RNG, RateMax: perMult * peakMult, Rate: func(at time.Time) float64 { return perMult * profile(at.Sub(start)) }}
+				updated = make(map[int]struct{})
+				for _, at := range proc.Arrivals(lastGen, end) {
+					if engine.TotalPassengerCap > 0 && engine.GeneratedPassengers >= engine.TotalPassengerCap {
+						break
+					}
+					for sid := range sim.GenerateBatch(engine, route, 1, at, engine.TotalPassengerCap, cfg) {
+						updated[sid] = struct{}{}
+					}
+					count++
 				}
+			} else {
+				perMin := lambda * float64(mult) * clampFactor(opt.ArrivalFactor) / opt.GroupSizes.Mean()
+				updated, count = sim.GenerateInterval(engine, route, lastGen, end, perMin, engine.TotalPassengerCap, cfg)
 			}
 			if count > 0 {
-				updated := sim.GenerateBatch(engine, route, count, lastGen, engine.TotalPassengerCap, cfg)
+				checkPlatforms(updated, end)
+				for _, st := range route.Stops {
+					if _, ok := updated[st.ID]; ok {
+						emitStop(st)
+					}
+				}
 				if opt.Trace {
-					fmt.Printf("[trace] gen t=%s +%d stops=%d total=%d\n", step.Format(time.RFC3339Nano), count, len(updated), engine.GeneratedPassengers)
+					slog.Debug("trace gen", "from", lastGen, "to", end, "added", count, "stops", len(updated), "total", engine.GeneratedPassengers)
 				}
 			}
-			lastGen = step
+			lastGen = end
+		}
+	}
+
+	// shiftHold applies the crew rules to bus turning at terminal st and returns when it
+	// resumes service
+	shiftHold := func(bus *model.Bus, st *model.BusStop) time.Time {
+		sev, hold := shifts.Turnaround(bus.ID, st.ID, engine.Now())
+		for _, v := range sev.Violations {
+			slog.Warn("shift limit exceeded", "bus", bus.ID, "driver", v.Driver, "kind", v.Kind, "limit_min", v.LimitMin, "actual_min", math.Round(v.ActualMin))
+		}
+		if hold || len(sev.Violations) > 0 {
+			emit(sev)
 		}
+		if !hold {
+			return engine.Now()
+		}
+		decisions.Note(engine.Now(), route, bus, sim.DecisionShift, st.ID, 0, fmt.Sprintf("driver %d %s, %.0f min out of service", sev.Driver, sev.Kind, sev.HoldMin))
+		return engine.Now().Add(time.Duration(sev.HoldMin * float64(time.Minute)))
 	}
 
 	// Track last visited stop index per bus (for accurate reposition start)
 	lastIdx := make(map[int]int)
+	// Stall detection: last sim time a boarding happened or nobody was waiting
+	lastProgress := start
+	stalled := false
+	stallDiagnostic := ""
 
-	// Event loop
-	for q.Len() > 0 {
-		ev := heap.Pop(q).(evt)
-		// Generate passengers up to this event time
-		if ev.t.After(lastGen) {
-			advanceGenTo(ev.t)
-		}
-		// Advance simulation time
-		engine.Now = ev.t
-		bus := ev.bus
-		idx := ev.stopIdx
-		st := route.Stops[idx]
-		lastIdx[bus.ID] = idx
-		if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
-			nextIdx := idx
-			if bus.Direction == "outbound" {
-				if idx < len(route.Stops)-1 {
-					nextIdx = idx + 1
+	nextMetrics := start.Add(opt.MetricsInterval)
+
+	// Event loop; a panic ends it with the state reached so far
+	loopErr := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error("batch run panicked; writing partial reports", "panic", r, "stack", string(debug.Stack()))
+				err = fmt.Errorf("batch run panicked: %v", r)
+			}
+		}()
+		for q.Len() > 0 {
+			if ctx.Err() != nil {
+				break
+			}
+			ev := heap.Pop(q).(evt)
+			// KPI heartbeats for every interval boundary passed (state as of the last event);
+			// the anomaly detectors run on them even when nobody consumes the events
+			for opt.MetricsInterval > 0 && !ev.t.Before(nextMetrics) {
+				avg := 0.0
+				if waitCount > 0 {
+					avg = waitSumMin / float64(waitCount)
 				}
-			} else {
-				if idx > 0 {
-					nextIdx = idx - 1
+				m := sim.NewMetricsEvent(nextMetrics, route, buses, engine.GeneratedPassengers, cumServed, avg)
+				m.WaitSamples = int(waitCount)
+				m.HeadwayAdherence, m.HeadwaySamples = headwayRec.Rolling(nextMetrics)
+				m.ExcessWaitMin = ewtRec.Current()
+				emit(m)
+				for _, a := range anomalies.Observe(m, buses, busDistance) {
+					slog.Warn("anomaly detected", "kind", a.Kind, "detail", a.Message)
+					emit(a)
 				}
+				nextMetrics = nextMetrics.Add(opt.MetricsInterval)
 			}
-			fmt.Printf("buslog bus=%d stop_idx=%d next_idx=%d stop_id=%d dist_km=%.2f\n", bus.ID, idx, nextIdx, st.ID, math.Round(busDistance[bus.ID]*100)/100)
-		}
-		// Arrive: alight
-		alighted := bus.AlightPassengersAtCurrentStop(engine.Now)
-		if len(alighted) > 0 {
-			cumServed += int64(len(alighted))
-		}
-		// Short pause before boarding (same as SSE preBoardPause)
-		boardTime := engine.Now.Add(preBoardPause)
-		if boardTime.After(lastGen) {
-			advanceGenTo(boardTime)
-		}
-		engine.Now = boardTime
-		// Board
-		boarded := st.BoardAtStop(bus, engine.Now)
-		if len(boarded) > 0 {
-			var localSum float64
-			for _, p := range boarded {
-				if p.WaitDuration != nil {
-					localSum += *p.WaitDuration
+			// Generate passengers up to this event time
+			if ev.t.After(lastGen) {
+				advanceGenTo(ev.t)
+			}
+			// Advance simulation time
+			engine.Clock.Set(ev.t)
+			bus := ev.bus
+			idx := ev.stopIdx
+			st := route.Stops[idx]
+			lastIdx[bus.ID] = idx
+			if d, ok := launchDelay[bus.ID]; ok {
+				decisions.Note(engine.Now(), route, bus, sim.DecisionDispatch, st.ID, 0, fmt.Sprintf("scheduled launch +%.1f min", d.Minutes()))
+				delete(launchDelay, bus.ID)
+				shifts.Start(bus.ID, engine.Now())
+				startTrip(bus)
+				traj.Add(bus.ID, engine.Now(), model.LatLng{Lat: st.Latitude, Lng: st.Longitude})
+			}
+			if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
+				nextIdx := idx
+				if bus.Direction == "outbound" {
+					if idx < len(route.Stops)-1 {
+						nextIdx = idx + 1
+					}
+				} else {
+					if idx > 0 {
+						nextIdx = idx - 1
+					}
 				}
+				slog.Debug("buslog", "bus", bus.ID, "stop_idx", idx, "next_idx", nextIdx, "stop_id", st.ID, "dist_km", math.Round(busDistance[bus.ID]*100)/100)
 			}
-			if localSum > 0 {
-				waitSumMin += localSum
-				waitCount += int64(len(boarded))
+			// with every berth taken the bus waits upstream until one frees up
+			dockAt, berthEvents, _ := berths.Dock(st.ID, bus.ID, ev.t)
+			for _, be := range berthEvents {
+				emit(be)
 			}
-		}
-		// quiet board trace
-		dwell := computeDwell(len(boarded), len(alighted))
-		depart := engine.Now.Add(dwell)
-		if depart.After(lastGen) {
-			advanceGenTo(depart)
-		}
-		engine.Now = depart
-		// quiet dwell trace
-		if isDone() {
-			break
-		}
-		// Move to next (chunked with mid-segment termination like SSE)
-		if bus.Direction == "outbound" {
-			if idx == len(route.Stops)-1 {
-				// terminal pause then flip (matches SSE terminal handling)
-				turn := engine.Now.Add(terminalPause)
-				if turn.After(lastGen) {
-					advanceGenTo(turn)
+			if dockAt.After(ev.t) {
+				if dockAt.After(lastGen) {
+					advanceGenTo(dockAt)
 				}
-				engine.Now = turn
-				bus.Direction = "inbound"
-				if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
-					fmt.Printf("[trace] terminal_flip t=%s bus=%d new_dir=%s\n", engine.Now.Format(time.RFC3339Nano), bus.ID, bus.Direction)
+				ev.t = dockAt
+				engine.Clock.Set(dockAt)
+			}
+			emit(sim.ArriveEvent{BusID: bus.ID, Direction: bus.Direction, StopID: st.ID, Time: engine.Now(), BusOnboard: bus.PassengersOnboard, PassengersOnboard: bus.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+			emit(sim.DoorsOpenEvent{BusID: bus.ID, Direction: bus.Direction, StopID: st.ID, Time: engine.Now(), Onboard: bus.PassengersOnboard})
+			// Arrive: alight
+			busStats.Set(bus.ID, engine.Now(), bus.PassengersOnboard, false)
+			headwayRec.Arrive(st.ID, bus.Direction, engine.Now())
+			zoneRec.Arrive(st.ID, bus)
+			alighted := bus.AlightPassengersAtCurrentStop(engine.Now())
+			zoneRec.Alight(st.ID, len(alighted))
+			dirRec.Alight(alighted)
+			dayRec.Alight(len(alighted))
+			engine.Recycle(alighted)
+			busStats.Alight(bus.ID, len(alighted), engine.Now(), bus.PassengersOnboard)
+			if len(alighted) > 0 {
+				cumServed += int64(len(alighted))
+				emit(sim.AlightEvent{BusID: bus.ID, Direction: bus.Direction, StopID: st.ID, Alighted: len(alighted), BusOnboard: bus.PassengersOnboard, PassengersOnboard: bus.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed})
+			}
+			// Short pause before boarding (same as SSE preBoardPause)
+			boardTime := engine.Now().Add(preBoardPause)
+			if boardTime.After(lastGen) {
+				advanceGenTo(boardTime)
+			}
+			engine.Clock.Set(boardTime)
+			// Board
+			boarded := st.BoardAtStop(bus, engine.Now())
+			engine.NoteBoarding(st, bus, boarded)
+			busStats.Board(bus.ID, len(boarded), engine.Now(), bus.PassengersOnboard)
+			if len(boarded) > 0 {
+				var localSum float64
+				localN := 0
+				for _, p := range boarded {
+					if engine.CountsWait(p) {
+						localSum += *p.WaitDuration
+						localN++
+						waitStats.Add(st.ID, p.Direction, *p.WaitDuration)
+						dayRec.Board(*p.WaitDuration)
+						stopWait.Add(st.ID, engine.Now(), *p.WaitDuration)
+						ewtRec.Board(st.ID, p.ArrivalStopTime, *p.WaitDuration)
+					}
 				}
-				// schedule next arrival at same terminal index (start inbound) immediately
-				if isDone() {
-					// Generate passengers up to this event time
+				if localSum > 0 {
+					waitSumMin += localSum
+					waitCount += int64(localN)
 				}
-				heap.Push(q, evt{t: engine.Now, bus: bus, stopIdx: idx})
-			} else {
-				next := route.Stops[idx+1]
-				dist := st.DistanceToNext
-				travelMin := dist / bus.AverageSpeedKmph * 60
-				travelDur := time.Duration(travelMin * float64(time.Minute))
-				steps := int(travelDur / travelStep)
-				if steps < 1 {
-					steps = 1
+				avg := 0.0
+				if waitCount > 0 {
+					avg = waitSumMin / float64(waitCount)
 				}
-				stepDur := travelDur / time.Duration(steps)
-				completed := true
-				for sstep := 0; sstep < steps; sstep++ {
-					t := engine.Now.Add(stepDur)
-					if t.After(lastGen) {
-						advanceGenTo(t)
+				stopAvg, stopN := stopWait.Avg(st.ID, engine.Now())
+				emit(sim.BoardEvent{BusID: bus.ID, Direction: bus.Direction, StopID: st.ID, Boarded: len(boarded), BusOnboard: bus.PassengersOnboard, PassengersOnboard: bus.PassengersOnboard, StopOutbound: len(st.OutboundQueue), StopInbound: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed, AvgWaitMin: avg, StopAvgWaitMin: stopAvg, StopWaitSamples: stopN})
+			}
+			boardedAt := map[int]struct{}{st.ID: {}}
+			checkPlatforms(boardedAt, engine.Now())
+			for _, s := range route.Stops {
+				if _, ok := boardedAt[s.ID]; ok {
+					emitStop(s)
+				}
+			}
+			// quiet board trace
+			if qOut, qIn := sim.QueuedPassengers(route); len(boarded) > 0 || qOut+qIn == 0 {
+				lastProgress = engine.Now()
+			} else if opt.StallTimeout > 0 && engine.Now().Sub(lastProgress) >= opt.StallTimeout {
+				stalled = true
+				stallDiagnostic = sim.StallDiagnostic(route, buses, engine.Now().Sub(lastProgress))
+				slog.Warn("stall detected", "diagnostic", stallDiagnostic)
+				break
+			}
+			dwell := computeDwell(len(boarded), len(alighted))
+			depart := engine.Now().Add(dwell)
+			if depart.After(lastGen) {
+				advanceGenTo(depart)
+			}
+			engine.Clock.Set(depart)
+			dwellRec.Record(st.ID, ev.t, depart)
+			zoneRec.Depart(st.ID, bus)
+			loadRec.Depart(st.ID, bus)
+			dayRec.Depart(bus)
+			emit(sim.NewDoorsCloseEvent(bus, st.ID, ev.t, depart, len(alighted), len(boarded)))
+			if be, ok := berths.Release(st.ID, bus.ID, depart); ok {
+				emit(be)
+			}
+			// quiet dwell trace
+			if isDone() {
+				break
+			}
+			// Move to next (chunked with mid-segment termination like SSE)
+			if bus.Direction == "outbound" {
+				if idx == len(route.Stops)-1 {
+					// terminal turn and recovery time then flip (matches SSE terminal handling)
+					turn, tev, limited := yard.Turn(st.ID, bus.ID, engine.PeriodID, engine.Now())
+					if limited {
+						emit(tev)
+					}
+					if turn.After(lastGen) {
+						advanceGenTo(turn)
 					}
-					engine.Now = t
-					// quiet move trace
+					busStats.Trip(bus.ID, "outbound", engine.Now(), true)
+					busStats.Layover(bus.ID, engine.Now())
+					engine.Clock.Set(turn)
+					bus.Direction = "inbound"
+					trips++
+					startTrip(bus)
+					decisions.Note(engine.Now(), route, bus, sim.DecisionTurnaround, st.ID, 0, "trip complete at terminal")
+					if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
+						slog.Debug("trace terminal_flip", "t", engine.Now(), "bus", bus.ID, "new_dir", bus.Direction)
+					}
+					// schedule next arrival at same terminal index (start inbound) immediately
 					if isDone() {
-						completed = false
-						break
+						// Generate passengers up to this event time
+					}
+					heap.Push(q, evt{t: shiftHold(bus, st), bus: bus, stopIdx: idx})
+				} else {
+					next := route.Stops[idx+1]
+					dist := route.SegmentKM(idx, idx+1)
+					travelMin := dist / bus.AverageSpeedKmph * 60
+					travelDur := time.Duration(travelMin * float64(time.Minute))
+					travelDur = sim.ResolveTravelTime(opt.Traffic, sim.TravelTimeRequest{BusID: bus.ID, FromStopID: st.ID, ToStopID: next.ID, Direction: bus.Direction, DistanceKM: dist, SpeedKmph: bus.AverageSpeedKmph, Depart: engine.Now()}, travelDur)
+					steps := int(travelDur / travelStep)
+					if steps < 1 {
+						steps = 1
+					}
+					stepDur := travelDur / time.Duration(steps)
+					completed := true
+					busStats.Set(bus.ID, engine.Now(), bus.PassengersOnboard, true)
+					leftAt := engine.Now()
+					for sstep := 0; sstep < steps; sstep++ {
+						sstep = nextStep(sstep, steps, leftAt, stepDur)
+						t := leftAt.Add(stepDur * time.Duration(sstep+1))
+						if t.After(lastGen) {
+							advanceGenTo(t)
+						}
+						engine.Clock.Set(t)
+						emitMove(bus, st, next, sstep+1, steps, "")
+						if isDone() {
+							completed = false
+							break
+						}
+					}
+					if completed {
+						busDistance[bus.ID] += dist
+						kwh := energyModel.SegmentKWh(bus.Type, bus.PassengersOnboard, dist, travelDur)
+						energyKWh += kwh
+						co2Kg += sim.SegmentCO2Kg(bus.Type, dist, kwh)
+						runningMin += travelDur.Minutes()
+						bus.CurrentStopID = next.ID
+						heap.Push(q, evt{t: engine.Now(), bus: bus, stopIdx: idx + 1})
 					}
 				}
-				if completed {
-					busDistance[bus.ID] += dist
-					bus.CurrentStopID = next.ID
-					heap.Push(q, evt{t: engine.Now, bus: bus, stopIdx: idx + 1})
-				}
-			}
-		} else {
-			if idx == 0 {
-				turn := engine.Now.Add(terminalPause)
-				if turn.After(lastGen) {
-					advanceGenTo(turn)
-				}
-				engine.Now = turn
-				bus.Direction = "outbound"
-				if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
-					fmt.Printf("[trace] terminal_flip t=%s bus=%d new_dir=%s\n", engine.Now.Format(time.RFC3339Nano), bus.ID, bus.Direction)
-				}
-				if isDone() {
-					break
-				}
-				heap.Push(q, evt{t: engine.Now, bus: bus, stopIdx: idx})
 			} else {
-				prev := route.Stops[idx-1]
-				dist := route.Stops[idx-1].DistanceToNext
-				travelMin := dist / bus.AverageSpeedKmph * 60
-				travelDur := time.Duration(travelMin * float64(time.Minute))
-				steps := int(travelDur / travelStep)
-				if steps < 1 {
-					steps = 1
-				}
-				stepDur := travelDur / time.Duration(steps)
-				completed := true
-				for sstep := 0; sstep < steps; sstep++ {
-					t := engine.Now.Add(stepDur)
-					if t.After(lastGen) {
-						advanceGenTo(t)
+				if idx == 0 {
+					turn, tev, limited := yard.Turn(st.ID, bus.ID, engine.PeriodID, engine.Now())
+					if limited {
+						emit(tev)
+					}
+					if turn.After(lastGen) {
+						advanceGenTo(turn)
+					}
+					busStats.Trip(bus.ID, "inbound", engine.Now(), true)
+					busStats.Layover(bus.ID, engine.Now())
+					engine.Clock.Set(turn)
+					bus.Direction = "outbound"
+					trips++
+					startTrip(bus)
+					decisions.Note(engine.Now(), route, bus, sim.DecisionTurnaround, st.ID, 0, "trip complete at terminal")
+					if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
+						slog.Debug("trace terminal_flip", "t", engine.Now(), "bus", bus.ID, "new_dir", bus.Direction)
 					}
-					engine.Now = t
-					// quiet move trace
 					if isDone() {
-						completed = false
 						break
 					}
-				}
-				if completed {
-					busDistance[bus.ID] += dist
-					bus.CurrentStopID = prev.ID
-					heap.Push(q, evt{t: engine.Now, bus: bus, stopIdx: idx - 1})
+					heap.Push(q, evt{t: shiftHold(bus, st), bus: bus, stopIdx: idx})
+				} else {
+					prev := route.Stops[idx-1]
+					dist := route.SegmentKM(idx, idx-1)
+					travelMin := dist / bus.AverageSpeedKmph * 60
+					travelDur := time.Duration(travelMin * float64(time.Minute))
+					travelDur = sim.ResolveTravelTime(opt.Traffic, sim.TravelTimeRequest{BusID: bus.ID, FromStopID: st.ID, ToStopID: prev.ID, Direction: bus.Direction, DistanceKM: dist, SpeedKmph: bus.AverageSpeedKmph, Depart: engine.Now()}, travelDur)
+					steps := int(travelDur / travelStep)
+					if steps < 1 {
+						steps = 1
+					}
+					stepDur := travelDur / time.Duration(steps)
+					completed := true
+					busStats.Set(bus.ID, engine.Now(), bus.PassengersOnboard, true)
+					leftAt := engine.Now()
+					for sstep := 0; sstep < steps; sstep++ {
+						sstep = nextStep(sstep, steps, leftAt, stepDur)
+						t := leftAt.Add(stepDur * time.Duration(sstep+1))
+						if t.After(lastGen) {
+							advanceGenTo(t)
+						}
+						engine.Clock.Set(t)
+						emitMove(bus, st, prev, sstep+1, steps, "")
+						if isDone() {
+							completed = false
+							break
+						}
+					}
+					if completed {
+						busDistance[bus.ID] += dist
+						kwh := energyModel.SegmentKWh(bus.Type, bus.PassengersOnboard, dist, travelDur)
+						energyKWh += kwh
+						co2Kg += sim.SegmentCO2Kg(bus.Type, dist, kwh)
+						runningMin += travelDur.Minutes()
+						bus.CurrentStopID = prev.ID
+						heap.Push(q, evt{t: engine.Now(), bus: bus, stopIdx: idx - 1})
+					}
 				}
 			}
+			// stop condition (post event boundary)
+			if isDone() {
+				break
+			}
 		}
-		// stop condition (post event boundary)
-		if isDone() {
-			break
-		}
+		return nil
+	}()
+
+	for _, sp := range tripSpans {
+		sp.End()
+	}
+	// shifts still open end with service, before the end-of-run repositioning
+	shifts.Finish(engine.Now())
+	aborted := ""
+	if loopErr != nil {
+		aborted = loopErr.Error()
+	} else if err := ctx.Err(); err != nil {
+		aborted = "cancelled: " + err.Error()
 	}
 
-	// Reposition (layover) phase: direction-aware to nearest allowed layover ahead; add distances; update engine.Now monotonically
+	// Reposition (layover) phase: direction-aware to nearest allowed layover ahead; add distances; update engine.Now() monotonically
+	_, repSpan := tracer.Start(ctx, "reposition")
 	layoverIdxSet := make(map[int]struct{})
 	for i, s := range route.Stops {
 		if s.AllowLayover {
@@ -529,18 +957,24 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 		if j > i {
 			// forward along increasing indices
 			for k := i; k < j; k++ {
-				d += route.Stops[k].DistanceToNext
+				d += route.SegmentKM(k, k+1)
 			}
 		} else {
 			// backward along decreasing indices
 			for k := i; k > j; k-- {
-				d += route.Stops[k-1].DistanceToNext
+				d += route.SegmentKM(k, k-1)
 			}
 		}
 		return d
 	}
 
+	// the buses reposition one after another on the engine clock, but each leaves when
+	// service ends, so their time budgets start from there
+	serviceEnd := engine.Now()
 	for _, bus := range buses {
+		if stalled || aborted != "" || endedBy != "" {
+			break
+		}
 		curIdx, ok := lastIdx[bus.ID]
 		if !ok {
 			curIdx = idxOf(bus.CurrentStopID)
@@ -553,12 +987,7 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 		bestIdx := -1
 		bestKm := math.MaxFloat64
 		if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
-			fmt.Printf("[trace] reposition_select bus=%d cur_idx=%d dir=%s candidates=", bus.ID, curIdx, map[bool]string{true: "outbound", false: "inbound"}[forward])
-			for ci, li := range layoverIdxs {
-				_ = ci
-				fmt.Printf("%d:", li)
-			}
-			fmt.Printf("\n")
+			slog.Debug("trace reposition_select", "bus", bus.ID, "cur_idx", curIdx, "dir", map[bool]string{true: "outbound", false: "inbound"}[forward], "candidates", layoverIdxs)
 		}
 		for _, li := range layoverIdxs {
 			if (forward && li > curIdx) || (!forward && li < curIdx) {
@@ -568,10 +997,11 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 					bestIdx = li
 				}
 				if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
-					fmt.Printf("[trace] ahead_candidate idx=%d km=%.3f\n", li, dkm)
+					slog.Debug("trace ahead_candidate", "idx", li, "km", dkm)
 				}
 			}
 		}
+		aheadFound := bestIdx != -1
 		if bestIdx == -1 { // fallback: nearest overall by km
 			bestKm = math.MaxFloat64
 			for _, li := range layoverIdxs {
@@ -581,118 +1011,147 @@ func Run(route *model.Route, fleet []*model.Bus, opt Options) (Summary, error) {
 					bestIdx = li
 				}
 				if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
-					fmt.Printf("[trace] fallback_candidate idx=%d km=%.3f\n", li, dkm)
+					slog.Debug("trace fallback_candidate", "idx", li, "km", dkm)
 				}
 			}
 		}
 		if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
-			fmt.Printf("[trace] reposition_choice bus=%d best_idx=%d best_km=%.3f\n", bus.ID, bestIdx, bestKm)
+			slog.Debug("trace reposition_choice", "bus", bus.ID, "best_idx", bestIdx, "best_km", bestKm)
+		}
+		if bestIdx != -1 {
+			decisions.Note(engine.Now(), route, bus, sim.DecisionReposition, route.Stops[curIdx].ID, route.Stops[bestIdx].ID, sim.RepositionReason(aheadFound, bestIdx == curIdx, bestKm, layoverIdxs))
 		}
 		if bestIdx == -1 || bestIdx == curIdx {
+			if bestIdx == curIdx {
+				emit(sim.LayoverEvent{BusID: bus.ID, TerminalStopID: route.Stops[curIdx].ID})
+			}
 			continue
 		}
 		step := 1
 		if bestIdx < curIdx {
 			step = -1
 		}
+		busStats.Reposition(bus.ID, serviceEnd)
+		repositioned := time.Duration(0)
 		for i := curIdx; i != bestIdx; i += step {
-			var dist float64
-			if step == 1 {
-				dist = route.Stops[i].DistanceToNext
-			} else {
-				dist = route.Stops[i-1].DistanceToNext
-			}
+			dist := route.SegmentKM(i, i+step)
 			// Advance simulated time by travel duration for completeness
 			travelMin := dist / bus.AverageSpeedKmph * 60
 			travelDur := time.Duration(travelMin * float64(time.Minute))
+			travelDur = sim.ResolveTravelTime(opt.Traffic, sim.TravelTimeRequest{BusID: bus.ID, FromStopID: route.Stops[i].ID, ToStopID: route.Stops[i+step].ID, Direction: bus.Direction, DistanceKM: dist, SpeedKmph: bus.AverageSpeedKmph, Depart: engine.Now(), Phase: "reposition"}, travelDur)
 			steps := int(travelDur / travelStep)
 			if steps < 1 {
 				steps = 1
 			}
 			stepDur := travelDur / time.Duration(steps)
+			kwh := energyModel.SegmentKWh(bus.Type, bus.PassengersOnboard, dist, travelDur)
+			energyKWh += kwh
+			co2Kg += sim.SegmentCO2Kg(bus.Type, dist, kwh)
+			runningMin += travelDur.Minutes()
+			repositioned += stepDur * time.Duration(steps)
 			for sstep := 0; sstep < steps; sstep++ {
-				engine.Now = engine.Now.Add(stepDur)
+				engine.Clock.Advance(stepDur)
 				// Credit distance gradually like SSE reposition move events
 				busDistance[bus.ID] += dist / float64(steps)
-				// quiet reposition move trace
+				emitMove(bus, route.Stops[i], route.Stops[i+step], sstep+1, steps, "reposition")
 			}
 		}
 		bus.CurrentStopID = route.Stops[bestIdx].ID
+		busStats.Layover(bus.ID, serviceEnd.Add(repositioned))
+		emit(sim.LayoverEvent{BusID: bus.ID, TerminalStopID: route.Stops[bestIdx].ID})
 		if opt.TraceBusID > 0 && opt.TraceBusID == bus.ID {
 			aheadOnly := ((forward && bestIdx > curIdx) || (!forward && bestIdx < curIdx))
 			_ = aheadOnly // reserved for potential future logging parity
-			fmt.Printf("buslog bus=%d layover stop_idx=%d next_idx=%d stop_id=%d dist_km=%.2f\n", bus.ID, bestIdx, -1, route.Stops[bestIdx].ID, math.Round(busDistance[bus.ID]*100)/100)
+			slog.Debug("buslog layover", "bus", bus.ID, "stop_idx", bestIdx, "next_idx", -1, "stop_id", route.Stops[bestIdx].ID, "dist_km", math.Round(busDistance[bus.ID]*100)/100)
 		}
 	}
 
+	repSpan.End()
+
 	avgWait := 0.0
 	if waitCount > 0 {
 		avgWait = waitSumMin / float64(waitCount)
 	}
 	// Clamp generated to cap defensively
-	if engine.GeneratedPassengers > opt.PassengerCap {
+	if opt.PassengerCap > 0 && engine.GeneratedPassengers > opt.PassengerCap {
 		engine.GeneratedPassengers = opt.PassengerCap
 	}
 
-	round2 := func(x float64) float64 { return math.Round(x*100) / 100 }
-	sum := Summary{Generated: engine.GeneratedPassengers, Served: cumServed, AvgWaitMin: avgWait, BusDistance: busDistance}
+	sum := Summary{Seed: baseSeed, Start: start, EndedBy: sim.EndReason(aborted, stalled, endedBy, opt.PassengerCap), Generated: engine.GeneratedPassengers, Served: cumServed, AvgWaitMin: avgWait, BusDistance: busDistance, Stalled: stalled, Diagnostic: stallDiagnostic, Aborted: aborted, Wait: waitStats.Distribution(), Stops: engine.StopStatsSnapshot(), Buses: busStats.Snapshot(buses, busDistance), Decisions: decisions.Entries(), Capacity: capacity, EnergyKWh: energyKWh, RunningMin: runningMin, CO2Kg: co2Kg}
+	sum.Types = sim.TypeBreakdown(buses, sum.Buses, busDistance, true)
+	sum.Zones = zoneRec.Stats(sum.Stops)
+	sum.Load = loadRec.Profile()
+	sum.Headway = headwayRec.Stats()
+	sum.StopHeadways = headwayRec.ByStop(route)
+	sum.Anomalies = anomalies.Events()
+	sum.Shifts = shifts.Stats()
+	sum.Periods = dayRec.Stats(engine)
+	sum.Platforms = platforms.Stats(engine.Now())
+	sum.Berths = berths.Stats(route, start, engine.Now())
+	sum.Turnarounds = yard.Stats(route, start, engine.Now())
+	sum.TripTimes = sim.FleetTripTimes(sum.Buses)
+	sum.Directions = dirRec.Stats(engine, sum.Wait)
+	sum.ExcessWait = ewtRec.Stats(route)
+	sum.Quality = sim.ComputeQuality(sum.Wait, sum.Buses, sum.Stops, stalled, opt.QualityWeights)
 	// Compute totals as the sum of displayed per-bus values (rounded), so rows and totals align across drivers
+	hours := sim.ServiceHours(sum.Buses)
 	for _, b := range buses {
-		d := round2(busDistance[b.ID])
+		d, c := sim.ReportPrecision.BusCost(busDistance[b.ID], hours[b.ID], b.Type, true)
 		sum.TotalDistance += d
-		if b.Type != nil {
-			sum.TotalCost += round2(float64(b.Type.CostPerKm) * d)
-		}
-	}
-
-	// Optional CSV report
-	if opt.ReportPath != "" {
-		ts := time.Now().Format("20060102-150405")
-		outPath := opt.ReportPath
-		if fi, err := os.Stat(outPath); err == nil && fi.IsDir() {
-			outPath = filepath.Join(outPath, fmt.Sprintf("report-%s.csv", ts))
-		} else if outPath != "" {
-			ext := filepath.Ext(outPath)
-			base := outPath[:len(outPath)-len(ext)]
-			outPath = fmt.Sprintf("%s-%s%s", base, ts, ext)
-		}
-		if f, err := os.Create(outPath); err == nil {
-			defer f.Close()
-			fmt.Fprintln(f, "section,bus_id,direction,type,avg_speed_kmph,distance_km,cost,generated,served,avg_wait_min,buses_count,timestamp")
-			for _, b := range buses {
-				d := round2(busDistance[b.ID])
-				c := 0.0
-				typeName := ""
-				if b.Type != nil {
-					c = round2(float64(b.Type.CostPerKm) * d)
-					typeName = b.Type.Name
-				}
-				fmt.Fprintf(f, "bus,%d,%s,%s,%.1f,%.2f,%.2f,,,,,%s\n", b.ID, b.Direction, typeName, b.AverageSpeedKmph, d, c, ts)
-			}
-			fmt.Fprintf(f, "summary,,,,,,%.2f,%d,%d,%.2f,%d,%s\n", sum.TotalCost, sum.Generated, sum.Served, sum.AvgWaitMin, len(buses), ts)
-			log.Printf("CSV report written to %s", outPath)
-		} else {
-			log.Printf("report: create failed: %v", err)
-		}
+		sum.TotalCost += c
 	}
 
-	// Console report
-	fmt.Println("=== Simulation Report (batch) ===")
-	fmt.Printf("Buses on route: %d\n", len(buses))
-	fmt.Printf("Passengers generated: %d\n", sum.Generated)
-	fmt.Printf("Passengers served: %d\n", sum.Served)
-	fmt.Printf("Average wait: %.2f minutes\n", sum.AvgWaitMin)
-	for _, b := range buses {
-		d := round2(busDistance[b.ID])
-		c := 0.0
-		name := ""
-		if b.Type != nil {
-			c = round2(float64(b.Type.CostPerKm) * d)
-			name = b.Type.Name
+	emit(sim.DoneEvent{Completed: !stalled && aborted == "", EndedBy: sum.EndedBy, Stalled: stalled, Diagnostic: stallDiagnostic, Aborted: aborted, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed, AvgWaitMin: avgWait, BusDistance: busDistance, Passengers: engine.Passengers, DwellStats: dwellRec.Stats(route, start, time.Time{}), Wait: sum.Wait, StopStats: sum.Stops, Zones: sum.Zones, Load: sum.Load, Headway: sum.Headway, StopHeadways: sum.StopHeadways, Anomalies: sum.Anomalies, Shifts: sum.Shifts, BusStats: sum.Buses, Decisions: sum.Decisions, Periods: sum.Periods, Platforms: sum.Platforms, Berths: sum.Berths, Turnarounds: sum.Turnarounds, Directions: sum.Directions, ExcessWait: sum.ExcessWait})
+
+	// Reports share the SSE writers so both drivers produce identical layouts
+	rep := sim.ReportSummary{Label: "batch", Generated: sum.Generated, Served: sum.Served, AvgWaitMin: sum.AvgWaitMin, BusDistance: busDistance, Stalled: stalled, Diagnostic: stallDiagnostic, Aborted: aborted, Wait: &sum.Wait, Stops: sum.Stops, Zones: sum.Zones, PeakLoad: sum.Load.Peak, Headway: sum.Headway, StopHeadways: sum.StopHeadways, Anomalies: sum.Anomalies, Shifts: sum.Shifts, BusStats: sum.Buses, Quality: &sum.Quality, Capacity: capacity.Note(), EnergyKWh: energyKWh, RunningMin: runningMin, CO2Kg: co2Kg, Metadata: sim.RunMetadata(opt.parameters(sum.Seed), buses), Criterion: sim.DescribeCriterion(opt.PassengerCap, opt.Criterion), EndedBy: sum.EndedBy, Periods: sum.Periods, Platforms: sum.Platforms, Berths: sum.Berths, Turnarounds: sum.Turnarounds, Directions: sum.Directions, ExcessWait: &sum.ExcessWait}
+	if opt.ReportFormat == "json" {
+		if err := writeJSONSummary(opt.ReportPath, opt.Quiet, buses, opt, sum); err != nil {
+			slog.Error("json summary: create failed", "err", err)
+		}
+	} else if opt.ReportPath != "" {
+		if _, err := report.Write(opt.ReportPath, opt.ReportFormat, buses, rep); err != nil {
+			slog.Error("report: create failed", "err", err)
+		}
+	}
+	if opt.DwellReportPath != "" {
+		if _, err := sim.WriteDwellReport(opt.DwellReportPath, dwellRec.Stats(route, start, time.Time{})); err != nil {
+			slog.Error("dwell report: create failed", "err", err)
+		}
+	}
+	if opt.LoadReportPath != "" {
+		if _, err := sim.WriteLoadReport(opt.LoadReportPath, sum.Load); err != nil {
+			slog.Error("load report: create failed", "err", err)
+		}
+	}
+	if opt.PassengerLogPath != "" {
+		if _, err := sim.WritePassengerLog(opt.PassengerLogPath, engine.Passengers); err != nil {
+			slog.Error("passenger log: create failed", "err", err)
 		}
-		fmt.Printf("Bus %d (%s, %s) distance=%.2f km cost=%.2f\n", b.ID, b.Direction, name, d, c)
 	}
-	fmt.Printf("Total distance: %.2f km\n", sum.TotalDistance)
-	fmt.Printf("Total operating cost: %.2f\n", sum.TotalCost)
-	return sum, nil
+	if opt.DecisionLogPath != "" {
+		if _, err := sim.WriteDecisionLog(opt.DecisionLogPath, sum.Decisions); err != nil {
+			slog.Error("decision log: create failed", "err", err)
+		}
+	}
+	if opt.TrajectoryLogPath != "" {
+		if _, err := sim.WriteTrajectories(opt.TrajectoryLogPath, traj, opt.SimplifyToleranceM); err != nil {
+			slog.Error("trajectories: create failed", "err", err)
+		}
+	}
+	if opt.ExportFormat != "" {
+		if paths, err := export.Write(opt.ExportFormat, opt.ExportDir, route, engine.Passengers, opt.SimplifyToleranceM); err != nil {
+			slog.Error("export failed", "err", err)
+		} else {
+			slog.Info("export written", "format", opt.ExportFormat, "files", paths)
+		}
+	}
+	if !opt.Quiet && opt.ReportFormat != "json" {
+		sim.PrintConsoleReport(buses, rep)
+	}
+	runSpan.SetAttributes(attribute.Int("generated", sum.Generated), attribute.Int64("served", sum.Served), attribute.Bool("stalled", stalled))
+	if loopErr != nil {
+		return sum, loopErr
+	}
+	return sum, ctx.Err()
 }
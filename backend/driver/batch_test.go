@@ -0,0 +1,103 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"brt08/backend/internal/simtest"
+	"brt08/backend/sim"
+)
+
+func TestRunSameSeedSameEvents(t *testing.T) {
+	start, err := sim.ParseStartTime("2024-03-04T06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func() ([]string, Summary) {
+		route, fleet := simtest.Corridor(t)
+		events, sum, err := RunEvents(context.Background(), route, fleet, Options{PeriodID: 2, PassengerCap: 300, Seed: 11, StartTime: start, Quiet: true, MetricsInterval: 5 * time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		trace := make([]string, len(events))
+		for i, ev := range events {
+			b, err := json.Marshal(ev)
+			if err != nil {
+				t.Fatalf("event %d (%T): %v", i, ev, err)
+			}
+			trace[i] = fmt.Sprintf("%T %s", ev, b)
+		}
+		return trace, sum
+	}
+	a, sumA := run()
+	b, sumB := run()
+	if len(a) != len(b) {
+		t.Fatalf("event counts differ: %d vs %d", len(a), len(b))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("event %d differs:\n%s\n%s", i, a[i], b[i])
+		}
+	}
+	if sumA.Served != sumB.Served || sumA.AvgWaitMin != sumB.AvgWaitMin || sumA.TotalDistance != sumB.TotalDistance {
+		t.Errorf("summaries differ: served %d/%d, wait %v/%v, distance %v/%v", sumA.Served, sumB.Served, sumA.AvgWaitMin, sumB.AvgWaitMin, sumA.TotalDistance, sumB.TotalDistance)
+	}
+	if sumA.Served == 0 {
+		t.Error("nobody was served")
+	}
+}
+
+// lastArrival returns the latest bus arrival time among events.
+func lastArrival(events []sim.Event) time.Time {
+	var last time.Time
+	for _, ev := range events {
+		if a, ok := ev.(sim.ArriveEvent); ok && a.Time.After(last) {
+			last = a.Time
+		}
+	}
+	return last
+}
+
+func TestStreamingMatchesBatchTiming(t *testing.T) {
+	start, err := sim.ParseStartTime("2024-03-04T06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	horizon := sim.StopCriterion{Duration: time.Hour}
+	route, fleet := simtest.Corridor(t)
+	batchEvents, sum, err := RunEvents(context.Background(), route, fleet, Options{PeriodID: 2, Seed: 3, StartTime: start, Quiet: true, Criterion: horizon})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route, fleet = simtest.Corridor(t)
+	at := start.Resolve(2, sim.DaySchedule{}, time.Now())
+	opts := sim.RunnerOptions{PeriodID: 2, Start: at, Clock: sim.NewHeadlessClock(at), Criterion: horizon}
+	events, stop, wait := sim.StartRunner(context.Background(), route, fleet, 3, Options{}.lambda(), opts, sim.StaticControl{SpeedMult: 1, ArrivalMult: 1})
+	defer stop()
+	var stream []sim.Event
+	for ev := range events {
+		stream = append(stream, ev)
+	}
+	wait()
+	done, ok := stream[len(stream)-1].(sim.DoneEvent)
+	if !ok {
+		t.Fatalf("last event is %T, want DoneEvent", stream[len(stream)-1])
+	}
+
+	// both stop after an hour of simulated time, give or take the last stop visit
+	end := at.Add(horizon.Duration)
+	for name, last := range map[string]time.Time{"batch": lastArrival(batchEvents), "streaming": lastArrival(stream)} {
+		if last.Before(at) || last.After(end.Add(10*time.Minute)) {
+			t.Errorf("%s: last arrival at +%s, want about %s", name, last.Sub(at), horizon.Duration)
+		}
+	}
+	// different random streams, the same service: waits agree to within a few minutes
+	if sum.AvgWaitMin <= 0 || math.Abs(done.AvgWaitMin-sum.AvgWaitMin) > 3 {
+		t.Errorf("average wait: streaming %.1f min, batch %.1f min", done.AvgWaitMin, sum.AvgWaitMin)
+	}
+}
@@ -0,0 +1,437 @@
+package driver
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/data"
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+// Observation is the number of passengers observed boarding at a stop over a whole
+// demand period.
+type Observation struct {
+	StopID    int
+	PeriodID  int
+	Boardings float64
+}
+
+// ReadObservations reads observed boardings from a CSV whose header names the columns
+// stop_id, period and boardings; other columns are ignored.
+func ReadObservations(path string) ([]Observation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading header: %w", path, err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, name := range []string{"stop_id", "period", "boardings"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("%s: header lacks a %s column", path, name)
+		}
+	}
+	var obs []Observation
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		field := func(name string) string {
+			if i := col[name]; i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		stop, err1 := strconv.Atoi(field("stop_id"))
+		period, err2 := strconv.Atoi(field("period"))
+		n, err3 := strconv.ParseFloat(field("boardings"), 64)
+		if err1 != nil || err2 != nil || err3 != nil || n < 0 {
+			return nil, fmt.Errorf("%s: line %d: want stop_id, period and boardings >= 0", path, line)
+		}
+		obs = append(obs, Observation{StopID: stop, PeriodID: period, Boardings: n})
+	}
+	if len(obs) == 0 {
+		return nil, fmt.Errorf("%s: no observations", path)
+	}
+	return obs, nil
+}
+
+// calibrationParam is a demand parameter Calibrate can fit: its bounds and the first
+// pass's step, on a log scale (factor) for rates and biases, linear for shares.
+type calibrationParam struct {
+	name   string
+	lo, hi float64
+	step   float64
+	log    bool
+}
+
+var calibrationParams = []calibrationParam{
+	{name: "lambda", lo: 0.05, hi: 20, step: 2, log: true},
+	{name: "dir_bias", lo: 0.1, hi: 10, step: 2, log: true},
+	{name: "spatial_gradient", lo: 0, hi: 1, step: 0.25},
+	{name: "baseline_demand", lo: 0, hi: 1, step: 0.25},
+}
+
+func paramValue(o Options, name string) float64 {
+	switch name {
+	case "lambda":
+		return o.lambda()
+	case "dir_bias":
+		return o.DirBias
+	case "spatial_gradient":
+		return o.SpatialGradient
+	}
+	return o.BaselineDemand
+}
+
+func setParam(o *Options, name string, v float64) {
+	switch name {
+	case "lambda":
+		o.Lambda = v
+	case "dir_bias":
+		o.DirBias = v
+	case "spatial_gradient":
+		o.SpatialGradient = v
+	case "baseline_demand":
+		o.BaselineDemand = v
+	}
+}
+
+// CalibrationOptions configures the demand calibration search.
+type CalibrationOptions struct {
+	Base         Options // scenario and starting parameter values; the period comes from the observations
+	NewRoute     func() (*model.Route, error)
+	Fleet        []*model.Bus
+	Observed     []Observation
+	Params       []string // parameters to fit (nil = lambda, dir_bias, spatial_gradient, baseline_demand)
+	Rounds       int      // passes over the parameters, each halving the step (default 3)
+	Steps        int      // candidates either side of the current value per parameter and pass (default 2)
+	Replications int      // seeds per evaluation, averaged (default 1)
+	Workers      int      // runs evaluated concurrently (<= 1 = sequential)
+}
+
+// CalibrationStep is one evaluated candidate value of a parameter.
+type CalibrationStep struct {
+	Round  int
+	Param  string
+	Value  float64
+	RMSE   float64
+	Chosen bool
+}
+
+// CalibrationFit compares the observed and fitted boardings at one stop and period.
+type CalibrationFit struct {
+	StopID    int
+	Name      string
+	PeriodID  int
+	Observed  float64
+	Simulated float64
+}
+
+// CalibrationResult is the fitted parameter set with the search that found it.
+type CalibrationResult struct {
+	Params      []string
+	Initial     map[string]float64
+	Fitted      map[string]float64
+	InitialRMSE float64
+	RMSE        float64
+	RunHours    float64 // simulated length of each evaluation run
+	Runs        int
+	Steps       []CalibrationStep
+	Fit         []CalibrationFit
+}
+
+type obsKey struct{ stop, period int }
+
+// Calibrate fits the demand parameters to the observed boardings by coordinate search:
+// each pass tries, for every parameter in turn, the current value and Steps values
+// either side of it, keeps the one with the lowest root mean square error over the
+// observations and halves the step for the next pass. Every candidate runs the batch
+// driver once per observed period and seed, for Base.Criterion.Duration (default one
+// hour), with the same seeds throughout; simulated boardings are scaled from the run
+// to the period's length. A zero Base.Seed is fixed first.
+func Calibrate(ctx context.Context, opt CalibrationOptions) (CalibrationResult, error) {
+	res := CalibrationResult{Initial: map[string]float64{}, Fitted: map[string]float64{}}
+	if len(opt.Observed) == 0 {
+		return res, fmt.Errorf("no observed boardings")
+	}
+	params, err := selectParams(opt.Params)
+	if err != nil {
+		return res, err
+	}
+	route, err := opt.NewRoute()
+	if err != nil {
+		return res, err
+	}
+	periodHours := make(map[int]float64)
+	for _, p := range data.TimePeriods {
+		periodHours[p.ID] = float64(p.EndMin-p.StartMin) / 60
+	}
+	observed := make(map[obsKey]float64, len(opt.Observed))
+	var periods []float64
+	for _, o := range opt.Observed {
+		if route.GetStop(o.StopID) == nil {
+			return res, fmt.Errorf("observed stop %d is not on the route", o.StopID)
+		}
+		if _, ok := periodHours[o.PeriodID]; !ok {
+			return res, fmt.Errorf("observed period %d unknown (want 1..%d)", o.PeriodID, len(data.TimePeriods))
+		}
+		if !containsPeriod(periods, o.PeriodID) {
+			periods = append(periods, float64(o.PeriodID))
+		}
+		observed[obsKey{o.StopID, o.PeriodID}] += o.Boardings
+	}
+	sort.Float64s(periods)
+	rounds, steps, reps := opt.Rounds, opt.Steps, max(opt.Replications, 1)
+	if rounds <= 0 {
+		rounds = 3
+	}
+	if steps <= 0 {
+		steps = 2
+	}
+
+	base := opt.Base
+	if base.Seed == 0 {
+		base.Seed = time.Now().UnixNano() % (1 << 40)
+	}
+	seeds := make([]float64, reps)
+	for i := range seeds {
+		seeds[i] = float64(base.Seed + int64(i))
+	}
+	runFor := base.Criterion.Duration
+	if runFor <= 0 {
+		runFor = time.Hour
+	}
+	base.PassengerCap, base.Criterion, base.Day, base.StartTime = 0, sim.StopCriterion{Duration: runFor}, sim.DaySchedule{}, sim.StartTime{}
+	res.RunHours = runFor.Hours()
+	names := make(map[int]string)
+	for _, st := range route.Stops {
+		names[st.ID] = st.Name
+	}
+
+	// evaluate runs every value of param and returns the error and fitted boardings of each
+	evaluate := func(param string, values []float64) ([]float64, []map[obsKey]float64, error) {
+		axes := []SweepAxis{{Name: param, Values: values}, {Name: "period", Values: periods}, {Name: "seed", Values: seeds}}
+		runs, err := Sweep(ctx, SweepOptions{Base: base, Axes: axes, NewRoute: opt.NewRoute, Fleet: opt.Fleet, Parallel: opt.Workers})
+		res.Runs += len(runs)
+		if err != nil {
+			return nil, nil, err
+		}
+		sims := make([]map[obsKey]float64, len(values))
+		for i := range sims {
+			sims[i] = make(map[obsKey]float64)
+		}
+		for _, r := range runs {
+			if r.Err != "" {
+				return nil, nil, fmt.Errorf("%s=%g period %g: %s", param, r.Params[param], r.Params["period"], r.Err)
+			}
+			i := indexOf(values, r.Params[param])
+			period := int(r.Params["period"])
+			scale := periodHours[period] / res.RunHours / float64(reps)
+			for _, st := range r.Sum.Stops {
+				k := obsKey{st.StopID, period}
+				if _, ok := observed[k]; ok {
+					sims[i][k] += float64(st.Boarded) * scale
+				}
+			}
+		}
+		errs := make([]float64, len(values))
+		for i, fit := range sims {
+			sq := 0.0
+			for k, n := range observed {
+				d := fit[k] - n
+				sq += d * d
+			}
+			errs[i] = math.Sqrt(sq / float64(len(observed)))
+		}
+		return errs, sims, nil
+	}
+
+	for _, p := range params {
+		res.Params = append(res.Params, p.name)
+		res.Initial[p.name] = clampParam(p, paramValue(base, p.name))
+		setParam(&base, p.name, res.Initial[p.name])
+	}
+	errs, sims, err := evaluate(params[0].name, []float64{res.Initial[params[0].name]})
+	if err != nil {
+		return res, err
+	}
+	res.InitialRMSE, res.RMSE = errs[0], errs[0]
+	best := sims[0]
+	for round := 1; round <= rounds; round++ {
+		for _, p := range params {
+			cur := paramValue(base, p.name)
+			values := candidates(p, cur, steps, round)
+			errs, sims, err := evaluate(p.name, values)
+			if err != nil {
+				return res, err
+			}
+			pick := indexOf(values, cur)
+			for i := range values {
+				if errs[i] < errs[pick] {
+					pick = i
+				}
+			}
+			for i, v := range values {
+				res.Steps = append(res.Steps, CalibrationStep{Round: round, Param: p.name, Value: v, RMSE: errs[i], Chosen: i == pick})
+			}
+			setParam(&base, p.name, values[pick])
+			res.RMSE, best = errs[pick], sims[pick]
+			slog.Info("calibration step", "round", round, "param", p.name, "value", values[pick], "rmse", errs[pick])
+		}
+	}
+	for _, p := range params {
+		res.Fitted[p.name] = paramValue(base, p.name)
+	}
+	for k, n := range observed {
+		res.Fit = append(res.Fit, CalibrationFit{StopID: k.stop, Name: names[k.stop], PeriodID: k.period, Observed: n, Simulated: best[k]})
+	}
+	sort.Slice(res.Fit, func(i, j int) bool {
+		a, b := res.Fit[i], res.Fit[j]
+		if a.PeriodID != b.PeriodID {
+			return a.PeriodID < b.PeriodID
+		}
+		return route.IndexOf(a.StopID) < route.IndexOf(b.StopID)
+	})
+	return res, nil
+}
+
+func selectParams(names []string) ([]calibrationParam, error) {
+	if len(names) == 0 {
+		return calibrationParams, nil
+	}
+	var out []calibrationParam
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		found := false
+		for _, p := range calibrationParams {
+			if p.name == n {
+				out, found = append(out, p), true
+			}
+		}
+		if !found {
+			known := make([]string, len(calibrationParams))
+			for i, p := range calibrationParams {
+				known[i] = p.name
+			}
+			return nil, fmt.Errorf("unknown calibration parameter %q (want %s)", n, strings.Join(known, ", "))
+		}
+	}
+	return out, nil
+}
+
+// candidates returns cur and steps values either side of it for the given pass, within
+// p's bounds and without duplicates.
+func candidates(p calibrationParam, cur float64, steps, round int) []float64 {
+	var out []float64
+	for k := -steps; k <= steps; k++ {
+		var v float64
+		if p.log {
+			v = cur * math.Pow(p.step, float64(k)/float64(steps)/math.Pow(2, float64(round-1)))
+		} else {
+			v = cur + p.step*float64(k)/float64(steps)/math.Pow(2, float64(round-1))
+		}
+		if k != 0 {
+			v = math.Round(clampParam(p, v)*1e4) / 1e4
+		}
+		if indexOf(out, v) < 0 {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func clampParam(p calibrationParam, v float64) float64 { return math.Max(p.lo, math.Min(p.hi, v)) }
+
+func indexOf(values []float64, v float64) int {
+	for i, x := range values {
+		if x == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsPeriod(periods []float64, id int) bool { return indexOf(periods, float64(id)) >= 0 }
+
+// FlagString renders the fitted parameters as command-line flags.
+func (r CalibrationResult) FlagString() string {
+	parts := make([]string, 0, len(r.Params))
+	for _, p := range r.Params {
+		parts = append(parts, fmt.Sprintf("-%s %s", p, strconv.FormatFloat(r.Fitted[p], 'f', -1, 64)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// PrintCalibrationReport prints the fitted parameter set and the fit per stop and period.
+func PrintCalibrationReport(r CalibrationResult) {
+	fmt.Printf("=== Demand Calibration (%d runs of %.2f h) ===\n", r.Runs, r.RunHours)
+	fmt.Printf("  %-18s %10s %10s\n", "parameter", "initial", "fitted")
+	for _, p := range r.Params {
+		fmt.Printf("  %-18s %10.4f %10.4f\n", p, r.Initial[p], r.Fitted[p])
+	}
+	fmt.Printf("  %-18s %10.2f %10.2f\n", "rmse_boardings", r.InitialRMSE, r.RMSE)
+	fmt.Printf("  %-6s %-28s %10s %10s\n", "period", "stop", "observed", "fitted")
+	for _, f := range r.Fit {
+		fmt.Printf("  %-6d %-28.28s %10.1f %10.1f\n", f.PeriodID, f.Name, f.Observed, f.Simulated)
+	}
+	fmt.Printf("Fitted parameters: %s\n", r.FlagString())
+}
+
+// WriteCalibrationCSV writes the parameters ("param" rows), every evaluated candidate
+// ("step" rows) and the fit ("fit" rows) to a file or directory (calibration-*.csv).
+func WriteCalibrationCSV(path string, r CalibrationResult) (string, error) {
+	ts := time.Now().Format("20060102-150405")
+	outPath := sim.TimestampedPath(path, "calibration", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"section", "param", "initial", "fitted", "round", "value", "rmse", "chosen", "period", "stop_id", "stop_name", "observed", "simulated"})
+	num := func(x float64) string { return strconv.FormatFloat(x, 'f', 4, 64) }
+	for _, p := range r.Params {
+		w.Write([]string{"param", p, num(r.Initial[p]), num(r.Fitted[p]), "", "", "", "", "", "", "", "", ""})
+	}
+	w.Write([]string{"param", "rmse_boardings", num(r.InitialRMSE), num(r.RMSE), "", "", "", "", "", "", "", "", ""})
+	for _, s := range r.Steps {
+		w.Write([]string{"step", s.Param, "", "", strconv.Itoa(s.Round), num(s.Value), num(s.RMSE), strconv.FormatBool(s.Chosen), "", "", "", "", ""})
+	}
+	for _, x := range r.Fit {
+		w.Write([]string{"fit", "", "", "", "", "", "", "", strconv.Itoa(x.PeriodID), strconv.Itoa(x.StopID), x.Name, num(x.Observed), num(x.Simulated)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("calibration written", "path", outPath, "steps", len(r.Steps))
+	return outPath, nil
+}
@@ -0,0 +1,128 @@
+package driver
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+// EcoComparison pairs a baseline batch run with the same scenario driven at the
+// advisor's speeds (same seed, so demand is identical).
+type EcoComparison struct {
+	Advice   []sim.SegmentAdvice
+	Baseline Summary
+	Eco      Summary
+}
+
+// CompareEco runs base twice on fresh routes: once as configured and once with adv as
+// the travel time provider (wrapping base.Traffic). A zero base.Seed is fixed first.
+func CompareEco(ctx context.Context, newRoute func() (*model.Route, error), fleet []*model.Bus, base Options, adv *sim.EcoAdvisor) (EcoComparison, error) {
+	cmp := EcoComparison{Advice: adv.Advice()}
+	if base.Seed == 0 {
+		base.Seed = time.Now().UnixNano() % (1 << 40)
+	}
+	base.Quiet = true
+	run := func(o Options) (Summary, error) {
+		route, err := newRoute()
+		if err != nil {
+			return Summary{}, err
+		}
+		return Run(ctx, route, ResizeFleet(fleet, len(fleet)), o)
+	}
+	var err error
+	if cmp.Baseline, err = run(base); err != nil {
+		return cmp, fmt.Errorf("baseline run: %w", err)
+	}
+	eco := base
+	adv.Next = base.Traffic
+	eco.Traffic = adv
+	eco = eco.withoutOutputs()
+	if cmp.Eco, err = run(eco); err != nil {
+		return cmp, fmt.Errorf("eco run: %w", err)
+	}
+	return cmp, nil
+}
+
+// pctDelta is the relative change from a to b in percent (0 when a is 0).
+func pctDelta(a, b float64) float64 {
+	if a == 0 {
+		return 0
+	}
+	return (b - a) / a * 100
+}
+
+// perKM normalizes a run total by its distance, since runs end when demand is served
+// and eco and baseline may cover different distances.
+func perKM(x float64, s Summary) float64 {
+	if s.TotalDistance <= 0 {
+		return 0
+	}
+	return x / s.TotalDistance
+}
+
+// PrintEcoReport prints the speed advisory and the eco vs baseline deltas.
+func PrintEcoReport(cmp EcoComparison) {
+	fmt.Println("=== Eco-Driving Speed Advisory ===")
+	fmt.Printf("  %-6s %-14s %8s %10s %10s\n", "type", "segment", "km", "advised", "baseline")
+	for _, a := range cmp.Advice {
+		fmt.Printf("  %-6d %6d->%-6d %8.3f %10.1f %10.1f\n", a.TypeID, a.FromStopID, a.ToStopID, a.DistanceKM, a.SpeedKmph, a.BaselineKmph)
+	}
+	b, e := cmp.Baseline, cmp.Eco
+	fmt.Println("Eco vs baseline:")
+	row := func(name string, x, y float64) {
+		fmt.Printf("  %-20s %12.2f %12.2f %+9.1f%%\n", name, x, y, pctDelta(x, y))
+	}
+	fmt.Printf("  %-20s %12s %12s %10s\n", "", "baseline", "eco", "delta")
+	row("energy_kwh", b.EnergyKWh, e.EnergyKWh)
+	row("running_min", b.RunningMin, e.RunningMin)
+	row("kwh_per_km", perKM(b.EnergyKWh, b), perKM(e.EnergyKWh, e))
+	row("running_min_per_km", perKM(b.RunningMin, b), perKM(e.RunningMin, e))
+	row("avg_wait_min", b.AvgWaitMin, e.AvgWaitMin)
+	row("wait_p90_min", b.Wait.Overall.P90, e.Wait.Overall.P90)
+	row("served", float64(b.Served), float64(e.Served))
+	row("total_distance_km", b.TotalDistance, e.TotalDistance)
+	if e.Stalled || b.Stalled {
+		fmt.Printf("  stalled: baseline=%v eco=%v\n", b.Stalled, e.Stalled)
+	}
+}
+
+// WriteEcoCSV writes the advisory ("advice" rows) and the comparison ("compare" rows)
+// to a file or directory (eco-*.csv).
+func WriteEcoCSV(path string, cmp EcoComparison) (string, error) {
+	ts := time.Now().Format("20060102-150405")
+	outPath := sim.TimestampedPath(path, "eco", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"section", "type_id", "from_stop_id", "to_stop_id", "distance_km", "advised_kmph", "baseline_kmph", "metric", "baseline", "eco", "delta_pct"})
+	num := func(x float64) string { return strconv.FormatFloat(x, 'f', 3, 64) }
+	for _, a := range cmp.Advice {
+		w.Write([]string{"advice", fmt.Sprint(a.TypeID), fmt.Sprint(a.FromStopID), fmt.Sprint(a.ToStopID), num(a.DistanceKM), num(a.SpeedKmph), num(a.BaselineKmph), "", "", "", ""})
+	}
+	b, e := cmp.Baseline, cmp.Eco
+	for _, m := range []struct {
+		name string
+		x, y float64
+	}{{"energy_kwh", b.EnergyKWh, e.EnergyKWh}, {"running_min", b.RunningMin, e.RunningMin}, {"kwh_per_km", perKM(b.EnergyKWh, b), perKM(e.EnergyKWh, e)}, {"running_min_per_km", perKM(b.RunningMin, b), perKM(e.RunningMin, e)}, {"avg_wait_min", b.AvgWaitMin, e.AvgWaitMin}, {"wait_p90_min", b.Wait.Overall.P90, e.Wait.Overall.P90}, {"served", float64(b.Served), float64(e.Served)}, {"total_distance_km", b.TotalDistance, e.TotalDistance}} {
+		w.Write([]string{"compare", "", "", "", "", "", "", m.name, num(m.x), num(m.y), num(pctDelta(m.x, m.y))})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("eco comparison written", "path", outPath)
+	return outPath, nil
+}
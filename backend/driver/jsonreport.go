@@ -0,0 +1,93 @@
+package driver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+// JSONBusRow mirrors the CSV report's bus rows.
+type JSONBusRow struct {
+	BusID        int     `json:"bus_id"`
+	Type         string  `json:"type"`
+	Direction    string  `json:"direction"` // at the end of the run
+	AvgSpeedKmph float64 `json:"avg_speed_kmph"`
+	DistanceKM   float64 `json:"distance_km"`
+	Cost         float64 `json:"cost"`
+}
+
+// JSONReport is the machine-readable batch summary written with ReportFormat "json".
+type JSONReport struct {
+	Driver     string         `json:"driver"`
+	Timestamp  time.Time      `json:"timestamp"`
+	Parameters map[string]any `json:"parameters"`
+	Summary    Summary        `json:"summary"`
+	BusRows    []JSONBusRow   `json:"bus_rows"`
+}
+
+// NewJSONReport assembles the report for a finished run.
+func NewJSONReport(buses []*model.Bus, opt Options, sum Summary) JSONReport {
+	rep := JSONReport{Driver: "batch", Timestamp: time.Now(), Summary: sum, Parameters: sim.RunMetadata(opt.parameters(sum.Seed), buses)}
+	pr := sim.ReportPrecision
+	hours := sim.ServiceHours(sum.Buses)
+	for _, b := range buses {
+		row := JSONBusRow{BusID: b.ID, Direction: b.Direction, AvgSpeedKmph: b.AverageSpeedKmph}
+		if b.Type != nil {
+			row.Type = b.Type.Name
+		}
+		row.DistanceKM, row.Cost = pr.BusCost(sum.BusDistance[b.ID], hours[b.ID], b.Type, true)
+		rep.BusRows = append(rep.BusRows, row)
+	}
+	return rep
+}
+
+// parameters is the option set recorded in reports (CSV metadata and JSON parameters),
+// with the effective seed.
+func (opt Options) parameters(seed int64) map[string]any {
+	_, eco := opt.Traffic.(*sim.EcoAdvisor)
+	return map[string]any{
+		"driver": "batch", "seed": seed, "period": opt.PeriodID, "passenger_cap": opt.PassengerCap, "morning_toward_kivukoni": opt.MorningTowardKivukoni,
+		"dir_bias": opt.DirBias, "spatial_gradient": opt.SpatialGradient, "baseline_demand": opt.BaselineDemand, "demand_profile": opt.DemandProfile, "arrival_factor": opt.ArrivalFactor, "lambda": opt.lambda(),
+		"population": opt.Population, "group_size_mean": opt.GroupSizes.Mean(), "stall_timeout": opt.StallTimeout.String(),
+		"seed_window": opt.Seeding.Window.String(), "seed_dist": opt.Seeding.Dist, "seed_exclude_from_wait": opt.Seeding.ExcludeFromWait,
+		"traffic": opt.Traffic != nil, "eco": eco, "stop_criterion": sim.DescribeCriterion(opt.PassengerCap, opt.Criterion),
+		"shifts": opt.Shifts.String(), "day": opt.Day.String(), "start": opt.StartTime.String(),
+		"platform_capacity": opt.Platforms.Default, "platform_spill": opt.Platforms.Spill, "berths": opt.Berths.Default,
+		"turnaround_bays": opt.Turnaround.Bays, "turnaround_s": opt.Turnaround.Duration().Seconds(), "recovery": opt.Turnaround.Recovery.String(),
+	}
+}
+
+// writeJSONSummary writes the JSON report to path (a file or directory, timestamped
+// like the CSV report), or to stdout when path is empty and the run is not quiet.
+func writeJSONSummary(path string, quiet bool, buses []*model.Bus, opt Options, sum Summary) error {
+	rep := NewJSONReport(buses, opt, sum)
+	if path == "" {
+		if quiet {
+			return nil
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	outPath := sim.TimestampedPath(path, "summary", ".json", time.Now().Format("20060102-150405"))
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rep); err != nil {
+		return err
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+	slog.Info("json summary written", "path", outPath)
+	return nil
+}
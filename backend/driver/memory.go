@@ -0,0 +1,25 @@
+package driver
+
+import (
+	"context"
+
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+// RunEvents runs the batch driver headless and returns the complete ordered event
+// sequence (the same sim.Event types the SSE runner streams, ending with sim.DoneEvent)
+// instead of discarding it. The event-queue driver is deterministic for a given seed,
+// so tests and analysis code can assert on sequences programmatically.
+func RunEvents(ctx context.Context, route *model.Route, fleet []*model.Bus, opt Options) ([]sim.Event, Summary, error) {
+	var events []sim.Event
+	prev := opt.OnEvent
+	opt.OnEvent = func(e sim.Event) {
+		events = append(events, e)
+		if prev != nil {
+			prev(e)
+		}
+	}
+	sum, err := Run(ctx, route, fleet, opt)
+	return events, sum, err
+}
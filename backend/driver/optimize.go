@@ -0,0 +1,238 @@
+package driver
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+// OptimizeOptions configures the fleet sizing search.
+type OptimizeOptions struct {
+	Base          Options
+	NewRoute      func() (*model.Route, error)
+	Fleet         []*model.Bus // base fleet: its type mix (and per-bus speeds) is scaled to each size
+	TargetWaitMin float64      // wait time to meet (minutes)
+	Metric        string       // "avg" (default) or "p90"
+	MinBuses      int          // smallest fleet tried (default 1)
+	MaxBuses      int          // largest fleet tried
+	VaryTypes     bool         // also try single-type fleets of every bus type in Fleet
+	Replications  int          // seeds per candidate; metrics are averaged (default 1)
+	Workers       int          // candidates evaluated concurrently (<= 1 = sequential)
+}
+
+// FleetCandidate is one evaluated fleet.
+type FleetCandidate struct {
+	Mix        string // "mixed" (the base type mix) or a bus type name
+	Buses      int
+	AvgWaitMin float64
+	WaitP90Min float64
+	Served     float64
+	DistanceKM float64
+	Cost       float64
+	Stalled    int // replications that stalled (never feasible)
+	Feasible   bool
+}
+
+// OptimizeResult lists every candidate and the cheapest feasible one (nil if none).
+type OptimizeResult struct {
+	Metric     string
+	TargetMin  float64
+	Candidates []FleetCandidate
+	Best       *FleetCandidate
+}
+
+// OptimizeFleet evaluates fleets of MinBuses..MaxBuses buses (for the base mix and,
+// with VaryTypes, each single type) with the batch driver and returns the cheapest by
+// mean operating cost whose mean wait metric meets the target. Every candidate uses the
+// same seeds, so comparisons are not blurred by demand noise.
+func OptimizeFleet(ctx context.Context, opt OptimizeOptions) (OptimizeResult, error) {
+	res := OptimizeResult{Metric: opt.Metric, TargetMin: opt.TargetWaitMin}
+	if res.Metric == "" {
+		res.Metric = "avg"
+	}
+	if res.Metric != "avg" && res.Metric != "p90" {
+		return res, fmt.Errorf("unknown wait metric %q (want avg or p90)", opt.Metric)
+	}
+	if opt.TargetWaitMin <= 0 {
+		return res, fmt.Errorf("target wait must be > 0 minutes")
+	}
+	if len(opt.Fleet) == 0 {
+		return res, fmt.Errorf("no base fleet to scale")
+	}
+	lo, hi := opt.MinBuses, opt.MaxBuses
+	if lo < 1 {
+		lo = 1
+	}
+	if hi < lo {
+		return res, fmt.Errorf("max buses (%d) below min buses (%d)", hi, lo)
+	}
+	reps := opt.Replications
+	if reps < 1 {
+		reps = 1
+	}
+	seed := opt.Base.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano() % (1 << 40)
+	}
+	seeds := make([]float64, reps)
+	for i := range seeds {
+		seeds[i] = float64(seed + int64(i))
+	}
+
+	// candidate mixes: the configured fleet, then each bus type on its own
+	type mix struct {
+		name  string
+		buses []*model.Bus
+	}
+	mixes := []mix{{name: "mixed", buses: opt.Fleet}}
+	if opt.VaryTypes {
+		byType := make(map[int][]*model.Bus)
+		var order []*model.BusType
+		for _, b := range opt.Fleet {
+			if b.Type == nil {
+				continue
+			}
+			if _, ok := byType[b.Type.ID]; !ok {
+				order = append(order, b.Type)
+			}
+			byType[b.Type.ID] = append(byType[b.Type.ID], b)
+		}
+		if len(order) > 1 {
+			for _, bt := range order {
+				mixes = append(mixes, mix{name: bt.Name, buses: byType[bt.ID]})
+			}
+		}
+	}
+
+	type job struct {
+		mix mix
+		n   int
+	}
+	var jobs []job
+	for _, m := range mixes {
+		for n := lo; n <= hi; n++ {
+			jobs = append(jobs, job{m, n})
+		}
+	}
+	cands := make([]FleetCandidate, len(jobs))
+	errs := make([]error, len(jobs))
+	runPool(ctx, len(jobs), opt.Workers, func(i int) {
+		cands[i], errs[i] = evalCandidate(ctx, opt, jobs[i].mix.name, ResizeFleet(jobs[i].mix.buses, jobs[i].n), seeds, res.Metric)
+	})
+	for _, err := range errs {
+		if err != nil {
+			return res, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	res.Candidates = cands
+	for i := range res.Candidates {
+		c := &res.Candidates[i]
+		if c.Feasible && (res.Best == nil || c.Cost < res.Best.Cost) {
+			res.Best = c
+		}
+	}
+	return res, nil
+}
+
+// evalCandidate runs one fleet for every seed (sequentially; candidates are the unit
+// of parallelism) and averages the results.
+func evalCandidate(ctx context.Context, opt OptimizeOptions, mix string, fleet []*model.Bus, seeds []float64, metric string) (FleetCandidate, error) {
+	c := FleetCandidate{Mix: mix, Buses: len(fleet)}
+	runs, err := Sweep(ctx, SweepOptions{Base: opt.Base, Axes: []SweepAxis{{Name: "seed", Values: seeds}}, NewRoute: opt.NewRoute, Fleet: fleet})
+	if err != nil {
+		return c, err
+	}
+	ok := 0
+	for _, r := range runs {
+		if r.Err != "" {
+			continue
+		}
+		ok++
+		s := r.Sum
+		if s.Stalled {
+			c.Stalled++
+		}
+		c.AvgWaitMin += s.AvgWaitMin
+		c.WaitP90Min += s.Wait.Overall.P90
+		c.Served += float64(s.Served)
+		c.DistanceKM += s.TotalDistance
+		c.Cost += s.TotalCost
+	}
+	if ok > 0 {
+		k := float64(ok)
+		c.AvgWaitMin, c.WaitP90Min, c.Served, c.DistanceKM, c.Cost = c.AvgWaitMin/k, c.WaitP90Min/k, c.Served/k, c.DistanceKM/k, c.Cost/k
+		m := c.AvgWaitMin
+		if metric == "p90" {
+			m = c.WaitP90Min
+		}
+		c.Feasible = c.Stalled == 0 && m <= opt.TargetWaitMin
+	}
+	slog.Debug("fleet candidate", "mix", c.Mix, "buses", c.Buses, "avg_wait", c.AvgWaitMin, "p90", c.WaitP90Min, "cost", c.Cost, "feasible", c.Feasible)
+	return c, nil
+}
+
+// PrintOptimizeReport prints the candidates (cheapest first) and the chosen fleet.
+func PrintOptimizeReport(res OptimizeResult) {
+	fmt.Printf("=== Fleet Sizing (target %s wait <= %.2f min) ===\n", res.Metric, res.TargetMin)
+	sorted := append([]FleetCandidate(nil), res.Candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Feasible != sorted[j].Feasible {
+			return sorted[i].Feasible
+		}
+		return sorted[i].Cost < sorted[j].Cost
+	})
+	fmt.Printf("  %-20s %5s %9s %9s %10s %12s %s\n", "mix", "buses", "avg_wait", "p90_wait", "dist_km", "cost", "")
+	for _, c := range sorted {
+		mark := ""
+		if c.Feasible {
+			mark = "ok"
+		}
+		if c.Stalled > 0 {
+			mark = "stalled"
+		}
+		fmt.Printf("  %-20s %5d %9.2f %9.2f %10.1f %12.2f %s\n", c.Mix, c.Buses, c.AvgWaitMin, c.WaitP90Min, c.DistanceKM, c.Cost, mark)
+	}
+	if res.Best == nil {
+		fmt.Println("No candidate meets the target; raise the bus limit or relax the target.")
+		return
+	}
+	fmt.Printf("Cheapest feasible fleet: %d buses (%s), cost %.2f, avg wait %.2f min, P90 wait %.2f min\n", res.Best.Buses, res.Best.Mix, res.Best.Cost, res.Best.AvgWaitMin, res.Best.WaitP90Min)
+}
+
+// WriteOptimizeCSV writes one row per candidate (best marked) to a file or directory.
+func WriteOptimizeCSV(path string, res OptimizeResult) (string, error) {
+	ts := time.Now().Format("20060102-150405")
+	outPath := sim.TimestampedPath(path, "fleet-sizing", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"mix", "buses", "avg_wait_min", "wait_p90_min", "served", "distance_km", "cost", "stalled_runs", "feasible", "best", "target_metric", "target_wait_min"})
+	num := func(x float64) string { return strconv.FormatFloat(x, 'f', 3, 64) }
+	for i := range res.Candidates {
+		c := &res.Candidates[i]
+		w.Write([]string{c.Mix, fmt.Sprint(c.Buses), num(c.AvgWaitMin), num(c.WaitP90Min), num(c.Served), num(c.DistanceKM), num(c.Cost), fmt.Sprint(c.Stalled), strconv.FormatBool(c.Feasible), strconv.FormatBool(c == res.Best), res.Metric, num(res.TargetMin)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("fleet sizing written", "path", outPath, "candidates", len(res.Candidates))
+	return outPath, nil
+}
@@ -0,0 +1,169 @@
+package driver
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+// MetricCI is the across-replication distribution of one metric.
+type MetricCI struct {
+	Name   string
+	Mean   float64
+	StdDev float64 // sample standard deviation
+	CILow  float64 // 95% confidence interval of the mean (Student t)
+	CIHigh float64
+}
+
+// ReplicationSummary aggregates repeated runs of one scenario with different seeds.
+type ReplicationSummary struct {
+	Runs    []SweepResult // one per replication, Params["seed"] holds its seed
+	Metrics []MetricCI    // avg_wait_min, wait_p90_min, served, total_distance_km, total_cost, quality_score
+}
+
+// Replicate runs the scenario n times with seeds base.Seed, base.Seed+1, ... (a random
+// base when base.Seed is 0) on a pool of workers and summarizes wait time, served,
+// distance and cost. Failed runs are kept in Runs but left out of the statistics.
+func Replicate(ctx context.Context, newRoute func() (*model.Route, error), fleet []*model.Bus, base Options, n, workers int) (ReplicationSummary, error) {
+	if n < 1 {
+		return ReplicationSummary{}, fmt.Errorf("replications must be >= 1")
+	}
+	seed := base.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano() % (1 << 40)
+	}
+	seeds := make([]float64, n)
+	for i := range seeds {
+		seeds[i] = float64(seed + int64(i))
+	}
+	results, err := Sweep(ctx, SweepOptions{Base: base, Axes: []SweepAxis{{Name: "seed", Values: seeds}}, NewRoute: newRoute, Fleet: fleet, Parallel: workers, Progress: func(done, total int, r SweepResult) {
+		slog.Info("replication finished", "done", done, "total", total, "seed", int64(r.Params["seed"]), "err", r.Err)
+	}})
+	rs := ReplicationSummary{Runs: results}
+	metrics := []struct {
+		name string
+		get  func(Summary) float64
+	}{
+		{"avg_wait_min", func(s Summary) float64 { return s.AvgWaitMin }},
+		{"wait_p90_min", func(s Summary) float64 { return s.Wait.Overall.P90 }},
+		{"served", func(s Summary) float64 { return float64(s.Served) }},
+		{"total_distance_km", func(s Summary) float64 { return s.TotalDistance }},
+		{"total_cost", func(s Summary) float64 { return s.TotalCost }},
+		{"quality_score", func(s Summary) float64 { return s.Quality.Score }},
+	}
+	for _, m := range metrics {
+		var xs []float64
+		for _, r := range results {
+			if r.Err == "" {
+				xs = append(xs, m.get(r.Sum))
+			}
+		}
+		rs.Metrics = append(rs.Metrics, meanCI(m.name, xs))
+	}
+	return rs, err
+}
+
+// meanCI computes mean, sample standard deviation and the 95% t interval of the mean.
+func meanCI(name string, xs []float64) MetricCI {
+	c := MetricCI{Name: name}
+	n := len(xs)
+	if n == 0 {
+		return c
+	}
+	for _, x := range xs {
+		c.Mean += x
+	}
+	c.Mean /= float64(n)
+	c.CILow, c.CIHigh = c.Mean, c.Mean
+	if n < 2 {
+		return c
+	}
+	ss := 0.0
+	for _, x := range xs {
+		ss += (x - c.Mean) * (x - c.Mean)
+	}
+	c.StdDev = math.Sqrt(ss / float64(n-1))
+	half := tQuantile975(n-1) * c.StdDev / math.Sqrt(float64(n))
+	c.CILow, c.CIHigh = c.Mean-half, c.Mean+half
+	return c
+}
+
+// tQuantile975 returns the two-sided 95% critical value of Student's t for df degrees
+// of freedom (table up to 30, normal approximation beyond).
+func tQuantile975(df int) float64 {
+	table := []float64{12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228, 2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086, 2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042}
+	if df >= 1 && df <= len(table) {
+		return table[df-1]
+	}
+	return 1.96
+}
+
+// PrintReplicationReport prints per-seed results and the confidence intervals.
+func PrintReplicationReport(rs ReplicationSummary) {
+	fmt.Printf("=== Replication Report (%d runs) ===\n", len(rs.Runs))
+	for _, r := range rs.Runs {
+		s := r.Sum
+		note := ""
+		if r.Err != "" {
+			note = "  error: " + r.Err
+		} else if s.Stalled {
+			note = "  stalled"
+		}
+		fmt.Printf("  seed %-14d served=%-6d avg_wait=%.2f min P90=%.2f min distance=%.1f km cost=%.2f%s\n", int64(r.Params["seed"]), s.Served, s.AvgWaitMin, s.Wait.Overall.P90, s.TotalDistance, s.TotalCost, note)
+	}
+	fmt.Println("Mean ± sd [95% CI]:")
+	for _, m := range rs.Metrics {
+		fmt.Printf("  %-18s %.3f ± %.3f [%.3f, %.3f]\n", m.Name, m.Mean, m.StdDev, m.CILow, m.CIHigh)
+	}
+}
+
+// WriteReplicationCSV writes one "run" row per replication and one "stat" row per
+// metric to a file or directory, timestamped like the other reports.
+func WriteReplicationCSV(path string, rs ReplicationSummary) (string, error) {
+	ts := time.Now().Format("20060102-150405")
+	outPath := sim.TimestampedPath(path, "replications", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"section", "seed", "metric", "value", "mean", "std_dev", "ci95_low", "ci95_high", "n", "stalled", "error"})
+	num := func(x float64) string { return strconv.FormatFloat(x, 'f', 4, 64) }
+	for _, r := range rs.Runs {
+		s := r.Sum
+		seed := strconv.FormatInt(int64(r.Params["seed"]), 10)
+		for _, mv := range []struct {
+			name string
+			v    float64
+		}{{"avg_wait_min", s.AvgWaitMin}, {"wait_p90_min", s.Wait.Overall.P90}, {"served", float64(s.Served)}, {"total_distance_km", s.TotalDistance}, {"total_cost", s.TotalCost}, {"quality_score", s.Quality.Score}} {
+			w.Write([]string{"run", seed, mv.name, num(mv.v), "", "", "", "", "", strconv.FormatBool(s.Stalled), r.Err})
+		}
+	}
+	ok := 0
+	for _, r := range rs.Runs {
+		if r.Err == "" {
+			ok++
+		}
+	}
+	for _, m := range rs.Metrics {
+		w.Write([]string{"stat", "", m.Name, "", num(m.Mean), num(m.StdDev), num(m.CILow), num(m.CIHigh), fmt.Sprint(ok), "", ""})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("replication summary written", "path", outPath, "runs", len(rs.Runs))
+	return outPath, nil
+}
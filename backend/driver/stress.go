@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// DefaultStressPassengers is the passenger cap of a stress run given no stop criterion.
+const DefaultStressPassengers = 1_000_000
+
+// stressSampleEvery is how often a stress run samples the heap.
+const stressSampleEvery = 100 * time.Millisecond
+
+// StressReport is the memory profile of a stress run.
+type StressReport struct {
+	Generated        int           `json:"generated"`
+	Served           int64         `json:"served"`
+	Elapsed          time.Duration `json:"elapsed"`
+	BaseHeapMB       float64       `json:"base_heap_mb"`        // live heap before the run
+	PeakHeapMB       float64       `json:"peak_heap_mb"`        // largest in-use heap sampled
+	FirstHalfPeakMB  float64       `json:"first_half_peak_mb"`  // ... in the first half of the run (wall time)
+	SecondHalfPeakMB float64       `json:"second_half_peak_mb"` // ... in the second half
+	TotalAllocMB     float64       `json:"total_alloc_mb"`      // allocated over the run, freed or not
+	NumGC            uint32        `json:"num_gc"`
+	Bounded          bool          `json:"bounded"` // the second half's peak stayed within 25% (+8 MB) of the first's
+}
+
+// RunStress runs the batch driver headless while sampling the heap, to check that
+// memory stays bounded however many passengers the run moves: alighted passengers
+// are recycled and wait statistics fold into fixed bins. Without a stop criterion the
+// run gets DefaultStressPassengers. A journey log or export keeps every passenger
+// and so defeats the check.
+func RunStress(ctx context.Context, route *model.Route, fleet []*model.Bus, opt Options) (StressReport, Summary, error) {
+	if opt.PassengerCap <= 0 && !opt.Criterion.Active() && !opt.Day.Enabled() {
+		opt.PassengerCap = DefaultStressPassengers
+	}
+	if opt.PassengerLogPath != "" || opt.ExportFormat != "" {
+		return StressReport{}, Summary{}, fmt.Errorf("stress run keeps no passengers: drop -passenger_log and -export")
+	}
+	var ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	rep := StressReport{BaseHeapMB: mb(ms.HeapInuse)}
+	allocs, gcs := ms.TotalAlloc, ms.NumGC
+
+	type sample struct {
+		at   time.Duration
+		heap uint64
+	}
+	var samples []sample
+	began := time.Now()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tick := time.NewTicker(stressSampleEvery)
+		defer tick.Stop()
+		var ms runtime.MemStats
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				runtime.ReadMemStats(&ms)
+				samples = append(samples, sample{time.Since(began), ms.HeapInuse})
+			}
+		}
+	}()
+	sum, err := Run(ctx, route, fleet, opt)
+	close(done)
+	wg.Wait()
+	rep.Elapsed = time.Since(began)
+	runtime.ReadMemStats(&ms)
+	samples = append(samples, sample{rep.Elapsed, ms.HeapInuse})
+
+	rep.Generated, rep.Served = sum.Generated, sum.Served
+	rep.TotalAllocMB = mb(ms.TotalAlloc - allocs)
+	rep.NumGC = ms.NumGC - gcs
+	var first, second uint64
+	for _, s := range samples {
+		if s.at < rep.Elapsed/2 {
+			first = max(first, s.heap)
+		} else {
+			second = max(second, s.heap)
+		}
+	}
+	rep.PeakHeapMB = mb(max(first, second))
+	rep.FirstHalfPeakMB, rep.SecondHalfPeakMB = mb(first), mb(second)
+	rep.Bounded = first == 0 || rep.SecondHalfPeakMB <= rep.FirstHalfPeakMB*1.25+8
+	return rep, sum, err
+}
+
+// PrintStressReport writes the memory profile of a stress run to stdout.
+func PrintStressReport(r StressReport) {
+	verdict := "bounded"
+	if !r.Bounded {
+		verdict = "GROWING"
+	}
+	fmt.Printf("Stress run: %d passengers generated, %d served in %s (%.0f passengers/s)\n", r.Generated, r.Served, r.Elapsed.Round(time.Millisecond), float64(r.Generated)/r.Elapsed.Seconds())
+	fmt.Printf("  heap in use: base %.1f MB, peak %.1f MB (first half %.1f MB, second half %.1f MB): %s\n", r.BaseHeapMB, r.PeakHeapMB, r.FirstHalfPeakMB, r.SecondHalfPeakMB, verdict)
+	fmt.Printf("  allocated %.1f MB over %d GC cycles\n", r.TotalAllocMB, r.NumGC)
+}
+
+func mb(b uint64) float64 { return float64(b) / (1 << 20) }
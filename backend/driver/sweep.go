@@ -0,0 +1,307 @@
+package driver
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+// SweepAxis is one swept parameter and the values it takes.
+type SweepAxis struct {
+	Name   string
+	Values []float64
+}
+
+// sweepParams lists the parameters a sweep may vary.
+var sweepParams = []string{"fleet", "lambda", "arrival_factor", "dir_bias", "spatial_gradient", "baseline_demand", "period", "passenger_cap", "population", "seed"}
+
+// ParseSweep parses a sweep spec such as "fleet=4:10:2;arrival_factor=0.5,1,1.5".
+// Axes are separated by ';'; each takes a lo:hi:step range (inclusive) or a comma list.
+func ParseSweep(spec string) ([]SweepAxis, error) {
+	var axes []SweepAxis
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, vals, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || vals == "" {
+			return nil, fmt.Errorf("sweep axis %q: want name=lo:hi:step or name=v1,v2", part)
+		}
+		known := false
+		for _, p := range sweepParams {
+			known = known || p == name
+		}
+		if !known {
+			return nil, fmt.Errorf("sweep axis %q: unknown parameter (want one of %s)", name, strings.Join(sweepParams, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("sweep axis %q given twice", name)
+		}
+		seen[name] = true
+		ax := SweepAxis{Name: name}
+		if r := strings.Split(vals, ":"); len(r) == 3 {
+			lo, err1 := strconv.ParseFloat(strings.TrimSpace(r[0]), 64)
+			hi, err2 := strconv.ParseFloat(strings.TrimSpace(r[1]), 64)
+			step, err3 := strconv.ParseFloat(strings.TrimSpace(r[2]), 64)
+			if err1 != nil || err2 != nil || err3 != nil || step <= 0 || hi < lo {
+				return nil, fmt.Errorf("sweep axis %q: invalid range %q", name, vals)
+			}
+			// step count rounded so float accumulation does not drop the upper bound
+			n := int(math.Floor((hi-lo)/step+1e-9)) + 1
+			for i := 0; i < n; i++ {
+				ax.Values = append(ax.Values, math.Round((lo+float64(i)*step)*1e9)/1e9)
+			}
+		} else {
+			for _, v := range strings.Split(vals, ",") {
+				x, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil {
+					return nil, fmt.Errorf("sweep axis %q: invalid value %q", name, v)
+				}
+				ax.Values = append(ax.Values, x)
+			}
+		}
+		axes = append(axes, ax)
+	}
+	if len(axes) == 0 {
+		return nil, fmt.Errorf("empty sweep spec")
+	}
+	return axes, nil
+}
+
+// SweepOptions configures a parameter sweep.
+type SweepOptions struct {
+	Base     Options                              // options shared by every run (output paths are ignored)
+	Axes     []SweepAxis                          // cartesian product of these values is run
+	NewRoute func() (*model.Route, error)         // fresh route per run (runs mutate stop queues); must be safe for concurrent use
+	Fleet    []*model.Bus                         // base fleet; the "fleet" axis resizes it keeping the type mix
+	Parallel int                                  // worker pool size (<= 1 = sequential)
+	Progress func(done, total int, r SweepResult) // optional, called as each run finishes (serialized)
+
+	autoSeed int64 // first per-run seed when neither Base.Seed nor a seed axis fixes one
+}
+
+// SweepResult is the summary of one sweep combination.
+type SweepResult struct {
+	Run    int
+	Params map[string]float64
+	Seed   int64 // seed the run used (recorded so unseeded runs can be reproduced)
+	Buses  int
+	Sum    Summary
+	Err    string
+}
+
+// Sweep runs driver.Run for every combination of the axes on a pool of opt.Parallel
+// workers and returns the results in combination order. Each run gets its own route
+// from NewRoute, its own copy of the fleet and its own RNGs, so runs share no mutable
+// state. With a fixed Base.Seed every combination sees the same random stream, so
+// differences come from the parameters alone; with Base.Seed 0 combination i runs with
+// seed s+i for one random s, which keeps concurrent runs distinct and reproducible.
+func Sweep(ctx context.Context, opt SweepOptions) ([]SweepResult, error) {
+	combos := [][]float64{{}}
+	for _, ax := range opt.Axes {
+		next := make([][]float64, 0, len(combos)*len(ax.Values))
+		for _, c := range combos {
+			for _, v := range ax.Values {
+				next = append(next, append(append([]float64(nil), c...), v))
+			}
+		}
+		combos = next
+	}
+	if opt.Base.Seed == 0 {
+		opt.autoSeed = time.Now().UnixNano() % (1 << 40)
+	}
+	results := make([]SweepResult, len(combos))
+	var mu sync.Mutex
+	done := 0
+	runPool(ctx, len(combos), opt.Parallel, func(i int) {
+		results[i] = runSweepCombo(ctx, opt, i, combos[i])
+		if opt.Progress != nil {
+			mu.Lock()
+			done++
+			opt.Progress(done, len(combos), results[i])
+			mu.Unlock()
+		}
+	})
+	// combinations never started after cancellation are dropped
+	ran := results[:0]
+	for _, r := range results {
+		if r.Run != 0 {
+			ran = append(ran, r)
+		}
+	}
+	return ran, ctx.Err()
+}
+
+// runPool calls fn(0..n-1) on up to workers goroutines and returns when all started
+// calls finish. Indexes not yet handed out when ctx is cancelled are skipped.
+func runPool(ctx context.Context, n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func runSweepCombo(ctx context.Context, sopt SweepOptions, idx int, values []float64) SweepResult {
+	res := SweepResult{Run: idx + 1, Params: make(map[string]float64, len(values))}
+	o := sopt.Base.withoutOutputs()
+	o.OnEvent = nil
+	o.Quiet = true
+	fleet := sopt.Fleet
+	for k, ax := range sopt.Axes {
+		v := values[k]
+		res.Params[ax.Name] = v
+		switch ax.Name {
+		case "fleet":
+			fleet = ResizeFleet(sopt.Fleet, int(v))
+		case "lambda":
+			o.Lambda = v
+		case "arrival_factor":
+			o.ArrivalFactor = v
+		case "dir_bias":
+			o.DirBias = v
+		case "spatial_gradient":
+			o.SpatialGradient = v
+		case "baseline_demand":
+			o.BaselineDemand = v
+		case "period":
+			o.PeriodID = int(v)
+		case "passenger_cap":
+			o.PassengerCap = int(v)
+		case "population":
+			o.Population = int(v)
+		case "seed":
+			o.Seed = int64(v)
+		}
+	}
+	if o.Seed == 0 {
+		o.Seed = sopt.autoSeed + int64(idx)
+	}
+	res.Seed = o.Seed
+	res.Buses = len(fleet)
+	route, err := sopt.NewRoute()
+	if err != nil {
+		res.Err = err.Error()
+		return res
+	}
+	sum, err := Run(ctx, route, fleet, o)
+	res.Sum = sum
+	if err != nil {
+		res.Err = err.Error()
+	}
+	return res
+}
+
+// ResizeFleet returns n buses cycling through base in order, so the type mix and the
+// per-bus speeds and start directions of base carry over. IDs are renumbered from 1.
+func ResizeFleet(base []*model.Bus, n int) []*model.Bus {
+	if len(base) == 0 || n <= 0 {
+		return nil
+	}
+	out := make([]*model.Bus, n)
+	for i := range out {
+		b := base[i%len(base)]
+		out[i] = &model.Bus{ID: i + 1, Type: b.Type, RouteID: b.RouteID, CurrentStopID: b.CurrentStopID, Direction: b.Direction, AverageSpeedKmph: b.AverageSpeedKmph}
+	}
+	return out
+}
+
+// WriteSweepCSV writes one row per sweep run (swept values first, then the summary) to a
+// file or directory, timestamped like the other reports.
+func WriteSweepCSV(path string, axes []SweepAxis, results []SweepResult) (string, error) {
+	ts := time.Now().Format("20060102-150405")
+	outPath := sim.TimestampedPath(path, "sweep", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	header := []string{"run"}
+	for _, ax := range axes {
+		header = append(header, ax.Name)
+	}
+	header = append(header, "seed", "buses", "generated", "served", "avg_wait_min", "wait_p50_min", "wait_p90_min", "total_distance_km", "total_cost", "quality_score", "capacity_utilization", "stalled", "aborted", "error")
+	w.Write(header)
+	pr := sim.ReportPrecision
+	num := func(x float64) string { return strconv.FormatFloat(x, 'f', -1, 64) }
+	for _, r := range results {
+		row := []string{fmt.Sprint(r.Run)}
+		for _, ax := range axes {
+			row = append(row, num(r.Params[ax.Name]))
+		}
+		s := r.Sum
+		row = append(row, fmt.Sprint(r.Seed), fmt.Sprint(r.Buses), fmt.Sprint(s.Generated), fmt.Sprint(s.Served), pr.FormatMinutes(s.AvgWaitMin, true), pr.FormatMinutes(s.Wait.Overall.P50, true), pr.FormatMinutes(s.Wait.Overall.P90, true), pr.FormatKM(s.TotalDistance, true), pr.FormatCurrency(s.TotalCost, true), fmt.Sprintf("%.1f", s.Quality.Score), fmt.Sprintf("%.3f", s.Capacity.Utilization), strconv.FormatBool(s.Stalled), s.Aborted, r.Err)
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("sweep summary written", "path", outPath, "runs", len(results))
+	return outPath, nil
+}
+
+// PrintSweepTable prints the sweep results as an aligned console table.
+func PrintSweepTable(axes []SweepAxis, results []SweepResult) {
+	names := make([]string, len(axes))
+	for i, ax := range axes {
+		names[i] = ax.Name
+	}
+	fmt.Println("=== Sweep Summary ===")
+	fmt.Printf("%-4s", "run")
+	for _, n := range names {
+		fmt.Printf(" %16s", n)
+	}
+	fmt.Printf(" %5s %7s %7s %9s %10s %10s %7s\n", "buses", "gen", "served", "avg_wait", "dist_km", "cost", "quality")
+	for _, r := range results {
+		fmt.Printf("%-4d", r.Run)
+		for _, n := range names {
+			fmt.Printf(" %16s", strconv.FormatFloat(r.Params[n], 'f', -1, 64))
+		}
+		s := r.Sum
+		note := ""
+		if r.Err != "" {
+			note = "  error: " + r.Err
+		} else if s.Stalled {
+			note = "  stalled"
+		}
+		fmt.Printf(" %5d %7d %7d %9.2f %10.1f %10.2f %7.1f%s\n", r.Buses, s.Generated, s.Served, s.AvgWaitMin, s.TotalDistance, s.TotalCost, s.Quality.Score, note)
+	}
+}
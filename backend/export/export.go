@@ -0,0 +1,119 @@
+// Package export converts the corridor, its stops and generated passenger demand
+// into input files for established simulators (MATSim, SUMO) for cross-validation.
+package export
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+)
+
+// projector maps WGS84 coordinates to a local metric plane (equirectangular around the
+// first stop). Accurate to well under 1% over a 20 km corridor, which is enough for
+// network lengths; both simulators only need consistent planar coordinates.
+type projector struct {
+	lat0, lng0, cosLat float64
+}
+
+func newProjector(route *model.Route) projector {
+	if len(route.Stops) == 0 {
+		return projector{cosLat: 1}
+	}
+	s := route.Stops[0]
+	return projector{lat0: s.Latitude, lng0: s.Longitude, cosLat: math.Cos(s.Latitude * math.Pi / 180)}
+}
+
+func (p projector) xy(lat, lng float64) (x, y float64) {
+	const mPerDeg = 111320.0
+	return (lng - p.lng0) * mPerDeg * p.cosLat, (lat - p.lat0) * mPerDeg
+}
+
+// secondsOfDay returns t as seconds after local midnight, the time base both simulators use.
+func secondsOfDay(t time.Time) float64 {
+	y, m, d := t.Date()
+	return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location())).Seconds()
+}
+
+// hms formats seconds of day as HH:MM:SS (MATSim time format).
+func hms(sec float64) string {
+	s := int(math.Round(sec))
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, (s/60)%60, s%60)
+}
+
+// dirStops returns the stops in travel order for a direction ("outbound" follows route order).
+func dirStops(route *model.Route, dir string) []*model.BusStop {
+	if dir != "inbound" {
+		return route.Stops
+	}
+	out := make([]*model.BusStop, len(route.Stops))
+	for i, s := range route.Stops {
+		out[len(out)-1-i] = s
+	}
+	return out
+}
+
+// dirStopID identifies a stop platform in one direction ("<id>_out" / "<id>_in").
+func dirStopID(stopID int, dir string) string {
+	if dir == "inbound" {
+		return fmt.Sprintf("%d_in", stopID)
+	}
+	return fmt.Sprintf("%d_out", stopID)
+}
+
+// lengthM returns the length in meters from one stop to an adjacent one in that
+// direction of travel, preferring the route distance.
+func lengthM(route *model.Route, fromID, toID int) float64 {
+	for i := 0; i+1 < len(route.Stops); i++ {
+		a, b := route.Stops[i], route.Stops[i+1]
+		if (a.ID == fromID && b.ID == toID) || (a.ID == toID && b.ID == fromID) {
+			from, to := i, i+1
+			if a.ID == toID {
+				from, to = i+1, i
+			}
+			if km := route.SegmentKM(from, to); km > 0 {
+				return km * 1000
+			}
+			return model.HaversineKM(model.LatLng{Lat: a.Latitude, Lng: a.Longitude}, model.LatLng{Lat: b.Latitude, Lng: b.Longitude}) * 1000
+		}
+	}
+	return 0
+}
+
+func esc(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeFile(dir, name string, data []byte) (string, error) {
+	p := filepath.Join(dir, name)
+	if err := atomicfile.WriteFile(p, data); err != nil {
+		return "", err
+	}
+	return p, nil
+}
+
+// Write exports the given format ("matsim" or "sumo") into dir and returns the written paths.
+// Passengers become one plan/person each, departing at their stop arrival time.
+// Pin geometry is simplified with Douglas-Peucker at toleranceM meters where the
+// format carries shapes (0 keeps every pin).
+func Write(format, dir string, route *model.Route, passengers []*model.Passenger, toleranceM float64) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	switch format {
+	case "matsim":
+		return writeMATSim(dir, route, passengers)
+	case "sumo":
+		return writeSUMO(dir, route, passengers, toleranceM)
+	default:
+		return nil, fmt.Errorf("export: unknown format %q (want matsim or sumo)", format)
+	}
+}
@@ -0,0 +1,128 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+
+	"brt08/backend/model"
+)
+
+const (
+	matsimFreespeed = 50 / 3.6 // m/s, corridor free-flow speed
+	matsimCapacity  = 2000.0   // veh/h per link
+	matsimLoopLenM  = 1.0      // terminal loop links so first stops have a link of their own
+)
+
+// matsimFacilityLink returns the link a direction's stop facility sits on: the link
+// arriving at the stop, or the terminal loop link for the first stop of the direction.
+func matsimFacilityLink(seq []*model.BusStop, k int) string {
+	if k == 0 {
+		return fmt.Sprintf("%d_%d", seq[0].ID, seq[0].ID)
+	}
+	return fmt.Sprintf("%d_%d", seq[k-1].ID, seq[k].ID)
+}
+
+// writeMATSim writes network.xml, transitSchedule.xml and plans.xml. The schedule lists
+// stops and routes without departures since buses are dispatched dynamically; add a
+// timetable (or headway-based departures) before running it in MATSim.
+func writeMATSim(dir string, route *model.Route, passengers []*model.Passenger) ([]string, error) {
+	proj := newProjector(route)
+	dirs := []string{"outbound", "inbound"}
+	facilityLink := make(map[string]string) // facility id -> link id
+
+	var net bytes.Buffer
+	net.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	net.WriteString("<!DOCTYPE network SYSTEM \"http://www.matsim.org/files/dtd/network_v2.dtd\">\n")
+	fmt.Fprintf(&net, "<network name=\"%s\">\n  <nodes>\n", esc(route.Name))
+	for _, s := range route.Stops {
+		x, y := proj.xy(s.Latitude, s.Longitude)
+		fmt.Fprintf(&net, "    <node id=\"%d\" x=\"%.2f\" y=\"%.2f\"/>\n", s.ID, x, y)
+	}
+	net.WriteString("  </nodes>\n  <links capperiod=\"01:00:00\">\n")
+	writeLink := func(id string, from, to int, length float64) {
+		fmt.Fprintf(&net, "    <link id=\"%s\" from=\"%d\" to=\"%d\" length=\"%.2f\" freespeed=\"%.2f\" capacity=\"%.0f\" permlanes=\"1\" oneway=\"1\" modes=\"pt,bus\"/>\n",
+			id, from, to, length, matsimFreespeed, matsimCapacity)
+	}
+	for _, d := range dirs {
+		seq := dirStops(route, d)
+		if len(seq) == 0 {
+			continue
+		}
+		writeLink(matsimFacilityLink(seq, 0), seq[0].ID, seq[0].ID, matsimLoopLenM)
+		for k := 1; k < len(seq); k++ {
+			writeLink(matsimFacilityLink(seq, k), seq[k-1].ID, seq[k].ID, lengthM(route, seq[k-1].ID, seq[k].ID))
+		}
+		for k, s := range seq {
+			facilityLink[dirStopID(s.ID, d)] = matsimFacilityLink(seq, k)
+		}
+	}
+	net.WriteString("  </links>\n</network>\n")
+
+	var ts bytes.Buffer
+	ts.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	ts.WriteString("<!DOCTYPE transitSchedule SYSTEM \"http://www.matsim.org/files/dtd/transitSchedule_v2.dtd\">\n")
+	ts.WriteString("<transitSchedule>\n  <transitStops>\n")
+	for _, d := range dirs {
+		for _, s := range dirStops(route, d) {
+			id := dirStopID(s.ID, d)
+			x, y := proj.xy(s.Latitude, s.Longitude)
+			fmt.Fprintf(&ts, "    <stopFacility id=\"%s\" x=\"%.2f\" y=\"%.2f\" linkRefId=\"%s\" name=\"%s\" isBlocking=\"false\"/>\n",
+				id, x, y, facilityLink[id], esc(s.Name))
+		}
+	}
+	fmt.Fprintf(&ts, "  </transitStops>\n  <transitLine id=\"%d\" name=\"%s\">\n", route.ID, esc(route.Name))
+	for _, d := range dirs {
+		seq := dirStops(route, d)
+		if len(seq) == 0 {
+			continue
+		}
+		fmt.Fprintf(&ts, "    <transitRoute id=\"%s\">\n      <transportMode>bus</transportMode>\n      <routeProfile>\n", d)
+		for _, s := range seq {
+			fmt.Fprintf(&ts, "        <stop refId=\"%s\" awaitDeparture=\"true\"/>\n", dirStopID(s.ID, d))
+		}
+		ts.WriteString("      </routeProfile>\n      <route>\n")
+		for k := range seq {
+			fmt.Fprintf(&ts, "        <link refId=\"%s\"/>\n", matsimFacilityLink(seq, k))
+		}
+		ts.WriteString("      </route>\n      <departures>\n      </departures>\n    </transitRoute>\n")
+	}
+	ts.WriteString("  </transitLine>\n</transitSchedule>\n")
+
+	var pl bytes.Buffer
+	pl.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	pl.WriteString("<!DOCTYPE population SYSTEM \"http://www.matsim.org/files/dtd/population_v6.dtd\">\n")
+	pl.WriteString("<population>\n")
+	stopByID := make(map[int]*model.BusStop, len(route.Stops))
+	for _, s := range route.Stops {
+		stopByID[s.ID] = s
+	}
+	for _, p := range passengers {
+		from, to := stopByID[p.StartStopID], stopByID[p.EndStopID]
+		if from == nil || to == nil {
+			continue
+		}
+		fx, fy := proj.xy(from.Latitude, from.Longitude)
+		tx, ty := proj.xy(to.Latitude, to.Longitude)
+		fmt.Fprintf(&pl, "  <person id=\"%d\">\n    <plan selected=\"yes\">\n", p.ID)
+		fmt.Fprintf(&pl, "      <activity type=\"origin\" link=\"%s\" x=\"%.2f\" y=\"%.2f\" end_time=\"%s\"/>\n",
+			facilityLink[dirStopID(from.ID, p.Direction)], fx, fy, hms(secondsOfDay(p.ArrivalStopTime)))
+		pl.WriteString("      <leg mode=\"pt\"/>\n")
+		fmt.Fprintf(&pl, "      <activity type=\"destination\" link=\"%s\" x=\"%.2f\" y=\"%.2f\"/>\n",
+			facilityLink[dirStopID(to.ID, p.Direction)], tx, ty)
+		pl.WriteString("    </plan>\n  </person>\n")
+	}
+	pl.WriteString("</population>\n")
+
+	var out []string
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"network.xml", net.Bytes()}, {"transitSchedule.xml", ts.Bytes()}, {"plans.xml", pl.Bytes()}} {
+		p, err := writeFile(dir, f.name, f.data)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
@@ -0,0 +1,126 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"brt08/backend/model"
+)
+
+const (
+	sumoSpeed      = 50 / 3.6 // m/s lane speed limit
+	sumoStopLenM   = 20.0     // platform length along the lane
+	sumoEdgeLaneNo = "_0"     // single-lane edges; lane ids are <edge>_0
+)
+
+// sumoStop places a direction's stop on the edge arriving at it, or at the start of the
+// departing edge for the first stop of the direction. Returns edge id and [start,end] positions.
+func sumoStop(route *model.Route, seq []*model.BusStop, k int) (edge string, start, end float64) {
+	if len(seq) < 2 {
+		return "", 0, 0
+	}
+	if k == 0 {
+		l := lengthM(route, seq[0].ID, seq[1].ID)
+		return fmt.Sprintf("%d_%d", seq[0].ID, seq[1].ID), 0, math.Min(sumoStopLenM, l)
+	}
+	l := lengthM(route, seq[k-1].ID, seq[k].ID)
+	return fmt.Sprintf("%d_%d", seq[k-1].ID, seq[k].ID), math.Max(0, l-sumoStopLenM), l
+}
+
+// writeSUMO writes plain node/edge files for netconvert, an additional file with bus
+// stops and a person file riding line <route id> between stops:
+//
+//	netconvert -n corridor.nod.xml -e corridor.edg.xml -o corridor.net.xml
+//
+// Bus vehicles/flows serving the stops are left to the user's scenario.
+func writeSUMO(dir string, route *model.Route, passengers []*model.Passenger, toleranceM float64) ([]string, error) {
+	proj := newProjector(route)
+	dirs := []string{"outbound", "inbound"}
+
+	var nod bytes.Buffer
+	nod.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<nodes>\n")
+	for _, s := range route.Stops {
+		x, y := proj.xy(s.Latitude, s.Longitude)
+		fmt.Fprintf(&nod, "    <node id=\"%d\" x=\"%.2f\" y=\"%.2f\"/>\n", s.ID, x, y)
+	}
+	nod.WriteString("</nodes>\n")
+
+	var edg bytes.Buffer
+	edg.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<edges>\n")
+	geom := route.Geometry()
+	for _, d := range dirs {
+		seq := dirStops(route, d)
+		for k := 1; k < len(seq); k++ {
+			// Edges through pins follow the road; straight edges need no shape
+			shape := ""
+			if p := geom.Path(seq[k-1].ID, seq[k].ID); p != nil {
+				if pts := model.SimplifyPath(p.Points, toleranceM); len(pts) > 2 {
+					xy := make([]string, len(pts))
+					for i, pt := range pts {
+						x, y := proj.xy(pt.Lat, pt.Lng)
+						xy[i] = fmt.Sprintf("%.2f,%.2f", x, y)
+					}
+					shape = fmt.Sprintf(" shape=\"%s\"", strings.Join(xy, " "))
+				}
+			}
+			fmt.Fprintf(&edg, "    <edge id=\"%d_%d\" from=\"%d\" to=\"%d\" numLanes=\"1\" speed=\"%.2f\" length=\"%.2f\" allow=\"bus\"%s/>\n",
+				seq[k-1].ID, seq[k].ID, seq[k-1].ID, seq[k].ID, sumoSpeed, lengthM(route, seq[k-1].ID, seq[k].ID), shape)
+		}
+	}
+	edg.WriteString("</edges>\n")
+
+	type placed struct {
+		edge string
+		pos  float64
+	}
+	stopAt := make(map[string]placed) // bus stop id -> edge and boarding position
+	var add bytes.Buffer
+	add.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<additional>\n")
+	for _, d := range dirs {
+		seq := dirStops(route, d)
+		for k, s := range seq {
+			edge, start, end := sumoStop(route, seq, k)
+			if edge == "" {
+				continue
+			}
+			id := dirStopID(s.ID, d)
+			stopAt[id] = placed{edge: edge, pos: (start + end) / 2}
+			fmt.Fprintf(&add, "    <busStop id=\"%s\" name=\"%s\" lane=\"%s%s\" startPos=\"%.2f\" endPos=\"%.2f\" lines=\"%d\" friendlyPos=\"true\"/>\n",
+				id, esc(s.Name), edge, sumoEdgeLaneNo, start, end, route.ID)
+		}
+	}
+	add.WriteString("</additional>\n")
+
+	// SUMO requires route files sorted by departure time
+	sorted := append([]*model.Passenger(nil), passengers...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ArrivalStopTime.Before(sorted[j].ArrivalStopTime) })
+	var per bytes.Buffer
+	per.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<routes>\n")
+	for _, p := range sorted {
+		from, okF := stopAt[dirStopID(p.StartStopID, p.Direction)]
+		_, okT := stopAt[dirStopID(p.EndStopID, p.Direction)]
+		if !okF || !okT {
+			continue
+		}
+		fmt.Fprintf(&per, "    <person id=\"%d\" depart=\"%.2f\" departPos=\"%.2f\">\n", p.ID, secondsOfDay(p.ArrivalStopTime), from.pos)
+		fmt.Fprintf(&per, "        <ride from=\"%s\" busStop=\"%s\" lines=\"%d\"/>\n", from.edge, dirStopID(p.EndStopID, p.Direction), route.ID)
+		per.WriteString("    </person>\n")
+	}
+	per.WriteString("</routes>\n")
+
+	var out []string
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{"corridor.nod.xml", nod.Bytes()}, {"corridor.edg.xml", edg.Bytes()}, {"stops.add.xml", add.Bytes()}, {"persons.rou.xml", per.Bytes()}} {
+		p, err := writeFile(dir, f.name, f.data)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
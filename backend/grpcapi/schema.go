@@ -0,0 +1,133 @@
+// Package grpcapi holds the protobuf schema of the gRPC API (sim.proto). The
+// descriptors are built at runtime from the same definitions, so the server needs
+// neither protoc nor generated code; messages are dynamicpb messages.
+package grpcapi
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/structpb"    // registers google/protobuf/struct.proto
+	_ "google.golang.org/protobuf/types/known/timestamppb" // registers google/protobuf/timestamp.proto
+)
+
+// Service and method names of sim.proto.
+const (
+	Package      = "brt08.v1"
+	ServiceName  = Package + ".Simulation"
+	StartRun     = "StartRun"
+	StreamEvents = "StreamEvents"
+	Control      = "Control"
+)
+
+// File is the descriptor of sim.proto.
+var File protoreflect.FileDescriptor
+
+type field struct {
+	name  string
+	num   int32
+	typ   descriptorpb.FieldDescriptorProto_Type
+	msg   string // message type name for TYPE_MESSAGE (fully qualified)
+	oneof bool   // member of the message's only oneof
+}
+
+const (
+	tDouble = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	tInt32  = descriptorpb.FieldDescriptorProto_TYPE_INT32
+	tInt64  = descriptorpb.FieldDescriptorProto_TYPE_INT64
+	tUint64 = descriptorpb.FieldDescriptorProto_TYPE_UINT64
+	tBool   = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+	tString = descriptorpb.FieldDescriptorProto_TYPE_STRING
+	tMsg    = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+)
+
+const (
+	timestamp = ".google.protobuf.Timestamp"
+	structMsg = ".google.protobuf.Struct"
+)
+
+func local(name string) string { return "." + Package + "." + name }
+
+// messages mirrors the messages of sim.proto, in order.
+var messages = []struct {
+	name   string
+	oneof  string
+	fields []field
+}{
+	{name: "StartRunRequest", fields: []field{{"period", 1, tInt32, "", false}, {"passenger_cap", 2, tInt32, "", false}, {"seed", 3, tInt64, "", false}, {"sim_hours", 4, tDouble, "", false}, {"max_trips", 5, tInt32, "", false}, {"dir_bias", 6, tDouble, "", false}, {"spatial_gradient", 7, tDouble, "", false}, {"baseline_demand", 8, tDouble, "", false}, {"lambda", 9, tDouble, "", false}, {"speed", 10, tDouble, "", false}, {"max_speed", 11, tBool, "", false}, {"arrival_factor", 12, tDouble, "", false}, {"max_rate", 13, tDouble, "", false}, {"route", 14, tString, "", false}, {"start", 15, tString, "", false}}},
+	{name: "RunInfo", fields: []field{{"run_id", 1, tString, "", false}}},
+	{name: "StreamEventsRequest", fields: []field{{"run_id", 1, tString, "", false}}},
+	{name: "Event", oneof: "payload", fields: []field{{"run_id", 1, tString, "", false}, {"type", 2, tString, "", false}, {"seq", 3, tUint64, "", false}, {"emitted", 4, tMsg, timestamp, false},
+		{"move", 10, tMsg, local("MoveEvent"), true}, {"arrive", 11, tMsg, local("ArriveEvent"), true}, {"stop_update", 12, tMsg, local("StopUpdateEvent"), true}, {"metrics", 13, tMsg, local("MetricsEvent"), true}, {"done", 14, tMsg, local("DoneEvent"), true}, {"data", 15, tMsg, structMsg, true}}},
+	{name: "MoveEvent", fields: []field{{"bus_id", 1, tInt32, "", false}, {"direction", 2, tString, "", false}, {"lat", 3, tDouble, "", false}, {"lng", 4, tDouble, "", false}, {"t", 5, tDouble, "", false}, {"from", 6, tInt32, "", false}, {"to", 7, tInt32, "", false}, {"phase", 8, tString, "", false}}},
+	{name: "ArriveEvent", fields: []field{{"bus_id", 1, tInt32, "", false}, {"direction", 2, tString, "", false}, {"stop_id", 3, tInt32, "", false}, {"time", 4, tMsg, timestamp, false}, {"bus_onboard", 5, tInt32, "", false}, {"generated_passengers", 6, tInt64, "", false}}},
+	{name: "StopUpdateEvent", fields: []field{{"stop_id", 1, tInt32, "", false}, {"outbound_queue", 2, tInt32, "", false}, {"inbound_queue", 3, tInt32, "", false}, {"generated_passengers", 4, tInt64, "", false}}},
+	{name: "MetricsEvent", fields: []field{{"time", 1, tMsg, timestamp, false}, {"generated_passengers", 2, tInt64, "", false}, {"served_passengers", 3, tInt64, "", false}, {"avg_wait_min", 4, tDouble, "", false}, {"waiting", 5, tInt32, "", false}, {"onboard", 6, tInt32, "", false}, {"fleet_utilization", 7, tDouble, "", false}, {"queue_max_wait_min", 8, tDouble, "", false}, {"headway_adherence", 9, tDouble, "", false}, {"excess_wait_min", 10, tDouble, "", false}}},
+	{name: "DoneEvent", fields: []field{{"completed", 1, tBool, "", false}, {"ended_by", 2, tString, "", false}, {"aborted", 3, tString, "", false}, {"generated_passengers", 4, tInt64, "", false}, {"served_passengers", 5, tInt64, "", false}, {"avg_wait_min", 6, tDouble, "", false}, {"wait_p90_min", 7, tDouble, "", false}, {"quality_score", 8, tDouble, "", false}, {"summary", 9, tMsg, structMsg, false}}},
+	{name: "ControlRequest", fields: []field{{"run_id", 1, tString, "", false}, {"speed", 2, tDouble, "", false}, {"max_speed", 3, tBool, "", false}, {"arrival_factor", 4, tDouble, "", false}, {"action", 5, tString, "", false}, {"stop_id", 6, tInt32, "", false}, {"passengers", 7, tString, "", false}, {"bus_id", 8, tInt32, "", false}, {"bus_speed_kmph", 9, tDouble, "", false}, {"hold_s", 10, tDouble, "", false}, {"period", 11, tInt32, "", false}, {"dir_bias", 12, tDouble, "", false}, {"spatial_gradient", 13, tDouble, "", false}, {"count", 14, tInt32, "", false}, {"direction", 15, tString, "", false}, {"dest_stop_id", 16, tInt32, "", false}}},
+	{name: "ControlResponse", fields: []field{{"speed", 1, tDouble, "", false}, {"arrival_factor", 2, tDouble, "", false}}},
+}
+
+func init() {
+	str := func(s string) *string { return &s }
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:       str("brt08/v1/sim.proto"),
+		Package:    str(Package),
+		Syntax:     str("proto3"),
+		Dependency: []string{"google/protobuf/struct.proto", "google/protobuf/timestamp.proto"},
+		Options:    &descriptorpb.FileOptions{GoPackage: str("brt08/backend/grpcapi")},
+	}
+	for _, m := range messages {
+		dp := &descriptorpb.DescriptorProto{Name: str(m.name)}
+		if m.oneof != "" {
+			dp.OneofDecl = []*descriptorpb.OneofDescriptorProto{{Name: str(m.oneof)}}
+		}
+		for _, f := range m.fields {
+			fp := &descriptorpb.FieldDescriptorProto{Name: str(f.name), Number: &f.num, Type: f.typ.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()}
+			if f.msg != "" {
+				fp.TypeName = str(f.msg)
+			}
+			if f.oneof {
+				fp.OneofIndex = new(int32)
+			}
+			dp.Field = append(dp.Field, fp)
+		}
+		fdp.MessageType = append(fdp.MessageType, dp)
+	}
+	method := func(name, in, out string, stream bool) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{Name: str(name), InputType: str(local(in)), OutputType: str(local(out)), ServerStreaming: &stream}
+	}
+	fdp.Service = []*descriptorpb.ServiceDescriptorProto{{Name: str("Simulation"), Method: []*descriptorpb.MethodDescriptorProto{
+		method(StartRun, "StartRunRequest", "RunInfo", false),
+		method(StreamEvents, "StreamEventsRequest", "Event", true),
+		method(Control, "ControlRequest", "ControlResponse", false),
+	}}}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(fmt.Sprintf("grpcapi: invalid schema: %v", err))
+	}
+	File = fd
+}
+
+// New returns an empty message of sim.proto by name (e.g. "Event").
+func New(name string) *dynamicpb.Message {
+	md := File.Messages().ByName(protoreflect.Name(name))
+	if md == nil {
+		panic("grpcapi: unknown message " + name)
+	}
+	return dynamicpb.NewMessage(md)
+}
+
+// Get returns field name of m (the zero value when unset).
+func Get(m protoreflect.Message, name string) protoreflect.Value {
+	return m.Get(m.Descriptor().Fields().ByName(protoreflect.Name(name)))
+}
+
+// Set sets field name of m to v (a Go value of the field's kind, e.g. float64).
+func Set(m protoreflect.Message, name string, v any) {
+	m.Set(m.Descriptor().Fields().ByName(protoreflect.Name(name)), protoreflect.ValueOf(v))
+}
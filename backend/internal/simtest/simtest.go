@@ -0,0 +1,36 @@
+// Package simtest holds fixtures shared by the simulation tests.
+package simtest
+
+import (
+	"math/rand"
+	"testing"
+
+	"brt08/backend/data"
+	"brt08/backend/model"
+)
+
+// Corridor loads the bundled corridor and builds a fresh copy of the bundled fleet
+// (buses carry run state, so every run needs its own).
+func Corridor(t testing.TB) (*model.Route, []*model.Bus) {
+	t.Helper()
+	rf, err := data.FS().Open("kimara_kivukoni_stops.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	route, err := model.LoadRouteFromReader(rf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ff, err := data.FS().Open("fleet.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ff.Close()
+	types, qty, err := model.LoadFleetFromReader(ff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, last := route.Stops[0].ID, route.Stops[len(route.Stops)-1].ID
+	return route, model.BuildFleetBuses(types, qty, route.ID, first, last, rand.New(rand.NewSource(1)))
+}
@@ -1,85 +1,652 @@
 package main
 
 import (
+	"brt08/backend/config"
+	"brt08/backend/data"
 	"brt08/backend/driver"
 	"brt08/backend/model"
+	"brt08/backend/report"
 	"brt08/backend/server"
+	"brt08/backend/sim"
+	"brt08/backend/sink"
+	"brt08/backend/telemetry"
+	"brt08/backend/web"
+	"context"
 	"flag"
+	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
 	// Flags
+	configPath := flag.String("config", "", "scenario file (YAML or JSON, see data/scenario.example.yaml); flags given on the command line override its values")
+	fleetFile := flag.String("fleet_file", "data/fleet.json", "fleet definition (bus types and quantities)")
+	vehicleParamsFile := flag.String("vehicle_params", "data/vehicle_params.json", "per-type vehicle dataset: speed distribution, fallback cost, energy and CO2 coefficients")
+	routeFile := flag.String("route_file", "data/kimara_kivukoni_stops.json", "route definition: native route JSON or a GeoJSON FeatureCollection (stop Points + LineString corridor)")
+	routeName := flag.String("route", "", "corridor id from -routes_index to simulate (overrides -route_file)")
+	routesIndex := flag.String("routes_index", "data/routes.json", "index of the corridor files on disk; streams pick one with ?route=<id> (listed at /api/routes)")
 	periodID := flag.Int("period", 2, "time period id influencing demand (1..6)")
 	passengerCap := flag.Int("passenger_cap", 0, "total passengers to generate (0 = unlimited / legacy unlimited mode)")
+	simHours := flag.Float64("sim_hours", 0, "stop criterion: end the run after this many simulated hours (0 = off; with -passenger_cap, whichever comes first)")
+	maxTrips := flag.Int("max_trips", 0, "stop criterion: end the run after this many completed one-way trips across the fleet (0 = off)")
 	morningTowardKivukoni := flag.Bool("morning_toward_kivukoni", true, "morning peak favored direction toward Kivukoni (outbound)")
 	dirBias := flag.Float64("dir_bias", 1.4, "directional bias factor (>1 favor favored direction)")
 	spatialGradient := flag.Float64("spatial_gradient", 0.8, "strength of spatial gradient (0-1)")
 	baselineDemand := flag.Float64("baseline_demand", 0.3, "baseline fraction when gradient applies (0-1)")
 	reportPath := flag.String("report", "", "if set, write CSV to this file or directory (timestamp appended)")
 	defaultSpeed := flag.Float64("time_scale", 1.0, "simulation real-time speed multiplier (>1 = faster)")
+	maxSpeed := flag.Float64("max_speed", server.DefaultMaxSpeed, "highest time scale a stream may request; speed \"max\" selects it (e.g. 100 for quick previews)")
+	eventThrottle := flag.Duration("event_throttle", 100*time.Millisecond, "above the default speed cap, stream a bus's moves and a stop's queue updates at most this often (0 = all events)")
+	backpressureFlag := flag.String("backpressure", "block", "what a stream's run does when the client falls behind: block | drop_moves (drop bus moves while the buffer is full) | disconnect (end the stream after -slow_client_timeout) | expand (buffer without bound)")
+	eventBuffer := flag.Int("event_buffer", sim.DefaultEventBuffer, "events buffered between a stream's run and its client")
+	slowClientTimeout := flag.Duration("slow_client_timeout", sim.DefaultSlowConsumerTimeout, "with -backpressure disconnect, longest a send or write may wait before the stream is ended")
 	defaultArrFactor := flag.Float64("arrival_factor", 1.0, "multiplier for passenger arrival rate (>1 = faster)")
+	lambda := flag.Float64("lambda", sim.DefaultLambda, "base passenger arrivals per corridor per minute (streams: the default of ?lambda=)")
 	addr := flag.String("addr", ":8080", "listen address")
-	driverMode := flag.String("driver", "sse", "simulation driver: sse | batch")
+	maxStreams := flag.Int("max_streams", 0, "simultaneous simulations (SSE, NDJSON and gRPC) the server runs; more requests get 429 with Retry-After (0 = unlimited)")
+	grpcAddr := flag.String("grpc_addr", "", "if set, also serve the gRPC API (grpcapi/sim.proto) on this address, e.g. :9090")
+	driverMode := flag.String("driver", "sse", "simulation driver: sse | batch | memory | sweep | optimize | avl | calibrate")
 	seed := flag.Int64("seed", 0, "random seed for reproducible runs (0 = random)")
+	demandProfile := flag.String("demand_profile", "flat", "arrival intensity profile: flat (selected period constant) | period (varies continuously across periods)")
+	stallMinutes := flag.Float64("stall_minutes", 30, "end the run when waiting passengers see no boarding for this many simulated minutes (0 = never)")
+	population := flag.Int("population", 0, "activity-based demand: synthetic population size whose members commute home->work in the morning and back in the evening (0 = independent Poisson arrivals)")
+	groupSizesSpec := flag.String("group_sizes", "", "group size distribution as size:weight pairs, e.g. 1:0.7,2:0.2,4:0.1 (empty = individual arrivals)")
+	passengerLog := flag.String("passenger_log", "", "if set, write every passenger's journey to this file or directory at the end of a run (.jsonl for JSON Lines, otherwise CSV)")
+	decisionLog := flag.String("decision_log", "", "if set, write the dispatch decision audit trail (dispatches, turnarounds, repositioning with their queue/headway inputs) to this file or directory (.jsonl for JSON Lines, otherwise CSV)")
+	trajectoryLog := flag.String("trajectory_log", "", "if set, write each run's bus trajectories as a GeoJSON FeatureCollection to this file or directory")
+	replications := flag.Int("replications", 1, "batch driver: repeat the scenario with this many consecutive seeds and report mean, sd and 95% confidence intervals (-report gets a replications-*.csv)")
+	targetWait := flag.Float64("target_wait", 5, "optimize driver: wait time in minutes the fleet must meet")
+	targetMetric := flag.String("target_metric", "avg", "optimize driver: wait metric compared with -target_wait: avg | p90")
+	maxBuses := flag.Int("max_buses", 20, "optimize driver: largest fleet evaluated")
+	varyTypes := flag.Bool("vary_types", false, "optimize driver: also evaluate single-type fleets of each bus type")
+	sweepSpec := flag.String("sweep", "", "parameter sweep for -driver sweep: ';'-separated axes, each name=lo:hi:step or name=v1,v2 (fleet, arrival_factor, dir_bias, spatial_gradient, baseline_demand, period, passenger_cap, population, seed)")
+	sweepOut := flag.String("sweep_out", "", "if set, write the consolidated sweep CSV to this file or directory (timestamp appended)")
+	workers := flag.Int("workers", 1, "worker pool size for sweep, replications and optimize (runs are isolated: own route, fleet copy and RNGs)")
+	sweepParallel := flag.Int("sweep_parallel", 0, "deprecated alias of -workers for the sweep driver")
+	eco := flag.Bool("eco", false, "eco-driving: run segments at energy-optimal advised speeds (batch driver compares against a baseline run)")
+	ecoTimeWeight := flag.Float64("eco_time_weight", 0, "eco-driving: value of running time in kW of energy-equivalent (higher = faster advice)")
+	chaosSpec := flag.String("chaos", "", "SSE fault injection for testing cleanup paths, e.g. drop=0.05,delay=0.2,max_delay=300ms,disconnect=0.001 (empty = off)")
+	runHistory := flag.Int("run_history", 20, "finished SSE runs kept in memory for /api/runs and /api/runs/compare (0 = none)")
+	staticDir := flag.String("static_dir", "", "serve the frontend from this directory instead of the embedded build (e.g. ../frontend/dist)")
+	simplifyM := flag.Float64("simplify_m", sim.DefaultSimplifyToleranceM, "Douglas-Peucker tolerance in meters for recorded trajectories and exported edge shapes (0 = keep every point)")
+	dwellReport := flag.String("dwell_report", "", "if set, write per-stop dwell distributions and berth occupancy shares (CSV) to this file or directory")
+	loadReport := flag.String("load_report", "", "if set, write the segment x direction occupancy heatmap with the peak load points (CSV) to this file or directory")
+	sinkURL := flag.String("sink", "", "also publish every stream event to redis://[:password@]host:6379/<stream key> (XADD) or kafka+http://rest-proxy:8082/<topic> (Kafka REST proxy)")
+	trafficURL := flag.String("traffic_url", "", "if set, POST each segment departure to this HTTP adapter for an external travel time (e.g. a SUMO/TraCI bridge)")
+	reportFormat := flag.String("format", "", "batch/memory report format: csv | xlsx | html | json (default: from the -report extension, else csv; json replaces the console report and is written to stdout without -report)")
+	exportFormat := flag.String("export", "", "if set (matsim | sumo), export the corridor, stops and generated passenger plans after each run")
+	exportDir := flag.String("export_dir", "export", "output directory for -export files")
+	precisionKM := flag.Int("precision_km", sim.DefaultPrecision.KMDecimals, "decimal places for distances in reports")
+	precisionCurrency := flag.Int("precision_currency", sim.DefaultPrecision.CurrencyDecimals, "decimal places for costs in reports")
+	precisionMinutes := flag.Int("precision_minutes", sim.DefaultPrecision.MinutesDecimals, "decimal places for minutes (waits) in reports")
+	fullPrecision := flag.Bool("full_precision", false, "keep full precision in CSV/JSON outputs (console stays rounded)")
+	headwayTargetsSpec := flag.String("headway_targets", "", "target headway minutes per period as period:minutes pairs, e.g. 2:4,5:4 (unlisted periods use target_headway_min of data/time_periods.json, bundled at build time)")
+	headwayTolerance := flag.Float64("headway_tolerance", sim.DefaultHeadwayTolerance, "headway adherence band as a fraction of the target headway (0.25 = within ±25%)")
+	shiftsSpec := flag.String("shifts", "", "driver shift rules as key=duration pairs over duty, drive, break, relief, e.g. duty=8h,drive=4h,break=30m,relief=10m (empty = no shifts)")
+	startSpec := flag.String("start", "", "simulated start of each run: now (wall clock), HH:MM (today), YYYY-MM-DD (at the period's start), YYYY-MM-DDTHH:MM or RFC 3339, local time unless a zone is given (empty = today at the period's, or -day's, start)")
+	observedFile := flag.String("observed", "", "calibrate driver: observed boardings CSV (stop_id,period,boardings per whole period)")
+	calibrateParams := flag.String("calibrate_params", "lambda,dir_bias,spatial_gradient,baseline_demand", "calibrate driver: comma-separated demand parameters to fit")
+	calibrateRounds := flag.Int("calibrate_rounds", 3, "calibrate driver: coordinate search passes, each halving the step")
+	avlFile := flag.String("avl_file", "", "GPS/AVL trace CSV (bus_id,timestamp,lat,lng) to replay: -driver avl compares it with a simulated run, streams replay it with ?source=avl")
+	avlStopRadius := flag.Float64("avl_stop_radius_m", sim.DefaultAVLStopRadiusM, "AVL playback: a position within this many metres of a stop is a bus at the stop")
+	platformCap := flag.Int("platform_capacity", 0, "waiting passengers a stop platform holds, for stops whose route data sets no platform_capacity (0 = unlimited); queues beyond it emit platform_overflow events")
+	platformSpill := flag.Bool("platform_spill", false, "passengers beyond a full platform walk to an adjacent stop with room they can still board at")
+	berthCount := flag.Int("berths", 0, "buses a station serves at once, for stops whose route data sets no berths (0 = unlimited); a bus arriving with every berth taken queues upstream")
+	turnaroundBays := flag.Int("turnaround_bays", 0, "buses each terminal turns at once (0 = unlimited); a bus finding every bay taken waits for one before running the other way")
+	turnaroundSec := flag.Float64("turnaround_s", sim.DefaultTurnaroundTime.Seconds(), "seconds a bus takes in a terminal bay to turn")
+	recoverySpec := flag.String("recovery", "", "minimum layover minutes from a bus's arrival at a terminal to its departure the other way: minutes for both terminals, terminal=minutes (stop id or name), @period=minutes or terminal@period=minutes, e.g. 3,kivukoni=5,@2=8 (empty = turn and go)")
+	daySpec := flag.String("day", "", "full-day run chaining periods at clock times: default (periods 1-6 at their usual times) or id@HH:MM transitions with an optional end, e.g. 2@06:00,3@09:00,end@12:00 (empty = the single -period)")
+	qualityWeightsSpec := flag.String("quality_weights", "", "service quality score weights as name:weight pairs over wait, crowding, reliability (default wait:0.5,crowding:0.3,reliability:0.2)")
+	etaSeconds := flag.Float64("eta_seconds", 30, "streams: emit passenger information display updates (stop_eta events) for every stop this many simulated seconds apart and score them against the actual arrivals (0 = off)")
+	metricsSeconds := flag.Float64("metrics_seconds", 10, "emit a KPI heartbeat (metrics event) every this many simulated seconds (0 = off)")
+	seedWindowMinutes := flag.Float64("seed_window_minutes", sim.DefaultSeedWindow.Minutes(), "how far back initial (seeded) passengers may have arrived")
+	seedDist := flag.String("seed_dist", "uniform", "backdating distribution of seeded passengers: uniform | exponential | none")
+	excludeSeededWait := flag.Bool("exclude_seeded_wait", false, "exclude seeded passengers from wait statistics")
 	traceBus := flag.Int("trace_bus", 0, "if >0, emit detailed trace logs for this bus id in chosen driver")
+	logLevel := flag.String("log_level", "info", "minimum log level: debug | info | warn | error (trace output is logged at debug; -trace_bus implies debug unless set)")
+	logFormat := flag.String("log_format", "text", "log output format: text | json")
+	stress := flag.Bool("stress", false, "run the batch driver headless sampling the heap and report whether memory stays bounded (default -passenger_cap 1000000 without a stop criterion)")
+	otelTrace := flag.String("otel_trace", "", "if set, export OpenTelemetry spans (stream, runner, bus trips, reposition) as JSON to this file (- = stderr)")
 	flag.Parse()
+	if *configPath != "" {
+		if err := applyScenario(*configPath); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	// Load route
-	rf, err := os.Open("data/kimara_kivukoni_stops.json")
+	levelSet := false
+	flag.Visit(func(f *flag.Flag) { levelSet = levelSet || f.Name == "log_level" })
+	if *traceBus > 0 && !levelSet {
+		*logLevel = "debug"
+	}
+	logger, err := newLogger(*logLevel, *logFormat)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+	if *configPath != "" {
+		slog.Info("scenario loaded", "path", *configPath)
+	}
+	shutdownTracing, err := telemetry.Setup(*otelTrace)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+	// Interrupts cancel running simulations (batch runs and SSE streams) and stop the server
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	// Bundled datasets missing on disk are read from the binary (see data.Open)
+	for _, p := range []string{*routesIndex, *fleetFile, *vehicleParamsFile} {
+		if data.Embedded(p) {
+			slog.Info("dataset not found on disk; using the embedded copy", "file", p)
+		}
 	}
-	defer rf.Close()
-	route, err := model.LoadRouteFromReader(rf, 100)
+	// Load route, and the other corridors streams may select
+	routeFileSet := false
+	flag.Visit(func(f *flag.Flag) { routeFileSet = routeFileSet || f.Name == "route_file" })
+	route, corridors, err := loadCorridors(*routesIndex, *routeName, *routeFile, routeFileSet)
 	if err != nil {
 		panic(err)
 	}
 
+	// Vehicle parameters: built-in defaults when the dataset is missing
+	vehicles := model.DefaultVehicleDataset()
+	if vf, err := data.Open(*vehicleParamsFile); err != nil {
+		slog.Warn("open vehicle params failed; using built-in defaults", "err", err)
+	} else {
+		ds, verr := model.LoadVehicleDatasetFromReader(vf)
+		vf.Close()
+		if verr != nil {
+			log.Fatalf("vehicle params: %v", verr)
+		}
+		vehicles = ds
+	}
+
 	// Load fleet or fallback
-	fleetFile, err := os.Open("data/fleet.json")
+	ff, err := data.Open(*fleetFile)
 	if err != nil {
-		log.Printf("warning: open fleet.json failed: %v; falling back to two default buses", err)
+		slog.Warn("open fleet file failed; falling back to two default buses", "err", err)
 	}
 	var fleetBuses []*model.Bus
 	if err == nil {
-		defer fleetFile.Close()
-		types, qty, ferr := model.LoadFleetFromReader(fleetFile)
+		defer ff.Close()
+		types, qty, ferr := model.LoadFleetFromReader(ff)
 		if ferr != nil {
-			log.Printf("warning: parse fleet.json failed: %v; using defaults", ferr)
-		} else {
-			baseSeed := *seed
-			if baseSeed == 0 {
-				baseSeed = time.Now().UnixNano()
-			}
-			rng := rand.New(rand.NewSource(baseSeed))
-			first := route.Stops[0].ID
-			last := route.Stops[len(route.Stops)-1].ID
-			fleetBuses = model.BuildFleetBuses(types, qty, route.ID, first, last, rng)
+			log.Fatalf("fleet file %s: %v", *fleetFile, ferr)
+		}
+		baseSeed := *seed
+		if baseSeed == 0 {
+			baseSeed = time.Now().UnixNano()
 		}
+		rng := rand.New(rand.NewSource(baseSeed))
+		vehicles.Apply(types)
+		first := route.Stops[0].ID
+		last := route.Stops[len(route.Stops)-1].ID
+		fleetBuses = model.BuildFleetBuses(types, qty, route.ID, first, last, rng)
 	}
 	if len(fleetBuses) == 0 {
-		bt := &model.BusType{ID: 1, Name: "Standard 12m", Capacity: 70, CostPerKm: 1.75}
+		bt := &model.BusType{ID: 1, Name: "Standard 12m", Capacity: 70}
+		vehicles.Apply(map[int]*model.BusType{bt.ID: bt})
 		fleetBuses = []*model.Bus{{ID: 1, Type: bt, RouteID: route.ID, CurrentStopID: route.Stops[0].ID, Direction: "outbound", AverageSpeedKmph: 28.0}, {ID: 2, Type: bt, RouteID: route.ID, CurrentStopID: route.Stops[len(route.Stops)-1].ID, Direction: "inbound", AverageSpeedKmph: 28.0}}
 	}
 
+	sim.ReportPrecision = sim.Precision{KMDecimals: *precisionKM, CurrencyDecimals: *precisionCurrency, MinutesDecimals: *precisionMinutes, FullPrecision: *fullPrecision}
+	stallTimeout := time.Duration(*stallMinutes * float64(time.Minute))
+	if *simHours < 0 || *maxTrips < 0 {
+		log.Fatal("-sim_hours and -max_trips must be >= 0")
+	}
+	if !(*maxSpeed > 0) || math.IsInf(*maxSpeed, 0) || *eventThrottle < 0 {
+		log.Fatal("-max_speed must be a positive finite number and -event_throttle >= 0")
+	}
+	backpressure, err := sim.ParseBackpressure(*backpressureFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	demandProfileName, err := sim.ParseDemandProfile(*demandProfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *eventBuffer < 1 || *slowClientTimeout <= 0 {
+		log.Fatal("-event_buffer must be >= 1 and -slow_client_timeout > 0")
+	}
+	criterion := sim.StopCriterion{Duration: time.Duration(*simHours * float64(time.Hour)), Trips: *maxTrips}
+	groupSizes, err := sim.ParseGroupSizes(*groupSizesSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *exportFormat != "" && *exportFormat != "matsim" && *exportFormat != "sumo" {
+		log.Fatalf("unknown -export format %q (want matsim or sumo)", *exportFormat)
+	}
+	if err := sim.ValidateSeedDist(*seedDist); err != nil {
+		log.Fatal(err)
+	}
+	seeding := sim.SeedConfig{Window: time.Duration(*seedWindowMinutes * float64(time.Minute)), Dist: *seedDist, ExcludeFromWait: *excludeSeededWait}
+	qualityWeights, err := sim.ParseQualityWeights(*qualityWeightsSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	headwayTargets, err := sim.ParseHeadwayTargets(*headwayTargetsSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	headway := sim.HeadwayConfig{Targets: headwayTargets, Tolerance: *headwayTolerance}
+	shifts, err := sim.ParseShiftConfig(*shiftsSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	day, err := sim.ParseDaySchedule(*daySpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	startTime, err := sim.ParseStartTime(*startSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if day.Enabled() && !startTime.DateOnly() {
+		log.Fatalf("-start %s: a full-day run starts at its first period, give a date (YYYY-MM-DD) only", *startSpec)
+	}
+	var avlTrace *sim.AVLTrace
+	if *avlFile != "" {
+		t, err := sim.ReadAVLTrace(*avlFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		avlTrace = &t
+	} else if *driverMode == "avl" {
+		log.Fatal("-driver avl requires -avl_file")
+	}
+	avlMatch := sim.AVLOptions{StopRadiusM: *avlStopRadius}
+	if *platformCap < 0 {
+		log.Fatal("-platform_capacity must be >= 0")
+	}
+	platforms := sim.PlatformConfig{Default: *platformCap, Spill: *platformSpill}
+	if *berthCount < 0 {
+		log.Fatal("-berths must be >= 0")
+	}
+	berths := sim.BerthConfig{Default: *berthCount}
+	if *turnaroundBays < 0 || *turnaroundSec <= 0 {
+		log.Fatal("-turnaround_bays must be >= 0 and -turnaround_s > 0")
+	}
+	recovery, err := sim.ParseRecovery(*recoverySpec)
+	if err == nil {
+		err = recovery.Validate(route)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	turnaround := sim.TurnaroundConfig{Bays: *turnaroundBays, Time: time.Duration(*turnaroundSec * float64(time.Second)), Recovery: recovery}
+	var traffic sim.TravelTimeProvider
+	if *trafficURL != "" {
+		traffic = sim.NewHTTPTravelTimeProvider(*trafficURL)
+	}
+	var ecoAdvisor *sim.EcoAdvisor
+	if *eco {
+		ecoAdvisor = sim.NewEcoAdvisor(route, fleetBuses, sim.DefaultEnergyModel(), *ecoTimeWeight)
+		if *driverMode != "batch" || *replications > 1 {
+			// other drivers simply run at the advised speeds
+			ecoAdvisor.Next = traffic
+			traffic = ecoAdvisor
+		}
+	}
+
+	switch *reportFormat {
+	case "", report.CSV, report.XLSX, report.HTML, "json":
+	default:
+		log.Fatalf("-format: want csv, xlsx, html or json, got %q", *reportFormat)
+	}
+	// Options of the headless drivers; the ones running several simulations (sweep,
+	// replications, fleet sizing, calibration, AVL and eco comparisons) drop the
+	// per-run output paths themselves.
+	baseOptions := func() driver.Options {
+		return driver.Options{PeriodID: *periodID, PassengerCap: *passengerCap, Criterion: criterion, Headway: headway, Shifts: shifts, Day: day, StartTime: startTime, MorningTowardKivukoni: *morningTowardKivukoni, DirBias: *dirBias, SpatialGradient: *spatialGradient, BaselineDemand: *baselineDemand, DemandProfile: demandProfileName, ArrivalFactor: *defaultArrFactor, Lambda: *lambda, Platforms: platforms, Berths: berths, Turnaround: turnaround, ReportPath: *reportPath, Seed: *seed, TraceBusID: *traceBus, StallTimeout: stallTimeout, GroupSizes: groupSizes, Population: *population, Seeding: seeding, PassengerLogPath: *passengerLog, DecisionLogPath: *decisionLog, TrajectoryLogPath: *trajectoryLog, SimplifyToleranceM: *simplifyM, DwellReportPath: *dwellReport, LoadReportPath: *loadReport, Traffic: traffic, ExportFormat: *exportFormat, ExportDir: *exportDir, QualityWeights: qualityWeights, MetricsInterval: time.Duration(*metricsSeconds * float64(time.Second)), ReportFormat: *reportFormat}
+	}
+	if *driverMode == "memory" {
+		// Run headless and keep the full ordered event slice
+		events, _, err := driver.RunEvents(ctx, route, fleetBuses, baseOptions())
+		if err != nil {
+			log.Fatal(err)
+		}
+		counts := make(map[string]int)
+		for _, ev := range events {
+			counts[fmt.Sprintf("%T", ev)]++
+		}
+		names := make([]string, 0, len(counts))
+		for n := range counts {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Printf("in-memory run: %d events\n", len(events))
+		for _, n := range names {
+			fmt.Printf("  %-24s %d\n", n, counts[n])
+		}
+		return
+	}
+	if *stress {
+		// Batch run checking that memory stays bounded for very large passenger caps
+		rep, _, err := driver.RunStress(ctx, route, fleetBuses, baseOptions())
+		if err != nil {
+			log.Fatal(err)
+		}
+		driver.PrintStressReport(rep)
+		if !rep.Bounded {
+			os.Exit(1)
+		}
+		return
+	}
+	// Every sweep, replication and optimizer run gets its own deep copy of the loaded
+	// route, so concurrent workers never share stop queues.
+	newRoute := func() (*model.Route, error) {
+		return route.Clone(), nil
+	}
+	if *sweepParallel > *workers {
+		*workers = *sweepParallel
+	}
+	if *driverMode == "sweep" {
+		// Run the batch driver once per parameter combination and tabulate the summaries
+		axes, err := driver.ParseSweep(*sweepSpec)
+		if err != nil {
+			log.Fatalf("-sweep: %v", err)
+		}
+		base := baseOptions()
+		progress := func(done, total int, r driver.SweepResult) {
+			slog.Info("sweep run finished", "done", done, "total", total, "run", r.Run, "params", r.Params, "err", r.Err)
+		}
+		results, err := driver.Sweep(ctx, driver.SweepOptions{Base: base, Axes: axes, NewRoute: newRoute, Fleet: fleetBuses, Parallel: *workers, Progress: progress})
+		driver.PrintSweepTable(axes, results)
+		if *sweepOut != "" {
+			if _, werr := driver.WriteSweepCSV(*sweepOut, axes, results); werr != nil {
+				slog.Error("sweep summary: create failed", "err", werr)
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *driverMode == "optimize" {
+		// Cheapest fleet meeting the wait target, evaluated with the batch driver
+		base := baseOptions()
+		res, err := driver.OptimizeFleet(ctx, driver.OptimizeOptions{Base: base, NewRoute: newRoute, Fleet: fleetBuses, TargetWaitMin: *targetWait, Metric: *targetMetric, MaxBuses: *maxBuses, VaryTypes: *varyTypes, Replications: *replications, Workers: *workers})
+		if err != nil {
+			log.Fatal(err)
+		}
+		driver.PrintOptimizeReport(res)
+		if *reportPath != "" {
+			if _, werr := driver.WriteOptimizeCSV(*reportPath, res); werr != nil {
+				slog.Error("fleet sizing: create failed", "err", werr)
+			}
+		}
+		return
+	}
+	if *driverMode == "calibrate" {
+		// Fit the demand parameters to observed boardings with the batch driver
+		if *observedFile == "" {
+			log.Fatal("-driver calibrate requires -observed")
+		}
+		obs, err := driver.ReadObservations(*observedFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		base := baseOptions()
+		res, err := driver.Calibrate(ctx, driver.CalibrationOptions{Base: base, NewRoute: newRoute, Fleet: fleetBuses, Observed: obs, Params: strings.Split(*calibrateParams, ","), Rounds: *calibrateRounds, Replications: *replications, Workers: *workers})
+		if err != nil {
+			log.Fatal(err)
+		}
+		driver.PrintCalibrationReport(res)
+		if *reportPath != "" {
+			if _, werr := driver.WriteCalibrationCSV(*reportPath, res); werr != nil {
+				slog.Error("calibration: create failed", "err", werr)
+			}
+		}
+		return
+	}
+	if *driverMode == "avl" {
+		// Replay the trace and simulate the same window for a side-by-side comparison
+		base := baseOptions()
+		cmp, err := driver.CompareAVL(ctx, newRoute, fleetBuses, *avlTrace, avlMatch, base)
+		if err != nil {
+			log.Fatal(err)
+		}
+		driver.PrintAVLReport(cmp)
+		if *reportPath != "" {
+			if _, werr := driver.WriteAVLCSV(*reportPath, cmp); werr != nil {
+				slog.Error("avl comparison: create failed", "err", werr)
+			}
+		}
+		return
+	}
+	if *driverMode == "batch" && *replications > 1 {
+		// Same scenario, consecutive seeds; per-run outputs are skipped
+		base := baseOptions()
+		rs, err := driver.Replicate(ctx, newRoute, fleetBuses, base, *replications, *workers)
+		driver.PrintReplicationReport(rs)
+		if *reportPath != "" {
+			if _, werr := driver.WriteReplicationCSV(*reportPath, rs); werr != nil {
+				slog.Error("replication summary: create failed", "err", werr)
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *driverMode == "batch" && ecoAdvisor != nil && *replications <= 1 {
+		// Baseline vs eco-driving with the same seed
+		base := baseOptions()
+		cmp, err := driver.CompareEco(ctx, newRoute, fleetBuses, base, ecoAdvisor)
+		if err != nil {
+			log.Fatal(err)
+		}
+		driver.PrintEcoReport(cmp)
+		if *reportPath != "" {
+			if _, werr := driver.WriteEcoCSV(*reportPath, cmp); werr != nil {
+				slog.Error("eco comparison: create failed", "err", werr)
+			}
+		}
+		return
+	}
 	if *driverMode == "batch" {
 		// Run headless, fast simulation without SSE
-		_, err := driver.Run(route, fleetBuses, driver.Options{PeriodID: *periodID, PassengerCap: *passengerCap, MorningTowardKivukoni: *morningTowardKivukoni, DirBias: *dirBias, SpatialGradient: *spatialGradient, BaselineDemand: *baselineDemand, ArrivalFactor: *defaultArrFactor, ReportPath: *reportPath, Seed: *seed, TraceBusID: *traceBus})
+		_, err := driver.Run(ctx, route, fleetBuses, baseOptions())
 		if err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
 	// Default: SSE server
-	srv := server.New(route, fleetBuses, server.Options{PeriodID: *periodID, PassengerCap: *passengerCap, MorningTowardKivukoni: *morningTowardKivukoni, DirBias: *dirBias, SpatialGradient: *spatialGradient, BaselineDemand: *baselineDemand, DefaultSpeed: *defaultSpeed, DefaultArrivalFactor: *defaultArrFactor, ReportPath: *reportPath, Seed: *seed, TraceBusID: *traceBus})
-	srv.Serve()
-	log.Printf("Serving on %s", *addr)
-	log.Fatal(http.ListenAndServe(*addr, nil))
+	chaos, err := server.ParseChaos(*chaosSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if chaos.Enabled() {
+		slog.Warn("chaos mode enabled: SSE writes are delayed, dropped and cut at random", "chaos", *chaosSpec)
+	}
+	var eventSink *sink.Async
+	if *sinkURL != "" {
+		es, err := sink.Open(*sinkURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		eventSink = sink.NewAsync(es, 0)
+		defer eventSink.Close()
+	}
+	var static fs.FS = web.FS()
+	if *staticDir != "" {
+		if st, err := os.Stat(*staticDir); err != nil || !st.IsDir() {
+			log.Fatalf("static_dir %q is not a directory", *staticDir)
+		}
+		static = os.DirFS(*staticDir)
+	}
+	srv := server.New(route, fleetBuses, server.WithOptions(server.Options{PeriodID: *periodID, PassengerCap: *passengerCap, Criterion: criterion, Headway: headway, Shifts: shifts, Day: day, StartTime: startTime, MorningTowardKivukoni: *morningTowardKivukoni, DirBias: *dirBias, SpatialGradient: *spatialGradient, BaselineDemand: *baselineDemand, DemandProfile: demandProfileName, DefaultSpeed: *defaultSpeed, DefaultArrivalFactor: *defaultArrFactor, Lambda: *lambda, Platforms: platforms, Berths: berths, Turnaround: turnaround, ReportPath: *reportPath, Seed: *seed, TraceBusID: *traceBus, StallTimeout: stallTimeout, GroupSizes: groupSizes, Population: *population, Seeding: seeding, PassengerLogPath: *passengerLog, DecisionLogPath: *decisionLog, TrajectoryLogPath: *trajectoryLog, SimplifyToleranceM: *simplifyM, DwellReportPath: *dwellReport, LoadReportPath: *loadReport, Traffic: traffic, ExportFormat: *exportFormat, ExportDir: *exportDir, QualityWeights: qualityWeights, MetricsInterval: time.Duration(*metricsSeconds * float64(time.Second)), ETAInterval: time.Duration(*etaSeconds * float64(time.Second)), Static: static, RunHistory: *runHistory, Chaos: chaos, MaxSpeed: *maxSpeed, EventThrottle: *eventThrottle, Backpressure: backpressure, EventBuffer: *eventBuffer, SlowClientTimeout: *slowClientTimeout, Sink: eventSink, GRPC: *grpcAddr != "", MaxStreams: *maxStreams, Corridors: corridors, AVL: avlTrace, AVLMatch: avlMatch}))
+	slog.Info("serving", "addr", *addr)
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gs := srv.NewGRPCServer(ctx)
+		go func() {
+			if err := gs.Serve(lis); err != nil {
+				slog.Error("grpc server", "err", err)
+			}
+		}()
+		defer gs.GracefulStop()
+		slog.Info("serving grpc", "addr", *grpcAddr)
+	}
+	httpSrv := &http.Server{Addr: *addr, Handler: srv.Handler(), BaseContext: func(net.Listener) context.Context { return ctx }}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		// Streams see their request context cancelled and finish with a done event and reports
+		sctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpSrv.Shutdown(sctx); err != nil {
+			slog.Warn("shutdown", "err", err)
+		}
+	}()
+	if err := httpSrv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdownDone
+	slog.Info("server stopped")
 }
 
 // (helper removed; generation moved into stream loop)
+
+// newLogger builds the process logger; the standard log package is routed through it
+// by slog.SetDefault so remaining log.Fatal calls come out in the same format.
+func newLogger(level, format string) (*slog.Logger, error) {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown -log_level %q (want debug, info, warn or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown -log_format %q (want text or json)", format)
+}
+
+// applyScenario sets every flag the scenario file defines, except those given
+// explicitly on the command line.
+func applyScenario(path string) error {
+	sc, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, st := range sc.Settings() {
+		if explicit[st.Flag] {
+			continue
+		}
+		if err := flag.Set(st.Flag, st.Value); err != nil {
+			return fmt.Errorf("scenario %s: %s: %w", path, st.Flag, err)
+		}
+	}
+	return nil
+}
+
+// loadRoute reads a route file, logging its load notes.
+func loadRoute(path string, id int) (*model.Route, error) {
+	if data.Embedded(path) {
+		slog.Info("dataset not found on disk; using the embedded copy", "file", path)
+	}
+	f, err := data.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	route, err := model.LoadRouteFromReader(f, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, note := range route.LoadNotes {
+		slog.Warn("route coordinates normalized", "file", path, "detail", note)
+	}
+	return route, nil
+}
+
+// loadCorridors loads the route to simulate and the corridors of the routes index.
+// The route is the index entry name; else routeFile when it was set explicitly, which
+// takes the id of the index entry with the same file or, if none, of its base name;
+// else the index default. A missing index leaves routeFile as the only corridor; an
+// index entry that fails to load is skipped with a warning unless it is the selected one.
+func loadCorridors(indexPath, name, routeFile string, routeFileSet bool) (*model.Route, []server.Corridor, error) {
+	var ix *model.RouteIndex
+	if f, err := data.Open(indexPath); err != nil {
+		if name != "" {
+			return nil, nil, fmt.Errorf("-route %s: %w", name, err)
+		}
+	} else {
+		ix, err = model.LoadRouteIndexFromReader(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", indexPath, err)
+		}
+	}
+	var corridors []server.Corridor
+	if ix != nil {
+		if name != "" {
+			if _, err := ix.Lookup(name); err != nil {
+				return nil, nil, fmt.Errorf("-route: %w", err)
+			}
+		} else if !routeFileSet {
+			name = ix.Default
+		}
+		for i, e := range ix.Routes {
+			path := e.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(indexPath), path)
+			}
+			if name == "" && filepath.Clean(path) == filepath.Clean(routeFile) {
+				name = e.ID
+			}
+			r, err := loadRoute(path, 100+i)
+			if err != nil {
+				if e.ID == name {
+					return nil, nil, err
+				}
+				slog.Warn("corridor skipped", "route", e.ID, "err", err)
+				continue
+			}
+			corridors = append(corridors, server.Corridor{ID: e.ID, Name: e.Name, File: e.File, Description: e.Description, Route: r})
+		}
+	}
+	for _, c := range corridors {
+		if c.ID == name {
+			return c.Route, corridors, nil
+		}
+	}
+	// a route file outside the index
+	route, err := loadRoute(routeFile, 100+len(corridors))
+	if err != nil {
+		return nil, nil, err
+	}
+	id := strings.TrimSuffix(filepath.Base(routeFile), filepath.Ext(routeFile))
+	for _, c := range corridors {
+		if c.ID == id {
+			id = routeFile // the index uses the base name for another file
+		}
+	}
+	corridors = append(corridors, server.Corridor{ID: id, Name: route.Name, File: routeFile, Route: route})
+	return route, corridors, nil
+}
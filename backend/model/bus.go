@@ -1,33 +1,58 @@
 package model
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // BusType represents a category of buses with cost and capacity attributes.
 type BusType struct {
-	ID        int     `json:"id"`
-	Name      string  `json:"name"`
-	Capacity  int     `json:"capacity"`
-	CostPerKm float64 `json:"cost_per_km"`
+	ID              int            `json:"id"`
+	Name            string         `json:"name"`
+	Capacity        int            `json:"capacity"`
+	CostPerKm       float64        `json:"cost_per_km"`
+	CostPerHour     float64        `json:"cost_per_hour"`      // crew and other time-based cost per in-service hour
+	FixedCostPerDay float64        `json:"fixed_cost_per_day"` // depreciation, insurance, ... per day in service
+	Speed           *SpeedDist     `json:"speed,omitempty"`    // average running speed per bus; nil = from the vehicle dataset
+	Params          *VehicleParams `json:"-"`                  // resolved vehicle dataset entry (speed, energy, emissions)
+}
+
+// CostBreakdown splits the operating cost of km driven over hours in service into its
+// distance, time and fixed parts; the daily fixed cost is charged once for every started
+// day in service.
+func (t *BusType) CostBreakdown(km, hours float64) (distance, time, fixed float64) {
+	if t == nil {
+		return 0, 0, 0
+	}
+	distance, time = t.CostPerKm*km, t.CostPerHour*hours
+	if hours > 0 {
+		fixed = t.FixedCostPerDay * math.Ceil(hours/24)
+	}
+	return distance, time, fixed
+}
+
+// OperatingCost is the total of CostBreakdown.
+func (t *BusType) OperatingCost(km, hours float64) float64 {
+	d, h, f := t.CostBreakdown(km, hours)
+	return d + h + f
 }
 
 // Bus represents an individual bus in operation.
 type Bus struct {
-	ID                int          `json:"id"`
-	Type              *BusType     `json:"type"`
-	RouteID           int          `json:"route_id"`
-	CurrentStopID     int          `json:"current_stop_id"`
-	Direction         string       `json:"direction"` // "outbound" or "inbound"
-	PassengersOnboard int          `json:"passengers_onboard"`
-	IsFull            bool         `json:"is_full"`
-	AverageSpeedKmph  float64      `json:"average_speed_kmph"`
+	ID                int      `json:"id"`
+	Type              *BusType `json:"type"`
+	RouteID           int      `json:"route_id"`
+	CurrentStopID     int      `json:"current_stop_id"`
+	Direction         string   `json:"direction"` // "outbound" or "inbound"
+	PassengersOnboard int      `json:"passengers_onboard"`
+	IsFull            bool     `json:"is_full"`
+	AverageSpeedKmph  float64  `json:"average_speed_kmph"`
 	// Detailed passenger tracking
 	Passengers    []*Passenger `json:"passengers,omitempty"`
 	TotalBoarded  int          `json:"total_boarded"`
 	TotalAlighted int          `json:"total_alighted"`
 }
 
-
-
 // LoadPassengers attempts to board up to n passengers.
 // It returns the number actually boarded (0..n).
 func (b *Bus) LoadPassengers(n int) int {
@@ -128,6 +153,7 @@ func (b *Bus) BoardPassengersAtStop(stopID int, waiting []*Passenger, now time.T
 		// Only consider those starting here & on same route & not already boarded
 		if capacityLeft > 0 && p.RouteID == b.RouteID && p.StartStopID == stopID && p.BoardingTime == nil {
 			p.MarkBoarded(now)
+			p.BusID = b.ID
 			b.Passengers = append(b.Passengers, p)
 			boarded = append(boarded, p)
 			capacityLeft--
@@ -151,7 +177,8 @@ func (b *Bus) AlightPassengersAtCurrentStop(now time.Time) (alighted []*Passenge
 	if len(b.Passengers) == 0 {
 		return nil
 	}
-	keep := make([]*Passenger, 0, len(b.Passengers))
+	// filter in place: the onboard slice is reused for the whole run
+	keep := b.Passengers[:0]
 	for _, p := range b.Passengers {
 		if p.EndStopID == b.CurrentStopID && p.IsOnboard() {
 			p.MarkArrived(now)
@@ -161,6 +188,7 @@ func (b *Bus) AlightPassengersAtCurrentStop(now time.Time) (alighted []*Passenge
 			keep = append(keep, p)
 		}
 	}
+	clear(b.Passengers[len(keep):])
 	b.Passengers = keep
 	b.PassengersOnboard = len(b.Passengers)
 	if b.Type != nil && b.PassengersOnboard >= b.Type.Capacity {
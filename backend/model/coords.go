@@ -0,0 +1,124 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// rawCoord accepts the legacy misspelled keys ("latitute"/"longtude") used by the bundled
+// data as well as the correct spellings and the usual short forms.
+type rawCoord struct {
+	Latitute  *float64 `json:"latitute"`
+	Latitude  *float64 `json:"latitude"`
+	Lat       *float64 `json:"lat"`
+	Longtude  *float64 `json:"longtude"`
+	Longitude *float64 `json:"longitude"`
+	Lng       *float64 `json:"lng"`
+	Lon       *float64 `json:"lon"`
+}
+
+// pick returns the single value given under any of the alias keys.
+func pick(axis string, vals ...*float64) (float64, bool, error) {
+	var v *float64
+	for _, c := range vals {
+		if c == nil {
+			continue
+		}
+		if v != nil && *v != *c {
+			return 0, false, fmt.Errorf("conflicting %s values %v and %v", axis, *v, *c)
+		}
+		v = c
+	}
+	if v == nil {
+		return 0, false, nil
+	}
+	return *v, true, nil
+}
+
+// resolve returns the coordinate; ok is false when either axis is missing.
+func (c rawCoord) resolve() (lat, lng float64, ok bool, err error) {
+	lat, okLat, err := pick("latitude", c.Latitute, c.Latitude, c.Lat)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	lng, okLng, err := pick("longitude", c.Longtude, c.Longitude, c.Lng, c.Lon)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	return lat, lng, okLat && okLng, nil
+}
+
+// minPlausibleRadiusKM is the smallest radius around the route centre accepted for
+// stops and pins; longer routes get twice their length.
+const minPlausibleRadiusKM = 25.0
+
+// NormalizeCoordinates validates stop and pin coordinates: they must be in range and
+// lie within a plausible radius of the route centre (median stop position). Points whose
+// latitude and longitude are evidently swapped are corrected and reported in notes;
+// anything else implausible is an error.
+func NormalizeCoordinates(r *Route) (notes []string, err error) {
+	if len(r.Stops) == 0 {
+		return nil, nil
+	}
+	fix := func(what string, lat, lng *float64) error {
+		if math.Abs(*lat) <= 90 && math.Abs(*lng) <= 180 {
+			return nil
+		}
+		if math.Abs(*lng) <= 90 && math.Abs(*lat) <= 180 {
+			*lat, *lng = *lng, *lat
+			notes = append(notes, fmt.Sprintf("%s: latitude out of range, swapped lat/lng to (%v, %v)", what, *lat, *lng))
+			return nil
+		}
+		return fmt.Errorf("%s: coordinate (%v, %v) out of range", what, *lat, *lng)
+	}
+	for _, s := range r.Stops {
+		if err := fix(fmt.Sprintf("stop %d (%s)", s.ID, s.Name), &s.Latitude, &s.Longitude); err != nil {
+			return notes, err
+		}
+	}
+	for _, p := range r.Pins {
+		if err := fix(fmt.Sprintf("pin %d-%d", p.LeftStopID, p.RightStopID), &p.Latitude, &p.Longitude); err != nil {
+			return notes, err
+		}
+	}
+
+	// Plausibility radius around the median stop (robust to a few bad points)
+	lats := make([]float64, len(r.Stops))
+	lngs := make([]float64, len(r.Stops))
+	length := 0.0
+	for i, s := range r.Stops {
+		lats[i], lngs[i] = s.Latitude, s.Longitude
+		length += s.DistanceToNext
+	}
+	if r.TotalDistanceKM > length {
+		length = r.TotalDistanceKM
+	}
+	sort.Float64s(lats)
+	sort.Float64s(lngs)
+	centre := LatLng{Lat: lats[len(lats)/2], Lng: lngs[len(lngs)/2]}
+	radius := math.Max(minPlausibleRadiusKM, 2*length)
+	check := func(what string, lat, lng *float64) error {
+		d := HaversineKM(centre, LatLng{Lat: *lat, Lng: *lng})
+		if d <= radius {
+			return nil
+		}
+		if math.Abs(*lng) <= 90 && HaversineKM(centre, LatLng{Lat: *lng, Lng: *lat}) <= radius {
+			*lat, *lng = *lng, *lat
+			notes = append(notes, fmt.Sprintf("%s: swapped lat/lng to (%v, %v) to match the route", what, *lat, *lng))
+			return nil
+		}
+		return fmt.Errorf("%s: (%v, %v) lies %.1f km from the route centre (limit %.1f km)", what, *lat, *lng, d, radius)
+	}
+	for _, s := range r.Stops {
+		if err := check(fmt.Sprintf("stop %d (%s)", s.ID, s.Name), &s.Latitude, &s.Longitude); err != nil {
+			return notes, err
+		}
+	}
+	for _, p := range r.Pins {
+		if err := check(fmt.Sprintf("pin %d-%d", p.LeftStopID, p.RightStopID), &p.Latitude, &p.Longitude); err != nil {
+			return notes, err
+		}
+	}
+	return notes, nil
+}
@@ -4,7 +4,6 @@ import (
     "encoding/json"
     "fmt"
     "io"
-    "math"
     "math/rand"
 )
 
@@ -20,63 +19,122 @@ type FleetQuantity struct {
     Quantity int `json:"quantity"`
 }
 
+// Bounds of a bus type's capacity (passengers, seated plus standing).
+const (
+    MinBusCapacity = 1
+    MaxBusCapacity = 400
+)
+
+// FleetErrors lists every problem found in a fleet file, each naming its field.
+type FleetErrors []error
+
+func (e FleetErrors) Error() string { return listProblems("fleet", e) }
+
+// Unwrap lets errors.Is and errors.As look at the individual problems.
+func (e FleetErrors) Unwrap() []error { return e }
+
 // LoadFleetFromReader parses a fleet JSON file and returns types indexed by id and the requested quantities.
+// Unknown fields are rejected, and the contents are checked with ValidateFleet; no value is
+// corrected silently. Entries with quantity 0 are dropped from the returned quantities.
 func LoadFleetFromReader(r io.Reader) (map[int]*BusType, []FleetQuantity, error) {
     dec := json.NewDecoder(r)
+    dec.DisallowUnknownFields()
     var ff FleetFile
     if err := dec.Decode(&ff); err != nil {
         return nil, nil, fmt.Errorf("decode fleet: %w", err)
     }
+    if err := ValidateFleet(&ff); err != nil {
+        return nil, nil, err
+    }
     types := make(map[int]*BusType, len(ff.BusTypes))
     for i := range ff.BusTypes {
         bt := ff.BusTypes[i] // copy
-        // ensure sane values
-        if bt.Capacity < 1 { bt.Capacity = 60 }
-        if bt.CostPerKm < 0 { bt.CostPerKm = 0 }
         types[bt.ID] = &bt
     }
-    // filter out non-positive quantities
     q := make([]FleetQuantity, 0, len(ff.Fleet))
     for _, it := range ff.Fleet {
-        if it.Quantity > 0 && it.TypeID != 0 {
+        if it.Quantity > 0 {
             q = append(q, it)
         }
     }
     return types, q, nil
 }
 
-// randomSpeedForType returns a plausible average speed (km/h) for a bus type.
-// Uses a truncated normal distribution around a type-specific mean.
-func randomSpeedForType(rng *rand.Rand, t *BusType) float64 {
-    mean := 28.0
-    std := 3.5
-    // Light heuristic by type name/capacity
-    if t != nil {
-        if t.Capacity >= 120 { // articulated tend to be a bit slower
-            mean = 25.0
-            std = 3.0
-        } else if t.Capacity <= 70 {
-            mean = 28.0
-            std = 4.0
+// ValidateFleet checks a fleet file: bus type ids positive and unique, capacities within
+// [MinBusCapacity, MaxBusCapacity], costs not negative, speeds valid, every fleet entry naming a
+// declared type with a quantity >= 0, and at least one bus in total. It returns nil or
+// a FleetErrors with one entry per offending field.
+func ValidateFleet(ff *FleetFile) error {
+    var errs FleetErrors
+    add := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+    if len(ff.BusTypes) == 0 {
+        add("bus_types: no bus types declared")
+    }
+    declared := make(map[int]int, len(ff.BusTypes)) // type id -> index
+    for i, bt := range ff.BusTypes {
+        field := fmt.Sprintf("bus_types[%d]", i)
+        if bt.ID <= 0 {
+            add("%s.id: %d must be positive", field, bt.ID)
+        } else if j, dup := declared[bt.ID]; dup {
+            add("%s.id: duplicate type id %d, also declared by bus_types[%d]", field, bt.ID, j)
+        } else {
+            declared[bt.ID] = i
+        }
+        if bt.Capacity < MinBusCapacity || bt.Capacity > MaxBusCapacity {
+            add("%s.capacity: %d out of range [%d, %d]", field, bt.Capacity, MinBusCapacity, MaxBusCapacity)
+        }
+        if bt.Speed != nil {
+            if msg := bt.Speed.Valid(); msg != "" {
+                add("%s.speed: %s", field, msg)
+            }
         }
-        // name-based tweak
-        if t.Name != "" {
-            switch {
-            case containsFold(t.Name, "articulated"):
-                mean = 25.0
-                std = 3.0
-            case containsFold(t.Name, "standard"):
-                mean = 28.0
-                std = 4.0
+        for _, c := range []struct {
+            name string
+            v    float64
+        }{{"cost_per_km", bt.CostPerKm}, {"cost_per_hour", bt.CostPerHour}, {"fixed_cost_per_day", bt.FixedCostPerDay}} {
+            if c.v < 0 {
+                add("%s.%s: %v must not be negative", field, c.name, c.v)
             }
         }
     }
-    // sample truncated to [15, 45]
-    v := rng.NormFloat64()*std + mean
-    if v < 15 { v = 15 }
-    if v > 45 { v = 45 }
-    // round to one decimal for nicer display
-    return math.Round(v*10) / 10
+    total := 0
+    for i, it := range ff.Fleet {
+        field := fmt.Sprintf("fleet[%d]", i)
+        if _, ok := declared[it.TypeID]; !ok {
+            add("%s.type_id: unknown bus type %d", field, it.TypeID)
+        }
+        if it.Quantity < 0 {
+            add("%s.quantity: %d must not be negative", field, it.Quantity)
+        } else {
+            total += it.Quantity
+        }
+    }
+    if total == 0 {
+        add("fleet: total quantity is 0, deploy at least one bus")
+    }
+    if len(errs) == 0 {
+        return nil
+    }
+    return errs
+}
+
+// randomSpeedForType samples a plausible average speed (km/h) for a bus type: from the
+// speed of its fleet entry when set, else from its vehicle parameters (see VehicleDataset;
+// DefaultVehicleDataset when none were applied).
+func randomSpeedForType(rng *rand.Rand, t *BusType) float64 {
+    if t != nil && t.Speed != nil {
+        return t.Speed.Sample(rng)
+    }
+    var p VehicleParams
+    if t != nil && t.Params != nil {
+        p = *t.Params
+    } else {
+        p = DefaultVehicleDataset().For(t)
+    }
+    if p.Speed == nil {
+        p.Speed = DefaultVehicleDataset().Default.Speed
+    }
+    return p.Speed.Sample(rng)
 }
 
 // BuildFleetBuses creates concrete Bus instances according to fleet quantities.
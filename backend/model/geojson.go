@@ -0,0 +1,185 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// GeoJSON route input: a FeatureCollection with one Point feature per stop and an
+// optional LineString corridor. Stop properties: stop_id (or id), stop_name (or name),
+// allow_layover. The collection's or the LineString's "route"/"name" and "direction"
+// properties name the route. Stops are ordered along the corridor and their distances
+// derived from it; without a corridor, feature order and straight-line distances apply.
+
+type geoJSONCollection struct {
+	Type       string           `json:"type"`
+	Name       string           `json:"name"`
+	Properties map[string]any   `json:"properties"`
+	Features   []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string         `json:"type"`
+	Properties map[string]any `json:"properties"`
+	Geometry   struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// isGeoJSON reports whether b is a GeoJSON FeatureCollection.
+func isGeoJSON(b []byte) bool {
+	var probe struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(b, &probe) == nil && probe.Type == "FeatureCollection"
+}
+
+func propString(p map[string]any, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := p[k].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func propInt(p map[string]any, keys ...string) (int, bool) {
+	for _, k := range keys {
+		if v, ok := p[k].(float64); ok {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+// chainage projects pt onto the polyline and returns the distance along it (km) of the
+// closest point. cum holds the cumulative length at each vertex.
+func chainage(line []LatLng, cum []float64, pt LatLng) float64 {
+	best, at := math.MaxFloat64, 0.0
+	kx := math.Cos(pt.Lat * math.Pi / 180)
+	for i := 0; i+1 < len(line); i++ {
+		a, b := line[i], line[i+1]
+		// local equirectangular plane around pt
+		ax, ay := (a.Lng-pt.Lng)*kx, a.Lat-pt.Lat
+		bx, by := (b.Lng-pt.Lng)*kx, b.Lat-pt.Lat
+		dx, dy := bx-ax, by-ay
+		t := 0.0
+		if l2 := dx*dx + dy*dy; l2 > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l2))
+		}
+		px, py := ax+t*dx, ay+t*dy
+		if d := px*px + py*py; d < best {
+			best, at = d, cum[i]+t*(cum[i+1]-cum[i])
+		}
+	}
+	return at
+}
+
+func round3(x float64) float64 { return math.Round(x*1000) / 1000 }
+
+// loadGeoJSONRoute builds a Route from a GeoJSON FeatureCollection.
+func loadGeoJSONRoute(b []byte, id int) (*Route, error) {
+	var fc geoJSONCollection
+	if err := json.Unmarshal(b, &fc); err != nil {
+		return nil, fmt.Errorf("decode geojson route: %w", err)
+	}
+	route := &Route{ID: id, Name: fc.Name, UnitDistance: "kilometers"}
+	if n := propString(fc.Properties, "route", "name"); n != "" {
+		route.Name = n
+	}
+	route.Direction = propString(fc.Properties, "direction")
+
+	type stopAt struct {
+		stop *BusStop
+		km   float64
+	}
+	var stops []stopAt
+	var line []LatLng
+	for i, f := range fc.Features {
+		switch f.Geometry.Type {
+		case "Point":
+			var c []float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &c); err != nil || len(c) < 2 {
+				return nil, fmt.Errorf("feature %d: invalid Point coordinates", i)
+			}
+			sid, ok := propInt(f.Properties, "stop_id", "id")
+			if !ok {
+				return nil, fmt.Errorf("feature %d: stop without stop_id", i)
+			}
+			bs := &BusStop{ID: sid, Name: propString(f.Properties, "stop_name", "name"), RouteID: id, Latitude: c[1], Longitude: c[0], Zone: propString(f.Properties, "zone")}
+			if v, ok := f.Properties["allow_layover"].(bool); ok {
+				bs.AllowLayover = v
+			}
+			if n, ok := propInt(f.Properties, "platform_capacity"); ok {
+				bs.PlatformCapacity = n
+			}
+			if n, ok := propInt(f.Properties, "berths"); ok {
+				bs.Berths = n
+			}
+			stops = append(stops, stopAt{stop: bs})
+		case "LineString":
+			if line != nil {
+				return nil, fmt.Errorf("feature %d: more than one LineString corridor", i)
+			}
+			var cs [][]float64
+			if err := json.Unmarshal(f.Geometry.Coordinates, &cs); err != nil || len(cs) < 2 {
+				return nil, fmt.Errorf("feature %d: invalid LineString coordinates", i)
+			}
+			for _, c := range cs {
+				if len(c) < 2 {
+					return nil, fmt.Errorf("feature %d: invalid LineString position", i)
+				}
+				line = append(line, LatLng{Lat: c[1], Lng: c[0]})
+			}
+			if route.Name == "" {
+				route.Name = propString(f.Properties, "route", "name")
+			}
+			if route.Direction == "" {
+				route.Direction = propString(f.Properties, "direction")
+			}
+		}
+	}
+	if len(stops) < 2 {
+		return nil, fmt.Errorf("geojson route: need at least two stop Points, got %d", len(stops))
+	}
+
+	if line != nil {
+		cum := make([]float64, len(line))
+		for i := 1; i < len(line); i++ {
+			cum[i] = cum[i-1] + HaversineKM(line[i-1], line[i])
+		}
+		for i := range stops {
+			s := stops[i].stop
+			stops[i].km = chainage(line, cum, LatLng{Lat: s.Latitude, Lng: s.Longitude})
+		}
+		sort.SliceStable(stops, func(i, j int) bool { return stops[i].km < stops[j].km })
+		// Corridor vertices between two stops become pins so movement follows the road
+		for i := 0; i+1 < len(stops); i++ {
+			for v := range line {
+				if cum[v] > stops[i].km && cum[v] < stops[i+1].km {
+					route.Pins = append(route.Pins, &RoutePin{LeftStopID: stops[i].stop.ID, RightStopID: stops[i+1].stop.ID, Latitude: line[v].Lat, Longitude: line[v].Lng})
+				}
+			}
+		}
+	} else {
+		for i := 1; i < len(stops); i++ {
+			a, b := stops[i-1].stop, stops[i].stop
+			stops[i].km = stops[i-1].km + HaversineKM(LatLng{Lat: a.Latitude, Lng: a.Longitude}, LatLng{Lat: b.Latitude, Lng: b.Longitude})
+		}
+	}
+
+	var cumulative float64
+	for i, s := range stops {
+		if i+1 < len(stops) {
+			s.stop.DistanceToNext = round3(stops[i+1].km - s.km)
+		}
+		s.stop.CumulativeDist = cumulative
+		cumulative += s.stop.DistanceToNext
+		route.Stops = append(route.Stops, s.stop)
+	}
+	route.TotalDistanceKM = round3(cumulative)
+	return route, nil
+}
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// LatLng is a geographic point in degrees.
+type LatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// SegmentPath is the polyline between two adjacent stops (pins included),
+// parameterized by travelled length so t=0.5 is halfway along the road.
+type SegmentPath struct {
+	FromStopID int
+	ToStopID   int
+	Points     []LatLng
+	LengthKM   float64
+	cum        []float64 // cumulative length fraction at each point (0..1)
+}
+
+// At returns the position at fraction t (0..1) along the path.
+func (p *SegmentPath) At(t float64) LatLng {
+	if len(p.Points) == 0 {
+		return LatLng{}
+	}
+	if t <= 0 || len(p.Points) == 1 {
+		return p.Points[0]
+	}
+	if t >= 1 {
+		return p.Points[len(p.Points)-1]
+	}
+	i := sort.SearchFloat64s(p.cum, t)
+	if i == 0 {
+		return p.Points[0]
+	}
+	a, b := p.Points[i-1], p.Points[i]
+	span := p.cum[i] - p.cum[i-1]
+	f := 0.0
+	if span > 0 {
+		f = (t - p.cum[i-1]) / span
+	}
+	return LatLng{Lat: a.Lat + (b.Lat-a.Lat)*f, Lng: a.Lng + (b.Lng-a.Lng)*f}
+}
+
+// RouteGeometry caches segment paths of a route in both directions together with
+// memoized move-step samples, so interpolation is computed once per route rather
+// than per move step per bus. Safe for concurrent use.
+type RouteGeometry struct {
+	paths   map[[2]int]*SegmentPath
+	mu      sync.Mutex
+	samples map[[3]int][]LatLng
+}
+
+// NewRouteGeometry precomputes segment paths for every adjacent stop pair of r.
+func NewRouteGeometry(r *Route) *RouteGeometry {
+	g := &RouteGeometry{paths: make(map[[2]int]*SegmentPath), samples: make(map[[3]int][]LatLng)}
+	pinsByPair, _ := segmentPins(r)
+	for i := 0; i+1 < len(r.Stops); i++ {
+		a, b := r.Stops[i], r.Stops[i+1]
+		from, to := LatLng{Lat: a.Latitude, Lng: a.Longitude}, LatLng{Lat: b.Latitude, Lng: b.Longitude}
+		pts := []LatLng{from}
+		pts = append(pts, orderPins(from, to, pinsByPair[[2]int{a.ID, b.ID}])...)
+		pts = append(pts, to)
+		fwd := newSegmentPath(a.ID, b.ID, pts)
+		rev := make([]LatLng, len(pts))
+		for j := range pts {
+			rev[j] = pts[len(pts)-1-j]
+		}
+		g.paths[[2]int{a.ID, b.ID}] = fwd
+		g.paths[[2]int{b.ID, a.ID}] = newSegmentPath(b.ID, a.ID, rev)
+	}
+	return g
+}
+
+// segmentPins groups the pins of r by the adjacent stop pair (in route order) they
+// lie between. A pin may name its stops either way round; pins between stops that
+// are not adjacent cannot be placed and are returned as unused.
+func segmentPins(r *Route) (byPair map[[2]int][]LatLng, unused []*RoutePin) {
+	byPair = make(map[[2]int][]LatLng)
+	for _, p := range r.Pins {
+		li, ri := r.IndexOf(p.LeftStopID), r.IndexOf(p.RightStopID)
+		if li < 0 || ri < 0 || (ri-li != 1 && li-ri != 1) {
+			unused = append(unused, p)
+			continue
+		}
+		key := [2]int{p.LeftStopID, p.RightStopID}
+		if ri < li {
+			key = [2]int{p.RightStopID, p.LeftStopID}
+		}
+		byPair[key] = append(byPair[key], LatLng{Lat: p.Latitude, Lng: p.Longitude})
+	}
+	return byPair, unused
+}
+
+// orderPins returns the pins between stops a and b in travel order. Files list them
+// as they were digitized, which is usually but not always along the road: when sorting
+// them by their projection on the a-b chord gives a shorter polyline, that order wins.
+func orderPins(a, b LatLng, pins []LatLng) []LatLng {
+	if len(pins) < 2 {
+		return pins
+	}
+	// local plane: degrees of longitude shrink with latitude
+	kx := math.Cos(a.Lat * math.Pi / 180)
+	dx, dy := (b.Lng-a.Lng)*kx, b.Lat-a.Lat
+	proj := func(p LatLng) float64 { return (p.Lng-a.Lng)*kx*dx + (p.Lat-a.Lat)*dy }
+	sorted := append([]LatLng(nil), pins...)
+	sort.SliceStable(sorted, func(i, j int) bool { return proj(sorted[i]) < proj(sorted[j]) })
+	length := func(ps []LatLng) float64 {
+		km, prev := 0.0, a
+		for _, p := range ps {
+			km += HaversineKM(prev, p)
+			prev = p
+		}
+		return km + HaversineKM(prev, b)
+	}
+	if length(sorted) < length(pins) {
+		return sorted
+	}
+	return pins
+}
+
+func newSegmentPath(from, to int, pts []LatLng) *SegmentPath {
+	p := &SegmentPath{FromStopID: from, ToStopID: to, Points: pts, cum: make([]float64, len(pts))}
+	for i := 1; i < len(pts); i++ {
+		p.LengthKM += HaversineKM(pts[i-1], pts[i])
+		p.cum[i] = p.LengthKM
+	}
+	for i := range p.cum {
+		if p.LengthKM > 0 {
+			p.cum[i] /= p.LengthKM
+		} else {
+			p.cum[i] = float64(i) / math.Max(1, float64(len(pts)-1))
+		}
+	}
+	return p
+}
+
+// Path returns the segment path between two adjacent stops, or nil.
+func (g *RouteGeometry) Path(fromStopID, toStopID int) *SegmentPath {
+	return g.paths[[2]int{fromStopID, toStopID}]
+}
+
+// Samples returns the positions at t = k/steps (k = 1..steps) along the segment.
+// Results are memoized per (segment, steps); callers must not modify the slice.
+func (g *RouteGeometry) Samples(fromStopID, toStopID, steps int) []LatLng {
+	key := [3]int{fromStopID, toStopID, steps}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if s, ok := g.samples[key]; ok {
+		return s
+	}
+	p := g.Path(fromStopID, toStopID)
+	if p == nil || steps < 1 {
+		return nil
+	}
+	s := make([]LatLng, steps)
+	for k := 1; k <= steps; k++ {
+		s[k-1] = p.At(float64(k) / float64(steps))
+	}
+	g.samples[key] = s
+	return s
+}
+
+// HaversineKM returns the great-circle distance between two points in km.
+func HaversineKM(a, b LatLng) float64 {
+	const R = 6371.0088 // mean Earth radius km
+	dLat := (b.Lat - a.Lat) * math.Pi / 180
+	dLon := (b.Lng - a.Lng) * math.Pi / 180
+	la1 := a.Lat * math.Pi / 180
+	la2 := b.Lat * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(la1)*math.Cos(la2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return R * 2 * math.Atan2(math.Sqrt(h), math.Sqrt(1-h))
+}
@@ -14,6 +14,10 @@ type Passenger struct {
     WaitDuration      *float64   `json:"wait_duration_minutes,omitempty"` // (BoardingTime - ArrivalStopTime) in minutes
     DepartureTime     *time.Time `json:"departure_time,omitempty"`     // same as BoardingTime, explicit for clarity
     ArrivalDestTime   *time.Time `json:"arrival_destination_time,omitempty"` // when passenger alights at destination
+    BusID             int        `json:"bus_id,omitempty"`                   // bus the passenger boarded (0 = not boarded)
+    Seeded            bool       `json:"seeded,omitempty"`                   // created by initial seeding with a backdated arrival
+    PersonID          int        `json:"person_id,omitempty"`                // synthetic population member making this trip (0 = independent arrival)
+    Unserved          string     `json:"unserved,omitempty"`                 // why the passenger left without travelling (e.g. "stop_closed")
 }
 
 // MarkBoarded sets the boarding / departure time and computes wait duration.
@@ -42,3 +46,16 @@ func (p *Passenger) IsOnboard() bool {
 func (p *Passenger) Completed() bool {
     return p.ArrivalDestTime != nil
 }
+
+// Clone returns a copy of p whose time and wait pointers are not shared with p.
+func (p *Passenger) Clone() *Passenger {
+    if p == nil {
+        return nil
+    }
+    c := *p
+    if p.BoardingTime != nil { t := *p.BoardingTime; c.BoardingTime = &t }
+    if p.WaitDuration != nil { w := *p.WaitDuration; c.WaitDuration = &w }
+    if p.DepartureTime != nil { t := *p.DepartureTime; c.DepartureTime = &t }
+    if p.ArrivalDestTime != nil { t := *p.ArrivalDestTime; c.ArrivalDestTime = &t }
+    return &c
+}
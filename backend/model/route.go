@@ -1,5 +1,7 @@
 package model
 
+import "sync"
+
 // Route models an ordered sequence of bus stops in one direction.
 type Route struct {
     ID              int        `json:"id"`
@@ -9,6 +11,16 @@ type Route struct {
     UnitDistance    string     `json:"unit_distance"`
     Stops           []*BusStop `json:"stops"`
     Pins            []*RoutePin `json:"pins,omitempty"`
+    LoadNotes       []string   `json:"-"` // coordinate corrections applied while loading
+
+    geomOnce sync.Once
+    geom     *RouteGeometry
+}
+
+// Geometry returns the cached segment geometry, building it on first use.
+func (r *Route) Geometry() *RouteGeometry {
+    r.geomOnce.Do(func() { r.geom = NewRouteGeometry(r) })
+    return r.geom
 }
 
 // RoutePin is an intermediate geometry point between two stops.
@@ -39,6 +51,39 @@ func (r *Route) IndexOf(id int) int {
     return -1
 }
 
+// SegmentKM returns the length of the segment between the adjacent stops at route
+// indices from and to in that direction of travel: outbound (to = from+1) is the
+// distance_next_stop of from; inbound (to = from-1) is the distance_prev_stop of from,
+// or the outbound length of the segment when it is not set. Other pairs are 0.
+func (r *Route) SegmentKM(from, to int) float64 {
+    if from < 0 || to < 0 || from >= len(r.Stops) || to >= len(r.Stops) {
+        return 0
+    }
+    switch to - from {
+    case 1:
+        return r.Stops[from].DistanceToNext
+    case -1:
+        if d := r.Stops[from].DistanceToPrev; d > 0 {
+            return d
+        }
+        return r.Stops[to].DistanceToNext
+    }
+    return 0
+}
+
+// DirectionKM returns the end-to-end length travelled in dir ("outbound" or "inbound").
+func (r *Route) DirectionKM(dir string) float64 {
+    km := 0.0
+    for i := 0; i+1 < len(r.Stops); i++ {
+        if dir == "inbound" {
+            km += r.SegmentKM(i+1, i)
+        } else {
+            km += r.SegmentKM(i, i+1)
+        }
+    }
+    return km
+}
+
 // NextStopID returns id of next stop or 0.
 func (r *Route) NextStopID(current int) int {
     idx := r.IndexOf(current)
@@ -56,3 +101,30 @@ func (r *Route) PreviousStopID(current int) int {
     }
     return r.Stops[idx-1].ID
 }
+
+// Clone returns a deep copy of the route for an isolated run (one SSE connection or
+// one batch replication): stops and their queues, pins and load notes are copied.
+// The geometry cache is not shared; the copy rebuilds it on first use.
+func (r *Route) Clone() *Route {
+    if r == nil {
+        return nil
+    }
+    c := &Route{ID: r.ID, Name: r.Name, Direction: r.Direction, TotalDistanceKM: r.TotalDistanceKM, UnitDistance: r.UnitDistance}
+    if r.Stops != nil {
+        c.Stops = make([]*BusStop, len(r.Stops))
+        for i, s := range r.Stops {
+            c.Stops[i] = s.Clone()
+        }
+    }
+    if r.Pins != nil {
+        c.Pins = make([]*RoutePin, len(r.Pins))
+        for i, p := range r.Pins {
+            if p != nil {
+                pc := *p
+                c.Pins[i] = &pc
+            }
+        }
+    }
+    c.LoadNotes = append([]string(nil), r.LoadNotes...)
+    return c
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RouteIndex maps the layout of backend/data/routes.json: the corridors available on
+// disk, each a route file (native JSON or GeoJSON) named by a short id.
+type RouteIndex struct {
+	Default string       `json:"default"` // id of the corridor used when none is chosen (empty = the first)
+	Routes  []RouteEntry `json:"routes"`
+}
+
+// RouteEntry is one corridor of a RouteIndex.
+type RouteEntry struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	File        string `json:"file"` // relative to the index file
+	Description string `json:"description,omitempty"`
+}
+
+// LoadRouteIndexFromReader parses a corridor index. Ids must be present and unique,
+// every entry needs a file, and the default (if set) must be one of the ids.
+func LoadRouteIndexFromReader(r io.Reader) (*RouteIndex, error) {
+	var ix RouteIndex
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ix); err != nil {
+		return nil, fmt.Errorf("decode route index: %w", err)
+	}
+	if len(ix.Routes) == 0 {
+		return nil, fmt.Errorf("route index lists no routes")
+	}
+	seen := make(map[string]bool, len(ix.Routes))
+	for i, e := range ix.Routes {
+		switch {
+		case e.ID == "":
+			return nil, fmt.Errorf("route index: routes[%d] has no id", i)
+		case seen[e.ID]:
+			return nil, fmt.Errorf("route index: duplicate id %q", e.ID)
+		case e.File == "":
+			return nil, fmt.Errorf("route index: route %q has no file", e.ID)
+		}
+		seen[e.ID] = true
+	}
+	if ix.Default == "" {
+		ix.Default = ix.Routes[0].ID
+	} else if !seen[ix.Default] {
+		return nil, fmt.Errorf("route index: default %q is not a listed route", ix.Default)
+	}
+	return &ix, nil
+}
+
+// Lookup returns the corridor with id; the error names the known ids.
+func (ix *RouteIndex) Lookup(id string) (RouteEntry, error) {
+	ids := make([]string, len(ix.Routes))
+	for i, e := range ix.Routes {
+		if e.ID == id {
+			return e, nil
+		}
+		ids[i] = e.ID
+	}
+	return RouteEntry{}, fmt.Errorf("unknown route %q (available: %s)", id, strings.Join(ids, ", "))
+}
@@ -19,24 +19,59 @@ type rawRoute struct {
 type rawStop struct {
     StopID           int     `json:"stop_id"`
     StopName         string  `json:"stop_name"`
-    Lat              float64 `json:"latitute"`
-    Lng              float64 `json:"longtude"`
+    rawCoord
     DistanceNext     float64 `json:"distance_next_stop"`
+    DistancePrev     float64 `json:"distance_prev_stop"` // inbound, when it differs from the outbound segment
     AllowLayover     *bool   `json:"allow_layover"`
+    Zone             string  `json:"zone"`
+    PlatformCapacity int     `json:"platform_capacity"`
+    Berths           int     `json:"berths"`
 }
 
 type rawPin struct {
     LeftStopID  int     `json:"left_stop_id"`
     RightStopID int     `json:"right_stop_id"`
-    Lat         float64 `json:"latitute"`
-    Lng         float64 `json:"longtude"`
+    rawCoord
 }
 
 // LoadRouteFromReader parses a route JSON (kimara_kivukoni_stops.json format) and builds a Route struct.
+// A GeoJSON FeatureCollection (stop Points plus a LineString corridor) is accepted as well.
+// Coordinates may use the legacy "latitute"/"longtude" keys or latitude/longitude (lat/lng);
+// The route is checked with ValidateRoute, which reports every structural problem at once,
+// and its coordinates with NormalizeCoordinates, whose corrections end up in Route.LoadNotes.
 func LoadRouteFromReader(r io.Reader, id int) (*Route, error) {
-    dec := json.NewDecoder(r)
+    b, err := io.ReadAll(r)
+    if err != nil {
+        return nil, fmt.Errorf("read route: %w", err)
+    }
+    var route *Route
+    if isGeoJSON(b) {
+        route, err = loadGeoJSONRoute(b, id)
+    } else {
+        route, err = loadJSONRoute(b, id)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := ValidateRoute(route); err != nil {
+        return nil, err
+    }
+    notes, err := NormalizeCoordinates(route)
+    if err != nil {
+        return nil, fmt.Errorf("route coordinates: %w", err)
+    }
+    _, unused := segmentPins(route)
+    for _, p := range unused {
+        notes = append(notes, fmt.Sprintf("pin %d-%d ignored: its stops are not adjacent on the route", p.LeftStopID, p.RightStopID))
+    }
+    route.LoadNotes = notes
+    return route, nil
+}
+
+// loadJSONRoute builds a Route from the native route JSON layout.
+func loadJSONRoute(b []byte, id int) (*Route, error) {
     var raw rawRoute
-    if err := dec.Decode(&raw); err != nil {
+    if err := json.Unmarshal(b, &raw); err != nil {
         return nil, fmt.Errorf("decode route: %w", err)
     }
     route := &Route{
@@ -50,21 +85,39 @@ func LoadRouteFromReader(r io.Reader, id int) (*Route, error) {
     }
     var cumulative float64
     for _, s := range raw.Stops {
+        lat, lng, ok, err := s.resolve()
+        if err != nil {
+            return nil, fmt.Errorf("stop %d: %w", s.StopID, err)
+        }
+        if !ok {
+            return nil, fmt.Errorf("stop %d: missing latitude/longitude", s.StopID)
+        }
         bs := &BusStop{
             ID:             s.StopID,
             Name:           s.StopName,
             RouteID:        id,
-            Latitude:       s.Lat,
-            Longitude:      s.Lng,
+            Latitude:       lat,
+            Longitude:      lng,
             DistanceToNext: s.DistanceNext,
+            DistanceToPrev: s.DistancePrev,
             CumulativeDist: cumulative,
+            Zone:           s.Zone,
+            PlatformCapacity: s.PlatformCapacity,
+            Berths:         s.Berths,
         }
     if s.AllowLayover != nil { bs.AllowLayover = *s.AllowLayover }
         cumulative += s.DistanceNext
         route.Stops = append(route.Stops, bs)
     }
     for _, p := range raw.Pins {
-        rp := &RoutePin{LeftStopID: p.LeftStopID, RightStopID: p.RightStopID, Latitude: p.Lat, Longitude: p.Lng}
+        lat, lng, ok, err := p.resolve()
+        if err != nil {
+            return nil, fmt.Errorf("pin %d-%d: %w", p.LeftStopID, p.RightStopID, err)
+        }
+        if !ok {
+            return nil, fmt.Errorf("pin %d-%d: missing latitude/longitude", p.LeftStopID, p.RightStopID)
+        }
+        rp := &RoutePin{LeftStopID: p.LeftStopID, RightStopID: p.RightStopID, Latitude: lat, Longitude: lng}
         route.Pins = append(route.Pins, rp)
     }
     return route, nil
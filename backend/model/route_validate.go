@@ -0,0 +1,83 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RouteErrors lists every problem found in a route file, so one load reports all
+// of them rather than the first.
+type RouteErrors []error
+
+func (e RouteErrors) Error() string { return listProblems("route", e) }
+
+// Unwrap lets errors.Is and errors.As look at the individual problems.
+func (e RouteErrors) Unwrap() []error { return e }
+
+// listProblems formats the problems of an invalid input file, one per line.
+func listProblems(what string, errs []error) string {
+	if len(errs) == 1 {
+		return "invalid " + what + ": " + errs[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid %s (%d problems):", what, len(errs))
+	for _, err := range errs {
+		b.WriteString("\n  - ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// ValidateRoute checks the structure of a loaded route: at least two stops, unique
+// stop ids, coordinates present (a 0 latitude or longitude is taken as missing),
+// strictly increasing cumulative distances (every segment longer than 0 km, inbound
+// lengths not negative) and pins whose stops are on the route. It returns nil or a
+// RouteErrors. Coordinate plausibility is checked separately by NormalizeCoordinates.
+func ValidateRoute(r *Route) error {
+	var errs RouteErrors
+	add := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+	if len(r.Stops) < 2 {
+		add("route has %d stop(s), need at least 2", len(r.Stops))
+	}
+	seen := make(map[int]int, len(r.Stops))
+	for i, s := range r.Stops {
+		what := fmt.Sprintf("stop %d (%s, position %d)", s.ID, s.Name, i+1)
+		if s.ID <= 0 {
+			add("%s: stop_id must be positive", what)
+		} else if j, dup := seen[s.ID]; dup {
+			add("%s: duplicate stop_id, also used at position %d", what, j+1)
+		} else {
+			seen[s.ID] = i
+		}
+		if s.Latitude == 0 || s.Longitude == 0 {
+			add("%s: missing or zero coordinate (%v, %v)", what, s.Latitude, s.Longitude)
+		}
+		if i < len(r.Stops)-1 && !(s.DistanceToNext > 0) {
+			add("%s: distance_next_stop %v must be > 0, cumulative distance would not increase", what, s.DistanceToNext)
+		}
+		if s.DistanceToPrev < 0 {
+			add("%s: negative distance_prev_stop %v", what, s.DistanceToPrev)
+		}
+		if s.PlatformCapacity < 0 {
+			add("%s: negative platform_capacity %d", what, s.PlatformCapacity)
+		}
+		if s.Berths < 0 {
+			add("%s: negative berths %d", what, s.Berths)
+		}
+	}
+	for i, p := range r.Pins {
+		what := fmt.Sprintf("pin %d-%d (position %d)", p.LeftStopID, p.RightStopID, i+1)
+		for j, id := range []int{p.LeftStopID, p.RightStopID} {
+			if _, ok := seen[id]; !ok && (j == 0 || id != p.LeftStopID) {
+				add("%s: stop %d is not on the route", what, id)
+			}
+		}
+		if p.Latitude == 0 || p.Longitude == 0 {
+			add("%s: missing or zero coordinate (%v, %v)", what, p.Latitude, p.Longitude)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
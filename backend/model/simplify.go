@@ -0,0 +1,71 @@
+package model
+
+import "math"
+
+// SimplifyIndices runs Douglas-Peucker on pts and returns the indices of the points to
+// keep (always including both ends), so callers can carry per-point data such as
+// timestamps along. toleranceM is the maximum perpendicular deviation in meters;
+// toleranceM <= 0 keeps every point.
+func SimplifyIndices(pts []LatLng, toleranceM float64) []int {
+	n := len(pts)
+	if toleranceM <= 0 || n <= 2 {
+		idx := make([]int, n)
+		for i := range idx {
+			idx[i] = i
+		}
+		return idx
+	}
+	// Local metric plane around the first point (same approximation as the exporters)
+	const mPerDeg = 111320.0
+	k := math.Cos(pts[0].Lat * math.Pi / 180)
+	xy := func(p LatLng) (float64, float64) {
+		return (p.Lng - pts[0].Lng) * mPerDeg * k, (p.Lat - pts[0].Lat) * mPerDeg
+	}
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+	stack := [][2]int{{0, n - 1}}
+	for len(stack) > 0 {
+		seg := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		first, last := seg[0], seg[1]
+		ax, ay := xy(pts[first])
+		bx, by := xy(pts[last])
+		dx, dy := bx-ax, by-ay
+		l2 := dx*dx + dy*dy
+		far, farD := -1, toleranceM
+		for i := first + 1; i < last; i++ {
+			px, py := xy(pts[i])
+			var d float64
+			if l2 == 0 {
+				d = math.Hypot(px-ax, py-ay)
+			} else {
+				t := math.Max(0, math.Min(1, ((px-ax)*dx+(py-ay)*dy)/l2))
+				d = math.Hypot(px-(ax+t*dx), py-(ay+t*dy))
+			}
+			if d > farD {
+				far, farD = i, d
+			}
+		}
+		if far >= 0 {
+			keep[far] = true
+			stack = append(stack, [2]int{first, far}, [2]int{far, last})
+		}
+	}
+	idx := make([]int, 0, n)
+	for i, ok := range keep {
+		if ok {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// SimplifyPath returns pts reduced with Douglas-Peucker at toleranceM meters.
+func SimplifyPath(pts []LatLng, toleranceM float64) []LatLng {
+	idx := SimplifyIndices(pts, toleranceM)
+	out := make([]LatLng, len(idx))
+	for i, j := range idx {
+		out[i] = pts[j]
+	}
+	return out
+}
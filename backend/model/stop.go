@@ -10,6 +10,7 @@ type BusStop struct {
     Latitude        float64       `json:"latitute"`
     Longitude       float64       `json:"longtude"`
     DistanceToNext  float64       `json:"distance_next_stop"`
+    DistanceToPrev  float64       `json:"distance_prev_stop,omitempty"` // inbound km to the previous stop when the carriageways differ (0 = same as outbound)
     CumulativeDist  float64       `json:"cumulative_distance_km"`
     OutboundQueue   []*Passenger  `json:"outbound_queue,omitempty"`
     InboundQueue    []*Passenger  `json:"inbound_queue,omitempty"`
@@ -17,6 +18,9 @@ type BusStop struct {
     TotalBoarded    int           `json:"total_boarded"`
     TotalDepartures int           `json:"total_departures"` // passengers leaving the queue (boarded)
     AllowLayover   bool            `json:"allow_layover"`    // if true, buses can wait off the main road
+    Zone           string          `json:"zone,omitempty"`   // corridor zone for per-zone reporting (e.g. "Kimara-Ubungo")
+    PlatformCapacity int           `json:"platform_capacity,omitempty"` // most passengers the platform holds (0 = unlimited)
+    Berths         int             `json:"berths,omitempty"`            // buses the station serves at once (0 = unlimited)
 }
 
 // EnqueuePassenger adds a passenger to the correct directional queue and stamps arrival time if zero.
@@ -39,6 +43,12 @@ func (s *BusStop) EnqueuePassenger(p *Passenger, dir string, now time.Time) {
 
 // BoardAtStop boards passengers from the specified direction queue onto the bus.
 // Returns slice of boarded passengers.
+//
+// The queue is consumed from its head: only the passengers looked at (the boarded
+// ones and any skipped among them) are touched, the skipped ones are packed in front
+// of the untouched rest and the slice moves forward past the boarded, so a boarding
+// costs what it boards rather than the whole queue. Appends reuse or regrow the
+// backing array as usual, which drops the consumed head.
 func (s *BusStop) BoardAtStop(bus *Bus, now time.Time) []*Passenger {
     if bus == nil {
         return nil
@@ -58,15 +68,14 @@ func (s *BusStop) BoardAtStop(bus *Bus, now time.Time) []*Passenger {
         if bus.Type != nil && bus.PassengersOnboard >= bus.Type.Capacity { bus.IsFull = true }
         return nil
     }
-    boarded := make([]*Passenger, 0, remaining)
-    newQueue := make([]*Passenger, 0, len(*queue))
-    for _, p := range *queue {
-        if remaining <= 0 { // capacity reached, keep rest
-            newQueue = append(newQueue, p)
-            continue
-        }
-    if p.RouteID == bus.RouteID && p.StartStopID == s.ID && p.BoardingTime == nil && (p.Direction == "" || p.Direction == bus.Direction) {
+    q := *queue
+    boarded := make([]*Passenger, 0, min(remaining, len(q)))
+    scanned, kept := 0, 0 // q[:kept] are the skipped passengers so far
+    for ; scanned < len(q) && remaining > 0; scanned++ {
+        p := q[scanned]
+        if p.RouteID == bus.RouteID && p.StartStopID == s.ID && p.BoardingTime == nil && (p.Direction == "" || p.Direction == bus.Direction) {
             p.MarkBoarded(now)
+            p.BusID = bus.ID
             bus.Passengers = append(bus.Passengers, p)
             boarded = append(boarded, p)
             bus.TotalBoarded++
@@ -74,10 +83,15 @@ func (s *BusStop) BoardAtStop(bus *Bus, now time.Time) []*Passenger {
             s.TotalDepartures++
             remaining--
         } else {
-            newQueue = append(newQueue, p)
+            q[kept] = p
+            kept++
         }
     }
-    *queue = newQueue
+    // move the skipped passengers up against the unscanned rest and drop the head
+    head := scanned - kept
+    copy(q[head:scanned], q[:kept])
+    clear(q[:head])
+    *queue = q[head:]
     bus.PassengersOnboard = len(bus.Passengers)
     if bus.Type != nil && bus.PassengersOnboard >= bus.Type.Capacity {
         bus.IsFull = true
@@ -86,3 +100,26 @@ func (s *BusStop) BoardAtStop(bus *Bus, now time.Time) []*Passenger {
     }
     return boarded
 }
+
+// Clone returns a deep copy of the stop: queued passengers are cloned too, so
+// enqueueing or boarding on the copy never touches the original.
+func (s *BusStop) Clone() *BusStop {
+    if s == nil {
+        return nil
+    }
+    c := *s
+    c.OutboundQueue = clonePassengers(s.OutboundQueue)
+    c.InboundQueue = clonePassengers(s.InboundQueue)
+    return &c
+}
+
+func clonePassengers(ps []*Passenger) []*Passenger {
+    if ps == nil {
+        return nil
+    }
+    out := make([]*Passenger, len(ps))
+    for i, p := range ps {
+        out[i] = p.Clone()
+    }
+    return out
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// BenchmarkBoardAtStop drains a peak queue of n passengers with successive 70-seat
+// buses. Draining is linear in n when a boarding costs what it boards, so ns/passenger
+// stays flat as the queue grows; rebuilding the queue on every boarding made it grow
+// with n.
+func BenchmarkBoardAtStop(b *testing.B) {
+	for _, n := range []int{1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprintf("queue=%d", n), func(b *testing.B) {
+			bt := &BusType{ID: 1, Name: "Standard", Capacity: 70}
+			bus := &Bus{ID: 1, Type: bt, RouteID: 1, Direction: "outbound"}
+			stop := &BusStop{ID: 5, RouteID: 1}
+			now := time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC)
+			pax := make([]*Passenger, n)
+			for i := range pax {
+				pax[i] = &Passenger{ID: i + 1, RouteID: 1, StartStopID: stop.ID, EndStopID: 9, Direction: "outbound", ArrivalStopTime: now}
+			}
+			boarded := 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for _, p := range pax {
+					p.BoardingTime, p.DepartureTime, p.WaitDuration = nil, nil, nil
+				}
+				stop.OutboundQueue = append(stop.OutboundQueue[:0:0], pax...)
+				b.StartTimer()
+				for {
+					bus.Passengers, bus.PassengersOnboard = bus.Passengers[:0], 0
+					got := stop.BoardAtStop(bus, now)
+					if len(got) == 0 {
+						break
+					}
+					boarded += len(got)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(max(boarded, 1)), "ns/passenger")
+		})
+	}
+}
+
+func TestBoardAtStopKeepsSkippedInOrder(t *testing.T) {
+	stop := &BusStop{ID: 5, RouteID: 1}
+	now := time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC)
+	for i := 1; i <= 6; i++ {
+		dir := "outbound"
+		if i%2 == 0 && i < 6 {
+			dir = "inbound" // queued on the wrong side: never boards an outbound bus
+		}
+		stop.OutboundQueue = append(stop.OutboundQueue, &Passenger{ID: i, RouteID: 1, StartStopID: stop.ID, EndStopID: 9, Direction: dir, ArrivalStopTime: now})
+	}
+	ids := func(ps []*Passenger) []int {
+		out := make([]int, len(ps))
+		for i, p := range ps {
+			out[i] = p.ID
+		}
+		return out
+	}
+	bt := &BusType{ID: 1, Name: "Standard", Capacity: 3}
+
+	bus := &Bus{ID: 1, Type: bt, RouteID: 1, Direction: "outbound"}
+	got := stop.BoardAtStop(bus, now.Add(4*time.Minute))
+	if fmt.Sprint(ids(got)) != "[1 3 5]" || fmt.Sprint(ids(stop.OutboundQueue)) != "[2 4 6]" {
+		t.Fatalf("first bus boarded %v leaving %v, want [1 3 5] leaving [2 4 6]", ids(got), ids(stop.OutboundQueue))
+	}
+	if !bus.IsFull || *got[0].WaitDuration != 4 {
+		t.Errorf("full %v, first wait %v min; want a full bus and a 4 min wait", bus.IsFull, *got[0].WaitDuration)
+	}
+
+	bus = &Bus{ID: 2, Type: bt, RouteID: 1, Direction: "outbound"}
+	got = stop.BoardAtStop(bus, now.Add(9*time.Minute))
+	if fmt.Sprint(ids(got)) != "[6]" || fmt.Sprint(ids(stop.OutboundQueue)) != "[2 4]" {
+		t.Errorf("second bus boarded %v leaving %v, want [6] leaving [2 4]", ids(got), ids(stop.OutboundQueue))
+	}
+}
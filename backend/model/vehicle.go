@@ -0,0 +1,209 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+)
+
+// SpeedDist is a truncated normal distribution of a vehicle's average running speed.
+// In JSON it is an object, or a bare number for a fixed speed (std 0).
+type SpeedDist struct {
+	MeanKmph float64 `json:"mean_kmph"`
+	StdKmph  float64 `json:"std_kmph"`
+	MinKmph  float64 `json:"min_kmph"`
+	MaxKmph  float64 `json:"max_kmph"`
+}
+
+// minSampleKmph keeps a sampled speed positive when the distribution sets no minimum.
+const minSampleKmph = 1.0
+
+// UnmarshalJSON accepts {"mean_kmph": ..., ...} or a fixed speed such as 27.5.
+func (d *SpeedDist) UnmarshalJSON(b []byte) error {
+	var fixed float64
+	if err := json.Unmarshal(b, &fixed); err == nil {
+		*d = SpeedDist{MeanKmph: fixed}
+		return nil
+	}
+	type plain SpeedDist
+	return json.Unmarshal(b, (*plain)(d))
+}
+
+// Valid reports a problem with the distribution, or "" when it can be sampled.
+func (d SpeedDist) Valid() string {
+	switch {
+	case !(d.MeanKmph > 0):
+		return "mean_kmph must be > 0"
+	case d.StdKmph < 0:
+		return "std_kmph must not be negative"
+	case d.MinKmph < 0:
+		return "min_kmph must not be negative"
+	case d.MaxKmph > 0 && d.MaxKmph < d.MinKmph:
+		return "max_kmph is below min_kmph"
+	}
+	return ""
+}
+
+// Sample draws a speed rounded to one decimal.
+func (d SpeedDist) Sample(rng *rand.Rand) float64 {
+	v := rng.NormFloat64()*d.StdKmph + d.MeanKmph
+	if v < d.MinKmph {
+		v = d.MinKmph
+	}
+	if v < minSampleKmph {
+		v = minSampleKmph
+	}
+	if d.MaxKmph > 0 && v > d.MaxKmph {
+		v = d.MaxKmph
+	}
+	return math.Round(v*10) / 10
+}
+
+// EnergyParams overrides energy model coefficients for a vehicle type (zero fields
+// keep the model default).
+type EnergyParams struct {
+	BaseMassKg     float64 `json:"base_mass_kg,omitempty"`
+	MassPerPlaceKg float64 `json:"mass_per_place_kg,omitempty"`
+	RollingCoeff   float64 `json:"rolling_coeff,omitempty"`
+	DragAreaM2     float64 `json:"drag_area_m2,omitempty"`
+	DriveEff       float64 `json:"drive_eff,omitempty"`
+	RegenFrac      float64 `json:"regen_frac,omitempty"`
+	AuxKW          float64 `json:"aux_kw,omitempty"`
+}
+
+// VehicleParams are the per-type coefficients of the vehicle dataset. An entry applies
+// to a bus type by TypeID, else by NameContains (case-insensitive), else by capacity
+// range; unset fields fall back to the dataset default.
+type VehicleParams struct {
+	TypeID       int           `json:"type_id,omitempty"`
+	NameContains string        `json:"name_contains,omitempty"`
+	MinCapacity  int           `json:"min_capacity,omitempty"`
+	MaxCapacity  int           `json:"max_capacity,omitempty"`
+	Speed        *SpeedDist    `json:"speed,omitempty"`
+	CostPerKm    *float64      `json:"cost_per_km,omitempty"` // used when the fleet file gives no cost
+	CostPerHour  *float64      `json:"cost_per_hour,omitempty"`
+	FixedPerDay  *float64      `json:"fixed_cost_per_day,omitempty"`
+	Energy       *EnergyParams `json:"energy,omitempty"`
+	CO2GPerKm    *float64      `json:"co2_g_per_km,omitempty"`  // direct (tailpipe) emissions
+	CO2GPerKWh   *float64      `json:"co2_g_per_kwh,omitempty"` // emissions of the energy used (grid factor)
+}
+
+// VehicleDataset maps the layout of backend/data/vehicle_params.json.
+type VehicleDataset struct {
+	Default VehicleParams   `json:"default"`
+	Types   []VehicleParams `json:"types"`
+}
+
+// DefaultVehicleDataset is used when no dataset file is available. It reproduces the
+// original speed heuristics: 28±3.5 km/h, standard (or <= 70 places) 28±4,
+// articulated (or >= 120 places) 25±3, truncated to 15..45.
+func DefaultVehicleDataset() *VehicleDataset {
+	f := func(x float64) *float64 { return &x }
+	return &VehicleDataset{
+		Default: VehicleParams{Speed: &SpeedDist{MeanKmph: 28, StdKmph: 3.5, MinKmph: 15, MaxKmph: 45}, CostPerKm: f(1.75), CO2GPerKm: f(0), CO2GPerKWh: f(0)},
+		Types: []VehicleParams{
+			{NameContains: "articulated", Speed: &SpeedDist{MeanKmph: 25, StdKmph: 3, MinKmph: 15, MaxKmph: 45}},
+			{NameContains: "standard", Speed: &SpeedDist{MeanKmph: 28, StdKmph: 4, MinKmph: 15, MaxKmph: 45}},
+			{MinCapacity: 120, Speed: &SpeedDist{MeanKmph: 25, StdKmph: 3, MinKmph: 15, MaxKmph: 45}},
+			{MaxCapacity: 70, Speed: &SpeedDist{MeanKmph: 28, StdKmph: 4, MinKmph: 15, MaxKmph: 45}},
+		},
+	}
+}
+
+// LoadVehicleDatasetFromReader parses a vehicle parameters JSON file.
+func LoadVehicleDatasetFromReader(r io.Reader) (*VehicleDataset, error) {
+	var ds VehicleDataset
+	if err := json.NewDecoder(r).Decode(&ds); err != nil {
+		return nil, fmt.Errorf("decode vehicle params: %w", err)
+	}
+	if ds.Default.Speed == nil {
+		ds.Default.Speed = DefaultVehicleDataset().Default.Speed
+	}
+	for i, p := range append([]VehicleParams{ds.Default}, ds.Types...) {
+		if s := p.Speed; s != nil && (s.StdKmph < 0 || (s.MaxKmph > 0 && s.MaxKmph < s.MinKmph)) {
+			return nil, fmt.Errorf("vehicle params entry %d: invalid speed distribution", i)
+		}
+	}
+	return &ds, nil
+}
+
+// For resolves the parameters of bt: the best matching entry (type id, then name,
+// then capacity range, first match wins within a rank) over the default.
+func (ds *VehicleDataset) For(bt *BusType) VehicleParams {
+	out := ds.Default
+	if bt == nil {
+		return out
+	}
+	var match *VehicleParams
+	rank := 0
+	for i := range ds.Types {
+		p := &ds.Types[i]
+		r := 0
+		switch {
+		case p.TypeID != 0:
+			if p.TypeID == bt.ID {
+				r = 3
+			}
+		case p.NameContains != "":
+			if containsFold(bt.Name, p.NameContains) {
+				r = 2
+			}
+		case p.MinCapacity > 0 || p.MaxCapacity > 0:
+			if (p.MinCapacity == 0 || bt.Capacity >= p.MinCapacity) && (p.MaxCapacity == 0 || bt.Capacity <= p.MaxCapacity) {
+				r = 1
+			}
+		}
+		if r > rank {
+			match, rank = p, r
+		}
+	}
+	if match == nil {
+		return out
+	}
+	if match.Speed != nil {
+		out.Speed = match.Speed
+	}
+	if match.CostPerKm != nil {
+		out.CostPerKm = match.CostPerKm
+	}
+	if match.CostPerHour != nil {
+		out.CostPerHour = match.CostPerHour
+	}
+	if match.FixedPerDay != nil {
+		out.FixedPerDay = match.FixedPerDay
+	}
+	if match.Energy != nil {
+		out.Energy = match.Energy
+	}
+	if match.CO2GPerKm != nil {
+		out.CO2GPerKm = match.CO2GPerKm
+	}
+	if match.CO2GPerKWh != nil {
+		out.CO2GPerKWh = match.CO2GPerKWh
+	}
+	return out
+}
+
+// Apply attaches the resolved parameters to every type and fills in the dataset costs
+// for types whose fleet entry has none. A speed given in the fleet file wins over the
+// dataset's.
+func (ds *VehicleDataset) Apply(types map[int]*BusType) {
+	for _, bt := range types {
+		p := ds.For(bt)
+		if bt.Speed != nil {
+			p.Speed = bt.Speed
+		}
+		bt.Params = &p
+		if bt.CostPerKm == 0 && p.CostPerKm != nil {
+			bt.CostPerKm = *p.CostPerKm
+		}
+		if bt.CostPerHour == 0 && p.CostPerHour != nil {
+			bt.CostPerHour = *p.CostPerHour
+		}
+		if bt.FixedCostPerDay == 0 && p.FixedPerDay != nil {
+			bt.FixedCostPerDay = *p.FixedPerDay
+		}
+	}
+}
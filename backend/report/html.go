@@ -0,0 +1,175 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"time"
+
+	"brt08/backend/sim"
+)
+
+// chart is a bar chart laid out for inline SVG, so the page needs no scripts or
+// network access.
+type chart struct {
+	Title         string
+	Width, Height float64
+	Bars          []bar
+	Max           float64
+	Unit          string
+}
+
+type bar struct {
+	Label      string
+	Value      float64
+	X, Y, W, H float64
+	Color      string
+}
+
+const (
+	chartWidth  = 720
+	chartHeight = 220
+	chartBottom = 40 // room for labels
+)
+
+// newChart scales values to bars; color picks each bar's fill (nil = one color).
+func newChart(title, unit string, labels []string, values []float64, color func(float64) string) chart {
+	c := chart{Title: title, Unit: unit, Width: chartWidth, Height: chartHeight + chartBottom}
+	for _, v := range values {
+		if v > c.Max {
+			c.Max = v
+		}
+	}
+	if len(values) == 0 {
+		return c
+	}
+	slot := float64(chartWidth) / float64(len(values))
+	for i, v := range values {
+		h := 0.0
+		if c.Max > 0 {
+			h = v / c.Max * chartHeight
+		}
+		fill := "#4a7fb5"
+		if color != nil {
+			fill = color(v)
+		}
+		c.Bars = append(c.Bars, bar{Label: labels[i], Value: v, X: float64(i)*slot + slot*0.1, Y: chartHeight - h, W: slot * 0.8, H: h, Color: fill})
+	}
+	return c
+}
+
+// waitColor matches the map's stop coloring (green <= 5 min, amber <= 10, red beyond).
+func waitColor(min float64) string {
+	switch {
+	case min <= 5:
+		return "#52b788"
+	case min <= 10:
+		return "#f4a261"
+	}
+	return "#e63946"
+}
+
+var htmlTmpl = template.Must(template.New("report").Funcs(template.FuncMap{
+	"cell": func(v any) string {
+		if f, ok := v.(float64); ok {
+			return fmt.Sprintf("%.2f", f)
+		}
+		return fmt.Sprint(v)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em auto; max-width: 980px; color: #222; }
+h1 { font-size: 1.5em; } h2 { font-size: 1.15em; margin-top: 2em; }
+table { border-collapse: collapse; font-size: 0.9em; margin-top: 0.5em; }
+th, td { border: 1px solid #ccc; padding: 3px 8px; text-align: right; }
+th { background: #f0f0f0; } td:first-child, th:first-child { text-align: left; }
+svg text { font-size: 10px; fill: #444; }
+.note { color: #a33; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{.Generated}}</p>
+{{range .Notes}}<p class="note">{{.}}</p>
+{{end}}
+{{range .Charts}}<h2>{{.Title}}</h2>
+<svg width="{{.Width}}" height="{{.Height}}" role="img" aria-label="{{.Title}}">
+{{range .Bars}}<rect x="{{.X}}" y="{{.Y}}" width="{{.W}}" height="{{.H}}" fill="{{.Color}}"><title>{{.Label}}: {{printf "%.2f" .Value}}</title></rect>
+<text x="{{.X}}" y="235" transform="rotate(40 {{.X}} 235)">{{.Label}}</text>
+{{end}}<line x1="0" y1="220" x2="720" y2="220" stroke="#888"/>
+<text x="2" y="10">max {{printf "%.2f" .Max}} {{.Unit}}</text>
+</svg>
+{{end}}
+{{range .Tables}}<h2>{{.Name}}</h2>
+<table>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{cell .}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// writeHTML renders a standalone page: charts of the wait histogram, average wait by
+// stop and distance by bus, followed by every table.
+func writeHTML(w io.Writer, tables []table, sum sim.ReportSummary) error {
+	title := "BRT simulation report"
+	if sum.Label != "" {
+		title += " (" + sum.Label + ")"
+	}
+	page := struct {
+		Title, Generated string
+		Notes            []string
+		Charts           []chart
+		Tables           []table
+	}{Title: title, Generated: time.Now().Format("2006-01-02 15:04:05"), Tables: tables}
+	if sum.Stalled {
+		page.Notes = append(page.Notes, "Run stalled: "+sum.Diagnostic)
+	}
+	if sum.Capacity != "" {
+		page.Notes = append(page.Notes, "Capacity warning: "+sum.Capacity)
+	}
+	if sum.Aborted != "" {
+		page.Notes = append(page.Notes, "Partial report: "+sum.Aborted)
+	}
+
+	if sum.Wait != nil && len(sum.Wait.Overall.Histogram) > 0 {
+		var labels []string
+		var values []float64
+		for _, b := range sum.Wait.Overall.Histogram {
+			label := fmt.Sprintf("%.0f-%.0f", b.LoMin, b.HiMin)
+			if b.HiMin == 0 {
+				label = fmt.Sprintf("%.0f+", b.LoMin)
+			}
+			labels = append(labels, label+" min")
+			values = append(values, float64(b.Count))
+		}
+		page.Charts = append(page.Charts, newChart("Wait time distribution", "passengers", labels, values, nil))
+	}
+	if len(sum.Stops) > 0 {
+		var labels []string
+		var values []float64
+		for _, s := range sum.Stops {
+			labels = append(labels, s.Name)
+			values = append(values, s.AvgWaitMinutes)
+		}
+		page.Charts = append(page.Charts, newChart("Average wait by stop", "min", labels, values, waitColor))
+	}
+	for _, t := range tables {
+		if t.Name != "Buses" || len(t.Rows) == 0 {
+			continue
+		}
+		var labels []string
+		var values []float64
+		for _, r := range t.Rows {
+			labels = append(labels, fmt.Sprintf("bus %v", r[0]))
+			values = append(values, r[4].(float64))
+		}
+		page.Charts = append(page.Charts, newChart("Distance by bus", "km", labels, values, nil))
+	}
+	return htmlTmpl.Execute(w, page)
+}
@@ -0,0 +1,253 @@
+// Package report writes end-of-run reports in the format selected by the output path's
+// extension or an explicit format: CSV (sim.WriteCSVReport), an XLSX workbook with
+// summary, bus, bus type, stop, zone, headway, stop headway and wait sheets, or a standalone HTML page with charts.
+package report
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+// Supported formats.
+const (
+	CSV  = "csv"
+	XLSX = "xlsx"
+	HTML = "html"
+)
+
+// FormatFor resolves the report format: an explicit format wins, else the extension of
+// path (.xlsx, .html or .htm), else CSV.
+func FormatFor(path, format string) string {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xlsx":
+		return XLSX
+	case ".html", ".htm":
+		return HTML
+	}
+	return CSV
+}
+
+// Write writes the report of a finished run to path (a file or directory, timestamped
+// like the CSV report) and returns the file written ("" when path is empty).
+func Write(path, format string, buses []*model.Bus, sum sim.ReportSummary) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	var write func(io.Writer, []table, sim.ReportSummary) error
+	f := FormatFor(path, format)
+	switch f {
+	case CSV:
+		return sim.WriteCSVReport(path, buses, sum)
+	case XLSX:
+		write = func(w io.Writer, t []table, _ sim.ReportSummary) error { return writeXLSX(w, t) }
+	case HTML:
+		write = writeHTML
+	default:
+		return "", fmt.Errorf("unknown report format %q (want csv, xlsx or html)", f)
+	}
+	// a file path keeps its name but gets the format's extension
+	ext := "." + f
+	outPath := sim.TimestampedPath(path, "report", ext, time.Now().Format("20060102-150405"))
+	if e := filepath.Ext(outPath); !strings.EqualFold(e, ext) && !(f == HTML && strings.EqualFold(e, ".htm")) {
+		outPath = strings.TrimSuffix(outPath, e) + ext
+	}
+	out, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if err := write(out, tables(buses, sum), sum); err != nil {
+		return "", err
+	}
+	if err := out.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("report written", "path", outPath)
+	return outPath, nil
+}
+
+// table is one sheet of the workbook (and one section of the HTML page). Cells hold
+// strings, ints or float64s, so numbers stay numeric in the spreadsheet.
+type table struct {
+	Name   string
+	Header []string
+	Rows   [][]any
+}
+
+// tables lays out the report content shared by the XLSX and HTML writers; figures are
+// rounded like the CSV report.
+func tables(buses []*model.Bus, sum sim.ReportSummary) []table {
+	pr := sim.ReportPrecision
+	busT := table{Name: "Buses", Header: []string{"bus_id", "type", "direction", "avg_speed_kmph", "distance_km", "cost", "boarded", "alighted", "max_load", "driving_min", "dwell_min", "layover_min", "reposition_min", "utilization"}}
+	stats := make(map[int]sim.BusStats, len(sum.BusStats))
+	for _, s := range sum.BusStats {
+		stats[s.BusID] = s
+	}
+	totalDist, totalCost := 0.0, 0.0
+	hours := sim.ServiceHours(sum.BusStats)
+	for _, b := range buses {
+		typeName := ""
+		if b.Type != nil {
+			typeName = b.Type.Name
+		}
+		d, c := pr.BusCost(sum.BusDistance[b.ID], hours[b.ID], b.Type, true)
+		totalDist += d
+		totalCost += c
+		row := []any{b.ID, typeName, b.Direction, b.AverageSpeedKmph, d, c, b.TotalBoarded, b.TotalAlighted, "", "", "", "", "", ""}
+		if s, ok := stats[b.ID]; ok {
+			row[6], row[7], row[8] = s.Boarded, s.Alighted, s.MaxLoad
+			row[9], row[10], row[11], row[12], row[13] = pr.Minutes(s.DrivingSec/60, true), pr.Minutes(s.DwellSec/60, true), pr.Minutes(s.LayoverSec/60, true), pr.Minutes(s.RepositionSec/60, true), s.Utilization
+		}
+		busT.Rows = append(busT.Rows, row)
+	}
+
+	summary := table{Name: "Summary", Header: []string{"key", "value"}}
+	add := func(k string, v any) { summary.Rows = append(summary.Rows, []any{k, v}) }
+	if sum.Label != "" {
+		add("driver", sum.Label)
+	}
+	add("generated", sum.Generated)
+	add("served", sum.Served)
+	add("avg_wait_min", pr.Minutes(sum.AvgWaitMin, true))
+	if sum.Wait != nil {
+		add("wait_p90_min", pr.Minutes(sum.Wait.Overall.P90, true))
+	}
+	add("buses", len(buses))
+	add("total_distance_km", totalDist)
+	add("total_cost", pr.Currency(totalCost, true))
+	if f := sim.FleetTime(sum.BusStats); f.InServiceSec > 0 {
+		add("fleet_time_utilization", f.Utilization)
+	}
+	if ew := sum.ExcessWait; ew != nil {
+		add("excess_wait_min", pr.Minutes(ew.Overall.ExcessWaitMin, true))
+	}
+	if q := sum.Quality; q != nil {
+		add("quality_score", q.Score)
+	}
+	if sum.EnergyKWh > 0 {
+		add("energy_kwh", sum.EnergyKWh)
+		add("co2_kg", sum.CO2Kg)
+	}
+	if sum.Criterion != "" {
+		add("stop_criterion", sum.Criterion)
+		add("ended_by", sum.EndedBy)
+	}
+	if sum.Stalled {
+		add("diagnostic", sum.Diagnostic)
+	}
+	if sum.Capacity != "" {
+		add("capacity", sum.Capacity)
+	}
+	if sum.Aborted != "" {
+		add("aborted", "partial report: "+sum.Aborted)
+	}
+	for _, kv := range sim.MetadataRows(sum.Metadata) {
+		add("meta."+kv[0], kv[1])
+	}
+
+	typeT := table{Name: "Bus types", Header: []string{"type_id", "type", "buses", "capacity", "distance_km", "service_hours", "cost", "distance_cost", "time_cost", "fixed_cost", "carried", "avg_load", "avg_occupancy"}}
+	for _, t := range sim.TypeBreakdown(buses, sum.BusStats, sum.BusDistance, true) {
+		typeT.Rows = append(typeT.Rows, []any{t.TypeID, t.TypeName, t.Buses, t.Capacity, t.DistanceKM, t.ServiceHours, t.Cost, t.DistanceCost, t.TimeCost, t.FixedCost, t.Boarded, t.AvgLoad, t.AvgOccupancy})
+	}
+
+	stopT := table{Name: "Stops", Header: []string{"stop_id", "stop_name", "arrivals", "boarded", "denied", "avg_wait_min", "max_queue", "remaining_outbound", "remaining_inbound"}}
+	for _, s := range sum.Stops {
+		stopT.Rows = append(stopT.Rows, []any{s.StopID, s.Name, s.ArrivalsGenerated, s.Boarded, s.Denied, pr.Minutes(s.AvgWaitMinutes, true), s.MaxQueue, s.RemainingOutbound, s.RemainingInbound})
+	}
+
+	out := []table{summary, busT, typeT, stopT}
+	if len(sum.Directions) > 0 {
+		dirT := table{Name: "Directions", Header: []string{"direction", "generated", "served", "avg_wait_min", "passenger_km"}}
+		for _, d := range sum.Directions {
+			dirT.Rows = append(dirT.Rows, []any{d.Direction, d.Generated, d.Served, pr.Minutes(d.AvgWaitMin, true), d.PassengerKM})
+		}
+		out = append(out, dirT)
+	}
+	if ew := sum.ExcessWait; ew != nil {
+		ewT := table{Name: "Excess wait", Header: []string{"scope", "stop_id", "stop_name", "boardings", "avg_wait_min", "scheduled_wait_min", "excess_wait_min"}}
+		row := func(scope string, stopID any, e sim.ExcessWait) {
+			ewT.Rows = append(ewT.Rows, []any{scope, stopID, e.Name, e.Boardings, pr.Minutes(e.AvgWaitMin, true), pr.Minutes(e.ScheduledWaitMin, true), pr.Minutes(e.ExcessWaitMin, true)})
+		}
+		row("overall", "", ew.Overall)
+		for _, e := range ew.ByStop {
+			row("stop", e.StopID, e)
+		}
+		out = append(out, ewT)
+	}
+	if len(sum.Zones) > 0 {
+		zoneT := table{Name: "Zones", Header: []string{"zone", "stops", "arrivals", "boarded", "alighted", "denied", "ridership", "avg_wait_min", "departures", "avg_load", "max_load", "avg_occupancy"}}
+		for _, z := range sum.Zones {
+			zoneT.Rows = append(zoneT.Rows, []any{z.Zone, z.Stops, z.Arrivals, z.Boarded, z.Alighted, z.Denied, z.Ridership, pr.Minutes(z.AvgWaitMin, true), z.Departures, z.AvgLoad, z.MaxLoad, z.Occupancy})
+		}
+		out = append(out, zoneT)
+	}
+	if len(sum.Periods) > 0 {
+		perT := table{Name: "Periods", Header: []string{"period_id", "period", "start", "end", "multiplier", "favored_direction", "generated", "boarded", "served", "avg_wait_min", "p90_wait_min", "departures", "avg_load", "load_factor"}}
+		for _, p := range sum.Periods {
+			perT.Rows = append(perT.Rows, []any{p.PeriodID, p.Name, p.Start, p.End, p.Multiplier, p.Favored, p.Generated, p.Boarded, p.Served, pr.Minutes(p.Wait.Mean, true), pr.Minutes(p.Wait.P90, true), p.Departures, p.AvgLoad, p.LoadFactor})
+		}
+		out = append(out, perT)
+	}
+	if len(sum.Headway) > 0 {
+		hwT := table{Name: "Headway", Header: []string{"period_id", "period", "target_min", "tolerance", "headways", "within", "bunched", "gapped", "adherence", "mean_min"}}
+		for _, h := range sum.Headway {
+			hwT.Rows = append(hwT.Rows, []any{h.PeriodID, h.Period, h.TargetMin, h.Tolerance, h.Headways, h.Within, h.Bunched, h.Gapped, h.Adherence, pr.Minutes(h.MeanMin, true)})
+		}
+		out = append(out, hwT)
+	}
+	if len(sum.StopHeadways) > 0 {
+		shT := table{Name: "Stop headways", Header: []string{"stop_id", "stop_name", "direction", "headways", "mean_min", "stddev_min", "cv"}}
+		for _, h := range sum.StopHeadways {
+			shT.Rows = append(shT.Rows, []any{h.StopID, h.Name, h.Direction, h.Count, pr.Minutes(h.MeanMin, true), pr.Minutes(h.StdDevMin, true), h.CV})
+		}
+		out = append(out, shT)
+	}
+	if fleet := sim.FleetTripTimes(sum.BusStats); len(fleet) > 0 {
+		tripT := table{Name: "Trip times", Header: []string{"scope", "bus_id", "direction", "trips", "trip_min_min", "trip_mean_min", "trip_max_min", "cycles", "cycle_min_min", "cycle_mean_min", "cycle_max_min"}}
+		row := func(scope string, busID any, tt sim.TripTimes) {
+			m := func(x float64) float64 { return pr.Minutes(x, true) }
+			tr, c := tt.Trip, tt.Cycle
+			tripT.Rows = append(tripT.Rows, []any{scope, busID, tt.Direction, tr.Count, m(tr.MinMin), m(tr.MeanMin), m(tr.MaxMin), c.Count, m(c.MinMin), m(c.MeanMin), m(c.MaxMin)})
+		}
+		for _, tt := range fleet {
+			row("fleet", "", tt)
+		}
+		for _, b := range buses {
+			for _, tt := range stats[b.ID].TripTimes {
+				row("bus", b.ID, tt)
+			}
+		}
+		out = append(out, tripT)
+	}
+	if sum.Wait != nil {
+		waitT := table{Name: "Wait", Header: []string{"scope", "key", "count", "mean_min", "p50_min", "p90_min", "p95_min", "max_min"}}
+		row := func(scope, key string, wp sim.WaitPercentiles) {
+			m := func(x float64) float64 { return pr.Minutes(x, true) }
+			waitT.Rows = append(waitT.Rows, []any{scope, key, wp.Count, m(wp.Mean), m(wp.P50), m(wp.P90), m(wp.P95), m(wp.Max)})
+		}
+		row("overall", "", sum.Wait.Overall)
+		for _, dir := range []string{"outbound", "inbound"} {
+			if wp, ok := sum.Wait.ByDirection[dir]; ok {
+				row("direction", dir, wp)
+			}
+		}
+		for _, s := range sum.Stops {
+			if wp, ok := sum.Wait.ByStop[s.StopID]; ok {
+				row("stop", fmt.Sprint(s.StopID), wp)
+			}
+		}
+		out = append(out, waitT)
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// streamRetryAfter is the Retry-After sent with a rejected stream: about how long a
+// short preview run takes to free its slot.
+const streamRetryAfter = 10 * time.Second
+
+// acquireStream takes a simulation slot, failing when MaxStreams runs are active.
+// Every SSE, NDJSON and gRPC run holds one (a gRPC run from StartRun on), since each
+// spawns a goroutine per bus plus the generators.
+func (s *Server) acquireStream() bool {
+	for {
+		n := s.activeStreams.Load()
+		if s.Opt.MaxStreams > 0 && n >= int64(s.Opt.MaxStreams) {
+			s.rejectedStreams.Add(1)
+			return false
+		}
+		if s.activeStreams.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+func (s *Server) releaseStream() { s.activeStreams.Add(-1) }
+
+// rejectStream answers a stream request over the limit with 429 and a Retry-After.
+func rejectStream(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Retry-After", strconv.Itoa(int(streamRetryAfter/time.Second)))
+	http.Error(w, "too many simulations running; retry later", http.StatusTooManyRequests)
+}
+
+// handleStatus reports the current load: active and pending runs against the limit,
+// rejected requests since start, goroutines and uptime.
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	ids := []string{}
+	s.streamControls.Range(func(k, _ any) bool {
+		if _, pending := s.pendingRuns.Load(k); !pending {
+			ids = append(ids, k.(string))
+		}
+		return true
+	})
+	sort.Strings(ids)
+	pending := 0
+	s.pendingRuns.Range(func(_, _ any) bool { pending++; return true })
+	active := s.activeStreams.Load()
+	available := -1 // unlimited
+	if s.Opt.MaxStreams > 0 {
+		available = max(s.Opt.MaxStreams-int(active), 0)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(map[string]any{
+		"active_streams":    active,
+		"max_streams":       s.Opt.MaxStreams,
+		"available_streams": available,
+		"pending_grpc_runs": pending,
+		"rejected_streams":  s.rejectedStreams.Load(),
+		"streams":           ids,
+		"goroutines":        runtime.NumGoroutine(),
+		"uptime_s":          int64(time.Since(s.started).Seconds()),
+	})
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chaos injects faults into SSE delivery to exercise the cleanup paths: writes are
+// randomly delayed (a slow client, back-pressuring the runner) or dropped, and streams
+// are cut as if the client had disconnected (EventSource then reconnects on its own).
+// Every run must still terminate and write its reports.
+type Chaos struct {
+	DropProb       float64       // probability an event is not written
+	DelayProb      float64       // probability a write is delayed
+	MaxDelay       time.Duration // upper bound of an injected delay
+	DisconnectProb float64       // probability, per event, that the stream is cut
+}
+
+// Enabled reports whether any fault is configured.
+func (c Chaos) Enabled() bool { return c.DropProb > 0 || c.DelayProb > 0 || c.DisconnectProb > 0 }
+
+// ParseChaos parses "drop=0.05,delay=0.2,max_delay=300ms,disconnect=0.001".
+func ParseChaos(spec string) (Chaos, error) {
+	c := Chaos{MaxDelay: 250 * time.Millisecond}
+	if strings.TrimSpace(spec) == "" {
+		return c, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return c, fmt.Errorf("chaos: %q: want key=value", part)
+		}
+		if k == "max_delay" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return c, fmt.Errorf("chaos: invalid max_delay %q", v)
+			}
+			c.MaxDelay = d
+			continue
+		}
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || p < 0 || p > 1 {
+			return c, fmt.Errorf("chaos: %s must be a probability in [0,1], got %q", k, v)
+		}
+		switch k {
+		case "drop":
+			c.DropProb = p
+		case "delay":
+			c.DelayProb = p
+		case "disconnect":
+			c.DisconnectProb = p
+		default:
+			return c, fmt.Errorf("chaos: unknown key %q (want drop, delay, max_delay, disconnect)", k)
+		}
+	}
+	return c, nil
+}
+
+// chaosConn applies a Chaos config to one stream and counts what it injected.
+type chaosConn struct {
+	cfg Chaos
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	dropped, delayed int
+	disconnected     bool
+}
+
+func newChaosConn(cfg Chaos, seed int64) *chaosConn {
+	return &chaosConn{cfg: cfg, rng: rand.New(rand.NewSource(seed))}
+}
+
+// before decides the fate of the next write: it may sleep, and returns whether to
+// drop the event and whether to cut the stream instead of writing.
+func (c *chaosConn) before() (drop, disconnect bool) {
+	if c == nil {
+		return false, false
+	}
+	c.mu.Lock()
+	if c.cfg.DisconnectProb > 0 && c.rng.Float64() < c.cfg.DisconnectProb {
+		c.disconnected = true
+		c.mu.Unlock()
+		return false, true
+	}
+	if c.cfg.DropProb > 0 && c.rng.Float64() < c.cfg.DropProb {
+		c.dropped++
+		c.mu.Unlock()
+		return true, false
+	}
+	var delay time.Duration
+	if c.cfg.DelayProb > 0 && c.cfg.MaxDelay > 0 && c.rng.Float64() < c.cfg.DelayProb {
+		c.delayed++
+		delay = time.Duration(c.rng.Int63n(int64(c.cfg.MaxDelay)) + 1)
+	}
+	c.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return false, false
+}
+
+func (c *chaosConn) counts() (dropped, delayed int, disconnected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dropped, c.delayed, c.disconnected
+}
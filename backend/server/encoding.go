@@ -0,0 +1,37 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// streamEncoding frames the events of a stream on the wire.
+type streamEncoding struct {
+	contentType string
+	write       func(w io.Writer, event string, data []byte) error
+}
+
+// sseEncoding is the Server-Sent Events framing of /api/stream.
+var sseEncoding = streamEncoding{contentType: "text/event-stream", write: func(w io.Writer, event string, data []byte) error {
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}}
+
+// ndjsonEncoding is the newline-delimited JSON of /api/stream.ndjson: one
+// {"event": name, "data": payload} object per line, for curl pipes, log shippers and
+// clients without an SSE library.
+var ndjsonEncoding = streamEncoding{contentType: "application/x-ndjson", write: func(w io.Writer, event string, data []byte) error {
+	name, _ := json.Marshal(event)
+	_, err := fmt.Fprintf(w, "{\"event\":%s,\"data\":%s}\n", name, data)
+	return err
+}}
+
+// encodingFor picks the framing from the request path.
+func encodingFor(path string) streamEncoding {
+	if strings.HasSuffix(path, ".ndjson") {
+		return ndjsonEncoding
+	}
+	return sseEncoding
+}
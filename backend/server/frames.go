@@ -0,0 +1,82 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"brt08/backend/sim"
+)
+
+// frameBuffer coalesces bus moves and stop queue updates into one "frame" event per
+// interval of wall time, keeping only the latest of each bus and stop. Any other event
+// first sends the pending frame, so clients see events in their original order. A nil
+// buffer (frames off) coalesces nothing. Caller must ensure synchronization.
+type frameBuffer struct {
+	every  time.Duration
+	last   time.Time
+	moves  map[int]map[string]any
+	stops  map[int]map[string]any
+	merged int // events held in the pending frame
+}
+
+func newFrameBuffer(every time.Duration) *frameBuffer {
+	if every <= 0 {
+		return nil
+	}
+	return &frameBuffer{every: every, moves: make(map[int]map[string]any), stops: make(map[int]map[string]any)}
+}
+
+// coalesces reports whether e goes into frames rather than out on its own.
+func (f *frameBuffer) coalesces(e sim.Event) bool {
+	if f == nil {
+		return false
+	}
+	switch e.(type) {
+	case sim.MoveEvent, sim.StopUpdateEvent:
+		return true
+	}
+	return false
+}
+
+func (f *frameBuffer) addMove(busID int, payload map[string]any) {
+	f.moves[busID] = payload
+	f.merged++
+}
+
+func (f *frameBuffer) addStop(stopID int, payload map[string]any) {
+	f.stops[stopID] = payload
+	f.merged++
+}
+
+// pending reports whether a frame holds unsent events.
+func (f *frameBuffer) pending() bool { return f != nil && f.merged > 0 }
+
+// due reports whether the pending frame should go out at now.
+func (f *frameBuffer) due(now time.Time) bool { return f.pending() && now.Sub(f.last) >= f.every }
+
+// take returns the "frame" payload (latest move per bus and queues per stop, by id)
+// and starts the next frame at now.
+func (f *frameBuffer) take(now time.Time) map[string]any {
+	moves := make([]map[string]any, 0, len(f.moves))
+	for _, id := range sortedKeys(f.moves) {
+		moves = append(moves, f.moves[id])
+	}
+	stops := make([]map[string]any, 0, len(f.stops))
+	for _, id := range sortedKeys(f.stops) {
+		stops = append(stops, f.stops[id])
+	}
+	out := map[string]any{"moves": moves, "stop_updates": stops, "merged": f.merged}
+	f.moves, f.stops = make(map[int]map[string]any), make(map[int]map[string]any)
+	f.merged = 0
+	f.last = now
+	return out
+}
+
+func sortedKeys(m map[int]map[string]any) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"brt08/backend/grpcapi"
+	"brt08/backend/sim"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// pendingRunTTL is how long a run started over gRPC waits for StreamEvents.
+const pendingRunTTL = time.Minute
+
+// pendingRun is a run prepared by StartRun that has no event stream yet.
+type pendingRun struct {
+	opt  Options
+	q    url.Values
+	ctrl *connControl
+}
+
+// typedEvents maps the SSE event names with a typed message to their Event field.
+var typedEvents = map[string]string{"move": "move", "arrive": "arrive", "stop_update": "stop_update", "metrics": "metrics", "done": "done"}
+
+// grpcSimulation is the handler type of the Simulation service.
+type grpcSimulation interface {
+	startRun(ctx context.Context, req *dynamicpb.Message) (*dynamicpb.Message, error)
+	streamEvents(req *dynamicpb.Message, stream grpc.ServerStream) error
+	control(ctx context.Context, req *dynamicpb.Message) (*dynamicpb.Message, error)
+}
+
+type grpcService struct{ s *Server }
+
+// RegisterGRPC adds the Simulation service of grpcapi/sim.proto to g. Its runs are
+// the server's streams: they share the controls, live statistics, run history,
+// reports and event sink of the SSE API, and a run id is a conn_id.
+func (s *Server) RegisterGRPC(g *grpc.Server) {
+	g.RegisterService(&simulationDesc, &grpcService{s: s})
+}
+
+// NewGRPCServer returns a gRPC server with the Simulation service whose event
+// streams also end when ctx is done, as SSE streams do on shutdown, so that
+// GracefulStop waits only for their final events and reports.
+func (s *Server) NewGRPCServer(ctx context.Context, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		sctx, cancel := context.WithCancel(ss.Context())
+		defer cancel()
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
+		return handler(srv, &serverStream{ServerStream: ss, ctx: sctx})
+	}))
+	g := grpc.NewServer(opts...)
+	s.RegisterGRPC(g)
+	return g
+}
+
+// serverStream overrides the context of a grpc.ServerStream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+func unaryHandler(method, in string, call func(grpcSimulation, context.Context, *dynamicpb.Message) (*dynamicpb.Message, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{MethodName: method, Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := grpcapi.New(in)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		h := func(ctx context.Context, req any) (any, error) {
+			return call(srv.(grpcSimulation), ctx, req.(*dynamicpb.Message))
+		}
+		if interceptor == nil {
+			return h(ctx, req)
+		}
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcapi.ServiceName + "/" + method}, h)
+	}}
+}
+
+var simulationDesc = grpc.ServiceDesc{
+	ServiceName: grpcapi.ServiceName,
+	HandlerType: (*grpcSimulation)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler(grpcapi.StartRun, "StartRunRequest", grpcSimulation.startRun),
+		unaryHandler(grpcapi.Control, "ControlRequest", grpcSimulation.control),
+	},
+	Streams: []grpc.StreamDesc{{StreamName: grpcapi.StreamEvents, ServerStreams: true, Handler: func(srv any, stream grpc.ServerStream) error {
+		req := grpcapi.New("StreamEventsRequest")
+		if err := stream.RecvMsg(req); err != nil {
+			return err
+		}
+		return srv.(grpcSimulation).streamEvents(req, stream)
+	}}},
+	Metadata: "brt08/v1/sim.proto",
+}
+
+// startRun turns the request into the query of an SSE stream, so both APIs accept
+// the same overrides, and parks the run until its events are streamed.
+func (g *grpcService) startRun(ctx context.Context, req *dynamicpb.Message) (*dynamicpb.Message, error) {
+	q := url.Values{}
+	for _, name := range []string{"period", "passenger_cap", "seed", "max_trips"} {
+		if v := grpcapi.Get(req, name).Int(); v != 0 {
+			q.Set(name, strconv.FormatInt(v, 10))
+		}
+	}
+	for _, name := range []string{"sim_hours", "dir_bias", "spatial_gradient", "baseline_demand", "lambda", "speed", "arrival_factor", "max_rate"} {
+		if v := grpcapi.Get(req, name).Float(); v != 0 {
+			q.Set(name, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+	}
+	if grpcapi.Get(req, "max_speed").Bool() {
+		q.Set("speed", "max")
+	}
+	for _, name := range []string{"route", "start"} {
+		if v := grpcapi.Get(req, name).String(); v != "" {
+			q.Set(name, v)
+		}
+	}
+	opt, err := g.s.streamOptions(q)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !g.s.acquireStream() {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many simulations running; retry in %s", streamRetryAfter)
+	}
+	id := newConnID()
+	ctrl := g.s.newConnControl(q)
+	g.s.pendingRuns.Store(id, &pendingRun{opt: opt, q: q, ctrl: ctrl})
+	// registered now so Control works before the events are streamed
+	g.s.streamControls.Store(id, ctrl)
+	time.AfterFunc(pendingRunTTL, func() {
+		if _, ok := g.s.pendingRuns.LoadAndDelete(id); ok {
+			g.s.streamControls.Delete(id)
+			g.s.releaseStream()
+		}
+	})
+	out := grpcapi.New("RunInfo")
+	grpcapi.Set(out, "run_id", id)
+	return out, nil
+}
+
+// streamEvents runs a started run, sending each event as an Event message until done.
+func (g *grpcService) streamEvents(req *dynamicpb.Message, stream grpc.ServerStream) error {
+	id := grpcapi.Get(req, "run_id").String()
+	v, ok := g.s.pendingRuns.LoadAndDelete(id)
+	if !ok {
+		return status.Errorf(codes.NotFound, "run %q not found or already streamed", id)
+	}
+	run := v.(*pendingRun)
+	defer g.s.releaseStream() // the slot taken by StartRun
+	var seq uint64
+	var sendErr error
+	g.s.runStream(stream.Context(), id, run.ctrl, run.opt, run.q, func(event string, data []byte) error {
+		seq++
+		ev, err := eventMessage(id, seq, event, data)
+		if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(ev); err != nil {
+			sendErr = err
+			return err
+		}
+		return nil
+	})
+	return sendErr
+}
+
+// eventMessage converts an SSE event into an Event: the typed events are filled from
+// the JSON payload by field name, the others carry it as a Struct.
+func eventMessage(runID string, seq uint64, event string, data []byte) (*dynamicpb.Message, error) {
+	field, typed := typedEvents[event]
+	if !typed {
+		field = "data"
+	}
+	if event == "done" {
+		// the typed summary plus the whole payload
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+		m["summary"] = data
+		b, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	}
+	env, err := json.Marshal(map[string]any{"run_id": runID, "type": event, "seq": seq, "emitted": time.Now().UTC().Format(time.RFC3339Nano), field: json.RawMessage(data)})
+	if err != nil {
+		return nil, err
+	}
+	ev := grpcapi.New("Event")
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(env, ev); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// grpcDemand reads the demand switches of a ControlRequest; as with StartRun, zero
+// leaves a value unchanged.
+func grpcDemand(req *dynamicpb.Message) sim.DemandChange {
+	dc := sim.DemandChange{PeriodID: int(grpcapi.Get(req, "period").Int())}
+	if v := grpcapi.Get(req, "dir_bias").Float(); v != 0 {
+		dc.DirBias = &v
+	}
+	if v := grpcapi.Get(req, "spatial_gradient").Float(); v != 0 {
+		dc.SpatialGradient = &v
+	}
+	return dc
+}
+
+func (g *grpcService) control(ctx context.Context, req *dynamicpb.Message) (*dynamicpb.Message, error) {
+	speed := grpcapi.Get(req, "speed").Float()
+	if grpcapi.Get(req, "max_speed").Bool() {
+		speed = math.Inf(1)
+	}
+	c, err := g.s.control(grpcapi.Get(req, "run_id").String(), controlRequest{Speed: speed, ArrivalFactor: grpcapi.Get(req, "arrival_factor").Float(), Action: grpcapi.Get(req, "action").String(), StopID: int(grpcapi.Get(req, "stop_id").Int()), Passengers: grpcapi.Get(req, "passengers").String(), BusID: int(grpcapi.Get(req, "bus_id").Int()), BusSpeedKmph: grpcapi.Get(req, "bus_speed_kmph").Float(), HoldSec: grpcapi.Get(req, "hold_s").Float(), Count: int(grpcapi.Get(req, "count").Int()), Direction: grpcapi.Get(req, "direction").String(), DestStopID: int(grpcapi.Get(req, "dest_stop_id").Int()), Demand: grpcDemand(req)})
+	switch {
+	case errors.Is(err, errConnNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	out := grpcapi.New("ControlResponse")
+	a := ctrlAdapter{c: c}
+	grpcapi.Set(out, "speed", a.Speed())
+	grpcapi.Set(out, "arrival_factor", a.ArrivalFactor())
+	return out, nil
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"brt08/backend/model"
+)
+
+// routeStopView is one stop of the /api/route payload. The legacy field names of the
+// route file are kept; per-direction attributes are measured in that direction of travel.
+type routeStopView struct {
+	ID           int             `json:"id"`
+	Name         string          `json:"name"`
+	Index        int             `json:"index"`
+	Latitude     float64         `json:"latitute"`
+	Longitude    float64         `json:"longtude"`
+	DistanceNext float64         `json:"distance_next_stop"`
+	DistancePrev float64         `json:"distance_prev_stop,omitempty"` // set only when the inbound carriageway differs
+	CumulativeKM float64         `json:"cumulative_distance_km"`
+	AllowLayover bool            `json:"allow_layover"`
+	Outbound     stopDirectionKM `json:"outbound"`
+	Inbound      stopDirectionKM `json:"inbound"`
+}
+
+type stopDirectionKM struct {
+	DistanceNextKM float64 `json:"distance_next_km"` // to the next stop in this direction (0 at the terminal)
+	FromStartKM    float64 `json:"from_start_km"`    // from this direction's first stop
+	Origin         bool    `json:"origin"`           // first stop of this direction
+	Terminal       bool    `json:"terminal"`         // last stop of this direction
+}
+
+type routeView struct {
+	ID              int               `json:"id"`
+	Name            string            `json:"route"`
+	Direction       string            `json:"direction"`
+	TotalDistanceKM float64           `json:"total_distance_km"`
+	UnitDistance    string            `json:"unit_distance"`
+	StopCount       int               `json:"stop_count"`
+	LayoverStopIDs  []int             `json:"layover_stop_ids"`
+	Stops           []routeStopView   `json:"stops"`
+	Pins            []*model.RoutePin `json:"pins,omitempty"`
+}
+
+func newRouteView(r *model.Route, withPins bool) routeView {
+	n := len(r.Stops)
+	v := routeView{ID: r.ID, Name: r.Name, Direction: r.Direction, TotalDistanceKM: r.TotalDistanceKM, UnitDistance: r.UnitDistance, StopCount: n, LayoverStopIDs: []int{}, Stops: make([]routeStopView, n)}
+	// inbound distances run from the last stop, over the inbound segment lengths
+	fromEnd := make([]float64, n)
+	for i := n - 2; i >= 0; i-- {
+		fromEnd[i] = fromEnd[i+1] + r.SegmentKM(i+1, i)
+	}
+	cum := 0.0
+	for i, s := range r.Stops {
+		sv := routeStopView{ID: s.ID, Name: s.Name, Index: i, Latitude: s.Latitude, Longitude: s.Longitude, DistanceNext: s.DistanceToNext, CumulativeKM: s.CumulativeDist, AllowLayover: s.AllowLayover, DistancePrev: s.DistanceToPrev}
+		sv.Outbound = stopDirectionKM{FromStartKM: round3(cum), Origin: i == 0, Terminal: i == n-1}
+		if i < n-1 {
+			sv.Outbound.DistanceNextKM = s.DistanceToNext
+		}
+		sv.Inbound = stopDirectionKM{FromStartKM: round3(fromEnd[i]), Origin: i == n-1, Terminal: i == 0}
+		if i > 0 {
+			sv.Inbound.DistanceNextKM = r.SegmentKM(i, i-1)
+		}
+		if s.AllowLayover || i == 0 || i == n-1 {
+			v.LayoverStopIDs = append(v.LayoverStopIDs, s.ID)
+		}
+		v.Stops[i] = sv
+		if i < n-1 {
+			cum += s.DistanceToNext
+		}
+	}
+	if withPins {
+		v.Pins = r.Pins
+	}
+	return v
+}
+
+func round3(x float64) float64 { return math.Round(x*1000) / 1000 }
+
+// handleRoute serves the route with stop metadata; ?pins=1 adds the shape pins and
+// ?route=<id> selects a corridor other than the default.
+func (s *Server) handleRoute(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	route, err := s.corridor(r.URL.Query().Get("route"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	withPins, _ := strconv.ParseBool(r.URL.Query().Get("pins"))
+	json.NewEncoder(w).Encode(newRouteView(route, withPins))
+}
+
+// defaultCorridor is the id of Server.Route when the server has no corridor list.
+const defaultCorridor = "default"
+
+// corridor returns the route with id, or Server.Route for "".
+func (s *Server) corridor(id string) (*model.Route, error) {
+	if id == "" || (id == defaultCorridor && len(s.Opt.Corridors) == 0) {
+		return s.Route, nil
+	}
+	for _, c := range s.Opt.Corridors {
+		if c.ID == id {
+			return c.Route, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown route %q (see /api/routes)", id)
+}
+
+// corridorID returns the corridor id of route.
+func (s *Server) corridorID(route *model.Route) string {
+	for _, c := range s.Opt.Corridors {
+		if c.Route == route {
+			return c.ID
+		}
+	}
+	return defaultCorridor
+}
+
+type corridorView struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	File        string  `json:"file,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Default     bool    `json:"default"`
+	StopCount   int     `json:"stop_count"`
+	LengthKM    float64 `json:"length_km"`
+	From        string  `json:"from"` // first stop
+	To          string  `json:"to"`   // last stop
+}
+
+func newCorridorView(c Corridor, isDefault bool) corridorView {
+	v := corridorView{ID: c.ID, Name: c.Name, File: c.File, Description: c.Description, Default: isDefault, StopCount: len(c.Route.Stops), LengthKM: round3(c.Route.DirectionKM("outbound"))}
+	if n := len(c.Route.Stops); n > 0 {
+		v.From, v.To = c.Route.Stops[0].Name, c.Route.Stops[n-1].Name
+	}
+	return v
+}
+
+// handleRoutes lists the corridors a stream can run on (?route=<id> of /api/stream and
+// /api/route); without a corridor list, just the default route.
+func (s *Server) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	out := []corridorView{}
+	for _, c := range s.Opt.Corridors {
+		out = append(out, newCorridorView(c, c.Route == s.Route))
+	}
+	if len(out) == 0 {
+		out = append(out, newCorridorView(Corridor{ID: defaultCorridor, Name: s.Route.Name, Route: s.Route}, true))
+	}
+	json.NewEncoder(w).Encode(out)
+}
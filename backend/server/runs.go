@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"brt08/backend/sim"
+)
+
+// handleRuns lists the stored runs (newest last) without their per-stop rows.
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	runs := s.runs.List()
+	out := make([]sim.RunResult, len(runs))
+	for i, run := range runs {
+		out[i] = *run
+		out[i].Stops = nil
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleCompareRuns diffs two stored runs: /api/runs/compare?a=<id>&b=<id> (b relative to a).
+func (s *Server) handleCompareRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	q := r.URL.Query()
+	if q.Get("a") == "" || q.Get("b") == "" {
+		http.Error(w, "a and b run ids are required", http.StatusBadRequest)
+		return
+	}
+	a, ok := s.runs.Get(q.Get("a"))
+	if !ok {
+		http.Error(w, "run a not found", http.StatusNotFound)
+		return
+	}
+	b, ok := s.runs.Get(q.Get("b"))
+	if !ok {
+		http.Error(w, "run b not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sim.CompareRuns(a, b))
+}
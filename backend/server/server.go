@@ -1,19 +1,44 @@
 package server
 
 import (
+	"brt08/backend/data"
+	"brt08/backend/export"
 	"brt08/backend/model"
+	"brt08/backend/report"
 	"brt08/backend/sim"
+	"brt08/backend/sink"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
+	"math"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("brt08/backend/server")
+
+// errChaosDisconnect marks a stream cut by the chaos mode.
+var errChaosDisconnect = errors.New("chaos: injected disconnect")
+
+// runnerExitTimeout is how long a cancelled run may take to close its event channel
+// before it is reported as leaked.
+const runnerExitTimeout = 30 * time.Second
+
 // ctrlAdapter bridges server connControl to sim.Control.
 type ctrlAdapter struct{ c *connControl }
 
@@ -25,14 +50,7 @@ func (a ctrlAdapter) Speed() float64 {
 	if v == nil {
 		return 1
 	}
-	f := v.(float64)
-	if f < 0.1 {
-		f = 0.1
-	}
-	if f > 10 {
-		f = 10
-	}
-	return f
+	return clampSpeed(v.(float64), a.c.maxSpeed)
 }
 func (a ctrlAdapter) ArrivalFactor() float64 {
 	if a.c == nil {
@@ -56,8 +74,19 @@ func (a ctrlAdapter) ArrivalFactor() float64 {
 type connControl struct {
 	speed       atomic.Value
 	arrivalMult atomic.Value
+	resync      chan struct{}               // pending state snapshot request (buffered 1)
+	closures    chan sim.StopClosure        // pending stop closures and reopenings
+	buses       chan sim.BusCommand         // pending bus commands
+	demand      chan sim.DemandChange       // pending period, dir_bias and spatial_gradient switches
+	inject      chan sim.PassengerInjection // pending passenger injections
+	maxSpeed    float64                     // highest time scale of the stream
+	route       *model.Route                // corridor of the stream, for validating stop controls
 }
 
+// fast reports whether the stream runs above DefaultMaxSpeed, where high-rate events
+// are throttled.
+func (c *connControl) fast() bool { return ctrlAdapter{c: c}.Speed() > DefaultMaxSpeed }
+
 // Options configures the server instance.
 type Options struct {
 	PeriodID              int
@@ -65,76 +94,415 @@ type Options struct {
 	BaselineDemand        float64
 	DefaultSpeed          float64
 	DefaultArrivalFactor  float64
+	Lambda                float64 // base arrivals per corridor per minute of streams without ?lambda= (0 = sim.DefaultLambda)
 	ReportPath            string
 	Seed                  int64
 	TraceBusID            int
 	PassengerCap          int
 	MorningTowardKivukoni bool
 	DirBias               float64
+	DemandProfile         string        // "flat" or "period" (continuous NHPP intensity)
+	StallTimeout          time.Duration // end streams whose waiting passengers see no boarding for this long
+	GroupSizes            sim.GroupSizeDist
+	Population            int // activity-based synthetic population size (0 = independent Poisson arrivals)
+	Seeding               sim.SeedConfig
+	PassengerLogPath      string                 // if set, each finished stream writes a passenger journey log
+	DecisionLogPath       string                 // if set, each finished stream writes its dispatch decision audit trail
+	TrajectoryLogPath     string                 // if set, each finished stream writes its bus trajectories (GeoJSON)
+	SimplifyToleranceM    float64                // Douglas-Peucker tolerance for trajectories and exported shapes
+	DwellReportPath       string                 // if set, each finished stream writes per-stop dwell analytics
+	LoadReportPath        string                 // if set, each finished stream writes its segment occupancy heatmap
+	Traffic               sim.TravelTimeProvider // optional external segment travel times
+	ExportFormat          string                 // "matsim" or "sumo": export corridor and demand of each finished stream
+	ExportDir             string
+	QualityWeights        sim.QualityWeights   // service quality score weights (zero = defaults)
+	MetricsInterval       time.Duration        // KPI heartbeat period in sim time (0 = off)
+	ETAInterval           time.Duration        // passenger information display (stop_eta) period in sim time (0 = off)
+	Platforms             sim.PlatformConfig   // platform capacities and spilling (zero = stops' own capacities, no spill)
+	Berths                sim.BerthConfig      // berths per station (zero = stops' own berths, else unlimited)
+	Turnaround            sim.TurnaroundConfig // terminal turnaround bays, time and recovery (zero = unlimited, sim.DefaultTurnaroundTime, no layover)
+	Static                fs.FS                // frontend files served at "/" (nil = API only)
+	RunHistory            int                  // finished streams kept for /api/runs comparisons (0 = none)
+	Chaos                 Chaos                // SSE fault injection (zero = off)
+	Criterion             sim.StopCriterion    // end streams after a sim-time horizon or trip count (zero = passenger cap only)
+	Headway               sim.HeadwayConfig    // per-period target headways for adherence (zero = defaults)
+	Shifts                sim.ShiftConfig      // driver duty and driving limits, breaks and reliefs (zero = no shifts)
+	StartTime             sim.StartTime        // simulated date and time each run starts at (zero = today at the period's start)
+	Day                   sim.DaySchedule      // chain periods through each run instead of the stream's period (zero = single period)
+	MaxSpeed              float64              // highest stream time scale, also what speed "max" selects (0 = DefaultMaxSpeed)
+	EventThrottle         time.Duration        // above DefaultMaxSpeed, send a bus's moves and a stop's updates at most this often (0 = all)
+	MaxEventRate          float64              // at any speed, moves per bus and updates per stop per second (0 = EventThrottle only)
+	FrameInterval         time.Duration        // coalesce moves and stop updates into a "frame" event this often (0 = send each)
+	Backpressure          sim.Backpressure     // what a run does when its stream falls behind (empty = block)
+	EventBuffer           int                  // events buffered between a run and its stream (0 = sim.DefaultEventBuffer)
+	SlowClientTimeout     time.Duration        // with the disconnect policy, longest wait of a send or a write (0 = sim.DefaultSlowConsumerTimeout)
+	Sink                  *sink.Async          // if set, receives every event sent on every stream (nil = SSE only)
+	GRPC                  bool                 // the gRPC API is served too (RegisterGRPC; reported in /api/version)
+	MaxStreams            int                  // simultaneous simulations across SSE, NDJSON and gRPC; more get 429 (0 = unlimited)
+	Corridors             []Corridor           // routes a stream may pick with ?route= (empty = Server.Route only)
+	Corridor              string               // id of the corridor a stream runs on, from ?route= ("" = Server.Route)
+	AVL                   *sim.AVLTrace        // recorded GPS/AVL trace streams may replay with ?source=avl (nil = none)
+	AVLMatch              sim.AVLOptions       // how the trace's positions are matched to stops and the route
+	Source                string               // what a stream plays, from ?source=: "" (simulation) or "avl" (the AVL trace)
+}
+
+// Corridor is a route the server can simulate besides (or including) its default one.
+type Corridor struct {
+	ID          string
+	Name        string
+	File        string
+	Description string
+	Route       *model.Route
+}
+
+// DefaultOptions returns the settings of the command-line defaults.
+func DefaultOptions() Options {
+	return Options{PeriodID: 2, SpatialGradient: 0.8, BaselineDemand: 0.3, DefaultSpeed: 1, DefaultArrivalFactor: 1, MorningTowardKivukoni: true, DirBias: 1.4, DemandProfile: "flat", StallTimeout: 30 * time.Minute, Seeding: sim.SeedConfig{Window: sim.DefaultSeedWindow, Dist: "uniform"}, SimplifyToleranceM: sim.DefaultSimplifyToleranceM, ExportDir: "export", MetricsInterval: 10 * time.Second, ETAInterval: 30 * time.Second, RunHistory: 20, MaxSpeed: DefaultMaxSpeed, EventThrottle: 100 * time.Millisecond}
 }
 
+// Option customizes a Server built by New.
+type Option func(*Options)
+
+// WithOptions replaces all settings at once.
+func WithOptions(o Options) Option { return func(dst *Options) { *dst = o } }
+
+// WithSeed fixes the random seed of every stream (0 = random per stream).
+func WithSeed(seed int64) Option { return func(o *Options) { o.Seed = seed } }
+
+// WithPassengerCap ends streams after this many passengers are served (0 = unlimited).
+func WithPassengerCap(n int) Option { return func(o *Options) { o.PassengerCap = n } }
+
+// WithPeriod selects the demand time period (1..6).
+func WithPeriod(id int) Option { return func(o *Options) { o.PeriodID = id } }
+
+// WithDefaultSpeed sets the initial real-time multiplier of new streams.
+func WithDefaultSpeed(x float64) Option { return func(o *Options) { o.DefaultSpeed = x } }
+
+// WithMaxSpeed sets the highest time scale of streams, which speed "max" selects.
+func WithMaxSpeed(x float64) Option { return func(o *Options) { o.MaxSpeed = x } }
+
+// WithStallTimeout ends streams whose waiting passengers see no boarding for d (0 = never).
+func WithStallTimeout(d time.Duration) Option { return func(o *Options) { o.StallTimeout = d } }
+
+// WithMetricsInterval sets the KPI heartbeat period in sim time (0 = off).
+func WithMetricsInterval(d time.Duration) Option { return func(o *Options) { o.MetricsInterval = d } }
+
+// WithETAInterval sets the passenger information display period in sim time (0 = off).
+func WithETAInterval(d time.Duration) Option { return func(o *Options) { o.ETAInterval = d } }
+
+// WithReportPath writes a CSV report for every finished stream.
+func WithReportPath(path string) Option { return func(o *Options) { o.ReportPath = path } }
+
+// WithTraffic plugs an external segment travel time provider.
+func WithTraffic(p sim.TravelTimeProvider) Option { return func(o *Options) { o.Traffic = p } }
+
+// WithStatic serves the frontend from fsys at "/".
+func WithStatic(fsys fs.FS) Option { return func(o *Options) { o.Static = fsys } }
+
+// WithMaxStreams caps the simultaneous simulations (0 = unlimited).
+func WithMaxStreams(n int) Option { return func(o *Options) { o.MaxStreams = n } }
+
+// WithCorridors sets the routes streams may select with ?route=.
+func WithCorridors(c []Corridor) Option { return func(o *Options) { o.Corridors = c } }
+
+// WithChaos enables SSE fault injection.
+func WithChaos(c Chaos) Option { return func(o *Options) { o.Chaos = c } }
+
 type Server struct {
 	Route *model.Route
 	Fleet []*model.Bus
 	Opt   Options
 
 	streamControls sync.Map // map[connID]*connControl
+	pendingRuns    sync.Map // map[connID]*pendingRun: gRPC runs awaiting StreamEvents
+	live           sync.Map // map[connID]*sim.LiveStats
+	lastLive       atomic.Pointer[sim.LiveStats]
+
+	activeStreams   atomic.Int64 // runs holding a MaxStreams slot
+	rejectedStreams atomic.Int64
+	started         time.Time
+
+	runs    *sim.RunStore
+	muxOnce sync.Once
+	mux     *http.ServeMux
 }
 
-func New(route *model.Route, fleet []*model.Bus, opt Options) *Server {
-	return &Server{Route: route, Fleet: fleet, Opt: opt}
+// New builds a server over route and fleet, starting from DefaultOptions.
+func New(route *model.Route, fleet []*model.Bus, opts ...Option) *Server {
+	s := &Server{Route: route, Fleet: fleet, Opt: DefaultOptions(), started: time.Now()}
+	for _, o := range opts {
+		o(&s.Opt)
+	}
+	s.runs = sim.NewRunStore(s.Opt.RunHistory)
+	return s
 }
 
-// Serve registers HTTP handlers on default mux.
-func (s *Server) Serve() {
-	routeHandler := func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		j, _ := json.Marshal(s.Route)
-		w.Write(j)
-	}
-	http.HandleFunc("/api/route", routeHandler)
-	http.HandleFunc("/api/route.json", routeHandler)
-	http.HandleFunc("/api/routejson", routeHandler)
-	http.HandleFunc("/api/control", s.handleControl)
-	http.HandleFunc("/api/stream", s.handleStream)
+// Handler returns the server's HTTP API on its own ServeMux, so it can be mounted in
+// another service or driven with httptest. The default mux is left untouched.
+func (s *Server) Handler() http.Handler {
+	s.muxOnce.Do(s.routes)
+	return s.mux
 }
 
-func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
+func (s *Server) routes() {
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/api/route", s.handleRoute)
+	s.mux.HandleFunc("/api/route.json", s.handleRoute)
+	s.mux.HandleFunc("/api/routejson", s.handleRoute)
+	s.mux.HandleFunc("/api/routes", s.handleRoutes)
+	s.mux.HandleFunc("/api/control", s.handleControl)
+	s.mux.HandleFunc("/api/stream", s.handleStream)
+	s.mux.HandleFunc("/api/stream.ndjson", s.handleStream)
+	s.mux.HandleFunc("/api/stats/stops", s.handleStopStats)
+	s.mux.HandleFunc("/api/stats/buses", s.handleBusStats)
+	s.mux.HandleFunc("/api/stats/headways", s.handleHeadwayStats)
+	s.mux.HandleFunc("/api/eta", s.handleETA)
+	s.mux.HandleFunc("/api/runs", s.handleRuns)
+	s.mux.HandleFunc("/api/runs/compare", s.handleCompareRuns)
+	s.mux.HandleFunc("/api/version", s.handleVersion)
+	s.mux.HandleFunc("/api/status", s.handleStatus)
+	if s.Opt.Static != nil {
+		s.mux.Handle("/", http.FileServer(http.FS(s.Opt.Static)))
+	}
+}
+
+// liveFor resolves the stream given by ?conn_id=, or the most recently started
+// stream (kept after it finishes). It writes the error response when none matches.
+func (s *Server) liveFor(w http.ResponseWriter, r *http.Request) *sim.LiveStats {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(204)
+	live := s.lastLive.Load()
+	if id := r.URL.Query().Get("conn_id"); id != "" {
+		v, ok := s.live.Load(id)
+		if !ok {
+			http.Error(w, "connection not found", 404)
+			return nil
+		}
+		live = v.(*sim.LiveStats)
+	}
+	if live == nil {
+		http.Error(w, "no simulation has started", 404)
+	}
+	return live
+}
+
+// handleStopStats returns per-stop aggregates of a stream (see liveFor).
+func (s *Server) handleStopStats(w http.ResponseWriter, r *http.Request) {
+	live := s.liveFor(w, r)
+	if live == nil {
 		return
 	}
-	var req struct {
-		ConnID        string  `json:"conn_id"`
-		Speed         float64 `json:"speed"`
-		ArrivalFactor float64 `json:"arrival_factor"`
+	stats := live.Stops()
+	for i := range stats {
+		stats[i].AvgWaitMinutes = sim.ReportPrecision.Minutes(stats[i].AvgWaitMinutes, true)
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad json", 400)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleBusStats returns per-bus live metrics of a stream (see liveFor).
+func (s *Server) handleBusStats(w http.ResponseWriter, r *http.Request) {
+	live := s.liveFor(w, r)
+	if live == nil {
+		return
+	}
+	stats := live.Buses()
+	for i := range stats {
+		stats[i].DistanceKM = sim.ReportPrecision.KM(stats[i].DistanceKM, true)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// handleETA predicts the next buses due at ?stop_id= in each direction of a stream
+// (see liveFor), as shown on a passenger information display; ?limit= caps the buses
+// listed per direction (0 = all).
+func (s *Server) handleETA(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	stopID, err := strconv.Atoi(q.Get("stop_id"))
+	if err != nil {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		http.Error(w, "stop_id must be an integer", http.StatusBadRequest)
+		return
+	}
+	limit := sim.ETADisplayBuses
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	live := s.liveFor(w, r)
+	if live == nil {
 		return
 	}
-	v, ok := s.streamControls.Load(req.ConnID)
+	eta, ok := live.ETA(stopID, limit)
 	if !ok {
-		http.Error(w, "connection not found", 404)
+		http.Error(w, fmt.Sprintf("stop %d is not on the stream's route", stopID), http.StatusNotFound)
 		return
 	}
+	for _, list := range [][]sim.BusETA{eta.Outbound, eta.Inbound} {
+		for i := range list {
+			list[i].ETAMin = sim.ReportPrecision.Minutes(list[i].ETAMin, true)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eta)
+}
+
+// handleHeadwayStats returns observed headway statistics per stop and direction of a
+// stream (see liveFor).
+func (s *Server) handleHeadwayStats(w http.ResponseWriter, r *http.Request) {
+	live := s.liveFor(w, r)
+	if live == nil {
+		return
+	}
+	stats := live.Headways()
+	if stats == nil {
+		stats = []sim.StopHeadway{}
+	}
+	for i := range stats {
+		stats[i].MeanMin = sim.ReportPrecision.Minutes(stats[i].MeanMin, true)
+		stats[i].StdDevMin = sim.ReportPrecision.Minutes(stats[i].StdDevMin, true)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// Control errors.
+var (
+	errConnNotFound  = errors.New("connection not found")
+	errUnknownAction = errors.New("unknown action")
+)
+
+// controlRequest is a command of /api/control or the gRPC Control call.
+type controlRequest struct {
+	Speed         float64          // 0 = unchanged, +Inf = "max"
+	ArrivalFactor float64          // 0 = unchanged
+	Action        string           // "resync", "close_stop", "reopen_stop", "set_bus_speed", "hold_bus", "short_turn" or "inject" (empty = none)
+	StopID        int              // stop of close_stop, reopen_stop and inject; short_turn: where to reverse (0 = the next stop)
+	Passengers    string           // close_stop: what waiting passengers do (sim.ClosureRedistribute or sim.ClosureUnserved)
+	BusID         int              // bus of set_bus_speed, hold_bus and short_turn
+	BusSpeedKmph  float64          // set_bus_speed: average speed from the bus's next departure (0 = its own)
+	HoldSec       float64          // hold_bus: extra dwell at the bus's next stop
+	Count         int              // inject: passengers to enqueue
+	Direction     string           // inject: "outbound" or "inbound" (empty = by destination, else either)
+	DestStopID    int              // inject: destination of all passengers (0 = drawn ahead of the stop)
+	Demand        sim.DemandChange // period, dir_bias and spatial_gradient from the next generator step (zero = unchanged)
+}
+
+// control applies a control request to the stream connID: a non-zero speed (+Inf =
+// "max") or arrival factor replaces the current one, action "resync" asks for a
+// "state" snapshot, "close_stop"/"reopen_stop" close or reopen a stop of the run and
+// "set_bus_speed", "hold_bus" and "short_turn" command one bus; "inject" enqueues
+// passengers at a stop. A demand change
+// switches the period, dir_bias or spatial_gradient of the run. It returns the
+// stream's controls.
+func (s *Server) control(connID string, req controlRequest) (*connControl, error) {
+	v, ok := s.streamControls.Load(connID)
+	if !ok {
+		return nil, errConnNotFound
+	}
 	c := v.(*connControl)
-	if req.Speed != 0 {
-		sp := req.Speed
+	switch req.Action {
+	case "":
+	case "resync":
+		select {
+		case c.resync <- struct{}{}:
+		default: // a snapshot is already pending
+		}
+	case "close_stop", "reopen_stop":
+		idx := c.route.IndexOf(req.StopID)
+		if idx < 0 {
+			return nil, fmt.Errorf("stop_id %d not on the route", req.StopID)
+		}
+		cl := sim.StopClosure{StopID: req.StopID, Reopen: req.Action == "reopen_stop"}
+		if !cl.Reopen {
+			if idx == 0 || idx == len(c.route.Stops)-1 {
+				return nil, fmt.Errorf("stop %d is a terminal and cannot be closed", req.StopID)
+			}
+			policy, err := sim.ParseClosurePolicy(req.Passengers)
+			if err != nil {
+				return nil, err
+			}
+			cl.Policy = policy
+		}
+		select {
+		case c.closures <- cl:
+		default:
+			return nil, errors.New("too many closures pending; retry")
+		}
+	case "set_bus_speed", "hold_bus", "short_turn":
+		if !slices.ContainsFunc(s.Fleet, func(b *model.Bus) bool { return b.ID == req.BusID }) {
+			return nil, fmt.Errorf("bus_id %d not in the fleet", req.BusID)
+		}
+		cmd := sim.BusCommand{BusID: req.BusID}
+		switch req.Action {
+		case "set_bus_speed":
+			if req.BusSpeedKmph < 0 || req.BusSpeedKmph > 120 {
+				return nil, errors.New("bus_speed_kmph must be in [0, 120]")
+			}
+			cmd.Kind, cmd.SpeedKmph = sim.BusSetSpeed, req.BusSpeedKmph
+		case "hold_bus":
+			if req.HoldSec <= 0 || req.HoldSec > 3600 {
+				return nil, errors.New("hold_s must be in (0, 3600]")
+			}
+			cmd.Kind, cmd.Hold = sim.BusHold, time.Duration(req.HoldSec*float64(time.Second))
+		default:
+			if req.StopID != 0 {
+				idx := c.route.IndexOf(req.StopID)
+				if idx < 0 {
+					return nil, fmt.Errorf("stop_id %d not on the route", req.StopID)
+				}
+				if idx == 0 || idx == len(c.route.Stops)-1 {
+					return nil, fmt.Errorf("stop %d is a terminal; buses turn there anyway", req.StopID)
+				}
+			}
+			cmd.Kind, cmd.StopID = sim.BusShortTurn, req.StopID
+		}
+		select {
+		case c.buses <- cmd:
+		default:
+			return nil, errors.New("too many bus commands pending; retry")
+		}
+	case "inject":
+		inj := sim.PassengerInjection{StopID: req.StopID, Direction: req.Direction, DestStopID: req.DestStopID, Count: req.Count}
+		if err := inj.Validate(c.route); err != nil {
+			return nil, err
+		}
+		select {
+		case c.inject <- inj:
+		default:
+			return nil, errors.New("too many injections pending; retry")
+		}
+	default:
+		return nil, errUnknownAction
+	}
+	if dc := req.Demand; dc.PeriodID != 0 || dc.DirBias != nil || dc.SpatialGradient != nil {
+		if err := dc.Validate(); err != nil {
+			return nil, err
+		}
+		if dc.PeriodID != 0 && s.Opt.Day.Enabled() {
+			return nil, errors.New("period follows the -day schedule of the run")
+		}
+		select {
+		case c.demand <- dc:
+		default:
+			return nil, errors.New("too many demand changes pending; retry")
+		}
+	}
+	speed, arrivalFactor := req.Speed, req.ArrivalFactor
+	if speed != 0 {
+		sp := speed
 		if sp <= 0 {
 			sp = 1
 		}
-		if sp > 10.0 {
-			sp = 10.0
-		}
+		sp = clampSpeed(sp, c.maxSpeed)
 		c.speed.Store(sp)
-		log.Printf("control: conn=%s speed=%.2fx", req.ConnID, sp)
+		slog.Info("control", "conn", connID, "speed", sp)
 	}
-	if req.ArrivalFactor != 0 {
-		af := req.ArrivalFactor
+	if arrivalFactor != 0 {
+		af := arrivalFactor
 		if af <= 0 {
 			af = 1
 		}
@@ -146,11 +514,153 @@ func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
 		}
 		c.arrivalMult.Store(af)
 	}
+	return c, nil
+}
+
+func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(204)
+		return
+	}
+	var req struct {
+		ConnID        string     `json:"conn_id"`
+		Speed         speedValue `json:"speed"` // number or "max"
+		ArrivalFactor float64    `json:"arrival_factor"`
+		Action        string     `json:"action"` // "resync": emit a full "state" event; "close_stop" / "reopen_stop"; "set_bus_speed" / "hold_bus" / "short_turn"; "inject"
+		StopID        int        `json:"stop_id"`
+		Passengers    string     `json:"passengers"` // close_stop: "redistribute" (default) or "unserved"
+		BusID         int        `json:"bus_id"`     // set_bus_speed, hold_bus, short_turn
+		BusSpeedKmph  float64    `json:"bus_speed_kmph"`
+		HoldSec       float64    `json:"hold_s"`
+		Count         int        `json:"count"` // inject
+		Direction     string     `json:"direction"`
+		DestStopID    int        `json:"dest_stop_id"`
+		Period        int        `json:"period"` // demand switches (absent = unchanged)
+		DirBias       *float64   `json:"dir_bias"`
+		Gradient      *float64   `json:"spatial_gradient"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", 400)
+		return
+	}
+	if _, err := s.control(req.ConnID, controlRequest{Speed: float64(req.Speed), ArrivalFactor: req.ArrivalFactor, Action: req.Action, StopID: req.StopID, Passengers: req.Passengers, BusID: req.BusID, BusSpeedKmph: req.BusSpeedKmph, HoldSec: req.HoldSec, Count: req.Count, Direction: req.Direction, DestStopID: req.DestStopID, Demand: sim.DemandChange{PeriodID: req.Period, DirBias: req.DirBias, SpatialGradient: req.Gradient}}); err != nil {
+		code := 400
+		if errors.Is(err, errConnNotFound) {
+			code = 404
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
 	w.WriteHeader(204)
 }
 
+// streamOptions returns the server options with the per-stream overrides of the query
+// string applied: route (corridor id), period, passenger_cap, dir_bias, spatial_gradient,
+// baseline_demand, seed and start (to reproduce a run), sim_hours and max_trips (stop
+// criterion), and the delivery settings max_rate and frame.
+func (s *Server) streamOptions(q url.Values) (Options, error) {
+	o := s.Opt
+	num := func(key string, lo, hi float64, dst *float64) error {
+		qs := q.Get(key)
+		if qs == "" {
+			return nil
+		}
+		v, err := strconv.ParseFloat(qs, 64)
+		if err != nil || v < lo || v > hi {
+			return fmt.Errorf("%s must be a number in [%g, %g]", key, lo, hi)
+		}
+		*dst = v
+		return nil
+	}
+	integer := func(key string, lo, hi int64, dst *int64) error {
+		qs := q.Get(key)
+		if qs == "" {
+			return nil
+		}
+		v, err := strconv.ParseInt(qs, 10, 64)
+		if err != nil || v < lo || v > hi {
+			return fmt.Errorf("%s must be an integer in [%d, %d]", key, lo, hi)
+		}
+		*dst = v
+		return nil
+	}
+	period, pcap := int64(o.PeriodID), int64(o.PassengerCap)
+	hours, trips := o.Criterion.Duration.Hours(), int64(o.Criterion.Trips)
+	for _, err := range []error{
+		num("sim_hours", 0, 1000, &hours),
+		integer("max_trips", 0, math.MaxInt32, &trips),
+		integer("period", 1, int64(len(data.TimePeriods)), &period),
+		integer("passenger_cap", 0, math.MaxInt32, &pcap),
+		integer("seed", math.MinInt64, math.MaxInt64, &o.Seed),
+		num("dir_bias", 0.01, 100, &o.DirBias),
+		num("spatial_gradient", 0, 1, &o.SpatialGradient),
+		num("baseline_demand", 0, 1, &o.BaselineDemand),
+		num("max_rate", 0, 1000, &o.MaxEventRate),
+	} {
+		if err != nil {
+			return o, err
+		}
+	}
+	if id := q.Get("route"); id != "" {
+		if _, err := s.corridor(id); err != nil {
+			return o, err
+		}
+		o.Corridor = id
+	}
+	switch src := q.Get("source"); src {
+	case "", "sim":
+	case "avl":
+		if s.Opt.AVL == nil {
+			return o, fmt.Errorf("source=avl needs a trace loaded with -avl_file")
+		}
+		o.Source = src
+	default:
+		return o, fmt.Errorf("source must be sim or avl")
+	}
+	if qs := q.Get("start"); qs != "" {
+		st, err := sim.ParseStartTime(qs)
+		if err != nil {
+			return o, err
+		}
+		if o.Day.Enabled() && !st.DateOnly() {
+			return o, fmt.Errorf("start: a full-day run starts at its first period, give a date (YYYY-MM-DD) only")
+		}
+		o.StartTime = st
+	}
+	if qs := q.Get("frame"); qs != "" {
+		d, err := time.ParseDuration(qs)
+		if err != nil || d < 0 || d > 10*time.Second {
+			return o, fmt.Errorf("frame must be a duration in [0, 10s], e.g. 250ms")
+		}
+		o.FrameInterval = d
+	}
+	o.PeriodID, o.PassengerCap = int(period), int(pcap)
+	o.Criterion = sim.StopCriterion{Duration: time.Duration(hours * float64(time.Hour)), Trips: int(trips)}
+	return o, nil
+}
+
 func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/event-stream")
+	q := r.URL.Query()
+	opt, err := s.streamOptions(q)
+	if err != nil {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Get("engine") == "legacy" {
+		// the old inline simulation is gone; the runner serves every stream
+		http.Error(w, "legacy engine disabled; remove engine=legacy to use runner", http.StatusGone)
+		return
+	}
+	if !s.acquireStream() {
+		rejectStream(w)
+		return
+	}
+	defer s.releaseStream()
+	enc := encodingFor(r.URL.Path)
+	w.Header().Set("Content-Type", enc.contentType)
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -159,42 +669,54 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "stream unsupported", 500)
 		return
 	}
-
-	// Per-connection clones
-	seedBase := s.Opt.Seed
-	if seedBase == 0 {
-		seedBase = time.Now().UnixNano()
+	connID := newConnID()
+	// With the disconnect policy a write stuck longer than the slow client timeout fails
+	// too, so a client that stopped reading is dropped rather than the run stalled.
+	var rc *http.ResponseController
+	slowTimeout := s.Opt.SlowClientTimeout
+	if slowTimeout <= 0 {
+		slowTimeout = sim.DefaultSlowConsumerTimeout
 	}
-	engineSeed := seedBase + 1
-	connBuses := make([]*model.Bus, 0, len(s.Fleet))
-	for _, proto := range s.Fleet {
-		b := &model.Bus{ID: proto.ID, Type: proto.Type, RouteID: proto.RouteID, CurrentStopID: proto.CurrentStopID, Direction: proto.Direction, AverageSpeedKmph: proto.AverageSpeedKmph}
-		connBuses = append(connBuses, b)
+	if s.Opt.Backpressure == sim.BackpressureDisconnect {
+		rc = http.NewResponseController(w)
 	}
-	start := time.Now()
-	lambda := 1.2
-	if qs := r.URL.Query().Get("lambda"); qs != "" {
-		if v, err := strconv.ParseFloat(qs, 64); err == nil && v > 0 {
-			lambda = v
+	write := func(event string, data []byte) error {
+		if rc != nil {
+			rc.SetWriteDeadline(time.Now().Add(slowTimeout))
 		}
+		err := enc.write(w, event, data)
+		if err == nil && rc != nil {
+			err = rc.Flush()
+		} else if err == nil {
+			flusher.Flush()
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			slog.Warn("stream client too slow; disconnecting", "conn", connID, "timeout", slowTimeout)
+		}
+		return err
+	}
+	s.runStream(r.Context(), connID, s.newConnControl(q), opt, q, write)
+}
+
+// newConnID returns a fresh stream connection id.
+func newConnID() string { return fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Int63()) }
+
+// newConnControl returns the live controls of a new stream, initialized from the
+// speed and arrival_factor query parameters or the server defaults.
+func (s *Server) newConnControl(q url.Values) *connControl {
+	ctrl := &connControl{resync: make(chan struct{}, 1), closures: make(chan sim.StopClosure, 8), buses: make(chan sim.BusCommand, 8), demand: make(chan sim.DemandChange, 8), inject: make(chan sim.PassengerInjection, 8), maxSpeed: s.Opt.maxSpeed(), route: s.Route}
+	if r, err := s.corridor(q.Get("route")); err == nil {
+		ctrl.route = r
 	}
-	connID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Int63())
-	ctrl := &connControl{}
 	initSpeed := s.Opt.DefaultSpeed
-	if qs := r.URL.Query().Get("speed"); qs != "" {
-		if v, err := strconv.ParseFloat(qs, 64); err == nil && v > 0 {
+	if qs := q.Get("speed"); qs != "" {
+		if v, err := parseSpeed(qs); err == nil {
 			initSpeed = v
 		}
 	}
-	if initSpeed < 0.1 {
-		initSpeed = 0.1
-	}
-	if initSpeed > 10.0 {
-		initSpeed = 10.0
-	}
-	ctrl.speed.Store(initSpeed)
+	ctrl.speed.Store(clampSpeed(initSpeed, ctrl.maxSpeed))
 	initArr := s.Opt.DefaultArrivalFactor
-	if qs := r.URL.Query().Get("arrival_factor"); qs != "" {
+	if qs := q.Get("arrival_factor"); qs != "" {
 		if v, err := strconv.ParseFloat(qs, 64); err == nil && v > 0 {
 			initArr = v
 		}
@@ -206,85 +728,310 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 		initArr = 50.0
 	}
 	ctrl.arrivalMult.Store(initArr)
+	return ctrl
+}
+
+// runStream runs one simulation under the stream options opt and sends its events to
+// write (one call per event, JSON data, serialized) until the run is done, then writes
+// the configured reports. A failing write cancels the run; the remaining events are
+// drained so the reports still cover it. The SSE, NDJSON and gRPC streams share it.
+func (s *Server) runStream(ctx context.Context, connID string, ctrl *connControl, opt Options, q url.Values, write func(event string, data []byte) error) {
+	// Per-connection clones
+	// A drawn seed stays below 2^40 so JSON clients read back the exact value to replay
+	seedBase := opt.Seed
+	if seedBase == 0 {
+		seedBase = time.Now().UnixNano() % (1 << 40)
+	}
+	engineSeed := seedBase + 1
+	connBuses := make([]*model.Bus, 0, len(s.Fleet))
+	for _, proto := range s.Fleet {
+		b := &model.Bus{ID: proto.ID, Type: proto.Type, RouteID: proto.RouteID, CurrentStopID: proto.CurrentStopID, Direction: proto.Direction, AverageSpeedKmph: proto.AverageSpeedKmph}
+		connBuses = append(connBuses, b)
+	}
+	started := time.Now()
+	start := opt.StartTime.Resolve(opt.PeriodID, s.Opt.Day, started)
+	if opt.Source == "avl" {
+		// the trace's own buses and clock replace the fleet and the start
+		connBuses = s.Opt.AVL.Buses(s.Fleet)
+		start = s.Opt.AVL.Start()
+	}
+	lambda := sim.DefaultLambda
+	if s.Opt.Lambda > 0 {
+		lambda = s.Opt.Lambda
+	}
+	if qs := q.Get("lambda"); qs != "" {
+		if v, err := strconv.ParseFloat(qs, 64); err == nil && v > 0 {
+			lambda = v
+		}
+	}
+	ctrls := ctrlAdapter{c: ctrl}
+	ctx, span := tracer.Start(ctx, "stream", trace.WithAttributes(attribute.String("conn_id", connID), attribute.Float64("speed", ctrls.Speed()), attribute.Float64("arrival_factor", ctrls.ArrivalFactor()), attribute.Float64("lambda", lambda)))
+	defer span.End()
+	// The stream's own cancel ends the run when the client can no longer be written to
+	ctx, cancelStream := context.WithCancel(ctx)
+	defer cancelStream()
+	var chaos *chaosConn
+	if s.Opt.Chaos.Enabled() {
+		chaos = newChaosConn(s.Opt.Chaos, seedBase)
+	}
 	s.streamControls.Store(connID, ctrl)
 	defer s.streamControls.Delete(connID)
+	live := &sim.LiveStats{}
+	s.live.Store(connID, live)
+	defer s.live.Delete(connID)
+	s.lastLive.Store(live)
+	var traj *sim.TrajectoryRecorder
+	if s.Opt.TrajectoryLogPath != "" {
+		traj = sim.NewTrajectoryRecorder()
+	}
 
-	// Serialize writer
+	// Serialize writer; after the first failed (or chaos-cut) write the run is cancelled
+	// and later events are only drained, so the final DoneEvent still produces reports.
 	var writeMu sync.Mutex
+	var writeErr error
 	flush := func(event string, payload any) {
 		writeMu.Lock()
+		defer writeMu.Unlock()
 		b, _ := json.Marshal(payload)
-		fmt.Fprintf(w, "event: %s\n", event)
-		fmt.Fprintf(w, "data: %s\n\n", string(b))
-		flusher.Flush()
-		writeMu.Unlock()
-	}
-	// Always use channel-based engine (runner) unless explicitly requested legacy
-	useLegacy := r.URL.Query().Get("engine") == "legacy"
-	if !useLegacy {
-		// Build control adapter to read live controls
-		var _ sim.Control = ctrlAdapter{}
-		evCh, stopFn, waitFn := sim.StartRunner(s.Route, connBuses, engineSeed, lambda, struct {
-			PeriodID              int
-			PassengerCap          int
-			MorningTowardKivukoni bool
-			DirBias               float64
-			SpatialGradient       float64
-			BaselineDemand        float64
-			TraceBusID            int
-			ConnID                string
-			Start                 time.Time
-		}{PeriodID: s.Opt.PeriodID, PassengerCap: s.Opt.PassengerCap, MorningTowardKivukoni: s.Opt.MorningTowardKivukoni, DirBias: s.Opt.DirBias, SpatialGradient: s.Opt.SpatialGradient, BaselineDemand: s.Opt.BaselineDemand, TraceBusID: s.Opt.TraceBusID, ConnID: connID, Start: start}, ctrlAdapter{c: ctrl})
-
-		// Ensure cleanup if client disconnects early
-		defer stopFn()
-		defer waitFn()
-
-		// Capture final metrics for reporting
-		var finalDone *sim.DoneEvent
-		for e := range evCh {
-			switch ev := e.(type) {
-			case sim.InitEvent:
-				flush("init", map[string]any{"time": ev.Time, "buses": []any{}, "message": "started", "conn_id": ev.ConnID, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGen, "inbound_generated": ev.InboundGen, "served_passengers": 0, "avg_wait_min": ev.AvgWaitMin, "arrival_factor": ev.ArrivalFactor})
-			case sim.StopUpdateEvent:
-				flush("stop_update", map[string]any{"stop_id": ev.StopID, "outbound_queue": ev.OutboundQueue, "inbound_queue": ev.InboundQueue, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated})
-			case sim.BusAddEvent:
-				flush("bus_add", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "avg_speed_kmph": ev.AvgSpeedKmph, "capacity": ev.Capacity})
-			case sim.ArriveEvent:
-				flush("arrive", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "stop_id": ev.StopID, "time": ev.Time, "bus_onboard": ev.BusOnboard, "passengers_onboard": ev.PassengersOnboard, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated})
-			case sim.AlightEvent:
-				flush("alight", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "stop_id": ev.StopID, "alighted": ev.Alighted, "bus_onboard": ev.BusOnboard, "passengers_onboard": ev.PassengersOnboard, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated, "final": ev.Final, "served_passengers": ev.ServedPassengers})
-			case sim.BoardEvent:
-				flush("board", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "stop_id": ev.StopID, "boarded": ev.Boarded, "bus_onboard": ev.BusOnboard, "passengers_onboard": ev.PassengersOnboard, "stop_outbound": ev.StopOutbound, "stop_inbound": ev.StopInbound, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated, "served_passengers": ev.ServedPassengers, "avg_wait_min": ev.AvgWaitMin})
-			case sim.MoveEvent:
-				flush("move", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "lat": ev.Lat, "lng": ev.Lng, "t": ev.T, "from": ev.From, "to": ev.To, "phase": ev.Phase})
-			case sim.LayoverEvent:
-				flush("layover", map[string]any{"bus_id": ev.BusID, "terminal_stop_id": ev.TerminalStopID})
-			case sim.RepositionStartEvent:
-				flush("reposition_start", map[string]any{"buses": ev.Buses, "layover_indices": ev.LayoverIndices})
-			case sim.RepositionBusEvent:
-				flush("reposition_bus", map[string]any{"bus_id": ev.BusID, "from_index": ev.FromIndex, "target_index": ev.TargetIndex, "current_stop_id": ev.CurrentStopID, "ahead_only": ev.AheadOnly})
-			case sim.RepositionCompleteEvent:
-				flush("reposition_complete", map[string]any{"elapsed_ms": ev.ElapsedMs})
-			case sim.DoneEvent:
-				// Remember final metrics and forward done downstream
-				finalDone = &ev
-				flush("done", map[string]any{"generated_passengers": ev.Generated, "served_passengers": ev.ServedPassengers, "avg_wait_min": ev.AvgWaitMin, "bus_distance": ev.BusDistance})
-			}
+		// the sink gets the run's events even after the client went away
+		s.Opt.Sink.Publish(sink.Record{ConnID: connID, Event: event, Time: time.Now(), Data: b})
+		if writeErr != nil {
+			return
+		}
+		drop, cut := chaos.before()
+		if cut {
+			writeErr = errChaosDisconnect
+			cancelStream()
+			return
+		}
+		if drop {
+			return
 		}
-		// After stream closes, write reports if requested
-		if finalDone != nil {
-			sum := sim.ReportSummary{Generated: finalDone.Generated, Served: finalDone.ServedPassengers, AvgWaitMin: finalDone.AvgWaitMin, BusDistance: finalDone.BusDistance}
-			if s.Opt.ReportPath != "" {
-				if _, err := sim.WriteCSVReport(s.Opt.ReportPath, connBuses, sum); err != nil {
-					log.Printf("report: create failed: %v", err)
+		if err := write(event, b); err != nil {
+			writeErr = err
+			cancelStream()
+		}
+	}
+	// Each connection runs on its own copy of the route: runners enqueue passengers into
+	// the stops, so sharing s.Route would mix (and race on) concurrent streams' queues.
+	base, _ := s.corridor(opt.Corridor) // validated by streamOptions
+	route := base.Clone()
+	for _, b := range connBuses {
+		b.RouteID = route.ID
+	}
+	params := map[string]any{"route": s.corridorID(base), "period": opt.PeriodID, "passenger_cap": opt.PassengerCap, "dir_bias": opt.DirBias, "spatial_gradient": opt.SpatialGradient, "baseline_demand": opt.BaselineDemand, "seed": seedBase, "lambda": lambda, "start": start.Format(time.RFC3339Nano), "stop_criterion": sim.DescribeCriterion(opt.PassengerCap, opt.Criterion)}
+	// Build control adapter to read live controls
+	var _ sim.Control = ctrlAdapter{}
+	if opt.Source == "avl" {
+		params["source"] = "avl"
+		params["avl_points"] = len(s.Opt.AVL.Points)
+	}
+	var evCh <-chan sim.Event
+	var stopFn, waitFn func()
+	if opt.Source == "avl" {
+		evCh, stopFn, waitFn = sim.StartPlayback(ctx, route, connBuses, *s.Opt.AVL, opt.PeriodID, s.Opt.Headway, s.Opt.AVLMatch, sim.NewPacedClock(start, ctrlAdapter{c: ctrl}.Speed))
+	} else {
+		evCh, stopFn, waitFn = sim.StartRunner(ctx, route, connBuses, engineSeed, lambda, sim.RunnerOptions{PeriodID: opt.PeriodID, PassengerCap: opt.PassengerCap, MorningTowardKivukoni: opt.MorningTowardKivukoni, DirBias: opt.DirBias, SpatialGradient: opt.SpatialGradient, BaselineDemand: opt.BaselineDemand, DemandProfile: s.Opt.DemandProfile, StallTimeout: s.Opt.StallTimeout, GroupSizes: s.Opt.GroupSizes, Population: s.Opt.Population, Seeding: s.Opt.Seeding, RecordPassengers: s.Opt.PassengerLogPath != "" || s.Opt.ExportFormat != "", RecordDecisions: s.Opt.DecisionLogPath != "", Traffic: s.Opt.Traffic, Criterion: opt.Criterion, Headway: s.Opt.Headway, Shifts: s.Opt.Shifts, Day: s.Opt.Day, Live: live, Trajectories: traj, MetricsInterval: s.Opt.MetricsInterval, ETAInterval: s.Opt.ETAInterval, Platforms: s.Opt.Platforms, Berths: s.Opt.Berths, Turnaround: s.Opt.Turnaround, Resync: ctrl.resync, Closures: ctrl.closures, BusCommands: ctrl.buses, DemandChanges: ctrl.demand, Injections: ctrl.inject, TraceBusID: s.Opt.TraceBusID, ConnID: connID, Start: start, Clock: sim.NewPacedClock(start, ctrlAdapter{c: ctrl}.Speed), Backpressure: s.Opt.Backpressure, EventBuffer: s.Opt.EventBuffer, SlowConsumerTimeout: s.Opt.SlowClientTimeout}, ctrlAdapter{c: ctrl})
+	}
+
+	// Ensure cleanup if client disconnects early
+	defer stopFn()
+	defer waitFn()
+
+	// Report a run whose channel stays open long after cancellation (leaked goroutines)
+	loopDone := make(chan struct{})
+	go func() {
+		select {
+		case <-loopDone:
+			return
+		case <-ctx.Done():
+		}
+		select {
+		case <-loopDone:
+		case <-time.After(runnerExitTimeout):
+			slog.Error("runner did not terminate after cancellation", "conn", connID, "timeout", runnerExitTimeout)
+		}
+	}()
+
+	// Capture final metrics for reporting
+	var finalDone *sim.DoneEvent
+	var quality sim.QualityScore
+	capacityNote := ""
+	throttle := newEventThrottle(s.Opt.EventThrottle, false)
+	if opt.MaxEventRate > 0 {
+		throttle = newEventThrottle(time.Duration(float64(time.Second)/opt.MaxEventRate), true)
+	}
+	frames := newFrameBuffer(opt.FrameInterval)
+	for e := range evCh {
+		if frames.pending() && !frames.coalesces(e) {
+			flush("frame", frames.take(time.Now()))
+		}
+		switch ev := e.(type) {
+		case sim.InitEvent:
+			flush("init", map[string]any{"time": ev.Time, "buses": []any{}, "message": "started", "conn_id": ev.ConnID, "schema_version": sim.EventSchemaVersion, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGen, "inbound_generated": ev.InboundGen, "served_passengers": 0, "avg_wait_min": ev.AvgWaitMin, "arrival_factor": ev.ArrivalFactor, "params": params})
+		case sim.CapacityWarningEvent:
+			capacityNote = ev.Check.Note()
+			flush("capacity_warning", map[string]any{"message": capacityNote, "check": ev.Check})
+		case sim.InitialStateEvent:
+			stops := make([]map[string]any, len(ev.Stops))
+			for i, q := range ev.Stops {
+				stops[i] = map[string]any{"stop_id": q.StopID, "outbound_queue": q.OutboundQueue, "inbound_queue": q.InboundQueue}
+			}
+			flush("initial_state", map[string]any{"time": ev.Time, "stops": stops, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated})
+		case sim.StateEvent:
+			buses := make([]map[string]any, len(ev.Buses))
+			for i, b := range ev.Buses {
+				buses[i] = map[string]any{"bus_id": b.BusID, "direction": b.Direction, "lat": b.Lat, "lng": b.Lng, "from": b.From, "to": b.To, "t": b.T, "phase": b.Phase, "bus_onboard": b.Onboard, "capacity": b.Capacity}
+			}
+			stops := make([]map[string]any, len(ev.Stops))
+			for i, q := range ev.Stops {
+				stops[i] = map[string]any{"stop_id": q.StopID, "outbound_queue": q.OutboundQueue, "inbound_queue": q.InboundQueue}
+			}
+			flush("state", map[string]any{"time": ev.Time, "buses": buses, "stops": stops, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated, "served_passengers": ev.ServedPassengers, "avg_wait_min": sim.ReportPrecision.Minutes(ev.AvgWaitMin, true), "closed_stops": ev.ClosedStops})
+		case sim.StopUpdateEvent:
+			p := map[string]any{"stop_id": ev.StopID, "outbound_queue": ev.OutboundQueue, "inbound_queue": ev.InboundQueue, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated}
+			if frames != nil {
+				frames.addStop(ev.StopID, p)
+				if now := time.Now(); frames.due(now) {
+					flush("frame", frames.take(now))
+				}
+				continue
+			}
+			if !throttle.allow("stop/"+strconv.Itoa(ev.StopID), time.Now(), ctrl.fast()) {
+				continue
+			}
+			flush("stop_update", p)
+		case sim.BusAddEvent:
+			flush("bus_add", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "avg_speed_kmph": ev.AvgSpeedKmph, "capacity": ev.Capacity})
+		case sim.ArriveEvent:
+			flush("arrive", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "stop_id": ev.StopID, "time": ev.Time, "bus_onboard": ev.BusOnboard, "passengers_onboard": ev.PassengersOnboard, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated})
+		case sim.DoorsOpenEvent:
+			flush("doors_open", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "stop_id": ev.StopID, "time": ev.Time, "onboard": ev.Onboard})
+		case sim.DoorsCloseEvent:
+			flush("doors_close", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "stop_id": ev.StopID, "time": ev.Time, "opened_at": ev.OpenedAt, "alighted": ev.Alighted, "boarded": ev.Boarded, "onboard": ev.Onboard, "dwell_s": ev.DwellSec, "dead_time_s": ev.DeadTimeSec, "service_time_s": ev.ServiceTimeSec})
+		case sim.AlightEvent:
+			flush("alight", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "stop_id": ev.StopID, "alighted": ev.Alighted, "bus_onboard": ev.BusOnboard, "passengers_onboard": ev.PassengersOnboard, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated, "final": ev.Final, "served_passengers": ev.ServedPassengers})
+		case sim.BoardEvent:
+			flush("board", map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "stop_id": ev.StopID, "boarded": ev.Boarded, "bus_onboard": ev.BusOnboard, "passengers_onboard": ev.PassengersOnboard, "stop_outbound": ev.StopOutbound, "stop_inbound": ev.StopInbound, "generated_passengers": ev.Generated, "outbound_generated": ev.OutboundGenerated, "inbound_generated": ev.InboundGenerated, "served_passengers": ev.ServedPassengers, "avg_wait_min": ev.AvgWaitMin, "stop_avg_wait_min": sim.ReportPrecision.Minutes(ev.StopAvgWaitMin, true), "stop_wait_samples": ev.StopWaitSamples})
+		case sim.ShiftEvent:
+			flush("shift", ev)
+		case sim.ClosureEvent:
+			flush("stop_closure", ev)
+		case sim.PeriodChangeEvent:
+			flush("period_change", ev)
+		case sim.PlatformOverflowEvent:
+			flush("platform_overflow", ev)
+		case sim.BerthEvent:
+			flush("berth", ev)
+		case sim.TurnaroundEvent:
+			flush("turnaround", ev)
+		case sim.BusControlEvent:
+			flush("bus_control", ev)
+		case sim.DemandChangeEvent:
+			flush("demand_change", ev)
+		case sim.InjectEvent:
+			flush("inject", ev)
+		case sim.StopETAEvent:
+			for _, list := range [][]sim.BusETA{ev.Outbound, ev.Inbound} {
+				for i := range list {
+					list[i].ETAMin = sim.ReportPrecision.Minutes(list[i].ETAMin, true)
 				}
 			}
-			sim.PrintConsoleReport(connBuses, sum)
+			flush("stop_eta", ev)
+		case sim.SkipEvent:
+			flush("skip", ev)
+		case sim.AnomalyEvent:
+			flush("anomaly", ev)
+		case sim.MetricsEvent:
+			pr := sim.ReportPrecision
+			flush("metrics", map[string]any{"time": ev.Time, "generated_passengers": ev.Generated, "served_passengers": ev.ServedPassengers, "avg_wait_min": pr.Minutes(ev.AvgWaitMin, true), "waiting": ev.Waiting, "onboard": ev.Onboard, "in_system": ev.InSystem, "fleet_utilization": ev.FleetUtilization, "queue_max_wait_min": pr.Minutes(ev.QueueMaxWaitMin, true), "queue_avg_wait_min": pr.Minutes(ev.QueueAvgWaitMin, true), "oldest_stop_id": ev.OldestStopID, "headway_adherence": ev.HeadwayAdherence, "headway_samples": ev.HeadwaySamples, "excess_wait_min": pr.Minutes(ev.ExcessWaitMin, true)})
+		case sim.MoveEvent:
+			p := map[string]any{"bus_id": ev.BusID, "direction": ev.Direction, "lat": ev.Lat, "lng": ev.Lng, "t": ev.T, "from": ev.From, "to": ev.To, "phase": ev.Phase}
+			if frames != nil {
+				frames.addMove(ev.BusID, p)
+				if now := time.Now(); frames.due(now) {
+					flush("frame", frames.take(now))
+				}
+				continue
+			}
+			if !throttle.allow("bus/"+strconv.Itoa(ev.BusID), time.Now(), ctrl.fast()) {
+				continue
+			}
+			flush("move", p)
+		case sim.LayoverEvent:
+			flush("layover", map[string]any{"bus_id": ev.BusID, "terminal_stop_id": ev.TerminalStopID})
+		case sim.RepositionStartEvent:
+			flush("reposition_start", map[string]any{"buses": ev.Buses, "layover_indices": ev.LayoverIndices})
+		case sim.RepositionBusEvent:
+			flush("reposition_bus", map[string]any{"bus_id": ev.BusID, "from_index": ev.FromIndex, "target_index": ev.TargetIndex, "current_stop_id": ev.CurrentStopID, "ahead_only": ev.AheadOnly})
+		case sim.RepositionCompleteEvent:
+			flush("reposition_complete", map[string]any{"elapsed_ms": ev.ElapsedMs})
+		case sim.DoneEvent:
+			// Remember final metrics and forward done downstream
+			finalDone = &ev
+			span.SetAttributes(attribute.Int("generated", ev.Generated), attribute.Int64("served", ev.ServedPassengers), attribute.Bool("stalled", ev.Stalled))
+			quality = sim.ComputeQuality(ev.Wait, ev.BusStats, ev.StopStats, ev.Stalled, s.Opt.QualityWeights)
+			pr := sim.ReportPrecision
+			dist := make(map[int]float64, len(ev.BusDistance))
+			for id, km := range ev.BusDistance {
+				dist[id] = pr.KM(km, true)
+			}
+			flush("done", map[string]any{"completed": ev.Completed, "ended_by": ev.EndedBy, "stalled": ev.Stalled, "diagnostic": ev.Diagnostic, "aborted": ev.Aborted, "generated_passengers": ev.Generated, "served_passengers": ev.ServedPassengers, "avg_wait_min": pr.Minutes(ev.AvgWaitMin, true), "wait_p50_min": pr.Minutes(ev.Wait.Overall.P50, true), "wait_p90_min": pr.Minutes(ev.Wait.Overall.P90, true), "wait_p95_min": pr.Minutes(ev.Wait.Overall.P95, true), "bus_distance": dist, "bus_stats": ev.BusStats, "fleet_time_utilization": sim.FleetTime(ev.BusStats).Utilization, "trip_times": sim.FleetTripTimes(ev.BusStats), "bus_types": sim.TypeBreakdown(connBuses, ev.BusStats, ev.BusDistance, true), "zones": ev.Zones, "peak_load": ev.Load.Peak, "headway": ev.Headway, "stop_headways": ev.StopHeadways, "anomalies": ev.Anomalies, "shifts": ev.Shifts, "closures": ev.Closures, "closure_unserved": ev.ClosureUnserved, "periods": ev.Periods, "eta_accuracy": ev.ETAAccuracy, "platforms": ev.Platforms, "berths": ev.Berths, "turnarounds": ev.Turnarounds, "directions": ev.Directions, "excess_wait": ev.ExcessWait, "throttled_events": throttle.dropped, "dropped_events": ev.DroppedEvents, "quality_score": math.Round(quality.Score*10) / 10, "quality": quality})
 		}
-		return
 	}
-
-	// If legacy explicitly requested, fall back to old inline simulation (currently disabled)
-	http.Error(w, "legacy engine disabled; remove engine=legacy to use runner", http.StatusGone)
+	close(loopDone)
+	if throttle.dropped > 0 {
+		slog.Info("stream events throttled", "conn", connID, "dropped", throttle.dropped, "interval", s.Opt.EventThrottle)
+	}
+	if chaos != nil {
+		dropped, delayed, cut := chaos.counts()
+		slog.Info("chaos stream finished", "conn", connID, "dropped", dropped, "delayed", delayed, "disconnected", cut, "write_err", writeErr, "done_received", finalDone != nil, "goroutines", runtime.NumGoroutine())
+	}
+	// After stream closes, write reports if requested
+	if finalDone != nil {
+		meta := map[string]any{"driver": "stream", "morning_toward_kivukoni": opt.MorningTowardKivukoni, "population": s.Opt.Population, "demand_profile": s.Opt.DemandProfile, "stall_timeout": s.Opt.StallTimeout.String(), "shifts": s.Opt.Shifts.String(), "day": s.Opt.Day.String(), "conn_id": connID}
+		for k, v := range params {
+			meta[k] = v
+		}
+		sum := sim.ReportSummary{Metadata: sim.RunMetadata(meta, connBuses), Criterion: sim.DescribeCriterion(opt.PassengerCap, opt.Criterion), EndedBy: finalDone.EndedBy, Generated: finalDone.Generated, Served: finalDone.ServedPassengers, AvgWaitMin: finalDone.AvgWaitMin, BusDistance: finalDone.BusDistance, Stalled: finalDone.Stalled, Diagnostic: finalDone.Diagnostic, Aborted: finalDone.Aborted, Wait: &finalDone.Wait, Stops: finalDone.StopStats, Zones: finalDone.Zones, PeakLoad: finalDone.Load.Peak, Headway: finalDone.Headway, StopHeadways: finalDone.StopHeadways, Anomalies: finalDone.Anomalies, Shifts: finalDone.Shifts, Closures: finalDone.Closures, Periods: finalDone.Periods, ETAAccuracy: finalDone.ETAAccuracy, Platforms: finalDone.Platforms, Berths: finalDone.Berths, Turnarounds: finalDone.Turnarounds, Directions: finalDone.Directions, ExcessWait: &finalDone.ExcessWait, BusStats: finalDone.BusStats, Quality: &quality, Capacity: capacityNote}
+		if s.Opt.ReportPath != "" {
+			if _, err := report.Write(s.Opt.ReportPath, "", connBuses, sum); err != nil {
+				slog.Error("report: create failed", "err", err)
+			}
+		}
+		if s.Opt.DwellReportPath != "" {
+			if _, err := sim.WriteDwellReport(s.Opt.DwellReportPath, finalDone.DwellStats); err != nil {
+				slog.Error("dwell report: create failed", "err", err)
+			}
+		}
+		if s.Opt.LoadReportPath != "" {
+			if _, err := sim.WriteLoadReport(s.Opt.LoadReportPath, finalDone.Load); err != nil {
+				slog.Error("load report: create failed", "err", err)
+			}
+		}
+		if s.Opt.PassengerLogPath != "" {
+			if _, err := sim.WritePassengerLog(s.Opt.PassengerLogPath, finalDone.Passengers); err != nil {
+				slog.Error("passenger log: create failed", "err", err)
+			}
+		}
+		if s.Opt.DecisionLogPath != "" {
+			if _, err := sim.WriteDecisionLog(s.Opt.DecisionLogPath, finalDone.Decisions); err != nil {
+				slog.Error("decision log: create failed", "err", err)
+			}
+		}
+		if s.Opt.TrajectoryLogPath != "" {
+			if _, err := sim.WriteTrajectories(s.Opt.TrajectoryLogPath, traj, s.Opt.SimplifyToleranceM); err != nil {
+				slog.Error("trajectories: create failed", "err", err)
+			}
+		}
+		if s.Opt.ExportFormat != "" {
+			if paths, err := export.Write(s.Opt.ExportFormat, s.Opt.ExportDir, route, finalDone.Passengers, s.Opt.SimplifyToleranceM); err != nil {
+				slog.Error("export failed", "err", err)
+			} else {
+				slog.Info("export written", "format", s.Opt.ExportFormat, "files", paths)
+			}
+		}
+		sim.PrintConsoleReport(connBuses, sum)
+		s.runs.Add(sim.NewRunResult(connID, started, params, connBuses, *finalDone, quality))
+	}
 }
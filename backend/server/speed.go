@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxSpeed is the highest time scale of a stream unless Options.MaxSpeed raises
+// it; above it, high-rate events are throttled (Options.EventThrottle).
+const DefaultMaxSpeed = 10.0
+
+// minSpeed is the slowest time scale of a stream.
+const minSpeed = 0.1
+
+// maxSpeed returns the highest time scale streams may run at. It stays finite: bus,
+// generator and criterion goroutines of a run order themselves by their paced sleeps,
+// so an unpaced stream would end before the buses moved.
+func (o *Options) maxSpeed() float64 {
+	if o.MaxSpeed <= 0 || math.IsNaN(o.MaxSpeed) || math.IsInf(o.MaxSpeed, 0) {
+		return DefaultMaxSpeed
+	}
+	return o.MaxSpeed
+}
+
+// clampSpeed bounds a requested time scale to [minSpeed, max]; "max" requests (+Inf)
+// run at max itself.
+func clampSpeed(sp, max float64) float64 {
+	if sp < minSpeed || math.IsNaN(sp) {
+		return minSpeed
+	}
+	if sp > max {
+		return max
+	}
+	return sp
+}
+
+// parseSpeed parses a time scale: a positive number, or "max" for the fastest allowed.
+func parseSpeed(s string) (float64, error) {
+	if strings.EqualFold(strings.TrimSpace(s), "max") {
+		return math.Inf(1), nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || math.IsNaN(v) {
+		return 0, fmt.Errorf("speed must be a positive number or \"max\", got %q", s)
+	}
+	return v, nil
+}
+
+// speedValue is a time scale in a control request: a number or the string "max".
+type speedValue float64
+
+func (v *speedValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		f, err := parseSpeed(s)
+		if err != nil {
+			return err
+		}
+		*v = speedValue(f)
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return err
+	}
+	*v = speedValue(f)
+	return nil
+}
+
+// eventThrottle limits high-rate events (bus moves, stop queue updates) to one per key
+// per interval of wall time, so a fast stream does not flood the client. Terminal
+// events (arrivals, done) are never throttled and a "resync" control restores the
+// exact state. Caller must ensure synchronization.
+type eventThrottle struct {
+	every   time.Duration
+	always  bool // throttle at any speed (a client-requested rate)
+	last    map[string]time.Time
+	dropped int
+}
+
+func newEventThrottle(every time.Duration, always bool) *eventThrottle {
+	return &eventThrottle{every: every, always: always, last: make(map[string]time.Time)}
+}
+
+// allow reports whether the event for key may be sent at now; unless the throttle
+// applies always, fast is false while the stream runs at or below DefaultMaxSpeed and
+// every event goes through.
+func (t *eventThrottle) allow(key string, now time.Time, fast bool) bool {
+	if t == nil || t.every <= 0 || !(fast || t.always) {
+		return true
+	}
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.every {
+		t.dropped++
+		return false
+	}
+	t.last[key] = now
+	return true
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"brt08/backend/sim"
+)
+
+// handleVersion reports the engine and build that serve this API, with the features
+// the server was started with, so clients and recorded runs can be matched to it.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	bi := sim.ReadBuildInfo()
+	bi.Features = s.features()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(bi)
+}
+
+// features lists the optional capabilities enabled by the server options.
+func (s *Server) features() []string {
+	o := &s.Opt
+	var out []string
+	add := func(on bool, name string) {
+		if on {
+			out = append(out, name)
+		}
+	}
+	_, eco := o.Traffic.(*sim.EcoAdvisor)
+	add(o.Traffic != nil && !eco, "traffic")
+	add(eco, "eco")
+	add(o.DemandProfile == "period", "period_profile")
+	add(o.Population > 0, "population")
+	add(o.MetricsInterval > 0, "metrics")
+	add(o.MetricsInterval > 0, "anomalies")
+	add(o.ETAInterval > 0, "eta_displays")
+	add(o.Platforms.Default > 0 || o.Platforms.Spill, "platform_capacity")
+	add(o.Berths.Default > 0, "berths")
+	add(o.Turnaround.Bays > 0, "turnaround_bays")
+	add(o.Turnaround.Recovery.Enabled(), "recovery")
+	add(o.Criterion.Active(), "stop_criterion")
+	add(o.Shifts.Enabled(), "shifts")
+	add(o.Day.Enabled(), "full_day")
+	add(o.AVL != nil, "avl_playback")
+	add(o.maxSpeed() > DefaultMaxSpeed, "fast_streaming")
+	add(o.Backpressure != "" && o.Backpressure != sim.BackpressureBlock, "backpressure_"+string(o.Backpressure))
+	add(o.RunHistory > 0, "run_history")
+	add(o.ReportPath != "", "report")
+	add(o.PassengerLogPath != "", "passenger_log")
+	add(o.DecisionLogPath != "", "decision_log")
+	add(o.TrajectoryLogPath != "", "trajectory_log")
+	add(o.DwellReportPath != "", "dwell_report")
+	add(o.LoadReportPath != "", "load_report")
+	add(o.ExportFormat != "", "export_"+o.ExportFormat)
+	add(o.Sink != nil, "event_sink")
+	add(o.GRPC, "grpc")
+	add(o.Chaos.Enabled(), "chaos")
+	add(len(o.Corridors) > 1, "corridors")
+	add(o.Static != nil, "static")
+	return out
+}
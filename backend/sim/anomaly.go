@@ -0,0 +1,215 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// Anomaly kinds.
+const (
+	AnomalyQueueGrowth   = "queue_growth"   // passengers waiting grow much faster than usual
+	AnomalyWaitDoubling  = "wait_doubling"  // mean boarding wait doubled from one wait window to the next
+	AnomalyBusStationary = "bus_stationary" // an in-service bus has not moved for too long
+)
+
+// AnomalyConfig tunes the online detectors; DefaultAnomalyConfig gives the defaults.
+type AnomalyConfig struct {
+	GrowthWindow     time.Duration // span over which the queue growth rate is measured
+	QueueGrowthSigma float64       // spike when the growth rate exceeds the running mean by this many deviations
+	MinQueueGrowth   float64       // ... and is at least this many passengers per minute
+	Warmup           time.Duration // simulated time before the queue and wait detectors fire
+	WaitWindow       time.Duration // the mean boarding wait of this window is compared with the one before
+	WaitFactor       float64       // ratio to the previous window (and the run average) that counts as doubling
+	MinWaitMin       float64       // ignore window means below this many minutes
+	MinWaitSamples   int           // boardings each window needs for a comparison
+	StationaryLimit  time.Duration // simulated time without movement that flags a bus
+}
+
+// DefaultAnomalyConfig returns conservative thresholds meant to flag real problems in
+// long unattended runs rather than ordinary demand swings.
+func DefaultAnomalyConfig() AnomalyConfig {
+	return AnomalyConfig{GrowthWindow: 5 * time.Minute, QueueGrowthSigma: 4, MinQueueGrowth: 5, Warmup: 15 * time.Minute, WaitWindow: 10 * time.Minute, WaitFactor: 2, MinWaitMin: 2, MinWaitSamples: 10, StationaryLimit: 15 * time.Minute}
+}
+
+// AnomalyDetector runs simple online detectors over the KPI heartbeats. Each condition
+// fires once when it starts and re-arms after it clears. Caller must ensure
+// synchronization.
+type AnomalyDetector struct {
+	cfg     AnomalyConfig
+	first   time.Time
+	samples []anomalySample // heartbeats covering the longest lookback
+
+	// queue growth: running mean and variance of the growth rate (Welford)
+	n           int
+	mean, m2    float64
+	queueActive bool
+	waitActive  bool
+
+	// stationary buses: distance at the last movement
+	moved      map[int]busMove
+	stationary map[int]bool
+
+	events []AnomalyEvent
+}
+
+type anomalySample struct {
+	at        time.Time
+	waiting   int
+	waitSum   float64 // boarding waits so far (minutes)
+	waitCount int
+}
+
+type busMove struct {
+	km float64
+	at time.Time
+}
+
+// NewAnomalyDetector returns a detector with cfg (zero fields take the defaults).
+func NewAnomalyDetector(cfg AnomalyConfig) *AnomalyDetector {
+	def := DefaultAnomalyConfig()
+	if cfg.GrowthWindow <= 0 {
+		cfg.GrowthWindow = def.GrowthWindow
+	}
+	if cfg.QueueGrowthSigma <= 0 {
+		cfg.QueueGrowthSigma = def.QueueGrowthSigma
+	}
+	if cfg.MinQueueGrowth <= 0 {
+		cfg.MinQueueGrowth = def.MinQueueGrowth
+	}
+	if cfg.Warmup <= 0 {
+		cfg.Warmup = def.Warmup
+	}
+	if cfg.WaitWindow <= 0 {
+		cfg.WaitWindow = def.WaitWindow
+	}
+	if cfg.WaitFactor <= 1 {
+		cfg.WaitFactor = def.WaitFactor
+	}
+	if cfg.MinWaitMin <= 0 {
+		cfg.MinWaitMin = def.MinWaitMin
+	}
+	if cfg.MinWaitSamples <= 0 {
+		cfg.MinWaitSamples = def.MinWaitSamples
+	}
+	if cfg.StationaryLimit <= 0 {
+		cfg.StationaryLimit = def.StationaryLimit
+	}
+	return &AnomalyDetector{cfg: cfg, moved: make(map[int]busMove), stationary: make(map[int]bool)}
+}
+
+// Observe checks a heartbeat and the fleet (distance per bus so far) and returns the
+// anomalies that started with it.
+func (d *AnomalyDetector) Observe(m MetricsEvent, buses []*model.Bus, busDistance map[int]float64) []AnomalyEvent {
+	if d.first.IsZero() {
+		d.first = m.Time
+	}
+	d.samples = append(d.samples, anomalySample{at: m.Time, waiting: m.Waiting, waitSum: m.AvgWaitMin * float64(m.WaitSamples), waitCount: m.WaitSamples})
+	keep := 2 * d.cfg.WaitWindow
+	if d.cfg.GrowthWindow > keep {
+		keep = d.cfg.GrowthWindow
+	}
+	// drop samples older than needed, keeping one at or before the lookback
+	i := 0
+	for i+1 < len(d.samples) && !d.samples[i+1].at.After(m.Time.Add(-keep)) {
+		i++
+	}
+	d.samples = d.samples[i:]
+
+	var out []AnomalyEvent
+	warm := m.Time.Sub(d.first) >= d.cfg.Warmup
+	out = append(out, d.queueGrowth(m, warm)...)
+	if warm {
+		out = append(out, d.waitDoubling(m)...)
+	}
+	out = append(out, d.busStationary(m.Time, buses, busDistance)...)
+	d.events = append(d.events, out...)
+	return out
+}
+
+// Events returns every anomaly detected so far.
+func (d *AnomalyDetector) Events() []AnomalyEvent {
+	return append([]AnomalyEvent(nil), d.events...)
+}
+
+// sampleAt returns the newest sample at or before t.
+func (d *AnomalyDetector) sampleAt(t time.Time) (anomalySample, bool) {
+	for i := len(d.samples) - 1; i >= 0; i-- {
+		if !d.samples[i].at.After(t) {
+			return d.samples[i], true
+		}
+	}
+	return anomalySample{}, false
+}
+
+func (d *AnomalyDetector) queueGrowth(m MetricsEvent, warm bool) []AnomalyEvent {
+	ref, ok := d.sampleAt(m.Time.Add(-d.cfg.GrowthWindow))
+	if !ok || !m.Time.After(ref.at) {
+		return nil
+	}
+	rate := float64(m.Waiting-ref.waiting) / m.Time.Sub(ref.at).Minutes()
+	var out []AnomalyEvent
+	if warm && d.n >= 2 {
+		sd := math.Sqrt(d.m2 / float64(d.n-1))
+		threshold := math.Max(d.mean+d.cfg.QueueGrowthSigma*sd, d.cfg.MinQueueGrowth)
+		spike := rate >= threshold
+		if spike && !d.queueActive {
+			out = append(out, AnomalyEvent{Time: m.Time, Kind: AnomalyQueueGrowth, Value: rate, Baseline: d.mean,
+				Message: fmt.Sprintf("waiting passengers grew by %.1f/min over %s (usual %.1f/min); %d now waiting", rate, d.cfg.GrowthWindow, d.mean, m.Waiting)})
+		}
+		d.queueActive = spike
+	}
+	// update the running statistics (spikes included, so a lasting change becomes the norm)
+	d.n++
+	delta := rate - d.mean
+	d.mean += delta / float64(d.n)
+	d.m2 += delta * (rate - d.mean)
+	return out
+}
+
+func (d *AnomalyDetector) waitDoubling(m MetricsEvent) []AnomalyEvent {
+	mid, ok1 := d.sampleAt(m.Time.Add(-d.cfg.WaitWindow))
+	old, ok2 := d.sampleAt(m.Time.Add(-2 * d.cfg.WaitWindow))
+	cur := anomalySample{waitSum: m.AvgWaitMin * float64(m.WaitSamples), waitCount: m.WaitSamples}
+	if !ok1 || !ok2 || cur.waitCount-mid.waitCount < d.cfg.MinWaitSamples || mid.waitCount-old.waitCount < d.cfg.MinWaitSamples {
+		return nil
+	}
+	now := (cur.waitSum - mid.waitSum) / float64(cur.waitCount-mid.waitCount)
+	before := (mid.waitSum - old.waitSum) / float64(mid.waitCount-old.waitCount)
+	// against the run mean too, so a quiet window followed by an ordinary one (bunched
+	// service) does not count
+	baseline := math.Max(before, m.AvgWaitMin)
+	doubled := before > 0 && now >= d.cfg.MinWaitMin && now >= baseline*d.cfg.WaitFactor
+	var out []AnomalyEvent
+	if doubled && !d.waitActive {
+		out = append(out, AnomalyEvent{Time: m.Time, Kind: AnomalyWaitDoubling, StopID: m.OldestStopID, Value: now, Baseline: before,
+			Message: fmt.Sprintf("average wait of boarding passengers rose from %.1f to %.1f min between consecutive %s windows (run average %.1f min)", before, now, d.cfg.WaitWindow, m.AvgWaitMin)})
+	}
+	d.waitActive = doubled
+	return out
+}
+
+func (d *AnomalyDetector) busStationary(at time.Time, buses []*model.Bus, busDistance map[int]float64) []AnomalyEvent {
+	var out []AnomalyEvent
+	for _, b := range buses {
+		km := busDistance[b.ID]
+		last, ok := d.moved[b.ID]
+		if !ok || km != last.km {
+			d.moved[b.ID] = busMove{km: km, at: at}
+			d.stationary[b.ID] = false
+			continue
+		}
+		// buses that have not entered service yet are parked, not stuck
+		if km == 0 || d.stationary[b.ID] {
+			continue
+		}
+		if still := at.Sub(last.at); still >= d.cfg.StationaryLimit {
+			d.stationary[b.ID] = true
+			out = append(out, AnomalyEvent{Time: at, Kind: AnomalyBusStationary, BusID: b.ID, StopID: b.CurrentStopID, Value: still.Minutes(),
+				Message: fmt.Sprintf("bus %d has not moved for %.0f min (near stop %d, %d onboard)", b.ID, still.Minutes(), b.CurrentStopID, b.PassengersOnboard)})
+		}
+	}
+	return out
+}
@@ -0,0 +1,427 @@
+package sim
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// Map matching defaults of AVL playback.
+const (
+	DefaultAVLStopRadiusM = 40.0  // a position this close to a stop is a bus at the stop
+	DefaultAVLMaxOffsetM  = 150.0 // positions farther than this from the route are dropped
+)
+
+// AVLPoint is one GPS/AVL position report of a bus.
+type AVLPoint struct {
+	BusID int
+	Time  time.Time
+	Lat   float64
+	Lng   float64
+}
+
+// AVLTrace is a recorded set of position reports, in time order (ties by bus id).
+type AVLTrace struct {
+	Points []AVLPoint
+}
+
+// ReadAVLTrace reads an AVL trace CSV file (see ParseAVLTrace).
+func ReadAVLTrace(path string) (AVLTrace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return AVLTrace{}, err
+	}
+	defer f.Close()
+	t, err := ParseAVLTrace(f)
+	if err != nil {
+		return AVLTrace{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// ParseAVLTrace parses a CSV with a header naming the columns bus_id, timestamp, lat
+// and lng (or lon); other columns are ignored. Timestamps are RFC 3339, local
+// "YYYY-MM-DD HH:MM:SS" or Unix seconds.
+func ParseAVLTrace(r io.Reader) (AVLTrace, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return AVLTrace{}, fmt.Errorf("avl: reading header: %w", err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "lon" {
+			h = "lng"
+		}
+		col[h] = i
+	}
+	for _, name := range []string{"bus_id", "timestamp", "lat", "lng"} {
+		if _, ok := col[name]; !ok {
+			return AVLTrace{}, fmt.Errorf("avl: header lacks a %s column", name)
+		}
+	}
+	var t AVLTrace
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return AVLTrace{}, fmt.Errorf("avl: %w", err)
+		}
+		field := func(name string) string {
+			if i := col[name]; i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		id, err1 := strconv.Atoi(field("bus_id"))
+		at, err2 := parseAVLTime(field("timestamp"))
+		lat, err3 := strconv.ParseFloat(field("lat"), 64)
+		lng, err4 := strconv.ParseFloat(field("lng"), 64)
+		if err := firstErr(err1, err2, err3, err4); err != nil {
+			return AVLTrace{}, fmt.Errorf("avl: line %d: %w", line, err)
+		}
+		t.Points = append(t.Points, AVLPoint{BusID: id, Time: at, Lat: lat, Lng: lng})
+	}
+	if len(t.Points) == 0 {
+		return AVLTrace{}, fmt.Errorf("avl: no positions")
+	}
+	sort.SliceStable(t.Points, func(i, j int) bool {
+		a, b := t.Points[i], t.Points[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		return a.BusID < b.BusID
+	})
+	return t, nil
+}
+
+func parseAVLTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateTime, s, time.Local); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(sec*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("bad timestamp %q", s)
+}
+
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start is the time of the first position.
+func (t AVLTrace) Start() time.Time { return t.Points[0].Time }
+
+// Duration is the time the trace covers.
+func (t AVLTrace) Duration() time.Duration { return t.Points[len(t.Points)-1].Time.Sub(t.Start()) }
+
+// Buses returns a bus per id in the trace, in first-seen order, taking type and speed
+// from the fleet bus of the same id (the first fleet bus's type otherwise).
+func (t AVLTrace) Buses(fleet []*model.Bus) []*model.Bus {
+	byID := make(map[int]*model.Bus, len(fleet))
+	for _, b := range fleet {
+		byID[b.ID] = b
+	}
+	var out []*model.Bus
+	seen := make(map[int]bool)
+	for _, p := range t.Points {
+		if seen[p.BusID] {
+			continue
+		}
+		seen[p.BusID] = true
+		b := &model.Bus{ID: p.BusID, Direction: "outbound"}
+		proto := byID[p.BusID]
+		if proto == nil && len(fleet) > 0 {
+			proto = fleet[0]
+		}
+		if proto != nil {
+			b.Type, b.RouteID, b.AverageSpeedKmph = proto.Type, proto.RouteID, proto.AverageSpeedKmph
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// AVLOptions tunes how positions are matched to the route.
+type AVLOptions struct {
+	StopRadiusM float64 // 0 = DefaultAVLStopRadiusM
+	MaxOffsetM  float64 // 0 = DefaultAVLMaxOffsetM
+}
+
+func (o AVLOptions) withDefaults() AVLOptions {
+	if o.StopRadiusM <= 0 {
+		o.StopRadiusM = DefaultAVLStopRadiusM
+	}
+	if o.MaxOffsetM <= 0 {
+		o.MaxOffsetM = DefaultAVLMaxOffsetM
+	}
+	return o
+}
+
+// AVLPlayer turns position reports into the runner's event sequence: a bus within the
+// stop radius arrives at (and opens its doors at) the nearest stop and departs at its
+// last report there, other positions are snapped onto the route as moves. Direction
+// follows the order of the stops visited, or the progress along the route before the
+// first stop. Observed arrivals and dwells feed the same headway and dwell recorders
+// as simulated runs, so the DoneEvent compares directly. Passenger counts are not
+// observed and stay zero. Caller must ensure synchronization.
+type AVLPlayer struct {
+	route    *model.Route
+	geom     *model.RouteGeometry
+	opt      AVLOptions
+	start    time.Time
+	buses    map[int]*avlBus
+	order    []int
+	headways *HeadwayRecorder
+	dwell    *DwellRecorder
+	dist     map[int]float64
+	offRoute int
+}
+
+type avlBus struct {
+	bus     *model.Bus
+	visit   int       // route index of the stop being visited, -1 between stops
+	arrived time.Time // start of the visit
+	last    time.Time // latest report at the stop
+	prevIdx int       // route index of the last stop visited, -1 before the first
+	pos     float64   // latest distance along the route, km
+	placed  bool
+}
+
+// NewAVLPlayer returns a player for route whose trace starts at start; buses are the
+// trace's buses (see AVLTrace.Buses), periodID and headway set the headway targets.
+func NewAVLPlayer(route *model.Route, buses []*model.Bus, start time.Time, periodID int, headway HeadwayConfig, opt AVLOptions) *AVLPlayer {
+	p := &AVLPlayer{route: route, geom: route.Geometry(), opt: opt.withDefaults(), start: start, buses: make(map[int]*avlBus), headways: NewHeadwayRecorder(headway, periodID, start), dwell: NewDwellRecorder(), dist: make(map[int]float64)}
+	for _, b := range buses {
+		p.buses[b.ID] = &avlBus{bus: b, visit: -1, prevIdx: -1}
+		p.order = append(p.order, b.ID)
+	}
+	return p
+}
+
+// Init returns the opening events: the stream start and a BusAddEvent per bus.
+func (p *AVLPlayer) Init() []Event {
+	out := []Event{InitEvent{Time: p.start, ArrivalFactor: 1}}
+	for _, id := range p.order {
+		b := p.buses[id].bus
+		out = append(out, BusAddEvent{BusID: b.ID, Direction: b.Direction, AvgSpeedKmph: b.AverageSpeedKmph, Capacity: busCapacity(b)})
+	}
+	return out
+}
+
+func busCapacity(b *model.Bus) int {
+	if b.Type == nil {
+		return 0
+	}
+	return b.Type.Capacity
+}
+
+// match snaps pt onto the route: the route index of the stop within the stop radius
+// (-1 if none), else the segment start index and fraction along it in route order.
+// ok is false when pt is farther than MaxOffsetM from the route.
+func (p *AVLPlayer) match(pt model.LatLng) (stop, seg int, t float64, ok bool) {
+	best := math.Inf(1)
+	for i, s := range p.route.Stops {
+		if d := model.HaversineKM(pt, model.LatLng{Lat: s.Latitude, Lng: s.Longitude}) * 1000; d < best {
+			best, stop = d, i
+		}
+	}
+	if best <= p.opt.StopRadiusM {
+		return stop, 0, 0, true
+	}
+	best = math.Inf(1)
+	for i := 0; i+1 < len(p.route.Stops); i++ {
+		path := p.geom.Path(p.route.Stops[i].ID, p.route.Stops[i+1].ID)
+		if path == nil {
+			continue
+		}
+		if d, f := nearestOnPath(path, pt); d < best {
+			best, seg, t = d, i, f
+		}
+	}
+	return -1, seg, t, best <= p.opt.MaxOffsetM
+}
+
+// nearestOnPath returns the distance in metres from pt to path and the fraction of
+// the path's length at the nearest point, on a local plane.
+func nearestOnPath(path *model.SegmentPath, pt model.LatLng) (float64, float64) {
+	kx := math.Cos(pt.Lat * math.Pi / 180)
+	best, frac, walked := math.Inf(1), 0.0, 0.0
+	for i := 0; i+1 < len(path.Points); i++ {
+		a, b := path.Points[i], path.Points[i+1]
+		dx, dy := (b.Lng-a.Lng)*kx, b.Lat-a.Lat
+		u := 0.0
+		if l2 := dx*dx + dy*dy; l2 > 0 {
+			u = math.Max(0, math.Min(1, ((pt.Lng-a.Lng)*kx*dx+(pt.Lat-a.Lat)*dy)/l2))
+		}
+		q := model.LatLng{Lat: a.Lat + (b.Lat-a.Lat)*u, Lng: a.Lng + (b.Lng-a.Lng)*u}
+		leg := model.HaversineKM(a, b)
+		if d := model.HaversineKM(pt, q) * 1000; d < best {
+			best = d
+			if path.LengthKM > 0 {
+				frac = (walked + leg*u) / path.LengthKM
+			}
+		}
+		walked += leg
+	}
+	return best, frac
+}
+
+// Feed plays one position report and returns the events it causes. Reports of buses
+// not passed to NewAVLPlayer are ignored.
+func (p *AVLPlayer) Feed(pt AVLPoint) []Event {
+	s := p.buses[pt.BusID]
+	if s == nil {
+		return nil
+	}
+	stop, seg, t, ok := p.match(model.LatLng{Lat: pt.Lat, Lng: pt.Lng})
+	if !ok {
+		p.offRoute++
+		return nil
+	}
+	var out []Event
+	b := s.bus
+	pos := p.route.Stops[seg].CumulativeDist + t*p.route.SegmentKM(seg, seg+1)
+	if stop >= 0 {
+		pos = p.route.Stops[stop].CumulativeDist
+	}
+	if s.placed {
+		p.dist[b.ID] += math.Abs(pos - s.pos)
+	}
+	if stop >= 0 && stop == s.visit {
+		s.last, s.pos, s.placed = pt.Time, pos, true
+		return nil
+	}
+	if s.visit >= 0 {
+		out = append(out, p.depart(s))
+	}
+	switch {
+	case stop >= 0 && s.prevIdx >= 0 && stop != s.prevIdx:
+		b.Direction = directionOf(stop - s.prevIdx)
+	case s.placed && math.Abs(pos-s.pos) >= p.opt.StopRadiusM/1000:
+		b.Direction = directionOf(int(math.Copysign(1, pos-s.pos)))
+	}
+	s.pos, s.placed = pos, true
+	if stop >= 0 {
+		st := p.route.Stops[stop]
+		s.visit, s.arrived, s.last, s.prevIdx = stop, pt.Time, pt.Time, stop
+		b.CurrentStopID = st.ID
+		p.headways.Arrive(st.ID, b.Direction, pt.Time)
+		return append(out, ArriveEvent{BusID: b.ID, Direction: b.Direction, StopID: st.ID, Time: pt.Time}, DoorsOpenEvent{BusID: b.ID, Direction: b.Direction, StopID: st.ID, Time: pt.Time})
+	}
+	from, to := p.route.Stops[seg], p.route.Stops[seg+1]
+	at := p.geom.Path(from.ID, to.ID).At(t)
+	if b.Direction == "inbound" {
+		from, to, t = to, from, 1-t
+	}
+	return append(out, MoveEvent{BusID: b.ID, Direction: b.Direction, Lat: at.Lat, Lng: at.Lng, T: t, From: from.ID, To: to.ID})
+}
+
+func directionOf(step int) string {
+	if step < 0 {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// depart ends the visit of s at its last report at the stop.
+func (p *AVLPlayer) depart(s *avlBus) Event {
+	st := p.route.Stops[s.visit]
+	s.visit = -1
+	p.dwell.Record(st.ID, s.arrived, s.last)
+	return NewDoorsCloseEvent(s.bus, st.ID, s.arrived, s.last, 0, 0)
+}
+
+// Done closes the visits still open and returns the final event of the playback.
+func (p *AVLPlayer) Done(end time.Time, aborted string) []Event {
+	var out []Event
+	for _, id := range p.order {
+		if s := p.buses[id]; s.visit >= 0 {
+			out = append(out, p.depart(s))
+		}
+	}
+	return append(out, DoneEvent{Completed: aborted == "", EndedBy: EndReason(aborted, false, "trace_end", 0), Aborted: aborted, BusDistance: p.dist, DwellStats: p.dwell.Stats(p.route, p.start, end), Headway: p.headways.Stats(), StopHeadways: p.headways.ByStop(p.route)})
+}
+
+// OffRoute is the number of reports dropped as too far from the route.
+func (p *AVLPlayer) OffRoute() int { return p.offRoute }
+
+// PlayAVL plays trace through a new player and passes every event to emit, ending
+// with the DoneEvent, without pacing.
+func PlayAVL(route *model.Route, buses []*model.Bus, trace AVLTrace, periodID int, headway HeadwayConfig, opt AVLOptions, emit func(Event)) *AVLPlayer {
+	p := NewAVLPlayer(route, buses, trace.Start(), periodID, headway, opt)
+	for _, e := range p.Init() {
+		emit(e)
+	}
+	for _, pt := range trace.Points {
+		for _, e := range p.Feed(pt) {
+			emit(e)
+		}
+	}
+	for _, e := range p.Done(trace.Points[len(trace.Points)-1].Time, "") {
+		emit(e)
+	}
+	return p
+}
+
+// StartPlayback replays trace on clock (which should start at trace.Start()) and
+// streams the events like StartRunner: the channel ends with the DoneEvent and is
+// closed after it; stop cancels the playback, wait waits for it to finish.
+func StartPlayback(ctx context.Context, route *model.Route, buses []*model.Bus, trace AVLTrace, periodID int, headway HeadwayConfig, opt AVLOptions, clock Clock) (events <-chan Event, stop func(), wait func()) {
+	ctx, stop = context.WithCancel(ctx)
+	ch := make(chan Event, DefaultEventBuffer)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ch)
+		p := NewAVLPlayer(route, buses, trace.Start(), periodID, headway, opt)
+		send := func(es []Event) {
+			for _, e := range es {
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+		send(p.Init())
+		end, aborted := trace.Start(), ""
+		for _, pt := range trace.Points {
+			if d := pt.Time.Sub(clock.Now()); d > 0 && !clock.Sleep(ctx, d) {
+				aborted = "cancelled"
+				break
+			}
+			clock.Set(pt.Time)
+			end = pt.Time
+			send(p.Feed(pt))
+		}
+		for _, e := range p.Done(end, aborted) {
+			ch <- e
+		}
+	}()
+	return ch, stop, wg.Wait
+}
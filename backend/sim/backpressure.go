@@ -0,0 +1,150 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Backpressure selects what a streaming run does when its consumer stops reading
+// events. Bus goroutines send while holding the run's lock, so under the default
+// policy a stalled consumer freezes the whole simulation.
+type Backpressure string
+
+const (
+	BackpressureBlock      Backpressure = "block"      // wait for the consumer (default)
+	BackpressureDropMoves  Backpressure = "drop_moves" // drop MoveEvents while the buffer is full; others wait
+	BackpressureDisconnect Backpressure = "disconnect" // end the run when a send waits longer than the slow consumer timeout
+	BackpressureExpand     Backpressure = "expand"     // queue events without bound in memory
+)
+
+// DefaultEventBuffer is the capacity of a run's event channel.
+const DefaultEventBuffer = 256
+
+// DefaultSlowConsumerTimeout is how long a send may wait under BackpressureDisconnect.
+const DefaultSlowConsumerTimeout = 5 * time.Second
+
+// ParseBackpressure parses a policy name; empty selects BackpressureBlock.
+func ParseBackpressure(s string) (Backpressure, error) {
+	switch p := Backpressure(strings.TrimSpace(s)); p {
+	case "":
+		return BackpressureBlock, nil
+	case BackpressureBlock, BackpressureDropMoves, BackpressureDisconnect, BackpressureExpand:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown backpressure policy %q (want block, drop_moves, disconnect or expand)", s)
+}
+
+// eventPolicy applies a Backpressure policy to the sends of one run.
+type eventPolicy struct {
+	policy  Backpressure
+	buffer  int
+	timeout time.Duration
+	cancel  context.CancelFunc
+	connID  string
+	dropped atomic.Int64
+	slow    atomic.Bool
+}
+
+func newEventPolicy(opts RunnerOptions, cancel context.CancelFunc) *eventPolicy {
+	p := &eventPolicy{policy: opts.Backpressure, buffer: opts.EventBuffer, timeout: opts.SlowConsumerTimeout, cancel: cancel, connID: opts.ConnID}
+	if p.policy == "" {
+		p.policy = BackpressureBlock
+	}
+	if p.buffer <= 0 {
+		p.buffer = DefaultEventBuffer
+	}
+	if p.timeout <= 0 {
+		p.timeout = DefaultSlowConsumerTimeout
+	}
+	return p
+}
+
+// send delivers e on ch, or gives up once ctx is done.
+func (p *eventPolicy) send(ctx context.Context, ch chan<- Event, e Event) {
+	switch p.policy {
+	case BackpressureDropMoves:
+		if _, ok := e.(MoveEvent); ok {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+			default:
+				p.dropped.Add(1)
+			}
+			return
+		}
+	case BackpressureDisconnect:
+		select {
+		case ch <- e:
+			return
+		default:
+		}
+		t := time.NewTimer(p.timeout)
+		defer t.Stop()
+		select {
+		case ch <- e:
+		case <-ctx.Done():
+		case <-t.C:
+			if !p.slow.Swap(true) {
+				slog.Warn("event consumer stalled; ending run", "conn", p.connID, "timeout", p.timeout)
+			}
+			p.cancel()
+		}
+		return
+	}
+	select {
+	case ch <- e:
+	case <-ctx.Done():
+	}
+}
+
+// abortReason explains a run ended by the policy ("" otherwise).
+func (p *eventPolicy) abortReason() string {
+	if p.slow.Load() {
+		return fmt.Sprintf("slow consumer: an event waited more than %s", p.timeout)
+	}
+	return ""
+}
+
+// output returns the channel the consumer reads. Under BackpressureExpand a pump
+// moves events from in to an unbounded queue, so sends never wait; the queue drains
+// in order and the output closes after in does.
+func (p *eventPolicy) output(in <-chan Event) <-chan Event {
+	if p.policy != BackpressureExpand {
+		return in
+	}
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		var queue []Event
+		peak := 0
+		for in != nil || len(queue) > 0 {
+			var next Event
+			var outCh chan<- Event
+			if len(queue) > 0 {
+				next, outCh = queue[0], out
+			}
+			select {
+			case e, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				queue = append(queue, e)
+				if len(queue) > peak {
+					peak = len(queue)
+				}
+			case outCh <- next:
+				queue[0] = nil
+				queue = queue[1:]
+			}
+		}
+		if peak > p.buffer {
+			slog.Info("event backlog expanded", "conn", p.connID, "peak", peak, "buffer", p.buffer)
+		}
+	}()
+	return out
+}
@@ -0,0 +1,224 @@
+package sim
+
+import (
+	"time"
+
+	"brt08/backend/model"
+)
+
+// BerthPoll is how often, in sim time, a bus queued at a station of the streaming
+// runner checks for a free berth.
+const BerthPoll = time.Second
+
+// BerthConfig sets how many buses a station can serve at once. The zero value leaves
+// every stop without a stated number of berths unlimited.
+type BerthConfig struct {
+	Default int // berths of stops without their own Berths (0 = unlimited)
+}
+
+// BerthEvent reports a bus queuing upstream of a station with every berth taken,
+// docking at a berth or leaving it.
+type BerthEvent struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"` // queued, docked or released
+	BusID    int       `json:"bus_id"`
+	StopID   int       `json:"stop_id"`
+	Berth    int       `json:"berth,omitempty"` // 1-based berth number (docked and released)
+	Occupied int       `json:"occupied"`        // berths taken after the event
+	Berths   int       `json:"berths"`
+	Queued   int       `json:"queued"`           // buses waiting for a berth after the event
+	WaitSec  float64   `json:"wait_s,omitempty"` // docked: time spent queued
+}
+
+func (BerthEvent) isEvent() {}
+
+// BerthStats summarizes the berth use of one station with limited berths.
+type BerthStats struct {
+	StopID      int     `json:"stop_id"`
+	Name        string  `json:"stop_name"`
+	Berths      int     `json:"berths"`
+	Docks       int     `json:"docks"`        // bus visits served
+	Queued      int     `json:"queued"`       // visits that waited for a berth
+	QueueMin    float64 `json:"queue_min"`    // total bus time spent queued
+	MaxWaitMin  float64 `json:"max_wait_min"` // longest single wait
+	MaxQueue    int     `json:"max_queue"`    // most buses waiting at once
+	Utilization float64 `json:"utilization"`  // share of berth time occupied over the run
+}
+
+type berth struct {
+	bus    int       // docked bus (0 = free)
+	since  time.Time // docked at
+	freeAt time.Time // left at, when free
+}
+
+type berthStop struct {
+	berths  []berth
+	queue   []int             // bus ids waiting, first come first served
+	since   map[int]time.Time // queued bus id -> arrival at the station
+	docking []time.Time       // dock times of buses that waited, while still ahead
+	busyMin float64
+	stats   BerthStats
+}
+
+// occupied counts the berths taken at at; a berth left after at is still taken then.
+func (s *berthStop) occupied(at time.Time) int {
+	n := 0
+	for _, b := range s.berths {
+		if b.bus != 0 || b.freeAt.After(at) {
+			n++
+		}
+	}
+	return n
+}
+
+// queued counts the buses waiting at at: those in the queue and those already given a
+// berth that only frees up after at.
+func (s *berthStop) queued(at time.Time) int {
+	n := len(s.queue)
+	for _, d := range s.docking {
+		if d.After(at) {
+			n++
+		}
+	}
+	return n
+}
+
+// BerthTracker allocates the berths of stations with a limited number to arriving
+// buses in arrival order. A nil tracker (no stop is limited) docks every bus at once.
+// Caller must ensure synchronization.
+type BerthTracker struct {
+	stops map[int]*berthStop
+}
+
+// NewBerthTracker returns a tracker for route, or nil when no stop has limited berths.
+func NewBerthTracker(route *model.Route, cfg BerthConfig) *BerthTracker {
+	t := &BerthTracker{stops: make(map[int]*berthStop)}
+	for _, st := range route.Stops {
+		n := st.Berths
+		if n <= 0 {
+			n = cfg.Default
+		}
+		if n > 0 {
+			t.stops[st.ID] = &berthStop{berths: make([]berth, n), since: make(map[int]time.Time), stats: BerthStats{StopID: st.ID, Name: st.Name, Berths: n}}
+		}
+	}
+	if len(t.stops) == 0 {
+		return nil
+	}
+	return t
+}
+
+// Dock asks for a berth for busID arriving at stopID at at. When a berth is free, or
+// frees up after at without another bus in the way, the bus docks and Dock returns
+// the time it reaches the berth and true. Otherwise the bus joins the queue and the
+// caller asks again later. The events are the queuing, when it starts, and the docking.
+func (t *BerthTracker) Dock(stopID, busID int, at time.Time) (time.Time, []BerthEvent, bool) {
+	if t == nil {
+		return at, nil, true
+	}
+	s, ok := t.stops[stopID]
+	if !ok {
+		return at, nil, true
+	}
+	kept := s.docking[:0]
+	for _, d := range s.docking {
+		if d.After(at) {
+			kept = append(kept, d)
+		}
+	}
+	s.docking = kept
+	var events []BerthEvent
+	arrived, waiting := s.since[busID]
+	if !waiting {
+		arrived = at
+	}
+	free := -1
+	for i, b := range s.berths {
+		if b.bus == 0 && (free < 0 || b.freeAt.Before(s.berths[free].freeAt)) {
+			free = i
+		}
+	}
+	first := len(s.queue) == 0 || s.queue[0] == busID
+	dockAt := at
+	if free >= 0 && s.berths[free].freeAt.After(dockAt) {
+		dockAt = s.berths[free].freeAt
+	}
+	if !waiting && (free < 0 || !first || dockAt.After(at)) {
+		// every berth is taken, or only frees up later: wait upstream
+		s.since[busID] = at
+		s.queue = append(s.queue, busID)
+		s.stats.Queued++
+		n := s.queued(at)
+		s.stats.MaxQueue = max(s.stats.MaxQueue, n)
+		events = append(events, BerthEvent{Time: at, Kind: "queued", BusID: busID, StopID: stopID, Occupied: s.occupied(at), Berths: len(s.berths), Queued: n})
+		waiting = true
+	}
+	if free < 0 || waiting && s.queue[0] != busID {
+		return time.Time{}, events, false
+	}
+	if waiting {
+		s.queue = s.queue[1:]
+		delete(s.since, busID)
+		if dockAt.After(at) {
+			s.docking = append(s.docking, dockAt)
+		}
+	}
+	s.berths[free] = berth{bus: busID, since: dockAt}
+	s.stats.Docks++
+	wait := dockAt.Sub(arrived)
+	s.stats.QueueMin += wait.Minutes()
+	s.stats.MaxWaitMin = max(s.stats.MaxWaitMin, wait.Minutes())
+	events = append(events, BerthEvent{Time: dockAt, Kind: "docked", BusID: busID, StopID: stopID, Berth: free + 1, Occupied: s.occupied(dockAt), Berths: len(s.berths), Queued: s.queued(dockAt), WaitSec: wait.Seconds()})
+	return dockAt, events, true
+}
+
+// Release frees the berth busID holds at stopID from at; false when the stop has
+// unlimited berths (or the bus holds none there).
+func (t *BerthTracker) Release(stopID, busID int, at time.Time) (BerthEvent, bool) {
+	if t == nil {
+		return BerthEvent{}, false
+	}
+	s, ok := t.stops[stopID]
+	if !ok {
+		return BerthEvent{}, false
+	}
+	for i, b := range s.berths {
+		if b.bus != busID {
+			continue
+		}
+		if d := at.Sub(b.since); d > 0 {
+			s.busyMin += d.Minutes()
+		}
+		s.berths[i] = berth{freeAt: at}
+		return BerthEvent{Time: at, Kind: "released", BusID: busID, StopID: stopID, Berth: i + 1, Occupied: s.occupied(at), Berths: len(s.berths), Queued: s.queued(at)}, true
+	}
+	return BerthEvent{}, false
+}
+
+// Stats returns the berth use of the stations with limited berths in route order,
+// utilization over start to end with buses still docked counted up to end.
+func (t *BerthTracker) Stats(route *model.Route, start, end time.Time) []BerthStats {
+	if t == nil {
+		return nil
+	}
+	var out []BerthStats
+	span := end.Sub(start).Minutes()
+	for _, st := range route.Stops {
+		s, ok := t.stops[st.ID]
+		if !ok {
+			continue
+		}
+		v := s.stats
+		busy := s.busyMin
+		for _, b := range s.berths {
+			if b.bus != 0 && end.After(b.since) {
+				busy += end.Sub(b.since).Minutes()
+			}
+		}
+		if span > 0 {
+			v.Utilization = busy / (span * float64(len(s.berths)))
+		}
+		out = append(out, v)
+	}
+	return out
+}
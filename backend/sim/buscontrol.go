@@ -0,0 +1,158 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// Bus command kinds of a live stream.
+const (
+	BusSetSpeed  = "set_speed"  // run at SpeedKmph from the next departure (0 = the bus's own speed)
+	BusHold      = "hold"       // keep the doors open Hold longer at the next stop served
+	BusShortTurn = "short_turn" // reverse at StopID (0 = the next stop) instead of the terminal
+)
+
+// BusCommand asks a run to control one of its buses.
+type BusCommand struct {
+	BusID     int
+	Kind      string
+	SpeedKmph float64       // set_speed
+	Hold      time.Duration // hold
+	StopID    int           // short_turn
+}
+
+// BusControlEvent reports a bus command accepted by the run (Status "queued"),
+// carried out ("applied") or dropped unused ("lapsed").
+type BusControlEvent struct {
+	Time      time.Time `json:"time"`
+	BusID     int       `json:"bus_id"`
+	Command   string    `json:"command"`
+	Status    string    `json:"status"`
+	StopID    int       `json:"stop_id,omitempty"`    // where a hold or short turn applies
+	SpeedKmph float64   `json:"speed_kmph,omitempty"` // set_speed: the new average speed
+	HoldSec   float64   `json:"hold_s,omitempty"`
+	SetDown   int       `json:"set_down,omitempty"` // short_turn: riders set down to wait for the next bus
+}
+
+func (BusControlEvent) isEvent() {}
+
+// BusControls keeps the pending operator commands of a run's buses. Commands take
+// effect at the bus's next stop or departure. It is not safe for concurrent use; the
+// runner calls it with its lock held.
+type BusControls struct {
+	route *model.Route
+	buses map[int]*model.Bus
+	own   map[int]float64 // bus id -> its speed before the first set_speed
+	speed map[int]float64 // pending speed changes
+	hold  map[int]time.Duration
+	turn  map[int]int // bus id -> short-turn stop (0 = the next stop)
+}
+
+// NewBusControls returns the controls of fleet on route, with nothing pending.
+func NewBusControls(route *model.Route, fleet []*model.Bus) *BusControls {
+	c := &BusControls{route: route, buses: make(map[int]*model.Bus, len(fleet)), own: make(map[int]float64), speed: make(map[int]float64), hold: make(map[int]time.Duration), turn: make(map[int]int)}
+	for _, b := range fleet {
+		c.buses[b.ID] = b
+	}
+	return c
+}
+
+// Apply queues cmd at now. A short turn must be at a stop still ahead of the bus on
+// its trip and not a terminal; a later command of the same kind replaces a pending one.
+func (c *BusControls) Apply(cmd BusCommand, now time.Time) (BusControlEvent, error) {
+	ev := BusControlEvent{Time: now, BusID: cmd.BusID, Command: cmd.Kind, Status: "queued"}
+	bus, ok := c.buses[cmd.BusID]
+	if !ok {
+		return ev, fmt.Errorf("bus %d not in the run", cmd.BusID)
+	}
+	switch cmd.Kind {
+	case BusSetSpeed:
+		if cmd.SpeedKmph < 0 {
+			return ev, fmt.Errorf("speed %g km/h must be >= 0", cmd.SpeedKmph)
+		}
+		c.speed[bus.ID] = cmd.SpeedKmph
+		ev.SpeedKmph = cmd.SpeedKmph
+	case BusHold:
+		if cmd.Hold <= 0 {
+			return ev, fmt.Errorf("hold must be > 0")
+		}
+		c.hold[bus.ID] = cmd.Hold
+		ev.HoldSec = cmd.Hold.Seconds()
+	case BusShortTurn:
+		if cmd.StopID != 0 {
+			idx, last := c.route.IndexOf(cmd.StopID), len(c.route.Stops)-1
+			if idx < 0 {
+				return ev, fmt.Errorf("stop %d not on the route", cmd.StopID)
+			}
+			if idx == 0 || idx == last {
+				return ev, fmt.Errorf("stop %d is a terminal", cmd.StopID)
+			}
+			cur := c.route.IndexOf(bus.CurrentStopID)
+			if bus.Direction == "inbound" && idx >= cur || bus.Direction != "inbound" && idx <= cur {
+				return ev, fmt.Errorf("stop %d is not ahead of bus %d", cmd.StopID, bus.ID)
+			}
+		}
+		c.turn[bus.ID] = cmd.StopID
+		ev.StopID = cmd.StopID
+	default:
+		return ev, fmt.Errorf("unknown bus command %q", cmd.Kind)
+	}
+	return ev, nil
+}
+
+// Depart applies a pending speed change to bus leaving a stop at now.
+func (c *BusControls) Depart(bus *model.Bus, now time.Time) (BusControlEvent, bool) {
+	v, ok := c.speed[bus.ID]
+	if !ok {
+		return BusControlEvent{}, false
+	}
+	delete(c.speed, bus.ID)
+	own, changed := c.own[bus.ID]
+	if !changed {
+		own = bus.AverageSpeedKmph
+		c.own[bus.ID] = own
+	}
+	if v == 0 {
+		v = own
+	}
+	bus.SetSpeedKmph(v)
+	return BusControlEvent{Time: now, BusID: bus.ID, Command: BusSetSpeed, Status: "applied", StopID: bus.CurrentStopID, SpeedKmph: bus.AverageSpeedKmph}, true
+}
+
+// Hold returns the pending hold of bus serving stopID at now, and clears it.
+func (c *BusControls) Hold(bus *model.Bus, stopID int, now time.Time) (time.Duration, BusControlEvent, bool) {
+	d, ok := c.hold[bus.ID]
+	if !ok {
+		return 0, BusControlEvent{}, false
+	}
+	delete(c.hold, bus.ID)
+	return d, BusControlEvent{Time: now, BusID: bus.ID, Command: BusHold, Status: "applied", StopID: stopID, HoldSec: d.Seconds()}, true
+}
+
+// ShortTurn checks for a short turn of bus at st, reached at now, and returns its
+// event (false = none pending there). When the event is applied the bus reverses at
+// st: riders bound beyond it are set down to queue there for the next bus in their
+// direction, their wait starting again. A short turn reaching a terminal, where the
+// bus turns anyway, lapses.
+func (c *BusControls) ShortTurn(bus *model.Bus, st *model.BusStop, now time.Time) (BusControlEvent, bool) {
+	at, ok := c.turn[bus.ID]
+	if !ok || at != 0 && at != st.ID {
+		return BusControlEvent{}, false
+	}
+	delete(c.turn, bus.ID)
+	ev := BusControlEvent{Time: now, BusID: bus.ID, Command: BusShortTurn, Status: "lapsed", StopID: st.ID}
+	if idx := c.route.IndexOf(st.ID); idx == 0 || idx == len(c.route.Stops)-1 {
+		return ev, true
+	}
+	ev.Status, ev.SetDown = "applied", len(bus.Passengers)
+	for _, p := range bus.Passengers {
+		p.StartStopID, p.ArrivalStopTime, p.BusID = st.ID, now, 0
+		p.BoardingTime, p.DepartureTime, p.WaitDuration = nil, nil, nil
+		st.EnqueuePassenger(p, bus.Direction, now)
+	}
+	bus.Passengers = nil
+	bus.PassengersOnboard, bus.IsFull = 0, false
+	return ev, true
+}
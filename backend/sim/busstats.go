@@ -0,0 +1,285 @@
+package sim
+
+import (
+	"time"
+
+	"brt08/backend/model"
+)
+
+// BusStats summarizes one bus's service. Occupancy and idle time are weighted by the
+// bus's own simulated time from its first stop arrival, which splits into driving,
+// dwelling, laying over and repositioning.
+type BusStats struct {
+	BusID          int         `json:"bus_id"`
+	Direction      string      `json:"direction"`
+	DistanceKM     float64     `json:"distance_km"`
+	TripsCompleted int         `json:"trips_completed"`      // terminal-to-terminal traversals
+	TripTimes      []TripTimes `json:"trip_times,omitempty"` // one-way trip and cycle times by direction
+	Boarded        int         `json:"boarded"`
+	Alighted       int         `json:"alighted"`
+	CurrentLoad    int         `json:"current_load"`
+	MaxLoad        int         `json:"max_load"` // peak passengers onboard
+	Capacity       int         `json:"capacity"`
+	AvgLoad        float64     `json:"avg_load"`      // time-weighted passengers onboard
+	AvgOccupancy   float64     `json:"avg_occupancy"` // AvgLoad / Capacity (0..1)
+	IdleSec        float64     `json:"idle_s"`        // stationary at stops and terminals
+	InServiceSec   float64     `json:"in_service_s"`
+	DrivingSec     float64     `json:"driving_s"`    // between stops in service
+	DwellSec       float64     `json:"dwell_s"`      // at stops, doors open
+	LayoverSec     float64     `json:"layover_s"`    // turning and recovering at terminals, crew breaks
+	RepositionSec  float64     `json:"reposition_s"` // driving empty to a layover after service
+	Utilization    float64     `json:"utilization"`  // (DrivingSec + DwellSec) / InServiceSec (0..1)
+}
+
+// DurationSummary is the count, minimum, mean and maximum of a set of durations.
+type DurationSummary struct {
+	Count   int     `json:"count"`
+	MinMin  float64 `json:"min_min"`
+	MeanMin float64 `json:"mean_min"`
+	MaxMin  float64 `json:"max_min"`
+}
+
+func (d *DurationSummary) add(x time.Duration) {
+	m := x.Minutes()
+	if d.Count == 0 || m < d.MinMin {
+		d.MinMin = m
+	}
+	d.MaxMin = max(d.MaxMin, m)
+	d.Count++
+	d.MeanMin += (m - d.MeanMin) / float64(d.Count)
+}
+
+func (d *DurationSummary) merge(o DurationSummary) {
+	if o.Count == 0 {
+		return
+	}
+	if d.Count == 0 || o.MinMin < d.MinMin {
+		d.MinMin = o.MinMin
+	}
+	d.MaxMin = max(d.MaxMin, o.MaxMin)
+	d.MeanMin = (d.MeanMin*float64(d.Count) + o.MeanMin*float64(o.Count)) / float64(d.Count+o.Count)
+	d.Count += o.Count
+}
+
+// TripTimes summarizes a direction's one-way trips, from the departure at the first
+// stop to the arrival at the terminal, and its cycles, from a trip's departure to the
+// bus's next departure in the same direction: the round trip with both layovers that
+// sets how many buses a headway needs. Short-turned trips, and cycles spanning one,
+// are left out.
+type TripTimes struct {
+	Direction string          `json:"direction"`
+	Trip      DurationSummary `json:"trip"`
+	Cycle     DurationSummary `json:"cycle"`
+}
+
+// tripDirections orders the directions of TripTimes.
+var tripDirections = [2]string{"outbound", "inbound"}
+
+func tripDirection(dir string) int {
+	if dir == "inbound" {
+		return 1
+	}
+	return 0
+}
+
+// BusActivity is what a bus spends its time on.
+type BusActivity int
+
+const (
+	ActivityDriving BusActivity = iota
+	ActivityDwelling
+	ActivityLayover
+	ActivityRepositioning
+	numActivities
+)
+
+type busTrack struct {
+	started  bool
+	last     time.Time
+	load     int
+	activity BusActivity
+	loadSec  float64
+	totalSec float64
+	actSec   [numActivities]float64
+	boarded  int
+	alighted int
+	trips    int
+	maxLoad  int
+	// the current trip's departure (zero until the bus moves), and the departure of the
+	// last full trip in each direction (zero after a short turn)
+	tripStart time.Time
+	lastStart [2]time.Time
+	tripTimes [2]TripTimes
+}
+
+// BusStatsRecorder accumulates per-bus metrics. Caller must ensure synchronization.
+type BusStatsRecorder struct {
+	tracks map[int]*busTrack
+}
+
+// NewBusStatsRecorder creates an empty recorder.
+func NewBusStatsRecorder() *BusStatsRecorder {
+	return &BusStatsRecorder{tracks: make(map[int]*busTrack)}
+}
+
+func (r *BusStatsRecorder) track(busID int) *busTrack {
+	t := r.tracks[busID]
+	if t == nil {
+		t = &busTrack{}
+		r.tracks[busID] = t
+	}
+	return t
+}
+
+// Set accrues time since the previous update and records the bus's new load and motion
+// state: moving between stops or dwelling at one.
+func (r *BusStatsRecorder) Set(busID int, at time.Time, load int, moving bool) {
+	act := ActivityDwelling
+	if moving {
+		act = ActivityDriving
+	}
+	r.set(busID, at, load, act)
+}
+
+// Layover accrues time since the previous update and marks the bus as laying over at
+// a terminal (turning, recovering or on a crew break) until its next update.
+func (r *BusStatsRecorder) Layover(busID int, at time.Time) {
+	r.set(busID, at, r.track(busID).load, ActivityLayover)
+}
+
+// Reposition accrues time since the previous update and marks the bus as driving out
+// of service to a layover.
+func (r *BusStatsRecorder) Reposition(busID int, at time.Time) {
+	r.set(busID, at, r.track(busID).load, ActivityRepositioning)
+}
+
+func (r *BusStatsRecorder) set(busID int, at time.Time, load int, act BusActivity) {
+	t := r.track(busID)
+	if t.started && at.After(t.last) {
+		dt := at.Sub(t.last).Seconds()
+		t.totalSec += dt
+		t.loadSec += float64(t.load) * dt
+		t.actSec[t.activity] += dt
+	}
+	if !t.started || at.After(t.last) {
+		t.last = at
+	}
+	t.started = true
+	t.load = load
+	if load > t.maxLoad {
+		t.maxLoad = load
+	}
+	t.activity = act
+	if act == ActivityDriving && t.tripStart.IsZero() {
+		t.tripStart = at
+	}
+}
+
+// Alight records alighted passengers at a stop (bus stationary).
+func (r *BusStatsRecorder) Alight(busID, n int, at time.Time, load int) {
+	r.Set(busID, at, load, false)
+	r.track(busID).alighted += n
+}
+
+// Board records boarded passengers at a stop (bus stationary).
+func (r *BusStatsRecorder) Board(busID, n int, at time.Time, load int) {
+	r.Set(busID, at, load, false)
+	r.track(busID).boarded += n
+}
+
+// Trip counts a traversal in direction dir completed at the terminal at time at; its
+// time and the cycle it closes are measured unless it was short-turned (full false).
+func (r *BusStatsRecorder) Trip(busID int, dir string, at time.Time, full bool) {
+	t := r.track(busID)
+	t.trips++
+	start := t.tripStart
+	t.tripStart = time.Time{}
+	if !full || start.IsZero() {
+		t.lastStart = [2]time.Time{}
+		return
+	}
+	d := tripDirection(dir)
+	tt := &t.tripTimes[d]
+	tt.Trip.add(at.Sub(start))
+	if last := t.lastStart[d]; !last.IsZero() {
+		tt.Cycle.add(start.Sub(last))
+	}
+	t.lastStart[d] = start
+}
+
+// ServiceHours returns the hours in service of each bus in stats, keyed by bus id:
+// the time basis of the hourly and daily fixed operating costs.
+func ServiceHours(stats []BusStats) map[int]float64 {
+	out := make(map[int]float64, len(stats))
+	for _, s := range stats {
+		out[s.BusID] = s.InServiceSec / 3600
+	}
+	return out
+}
+
+// Snapshot returns metrics for buses in fleet order.
+func (r *BusStatsRecorder) Snapshot(buses []*model.Bus, distance map[int]float64) []BusStats {
+	out := make([]BusStats, 0, len(buses))
+	for _, b := range buses {
+		t := r.track(b.ID)
+		s := BusStats{BusID: b.ID, Direction: b.Direction, DistanceKM: distance[b.ID], TripsCompleted: t.trips, Boarded: t.boarded, Alighted: t.alighted, CurrentLoad: b.PassengersOnboard, MaxLoad: t.maxLoad, InServiceSec: t.totalSec,
+			DrivingSec: t.actSec[ActivityDriving], DwellSec: t.actSec[ActivityDwelling], LayoverSec: t.actSec[ActivityLayover], RepositionSec: t.actSec[ActivityRepositioning]}
+		s.IdleSec = s.DwellSec + s.LayoverSec
+		for d, tt := range t.tripTimes {
+			if tt.Trip.Count > 0 {
+				tt.Direction = tripDirections[d]
+				s.TripTimes = append(s.TripTimes, tt)
+			}
+		}
+		if b.Type != nil {
+			s.Capacity = b.Type.Capacity
+		}
+		if t.totalSec > 0 {
+			s.AvgLoad = t.loadSec / t.totalSec
+			s.Utilization = (s.DrivingSec + s.DwellSec) / t.totalSec
+			if s.Capacity > 0 {
+				s.AvgOccupancy = s.AvgLoad / float64(s.Capacity)
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// FleetTime sums the time budgets of stats into one fleet-wide BusStats (BusID
+// 0) with its utilization; only the time and utilization fields are set.
+func FleetTime(stats []BusStats) BusStats {
+	var f BusStats
+	for _, s := range stats {
+		f.InServiceSec += s.InServiceSec
+		f.DrivingSec += s.DrivingSec
+		f.DwellSec += s.DwellSec
+		f.LayoverSec += s.LayoverSec
+		f.RepositionSec += s.RepositionSec
+	}
+	f.IdleSec = f.DwellSec + f.LayoverSec
+	if f.InServiceSec > 0 {
+		f.Utilization = (f.DrivingSec + f.DwellSec) / f.InServiceSec
+	}
+	return f
+}
+
+// FleetTripTimes merges the trip and cycle times of stats by direction.
+func FleetTripTimes(stats []BusStats) []TripTimes {
+	var all [2]TripTimes
+	for _, s := range stats {
+		for _, tt := range s.TripTimes {
+			d := tripDirection(tt.Direction)
+			all[d].Trip.merge(tt.Trip)
+			all[d].Cycle.merge(tt.Cycle)
+		}
+	}
+	var out []TripTimes
+	for d, tt := range all {
+		if tt.Trip.Count > 0 {
+			tt.Direction = tripDirections[d]
+			out = append(out, tt)
+		}
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"brt08/backend/model"
+)
+
+func TestBusStatsSplitsTime(t *testing.T) {
+	r := NewBusStatsRecorder()
+	at := func(sec int) time.Time { return testStart.Add(time.Duration(sec) * time.Second) }
+	r.Set(1, at(0), 0, false)    // doors open at the first stop
+	r.Set(1, at(30), 10, true)   // 30 s dwelling
+	r.Set(1, at(300), 10, false) // 270 s driving
+	r.Layover(1, at(360))        // 60 s dwelling
+	r.Reposition(1, at(960))     // 600 s laying over
+	r.Layover(1, at(1200))       // 240 s repositioning
+
+	bus := &model.Bus{ID: 1, Type: &model.BusType{Capacity: 70}}
+	s := r.Snapshot([]*model.Bus{bus}, nil)[0]
+	for name, got := range map[string][2]float64{
+		"driving":    {s.DrivingSec, 270},
+		"dwell":      {s.DwellSec, 90},
+		"layover":    {s.LayoverSec, 600},
+		"reposition": {s.RepositionSec, 240},
+		"in service": {s.InServiceSec, 1200},
+		"idle":       {s.IdleSec, 690},
+	} {
+		if math.Abs(got[0]-got[1]) > 1e-9 {
+			t.Errorf("%s = %v s, want %v s", name, got[0], got[1])
+		}
+	}
+	if want := 360.0 / 1200; math.Abs(s.Utilization-want) > 1e-9 {
+		t.Errorf("utilization = %v, want %v", s.Utilization, want)
+	}
+
+	fleet := FleetTime([]BusStats{s, {InServiceSec: 800, DrivingSec: 400, LayoverSec: 400}})
+	if fleet.InServiceSec != 2000 || fleet.DrivingSec != 670 || fleet.LayoverSec != 1000 {
+		t.Errorf("fleet time = %+v", fleet)
+	}
+	if want := 760.0 / 2000; math.Abs(fleet.Utilization-want) > 1e-9 {
+		t.Errorf("fleet utilization = %v, want %v", fleet.Utilization, want)
+	}
+}
+
+func TestBusStatsTripAndCycleTimes(t *testing.T) {
+	r := NewBusStatsRecorder()
+	at := func(min int) time.Time { return testStart.Add(time.Duration(min) * time.Minute) }
+	// outbound 0-40, inbound 50-85, outbound 95-140, then a short-turned inbound trip
+	r.Set(1, at(0), 0, true)
+	r.Trip(1, "outbound", at(40), true)
+	r.Set(1, at(50), 0, true)
+	r.Trip(1, "inbound", at(85), true)
+	r.Set(1, at(95), 0, true)
+	r.Trip(1, "outbound", at(140), true)
+	r.Set(1, at(150), 0, true)
+	r.Trip(1, "inbound", at(160), false)
+
+	s := r.Snapshot([]*model.Bus{{ID: 1}}, nil)[0]
+	if s.TripsCompleted != 4 {
+		t.Errorf("trips completed = %d, want 4", s.TripsCompleted)
+	}
+	if len(s.TripTimes) != 2 {
+		t.Fatalf("trip times = %+v, want outbound and inbound", s.TripTimes)
+	}
+	out, in := s.TripTimes[0], s.TripTimes[1]
+	if out.Direction != "outbound" || out.Trip.Count != 2 || out.Trip.MinMin != 40 || out.Trip.MaxMin != 45 || out.Trip.MeanMin != 42.5 {
+		t.Errorf("outbound trips = %+v", out)
+	}
+	// one outbound cycle (0 to 95); the short turn is left out of both
+	if out.Cycle.Count != 1 || out.Cycle.MeanMin != 95 {
+		t.Errorf("outbound cycles = %+v", out.Cycle)
+	}
+	if in.Direction != "inbound" || in.Trip.Count != 1 || in.Trip.MeanMin != 35 || in.Cycle.Count != 0 {
+		t.Errorf("inbound = %+v", in)
+	}
+
+	fleet := FleetTripTimes([]BusStats{s, s})
+	if len(fleet) != 2 || fleet[0].Trip.Count != 4 || fleet[0].Trip.MeanMin != 42.5 || fleet[1].Trip.Count != 2 {
+		t.Errorf("fleet trip times = %+v", fleet)
+	}
+}
@@ -0,0 +1,97 @@
+package sim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StartTime is the simulated date and time a run starts at. The zero value starts
+// today (local time) at the clock time the run's demand period, or full day, begins,
+// so event timestamps line up with the period boundaries.
+type StartTime struct {
+	Wall     bool      // start at the wall clock ("now"), the behaviour before calendar time
+	At       time.Time // a fixed instant (zero = not set)
+	Date     time.Time // a fixed date at midnight (zero = the current date)
+	ClockMin int       // minutes after midnight when HasClock
+	HasClock bool      // false = the period's start
+}
+
+// ParseStartTime parses a -start spec: "" (today at the period's start), "now",
+// "HH:MM" (today), "YYYY-MM-DD" (at the period's start), "YYYY-MM-DDTHH:MM[:SS]"
+// or an RFC 3339 instant. Times without a zone are local.
+func ParseStartTime(spec string) (StartTime, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return StartTime{}, nil
+	case spec == "now":
+		return StartTime{Wall: true}, nil
+	case !strings.Contains(spec, "-"):
+		min, err := parseClock(spec)
+		if err != nil || min == 24*60 {
+			return StartTime{}, fmt.Errorf("start: bad clock time %q (want HH:MM)", spec)
+		}
+		return StartTime{ClockMin: min, HasClock: true}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, spec); err == nil {
+		return StartTime{At: t}, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, spec, time.Local); err == nil {
+			return StartTime{At: t}, nil
+		}
+	}
+	if d, err := time.ParseInLocation(time.DateOnly, spec, time.Local); err == nil {
+		return StartTime{Date: d}, nil
+	}
+	return StartTime{}, fmt.Errorf("start: %q is not now, HH:MM, YYYY-MM-DD or YYYY-MM-DDTHH:MM", spec)
+}
+
+// DateOnly reports whether s fixes at most the date, leaving the clock time to the
+// run (as full-day runs require).
+func (s StartTime) DateOnly() bool { return !s.Wall && s.At.IsZero() && !s.HasClock }
+
+// Resolve returns the start of a run of periodID (or of day, when enabled) given the
+// wall clock now.
+func (s StartTime) Resolve(periodID int, day DaySchedule, now time.Time) time.Time {
+	switch {
+	case s.Wall:
+		return now
+	case !s.At.IsZero():
+		return s.At
+	}
+	date := s.Date
+	if date.IsZero() {
+		y, m, d := now.Date()
+		date = time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	}
+	min := s.ClockMin
+	if !s.HasClock {
+		min = periodStartMin(periodID)
+		if day.Enabled() {
+			min = day.StartMin()
+		}
+	}
+	return date.Add(time.Duration(min) * time.Minute)
+}
+
+// String renders s as a -start spec.
+func (s StartTime) String() string {
+	switch {
+	case s.Wall:
+		return "now"
+	case !s.At.IsZero():
+		return s.At.Format(time.RFC3339)
+	case s.HasClock:
+		return clockString(s.ClockMin)
+	case !s.Date.IsZero():
+		return s.Date.Format(time.DateOnly)
+	}
+	return ""
+}
+
+// clockMinutes returns the minutes after midnight of t.
+func clockMinutes(t time.Time) float64 {
+	return float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60
+}
@@ -0,0 +1,116 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// Per-visit timings used for the cycle estimate; they mirror the runner's pre-board
+// pause plus minimum dwell at stops and the terminal turnaround pause.
+const (
+	capacityStopTime     = 650*time.Millisecond + 1200*time.Millisecond
+	capacityTerminalTime = 3 * time.Second
+)
+
+// CapacityCheck compares the corridor's theoretical carrying capacity (buses/hour x
+// capacity, per direction) with the expected load on the busiest segment under the
+// configured demand.
+type CapacityCheck struct {
+	Buses           int     `json:"buses"`
+	CycleMin        float64 `json:"cycle_min"`         // mean round-trip time per bus
+	BusesPerHour    float64 `json:"buses_per_hour"`    // departures per direction per hour, whole fleet
+	CapacityPerHour float64 `json:"capacity_per_hour"` // passenger places per direction per hour
+	DemandPerHour   float64 `json:"demand_per_hour"`   // expected arrivals per hour, both directions
+	PeakDirection   string  `json:"peak_direction"`    // direction of the busiest segment
+	PeakFromStopID  int     `json:"peak_from_stop_id"` // busiest segment (route order)
+	PeakToStopID    int     `json:"peak_to_stop_id"`
+	PeakLoadPerHour float64 `json:"peak_load_per_hour"` // expected riders per hour crossing it
+	Utilization     float64 `json:"utilization"`        // PeakLoadPerHour / CapacityPerHour
+	Exceeded        bool    `json:"exceeded"`
+}
+
+// CheckCapacity estimates capacity from each bus's round trip at its average speed and
+// the expected peak segment load from arrivalsPerMin (all stops, both directions) split
+// by cfg's direction bias and spatial weights, with destinations uniform downstream as
+// in GenerateBatch. Traffic, bunching and crowding delays are ignored, so capacity is
+// an upper bound: demand above it guarantees unbounded queues.
+func CheckCapacity(route *model.Route, buses []*model.Bus, arrivalsPerMin float64, cfg DemandConfig) CapacityCheck {
+	c := CapacityCheck{Buses: len(buses), DemandPerHour: arrivalsPerMin * 60}
+	n := len(route.Stops)
+	if n < 2 {
+		return c
+	}
+	// a cycle runs the route out and back, whose lengths differ on one-way sections
+	cycleKM := route.DirectionKM("outbound") + route.DirectionKM("inbound")
+	dwell := time.Duration(2*n)*capacityStopTime + 2*capacityTerminalTime
+	cycleSum := 0.0
+	for _, b := range buses {
+		speed := b.AverageSpeedKmph
+		if speed <= 0 {
+			speed = 25
+		}
+		cycle := cycleKM/speed*60 + dwell.Minutes()
+		cycleSum += cycle
+		c.BusesPerHour += 60 / cycle
+		if b.Type != nil {
+			c.CapacityPerHour += float64(b.Type.Capacity) * 60 / cycle
+		}
+	}
+	if len(buses) > 0 {
+		c.CycleMin = cycleSum / float64(len(buses))
+	}
+
+	pOutbound := 0.5
+	if cfg.FavoredOutbound {
+		pOutbound = cfg.DirBias / (cfg.DirBias + 1.0)
+	} else if cfg.FavoredInbound {
+		pOutbound = 1.0 / (cfg.DirBias + 1.0)
+	}
+	// expected share of a direction's riders on segment k (stop k -> k+1)
+	out := make([]float64, n-1)
+	in := make([]float64, n-1)
+	wOut, wIn := make([]float64, n), make([]float64, n)
+	sumOut, sumIn := 0.0, 0.0
+	for i := 0; i < n-1; i++ {
+		wOut[i] = gradientWeightOutbound(i, n, cfg.SpatialGradient, cfg.BaselineDemand, cfg.DirBias, cfg.FavoredOutbound)
+		sumOut += wOut[i]
+	}
+	for i := 1; i < n; i++ {
+		wIn[i] = gradientWeightInbound(i, n, cfg.SpatialGradient, cfg.BaselineDemand, cfg.DirBias, cfg.FavoredInbound)
+		sumIn += wIn[i]
+	}
+	for k := 0; k < n-1; k++ {
+		for i := 0; i <= k && sumOut > 0; i++ {
+			out[k] += wOut[i] / sumOut * float64(n-1-k) / float64(n-1-i)
+		}
+		for i := k + 1; i < n && sumIn > 0; i++ {
+			in[k] += wIn[i] / sumIn * float64(k+1) / float64(i)
+		}
+	}
+	for k := 0; k < n-1; k++ {
+		if l := c.DemandPerHour * pOutbound * out[k]; l > c.PeakLoadPerHour {
+			c.PeakLoadPerHour, c.PeakDirection = l, "outbound"
+			c.PeakFromStopID, c.PeakToStopID = route.Stops[k].ID, route.Stops[k+1].ID
+		}
+		if l := c.DemandPerHour * (1 - pOutbound) * in[k]; l > c.PeakLoadPerHour {
+			c.PeakLoadPerHour, c.PeakDirection = l, "inbound"
+			c.PeakFromStopID, c.PeakToStopID = route.Stops[k+1].ID, route.Stops[k].ID
+		}
+	}
+	if c.CapacityPerHour > 0 {
+		c.Utilization = c.PeakLoadPerHour / c.CapacityPerHour
+	}
+	c.Exceeded = c.PeakLoadPerHour > c.CapacityPerHour
+	return c
+}
+
+// Note describes an exceeded check in one line for logs and reports ("" when within capacity).
+func (c CapacityCheck) Note() string {
+	if !c.Exceeded {
+		return ""
+	}
+	return fmt.Sprintf("configured demand exceeds corridor capacity: %.0f riders/h expected on the busiest %s segment (stop %d -> %d) vs %.0f places/h from %d buses (%.1f departures/h per direction, %.1f min cycle); utilization %.0f%%, queues will grow without bound",
+		c.PeakLoadPerHour, c.PeakDirection, c.PeakFromStopID, c.PeakToStopID, c.CapacityPerHour, c.Buses, c.BusesPerHour, c.CycleMin, c.Utilization*100)
+}
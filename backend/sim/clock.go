@@ -0,0 +1,127 @@
+package sim
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAcceleration is the simulated seconds per wall second of a PacedClock at
+// speed 1.
+const DefaultAcceleration = 5.0
+
+// pacingChunk bounds the simulated time a PacedClock sleeps between checks, so speed
+// changes and cut-offs take effect promptly.
+const pacingChunk = 500 * time.Millisecond
+
+// Clock is the time source of a run: the simulated time shared by the engine and the
+// pace at which it maps onto wall time. The streaming runner sleeps on a PacedClock
+// between steps and the batch driver jumps a HeadlessClock from event to event, so
+// both move simulated time through the same interface.
+type Clock interface {
+	Now() time.Time                                  // current simulated time
+	Set(t time.Time)                                 // jump to t
+	Advance(d time.Duration) time.Time               // move simulated time forward by d and return it
+	Sleep(ctx context.Context, d time.Duration) bool // wait the wall time d of simulated time takes; false once cut or ctx is done
+	Cut()                                            // end every pending and later Sleep (the run reached its end)
+	Wall() time.Time                                 // current wall time
+	Acceleration() float64                           // simulated seconds per wall second (0 = unpaced)
+}
+
+// simTime is the simulated time shared by the clocks.
+type simTime struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *simTime) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *simTime) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+func (c *simTime) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+func (c *simTime) Wall() time.Time { return time.Now() }
+
+// PacedClock runs simulated time DefaultAcceleration times faster than wall time,
+// scaled by a live speed multiplier (the stream's time scale).
+type PacedClock struct {
+	simTime
+	speed func() float64
+	cut   chan struct{}
+	once  sync.Once
+}
+
+// NewPacedClock returns a clock at start paced by speed (nil = 1).
+func NewPacedClock(start time.Time, speed func() float64) *PacedClock {
+	return &PacedClock{simTime: simTime{now: start}, speed: speed, cut: make(chan struct{})}
+}
+
+// Acceleration returns the simulated seconds per wall second at the current speed.
+func (c *PacedClock) Acceleration() float64 {
+	s := 1.0
+	if c.speed != nil {
+		if v := c.speed(); v > 0 {
+			s = v
+		}
+	}
+	return DefaultAcceleration * s
+}
+
+func (c *PacedClock) Sleep(ctx context.Context, d time.Duration) bool {
+	for d > 0 {
+		chunk := d
+		if chunk > pacingChunk {
+			chunk = pacingChunk
+		}
+		select {
+		case <-c.cut:
+			return false
+		default:
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-c.cut:
+			return false
+		case <-time.After(time.Duration(float64(chunk) / c.Acceleration())):
+		}
+		d -= chunk
+	}
+	return true
+}
+
+func (c *PacedClock) Cut() { c.once.Do(func() { close(c.cut) }) }
+
+// HeadlessClock never waits: simulated time moves only by Set and Advance, as fast as
+// the run computes.
+type HeadlessClock struct {
+	simTime
+	cut atomic.Bool
+}
+
+// NewHeadlessClock returns an unpaced clock at start.
+func NewHeadlessClock(start time.Time) *HeadlessClock {
+	return &HeadlessClock{simTime: simTime{now: start}}
+}
+
+func (c *HeadlessClock) Sleep(ctx context.Context, d time.Duration) bool {
+	return !c.cut.Load() && ctx.Err() == nil
+}
+
+func (c *HeadlessClock) Cut() { c.cut.Store(true) }
+
+func (c *HeadlessClock) Acceleration() float64 { return 0 }
@@ -0,0 +1,258 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// What happens to the passengers waiting at a closed stop.
+const (
+	ClosureRedistribute = "redistribute" // walk to the nearest open stop on their way (default)
+	ClosureUnserved     = "unserved"     // give up and leave the system
+)
+
+// ParseClosurePolicy validates a closure policy; empty selects ClosureRedistribute.
+func ParseClosurePolicy(s string) (string, error) {
+	switch s {
+	case "", ClosureRedistribute:
+		return ClosureRedistribute, nil
+	case ClosureUnserved:
+		return ClosureUnserved, nil
+	}
+	return "", fmt.Errorf("unknown closure policy %q (want redistribute or unserved)", s)
+}
+
+// StopClosure asks a run to close a stop, or to reopen it, from now on.
+type StopClosure struct {
+	StopID int
+	Reopen bool
+	Policy string // for a closure: ClosureRedistribute or ClosureUnserved
+}
+
+// ClosureEvent reports a stop closed or reopened, with the passengers it displaced.
+type ClosureEvent struct {
+	Time          time.Time `json:"time"`
+	StopID        int       `json:"stop_id"`
+	Closed        bool      `json:"closed"`
+	Policy        string    `json:"policy,omitempty"`
+	Redistributed int       `json:"redistributed"` // waiting passengers moved to another stop
+	Unserved      int       `json:"unserved"`      // waiting passengers who left
+	Touched       []int     `json:"-"`             // stops whose queues changed
+}
+
+func (ClosureEvent) isEvent() {}
+
+// SkipEvent reports a bus passing a closed stop without opening its doors.
+type SkipEvent struct {
+	Time        time.Time `json:"time"`
+	BusID       int       `json:"bus_id"`
+	Direction   string    `json:"direction"`
+	StopID      int       `json:"stop_id"`
+	Onboard     int       `json:"onboard"`
+	CarriedPast int       `json:"carried_past"` // riders bound for the stop, set down at the next one
+}
+
+func (SkipEvent) isEvent() {}
+
+// ClosureRecord logs one closure of a stop and its passenger impact.
+type ClosureRecord struct {
+	StopID        int        `json:"stop_id"`
+	Name          string     `json:"stop_name"`
+	Policy        string     `json:"policy"`
+	ClosedAt      time.Time  `json:"closed_at"`
+	ReopenedAt    *time.Time `json:"reopened_at,omitempty"` // nil = closed until the end of the run
+	DurationMin   float64    `json:"duration_min"`
+	Redistributed int        `json:"redistributed"`
+	Unserved      int        `json:"unserved"`
+	Skips         int        `json:"skips"`        // bus visits that passed the stop
+	CarriedPast   int        `json:"carried_past"` // riders set down at the following stop instead
+}
+
+// StopClosures tracks the closed stops of a run. It is not safe for concurrent use;
+// the runner calls it with its lock held.
+type StopClosures struct {
+	route   *model.Route
+	active  map[int]*ClosureRecord // closed stop id -> its record
+	records []*ClosureRecord
+	lost    int
+}
+
+// NewStopClosures returns a tracker with every stop of route open.
+func NewStopClosures(route *model.Route) *StopClosures {
+	return &StopClosures{route: route, active: make(map[int]*ClosureRecord)}
+}
+
+// Closed reports whether the stop is closed.
+func (c *StopClosures) Closed(stopID int) bool {
+	_, ok := c.active[stopID]
+	return ok
+}
+
+// Unserved returns the passengers who left the system because of closures.
+func (c *StopClosures) Unserved() int { return c.lost }
+
+// Apply closes or reopens a stop at now. Terminals cannot be closed, as every trip
+// starts and ends there; closing a closed stop or reopening an open one is an error.
+func (c *StopClosures) Apply(req StopClosure, now time.Time) (ClosureEvent, error) {
+	ev := ClosureEvent{Time: now, StopID: req.StopID, Closed: !req.Reopen}
+	idx := c.route.IndexOf(req.StopID)
+	if idx < 0 {
+		return ev, fmt.Errorf("stop %d not on the route", req.StopID)
+	}
+	if req.Reopen {
+		rec, ok := c.active[req.StopID]
+		if !ok {
+			return ev, fmt.Errorf("stop %d is not closed", req.StopID)
+		}
+		t := now
+		rec.ReopenedAt = &t
+		rec.DurationMin = now.Sub(rec.ClosedAt).Minutes()
+		delete(c.active, req.StopID)
+		return ev, nil
+	}
+	if idx == 0 || idx == len(c.route.Stops)-1 {
+		return ev, fmt.Errorf("stop %d is a terminal and cannot be closed", req.StopID)
+	}
+	if c.Closed(req.StopID) {
+		return ev, fmt.Errorf("stop %d is already closed", req.StopID)
+	}
+	policy, err := ParseClosurePolicy(req.Policy)
+	if err != nil {
+		return ev, err
+	}
+	rec := &ClosureRecord{StopID: req.StopID, Name: c.route.Stops[idx].Name, Policy: policy, ClosedAt: now}
+	c.active[req.StopID] = rec
+	c.records = append(c.records, rec)
+	ev.Policy = policy
+	ev.Redistributed, ev.Unserved, ev.Touched = c.Clear(req.StopID, now)
+	return ev, nil
+}
+
+// Clear applies the closure policy to the passengers waiting at a closed stop (on
+// closing, and for arrivals generated there later). A redistributed passenger walks
+// to the nearest open stop before their destination in either direction along the
+// corridor and keeps their arrival time; one with no such stop leaves unserved. It
+// returns the counts and the stops whose queues changed.
+func (c *StopClosures) Clear(stopID int, now time.Time) (moved, lost int, touched []int) {
+	rec, ok := c.active[stopID]
+	if !ok {
+		return 0, 0, nil
+	}
+	st := c.route.GetStop(stopID)
+	idx := c.route.IndexOf(stopID)
+	seen := map[int]bool{stopID: true}
+	touched = []int{stopID}
+	for _, queue := range []*[]*model.Passenger{&st.OutboundQueue, &st.InboundQueue} {
+		for _, p := range *queue {
+			var to *model.BusStop
+			if rec.Policy == ClosureRedistribute {
+				to = c.walkTarget(idx, p)
+			}
+			if to == nil {
+				p.Unserved = "stop_closed"
+				lost++
+				continue
+			}
+			p.StartStopID = to.ID
+			if p.Direction == "inbound" {
+				to.InboundQueue = append(to.InboundQueue, p)
+			} else {
+				to.OutboundQueue = append(to.OutboundQueue, p)
+			}
+			moved++
+			if !seen[to.ID] {
+				seen[to.ID] = true
+				touched = append(touched, to.ID)
+			}
+		}
+		*queue = nil
+	}
+	rec.Redistributed += moved
+	rec.Unserved += lost
+	c.lost += lost
+	return moved, lost, touched
+}
+
+// walkTarget returns the open stop nearest to route index idx that p can still board
+// at: upstream of idx, or downstream but before p's destination.
+func (c *StopClosures) walkTarget(idx int, p *model.Passenger) *model.BusStop {
+	stops := c.route.Stops
+	step := 1 // direction of travel in route order
+	if p.Direction == "inbound" {
+		step = -1
+	}
+	dest := c.route.IndexOf(p.EndStopID)
+	var best *model.BusStop
+	bestKm := math.Inf(1)
+	for _, dir := range []int{-step, step} {
+		km := 0.0
+		for j := idx + dir; j >= 0 && j < len(stops); j += dir {
+			if dir > 0 {
+				km += stops[j-1].DistanceToNext
+			} else {
+				km += stops[j].DistanceToNext
+			}
+			if dir == step && (j-dest)*step >= 0 {
+				break // at or past the destination
+			}
+			if c.Closed(stops[j].ID) {
+				continue
+			}
+			if km < bestKm {
+				best, bestKm = stops[j], km
+			}
+			break
+		}
+	}
+	return best
+}
+
+// Skip records bus passing the closed stop at now. Riders bound for it are set down
+// at the next stop of the trip instead.
+func (c *StopClosures) Skip(bus *model.Bus, stopID int, now time.Time) SkipEvent {
+	next := c.route.NextStopID(stopID)
+	if bus.Direction == "inbound" {
+		next = c.route.PreviousStopID(stopID)
+	}
+	carried := 0
+	for _, p := range bus.Passengers {
+		if p.EndStopID == stopID && next != 0 {
+			p.EndStopID = next
+			carried++
+		}
+	}
+	if rec, ok := c.active[stopID]; ok {
+		rec.Skips++
+		rec.CarriedPast += carried
+	}
+	return SkipEvent{Time: now, BusID: bus.ID, Direction: bus.Direction, StopID: stopID, Onboard: bus.PassengersOnboard, CarriedPast: carried}
+}
+
+// ClosedStops lists the closed stops in route order.
+func (c *StopClosures) ClosedStops() []int {
+	var out []int
+	for _, st := range c.route.Stops {
+		if c.Closed(st.ID) {
+			out = append(out, st.ID)
+		}
+	}
+	return out
+}
+
+// Records returns the closure log, closures still in force measured up to end.
+func (c *StopClosures) Records(end time.Time) []ClosureRecord {
+	if len(c.records) == 0 {
+		return nil
+	}
+	out := make([]ClosureRecord, len(c.records))
+	for i, r := range c.records {
+		out[i] = *r
+		if r.ReopenedAt == nil {
+			out[i].DurationMin = end.Sub(r.ClosedAt).Minutes()
+		}
+	}
+	return out
+}
@@ -0,0 +1,68 @@
+package sim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StopCriterion ends a run after a simulated time horizon or a number of completed
+// one-way trips (terminal to terminal, summed over the fleet), alongside or instead of
+// the passenger cap; whichever condition is met first ends the run. A run ended by the
+// criterion skips layover repositioning, and passengers still waiting or aboard stay in
+// the report as unserved.
+type StopCriterion struct {
+	Duration time.Duration // simulated time from the start (0 = no horizon)
+	Trips    int           // completed trips across the fleet (0 = no limit)
+}
+
+// Active reports whether any criterion is set.
+func (c StopCriterion) Active() bool { return c.Duration > 0 || c.Trips > 0 }
+
+// Reached returns which criterion ("duration" or "trips") is met after elapsed simulated
+// time with trips completed, or "" if none.
+func (c StopCriterion) Reached(elapsed time.Duration, trips int) string {
+	if c.Duration > 0 && elapsed >= c.Duration {
+		return "duration"
+	}
+	if c.Trips > 0 && trips >= c.Trips {
+		return "trips"
+	}
+	return ""
+}
+
+// DescribeCriterion renders a run's end conditions for reports, such as
+// "passenger_cap=500; duration=2h0m0s" ("unbounded" when nothing ends the run).
+func DescribeCriterion(passengerCap int, c StopCriterion) string {
+	var parts []string
+	if passengerCap > 0 {
+		parts = append(parts, fmt.Sprintf("passenger_cap=%d", passengerCap))
+	}
+	if c.Duration > 0 {
+		parts = append(parts, "duration="+c.Duration.String())
+	}
+	if c.Trips > 0 {
+		parts = append(parts, fmt.Sprintf("trips=%d", c.Trips))
+	}
+	if len(parts) == 0 {
+		return "unbounded"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// EndReason names what ended a run, for DoneEvent.EndedBy and reports: "aborted",
+// "stalled", the criterion met ("duration", "trips"), "passenger_cap" or "" (open-ended
+// run stopped externally without a cap).
+func EndReason(aborted string, stalled bool, criterion string, passengerCap int) string {
+	switch {
+	case aborted != "":
+		return "aborted"
+	case stalled:
+		return "stalled"
+	case criterion != "":
+		return criterion
+	case passengerCap > 0:
+		return "passenger_cap"
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package sim
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// csvTable accumulates rows of a sectioned CSV whose header is the union of all columns used.
+// Rows only set the columns they need; the rest stay empty so every record has the same width
+// and columns keep their first-use position (existing consumers reading by index are unaffected).
+type csvTable struct {
+	cols []string
+	idx  map[string]int
+	rows []map[int]string
+}
+
+func newCSVTable(cols ...string) *csvTable {
+	t := &csvTable{idx: make(map[string]int)}
+	for _, c := range cols {
+		t.col(c)
+	}
+	return t
+}
+
+func (t *csvTable) col(name string) int {
+	if i, ok := t.idx[name]; ok {
+		return i
+	}
+	t.idx[name] = len(t.cols)
+	t.cols = append(t.cols, name)
+	return t.idx[name]
+}
+
+// add appends a row given as column/value pairs.
+func (t *csvTable) add(kv ...string) {
+	row := make(map[int]string, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		row[t.col(kv[i])] = kv[i+1]
+	}
+	t.rows = append(t.rows, row)
+}
+
+// set fills more columns of the last row, given as column/value pairs.
+func (t *csvTable) set(kv ...string) {
+	row := t.rows[len(t.rows)-1]
+	for i := 0; i+1 < len(kv); i += 2 {
+		row[t.col(kv[i])] = kv[i+1]
+	}
+}
+
+func (t *csvTable) writeTo(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.cols); err != nil {
+		return err
+	}
+	rec := make([]string, len(t.cols))
+	for _, row := range t.rows {
+		for i := range rec {
+			rec[i] = row[i]
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,325 @@
+package sim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"brt08/backend/data"
+	"brt08/backend/model"
+)
+
+// DayPeriod is one period of a full-day run, in clock minutes after midnight.
+type DayPeriod struct {
+	ID       int
+	Name     string
+	StartMin int
+	EndMin   int
+}
+
+// DaySchedule chains demand periods into one continuous run: from each period's start
+// on, arrivals use its multiplier and favored direction. The run starts at the first
+// period's clock time. The zero value is off (single-period runs).
+type DaySchedule struct {
+	Periods []DayPeriod // chronological and contiguous
+}
+
+// DefaultDaySchedule chains every period of data.TimePeriods at their usual times.
+func DefaultDaySchedule() DaySchedule {
+	var d DaySchedule
+	for _, p := range data.TimePeriods {
+		d.Periods = append(d.Periods, DayPeriod{ID: p.ID, Name: p.Name, StartMin: p.StartMin, EndMin: p.EndMin})
+	}
+	return d
+}
+
+// ParseDaySchedule parses a -day spec: "" (off), "default" (DefaultDaySchedule), or
+// comma-separated id@HH:MM transitions with an optional end@HH:MM, e.g.
+// "2@06:00,3@09:00,4@12:00,5@15:00,end@19:00". Times must increase; without an end the
+// last period runs to its usual end time.
+func ParseDaySchedule(spec string) (DaySchedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "":
+		return DaySchedule{}, nil
+	case "default":
+		return DefaultDaySchedule(), nil
+	}
+	byID := make(map[int]data.TimePeriod, len(data.TimePeriods))
+	for _, p := range data.TimePeriods {
+		byID[p.ID] = p
+	}
+	var d DaySchedule
+	end := -1
+	for _, part := range strings.Split(spec, ",") {
+		key, at, ok := strings.Cut(strings.TrimSpace(part), "@")
+		if !ok {
+			return DaySchedule{}, fmt.Errorf("day: %q is not id@HH:MM", part)
+		}
+		min, err := parseClock(at)
+		if err != nil {
+			return DaySchedule{}, fmt.Errorf("day: %q: %w", part, err)
+		}
+		if n := len(d.Periods); end >= 0 || (n > 0 && min <= d.Periods[n-1].StartMin) {
+			return DaySchedule{}, fmt.Errorf("day: %q: times must increase and end must come last", part)
+		}
+		if key == "end" {
+			end = min
+			continue
+		}
+		id, err := strconv.Atoi(key)
+		p, known := byID[id]
+		if err != nil || !known {
+			return DaySchedule{}, fmt.Errorf("day: unknown period %q (want 1..%d)", key, len(data.TimePeriods))
+		}
+		if n := len(d.Periods); n > 0 {
+			d.Periods[n-1].EndMin = min
+		}
+		d.Periods = append(d.Periods, DayPeriod{ID: id, Name: p.Name, StartMin: min, EndMin: p.EndMin})
+	}
+	n := len(d.Periods)
+	if n == 0 {
+		return DaySchedule{}, fmt.Errorf("day: no periods")
+	}
+	if end >= 0 {
+		d.Periods[n-1].EndMin = end
+	}
+	if last := d.Periods[n-1]; last.EndMin <= last.StartMin {
+		return DaySchedule{}, fmt.Errorf("day: period %d starts at %s, after its usual end; give end@HH:MM", last.ID, clockString(last.StartMin))
+	}
+	return d, nil
+}
+
+// parseClock parses HH:MM into minutes after midnight (24:00 allowed as the end of day).
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hh < 0 || mm < 0 || mm > 59 || hh*60+mm > 24*60 {
+		return 0, fmt.Errorf("bad clock time %q (want HH:MM)", s)
+	}
+	return hh*60 + mm, nil
+}
+
+func clockString(min int) string { return fmt.Sprintf("%02d:%02d", min/60, min%60) }
+
+// Enabled reports whether the schedule chains periods.
+func (d DaySchedule) Enabled() bool { return len(d.Periods) > 0 }
+
+// StartMin is the clock time the run starts at.
+func (d DaySchedule) StartMin() int { return d.Periods[0].StartMin }
+
+// Duration is the simulated length of the whole day.
+func (d DaySchedule) Duration() time.Duration {
+	if !d.Enabled() {
+		return 0
+	}
+	return time.Duration(d.Periods[len(d.Periods)-1].EndMin-d.StartMin()) * time.Minute
+}
+
+// index returns the period in force elapsed after the start (the last one past the end).
+func (d DaySchedule) index(elapsed time.Duration) int {
+	clock := float64(d.StartMin()) + elapsed.Minutes()
+	for i, p := range d.Periods {
+		if clock < float64(p.EndMin) {
+			return i
+		}
+	}
+	return len(d.Periods) - 1
+}
+
+// At returns the period in force elapsed after the start.
+func (d DaySchedule) At(elapsed time.Duration) DayPeriod { return d.Periods[d.index(elapsed)] }
+
+// Profile is the demand profile of the day: each period's multiplier over its window.
+// The second return value is the largest multiplier, the thinning bound.
+func (d DaySchedule) Profile() (DemandProfile, float64) {
+	maxMult := 0.0
+	for _, p := range d.Periods {
+		maxMult = max(maxMult, periodMultiplier(p.ID))
+	}
+	return func(elapsed time.Duration) float64 { return periodMultiplier(d.At(elapsed).ID) }, maxMult
+}
+
+func periodMultiplier(id int) float64 {
+	if m := data.TimePeriodMultiplier[id]; m != 0 {
+		return m
+	}
+	return 1
+}
+
+// favoredName names the favored direction of a period ("" for none).
+func favoredName(periodID int, morningTowardKivukoni bool) string {
+	out, in := FavoredDirections(periodID, morningTowardKivukoni)
+	switch {
+	case out:
+		return "outbound"
+	case in:
+		return "inbound"
+	}
+	return ""
+}
+
+// PeriodChangeEvent reports a full-day run entering its next period.
+type PeriodChangeEvent struct {
+	Time       time.Time `json:"time"`
+	PeriodID   int       `json:"period_id"`
+	Name       string    `json:"name"`
+	Clock      string    `json:"clock"` // HH:MM
+	Multiplier float64   `json:"multiplier"`
+	Favored    string    `json:"favored_direction,omitempty"`
+}
+
+func (PeriodChangeEvent) isEvent() {}
+
+// PeriodStats is one period's section of a full-day report: passengers generated,
+// boarding waits, deliveries and bus loads while it was in force, so peak and
+// off-peak service are not averaged together.
+type PeriodStats struct {
+	PeriodID          int             `json:"period_id"`
+	Name              string          `json:"name"`
+	Start             string          `json:"start"` // HH:MM
+	End               string          `json:"end"`
+	Multiplier        float64         `json:"multiplier"`
+	Favored           string          `json:"favored_direction,omitempty"`
+	Generated         int             `json:"generated"`
+	OutboundGenerated int             `json:"outbound_generated"`
+	InboundGenerated  int             `json:"inbound_generated"`
+	Boarded           int             `json:"boarded"`
+	Wait              WaitPercentiles `json:"wait"`
+	Served            int             `json:"served"`      // passengers delivered to their destination
+	Departures        int             `json:"departures"`  // bus departures from stops
+	AvgLoad           float64         `json:"avg_load"`    // mean passengers aboard per departure
+	LoadFactor        float64         `json:"load_factor"` // aboard / capacity over the departures (0..1)
+}
+
+// DayRecorder drives a full-day run through its periods and splits its generation
+// and boarding waits by period. A nil recorder (single-period run) ignores every
+// call. Caller must ensure synchronization.
+type DayRecorder struct {
+	day     DaySchedule
+	start   time.Time
+	morning bool
+	cur     int
+	gen     [3]int // generated, outbound, inbound totals already attributed
+	stats   []PeriodStats
+	waits   []waitSamples
+	loads   [][2]int // passengers aboard and capacity summed over each period's departures
+}
+
+// NewDayRecorder returns a recorder for day starting at start, or nil when day is off.
+func NewDayRecorder(day DaySchedule, start time.Time, morningTowardKivukoni bool) *DayRecorder {
+	if !day.Enabled() {
+		return nil
+	}
+	r := &DayRecorder{day: day, start: start, morning: morningTowardKivukoni, waits: make([]waitSamples, len(day.Periods)), loads: make([][2]int, len(day.Periods))}
+	for _, p := range day.Periods {
+		r.stats = append(r.stats, PeriodStats{PeriodID: p.ID, Name: p.Name, Start: clockString(p.StartMin), End: clockString(p.EndMin), Multiplier: periodMultiplier(p.ID), Favored: favoredName(p.ID, morningTowardKivukoni)})
+	}
+	return r
+}
+
+// Multiplier is the demand multiplier of the current period.
+func (r *DayRecorder) Multiplier() float64 { return r.stats[r.cur].Multiplier }
+
+// Advance moves the run to simulated time at: passengers e generated since the last
+// call count toward the period that was in force, and when a new period has begun
+// e.PeriodID and cfg's favored direction switch to it and its event is returned.
+func (r *DayRecorder) Advance(at time.Time, e *Simulator, cfg *DemandConfig) (PeriodChangeEvent, bool) {
+	if r == nil {
+		return PeriodChangeEvent{}, false
+	}
+	r.attribute(e)
+	i := r.day.index(at.Sub(r.start))
+	if i == r.cur {
+		return PeriodChangeEvent{}, false
+	}
+	r.cur = i
+	p := r.day.Periods[i]
+	e.PeriodID = p.ID
+	cfg.FavoredOutbound, cfg.FavoredInbound = FavoredDirections(p.ID, r.morning)
+	return PeriodChangeEvent{Time: at, PeriodID: p.ID, Name: p.Name, Clock: clockString(p.StartMin), Multiplier: r.stats[i].Multiplier, Favored: r.stats[i].Favored}, true
+}
+
+func (r *DayRecorder) attribute(e *Simulator) {
+	s := &r.stats[r.cur]
+	s.Generated += e.GeneratedPassengers - r.gen[0]
+	s.OutboundGenerated += e.OutboundGenerated - r.gen[1]
+	s.InboundGenerated += e.InboundGenerated - r.gen[2]
+	r.gen = [3]int{e.GeneratedPassengers, e.OutboundGenerated, e.InboundGenerated}
+}
+
+// Next returns when the period after the one in force at begins, or the zero time
+// when none follows (or r is nil).
+func (r *DayRecorder) Next(at time.Time) time.Time {
+	if r == nil {
+		return time.Time{}
+	}
+	i := r.day.index(at.Sub(r.start))
+	if i+1 == len(r.day.Periods) {
+		return time.Time{}
+	}
+	return r.start.Add(time.Duration(r.day.Periods[i].EndMin-r.day.StartMin()) * time.Minute)
+}
+
+// Board records a boarding wait in the current period.
+func (r *DayRecorder) Board(waitMin float64) {
+	if r == nil {
+		return
+	}
+	r.stats[r.cur].Boarded++
+	r.waits[r.cur].add(waitMin)
+}
+
+// Alight records n passengers delivered in the current period.
+func (r *DayRecorder) Alight(n int) {
+	if r == nil {
+		return
+	}
+	r.stats[r.cur].Served += n
+}
+
+// Depart records bus b leaving a stop in the current period with its current load.
+func (r *DayRecorder) Depart(b *model.Bus) {
+	if r == nil {
+		return
+	}
+	r.stats[r.cur].Departures++
+	l := &r.loads[r.cur]
+	l[0] += b.PassengersOnboard
+	if b.Type != nil {
+		l[1] += b.Type.Capacity
+	}
+}
+
+// Stats returns the per-period sections, counting e's generation up to now.
+func (r *DayRecorder) Stats(e *Simulator) []PeriodStats {
+	if r == nil {
+		return nil
+	}
+	r.attribute(e)
+	out := append([]PeriodStats(nil), r.stats...)
+	for i := range out {
+		out[i].Wait = r.waits[i].summarize()
+		if n := out[i].Departures; n > 0 {
+			out[i].AvgLoad = float64(r.loads[i][0]) / float64(n)
+		}
+		if c := r.loads[i][1]; c > 0 {
+			out[i].LoadFactor = float64(r.loads[i][0]) / float64(c)
+		}
+	}
+	return out
+}
+
+// String renders the schedule as a -day spec ("" when off).
+func (d DaySchedule) String() string {
+	if !d.Enabled() {
+		return ""
+	}
+	parts := make([]string, 0, len(d.Periods)+1)
+	for _, p := range d.Periods {
+		parts = append(parts, fmt.Sprintf("%d@%s", p.ID, clockString(p.StartMin)))
+	}
+	return strings.Join(append(parts, "end@"+clockString(d.Periods[len(d.Periods)-1].EndMin)), ",")
+}
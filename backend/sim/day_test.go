@@ -0,0 +1,45 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"brt08/backend/model"
+)
+
+func TestDayRecorderServedAndLoadPerPeriod(t *testing.T) {
+	day, err := ParseDaySchedule("2@06:00,3@09:00,end@12:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewDayRecorder(day, testStart, true)
+	e := &Simulator{}
+	var cfg DemandConfig
+	bus := func(aboard int) *model.Bus {
+		return &model.Bus{Type: &model.BusType{Capacity: 80}, PassengersOnboard: aboard}
+	}
+
+	// morning peak: two full-ish departures and 30 deliveries
+	r.Depart(bus(60))
+	r.Depart(bus(40))
+	r.Alight(30)
+	if ev, ok := r.Advance(testStart.Add(3*time.Hour), e, &cfg); !ok || ev.PeriodID != 3 {
+		t.Fatalf("advance to 09:00 = %+v, %v; want the late morning", ev, ok)
+	}
+	// late morning: one light departure and 5 deliveries
+	r.Depart(bus(8))
+	r.Alight(5)
+
+	got := r.Stats(e)
+	if len(got) != 2 {
+		t.Fatalf("%d periods, want 2", len(got))
+	}
+	peak, late := got[0], got[1]
+	if peak.Served != 30 || peak.Departures != 2 || peak.AvgLoad != 50 || math.Abs(peak.LoadFactor-100.0/160) > 1e-9 {
+		t.Errorf("morning peak = served %d, departures %d, avg load %v, load factor %v", peak.Served, peak.Departures, peak.AvgLoad, peak.LoadFactor)
+	}
+	if late.Served != 5 || late.Departures != 1 || late.AvgLoad != 8 || math.Abs(late.LoadFactor-0.1) > 1e-9 {
+		t.Errorf("late morning = served %d, departures %d, avg load %v, load factor %v", late.Served, late.Departures, late.AvgLoad, late.LoadFactor)
+	}
+}
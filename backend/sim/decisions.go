@@ -0,0 +1,143 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+)
+
+// Dispatch decision kinds recorded in the audit log.
+const (
+	DecisionDispatch   = "dispatch"   // initial launch from a terminal on the staggered schedule
+	DecisionTurnaround = "turnaround" // direction reversal after completing a trip
+	DecisionReposition = "reposition" // end-of-run layover terminal choice
+	DecisionShift      = "shift"      // hold at a terminal for a driver break or relief
+	DecisionControl    = "control"    // operator command on a live stream (hold, short turn)
+)
+
+// Decision is one audited dispatch/control decision with the inputs it was taken on.
+type Decision struct {
+	Time          time.Time `json:"time"`
+	BusID         int       `json:"bus_id"`
+	Kind          string    `json:"kind"`
+	StopID        int       `json:"stop_id"`                  // where the decision was taken
+	TargetStopID  int       `json:"target_stop_id,omitempty"` // reposition target
+	Direction     string    `json:"direction"`                // bus direction after the decision
+	HeadwayMin    *float64  `json:"headway_min,omitempty"`    // since the previous departure from this stop in this direction
+	StopQueue     int       `json:"stop_queue"`               // passengers waiting at StopID for Direction
+	QueueOutbound int       `json:"queue_outbound"`           // route-wide queues
+	QueueInbound  int       `json:"queue_inbound"`
+	Onboard       int       `json:"onboard"`
+	Reason        string    `json:"reason"`
+}
+
+// DecisionLog accumulates decisions of a run. A nil log records nothing.
+// Caller must ensure synchronization.
+type DecisionLog struct {
+	entries    []Decision
+	lastDepart map[string]time.Time // stop/direction -> last dispatch or turnaround
+}
+
+func NewDecisionLog() *DecisionLog {
+	return &DecisionLog{lastDepart: make(map[string]time.Time)}
+}
+
+// Note records a decision for bus at stopID, capturing queue and load inputs from the
+// current route state. Dispatches and turnarounds also get the observed headway.
+func (l *DecisionLog) Note(at time.Time, route *model.Route, bus *model.Bus, kind string, stopID, targetStopID int, reason string) {
+	if l == nil {
+		return
+	}
+	d := Decision{Time: at, BusID: bus.ID, Kind: kind, StopID: stopID, TargetStopID: targetStopID, Direction: bus.Direction, Onboard: bus.PassengersOnboard, Reason: reason}
+	d.QueueOutbound, d.QueueInbound = QueuedPassengers(route)
+	for _, st := range route.Stops {
+		if st.ID != stopID {
+			continue
+		}
+		if bus.Direction == "inbound" {
+			d.StopQueue = len(st.InboundQueue)
+		} else {
+			d.StopQueue = len(st.OutboundQueue)
+		}
+	}
+	if kind == DecisionDispatch || kind == DecisionTurnaround {
+		key := fmt.Sprintf("%d/%s", stopID, bus.Direction)
+		if prev, ok := l.lastDepart[key]; ok {
+			h := at.Sub(prev).Minutes()
+			d.HeadwayMin = &h
+		}
+		l.lastDepart[key] = at
+	}
+	l.entries = append(l.entries, d)
+}
+
+// RepositionReason explains an end-of-run layover choice.
+func RepositionReason(ahead, stay bool, km float64, candidates []int) string {
+	switch {
+	case stay:
+		return "already at a layover stop"
+	case ahead:
+		return fmt.Sprintf("nearest layover ahead (%.2f km, %d candidates)", km, len(candidates))
+	}
+	return fmt.Sprintf("no layover ahead; nearest overall (%.2f km, %d candidates)", km, len(candidates))
+}
+
+// Entries returns the recorded decisions in the order they were taken.
+func (l *DecisionLog) Entries() []Decision {
+	if l == nil {
+		return nil
+	}
+	return append([]Decision(nil), l.entries...)
+}
+
+// WriteDecisionLog writes the dispatch audit trail to the given path or directory,
+// as JSON Lines for .jsonl/.ndjson and CSV otherwise (see WritePassengerLog).
+func WriteDecisionLog(logPath string, decisions []Decision) (string, error) {
+	if logPath == "" {
+		return "", nil
+	}
+	ts := time.Now().Format("20060102-150405")
+	outPath := timestampedPath(logPath, "decisions", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	ext := strings.ToLower(filepath.Ext(outPath))
+	if ext == ".jsonl" || ext == ".ndjson" {
+		enc := json.NewEncoder(w)
+		for _, d := range decisions {
+			if err := enc.Encode(d); err != nil {
+				return "", err
+			}
+		}
+	} else {
+		fmt.Fprintln(w, "time,bus_id,kind,stop_id,target_stop_id,direction,headway_min,stop_queue,queue_outbound,queue_inbound,onboard,reason")
+		for _, d := range decisions {
+			target, headway := "", ""
+			if d.TargetStopID != 0 {
+				target = fmt.Sprint(d.TargetStopID)
+			}
+			if d.HeadwayMin != nil {
+				headway = fmt.Sprintf("%.3f", *d.HeadwayMin)
+			}
+			fmt.Fprintf(w, "%s,%d,%s,%d,%s,%s,%s,%d,%d,%d,%d,%q\n", d.Time.Format(time.RFC3339Nano), d.BusID, d.Kind, d.StopID, target, d.Direction, headway, d.StopQueue, d.QueueOutbound, d.QueueInbound, d.Onboard, d.Reason)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("decision log written", "path", outPath, "decisions", len(decisions))
+	return outPath, nil
+}
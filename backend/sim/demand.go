@@ -1,10 +1,15 @@
 package sim
 
 import (
+    "slices"
     "time"
     "brt08/backend/model"
 )
 
+// DefaultLambda is the base passenger arrival rate (per corridor per minute) of runs
+// that do not set one.
+const DefaultLambda = 1.2
+
 // DemandConfig encapsulates parameters that shape passenger generation.
 type DemandConfig struct {
     FavoredOutbound bool
@@ -12,6 +17,7 @@ type DemandConfig struct {
     SpatialGradient float64
     BaselineDemand  float64
     DirBias         float64
+    GroupSizes      GroupSizeDist // compound arrivals; zero value = everyone travels alone
 }
 
 // FavoredDirections computes favored directions for a given period and morning flag.
@@ -72,10 +78,12 @@ func SeedInitial(engine *Simulator, route *model.Route, start time.Time, seedTar
             destIdx := originIdx + 1 + engine.RNG.Intn(nStops-originIdx-1)
             origin := route.Stops[originIdx]
             dest := route.Stops[destIdx]
-            arrTime := start.Add(-time.Duration(engine.RNG.Float64()*2*float64(time.Minute)))
+            arrTime := start.Add(-engine.Seeding.backdate(engine.RNG))
             p := engine.NewPassengerPublic(origin.ID, dest.ID, arrTime)
+            p.Seeded = true
             p.Direction = "outbound"
             origin.EnqueuePassenger(p, "outbound", arrTime)
+            engine.noteArrival(origin)
             engine.GeneratedPassengers++; engine.OutboundGenerated++
             seeded++
         } else {
@@ -89,10 +97,12 @@ func SeedInitial(engine *Simulator, route *model.Route, start time.Time, seedTar
             destIdx := engine.RNG.Intn(originIdxGlobal)
             origin := route.Stops[originIdxGlobal]
             dest := route.Stops[destIdx]
-            arrTime := start.Add(-time.Duration(engine.RNG.Float64()*2*float64(time.Minute)))
+            arrTime := start.Add(-engine.Seeding.backdate(engine.RNG))
             p := engine.NewPassengerPublic(origin.ID, dest.ID, arrTime)
+            p.Seeded = true
             p.Direction = "inbound"
             origin.EnqueuePassenger(p, "inbound", arrTime)
+            engine.noteArrival(origin)
             engine.GeneratedPassengers++; engine.InboundGenerated++
             seeded++
         }
@@ -100,51 +110,96 @@ func SeedInitial(engine *Simulator, route *model.Route, start time.Time, seedTar
     return seeded
 }
 
-// GenerateBatch creates up to 'count' passengers according to cfg and returns set of updated stop IDs.
+// GenerateBatch creates up to 'count' arrival events according to cfg and returns set of updated stop IDs.
+// Each event is a group drawn from cfg.GroupSizes sharing origin, destination and arrival time.
 // Caller must ensure synchronization.
 func GenerateBatch(engine *Simulator, route *model.Route, count int, now time.Time, totalTarget int, cfg DemandConfig) map[int]struct{} {
     updatedStops := make(map[int]struct{})
     if count <= 0 { return updatedStops }
-    nStops := len(route.Stops)
-    pOutbound := 0.5
-    if cfg.FavoredOutbound { pOutbound = cfg.DirBias / (cfg.DirBias + 1.0) } else if cfg.FavoredInbound { pOutbound = 1.0 / (cfg.DirBias + 1.0) }
+    g := newArrivalSampler(route, cfg)
     for i := 0; i < count; i++ {
         if totalTarget > 0 && engine.GeneratedPassengers >= totalTarget { break }
-        dir := "outbound"
-        if engine.RNG.Float64() >= pOutbound { dir = "inbound" }
-        if dir == "outbound" {
-            weights := make([]float64, nStops-1)
-            sum := 0.0
-            for si := 0; si < nStops-1; si++ { w := gradientWeightOutbound(si, nStops, cfg.SpatialGradient, cfg.BaselineDemand, cfg.DirBias, cfg.FavoredOutbound); weights[si] = w; sum += w }
-            r := engine.RNG.Float64()*sum
-            cum := 0.0
-            originIdx := 0
-            for si, w := range weights { cum += w; if r <= cum { originIdx = si; break } }
-            destIdx := originIdx + 1 + engine.RNG.Intn(nStops-originIdx-1)
-            origin := route.Stops[originIdx]
-            dest := route.Stops[destIdx]
-            p := engine.NewPassengerPublic(origin.ID, dest.ID, now)
-            p.Direction = "outbound"
-            origin.EnqueuePassenger(p, "outbound", now)
-            engine.GeneratedPassengers++; engine.OutboundGenerated++
-            updatedStops[origin.ID] = struct{}{}
-        } else {
-            weights := make([]float64, nStops-1)
-            sum := 0.0
-            for si := 1; si < nStops; si++ { w := gradientWeightInbound(si, nStops, cfg.SpatialGradient, cfg.BaselineDemand, cfg.DirBias, cfg.FavoredInbound); weights[si-1] = w; sum += w }
-            r := engine.RNG.Float64()*sum
-            cum := 0.0
-            originIdxGlobal := 1
-            for k, w := range weights { cum += w; if r <= cum { originIdxGlobal = k+1; break } }
-            destIdx := engine.RNG.Intn(originIdxGlobal)
-            origin := route.Stops[originIdxGlobal]
-            dest := route.Stops[destIdx]
-            p := engine.NewPassengerPublic(origin.ID, dest.ID, now)
-            p.Direction = "inbound"
-            origin.EnqueuePassenger(p, "inbound", now)
-            engine.GeneratedPassengers++; engine.InboundGenerated++
-            updatedStops[origin.ID] = struct{}{}
-        }
+        updatedStops[g.arrive(engine, now, totalTarget)] = struct{}{}
     }
     return updatedStops
 }
+
+// GenerateInterval generates the arrivals of [from, to) in one step instead of
+// stepping through it: a Poisson count of arrival events at perMin a minute over the
+// whole interval, each at a uniform time within it (a Poisson process given its
+// count), enqueued in time order. The count is cut to what totalTarget leaves. It
+// returns the stops that got passengers and the number of arrival events.
+// Caller must ensure synchronization.
+func GenerateInterval(engine *Simulator, route *model.Route, from, to time.Time, perMin float64, totalTarget int, cfg DemandConfig) (map[int]struct{}, int) {
+    updatedStops := make(map[int]struct{})
+    span := to.Sub(from)
+    if span <= 0 { return updatedStops, 0 }
+    count := engine.poisson(perMin * span.Minutes())
+    if totalTarget > 0 { count = min(count, max(totalTarget-engine.GeneratedPassengers, 0)) }
+    if count == 0 { return updatedStops, 0 }
+    offsets := make([]time.Duration, count)
+    for i := range offsets { offsets[i] = time.Duration(engine.RNG.Int63n(int64(span))) }
+    slices.Sort(offsets)
+    g := newArrivalSampler(route, cfg)
+    for _, off := range offsets {
+        if totalTarget > 0 && engine.GeneratedPassengers >= totalTarget { break }
+        updatedStops[g.arrive(engine, from.Add(off), totalTarget)] = struct{}{}
+    }
+    return updatedStops, count
+}
+
+// arrivalSampler draws arrivals under one DemandConfig: direction, then an origin
+// weighted by the spatial gradient and a destination uniformly ahead of it.
+type arrivalSampler struct {
+    route     *model.Route
+    cfg       DemandConfig
+    pOutbound float64
+    outW      []float64 // origin weights by stop index, outbound (last stop excluded)
+    inW       []float64 // ... inbound, from stop index 1
+    outSum    float64
+    inSum     float64
+}
+
+func newArrivalSampler(route *model.Route, cfg DemandConfig) *arrivalSampler {
+    nStops := len(route.Stops)
+    g := &arrivalSampler{route: route, cfg: cfg, pOutbound: 0.5, outW: make([]float64, nStops-1), inW: make([]float64, nStops-1)}
+    if cfg.FavoredOutbound { g.pOutbound = cfg.DirBias / (cfg.DirBias + 1.0) } else if cfg.FavoredInbound { g.pOutbound = 1.0 / (cfg.DirBias + 1.0) }
+    for si := 0; si < nStops-1; si++ { w := gradientWeightOutbound(si, nStops, cfg.SpatialGradient, cfg.BaselineDemand, cfg.DirBias, cfg.FavoredOutbound); g.outW[si] = w; g.outSum += w }
+    for si := 1; si < nStops; si++ { w := gradientWeightInbound(si, nStops, cfg.SpatialGradient, cfg.BaselineDemand, cfg.DirBias, cfg.FavoredInbound); g.inW[si-1] = w; g.inSum += w }
+    return g
+}
+
+// arrive enqueues one arrival event (a group when cfg.GroupSizes is set) at now and
+// returns its origin stop id.
+func (g *arrivalSampler) arrive(engine *Simulator, now time.Time, totalTarget int) int {
+    route, nStops := g.route, len(g.route.Stops)
+    var origin, dest *model.BusStop
+    dir := "outbound"
+    if engine.RNG.Float64() >= g.pOutbound { dir = "inbound" }
+    if dir == "outbound" {
+        r := engine.RNG.Float64()*g.outSum
+        cum := 0.0
+        originIdx := 0
+        for si, w := range g.outW { cum += w; if r <= cum { originIdx = si; break } }
+        destIdx := originIdx + 1 + engine.RNG.Intn(nStops-originIdx-1)
+        origin, dest = route.Stops[originIdx], route.Stops[destIdx]
+    } else {
+        r := engine.RNG.Float64()*g.inSum
+        cum := 0.0
+        originIdxGlobal := 1
+        for k, w := range g.inW { cum += w; if r <= cum { originIdxGlobal = k+1; break } }
+        destIdx := engine.RNG.Intn(originIdxGlobal)
+        origin, dest = route.Stops[originIdxGlobal], route.Stops[destIdx]
+    }
+    size := g.cfg.GroupSizes.Sample(engine.RNG)
+    for i := 0; i < size; i++ {
+        if totalTarget > 0 && engine.GeneratedPassengers >= totalTarget { break }
+        p := engine.NewPassengerPublic(origin.ID, dest.ID, now)
+        p.Direction = dir
+        origin.EnqueuePassenger(p, dir, now)
+        engine.noteArrival(origin)
+        engine.GeneratedPassengers++
+        if dir == "outbound" { engine.OutboundGenerated++ } else { engine.InboundGenerated++ }
+    }
+    return origin.ID
+}
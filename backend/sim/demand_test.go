@@ -0,0 +1,50 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"brt08/backend/internal/simtest"
+	"brt08/backend/model"
+)
+
+func TestGenerateIntervalArrivals(t *testing.T) {
+	route, fleet := simtest.Corridor(t)
+	engine := NewSimulator(route, fleet[0], 1, 1, testStart)
+	cfg := DemandConfig{BaselineDemand: 1, DirBias: 1}
+	from, to := testStart, testStart.Add(2*time.Hour)
+
+	updated, n := GenerateInterval(engine, route, from, to, 2, 0, cfg)
+	// a Poisson count of mean 240 (sd ~15.5)
+	if n < 180 || n > 300 || engine.GeneratedPassengers != n {
+		t.Fatalf("%d arrival events, %d generated; want about 240 of each", n, engine.GeneratedPassengers)
+	}
+	queued := 0
+	for _, st := range route.Stops {
+		for _, q := range [][]*model.Passenger{st.OutboundQueue, st.InboundQueue} {
+			for i, p := range q {
+				if p.ArrivalStopTime.Before(from) || !p.ArrivalStopTime.Before(to) {
+					t.Errorf("stop %d: arrival at %s outside the interval", st.ID, p.ArrivalStopTime)
+				}
+				if i > 0 && p.ArrivalStopTime.Before(q[i-1].ArrivalStopTime) {
+					t.Errorf("stop %d: arrivals out of time order", st.ID)
+				}
+			}
+			queued += len(q)
+		}
+		if len(st.OutboundQueue)+len(st.InboundQueue) > 0 {
+			if _, ok := updated[st.ID]; !ok {
+				t.Errorf("stop %d got passengers but is not reported updated", st.ID)
+			}
+		}
+	}
+	if queued != n {
+		t.Errorf("%d queued, want %d", queued, n)
+	}
+
+	// the count is cut to what the cap leaves
+	_, n = GenerateInterval(engine, route, to, to.Add(2*time.Hour), 2, engine.GeneratedPassengers+10, cfg)
+	if n != 10 {
+		t.Errorf("%d arrival events under a cap 10 away, want 10", n)
+	}
+}
@@ -0,0 +1,62 @@
+package sim
+
+import (
+	"fmt"
+	"time"
+
+	"brt08/backend/data"
+)
+
+// DemandChange switches the demand of a running stream from now on: the time period
+// (0 = unchanged), the favored-direction bias and the spatial gradient (nil = unchanged).
+type DemandChange struct {
+	PeriodID        int
+	DirBias         *float64
+	SpatialGradient *float64
+}
+
+// Validate checks the values against the ranges a stream accepts at its start.
+func (c DemandChange) Validate() error {
+	if c.PeriodID != 0 && periodName(c.PeriodID) == "" {
+		return fmt.Errorf("period must be in [1, %d]", len(data.TimePeriods))
+	}
+	if c.DirBias != nil && (*c.DirBias < 0.01 || *c.DirBias > 100) {
+		return fmt.Errorf("dir_bias must be a number in [0.01, 100]")
+	}
+	if c.SpatialGradient != nil && (*c.SpatialGradient < 0 || *c.SpatialGradient > 1) {
+		return fmt.Errorf("spatial_gradient must be a number in [0, 1]")
+	}
+	return nil
+}
+
+// DemandChangeEvent reports the demand settings of a stream after a DemandChange.
+type DemandChangeEvent struct {
+	Time            time.Time `json:"time"`
+	PeriodID        int       `json:"period_id"`
+	Period          string    `json:"period_name"`
+	Multiplier      float64   `json:"multiplier"`
+	Favored         string    `json:"favored_direction,omitempty"`
+	DirBias         float64   `json:"dir_bias"`
+	SpatialGradient float64   `json:"spatial_gradient"`
+}
+
+func (DemandChangeEvent) isEvent() {}
+
+func periodName(id int) string {
+	for _, p := range data.TimePeriods {
+		if p.ID == id {
+			return p.Name
+		}
+	}
+	return ""
+}
+
+// profileFrom returns the demand profile kind of periodID for a run switching to it
+// after elapsed: the period starts then, at the beginning of its clock window.
+func profileFrom(kind string, periodID int, elapsed time.Duration) (DemandProfile, float64) {
+	p, max := FlatProfile(periodID)
+	if kind == "period" {
+		p, max = PeriodProfile(periodID, float64(periodStartMin(periodID)))
+	}
+	return func(e time.Duration) float64 { return p(e - elapsed) }, max
+}
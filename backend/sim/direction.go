@@ -0,0 +1,71 @@
+package sim
+
+import "brt08/backend/model"
+
+// DirectionStats splits a run's demand and service by direction of travel, which the
+// direction-biased demand model makes lopsided in most periods.
+type DirectionStats struct {
+	Direction   string  `json:"direction"`
+	Generated   int     `json:"generated"`
+	Served      int64   `json:"served"`       // delivered to their destination
+	AvgWaitMin  float64 `json:"avg_wait_min"` // mean boarding wait
+	PassengerKM float64 `json:"passenger_km"` // distance ridden by the served passengers
+}
+
+// DirectionRecorder accumulates served passengers and their passenger-km by direction
+// from alightings. Caller must ensure synchronization.
+type DirectionRecorder struct {
+	index  map[int]int // stop id -> route position
+	fwd    []float64   // outbound km from the first stop to each stop
+	back   []float64   // inbound km from each stop ... summed from the first stop
+	served [2]int64
+	km     [2]float64
+}
+
+// NewDirectionRecorder returns a recorder measuring distances along route.
+func NewDirectionRecorder(route *model.Route) *DirectionRecorder {
+	n := len(route.Stops)
+	r := &DirectionRecorder{index: make(map[int]int, n), fwd: make([]float64, n), back: make([]float64, n)}
+	for i, st := range route.Stops {
+		r.index[st.ID] = i
+		if i > 0 {
+			r.fwd[i] = r.fwd[i-1] + route.SegmentKM(i-1, i)
+			r.back[i] = r.back[i-1] + route.SegmentKM(i, i-1)
+		}
+	}
+	return r
+}
+
+// Alight records passengers delivered to their destination.
+func (r *DirectionRecorder) Alight(ps []*model.Passenger) {
+	for _, p := range ps {
+		d := tripDirection(p.Direction)
+		r.served[d]++
+		from, ok1 := r.index[p.StartStopID]
+		to, ok2 := r.index[p.EndStopID]
+		if !ok1 || !ok2 {
+			continue
+		}
+		if d == 0 {
+			r.km[d] += r.fwd[max(from, to)] - r.fwd[min(from, to)]
+		} else {
+			r.km[d] += r.back[max(from, to)] - r.back[min(from, to)]
+		}
+	}
+}
+
+// Stats returns both directions, outbound first, with the generation counts of e and
+// the boarding waits of wait.
+func (r *DirectionRecorder) Stats(e *Simulator, wait WaitDistribution) []DirectionStats {
+	out := make([]DirectionStats, 0, len(tripDirections))
+	for d, dir := range tripDirections {
+		s := DirectionStats{Direction: dir, Served: r.served[d], PassengerKM: r.km[d], AvgWaitMin: wait.ByDirection[dir].Mean}
+		if d == 0 {
+			s.Generated = e.OutboundGenerated
+		} else {
+			s.Generated = e.InboundGenerated
+		}
+		out = append(out, s)
+	}
+	return out
+}
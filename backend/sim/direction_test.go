@@ -0,0 +1,36 @@
+package sim
+
+import (
+	"math"
+	"testing"
+
+	"brt08/backend/internal/simtest"
+	"brt08/backend/model"
+)
+
+func TestDirectionStatsSplitByDirection(t *testing.T) {
+	route, _ := simtest.Corridor(t)
+	id := func(i int) int { return route.Stops[i].ID }
+	r := NewDirectionRecorder(route)
+	r.Alight([]*model.Passenger{
+		{Direction: "outbound", StartStopID: id(0), EndStopID: id(2)},
+		{Direction: "outbound", StartStopID: id(1), EndStopID: id(2)},
+		{Direction: "inbound", StartStopID: id(3), EndStopID: id(1)},
+	})
+	e := &Simulator{OutboundGenerated: 5, InboundGenerated: 3}
+	wait := WaitDistribution{ByDirection: map[string]WaitPercentiles{"outbound": {Mean: 4}, "inbound": {Mean: 7}}}
+	got := r.Stats(e, wait)
+	want := []DirectionStats{
+		{Direction: "outbound", Generated: 5, Served: 2, AvgWaitMin: 4, PassengerKM: route.SegmentKM(0, 1) + 2*route.SegmentKM(1, 2)},
+		{Direction: "inbound", Generated: 3, Served: 1, AvgWaitMin: 7, PassengerKM: route.SegmentKM(3, 2) + route.SegmentKM(2, 1)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("stats = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Direction != w.Direction || g.Generated != w.Generated || g.Served != w.Served || g.AvgWaitMin != w.AvgWaitMin || math.Abs(g.PassengerKM-w.PassengerKM) > 1e-9 {
+			t.Errorf("%s = %+v, want %+v", w.Direction, g, w)
+		}
+	}
+}
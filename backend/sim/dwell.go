@@ -0,0 +1,329 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+)
+
+// Dwell timing shared by the runner and the batch driver. A stop visit keeps the doors
+// open for PreBoardPause (alighting), then DwellDeadTime plus DwellPerPassenger per
+// boarding or alighting passenger, the whole dwell after the pause capped at DwellMax.
+const (
+	PreBoardPause     = 650 * time.Millisecond
+	DwellDeadTime     = 1200 * time.Millisecond
+	DwellPerPassenger = 300 * time.Millisecond
+	DwellMax          = 4 * time.Second
+)
+
+// DwellTime splits the dwell after the pre-board pause into dead time (door operation,
+// fixed) and passenger service time for the given movements.
+func DwellTime(boardedN, alightedN int) (dead, service time.Duration) {
+	service = DwellPerPassenger * time.Duration(boardedN+alightedN)
+	if DwellDeadTime+service > DwellMax {
+		service = DwellMax - DwellDeadTime
+	}
+	return DwellDeadTime, service
+}
+
+// DwellRecorder collects per-stop dwell samples (bus arrival to departure) for station design analytics.
+// A stop keeps its latest maxDwellVisits visits; older ones fold into running totals
+// (see stopDwells.fold), so memory stays bounded however long the run.
+// Caller must ensure synchronization.
+type DwellRecorder struct {
+	stops map[int]*stopDwells
+	last  time.Time // latest recorded departure
+}
+
+// maxDwellVisits is how many visits a stop keeps before folding the older ones.
+const maxDwellVisits = 1 << 12
+
+// dwellFoldLag is how far before the latest departure folding stops: a visit recorded
+// later with an arrival further back than this loses the part before the fold.
+const dwellFoldLag = time.Hour
+
+// stopDwells is one stop's visits: those since cut, and aggregates of the folded ones.
+type stopDwells struct {
+	visits [][2]time.Time // [arrive, depart) intervals departing after cut
+	cut    time.Time      // occupancy is tallied in secs up to here (zero = nothing folded)
+	n      int            // folded visits
+	sum    float64        // ... their total dwell (s)
+	max    float64
+	bins   []int     // ... their dwells in 1 s bins
+	secs   []float64 // seconds before cut spent with k buses dwelling (secs[0] unused)
+	maxC   int
+}
+
+// NewDwellRecorder creates an empty recorder.
+func NewDwellRecorder() *DwellRecorder {
+	return &DwellRecorder{stops: make(map[int]*stopDwells)}
+}
+
+// Record stores one bus dwell at a stop.
+func (r *DwellRecorder) Record(stopID int, arrive, depart time.Time) {
+	if depart.Before(arrive) {
+		return
+	}
+	if depart.After(r.last) {
+		r.last = depart
+	}
+	d := r.stops[stopID]
+	if d == nil {
+		d = &stopDwells{}
+		r.stops[stopID] = d
+	}
+	d.visits = append(d.visits, [2]time.Time{arrive, depart})
+	if len(d.visits) > maxDwellVisits {
+		d.fold(r.last.Add(-dwellFoldLag))
+	}
+}
+
+// fold tallies the occupancy before cut and moves the visits departed by then into
+// the aggregates.
+func (d *stopDwells) fold(cut time.Time) {
+	if !cut.After(d.cut) {
+		return
+	}
+	from := d.cut
+	if from.IsZero() {
+		from = d.visits[0][0]
+		for _, v := range d.visits {
+			if v[0].Before(from) {
+				from = v[0]
+			}
+		}
+	}
+	d.secs, d.maxC = sweepOccupancy(d.visits, from, cut, d.secs, d.maxC)
+	keep := d.visits[:0]
+	for _, v := range d.visits {
+		if v[1].After(cut) {
+			keep = append(keep, v)
+			continue
+		}
+		dur := v[1].Sub(v[0]).Seconds()
+		d.n++
+		d.sum += dur
+		d.max = math.Max(d.max, dur)
+		b := int(math.Floor(dur))
+		for len(d.bins) <= b {
+			d.bins = append(d.bins, 0)
+		}
+		d.bins[b]++
+	}
+	clear(d.visits[len(keep):])
+	d.visits = keep
+	d.cut = cut
+}
+
+// DwellBin is one histogram bucket of dwell durations.
+type DwellBin struct {
+	LoSec float64 `json:"lo_s"`
+	HiSec float64 `json:"hi_s"`
+	Count int     `json:"count"`
+}
+
+// StopDwellStats summarizes dwell times and berth occupancy at one stop.
+type StopDwellStats struct {
+	StopID        int        `json:"stop_id"`
+	Name          string     `json:"name"`
+	Visits        int        `json:"visits"`
+	MeanSec       float64    `json:"mean_s"`
+	P50Sec        float64    `json:"p50_s"`
+	P90Sec        float64    `json:"p90_s"`
+	MaxSec        float64    `json:"max_s"`
+	Histogram     []DwellBin `json:"histogram"`
+	MaxConcurrent int        `json:"max_concurrent"`
+	// OccupancyShare[k] is the share of the observation window with exactly k buses dwelling.
+	OccupancyShare []float64 `json:"occupancy_share"`
+}
+
+// Stats summarizes all stops in route order over the window [start, end).
+// A zero end uses the latest recorded departure.
+func (r *DwellRecorder) Stats(route *model.Route, start, end time.Time) []StopDwellStats {
+	if end.IsZero() {
+		end = r.last
+	}
+	window := end.Sub(start).Seconds()
+	out := make([]StopDwellStats, 0, len(route.Stops))
+	for _, st := range route.Stops {
+		d := r.stops[st.ID]
+		s := StopDwellStats{StopID: st.ID, Name: st.Name}
+		if d == nil {
+			out = append(out, s)
+			continue
+		}
+		s.Visits = d.n + len(d.visits)
+		durs := make([]float64, len(d.visits))
+		sum, maxSec := d.sum, d.max
+		for i, v := range d.visits {
+			durs[i] = v[1].Sub(v[0]).Seconds()
+			sum += durs[i]
+			maxSec = math.Max(maxSec, durs[i])
+		}
+		sort.Float64s(durs)
+		s.MeanSec = sum / float64(s.Visits)
+		s.MaxSec = maxSec
+		// 1-second bins from 0 up to the maximum
+		nBins := int(math.Floor(s.MaxSec)) + 1
+		s.Histogram = make([]DwellBin, nBins)
+		for i := range s.Histogram {
+			s.Histogram[i] = DwellBin{LoSec: float64(i), HiSec: float64(i + 1)}
+		}
+		for i, c := range d.bins {
+			s.Histogram[i].Count += c
+		}
+		for _, v := range durs {
+			s.Histogram[int(math.Floor(v))].Count++
+		}
+		if d.n == 0 {
+			s.P50Sec = Percentile(durs, 50)
+			s.P90Sec = Percentile(durs, 90)
+		} else {
+			s.P50Sec = binnedPercentile(s.Histogram, s.Visits, 50, s.MaxSec)
+			s.P90Sec = binnedPercentile(s.Histogram, s.Visits, 90, s.MaxSec)
+		}
+		from := d.cut
+		if from.IsZero() {
+			from = start
+		}
+		secs, maxC := sweepOccupancy(d.visits, from, time.Time{}, append([]float64(nil), d.secs...), d.maxC)
+		s.OccupancyShare, s.MaxConcurrent = occupancyShares(secs, maxC, window)
+		out = append(out, s)
+	}
+	return out
+}
+
+// binnedPercentile interpolates the p-th percentile of n dwells from their 1 s
+// histogram, spreading each bin's dwells evenly across it.
+func binnedPercentile(hist []DwellBin, n int, p, maxSec float64) float64 {
+	rank := p / 100 * float64(n-1)
+	seen := 0
+	for _, b := range hist {
+		if b.Count == 0 || float64(seen+b.Count) <= rank {
+			seen += b.Count
+			continue
+		}
+		return math.Min(b.LoSec+(rank-float64(seen)+0.5)/float64(b.Count), maxSec)
+	}
+	return maxSec
+}
+
+// sweepOccupancy adds to secs[k] the time in [from, to) spent with k of the dwell
+// intervals vs open (a zero to leaves the sweep open-ended), raising maxC to the most
+// open at once.
+func sweepOccupancy(vs [][2]time.Time, from, to time.Time, secs []float64, maxC int) ([]float64, int) {
+	type edge struct {
+		t     time.Time
+		delta int
+	}
+	edges := make([]edge, 0, 2*len(vs))
+	for _, v := range vs {
+		if !to.IsZero() && !v[0].Before(to) {
+			continue
+		}
+		edges = append(edges, edge{v[0], 1})
+		if to.IsZero() || v[1].Before(to) {
+			edges = append(edges, edge{v[1], -1})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].t.Equal(edges[j].t) {
+			return edges[i].delta < edges[j].delta
+		}
+		return edges[i].t.Before(edges[j].t)
+	})
+	for len(secs) <= maxC {
+		secs = append(secs, 0)
+	}
+	cur := 0
+	prev := from
+	for _, e := range edges {
+		if e.t.After(prev) {
+			secs[cur] += e.t.Sub(prev).Seconds()
+			prev = e.t
+		}
+		cur += e.delta
+		if cur > maxC {
+			maxC = cur
+			for len(secs) <= maxC {
+				secs = append(secs, 0)
+			}
+		}
+	}
+	if !to.IsZero() && to.After(prev) {
+		secs[cur] += to.Sub(prev).Seconds()
+	}
+	return secs, maxC
+}
+
+// occupancyShares turns the seconds spent with k buses at a stop into shares of the
+// window, the idle share being whatever the busy ones leave.
+func occupancyShares(secs []float64, maxC int, window float64) ([]float64, int) {
+	if window <= 0 {
+		return secs, maxC
+	}
+	busy := 0.0
+	for k := 1; k < len(secs); k++ {
+		busy += secs[k]
+	}
+	secs[0] = window - busy
+	for k := range secs {
+		secs[k] /= window
+	}
+	return secs, maxC
+}
+
+// Percentile returns the p-th percentile (0..100) of sorted values using linear interpolation.
+func Percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	if lo == hi {
+		return sorted[lo]
+	}
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// WriteDwellReport writes per-stop dwell statistics, histograms and berth occupancy shares as CSV.
+// Path handling matches WriteCSVReport.
+func WriteDwellReport(reportPath string, stats []StopDwellStats) (string, error) {
+	if reportPath == "" {
+		return "", nil
+	}
+	ts := time.Now().Format("20060102-150405")
+	outPath := timestampedPath(reportPath, "dwell", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "section,stop_id,stop_name,visits,mean_s,p50_s,p90_s,max_s,max_concurrent,bin_lo_s,bin_hi_s,count,buses_dwelling,time_share")
+	for _, s := range stats {
+		name := csvQuote(s.Name)
+		fmt.Fprintf(w, "dwell,%d,%s,%d,%.2f,%.2f,%.2f,%.2f,%d,,,,,\n", s.StopID, name, s.Visits, s.MeanSec, s.P50Sec, s.P90Sec, s.MaxSec, s.MaxConcurrent)
+		for _, b := range s.Histogram {
+			fmt.Fprintf(w, "histogram,%d,%s,,,,,,,%.0f,%.0f,%d,,\n", s.StopID, name, b.LoSec, b.HiSec, b.Count)
+		}
+		for k, share := range s.OccupancyShare {
+			fmt.Fprintf(w, "berth,%d,%s,,,,,,,,,,%d,%.4f\n", s.StopID, name, k, share)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("dwell report written", "path", outPath)
+	return outPath, nil
+}
@@ -0,0 +1,227 @@
+package sim
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// EnergyModel estimates traction and auxiliary energy for one stop-to-stop segment of
+// a battery-electric bus: rolling resistance and aerodynamic drag over the distance,
+// the kinetic energy of accelerating to cruise speed (partly recovered when braking
+// into the next stop), and a constant auxiliary load (HVAC, doors, lighting) for the
+// running time. Speed is taken as the segment average.
+type EnergyModel struct {
+	BaseMassKg     float64 // empty vehicle mass independent of size
+	MassPerPlaceKg float64 // added empty mass per unit of type capacity
+	PassengerKg    float64
+	RollingCoeff   float64 // rolling resistance coefficient
+	DragAreaM2     float64 // drag coefficient x frontal area
+	AirDensity     float64 // kg/m^3
+	DriveEff       float64 // battery-to-wheel efficiency
+	RegenFrac      float64 // share of kinetic energy recovered when braking
+	AuxKW          float64 // auxiliary load while running
+}
+
+// DefaultEnergyModel returns figures typical of 12-18 m electric city buses.
+func DefaultEnergyModel() EnergyModel {
+	return EnergyModel{BaseMassKg: 6000, MassPerPlaceKg: 90, PassengerKg: 70, RollingCoeff: 0.008, DragAreaM2: 6.5, AirDensity: 1.2, DriveEff: 0.85, RegenFrac: 0.3, AuxKW: 12}
+}
+
+// ForType overlays the energy coefficients of bt's vehicle parameters (non-zero
+// fields) on m.
+func (m EnergyModel) ForType(bt *model.BusType) EnergyModel {
+	if bt == nil || bt.Params == nil || bt.Params.Energy == nil {
+		return m
+	}
+	e := bt.Params.Energy
+	set := func(dst *float64, v float64) {
+		if v != 0 {
+			*dst = v
+		}
+	}
+	set(&m.BaseMassKg, e.BaseMassKg)
+	set(&m.MassPerPlaceKg, e.MassPerPlaceKg)
+	set(&m.RollingCoeff, e.RollingCoeff)
+	set(&m.DragAreaM2, e.DragAreaM2)
+	set(&m.DriveEff, e.DriveEff)
+	set(&m.RegenFrac, e.RegenFrac)
+	set(&m.AuxKW, e.AuxKW)
+	return m
+}
+
+// SegmentCO2Kg returns the emissions of covering distKM using kwh, from bt's vehicle
+// parameters (direct g/km plus g/kWh of energy); 0 without parameters.
+func SegmentCO2Kg(bt *model.BusType, distKM, kwh float64) float64 {
+	if bt == nil || bt.Params == nil {
+		return 0
+	}
+	g := 0.0
+	if p := bt.Params.CO2GPerKm; p != nil {
+		g += *p * distKM
+	}
+	if p := bt.Params.CO2GPerKWh; p != nil {
+		g += *p * kwh
+	}
+	return g / 1000
+}
+
+// MassKg is the vehicle mass of bt carrying load passengers.
+func (m EnergyModel) MassKg(bt *model.BusType, load int) float64 {
+	mass := m.BaseMassKg + float64(load)*m.PassengerKg
+	if bt != nil {
+		mass += float64(bt.Capacity) * m.MassPerPlaceKg
+	}
+	return mass
+}
+
+// SegmentKWh returns the energy used to cover distKM in dur with load passengers aboard.
+func (m EnergyModel) SegmentKWh(bt *model.BusType, load int, distKM float64, dur time.Duration) float64 {
+	d, t := distKM*1000, dur.Seconds()
+	if d <= 0 || t <= 0 {
+		return 0
+	}
+	m = m.ForType(bt)
+	v := d / t
+	mass := m.MassKg(bt, load)
+	traction := m.RollingCoeff*mass*9.81*d + 0.5*m.AirDensity*m.DragAreaM2*v*v*d + 0.5*mass*v*v*(1-m.RegenFrac)
+	if m.DriveEff > 0 {
+		traction /= m.DriveEff
+	}
+	return (traction + m.AuxKW*1000*t) / 3.6e6
+}
+
+// SegmentAdvice is the recommended speed on one segment for one bus type.
+type SegmentAdvice struct {
+	TypeID       int     `json:"type_id"`
+	FromStopID   int     `json:"from_stop_id"`
+	ToStopID     int     `json:"to_stop_id"`
+	DistanceKM   float64 `json:"distance_km"`
+	SpeedKmph    float64 `json:"speed_kmph"`    // recommended average speed
+	BaselineKmph float64 `json:"baseline_kmph"` // the type's scheduled speed
+}
+
+// EcoAdvisor recommends per-segment speeds that minimize energy plus TimeWeightKW x
+// running time. Lower speeds save drag and braking losses but pay the auxiliary load
+// for longer, so short segments (more stop-start energy per km) get lower speeds.
+// It never advises driving faster than a bus's own speed, and it implements
+// TravelTimeProvider so both drivers can apply the advice ("eco-driving" mode).
+type EcoAdvisor struct {
+	Model        EnergyModel
+	TimeWeightKW float64 // value of running time, in kW of energy-equivalent (0 = pure energy)
+	MinKmph      float64
+	Next         TravelTimeProvider // optional external traffic model; the slower time wins
+
+	busType map[int]*model.BusType
+	advice  map[int]map[[2]int]float64 // type id -> (from,to) -> km/h
+	list    []SegmentAdvice
+}
+
+// NewEcoAdvisor computes advice for every segment (both directions) and every bus type
+// in fleet, assuming each type runs half full.
+func NewEcoAdvisor(route *model.Route, fleet []*model.Bus, m EnergyModel, timeWeightKW float64) *EcoAdvisor {
+	a := &EcoAdvisor{Model: m, TimeWeightKW: timeWeightKW, MinKmph: 10, busType: make(map[int]*model.BusType), advice: make(map[int]map[[2]int]float64)}
+	baseline := make(map[int]float64) // mean scheduled speed per type
+	counts := make(map[int]int)
+	var types []*model.BusType
+	for _, b := range fleet {
+		if b.Type == nil {
+			continue
+		}
+		a.busType[b.ID] = b.Type
+		if counts[b.Type.ID] == 0 {
+			types = append(types, b.Type)
+		}
+		counts[b.Type.ID]++
+		baseline[b.Type.ID] += b.AverageSpeedKmph
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].ID < types[j].ID })
+	for _, bt := range types {
+		base := baseline[bt.ID] / float64(counts[bt.ID])
+		seg := make(map[[2]int]float64)
+		for i := 0; i+1 < len(route.Stops); i++ {
+			from, to := route.Stops[i], route.Stops[i+1]
+			out, in := route.SegmentKM(i, i+1), route.SegmentKM(i+1, i)
+			v := a.optimalKmph(bt, out)
+			seg[[2]int{from.ID, to.ID}] = v
+			a.list = append(a.list, SegmentAdvice{TypeID: bt.ID, FromStopID: from.ID, ToStopID: to.ID, DistanceKM: out, SpeedKmph: advised(v, base), BaselineKmph: base})
+			if in == out {
+				seg[[2]int{to.ID, from.ID}] = v
+				continue
+			}
+			// a different inbound carriageway gets its own advice
+			v = a.optimalKmph(bt, in)
+			seg[[2]int{to.ID, from.ID}] = v
+			a.list = append(a.list, SegmentAdvice{TypeID: bt.ID, FromStopID: to.ID, ToStopID: from.ID, DistanceKM: in, SpeedKmph: advised(v, base), BaselineKmph: base})
+		}
+		a.advice[bt.ID] = seg
+	}
+	return a
+}
+
+// advised caps the optimal speed v at the type's scheduled speed base, when known.
+func advised(v, base float64) float64 {
+	if base <= 0 {
+		return v
+	}
+	return math.Min(v, base)
+}
+
+// optimalKmph minimizes K v^2 + P d / v (speed-dependent energy plus weighted time),
+// whose minimum is v = cbrt(P d / 2K).
+func (a *EcoAdvisor) optimalKmph(bt *model.BusType, distKM float64) float64 {
+	m := a.Model.ForType(bt)
+	d := distKM * 1000
+	if d <= 0 {
+		return a.MinKmph
+	}
+	mass := m.MassKg(bt, 0)
+	if bt != nil {
+		mass = m.MassKg(bt, bt.Capacity/2)
+	}
+	eff := m.DriveEff
+	if eff <= 0 {
+		eff = 1
+	}
+	k := (0.5*m.AirDensity*m.DragAreaM2*d + 0.5*mass*(1-m.RegenFrac)) / eff
+	p := (m.AuxKW + a.TimeWeightKW) * 1000
+	if k <= 0 || p <= 0 {
+		return a.MinKmph
+	}
+	v := math.Cbrt(p*d/(2*k)) * 3.6
+	return math.Max(v, a.MinKmph)
+}
+
+// Advice lists the recommended speeds in route order, per bus type.
+func (a *EcoAdvisor) Advice() []SegmentAdvice { return a.list }
+
+// SpeedKmph returns the advised speed for bus on from->to, capped at its own speed.
+func (a *EcoAdvisor) SpeedKmph(busID, fromStopID, toStopID int, busSpeed float64) float64 {
+	bt := a.busType[busID]
+	if bt == nil {
+		return busSpeed
+	}
+	v, ok := a.advice[bt.ID][[2]int{fromStopID, toStopID}]
+	if !ok || (busSpeed > 0 && v > busSpeed) {
+		return busSpeed
+	}
+	return v
+}
+
+// SegmentTravelTime runs the segment at the advised speed; repositioning moves are
+// advised too. With Next set, its time is used when it is slower (congestion).
+func (a *EcoAdvisor) SegmentTravelTime(req TravelTimeRequest) (time.Duration, bool) {
+	v := a.SpeedKmph(req.BusID, req.FromStopID, req.ToStopID, req.SpeedKmph)
+	if v <= 0 || req.DistanceKM <= 0 {
+		return 0, false
+	}
+	d := time.Duration(req.DistanceKM / v * float64(time.Hour))
+	if a.Next != nil {
+		if nd, ok := a.Next.SegmentTravelTime(req); ok && nd > d {
+			d = nd
+		}
+	}
+	return d, true
+}
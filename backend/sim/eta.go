@@ -0,0 +1,300 @@
+package sim
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// etaDefaultSpeedKmph stands in for a bus without an average speed.
+const etaDefaultSpeedKmph = 25.0
+
+// ETADisplayBuses is how many buses a passenger information display lists per direction.
+const ETADisplayBuses = 3
+
+// ETAHorizonEdges are the lower bounds, in minutes ahead of the arrival, of the
+// buckets ETA accuracy is reported in.
+var ETAHorizonEdges = []float64{0, 2, 5, 10, 20}
+
+// BusETA is the predicted arrival of one bus at a stop.
+type BusETA struct {
+	BusID     int       `json:"bus_id"`
+	Direction string    `json:"direction"` // the direction the bus is running now
+	ETA       time.Time `json:"eta"`
+	ETAMin    float64   `json:"eta_min"` // minutes from the prediction time
+	Status    string    `json:"status"`  // at_stop, approaching, en_route or after_turnaround
+	Onboard   int       `json:"onboard"`
+}
+
+// StopETAEvent is a passenger information display update: the next-bus countdowns of
+// one stop, emitted for every stop each RunnerOptions.ETAInterval.
+type StopETAEvent struct {
+	StopETA
+}
+
+func (StopETAEvent) isEvent() {}
+
+// ETAAccuracy compares the displayed predictions with the actual arrivals, for
+// predictions made a given time ahead.
+type ETAAccuracy struct {
+	HorizonMin     float64 `json:"horizon_min"`     // predictions made at least this many minutes ahead
+	HorizonMaxMin  float64 `json:"horizon_max_min"` // and less than this many (0 = no upper bound)
+	Predictions    int     `json:"predictions"`
+	MeanErrorMin   float64 `json:"mean_error_min"` // actual minus predicted; positive = the bus came late
+	MAEMin         float64 `json:"mae_min"`        // mean absolute error
+	P90AbsErrorMin float64 `json:"p90_abs_error_min"`
+}
+
+// StopETA lists the next buses due at a stop in each direction, soonest first.
+type StopETA struct {
+	StopID   int       `json:"stop_id"`
+	Name     string    `json:"stop_name"`
+	Time     time.Time `json:"time"` // simulated time of the prediction
+	Outbound []BusETA  `json:"outbound"`
+	Inbound  []BusETA  `json:"inbound"`
+}
+
+// ETATracker follows where each bus is on the route and predicts its arrival at stops,
+// as a passenger information display would: the rest of the current move or dwell,
+// then each further segment at the bus's average speed, plus the mean dwell observed
+// at the stops in between and the terminal turnaround for buses still running the
+// other way. Predictions are made as of the latest bus clock reported, since each
+// bus keeps its own simulated clock. Caller must ensure synchronization.
+type ETATracker struct {
+	route    *model.Route
+	now      time.Time   // latest bus clock seen
+	index    map[int]int // stop id -> position on the route
+	buses    map[int]*etaBus
+	order    []int // bus ids in first-seen order
+	dwellSum map[int]time.Duration
+	dwellN   map[int]int
+	pending  map[etaKey][]etaPrediction // displayed predictions awaiting the arrival
+	errs     [][]float64                // prediction errors (minutes) per ETAHorizonEdges bucket
+	// Turnaround is the terminal turn assumed for buses still running the other way
+	// (0 = DefaultTurnaroundTime).
+	Turnaround time.Duration
+}
+
+type etaKey struct {
+	bus, stop int
+	dir       string
+}
+
+type etaPrediction struct{ made, eta time.Time }
+
+type etaBus struct {
+	bus    *model.Bus
+	idx    int       // stop the bus is dwelling at or heading to
+	moving bool      // heading to idx, arriving at until
+	until  time.Time // arrival at idx, or the end of the dwell there
+}
+
+// NewETATracker returns a tracker for route with no buses placed yet.
+func NewETATracker(route *model.Route) *ETATracker {
+	t := &ETATracker{route: route, index: make(map[int]int, len(route.Stops)), buses: make(map[int]*etaBus), pending: make(map[etaKey][]etaPrediction), errs: make([][]float64, len(ETAHorizonEdges)), dwellSum: make(map[int]time.Duration), dwellN: make(map[int]int)}
+	for i, s := range route.Stops {
+		t.index[s.ID] = i
+	}
+	return t
+}
+
+func (t *ETATracker) state(b *model.Bus, at time.Time) *etaBus {
+	if at.After(t.now) {
+		t.now = at
+	}
+	s, ok := t.buses[b.ID]
+	if !ok {
+		s = &etaBus{bus: b}
+		t.buses[b.ID] = s
+		t.order = append(t.order, b.ID)
+	}
+	return s
+}
+
+// Dwell records b dwelling at stopID from start until the doors close at until.
+func (t *ETATracker) Dwell(b *model.Bus, stopID int, start, until time.Time) {
+	s := t.state(b, start)
+	s.idx, s.moving, s.until = t.index[stopID], false, until
+	t.dwellSum[stopID] += until.Sub(start)
+	t.dwellN[stopID]++
+}
+
+// Move records b leaving for stopID at depart, arriving at arrive.
+func (t *ETATracker) Move(b *model.Bus, stopID int, depart, arrive time.Time) {
+	s := t.state(b, depart)
+	s.idx, s.moving, s.until = t.index[stopID], true, arrive
+}
+
+// Turn records b turning at its terminal into its new direction at at, ready at until.
+func (t *ETATracker) Turn(b *model.Bus, at, until time.Time) {
+	s := t.state(b, at)
+	s.idx, s.moving, s.until = t.index[b.CurrentStopID], false, until
+}
+
+// dwellEst is the expected stop time at position i: the pre-board pause plus the mean
+// dwell seen there (the dead time before any bus has stopped).
+func (t *ETATracker) dwellEst(i int) time.Duration {
+	id := t.route.Stops[i].ID
+	if n := t.dwellN[id]; n > 0 {
+		return PreBoardPause + t.dwellSum[id]/time.Duration(n)
+	}
+	return PreBoardPause + DwellDeadTime
+}
+
+// walk returns the arrival at position to of a bus leaving position from at dep,
+// one stop at a time in the direction of step (+1 outbound, -1 inbound).
+func (t *ETATracker) walk(from, to, step int, dep time.Time, speed float64) time.Time {
+	for i := from; i != to; i += step {
+		dep = dep.Add(time.Duration(t.route.SegmentKM(i, i+step) / speed * float64(time.Hour)))
+		if i+step != to {
+			dep = dep.Add(t.dwellEst(i + step))
+		}
+	}
+	return dep
+}
+
+// arrival predicts when s reaches position k running dir; false when it has already
+// passed k in that direction on its current trip.
+func (t *ETATracker) arrival(s *etaBus, k int, dir string, now time.Time) (time.Time, string, bool) {
+	speed := s.bus.AverageSpeedKmph
+	if speed <= 0 {
+		speed = etaDefaultSpeedKmph
+	}
+	step, end := 1, len(t.route.Stops)-1
+	if s.bus.Direction == "inbound" {
+		step, end = -1, 0
+	}
+	dep := s.until
+	if s.moving {
+		dep = dep.Add(t.dwellEst(s.idx))
+	}
+	if s.bus.Direction == dir {
+		switch {
+		case (k-s.idx)*step < 0:
+			return time.Time{}, "", false
+		case k == s.idx && s.moving:
+			return s.until, "approaching", true
+		case k == s.idx:
+			return now, "at_stop", true
+		}
+		return t.walk(s.idx, k, step, dep, speed), "en_route", true
+	}
+	// finish the trip, turn at the terminal and come back
+	if s.idx != end {
+		dep = t.walk(s.idx, end, step, dep, speed).Add(t.dwellEst(end))
+	}
+	if t.Turnaround > 0 {
+		dep = dep.Add(t.Turnaround)
+	} else {
+		dep = dep.Add(DefaultTurnaroundTime)
+	}
+	if k == end {
+		return dep, "after_turnaround", true
+	}
+	return t.walk(end, k, -step, dep.Add(t.dwellEst(end)), speed), "after_turnaround", true
+}
+
+// Predict returns the next limit buses (0 = all) due at stopID in each direction;
+// false when the stop is not on the route.
+func (t *ETATracker) Predict(stopID, limit int) (StopETA, bool) {
+	now := t.now
+	k, ok := t.index[stopID]
+	if !ok {
+		return StopETA{}, false
+	}
+	out := StopETA{StopID: stopID, Name: t.route.Stops[k].Name, Time: now, Outbound: []BusETA{}, Inbound: []BusETA{}}
+	for _, dir := range []string{"outbound", "inbound"} {
+		var list []BusETA
+		for _, id := range t.order {
+			s := t.buses[id]
+			at, status, ok := t.arrival(s, k, dir, now)
+			if !ok {
+				continue
+			}
+			if at.Before(now) {
+				at = now
+			}
+			list = append(list, BusETA{BusID: id, Direction: s.bus.Direction, ETA: at, ETAMin: at.Sub(now).Minutes(), Status: status, Onboard: s.bus.PassengersOnboard})
+		}
+		sort.SliceStable(list, func(i, j int) bool { return list[i].ETA.Before(list[j].ETA) })
+		if limit > 0 && len(list) > limit {
+			list = list[:limit]
+		}
+		if list == nil {
+			list = []BusETA{}
+		}
+		if dir == "outbound" {
+			out.Outbound = list
+		} else {
+			out.Inbound = list
+		}
+	}
+	return out, true
+}
+
+// Display predicts like Predict and remembers the listed arrivals, so Arrive can score
+// them; false also when no bus has been placed yet.
+func (t *ETATracker) Display(stopID, limit int) (StopETA, bool) {
+	if len(t.order) == 0 {
+		return StopETA{}, false
+	}
+	e, ok := t.Predict(stopID, limit)
+	if !ok {
+		return e, false
+	}
+	remember := func(dir string, list []BusETA) {
+		for _, b := range list {
+			k := etaKey{bus: b.BusID, stop: stopID, dir: dir}
+			t.pending[k] = append(t.pending[k], etaPrediction{made: e.Time, eta: b.ETA})
+		}
+	}
+	remember("outbound", e.Outbound)
+	remember("inbound", e.Inbound)
+	return e, true
+}
+
+// Arrive scores the displayed predictions of b reaching stopID, which it did at at.
+func (t *ETATracker) Arrive(b *model.Bus, stopID int, at time.Time) {
+	k := etaKey{bus: b.ID, stop: stopID, dir: b.Direction}
+	for _, p := range t.pending[k] {
+		ahead := p.eta.Sub(p.made).Minutes()
+		i := sort.SearchFloat64s(ETAHorizonEdges, ahead)
+		if i == len(ETAHorizonEdges) || ETAHorizonEdges[i] > ahead {
+			i--
+		}
+		if i < 0 {
+			i = 0
+		}
+		t.errs[i] = append(t.errs[i], at.Sub(p.eta).Minutes())
+	}
+	delete(t.pending, k)
+}
+
+// Accuracy returns the prediction errors per horizon bucket (nil before any displayed
+// prediction was scored).
+func (t *ETATracker) Accuracy() []ETAAccuracy {
+	var out []ETAAccuracy
+	for i, errs := range t.errs {
+		if len(errs) == 0 {
+			continue
+		}
+		a := ETAAccuracy{HorizonMin: ETAHorizonEdges[i], Predictions: len(errs)}
+		if i+1 < len(ETAHorizonEdges) {
+			a.HorizonMaxMin = ETAHorizonEdges[i+1]
+		}
+		abs := make([]float64, len(errs))
+		for j, e := range errs {
+			a.MeanErrorMin += e
+			abs[j] = math.Abs(e)
+			a.MAEMin += abs[j]
+		}
+		a.MeanErrorMin /= float64(len(errs))
+		a.MAEMin /= float64(len(errs))
+		sort.Float64s(abs)
+		a.P90AbsErrorMin = Percentile(abs, 90)
+		out = append(out, a)
+	}
+	return out
+}
@@ -1,6 +1,10 @@
 package sim
 
-import "time"
+import (
+	"time"
+
+	"brt08/backend/model"
+)
 
 // Event is a marker for all simulation events emitted by Runner.
 type Event interface{ isEvent() }
@@ -18,6 +22,14 @@ type InitEvent struct {
 
 func (InitEvent) isEvent() {}
 
+// CapacityWarningEvent is emitted after InitEvent when the configured demand exceeds the
+// fleet's theoretical corridor capacity, so growing queues are expected.
+type CapacityWarningEvent struct {
+	Check CapacityCheck
+}
+
+func (CapacityWarningEvent) isEvent() {}
+
 // StopUpdateEvent updates stop queue sizes and counters.
 type StopUpdateEvent struct {
 	StopID            int
@@ -30,6 +42,64 @@ type StopUpdateEvent struct {
 
 func (StopUpdateEvent) isEvent() {}
 
+// StopQueue is the queue size of one stop per direction.
+type StopQueue struct {
+	StopID        int
+	OutboundQueue int
+	InboundQueue  int
+}
+
+// InitialStateEvent carries every stop queue in one event at stream start, instead of
+// a burst of per-stop StopUpdateEvents that can overrun the channel on long routes.
+type InitialStateEvent struct {
+	Time              time.Time
+	Stops             []StopQueue
+	Generated         int
+	OutboundGenerated int
+	InboundGenerated  int
+}
+
+func (InitialStateEvent) isEvent() {}
+
+// BusState is the last known state of one launched bus.
+type BusState struct {
+	BusID     int
+	Direction string
+	Lat       float64
+	Lng       float64
+	From      int
+	To        int
+	T         float64
+	Phase     string
+	Onboard   int
+	Capacity  int
+}
+
+// StateEvent is a full snapshot of a running simulation, emitted on request so a
+// client that missed events can rebuild its view without restarting the stream.
+type StateEvent struct {
+	Time              time.Time
+	Buses             []BusState
+	Stops             []StopQueue
+	Generated         int
+	OutboundGenerated int
+	InboundGenerated  int
+	ServedPassengers  int64
+	AvgWaitMin        float64
+	ClosedStops       []int // stops closed by a StopClosure
+}
+
+func (StateEvent) isEvent() {}
+
+// InitialState snapshots the stop queues and generation counters of s on route.
+func InitialState(route *model.Route, s *Simulator) InitialStateEvent {
+	stops := make([]StopQueue, len(route.Stops))
+	for i, st := range route.Stops {
+		stops[i] = StopQueue{StopID: st.ID, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue)}
+	}
+	return InitialStateEvent{Time: s.Now(), Stops: stops, Generated: s.GeneratedPassengers, OutboundGenerated: s.OutboundGenerated, InboundGenerated: s.InboundGenerated}
+}
+
 // BusAddEvent indicates a bus added to the route at the start.
 type BusAddEvent struct {
 	BusID        int
@@ -55,6 +125,42 @@ type ArriveEvent struct {
 
 func (ArriveEvent) isEvent() {}
 
+// DoorsOpenEvent marks a bus opening its doors at a stop (on arrival).
+type DoorsOpenEvent struct {
+	BusID     int
+	Direction string
+	StopID    int
+	Time      time.Time
+	Onboard   int // passengers aboard before alighting
+}
+
+func (DoorsOpenEvent) isEvent() {}
+
+// DoorsCloseEvent marks the doors closing before departure, with the dwell split into
+// dead time (alighting pause plus door operation, see DwellTime) and passenger service
+// time, so dwell composition can be analyzed from the stream or a recording.
+type DoorsCloseEvent struct {
+	BusID          int
+	Direction      string
+	StopID         int
+	Time           time.Time
+	OpenedAt       time.Time
+	Alighted       int
+	Boarded        int
+	Onboard        int
+	DwellSec       float64 // Time - OpenedAt
+	DeadTimeSec    float64
+	ServiceTimeSec float64
+}
+
+func (DoorsCloseEvent) isEvent() {}
+
+// NewDoorsCloseEvent builds the close event of a visit opened at openedAt.
+func NewDoorsCloseEvent(b *model.Bus, stopID int, openedAt, closedAt time.Time, alighted, boarded int) DoorsCloseEvent {
+	dead, service := DwellTime(boarded, alighted)
+	return DoorsCloseEvent{BusID: b.ID, Direction: b.Direction, StopID: stopID, Time: closedAt, OpenedAt: openedAt, Alighted: alighted, Boarded: boarded, Onboard: b.PassengersOnboard, DwellSec: closedAt.Sub(openedAt).Seconds(), DeadTimeSec: (PreBoardPause + dead).Seconds(), ServiceTimeSec: service.Seconds()}
+}
+
 // AlightEvent indicates alighting.
 type AlightEvent struct {
 	BusID             int
@@ -87,10 +193,53 @@ type BoardEvent struct {
 	InboundGenerated  int
 	ServedPassengers  int64
 	AvgWaitMin        float64
+	StopAvgWaitMin    float64 // mean wait of boardings at StopID within the last StopWaitWindow
+	StopWaitSamples   int     // boardings that mean covers
 }
 
 func (BoardEvent) isEvent() {}
 
+// MetricsEvent is a periodic KPI heartbeat emitted every RunnerOptions.MetricsInterval
+// of simulated time, so dashboards need not rebuild KPIs from board/alight events.
+type MetricsEvent struct {
+	Time             time.Time
+	Generated        int
+	ServedPassengers int64
+	AvgWaitMin       float64
+	WaitSamples      int     // boarded passengers AvgWaitMin covers
+	Waiting          int     // passengers queued at stops
+	Onboard          int     // passengers on buses
+	InSystem         int     // Waiting + Onboard
+	FleetUtilization float64 // Onboard / total fleet capacity (0..1)
+	// Queue aging: how long passengers still waiting have been waiting so far. Unlike
+	// AvgWaitMin (boarded passengers only) this exposes stops that are skipped or saturated.
+	QueueMaxWaitMin float64
+	QueueAvgWaitMin float64
+	OldestStopID    int // stop holding the longest-waiting passenger (0 = none waiting)
+	// Headway adherence: share of stop headways within the tolerance band of the period's
+	// target over the last HeadwayWindow, and how many headways that covers.
+	HeadwayAdherence float64
+	HeadwaySamples   int
+	// Excess wait time so far: mean boarding wait less half the scheduled headway.
+	ExcessWaitMin float64
+}
+
+func (MetricsEvent) isEvent() {}
+
+// AnomalyEvent reports a metric deviating strongly from its recent behaviour (see
+// AnomalyDetector); Kind is one of the Anomaly* constants.
+type AnomalyEvent struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	Message  string    `json:"message"`
+	Value    float64   `json:"value"`              // growth rate (passengers/min), wait (min) or stationary time (min)
+	Baseline float64   `json:"baseline,omitempty"` // the usual growth rate or the wait one window earlier
+	BusID    int       `json:"bus_id,omitempty"`
+	StopID   int       `json:"stop_id,omitempty"`
+}
+
+func (AnomalyEvent) isEvent() {}
+
 // MoveEvent indicates an in-transit update between two stops (optionally for reposition phase).
 type MoveEvent struct {
 	BusID     int
@@ -142,12 +291,38 @@ func (RepositionCompleteEvent) isEvent() {}
 // DoneEvent signals completion and carries summary metrics and per-bus distances.
 type DoneEvent struct {
 	Completed         bool
+	EndedBy           string // what ended the run (see EndReason)
+	Stalled           bool   // run ended by the stall watchdog
+	Diagnostic        string // why the run stalled (empty otherwise)
+	Aborted           string // why the run ended early (cancelled, slow consumer, panic); figures cover the simulated part only
 	Generated         int
 	OutboundGenerated int
 	InboundGenerated  int
 	ServedPassengers  int64
 	AvgWaitMin        float64
 	BusDistance       map[int]float64
+	Passengers        []*model.Passenger // every generated passenger when RunnerOptions.RecordPassengers is set
+	Decisions         []Decision         // dispatch audit trail when RunnerOptions.RecordDecisions is set
+	DwellStats        []StopDwellStats   // per-stop dwell distribution and berth occupancy
+	Wait              WaitDistribution   // boarding wait percentiles and histograms
+	StopStats         []StopStats        // per-stop arrivals, boardings, denied boardings, wait and peak queue
+	Zones             []ZoneStats        // per corridor zone ridership and load (nil when stops carry no zones)
+	Load              LoadProfile        // segment x direction occupancy and the peak load points
+	Headway           []HeadwayAdherence // headway adherence per period against the target headways
+	StopHeadways      []StopHeadway      // observed headway mean, standard deviation and CV per stop and direction
+	Anomalies         []AnomalyEvent     // anomalies detected on the KPI heartbeats
+	Shifts            *ShiftStats        // driver breaks, reliefs and limit violations (nil without shifts)
+	BusStats          []BusStats         // per-bus distance, trips, boardings, load, occupancy and idle time
+	DroppedEvents     int                // MoveEvents dropped under BackpressureDropMoves
+	Closures          []ClosureRecord    // stops closed mid-run and their passenger impact
+	ClosureUnserved   int                // waiting passengers who left because their stop closed
+	Periods           []PeriodStats      // per-period sections of a full-day run (nil = single period)
+	ETAAccuracy       []ETAAccuracy      // displayed arrival predictions against the actual arrivals (nil without RunnerOptions.ETAInterval)
+	Platforms         []PlatformStats    // overflows of the stops with a platform capacity (nil = none)
+	Berths            []BerthStats       // berth use of the stations with limited berths (nil = none)
+	Turnarounds       []TurnaroundStats  // bay use and recovery layovers of both terminals (nil = unlimited bays, no recovery time)
+	Directions        []DirectionStats   // generated, served, wait and passenger-km by direction
+	ExcessWait        ExcessWaitStats    // average wait beyond half the scheduled headway, overall and per stop
 }
 
 func (DoneEvent) isEvent() {}
@@ -0,0 +1,94 @@
+package sim
+
+import (
+	"time"
+
+	"brt08/backend/model"
+)
+
+// ExcessWait is the excess wait time (EWT) of a set of boardings: the mean actual wait
+// less the mean scheduled wait, half the target headway in force when each rider
+// arrived (what riders arriving at random wait when buses keep to the headway). It is
+// the wait that irregular service adds; negative when buses ran more often than scheduled.
+type ExcessWait struct {
+	StopID           int     `json:"stop_id,omitempty"`
+	Name             string  `json:"name,omitempty"`
+	Boardings        int     `json:"boardings"`
+	AvgWaitMin       float64 `json:"avg_wait_min"`
+	ScheduledWaitMin float64 `json:"scheduled_wait_min"`
+	ExcessWaitMin    float64 `json:"excess_wait_min"`
+}
+
+// ExcessWaitStats is the excess wait time of a run overall and per boarding stop.
+type ExcessWaitStats struct {
+	Overall ExcessWait   `json:"overall"`
+	ByStop  []ExcessWait `json:"by_stop"` // stops with boardings, in route order
+}
+
+type excessWaitAcc struct {
+	n           int
+	wait, sched float64
+}
+
+func (a *excessWaitAcc) add(waitMin, schedMin float64) {
+	a.n++
+	a.wait += waitMin
+	a.sched += schedMin
+}
+
+func (a excessWaitAcc) summary() ExcessWait {
+	if a.n == 0 {
+		return ExcessWait{}
+	}
+	n := float64(a.n)
+	e := ExcessWait{Boardings: a.n, AvgWaitMin: a.wait / n, ScheduledWaitMin: a.sched / n}
+	e.ExcessWaitMin = e.AvgWaitMin - e.ScheduledWaitMin
+	return e
+}
+
+// ExcessWaitRecorder accumulates EWT overall and per boarding stop against the
+// headway targets of a HeadwayRecorder. Caller must ensure synchronization.
+type ExcessWaitRecorder struct {
+	headway *HeadwayRecorder
+	all     excessWaitAcc
+	stops   map[int]*excessWaitAcc
+}
+
+// NewExcessWaitRecorder returns an empty recorder scheduled by h's targets.
+func NewExcessWaitRecorder(h *HeadwayRecorder) *ExcessWaitRecorder {
+	return &ExcessWaitRecorder{headway: h, stops: make(map[int]*excessWaitAcc)}
+}
+
+// Board records a passenger who arrived at stopID at simulated time arrived boarding
+// after waitMin minutes; the scheduled wait is that of the headway in force on arrival,
+// so a wait across a period boundary is scored against the period it started in.
+func (r *ExcessWaitRecorder) Board(stopID int, arrived time.Time, waitMin float64) {
+	sched := r.headway.ScheduledWait(arrived)
+	r.all.add(waitMin, sched)
+	a := r.stops[stopID]
+	if a == nil {
+		a = &excessWaitAcc{}
+		r.stops[stopID] = a
+	}
+	a.add(waitMin, sched)
+}
+
+// Current returns the overall EWT so far in minutes (0 before any boarding).
+func (r *ExcessWaitRecorder) Current() float64 {
+	return r.all.summary().ExcessWaitMin
+}
+
+// Stats returns the overall EWT and that of each stop with boardings, in route order.
+func (r *ExcessWaitRecorder) Stats(route *model.Route) ExcessWaitStats {
+	out := ExcessWaitStats{Overall: r.all.summary()}
+	for _, st := range route.Stops {
+		a, ok := r.stops[st.ID]
+		if !ok {
+			continue
+		}
+		e := a.summary()
+		e.StopID, e.Name = st.ID, st.Name
+		out.ByStop = append(out.ByStop, e)
+	}
+	return out
+}
@@ -0,0 +1,42 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"brt08/backend/internal/simtest"
+)
+
+func TestExcessWaitAgainstHeadwayOnArrival(t *testing.T) {
+	route, _ := simtest.Corridor(t)
+	// 3 min target in the morning peak (to 09:00), 6 min in the late morning
+	h := NewHeadwayRecorder(HeadwayConfig{Targets: map[int]float64{2: 3, 3: 6}}, 2, testStart)
+	r := NewExcessWaitRecorder(h)
+	if r.Current() != 0 {
+		t.Errorf("EWT before any boarding = %v, want 0", r.Current())
+	}
+	a, b := route.Stops[0].ID, route.Stops[1].ID
+	r.Board(a, testStart.Add(time.Hour), 4)                // scheduled 1.5
+	r.Board(a, testStart.Add(2*time.Hour), 2)              // scheduled 1.5
+	r.Board(b, testStart.Add(3*time.Hour-time.Minute), 10) // arrived 08:59: still the peak's 1.5
+	r.Board(b, testStart.Add(3*time.Hour+time.Minute), 5)  // late morning: 3
+
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+	st := r.Stats(route)
+	if o := st.Overall; o.Boardings != 4 || !near(o.AvgWaitMin, 5.25) || !near(o.ScheduledWaitMin, 1.875) || !near(o.ExcessWaitMin, 3.375) {
+		t.Errorf("overall = %+v", o)
+	}
+	if !near(r.Current(), 3.375) {
+		t.Errorf("current EWT = %v, want 3.375", r.Current())
+	}
+	if len(st.ByStop) != 2 || st.ByStop[0].StopID != a || st.ByStop[1].StopID != b {
+		t.Fatalf("by stop = %+v, want stops %d and %d in route order", st.ByStop, a, b)
+	}
+	if s := st.ByStop[0]; !near(s.ExcessWaitMin, 1.5) || s.Name != route.Stops[0].Name {
+		t.Errorf("stop %d = %+v", a, s)
+	}
+	if s := st.ByStop[1]; !near(s.ScheduledWaitMin, 2.25) || !near(s.ExcessWaitMin, 5.25) {
+		t.Errorf("stop %d = %+v", b, s)
+	}
+}
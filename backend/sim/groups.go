@@ -0,0 +1,90 @@
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// GroupSizeDist is a discrete distribution of group sizes for compound Poisson arrivals.
+// The zero value always yields groups of one (plain Poisson arrivals).
+type GroupSizeDist struct {
+	Sizes []int
+	cum   []float64 // cumulative probabilities aligned with Sizes
+}
+
+// ParseGroupSizes parses "size:weight" pairs such as "1:0.7,2:0.2,4:0.1".
+// Weights are normalized, so "1:7,2:2,4:1" is equivalent. An empty spec yields singles.
+func ParseGroupSizes(spec string) (GroupSizeDist, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return GroupSizeDist{}, nil
+	}
+	weights := make(map[int]float64)
+	for _, part := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(kv) != 2 {
+			return GroupSizeDist{}, fmt.Errorf("group sizes: %q is not size:weight", part)
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil || size < 1 {
+			return GroupSizeDist{}, fmt.Errorf("group sizes: invalid size %q", kv[0])
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || w < 0 {
+			return GroupSizeDist{}, fmt.Errorf("group sizes: invalid weight %q", kv[1])
+		}
+		weights[size] += w
+	}
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return GroupSizeDist{}, fmt.Errorf("group sizes: weights sum to zero")
+	}
+	var g GroupSizeDist
+	for size := range weights {
+		g.Sizes = append(g.Sizes, size)
+	}
+	sort.Ints(g.Sizes)
+	cum := 0.0
+	for _, size := range g.Sizes {
+		cum += weights[size] / total
+		g.cum = append(g.cum, cum)
+	}
+	return g, nil
+}
+
+// Sample draws a group size (always >= 1).
+func (g GroupSizeDist) Sample(rng *rand.Rand) int {
+	if len(g.Sizes) == 0 {
+		return 1
+	}
+	r := rng.Float64()
+	for i, c := range g.cum {
+		if r <= c {
+			return g.Sizes[i]
+		}
+	}
+	return g.Sizes[len(g.Sizes)-1]
+}
+
+// Mean returns the expected group size; arrival event rates are divided by it so
+// enabling groups reshapes arrivals without changing the expected passenger volume.
+func (g GroupSizeDist) Mean() float64 {
+	if len(g.Sizes) == 0 {
+		return 1
+	}
+	mean, prev := 0.0, 0.0
+	for i, size := range g.Sizes {
+		mean += float64(size) * (g.cum[i] - prev)
+		prev = g.cum[i]
+	}
+	if mean < 1 {
+		return 1
+	}
+	return mean
+}
@@ -0,0 +1,254 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"brt08/backend/data"
+	"brt08/backend/model"
+)
+
+// DefaultHeadwayTolerance is the adherence band as a fraction of the target headway:
+// an observed headway within ±25% of target counts as adherent.
+const DefaultHeadwayTolerance = 0.25
+
+// HeadwayWindow is the span of the rolling adherence reported with each metrics event.
+const HeadwayWindow = 30 * time.Minute
+
+// HeadwayConfig sets the targets headway adherence is measured against.
+type HeadwayConfig struct {
+	Targets   map[int]float64 // period id -> target headway minutes; missing periods use data.TargetHeadwayMin
+	Tolerance float64         // band as a fraction of target (0 = DefaultHeadwayTolerance)
+}
+
+// ParseHeadwayTargets parses "period:minutes" pairs such as "2:4,5:4". An empty spec
+// yields nil (the per-period defaults).
+func ParseHeadwayTargets(spec string) (map[int]float64, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	out := make(map[int]float64)
+	for _, part := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("headway targets: %q is not period:minutes", part)
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("headway targets: invalid period %q", kv[0])
+		}
+		m, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || m <= 0 {
+			return nil, fmt.Errorf("headway targets: invalid minutes %q", kv[1])
+		}
+		out[id] = m
+	}
+	return out, nil
+}
+
+// HeadwayAdherence summarizes observed stop headways in one period.
+type HeadwayAdherence struct {
+	PeriodID  int     `json:"period_id"`
+	Period    string  `json:"period"`
+	TargetMin float64 `json:"target_min"`
+	Tolerance float64 `json:"tolerance"` // band as a fraction of target
+	Headways  int     `json:"headways"`  // observed stop arrivals with a previous bus in the same direction
+	Within    int     `json:"within"`
+	Bunched   int     `json:"bunched"`   // shorter than the band
+	Gapped    int     `json:"gapped"`    // longer than the band
+	Adherence float64 `json:"adherence"` // Within / Headways (0..1)
+	MeanMin   float64 `json:"mean_min"`
+}
+
+type headwayKey struct {
+	stopID int
+	dir    string
+}
+
+type headwayMoments struct {
+	n          int
+	sum, sumSq float64
+}
+
+type headwaySample struct {
+	at     time.Time
+	within bool
+}
+
+// HeadwayRecorder measures the time between consecutive buses arriving at each stop in
+// each direction against the target of the period in effect. The run starts at the
+// beginning of the selected period, as with the period demand profile. Caller must
+// ensure synchronization.
+type HeadwayRecorder struct {
+	cfg      HeadwayConfig
+	start    time.Time
+	startMin float64
+	periodID int
+	last     map[headwayKey]time.Time
+	stops    map[headwayKey]*headwayMoments
+	per      map[int]*HeadwayAdherence
+	sum      map[int]float64
+	order    []int
+	recent   []headwaySample
+}
+
+// NewHeadwayRecorder returns an empty recorder for a run of periodID starting at start;
+// headways are assigned to periods by start's clock time.
+func NewHeadwayRecorder(cfg HeadwayConfig, periodID int, start time.Time) *HeadwayRecorder {
+	if cfg.Tolerance <= 0 {
+		cfg.Tolerance = DefaultHeadwayTolerance
+	}
+	return &HeadwayRecorder{cfg: cfg, start: start, startMin: clockMinutes(start), periodID: periodID, last: make(map[headwayKey]time.Time), stops: make(map[headwayKey]*headwayMoments), per: make(map[int]*HeadwayAdherence), sum: make(map[int]float64)}
+}
+
+// period returns the period in effect at simulated time at (the selected one outside
+// every period window).
+func (r *HeadwayRecorder) period(at time.Time) (int, string) {
+	clock := math.Mod(r.startMin+at.Sub(r.start).Minutes(), 24*60)
+	name := ""
+	for _, p := range data.TimePeriods {
+		if clock >= float64(p.StartMin) && clock < float64(p.EndMin) {
+			return p.ID, p.Name
+		}
+		if p.ID == r.periodID {
+			name = p.Name
+		}
+	}
+	return r.periodID, name
+}
+
+func (r *HeadwayRecorder) target(periodID int) float64 {
+	if t, ok := r.cfg.Targets[periodID]; ok {
+		return t
+	}
+	return data.TargetHeadwayMin[periodID]
+}
+
+// ScheduledWait returns half the target headway in force at simulated time at, in
+// minutes: the mean wait of riders arriving at random while buses keep to it.
+func (r *HeadwayRecorder) ScheduledWait(at time.Time) float64 {
+	pid, _ := r.period(at)
+	return r.target(pid) / 2
+}
+
+// Arrive records a bus arriving at stopID in direction dir at simulated time at.
+func (r *HeadwayRecorder) Arrive(stopID int, dir string, at time.Time) {
+	k := headwayKey{stopID, dir}
+	prev, seen := r.last[k]
+	if !seen || at.After(prev) {
+		r.last[k] = at
+	}
+	if !seen {
+		return
+	}
+	// buses in the stream driver keep their own clocks, so arrivals can be recorded
+	// slightly out of order
+	h := math.Abs(at.Sub(prev).Minutes())
+	m, ok := r.stops[k]
+	if !ok {
+		m = &headwayMoments{}
+		r.stops[k] = m
+	}
+	m.n++
+	m.sum += h
+	m.sumSq += h * h
+	pid, name := r.period(at)
+	target := r.target(pid)
+	if target <= 0 {
+		return
+	}
+	a, ok := r.per[pid]
+	if !ok {
+		a = &HeadwayAdherence{PeriodID: pid, Period: name, TargetMin: target, Tolerance: r.cfg.Tolerance}
+		r.per[pid] = a
+		r.order = append(r.order, pid)
+	}
+	a.Headways++
+	r.sum[pid] += h
+	within := false
+	switch {
+	case h < target*(1-r.cfg.Tolerance):
+		a.Bunched++
+	case h > target*(1+r.cfg.Tolerance):
+		a.Gapped++
+	default:
+		a.Within++
+		within = true
+	}
+	r.recent = append(r.recent, headwaySample{at: at, within: within})
+}
+
+// Rolling returns the adherence share over the HeadwayWindow before at and the number
+// of headways it covers (0 when none were observed).
+func (r *HeadwayRecorder) Rolling(at time.Time) (float64, int) {
+	cutoff := at.Add(-HeadwayWindow)
+	i := 0
+	for i < len(r.recent) && r.recent[i].at.Before(cutoff) {
+		i++
+	}
+	r.recent = r.recent[i:]
+	within := 0
+	for _, s := range r.recent {
+		if s.within {
+			within++
+		}
+	}
+	if len(r.recent) == 0 {
+		return 0, 0
+	}
+	return float64(within) / float64(len(r.recent)), len(r.recent)
+}
+
+// Stats returns one summary per period observed, in order of first observation.
+func (r *HeadwayRecorder) Stats() []HeadwayAdherence {
+	out := make([]HeadwayAdherence, 0, len(r.order))
+	for _, pid := range r.order {
+		a := *r.per[pid]
+		if a.Headways > 0 {
+			a.Adherence = float64(a.Within) / float64(a.Headways)
+			a.MeanMin = r.sum[pid] / float64(a.Headways)
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+// StopHeadway is the distribution of observed headways at one stop in one direction.
+// The coefficient of variation (StdDev / Mean) is the usual regularity measure: near 0
+// for even spacing, near 1 or above when buses bunch.
+type StopHeadway struct {
+	StopID    int     `json:"stop_id"`
+	Name      string  `json:"stop_name"`
+	Direction string  `json:"direction"`
+	Count     int     `json:"headways"`
+	MeanMin   float64 `json:"mean_min"`
+	StdDevMin float64 `json:"stddev_min"`
+	CV        float64 `json:"cv"`
+}
+
+// ByStop returns the headway statistics per stop and direction in route order
+// (outbound first), skipping stop-directions without an observed headway.
+func (r *HeadwayRecorder) ByStop(route *model.Route) []StopHeadway {
+	var out []StopHeadway
+	for _, dir := range []string{"outbound", "inbound"} {
+		for _, st := range route.Stops {
+			m, ok := r.stops[headwayKey{st.ID, dir}]
+			if !ok || m.n == 0 {
+				continue
+			}
+			s := StopHeadway{StopID: st.ID, Name: st.Name, Direction: dir, Count: m.n, MeanMin: m.sum / float64(m.n)}
+			if v := m.sumSq/float64(m.n) - s.MeanMin*s.MeanMin; v > 0 {
+				s.StdDevMin = math.Sqrt(v)
+			}
+			if s.MeanMin > 0 {
+				s.CV = s.StdDevMin / s.MeanMin
+			}
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package sim
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// MaxInjection is the most passengers one injection enqueues.
+const MaxInjection = 1000
+
+// PassengerInjection asks a run to enqueue Count passengers at a stop, as if they had
+// just arrived there.
+type PassengerInjection struct {
+	StopID     int
+	Direction  string // "outbound" or "inbound" ("" = toward DestStopID, or either way)
+	DestStopID int    // destination of every passenger (0 = drawn uniformly ahead of the stop)
+	Count      int
+}
+
+// Validate checks the injection against route: the stop and destination must be on it,
+// with the destination ahead of the stop in the direction of travel.
+func (inj PassengerInjection) Validate(route *model.Route) error {
+	if inj.Count < 1 || inj.Count > MaxInjection {
+		return fmt.Errorf("count must be in [1, %d]", MaxInjection)
+	}
+	idx := route.IndexOf(inj.StopID)
+	if idx < 0 {
+		return fmt.Errorf("stop_id %d not on the route", inj.StopID)
+	}
+	last := len(route.Stops) - 1
+	switch inj.Direction {
+	case "":
+	case "outbound":
+		if idx == last {
+			return fmt.Errorf("stop %d is the outbound terminus", inj.StopID)
+		}
+	case "inbound":
+		if idx == 0 {
+			return fmt.Errorf("stop %d is the inbound terminus", inj.StopID)
+		}
+	default:
+		return fmt.Errorf("direction must be outbound or inbound")
+	}
+	if inj.DestStopID == 0 {
+		return nil
+	}
+	dest := route.IndexOf(inj.DestStopID)
+	switch {
+	case dest < 0:
+		return fmt.Errorf("dest_stop_id %d not on the route", inj.DestStopID)
+	case dest == idx:
+		return fmt.Errorf("dest_stop_id is the stop itself")
+	case inj.Direction == "outbound" && dest < idx, inj.Direction == "inbound" && dest > idx:
+		return fmt.Errorf("dest_stop_id %d is behind stop %d for %s passengers", inj.DestStopID, inj.StopID, inj.Direction)
+	}
+	return nil
+}
+
+// InjectEvent reports passengers injected at a stop.
+type InjectEvent struct {
+	Time     time.Time `json:"time"`
+	StopID   int       `json:"stop_id"`
+	Outbound int       `json:"outbound"`
+	Inbound  int       `json:"inbound"`
+	DestID   int       `json:"dest_stop_id,omitempty"` // 0 = drawn per passenger
+}
+
+func (InjectEvent) isEvent() {}
+
+// InjectPassengers enqueues the passengers of a validated injection at now, drawing
+// directions and destinations left open from rng. They count as generated, but not
+// toward a passenger cap already reached: the injection is an addition to the
+// configured demand.
+func InjectPassengers(engine *Simulator, route *model.Route, inj PassengerInjection, rng *rand.Rand, now time.Time) InjectEvent {
+	ev := InjectEvent{Time: now, StopID: inj.StopID, DestID: inj.DestStopID}
+	st := route.GetStop(inj.StopID)
+	idx, last := route.IndexOf(inj.StopID), len(route.Stops)-1
+	for i := 0; i < inj.Count; i++ {
+		dir := inj.Direction
+		if dir == "" {
+			switch {
+			case inj.DestStopID != 0 && route.IndexOf(inj.DestStopID) < idx, idx == last:
+				dir = "inbound"
+			case inj.DestStopID != 0, idx == 0, rng.Intn(2) == 0:
+				dir = "outbound"
+			default:
+				dir = "inbound"
+			}
+		}
+		dest := inj.DestStopID
+		if dest == 0 {
+			if dir == "outbound" {
+				dest = route.Stops[idx+1+rng.Intn(last-idx)].ID
+			} else {
+				dest = route.Stops[rng.Intn(idx)].ID
+			}
+		}
+		p := engine.NewPassengerPublic(st.ID, dest, now)
+		p.Direction = dir
+		st.EnqueuePassenger(p, dir, now)
+		engine.noteArrival(st)
+		engine.GeneratedPassengers++
+		if dir == "outbound" {
+			engine.OutboundGenerated++
+			ev.Outbound++
+		} else {
+			engine.InboundGenerated++
+			ev.Inbound++
+		}
+	}
+	return ev
+}
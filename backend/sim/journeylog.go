@@ -0,0 +1,106 @@
+package sim
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+)
+
+// journeyRecord is one row of the passenger journey log.
+type journeyRecord struct {
+	PassengerID  int        `json:"passenger_id"`
+	Direction    string     `json:"direction"`
+	OriginStopID int        `json:"origin_stop_id"`
+	DestStopID   int        `json:"dest_stop_id"`
+	BusID        int        `json:"bus_id,omitempty"`
+	ArrivalTime  time.Time  `json:"arrival_time"`
+	BoardingTime *time.Time `json:"boarding_time,omitempty"`
+	AlightTime   *time.Time `json:"alighting_time,omitempty"`
+	WaitMin      *float64   `json:"wait_min,omitempty"`
+	RideMin      *float64   `json:"ride_min,omitempty"`
+	Status       string     `json:"status"`              // waiting | onboard | completed | unserved
+	PersonID     int        `json:"person_id,omitempty"` // synthetic population member (activity-based demand)
+}
+
+func newJourneyRecord(p *model.Passenger) journeyRecord {
+	r := journeyRecord{PassengerID: p.ID, Direction: p.Direction, OriginStopID: p.StartStopID, DestStopID: p.EndStopID, BusID: p.BusID, ArrivalTime: p.ArrivalStopTime, BoardingTime: p.BoardingTime, AlightTime: p.ArrivalDestTime, WaitMin: p.WaitDuration, Status: "waiting", PersonID: p.PersonID}
+	if p.BoardingTime != nil {
+		r.Status = "onboard"
+	}
+	if p.BoardingTime != nil && p.ArrivalDestTime != nil {
+		ride := p.ArrivalDestTime.Sub(*p.BoardingTime).Minutes()
+		r.RideMin = &ride
+		r.Status = "completed"
+	}
+	if p.Unserved != "" {
+		r.Status = "unserved"
+	}
+	return r
+}
+
+// WritePassengerLog writes one record per passenger to the given path or directory.
+// The format follows the extension: .jsonl/.ndjson writes JSON Lines, anything else CSV.
+// Like WriteCSVReport, a timestamp is suffixed (or a timestamped file created in a directory).
+func WritePassengerLog(logPath string, passengers []*model.Passenger) (string, error) {
+	if logPath == "" {
+		return "", nil
+	}
+	ts := time.Now().Format("20060102-150405")
+	outPath := timestampedPath(logPath, "passengers", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	ext := strings.ToLower(filepath.Ext(outPath))
+	if ext == ".jsonl" || ext == ".ndjson" {
+		enc := json.NewEncoder(w)
+		for _, p := range passengers {
+			if err := enc.Encode(newJourneyRecord(p)); err != nil {
+				return "", err
+			}
+		}
+	} else {
+		fmtTime := func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			return t.Format(time.RFC3339Nano)
+		}
+		fmtMin := func(v *float64) string {
+			if v == nil {
+				return ""
+			}
+			return fmt.Sprintf("%.3f", *v)
+		}
+		fmt.Fprintln(w, "passenger_id,direction,origin_stop_id,dest_stop_id,bus_id,arrival_time,boarding_time,alighting_time,wait_min,ride_min,status,person_id")
+		for _, p := range passengers {
+			r := newJourneyRecord(p)
+			busID := ""
+			if r.BusID != 0 {
+				busID = fmt.Sprint(r.BusID)
+			}
+			personID := ""
+			if r.PersonID != 0 {
+				personID = fmt.Sprint(r.PersonID)
+			}
+			fmt.Fprintf(w, "%d,%s,%d,%d,%s,%s,%s,%s,%s,%s,%s,%s\n", r.PassengerID, r.Direction, r.OriginStopID, r.DestStopID, busID, r.ArrivalTime.Format(time.RFC3339Nano), fmtTime(r.BoardingTime), fmtTime(r.AlightTime), fmtMin(r.WaitMin), fmtMin(r.RideMin), r.Status, personID)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("passenger log written", "path", outPath, "passengers", len(passengers))
+	return outPath, nil
+}
@@ -0,0 +1,65 @@
+package sim
+
+import "sync"
+
+// LiveStats lets HTTP handlers read aggregates of a running simulation. The runner
+// binds snapshot functions that take its engine lock; all methods are safe for
+// concurrent use.
+type LiveStats struct {
+	mu       sync.Mutex
+	stops    func() []StopStats
+	buses    func() []BusStats
+	headways func() []StopHeadway
+	eta      func(stopID, limit int) (StopETA, bool)
+}
+
+func (l *LiveStats) bind(stops func() []StopStats, buses func() []BusStats, headways func() []StopHeadway, eta func(stopID, limit int) (StopETA, bool)) {
+	l.mu.Lock()
+	l.stops, l.buses, l.headways, l.eta = stops, buses, headways, eta
+	l.mu.Unlock()
+}
+
+// Stops returns the current per-stop aggregates, or nil before a run is bound.
+func (l *LiveStats) Stops() []StopStats {
+	l.mu.Lock()
+	f := l.stops
+	l.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	return f()
+}
+
+// Buses returns the current per-bus metrics, or nil before a run is bound.
+func (l *LiveStats) Buses() []BusStats {
+	l.mu.Lock()
+	f := l.buses
+	l.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	return f()
+}
+
+// Headways returns the current per-stop headway statistics, or nil before a run is bound.
+func (l *LiveStats) Headways() []StopHeadway {
+	l.mu.Lock()
+	f := l.headways
+	l.mu.Unlock()
+	if f == nil {
+		return nil
+	}
+	return f()
+}
+
+// ETA predicts the next limit buses (0 = all) due at stopID in each direction; false
+// before a run is bound or when the stop is not on its route.
+func (l *LiveStats) ETA(stopID, limit int) (StopETA, bool) {
+	l.mu.Lock()
+	f := l.eta
+	l.mu.Unlock()
+	if f == nil {
+		return StopETA{}, false
+	}
+	return f(stopID, limit)
+}
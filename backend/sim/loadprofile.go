@@ -0,0 +1,180 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+)
+
+// SegmentLoad is the occupancy of one direction of an inter-stop segment: every bus
+// leaving FromStopID toward ToStopID contributes its onboard load.
+type SegmentLoad struct {
+	Direction    string  `json:"direction"`
+	FromStopID   int     `json:"from_stop_id"`
+	FromName     string  `json:"from_stop_name"`
+	ToStopID     int     `json:"to_stop_id"`
+	ToName       string  `json:"to_stop_name"`
+	Departures   int     `json:"departures"`
+	Carried      int     `json:"carried"` // passengers carried over the segment (sum of loads)
+	AvgLoad      float64 `json:"avg_load"`
+	MaxLoad      int     `json:"max_load"`
+	AvgOccupancy float64 `json:"avg_occupancy"` // load / capacity over the departures (0..1)
+}
+
+// LoadProfile is the segment × direction occupancy matrix of a run. Segment i joins
+// route stops i and i+1; Peak holds each direction's peak load point (the segment
+// carrying the most passengers), outbound first.
+type LoadProfile struct {
+	Outbound []SegmentLoad `json:"outbound"`
+	Inbound  []SegmentLoad `json:"inbound"`
+	Peak     []SegmentLoad `json:"peak,omitempty"`
+}
+
+type segmentAcc struct {
+	deps, load, cap, max int
+}
+
+// LoadRecorder accumulates the load of every bus departure by segment and direction.
+// Caller must ensure synchronization.
+type LoadRecorder struct {
+	route *model.Route
+	index map[int]int
+	out   []segmentAcc
+	in    []segmentAcc
+}
+
+// NewLoadRecorder returns an empty recorder for route.
+func NewLoadRecorder(route *model.Route) *LoadRecorder {
+	n := len(route.Stops) - 1
+	if n < 0 {
+		n = 0
+	}
+	r := &LoadRecorder{route: route, index: make(map[int]int, len(route.Stops)), out: make([]segmentAcc, n), in: make([]segmentAcc, n)}
+	for i, st := range route.Stops {
+		r.index[st.ID] = i
+	}
+	return r
+}
+
+// Depart records bus b leaving stopID in its current direction with its current load.
+// Departures from the last stop in the direction of travel are ignored.
+func (r *LoadRecorder) Depart(stopID int, b *model.Bus) {
+	i, ok := r.index[stopID]
+	if !ok {
+		return
+	}
+	acc := r.out
+	if b.Direction == "inbound" {
+		acc, i = r.in, i-1
+	}
+	if i < 0 || i >= len(acc) {
+		return
+	}
+	a := &acc[i]
+	a.deps++
+	a.load += b.PassengersOnboard
+	if b.Type != nil {
+		a.cap += b.Type.Capacity
+	}
+	if b.PassengersOnboard > a.max {
+		a.max = b.PassengersOnboard
+	}
+}
+
+// Profile returns the recorded matrix with each direction's peak load point.
+func (r *LoadRecorder) Profile() LoadProfile {
+	var p LoadProfile
+	build := func(dir string, acc []segmentAcc) []SegmentLoad {
+		rows := make([]SegmentLoad, len(acc))
+		peak := -1
+		for i, a := range acc {
+			from, to := r.route.Stops[i], r.route.Stops[i+1]
+			if dir == "inbound" {
+				from, to = to, from
+			}
+			s := SegmentLoad{Direction: dir, FromStopID: from.ID, FromName: from.Name, ToStopID: to.ID, ToName: to.Name, Departures: a.deps, Carried: a.load, MaxLoad: a.max}
+			if a.deps > 0 {
+				s.AvgLoad = float64(a.load) / float64(a.deps)
+			}
+			if a.cap > 0 {
+				s.AvgOccupancy = float64(a.load) / float64(a.cap)
+			}
+			rows[i] = s
+			if a.load > 0 && (peak < 0 || a.load > acc[peak].load) {
+				peak = i
+			}
+		}
+		if peak >= 0 {
+			p.Peak = append(p.Peak, rows[peak])
+		}
+		return rows
+	}
+	p.Outbound = build("outbound", r.out)
+	p.Inbound = build("inbound", r.in)
+	return p
+}
+
+// WriteLoadReport writes the occupancy heatmap as CSV: one row per segment (in route
+// order) with the outbound and inbound load side by side and the peak direction(s),
+// followed by a "peak" row per direction. Returns the file written.
+func WriteLoadReport(reportPath string, p LoadProfile) (string, error) {
+	if reportPath == "" {
+		return "", nil
+	}
+	ts := time.Now().Format("20060102-150405")
+	outPath := timestampedPath(reportPath, "load", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "section,segment,from_stop_id,from_stop_name,to_stop_id,to_stop_name,outbound_departures,outbound_carried,outbound_avg_load,outbound_max_load,outbound_occupancy,inbound_departures,inbound_carried,inbound_avg_load,inbound_max_load,inbound_occupancy,peak")
+	isPeak := func(s SegmentLoad) bool {
+		for _, pk := range p.Peak {
+			if pk.Direction == s.Direction && pk.FromStopID == s.FromStopID {
+				return true
+			}
+		}
+		return false
+	}
+	for i := range p.Outbound {
+		o := p.Outbound[i]
+		var in SegmentLoad
+		if i < len(p.Inbound) {
+			in = p.Inbound[i]
+		}
+		peak := ""
+		if isPeak(o) {
+			peak = "outbound"
+		}
+		if in.Departures > 0 && isPeak(in) {
+			if peak != "" {
+				peak += "+"
+			}
+			peak += "inbound"
+		}
+		fmt.Fprintf(w, "segment,%d,%d,%s,%d,%s,%d,%d,%.2f,%d,%.4f,%d,%d,%.2f,%d,%.4f,%s\n", i+1, o.FromStopID, csvQuote(o.FromName), o.ToStopID, csvQuote(o.ToName),
+			o.Departures, o.Carried, o.AvgLoad, o.MaxLoad, o.AvgOccupancy, in.Departures, in.Carried, in.AvgLoad, in.MaxLoad, in.AvgOccupancy, peak)
+	}
+	for _, pk := range p.Peak {
+		// peak rows name the stops in the direction of travel
+		if pk.Direction == "inbound" {
+			fmt.Fprintf(w, "peak,,%d,%s,%d,%s,,,,,,%d,%d,%.2f,%d,%.4f,inbound\n", pk.FromStopID, csvQuote(pk.FromName), pk.ToStopID, csvQuote(pk.ToName), pk.Departures, pk.Carried, pk.AvgLoad, pk.MaxLoad, pk.AvgOccupancy)
+			continue
+		}
+		fmt.Fprintf(w, "peak,,%d,%s,%d,%s,%d,%d,%.2f,%d,%.4f,,,,,,outbound\n", pk.FromStopID, csvQuote(pk.FromName), pk.ToStopID, csvQuote(pk.ToName), pk.Departures, pk.Carried, pk.AvgLoad, pk.MaxLoad, pk.AvgOccupancy)
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("load report written", "path", outPath)
+	return outPath, nil
+}
@@ -0,0 +1,171 @@
+package sim
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"brt08/backend/model"
+)
+
+// FleetEntry is one bus type of a run's fleet composition.
+type FleetEntry struct {
+	TypeID    int              `json:"type_id"`
+	Type      string           `json:"type"`
+	Count     int              `json:"count"`
+	Capacity  int              `json:"capacity"`
+	CostPerKm float64          `json:"cost_per_km"`
+	PerHour   float64          `json:"cost_per_hour,omitempty"`
+	PerDay    float64          `json:"fixed_cost_per_day,omitempty"`
+	Speed     *model.SpeedDist `json:"speed,omitempty"` // distribution the bus speeds were drawn from
+}
+
+// FleetComposition counts buses per type, in order of first appearance.
+func FleetComposition(buses []*model.Bus) []FleetEntry {
+	var out []FleetEntry
+	idx := make(map[int]int)
+	for _, b := range buses {
+		if b.Type == nil {
+			continue
+		}
+		i, ok := idx[b.Type.ID]
+		if !ok {
+			i = len(out)
+			idx[b.Type.ID] = i
+			out = append(out, FleetEntry{TypeID: b.Type.ID, Type: b.Type.Name, Capacity: b.Type.Capacity, CostPerKm: b.Type.CostPerKm, PerHour: b.Type.CostPerHour, PerDay: b.Type.FixedCostPerDay, Speed: b.Type.Speed})
+			if e := &out[i]; e.Speed == nil && b.Type.Params != nil {
+				e.Speed = b.Type.Params.Speed
+			}
+		}
+		out[i].Count++
+	}
+	return out
+}
+
+// EngineVersion is the simulation engine release. Bump it when a change alters the
+// results of a run for the same inputs.
+const EngineVersion = "0.9.0"
+
+// EventSchemaVersion is the version of the event payloads (the SSE events and the
+// DoneEvent fields reports are built from); bump it on incompatible changes.
+const EventSchemaVersion = 1
+
+// SupportedEventSchemas lists the event schema versions the engine can emit.
+var SupportedEventSchemas = []int{EventSchemaVersion}
+
+// buildDate is set at link time, e.g.
+// -ldflags "-X brt08/backend/sim.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)".
+var buildDate string
+
+// BuildInfo identifies the engine that produced a run.
+type BuildInfo struct {
+	EngineVersion string   `json:"engine_version"`
+	Version       string   `json:"build_version"` // see BuildVersion
+	Commit        string   `json:"git_commit,omitempty"`
+	CommitTime    string   `json:"commit_time,omitempty"`
+	Dirty         bool     `json:"dirty,omitempty"`
+	BuildDate     string   `json:"build_date,omitempty"` // link-time date, else empty
+	GoVersion     string   `json:"go_version"`
+	EventSchemas  []int    `json:"event_schemas"`
+	Features      []string `json:"features,omitempty"` // filled in by the caller for its configuration
+}
+
+// ReadBuildInfo returns the version information embedded in the binary.
+func ReadBuildInfo() BuildInfo {
+	bi := BuildInfo{EngineVersion: EngineVersion, Version: BuildVersion(), BuildDate: buildDate, GoVersion: runtime.Version(), EventSchemas: append([]int(nil), SupportedEventSchemas...)}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				bi.Commit = s.Value
+			case "vcs.time":
+				bi.CommitTime = s.Value
+			case "vcs.modified":
+				bi.Dirty = s.Value == "true"
+			}
+		}
+	}
+	return bi
+}
+
+// BuildVersion identifies the binary git-style: the VCS revision (12 characters,
+// "-dirty" when built from a modified tree), else the module version, else "devel".
+func BuildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+	rev, dirty := "", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if rev == "" {
+		if v := info.Main.Version; v != "" && v != "(devel)" {
+			return v
+		}
+		return "devel"
+	}
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if dirty {
+		rev += "-dirty"
+	}
+	return rev
+}
+
+// RunMetadata completes a run's option set with the fleet composition, build and
+// engine version and event schema, so reports record everything needed to reproduce
+// them. params is not modified.
+func RunMetadata(params map[string]any, buses []*model.Bus) map[string]any {
+	out := make(map[string]any, len(params)+5)
+	for k, v := range params {
+		out[k] = v
+	}
+	out["buses"] = len(buses)
+	out["fleet"] = FleetComposition(buses)
+	out["build_version"] = BuildVersion()
+	out["engine_version"] = EngineVersion
+	out["event_schema"] = EventSchemaVersion
+	return out
+}
+
+// MetadataRows renders metadata as key/value pairs sorted by key; the fleet reads
+// "Standard 12m x4 (cap 70, 4550/km, 28±4 km/h); ...".
+func MetadataRows(meta map[string]any) [][2]string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := make([][2]string, 0, len(keys))
+	for _, k := range keys {
+		v := fmt.Sprint(meta[k])
+		if fl, ok := meta[k].([]FleetEntry); ok {
+			parts := make([]string, len(fl))
+			for i, e := range fl {
+				cost := fmt.Sprintf("%g/km", e.CostPerKm)
+				if e.PerHour > 0 {
+					cost += fmt.Sprintf(", %g/h", e.PerHour)
+				}
+				if e.PerDay > 0 {
+					cost += fmt.Sprintf(", %g/day", e.PerDay)
+				}
+				if e.Speed != nil {
+					cost += fmt.Sprintf(", %g±%g km/h", e.Speed.MeanKmph, e.Speed.StdKmph)
+				}
+				parts[i] = fmt.Sprintf("%s x%d (cap %d, %s)", e.Type, e.Count, e.Capacity, cost)
+			}
+			v = strings.Join(parts, "; ")
+		}
+		rows = append(rows, [2]string{k, v})
+	}
+	return rows
+}
@@ -0,0 +1,57 @@
+package sim
+
+import (
+	"time"
+
+	"brt08/backend/model"
+)
+
+// FleetLoad returns passengers onboard and the total capacity of buses.
+func FleetLoad(buses []*model.Bus) (onboard, capacity int) {
+	for _, b := range buses {
+		onboard += b.PassengersOnboard
+		if b.Type != nil {
+			capacity += b.Type.Capacity
+		}
+	}
+	return onboard, capacity
+}
+
+// QueueAging returns the maximum and average time passengers still queued at stops have
+// been waiting at now, in minutes, and the stop of the longest waiter.
+// Caller must ensure synchronization.
+func QueueAging(route *model.Route, now time.Time) (maxMin, avgMin float64, oldestStopID int) {
+	var sum float64
+	n := 0
+	for _, st := range route.Stops {
+		for _, q := range [][]*model.Passenger{st.OutboundQueue, st.InboundQueue} {
+			for _, p := range q {
+				w := now.Sub(p.ArrivalStopTime).Minutes()
+				if w < 0 {
+					w = 0
+				}
+				sum += w
+				n++
+				if w > maxMin || oldestStopID == 0 {
+					maxMin, oldestStopID = w, st.ID
+				}
+			}
+		}
+	}
+	if n > 0 {
+		avgMin = sum / float64(n)
+	}
+	return maxMin, avgMin, oldestStopID
+}
+
+// NewMetricsEvent builds a KPI heartbeat from the current route and fleet state.
+func NewMetricsEvent(at time.Time, route *model.Route, buses []*model.Bus, generated int, served int64, avgWaitMin float64) MetricsEvent {
+	qOut, qIn := QueuedPassengers(route)
+	onboard, capacity := FleetLoad(buses)
+	m := MetricsEvent{Time: at, Generated: generated, ServedPassengers: served, AvgWaitMin: avgWaitMin, Waiting: qOut + qIn, Onboard: onboard, InSystem: qOut + qIn + onboard}
+	m.QueueMaxWaitMin, m.QueueAvgWaitMin, m.OldestStopID = QueueAging(route, at)
+	if capacity > 0 {
+		m.FleetUtilization = float64(onboard) / float64(capacity)
+	}
+	return m
+}
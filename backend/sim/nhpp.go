@@ -0,0 +1,115 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"brt08/backend/data"
+)
+
+// DemandProfile returns the demand multiplier in effect after a given elapsed simulated time.
+type DemandProfile func(elapsed time.Duration) float64
+
+// FlatProfile keeps the selected period multiplier constant for the whole run.
+func FlatProfile(periodID int) (DemandProfile, float64) {
+	mult := data.TimePeriodMultiplier[periodID]
+	if mult == 0 {
+		mult = 1
+	}
+	return func(time.Duration) float64 { return mult }, mult
+}
+
+// PeriodProfile starts the run startMin minutes after midnight and varies the
+// multiplier continuously with the clock, interpolating linearly between period midpoints.
+// Before the first and after the last midpoint the edge multiplier is held.
+// The second return value is the profile maximum, used as the thinning bound.
+func PeriodProfile(periodID int, startMin float64) (DemandProfile, float64) {
+	type anchor struct{ min, mult float64 }
+	anchors := make([]anchor, 0, len(data.TimePeriods))
+	maxMult := 0.0
+	for _, p := range data.TimePeriods {
+		m := data.TimePeriodMultiplier[p.ID]
+		if m == 0 {
+			m = 1
+		}
+		anchors = append(anchors, anchor{min: float64(p.StartMin+p.EndMin) / 2, mult: m})
+		if m > maxMult {
+			maxMult = m
+		}
+	}
+	if len(anchors) == 0 {
+		return FlatProfile(periodID)
+	}
+	prof := func(elapsed time.Duration) float64 {
+		clock := math.Mod(startMin+elapsed.Minutes(), 24*60)
+		if clock <= anchors[0].min {
+			return anchors[0].mult
+		}
+		for i := 1; i < len(anchors); i++ {
+			if clock <= anchors[i].min {
+				a, b := anchors[i-1], anchors[i]
+				f := (clock - a.min) / (b.min - a.min)
+				return a.mult + (b.mult-a.mult)*f
+			}
+		}
+		return anchors[len(anchors)-1].mult
+	}
+	return prof, maxMult
+}
+
+// ParseDemandProfile checks a profile name; empty selects "flat".
+func ParseDemandProfile(kind string) (string, error) {
+	switch kind = strings.TrimSpace(kind); kind {
+	case "":
+		return "flat", nil
+	case "flat", "period":
+		return kind, nil
+	}
+	return "", fmt.Errorf("unknown demand profile %q (want flat or period)", kind)
+}
+
+// NewDemandProfile resolves a profile by name ("flat" or "period", see
+// ParseDemandProfile) for a run starting at clock time start; anything else is flat.
+func NewDemandProfile(kind string, periodID int, start time.Time) (DemandProfile, float64) {
+	if kind == "period" {
+		return PeriodProfile(periodID, clockMinutes(start))
+	}
+	return FlatProfile(periodID)
+}
+
+// NHPP samples arrival instants of a non-homogeneous Poisson process using
+// Lewis-Shedler thinning: candidates are drawn at rate RateMax and accepted
+// with probability Rate(t)/RateMax.
+type NHPP struct {
+	RNG     *rand.Rand
+	Rate    func(t time.Time) float64 // arrivals per minute at t
+	RateMax float64                   // upper bound of Rate over the sampled interval
+}
+
+// Arrivals returns the ordered arrival instants within [from, to).
+// Since inter-arrival times are memoryless, consecutive calls over adjacent
+// intervals produce a single consistent process.
+func (p NHPP) Arrivals(from, to time.Time) []time.Time {
+	if p.RateMax <= 0 || !to.After(from) {
+		return nil
+	}
+	var out []time.Time
+	t := from
+	for {
+		gapMin := p.RNG.ExpFloat64() / p.RateMax
+		t = t.Add(time.Duration(gapMin * float64(time.Minute)))
+		if !t.Before(to) {
+			return out
+		}
+		r := p.Rate(t)
+		if r <= 0 {
+			continue
+		}
+		if r >= p.RateMax || p.RNG.Float64()*p.RateMax <= r {
+			out = append(out, t)
+		}
+	}
+}
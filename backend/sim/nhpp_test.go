@@ -0,0 +1,25 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPeriodProfileStartsAtRunClock(t *testing.T) {
+	// the evening peak runs 15:00-19:00, so 17:00 is its midpoint whatever period was
+	// selected: a run started there must open at the evening multiplier
+	start := time.Date(2024, 3, 4, 17, 0, 0, 0, time.UTC)
+	prof, _ := NewDemandProfile("period", 2, start)
+	if got := prof(0); math.Abs(got-1.4) > 1e-9 {
+		t.Errorf("profile at 17:00 start = %v, want 1.4 (evening peak)", got)
+	}
+	// at the selected period's own start the profile still follows the clock
+	prof, _ = NewDemandProfile("period", 2, testStart)
+	aligned, _ := PeriodProfile(2, 6*60)
+	for _, e := range []time.Duration{0, 45 * time.Minute, 2 * time.Hour} {
+		if got, want := prof(e), aligned(e); got != want {
+			t.Errorf("profile(%s) from 06:00 = %v, want %v", e, got, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package sim
+
+import (
+	"sort"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// PlatformConfig sets the platform capacity of stops and what happens when it is
+// exceeded. The zero value leaves every stop without a stated capacity unlimited.
+type PlatformConfig struct {
+	Default int  // capacity of stops without their own PlatformCapacity (0 = unlimited)
+	Spill   bool // passengers beyond the capacity walk to an adjacent stop with room
+}
+
+// PlatformOverflowEvent reports a stop's waiting passengers exceeding its platform
+// capacity (Overflowing), and the platform having room again (!Overflowing).
+type PlatformOverflowEvent struct {
+	Time        time.Time `json:"time"`
+	StopID      int       `json:"stop_id"`
+	Name        string    `json:"stop_name"`
+	Overflowing bool      `json:"overflowing"`
+	Waiting     int       `json:"waiting"` // both directions, before any spill
+	Capacity    int       `json:"capacity"`
+	Spilled     int       `json:"spilled"`              // passengers who walked to an adjacent stop
+	Touched     []int     `json:"spilled_to,omitempty"` // stops that received them
+}
+
+func (PlatformOverflowEvent) isEvent() {}
+
+// PlatformStats is the station sizing summary of one stop with a platform capacity.
+type PlatformStats struct {
+	StopID      int     `json:"stop_id"`
+	Name        string  `json:"stop_name"`
+	Capacity    int     `json:"capacity"`
+	PeakWaiting int     `json:"peak_waiting"` // most passengers wanting the platform at once (before spilling)
+	Overflows   int     `json:"overflows"`    // episodes over capacity
+	OverflowMin float64 `json:"overflow_min"` // total time over capacity (or full, when spilling)
+	Spilled     int     `json:"spilled"`      // passengers sent on to an adjacent stop
+	Received    int     `json:"received"`     // passengers spilled here from an adjacent stop
+}
+
+// PlatformMonitor checks stop queues against their platform capacity, spilling the
+// excess when configured, and keeps the per-stop overflow statistics. A nil monitor
+// (no stop has a capacity) ignores every call. Caller must ensure synchronization.
+type PlatformMonitor struct {
+	route  *model.Route
+	spill  bool
+	cap    map[int]int
+	since  map[int]time.Time // overflowing stop id -> start of the episode
+	stats  map[int]*PlatformStats
+	Closed func(stopID int) bool // stops passengers must not spill to (nil = none)
+}
+
+// NewPlatformMonitor returns a monitor for route, or nil when no stop has a capacity.
+func NewPlatformMonitor(route *model.Route, cfg PlatformConfig) *PlatformMonitor {
+	m := &PlatformMonitor{route: route, spill: cfg.Spill, cap: make(map[int]int), since: make(map[int]time.Time), stats: make(map[int]*PlatformStats)}
+	for _, st := range route.Stops {
+		c := st.PlatformCapacity
+		if c <= 0 {
+			c = cfg.Default
+		}
+		if c > 0 {
+			m.cap[st.ID] = c
+			m.stats[st.ID] = &PlatformStats{StopID: st.ID, Name: st.Name, Capacity: c}
+		}
+	}
+	if len(m.cap) == 0 {
+		return nil
+	}
+	return m
+}
+
+func waiting(st *model.BusStop) int { return len(st.OutboundQueue) + len(st.InboundQueue) }
+
+// Check compares the queue of stopID with its capacity at now, after arrivals or
+// boardings there. It spills the excess when configured and returns an event when an
+// overflow starts or ends, plus the stops whose queues changed.
+func (m *PlatformMonitor) Check(stopID int, now time.Time) (PlatformOverflowEvent, bool, []int) {
+	if m == nil {
+		return PlatformOverflowEvent{}, false, nil
+	}
+	capacity, ok := m.cap[stopID]
+	if !ok {
+		return PlatformOverflowEvent{}, false, nil
+	}
+	st := m.route.GetStop(stopID)
+	s := m.stats[stopID]
+	n := waiting(st)
+	s.PeakWaiting = max(s.PeakWaiting, n)
+	start, over := m.since[stopID]
+	if n <= capacity {
+		// with spilling a full platform still turns passengers away
+		if over && (n < capacity || !m.spill) {
+			delete(m.since, stopID)
+			if d := now.Sub(start); d > 0 {
+				s.OverflowMin += d.Minutes()
+			}
+			return PlatformOverflowEvent{Time: now, StopID: stopID, Name: st.Name, Waiting: n, Capacity: capacity}, true, nil
+		}
+		return PlatformOverflowEvent{}, false, nil
+	}
+	var spilled int
+	var touched []int
+	if m.spill {
+		spilled, touched = m.spillExcess(st, n-capacity)
+		s.Spilled += spilled
+	}
+	if over {
+		return PlatformOverflowEvent{}, false, touched
+	}
+	m.since[stopID] = now
+	s.Overflows++
+	return PlatformOverflowEvent{Time: now, StopID: stopID, Name: st.Name, Overflowing: true, Waiting: n, Capacity: capacity, Spilled: spilled, Touched: touched}, true, touched
+}
+
+// spillExcess moves up to excess of the latest arrivals at st to an adjacent stop with
+// room that they can still board at, keeping their arrival times.
+func (m *PlatformMonitor) spillExcess(st *model.BusStop, excess int) (int, []int) {
+	idx := m.route.IndexOf(st.ID)
+	moved := 0
+	var touched []int
+	for moved < excess {
+		queue := &st.OutboundQueue
+		if len(st.InboundQueue) > len(st.OutboundQueue) {
+			queue = &st.InboundQueue
+		}
+		if len(*queue) == 0 {
+			break
+		}
+		p := (*queue)[len(*queue)-1]
+		to := m.neighbor(idx, p)
+		if to == nil {
+			break
+		}
+		*queue = (*queue)[:len(*queue)-1]
+		p.StartStopID = to.ID
+		if p.Direction == "inbound" {
+			to.InboundQueue = append(to.InboundQueue, p)
+		} else {
+			to.OutboundQueue = append(to.OutboundQueue, p)
+		}
+		if r := m.stats[to.ID]; r != nil {
+			r.Received++
+		}
+		moved++
+		if len(touched) == 0 || touched[len(touched)-1] != to.ID {
+			touched = append(touched, to.ID)
+		}
+	}
+	sort.Ints(touched)
+	return moved, dedupe(touched)
+}
+
+// neighbor returns the nearer of the stops either side of route index idx where p
+// can still board (upstream, or downstream before p's destination) and that has room.
+func (m *PlatformMonitor) neighbor(idx int, p *model.Passenger) *model.BusStop {
+	stops := m.route.Stops
+	step := 1
+	if p.Direction == "inbound" {
+		step = -1
+	}
+	dest := m.route.IndexOf(p.EndStopID)
+	var best *model.BusStop
+	bestKm := 0.0
+	for _, j := range []int{idx - 1, idx + 1} {
+		if j < 0 || j >= len(stops) || (j-idx == step && (j-dest)*step >= 0) {
+			continue
+		}
+		to := stops[j]
+		if m.Closed != nil && m.Closed(to.ID) {
+			continue
+		}
+		if c, ok := m.cap[to.ID]; ok && waiting(to) >= c {
+			continue
+		}
+		km := stops[min(idx, j)].DistanceToNext
+		if best == nil || km < bestKm {
+			best, bestKm = to, km
+		}
+	}
+	return best
+}
+
+func dedupe(ids []int) []int {
+	out := ids[:0]
+	for i, id := range ids {
+		if i == 0 || id != ids[i-1] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// Stats returns the statistics of the stops with a capacity in route order, overflows
+// still in progress measured up to end.
+func (m *PlatformMonitor) Stats(end time.Time) []PlatformStats {
+	if m == nil {
+		return nil
+	}
+	var out []PlatformStats
+	for _, st := range m.route.Stops {
+		s, ok := m.stats[st.ID]
+		if !ok {
+			continue
+		}
+		v := *s
+		if start, over := m.since[st.ID]; over && end.After(start) {
+			v.OverflowMin += end.Sub(start).Minutes()
+		}
+		out = append(out, v)
+	}
+	return out
+}
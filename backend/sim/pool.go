@@ -0,0 +1,46 @@
+package sim
+
+import "brt08/backend/model"
+
+// maxFreePassengers bounds the recycled passengers an engine keeps for reuse; beyond
+// it released ones are left to the garbage collector.
+const maxFreePassengers = 1 << 14
+
+// Recycle hands alighted passengers back to the engine, which reuses them for the
+// next arrivals instead of allocating. Nothing may hold on to them afterwards. With
+// RecordPassengers every passenger stays in the journey log and Recycle does nothing.
+// Caller must ensure synchronization.
+func (s *Simulator) Recycle(ps []*model.Passenger) {
+	if s.RecordPassengers {
+		return
+	}
+	for _, p := range ps {
+		if len(s.free) == maxFreePassengers {
+			return
+		}
+		*p = model.Passenger{}
+		s.free = append(s.free, p)
+	}
+}
+
+// allocPassenger returns a recycled passenger, or a new one when none is free.
+func (s *Simulator) allocPassenger() *model.Passenger {
+	n := len(s.free)
+	if n == 0 {
+		return &model.Passenger{}
+	}
+	p := s.free[n-1]
+	s.free[n-1] = nil
+	s.free = s.free[:n-1]
+	return p
+}
+
+// complete keeps delivered passengers in Completed for the journey log, or recycles
+// them.
+func (s *Simulator) complete(ps []*model.Passenger) {
+	if s.RecordPassengers {
+		s.Completed = append(s.Completed, ps...)
+		return
+	}
+	s.Recycle(ps)
+}
@@ -0,0 +1,195 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"brt08/backend/data"
+	"brt08/backend/model"
+)
+
+// Person is a member of the synthetic population: a commuter living near one stop and
+// working near another, with preferred departure times for the morning and evening legs.
+type Person struct {
+	ID        int
+	HomeIdx   int     // route stop index of the home stop
+	WorkIdx   int     // route stop index of the work stop
+	DepartMin float64 // minutes after midnight of the home -> work departure
+	ReturnMin float64 // minutes after midnight of the work -> home departure
+}
+
+// Trip is one leg of a person's day, arriving at the origin stop at At.
+type Trip struct {
+	Person    *Person
+	At        time.Time
+	OriginIdx int
+	DestIdx   int
+}
+
+// dayTrip is a trip template at a clock offset within any day.
+type dayTrip struct {
+	clockMin float64
+	person   *Person
+	outward  bool // home -> work
+}
+
+// Population is an activity-based demand source: every person makes the same two
+// trips each simulated day, so morning and evening flows mirror each other.
+type Population struct {
+	People  []*Person
+	day     []dayTrip // sorted by clockMin
+	dayZero time.Time // midnight of the first simulated day
+}
+
+// Preferred departure distributions (minutes after midnight).
+const (
+	popMorningMeanMin = 7*60 + 30
+	popMorningStdMin  = 40
+	popEveningMeanMin = 17*60 + 15
+	popEveningStdMin  = 60
+	popMinWorkDayMin  = 4 * 60
+)
+
+// NewPopulation draws size people for the route. Homes concentrate toward the outer
+// terminal and workplaces toward the CBD (Kivukoni, or Kimara when the morning peak
+// runs the other way) using cfg's spatial gradient and baseline. start is the simulated
+// instant at which the selected period begins; trips are placed on that day's clock.
+func NewPopulation(rng *rand.Rand, route *model.Route, size int, periodID int, morningTowardKivukoni bool, start time.Time, cfg DemandConfig) *Population {
+	n := len(route.Stops)
+	pop := &Population{dayZero: start.Add(-time.Duration(periodStartMin(periodID)) * time.Minute)}
+	if size <= 0 || n < 2 {
+		return pop
+	}
+	// weight(i) rises toward the CBD end of the corridor
+	cbdWeight := func(i int) float64 {
+		if cfg.SpatialGradient <= 0 {
+			return 1
+		}
+		pos := float64(i) / float64(n-1)
+		if !morningTowardKivukoni {
+			pos = 1 - pos
+		}
+		return clamp01(cfg.BaselineDemand) + cfg.SpatialGradient*pos
+	}
+	homeW := make([]float64, n)
+	workW := make([]float64, n)
+	for i := range route.Stops {
+		workW[i] = cbdWeight(i)
+		homeW[i] = cbdWeight(n - 1 - i)
+	}
+	for id := 1; id <= size; id++ {
+		p := &Person{ID: id, HomeIdx: weightedIndex(rng, homeW)}
+		p.WorkIdx = weightedIndex(rng, workW)
+		for p.WorkIdx == p.HomeIdx {
+			p.WorkIdx = weightedIndex(rng, workW)
+		}
+		p.DepartMin = clampF(popMorningMeanMin+rng.NormFloat64()*popMorningStdMin, 5*60, 10*60+30)
+		p.ReturnMin = clampF(popEveningMeanMin+rng.NormFloat64()*popEveningStdMin, 15*60, 21*60)
+		if p.ReturnMin < p.DepartMin+popMinWorkDayMin {
+			p.ReturnMin = p.DepartMin + popMinWorkDayMin
+		}
+		pop.People = append(pop.People, p)
+		pop.day = append(pop.day, dayTrip{clockMin: p.DepartMin, person: p, outward: true}, dayTrip{clockMin: p.ReturnMin, person: p})
+	}
+	sort.SliceStable(pop.day, func(i, j int) bool { return pop.day[i].clockMin < pop.day[j].clockMin })
+	return pop
+}
+
+// Trips returns the trips starting within [from, to) in time order, repeating the
+// daily pattern for runs that cross midnight.
+func (p *Population) Trips(from, to time.Time) []Trip {
+	if p == nil || len(p.day) == 0 || !to.After(from) {
+		return nil
+	}
+	var out []Trip
+	firstDay := int(math.Floor(from.Sub(p.dayZero).Hours() / 24))
+	for d := firstDay; ; d++ {
+		base := p.dayZero.Add(time.Duration(d) * 24 * time.Hour)
+		if !base.Before(to) {
+			return out
+		}
+		lo := from.Sub(base).Minutes()
+		i := sort.Search(len(p.day), func(k int) bool { return p.day[k].clockMin >= lo })
+		for ; i < len(p.day); i++ {
+			dt := p.day[i]
+			at := base.Add(time.Duration(dt.clockMin * float64(time.Minute)))
+			if !at.Before(to) {
+				break
+			}
+			tr := Trip{Person: dt.person, At: at, OriginIdx: dt.person.WorkIdx, DestIdx: dt.person.HomeIdx}
+			if dt.outward {
+				tr.OriginIdx, tr.DestIdx = dt.person.HomeIdx, dt.person.WorkIdx
+			}
+			out = append(out, tr)
+		}
+	}
+}
+
+// GeneratePopulationTrips enqueues the population's trips within [from, to) and returns
+// the set of updated stop IDs. Caller must ensure synchronization.
+func GeneratePopulationTrips(engine *Simulator, route *model.Route, pop *Population, from, to time.Time, totalTarget int) map[int]struct{} {
+	updatedStops := make(map[int]struct{})
+	for _, tr := range pop.Trips(from, to) {
+		if totalTarget > 0 && engine.GeneratedPassengers >= totalTarget {
+			break
+		}
+		origin := route.Stops[tr.OriginIdx]
+		dest := route.Stops[tr.DestIdx]
+		dir := "outbound"
+		if tr.DestIdx < tr.OriginIdx {
+			dir = "inbound"
+		}
+		p := engine.NewPassengerPublic(origin.ID, dest.ID, tr.At)
+		p.Direction = dir
+		p.PersonID = tr.Person.ID
+		origin.EnqueuePassenger(p, dir, tr.At)
+		engine.noteArrival(origin)
+		engine.GeneratedPassengers++
+		if dir == "outbound" {
+			engine.OutboundGenerated++
+		} else {
+			engine.InboundGenerated++
+		}
+		updatedStops[origin.ID] = struct{}{}
+	}
+	return updatedStops
+}
+
+// periodStartMin returns the clock minute at which a demand period begins (0 if unknown).
+func periodStartMin(periodID int) int {
+	for _, tp := range data.TimePeriods {
+		if tp.ID == periodID {
+			return tp.StartMin
+		}
+	}
+	return 0
+}
+
+// weightedIndex samples an index proportionally to w.
+func weightedIndex(rng *rand.Rand, w []float64) int {
+	sum := 0.0
+	for _, x := range w {
+		sum += x
+	}
+	r := rng.Float64() * sum
+	cum := 0.0
+	for i, x := range w {
+		cum += x
+		if r <= cum {
+			return i
+		}
+	}
+	return len(w) - 1
+}
+
+func clampF(x, lo, hi float64) float64 {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
@@ -0,0 +1,77 @@
+package sim
+
+import (
+	"math"
+	"strconv"
+
+	"brt08/backend/model"
+)
+
+// Precision is the reporting rounding policy: decimal places per quantity kind.
+// Console output is always rounded; with FullPrecision, machine-readable outputs
+// (CSV, JSON) carry unrounded values instead.
+type Precision struct {
+	KMDecimals       int  // distances
+	CurrencyDecimals int  // operating cost
+	MinutesDecimals  int  // waits and other durations in minutes
+	FullPrecision    bool // CSV/JSON keep full precision
+}
+
+// DefaultPrecision matches the historical two-decimal reports.
+var DefaultPrecision = Precision{KMDecimals: 2, CurrencyDecimals: 2, MinutesDecimals: 2}
+
+// ReportPrecision is the policy applied by all report writers; set it once at startup.
+var ReportPrecision = DefaultPrecision
+
+func roundTo(x float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(x*p) / p
+}
+
+func (p Precision) round(x float64, places int, machine bool) float64 {
+	if machine && p.FullPrecision {
+		return x
+	}
+	return roundTo(x, places)
+}
+
+func (p Precision) format(x float64, places int, machine bool) string {
+	if machine && p.FullPrecision {
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(x, 'f', places, 64)
+}
+
+// KM rounds a distance; machine selects the CSV/JSON policy.
+func (p Precision) KM(x float64, machine bool) float64 { return p.round(x, p.KMDecimals, machine) }
+
+// Currency rounds a cost.
+func (p Precision) Currency(x float64, machine bool) float64 {
+	return p.round(x, p.CurrencyDecimals, machine)
+}
+
+// Minutes rounds a duration in minutes.
+func (p Precision) Minutes(x float64, machine bool) float64 {
+	return p.round(x, p.MinutesDecimals, machine)
+}
+
+// FormatKM, FormatCurrency and FormatMinutes render values with the policy's decimals.
+func (p Precision) FormatKM(x float64, machine bool) string {
+	return p.format(x, p.KMDecimals, machine)
+}
+
+func (p Precision) FormatCurrency(x float64, machine bool) string {
+	return p.format(x, p.CurrencyDecimals, machine)
+}
+
+func (p Precision) FormatMinutes(x float64, machine bool) string {
+	return p.format(x, p.MinutesDecimals, machine)
+}
+
+// BusCost returns the displayed distance and operating cost of one bus of type t over
+// hours in service (see model.BusType.OperatingCost): cost is computed from the rounded
+// distance so per-bus rows and totals add up in every output.
+func (p Precision) BusCost(km, hours float64, t *model.BusType, machine bool) (dist, cost float64) {
+	dist = p.KM(km, machine)
+	return dist, p.Currency(t.OperatingCost(dist, hours), machine)
+}
@@ -0,0 +1,111 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Service quality score reference points. A component scores 1 at the ideal and 0 at
+// (or beyond) its cap, linearly in between.
+const (
+	qualityWaitCapMin       = 30.0 // P90 wait at which the wait component reaches 0
+	qualityBufferCapMin     = 15.0 // P90-P50 wait spread at which reliability reaches 0
+	qualityComfortOccupancy = 0.6  // average load/capacity above which crowding is penalized
+)
+
+// QualityWeights weighs the service quality components; they are normalized on use.
+type QualityWeights struct {
+	Wait        float64 `json:"wait"`
+	Crowding    float64 `json:"crowding"`
+	Reliability float64 `json:"reliability"`
+}
+
+// DefaultQualityWeights emphasizes waiting, the component passengers feel most.
+var DefaultQualityWeights = QualityWeights{Wait: 0.5, Crowding: 0.3, Reliability: 0.2}
+
+// ParseQualityWeights parses "wait:0.5,crowding:0.3,reliability:0.2". Omitted components
+// get weight 0; an empty spec yields the defaults.
+func ParseQualityWeights(spec string) (QualityWeights, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return DefaultQualityWeights, nil
+	}
+	var w QualityWeights
+	for _, part := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(kv) != 2 {
+			return QualityWeights{}, fmt.Errorf("quality weights: %q is not name:weight", part)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || v < 0 {
+			return QualityWeights{}, fmt.Errorf("quality weights: invalid weight %q", kv[1])
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "wait":
+			w.Wait = v
+		case "crowding":
+			w.Crowding = v
+		case "reliability":
+			w.Reliability = v
+		default:
+			return QualityWeights{}, fmt.Errorf("quality weights: unknown component %q (want wait, crowding, reliability)", kv[0])
+		}
+	}
+	if w.Wait+w.Crowding+w.Reliability <= 0 {
+		return QualityWeights{}, fmt.Errorf("quality weights: weights sum to zero")
+	}
+	return w, nil
+}
+
+// QualityScore is the composite service quality index of a run (0..100) with its
+// components (0..1 each).
+type QualityScore struct {
+	Score       float64        `json:"score"`
+	Wait        float64        `json:"wait"`        // 1 - P90 wait / 30 min
+	Crowding    float64        `json:"crowding"`    // occupancy above 60% and denied boardings penalized
+	Reliability float64        `json:"reliability"` // 1 - (P90 - P50 wait) / 15 min; 0 when stalled
+	Weights     QualityWeights `json:"weights"`
+}
+
+func clamp01(x float64) float64 { return math.Max(0, math.Min(1, x)) }
+
+// ComputeQuality scores a run from its wait distribution, per-bus and per-stop aggregates.
+// Zero weights fall back to DefaultQualityWeights.
+func ComputeQuality(wait WaitDistribution, buses []BusStats, stops []StopStats, stalled bool, weights QualityWeights) QualityScore {
+	if weights.Wait+weights.Crowding+weights.Reliability <= 0 {
+		weights = DefaultQualityWeights
+	}
+	q := QualityScore{Weights: weights}
+	q.Wait = clamp01(1 - wait.Overall.P90/qualityWaitCapMin)
+
+	// In-service-time weighted fleet occupancy and the share of boarding attempts denied
+	var occSec, totSec float64
+	for _, b := range buses {
+		occSec += b.AvgOccupancy * b.InServiceSec
+		totSec += b.InServiceSec
+	}
+	occ := 0.0
+	if totSec > 0 {
+		occ = occSec / totSec
+	}
+	boarded, denied := 0, 0
+	for _, s := range stops {
+		boarded += s.Boarded
+		denied += s.Denied
+	}
+	deniedShare := 0.0
+	if boarded+denied > 0 {
+		deniedShare = float64(denied) / float64(boarded+denied)
+	}
+	excess := clamp01((occ - qualityComfortOccupancy) / (1 - qualityComfortOccupancy))
+	q.Crowding = (1 - excess) * (1 - deniedShare)
+
+	if !stalled {
+		q.Reliability = clamp01(1 - (wait.Overall.P90-wait.Overall.P50)/qualityBufferCapMin)
+	}
+	total := weights.Wait + weights.Crowding + weights.Reliability
+	q.Score = 100 * (weights.Wait*q.Wait + weights.Crowding*q.Crowding + weights.Reliability*q.Reliability) / total
+	return q
+}
@@ -2,21 +2,53 @@ package sim
 
 import (
 	"fmt"
-	"log"
-	"math"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"brt08/backend/atomicfile"
 	"brt08/backend/model"
 )
 
 // ReportSummary carries end-of-run metrics needed for reporting.
 type ReportSummary struct {
-	Generated   int
-	Served      int64
-	AvgWaitMin  float64
-	BusDistance map[int]float64 // km per bus id
+	Label        string // optional driver label shown in the console heading
+	Generated    int
+	Served       int64
+	AvgWaitMin   float64
+	BusDistance  map[int]float64    // km per bus id
+	Stalled      bool               // run ended because service stalled
+	Diagnostic   string             // explanation when Stalled
+	Aborted      string             // why the run ended early; the report is partial
+	Wait         *WaitDistribution  // wait percentiles/histograms (nil = not collected)
+	Stops        []StopStats        // per-stop aggregates in route order (nil = omitted)
+	Zones        []ZoneStats        // per corridor zone aggregates (nil = omitted)
+	PeakLoad     []SegmentLoad      // peak load point per direction (nil = omitted)
+	Headway      []HeadwayAdherence // headway adherence per period (nil = omitted)
+	StopHeadways []StopHeadway      // observed headway statistics per stop and direction (nil = omitted)
+	Anomalies    []AnomalyEvent     // anomalies detected during the run
+	Shifts       *ShiftStats        // driver breaks, reliefs and violations (nil = shifts disabled)
+	Closures     []ClosureRecord    // stops closed mid-run (nil = none)
+	Periods      []PeriodStats      // per-period sections of a full-day run (nil = single period)
+	ETAAccuracy  []ETAAccuracy      // passenger information display prediction errors (nil = not simulated)
+	Platforms    []PlatformStats    // platform overflows of the stops with a capacity (nil = none)
+	Berths       []BerthStats       // berth use of the stations with limited berths (nil = none)
+	Turnarounds  []TurnaroundStats  // terminal bay use and recovery (nil = unlimited bays, no recovery time)
+	Directions   []DirectionStats   // demand and service by direction (nil = omitted)
+	ExcessWait   *ExcessWaitStats   // excess wait time overall and per stop (nil = omitted)
+	BusStats     []BusStats         // per-bus service metrics, used for the per-type breakdown
+	Quality      *QualityScore      // composite service quality index (nil = omitted)
+	Capacity     string             // corridor capacity warning (empty = demand within capacity)
+	EnergyKWh    float64            // estimated running energy (0 = not tracked)
+	RunningMin   float64            // bus-minutes spent moving between stops
+	CO2Kg        float64            // emissions from the vehicle dataset coefficients
+	Metadata     map[string]any     // run options, fleet and build version (see RunMetadata); nil = omitted
+	Criterion    string             // end conditions (see DescribeCriterion); empty = not recorded
+	EndedBy      string             // what ended the run (see EndReason)
 }
 
 // WriteCSVReport writes a CSV report to the given path or directory.
@@ -27,67 +59,448 @@ func WriteCSVReport(reportPath string, buses []*model.Bus, sum ReportSummary) (s
 		return "", nil
 	}
 	ts := time.Now().Format("20060102-150405")
-	outPath := reportPath
-	if fi, err := os.Stat(outPath); err == nil && fi.IsDir() {
-		outPath = filepath.Join(outPath, fmt.Sprintf("report-%s.csv", ts))
-	} else if outPath != "" {
-		ext := filepath.Ext(outPath)
-		base := outPath[:len(outPath)-len(ext)]
-		outPath = fmt.Sprintf("%s-%s%s", base, ts, ext)
-	}
-	f, err := os.Create(outPath)
+	outPath := timestampedPath(reportPath, "report", ".csv", ts)
+	f, err := atomicfile.Create(outPath)
 	if err != nil {
 		return "", err
 	}
 	defer f.Close()
-	fmt.Fprintln(f, "section,bus_id,direction,type,avg_speed_kmph,distance_km,cost,generated,served,avg_wait_min,buses_count,timestamp")
-	round2 := func(x float64) float64 { return math.Round(x*100) / 100 }
+	t := newCSVTable("section", "bus_id", "direction", "type", "avg_speed_kmph", "distance_km", "cost", "generated", "served", "avg_wait_min", "buses_count", "timestamp")
+	pr := ReportPrecision
+	totalCost := 0.0
+	hours := ServiceHours(sum.BusStats)
+	// header section: one row per option so the run can be reproduced from the file
+	for _, kv := range MetadataRows(sum.Metadata) {
+		t.add("section", "meta", "key", kv[0], "value", kv[1], "timestamp", ts)
+	}
 	for _, b := range buses {
-		d := round2(sum.BusDistance[b.ID])
-		c := 0.0
 		typeName := ""
 		if b.Type != nil {
-			c = round2(float64(b.Type.CostPerKm) * d)
 			typeName = b.Type.Name
 		}
-		fmt.Fprintf(f, "bus,%d,%s,%s,%.1f,%.2f,%.2f,,,,,%s\n", b.ID, b.Direction, typeName, b.AverageSpeedKmph, d, c, ts)
+		d, c := pr.BusCost(sum.BusDistance[b.ID], hours[b.ID], b.Type, true)
+		totalCost += c
+		boarded, alighted, maxLoad := busCounts(b, sum.BusStats)
+		t.add("section", "bus", "bus_id", fmt.Sprint(b.ID), "direction", b.Direction, "type", typeName, "avg_speed_kmph", fmt.Sprintf("%.1f", b.AverageSpeedKmph), "distance_km", pr.FormatKM(d, true), "cost", pr.FormatCurrency(c, true), "timestamp", ts, "boarded", fmt.Sprint(boarded), "alighted", fmt.Sprint(alighted), "max_load", maxLoad)
+		if s, ok := busStatsOf(b, sum.BusStats); ok {
+			addUtilizationCells(t, s)
+		}
+	}
+	for _, bt := range TypeBreakdown(buses, sum.BusStats, sum.BusDistance, true) {
+		t.add("section", "bus_type", "type_id", fmt.Sprint(bt.TypeID), "type", bt.TypeName, "buses_count", fmt.Sprint(bt.Buses), "capacity", fmt.Sprint(bt.Capacity), "distance_km", pr.FormatKM(bt.DistanceKM, true), "cost", pr.FormatCurrency(bt.Cost, true), "service_hours", fmt.Sprintf("%.2f", bt.ServiceHours), "distance_cost", pr.FormatCurrency(bt.DistanceCost, true), "time_cost", pr.FormatCurrency(bt.TimeCost, true), "fixed_cost", pr.FormatCurrency(bt.FixedCost, true), "boarded", fmt.Sprint(bt.Boarded), "avg_load", fmt.Sprintf("%.2f", bt.AvgLoad), "avg_occupancy", fmt.Sprintf("%.3f", bt.AvgOccupancy), "timestamp", ts)
+	}
+	t.add("section", "summary", "cost", pr.FormatCurrency(totalCost, true), "generated", fmt.Sprint(sum.Generated), "served", fmt.Sprint(sum.Served), "avg_wait_min", pr.FormatMinutes(sum.AvgWaitMin, true), "buses_count", fmt.Sprint(len(buses)), "stop_criterion", sum.Criterion, "ended_by", sum.EndedBy, "timestamp", ts)
+	if len(sum.BusStats) > 0 {
+		addUtilizationCells(t, FleetTime(sum.BusStats))
+	}
+	for _, d := range sum.Directions {
+		t.add("section", "direction", "direction", d.Direction, "generated", fmt.Sprint(d.Generated), "served", fmt.Sprint(d.Served), "avg_wait_min", pr.FormatMinutes(d.AvgWaitMin, true), "passenger_km", fmt.Sprintf("%.2f", d.PassengerKM), "timestamp", ts)
+	}
+	if ew := sum.ExcessWait; ew != nil {
+		addExcessWaitRow(t, "overall", ew.Overall, ts)
+		for _, e := range ew.ByStop {
+			addExcessWaitRow(t, "stop", e, ts)
+		}
+	}
+	if q := sum.Quality; q != nil {
+		f3 := func(x float64) string { return strconv.FormatFloat(x, 'f', 3, 64) }
+		t.add("section", "quality", "quality_score", fmt.Sprintf("%.1f", q.Score), "quality_wait", f3(q.Wait), "quality_crowding", f3(q.Crowding), "quality_reliability", f3(q.Reliability), "timestamp", ts)
+	}
+	if sum.Stalled {
+		t.add("section", "diagnostic", "note", sum.Diagnostic, "timestamp", ts)
+	}
+	if sum.EnergyKWh > 0 {
+		t.add("section", "energy", "energy_kwh", fmt.Sprintf("%.2f", sum.EnergyKWh), "running_min", pr.FormatMinutes(sum.RunningMin, true), "co2_kg", fmt.Sprintf("%.2f", sum.CO2Kg), "timestamp", ts)
+	}
+	if sum.Capacity != "" {
+		t.add("section", "capacity", "note", sum.Capacity, "timestamp", ts)
+	}
+	if sum.Aborted != "" {
+		t.add("section", "aborted", "note", "partial report: "+sum.Aborted, "timestamp", ts)
+	}
+	if sum.Wait != nil {
+		addWaitRows(t, "overall", "", sum.Wait.Overall, ts)
+		for _, dir := range []string{"outbound", "inbound"} {
+			if wp, ok := sum.Wait.ByDirection[dir]; ok {
+				addWaitRows(t, "direction", dir, wp, ts)
+			}
+		}
+		for _, sid := range sortedStopIDs(sum.Wait.ByStop) {
+			addWaitRows(t, "stop", fmt.Sprint(sid), sum.Wait.ByStop[sid], ts)
+		}
+	}
+	for _, st := range sum.Stops {
+		t.add("section", "stop", "stop_id", fmt.Sprint(st.StopID), "stop_name", st.Name, "arrivals", fmt.Sprint(st.ArrivalsGenerated), "boarded", fmt.Sprint(st.Boarded), "denied", fmt.Sprint(st.Denied), "avg_wait_min", pr.FormatMinutes(st.AvgWaitMinutes, true), "max_queue", fmt.Sprint(st.MaxQueue), "remaining_outbound", fmt.Sprint(st.RemainingOutbound), "remaining_inbound", fmt.Sprint(st.RemainingInbound), "timestamp", ts)
+	}
+	for _, h := range sum.Headway {
+		t.add("section", "headway", "period", fmt.Sprint(h.PeriodID), "period_name", h.Period, "target_headway_min", fmt.Sprintf("%.2f", h.TargetMin), "tolerance", fmt.Sprintf("%.2f", h.Tolerance), "headways", fmt.Sprint(h.Headways), "within", fmt.Sprint(h.Within), "bunched", fmt.Sprint(h.Bunched), "gapped", fmt.Sprint(h.Gapped), "adherence", fmt.Sprintf("%.4f", h.Adherence), "mean_headway_min", pr.FormatMinutes(h.MeanMin, true), "timestamp", ts)
+	}
+	for _, a := range sum.Anomalies {
+		t.add("section", "anomaly", "time", a.Time.Format(time.RFC3339), "kind", a.Kind, "bus_id", fmt.Sprint(a.BusID), "stop_id", fmt.Sprint(a.StopID), "value", fmt.Sprintf("%.2f", a.Value), "detail", a.Message, "timestamp", ts)
+	}
+	if sh := sum.Shifts; sh != nil {
+		t.add("section", "shift", "kind", "summary", "drivers", fmt.Sprint(sh.Drivers), "breaks", fmt.Sprint(sh.Breaks), "reliefs", fmt.Sprint(sh.Reliefs), "out_of_service_min", pr.FormatMinutes(sh.OutOfServiceMin, true), "availability", fmt.Sprintf("%.4f", sh.Availability), "violations", fmt.Sprint(len(sh.Violations)), "timestamp", ts)
+		for _, v := range sh.Violations {
+			t.add("section", "shift", "kind", v.Kind, "time", v.Time.Format(time.RFC3339), "bus_id", fmt.Sprint(v.BusID), "driver", fmt.Sprint(v.Driver), "limit_min", pr.FormatMinutes(v.LimitMin, true), "actual_min", pr.FormatMinutes(v.ActualMin, true), "timestamp", ts)
+		}
+	}
+	for _, c := range sum.Closures {
+		reopened := ""
+		if c.ReopenedAt != nil {
+			reopened = c.ReopenedAt.Format(time.RFC3339)
+		}
+		t.add("section", "closure", "stop_id", fmt.Sprint(c.StopID), "stop_name", c.Name, "policy", c.Policy, "time", c.ClosedAt.Format(time.RFC3339), "reopened_at", reopened, "duration_min", pr.FormatMinutes(c.DurationMin, true), "redistributed", fmt.Sprint(c.Redistributed), "unserved", fmt.Sprint(c.Unserved), "skips", fmt.Sprint(c.Skips), "carried_past", fmt.Sprint(c.CarriedPast), "timestamp", ts)
+	}
+	if len(sum.Periods) > 0 {
+		boarded := 0
+		for _, p := range sum.Periods {
+			boarded += p.Boarded
+			t.add("section", "period", "period", fmt.Sprint(p.PeriodID), "period_name", p.Name, "start", p.Start, "end", p.End, "multiplier", fmt.Sprintf("%.2f", p.Multiplier), "favored_direction", p.Favored, "generated", fmt.Sprint(p.Generated), "outbound_generated", fmt.Sprint(p.OutboundGenerated), "inbound_generated", fmt.Sprint(p.InboundGenerated), "boarded", fmt.Sprint(p.Boarded), "avg_wait_min", pr.FormatMinutes(p.Wait.Mean, true), "p90_wait_min", pr.FormatMinutes(p.Wait.P90, true),
+				"served", fmt.Sprint(p.Served), "departures", fmt.Sprint(p.Departures), "avg_load", fmt.Sprintf("%.2f", p.AvgLoad), "load_factor", fmt.Sprintf("%.3f", p.LoadFactor), "timestamp", ts)
+		}
+		t.add("section", "day", "start", sum.Periods[0].Start, "end", sum.Periods[len(sum.Periods)-1].End, "generated", fmt.Sprint(sum.Generated), "served", fmt.Sprint(sum.Served), "boarded", fmt.Sprint(boarded), "avg_wait_min", pr.FormatMinutes(sum.AvgWaitMin, true), "timestamp", ts)
+	}
+	for _, p := range sum.Platforms {
+		t.add("section", "platform", "stop_id", fmt.Sprint(p.StopID), "stop_name", p.Name, "capacity", fmt.Sprint(p.Capacity), "peak_waiting", fmt.Sprint(p.PeakWaiting), "overflows", fmt.Sprint(p.Overflows), "overflow_min", pr.FormatMinutes(p.OverflowMin, true), "spilled", fmt.Sprint(p.Spilled), "received", fmt.Sprint(p.Received), "timestamp", ts)
+	}
+	for _, b := range sum.Berths {
+		t.add("section", "berth", "stop_id", fmt.Sprint(b.StopID), "stop_name", b.Name, "berths", fmt.Sprint(b.Berths), "docks", fmt.Sprint(b.Docks), "queued", fmt.Sprint(b.Queued), "queue_min", pr.FormatMinutes(b.QueueMin, true), "max_wait_min", pr.FormatMinutes(b.MaxWaitMin, true), "max_queue", fmt.Sprint(b.MaxQueue), "utilization", fmt.Sprintf("%.4f", b.Utilization), "timestamp", ts)
+	}
+	for _, y := range sum.Turnarounds {
+		t.add("section", "turnaround", "stop_id", fmt.Sprint(y.StopID), "stop_name", y.Name, "bays", fmt.Sprint(y.Bays), "turns", fmt.Sprint(y.Turns), "queued", fmt.Sprint(y.Queued), "wait_min", pr.FormatMinutes(y.WaitMin, true), "max_wait_min", pr.FormatMinutes(y.MaxWaitMin, true), "max_queue", fmt.Sprint(y.MaxQueue), "recovery_min", pr.FormatMinutes(y.RecoveryMin, true), "utilization", fmt.Sprintf("%.4f", y.Utilization), "timestamp", ts)
 	}
-	totalCost := 0.0
 	for _, b := range buses {
-		if b.Type != nil {
-			d := round2(sum.BusDistance[b.ID])
-			totalCost += round2(float64(b.Type.CostPerKm) * d)
+		if s, ok := busStatsOf(b, sum.BusStats); ok {
+			for _, tt := range s.TripTimes {
+				addTripTimesRow(t, "bus", fmt.Sprint(b.ID), tt, ts)
+			}
 		}
 	}
-	fmt.Fprintf(f, "summary,,,,,,%.2f,%d,%d,%.2f,%d,%s\n", totalCost, sum.Generated, sum.Served, sum.AvgWaitMin, len(buses), ts)
-	log.Printf("CSV report written to %s", outPath)
+	for _, tt := range FleetTripTimes(sum.BusStats) {
+		addTripTimesRow(t, "fleet", "", tt, ts)
+	}
+	for _, a := range sum.ETAAccuracy {
+		t.add("section", "eta_accuracy", "horizon_min", fmt.Sprint(a.HorizonMin), "horizon_max_min", fmt.Sprint(a.HorizonMaxMin), "predictions", fmt.Sprint(a.Predictions), "mean_error_min", pr.FormatMinutes(a.MeanErrorMin, true), "mae_min", pr.FormatMinutes(a.MAEMin, true), "p90_abs_error_min", pr.FormatMinutes(a.P90AbsErrorMin, true), "timestamp", ts)
+	}
+	for _, h := range sum.StopHeadways {
+		t.add("section", "stop_headway", "stop_id", fmt.Sprint(h.StopID), "stop_name", h.Name, "direction", h.Direction, "headways", fmt.Sprint(h.Count), "mean_headway_min", pr.FormatMinutes(h.MeanMin, true), "stddev_headway_min", pr.FormatMinutes(h.StdDevMin, true), "headway_cv", fmt.Sprintf("%.3f", h.CV), "timestamp", ts)
+	}
+	for _, z := range sum.Zones {
+		t.add("section", "zone", "zone", z.Zone, "stops", fmt.Sprint(z.Stops), "arrivals", fmt.Sprint(z.Arrivals), "boarded", fmt.Sprint(z.Boarded), "alighted", fmt.Sprint(z.Alighted), "denied", fmt.Sprint(z.Denied), "ridership", fmt.Sprint(z.Ridership), "avg_wait_min", pr.FormatMinutes(z.AvgWaitMin, true), "departures", fmt.Sprint(z.Departures), "avg_load", fmt.Sprintf("%.2f", z.AvgLoad), "max_load", fmt.Sprint(z.MaxLoad), "avg_occupancy", fmt.Sprintf("%.3f", z.Occupancy), "timestamp", ts)
+	}
+	if err := t.writeTo(f); err != nil {
+		return "", err
+	}
+	if err := f.Commit(); err != nil {
+		return "", err
+	}
+	slog.Info("CSV report written", "path", outPath)
 	return outPath, nil
 }
 
+// busCounts returns boardings, alightings and peak load of b, preferring the recorded
+// stats; without them the peak load is unknown (empty).
+func busCounts(b *model.Bus, stats []BusStats) (boarded, alighted int, maxLoad string) {
+	if s, ok := busStatsOf(b, stats); ok {
+		return s.Boarded, s.Alighted, fmt.Sprint(s.MaxLoad)
+	}
+	return b.TotalBoarded, b.TotalAlighted, ""
+}
+
+// busStatsOf returns the recorded stats of b.
+func busStatsOf(b *model.Bus, stats []BusStats) (BusStats, bool) {
+	for _, s := range stats {
+		if s.BusID == b.ID {
+			return s, true
+		}
+	}
+	return BusStats{}, false
+}
+
+// timeShares returns the shares (0..1) of s's time in service spent driving, dwelling,
+// laying over and repositioning.
+func timeShares(s BusStats) (driving, dwell, layover, reposition float64) {
+	if s.InServiceSec <= 0 {
+		return 0, 0, 0, 0
+	}
+	return s.DrivingSec / s.InServiceSec, s.DwellSec / s.InServiceSec, s.LayoverSec / s.InServiceSec, s.RepositionSec / s.InServiceSec
+}
+
+// addUtilizationCells fills the time budget columns of the row just added from s.
+func addUtilizationCells(t *csvTable, s BusStats) {
+	pr := ReportPrecision
+	t.set("in_service_min", pr.FormatMinutes(s.InServiceSec/60, true), "driving_min", pr.FormatMinutes(s.DrivingSec/60, true), "dwell_min", pr.FormatMinutes(s.DwellSec/60, true),
+		"layover_min", pr.FormatMinutes(s.LayoverSec/60, true), "reposition_min", pr.FormatMinutes(s.RepositionSec/60, true), "utilization", fmt.Sprintf("%.3f", s.Utilization))
+}
+
+// addTripTimesRow appends a row of one direction's trip and cycle times.
+func addTripTimesRow(t *csvTable, scope, busID string, tt TripTimes, ts string) {
+	f2 := func(x float64) string { return ReportPrecision.FormatMinutes(x, true) }
+	t.add("section", "trip_times", "scope", scope, "bus_id", busID, "direction", tt.Direction, "trips", fmt.Sprint(tt.Trip.Count), "trip_min_min", f2(tt.Trip.MinMin), "trip_mean_min", f2(tt.Trip.MeanMin), "trip_max_min", f2(tt.Trip.MaxMin),
+		"cycles", fmt.Sprint(tt.Cycle.Count), "cycle_min_min", f2(tt.Cycle.MinMin), "cycle_mean_min", f2(tt.Cycle.MeanMin), "cycle_max_min", f2(tt.Cycle.MaxMin), "timestamp", ts)
+}
+
+// addExcessWaitRow appends one excess wait time row (scope overall or stop).
+func addExcessWaitRow(t *csvTable, scope string, e ExcessWait, ts string) {
+	f2 := func(x float64) string { return ReportPrecision.FormatMinutes(x, true) }
+	stopID := ""
+	if e.StopID != 0 {
+		stopID = fmt.Sprint(e.StopID)
+	}
+	t.add("section", "excess_wait", "scope", scope, "stop_id", stopID, "stop_name", e.Name, "boardings", fmt.Sprint(e.Boardings), "avg_wait_min", f2(e.AvgWaitMin), "scheduled_wait_min", f2(e.ScheduledWaitMin), "excess_wait_min", f2(e.ExcessWaitMin), "timestamp", ts)
+}
+
+// addWaitRows appends the percentile row and histogram rows for one wait scope.
+func addWaitRows(t *csvTable, scope, key string, wp WaitPercentiles, ts string) {
+	f2 := func(x float64) string { return ReportPrecision.FormatMinutes(x, true) }
+	t.add("section", "wait", "scope", scope, "key", key, "wait_count", fmt.Sprint(wp.Count), "wait_mean_min", f2(wp.Mean), "wait_p50_min", f2(wp.P50), "wait_p90_min", f2(wp.P90), "wait_p95_min", f2(wp.P95), "wait_max_min", f2(wp.Max), "timestamp", ts)
+	for _, b := range wp.Histogram {
+		hi := ""
+		if b.HiMin > 0 {
+			hi = fmt.Sprintf("%.0f", b.HiMin)
+		}
+		t.add("section", "wait_hist", "scope", scope, "key", key, "bin_lo_min", fmt.Sprintf("%.0f", b.LoMin), "bin_hi_min", hi, "bin_count", fmt.Sprint(b.Count), "timestamp", ts)
+	}
+}
+
+// sortedStopIDs returns map keys in ascending order for stable report output.
+func sortedStopIDs[V any](m map[int]V) []int {
+	ids := make([]int, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// timestampedPath resolves an output path: a directory gets "<prefix>-<ts><defaultExt>" inside it,
+// a file path gets the timestamp suffixed before its extension.
+func timestampedPath(path, prefix, defaultExt, ts string) string {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		return filepath.Join(path, fmt.Sprintf("%s-%s%s", prefix, ts, defaultExt))
+	}
+	ext := filepath.Ext(path)
+	base := path[:len(path)-len(ext)]
+	return fmt.Sprintf("%s-%s%s", base, ts, ext)
+}
+
+// TimestampedPath is timestampedPath for writers outside this package.
+func TimestampedPath(path, prefix, defaultExt, ts string) string {
+	return timestampedPath(path, prefix, defaultExt, ts)
+}
+
+// csvQuote wraps a free-text value so embedded commas and quotes survive CSV parsing.
+func csvQuote(v string) string {
+	return `"` + strings.ReplaceAll(v, `"`, `""`) + `"`
+}
+
 // PrintConsoleReport prints a human-readable report to stdout.
 func PrintConsoleReport(buses []*model.Bus, sum ReportSummary) {
 	totalCost := 0.0
 	totalDist := 0.0
-	fmt.Println("=== Simulation Report ===")
+	if sum.Label != "" {
+		fmt.Printf("=== Simulation Report (%s) ===\n", sum.Label)
+	} else {
+		fmt.Println("=== Simulation Report ===")
+	}
+	if q := sum.Quality; q != nil {
+		fmt.Printf("Service quality score: %.1f / 100 (wait %.2f, crowding %.2f, reliability %.2f)\n", q.Score, q.Wait, q.Crowding, q.Reliability)
+	}
+	if sum.Stalled {
+		fmt.Printf("Run stalled: %s\n", sum.Diagnostic)
+	}
+	if sum.Capacity != "" {
+		fmt.Printf("Capacity warning: %s\n", sum.Capacity)
+	}
+	if sum.Aborted != "" {
+		fmt.Printf("Run aborted (partial report): %s\n", sum.Aborted)
+	}
+	if sum.Criterion != "" {
+		fmt.Printf("Stop criterion: %s (ended by %s)\n", sum.Criterion, sum.EndedBy)
+	}
 	fmt.Printf("Buses on route: %d\n", len(buses))
 	fmt.Printf("Passengers generated: %d\n", sum.Generated)
 	fmt.Printf("Passengers served: %d\n", sum.Served)
-	fmt.Printf("Average wait: %.2f minutes\n", sum.AvgWaitMin)
-	round2 := func(x float64) float64 { return math.Round(x*100) / 100 }
+	pr := ReportPrecision
+	fmt.Printf("Average wait: %s minutes\n", pr.FormatMinutes(sum.AvgWaitMin, false))
+	if len(sum.Directions) > 0 {
+		fmt.Println("By direction:")
+		for _, d := range sum.Directions {
+			fmt.Printf("  %-9s generated=%d served=%d avg_wait=%s min passenger_km=%.1f\n", d.Direction, d.Generated, d.Served, pr.FormatMinutes(d.AvgWaitMin, false), d.PassengerKM)
+		}
+	}
+	if ew := sum.ExcessWait; ew != nil && ew.Overall.Boardings > 0 {
+		o := ew.Overall
+		fmt.Printf("Excess wait: %s minutes (avg wait %s - scheduled %s)\n", pr.FormatMinutes(o.ExcessWaitMin, false), pr.FormatMinutes(o.AvgWaitMin, false), pr.FormatMinutes(o.ScheduledWaitMin, false))
+	}
+	if sum.Wait != nil {
+		printWaitConsole(*sum.Wait)
+	}
+	hours := ServiceHours(sum.BusStats)
 	for _, b := range buses {
-		d := round2(sum.BusDistance[b.ID])
-		c := 0.0
+		name := ""
 		if b.Type != nil {
-			c = round2(float64(b.Type.CostPerKm) * d)
+			name = b.Type.Name
 		}
+		d, c := pr.BusCost(sum.BusDistance[b.ID], hours[b.ID], b.Type, false)
 		totalDist += d
 		totalCost += c
-		name := ""
-		if b.Type != nil {
-			name = b.Type.Name
+		boarded, alighted, maxLoad := busCounts(b, sum.BusStats)
+		if maxLoad == "" {
+			maxLoad = "n/a"
+		}
+		util := ""
+		if s, ok := busStatsOf(b, sum.BusStats); ok {
+			util = fmt.Sprintf(" utilization=%.0f%%", s.Utilization*100)
+		}
+		fmt.Printf("Bus %d (%s, %s) distance=%s km cost=%s boarded=%d alighted=%d max_load=%s%s\n", b.ID, b.Direction, name, pr.FormatKM(d, false), pr.FormatCurrency(c, false), boarded, alighted, maxLoad, util)
+	}
+	if f := FleetTime(sum.BusStats); f.InServiceSec > 0 {
+		drive, dwell, layover, repos := timeShares(f)
+		fmt.Printf("Fleet time: %.1f bus-hours, driving %.0f%%, dwelling %.0f%%, layover %.0f%%, repositioning %.0f%% (utilization %.0f%%)\n", f.InServiceSec/3600, drive*100, dwell*100, layover*100, repos*100, f.Utilization*100)
+	}
+	if tts := FleetTripTimes(sum.BusStats); len(tts) > 0 {
+		fmt.Println("Trip and cycle times (min / mean / max):")
+		for _, tt := range tts {
+			cycle := "no full cycle"
+			if c := tt.Cycle; c.Count > 0 {
+				cycle = fmt.Sprintf("%d cycles %s / %s / %s min", c.Count, pr.FormatMinutes(c.MinMin, false), pr.FormatMinutes(c.MeanMin, false), pr.FormatMinutes(c.MaxMin, false))
+			}
+			tr := tt.Trip
+			fmt.Printf("  %s: %d trips %s / %s / %s min, %s\n", tt.Direction, tr.Count, pr.FormatMinutes(tr.MinMin, false), pr.FormatMinutes(tr.MeanMin, false), pr.FormatMinutes(tr.MaxMin, false), cycle)
+		}
+	}
+	if types := TypeBreakdown(buses, sum.BusStats, sum.BusDistance, false); len(types) > 0 {
+		fmt.Println("Per bus type:")
+		for _, ts := range types {
+			fmt.Printf("  %s x%d: distance=%s km hours=%.1f cost=%s (distance %s, time %s, fixed %s) carried=%d avg_load=%.1f occupancy=%.0f%%\n", ts.TypeName, ts.Buses, pr.FormatKM(ts.DistanceKM, false), ts.ServiceHours, pr.FormatCurrency(ts.Cost, false),
+				pr.FormatCurrency(ts.DistanceCost, false), pr.FormatCurrency(ts.TimeCost, false), pr.FormatCurrency(ts.FixedCost, false), ts.Boarded, ts.AvgLoad, ts.AvgOccupancy*100)
+		}
+	}
+	if len(sum.Anomalies) > 0 {
+		fmt.Printf("Anomalies (%d):\n", len(sum.Anomalies))
+		for _, a := range sum.Anomalies {
+			fmt.Printf("  %s %s: %s\n", a.Time.Format("15:04:05"), a.Kind, a.Message)
+		}
+	}
+	if sh := sum.Shifts; sh != nil {
+		fmt.Printf("Shifts: %d drivers, %d breaks, %d reliefs, %s min out of service (availability %.1f%%), %d violations\n", sh.Drivers, sh.Breaks, sh.Reliefs, pr.FormatMinutes(sh.OutOfServiceMin, false), sh.Availability*100, len(sh.Violations))
+		for _, v := range sh.Violations {
+			fmt.Printf("  %s bus %d driver %d: %s %.0f min (limit %.0f)\n", v.Time.Format("15:04:05"), v.BusID, v.Driver, v.Kind, v.ActualMin, v.LimitMin)
+		}
+	}
+	if len(sum.Closures) > 0 {
+		fmt.Printf("Stop closures (%d):\n", len(sum.Closures))
+		for _, c := range sum.Closures {
+			fmt.Printf("  %s stop %d %s: %s min closed, %d redistributed, %d unserved, %d buses skipped, %d riders carried past\n", c.ClosedAt.Format("15:04:05"), c.StopID, c.Name, pr.FormatMinutes(c.DurationMin, false), c.Redistributed, c.Unserved, c.Skips, c.CarriedPast)
+		}
+	}
+	if len(sum.Periods) > 0 {
+		fmt.Printf("Periods (%s-%s):\n", sum.Periods[0].Start, sum.Periods[len(sum.Periods)-1].End)
+		for _, p := range sum.Periods {
+			fav := p.Favored
+			if fav == "" {
+				fav = "balanced"
+			}
+			fmt.Printf("  %s %s-%s x%.2f %s: generated=%d (out %d, in %d) boarded=%d served=%d avg_wait=%s min p90=%s min load_factor=%.1f%%\n", p.Name, p.Start, p.End, p.Multiplier, fav, p.Generated, p.OutboundGenerated, p.InboundGenerated, p.Boarded, p.Served, pr.FormatMinutes(p.Wait.Mean, false), pr.FormatMinutes(p.Wait.P90, false), p.LoadFactor*100)
+		}
+	}
+	if len(sum.Platforms) > 0 {
+		fmt.Println("Platform capacity:")
+		for _, p := range sum.Platforms {
+			fmt.Printf("  stop %d %s: capacity %d, peak %d waiting, %d overflows for %s min, %d spilled, %d received\n", p.StopID, p.Name, p.Capacity, p.PeakWaiting, p.Overflows, pr.FormatMinutes(p.OverflowMin, false), p.Spilled, p.Received)
+		}
+	}
+	if len(sum.Berths) > 0 {
+		fmt.Println("Berths:")
+		for _, b := range sum.Berths {
+			fmt.Printf("  stop %d %s: %d berths %.0f%% used, %d docks, %d queued for %s min (longest %s min, up to %d buses)\n", b.StopID, b.Name, b.Berths, b.Utilization*100, b.Docks, b.Queued, pr.FormatMinutes(b.QueueMin, false), pr.FormatMinutes(b.MaxWaitMin, false), b.MaxQueue)
+		}
+	}
+	if len(sum.Turnarounds) > 0 {
+		fmt.Println("Terminal turnarounds:")
+		for _, y := range sum.Turnarounds {
+			bays := "unlimited bays"
+			if y.Bays > 0 {
+				bays = fmt.Sprintf("%d bays %.0f%% used", y.Bays, y.Utilization*100)
+			}
+			fmt.Printf("  %s: %s, %d turns, %d waited for %s min (longest %s min, up to %d buses), %s min recovery layover\n", y.Name, bays, y.Turns, y.Queued, pr.FormatMinutes(y.WaitMin, false), pr.FormatMinutes(y.MaxWaitMin, false), y.MaxQueue, pr.FormatMinutes(y.RecoveryMin, false))
+		}
+	}
+	if len(sum.ETAAccuracy) > 0 {
+		fmt.Println("ETA accuracy (actual - predicted):")
+		for _, a := range sum.ETAAccuracy {
+			window := fmt.Sprintf("%g+ min ahead", a.HorizonMin)
+			if a.HorizonMaxMin > 0 {
+				window = fmt.Sprintf("%g-%g min ahead", a.HorizonMin, a.HorizonMaxMin)
+			}
+			fmt.Printf("  %s: %d predictions, mean error %s min, MAE %s min, P90 |error| %s min\n", window, a.Predictions, pr.FormatMinutes(a.MeanErrorMin, false), pr.FormatMinutes(a.MAEMin, false), pr.FormatMinutes(a.P90AbsErrorMin, false))
+		}
+	}
+	if len(sum.Headway) > 0 {
+		fmt.Println("Headway adherence:")
+		for _, h := range sum.Headway {
+			fmt.Printf("  %s: %.0f%% of %d headways within ±%.0f%% of %.1f min (bunched=%d gapped=%d mean=%.1f min)\n", h.Period, h.Adherence*100, h.Headways, h.Tolerance*100, h.TargetMin, h.Bunched, h.Gapped, h.MeanMin)
+		}
+	}
+	for _, dir := range []string{"outbound", "inbound"} {
+		n, cvSum, worst := 0, 0.0, StopHeadway{}
+		for _, h := range sum.StopHeadways {
+			if h.Direction != dir {
+				continue
+			}
+			n++
+			cvSum += h.CV
+			if h.CV > worst.CV {
+				worst = h
+			}
+		}
+		if n > 0 {
+			fmt.Printf("Headway regularity (%s): mean CV %.2f over %d stops; least regular %s (mean %s min, CV %.2f)\n", dir, cvSum/float64(n), n, worst.Name, pr.FormatMinutes(worst.MeanMin, false), worst.CV)
+		}
+	}
+	for _, pk := range sum.PeakLoad {
+		fmt.Printf("Peak load point (%s): %s -> %s carried=%d avg_load=%.1f max_load=%d occupancy=%.0f%%\n", pk.Direction, pk.FromName, pk.ToName, pk.Carried, pk.AvgLoad, pk.MaxLoad, pk.AvgOccupancy*100)
+	}
+	if len(sum.Zones) > 0 {
+		fmt.Println("Per zone:")
+		for _, z := range sum.Zones {
+			fmt.Printf("  %s (%d stops): ridership=%d boarded=%d alighted=%d avg_wait=%s min avg_load=%.1f max_load=%d occupancy=%.0f%%\n", z.Zone, z.Stops, z.Ridership, z.Boarded, z.Alighted, pr.FormatMinutes(z.AvgWaitMin, false), z.AvgLoad, z.MaxLoad, z.Occupancy*100)
+		}
+	}
+	fmt.Printf("Total distance: %s km\n", pr.FormatKM(totalDist, false))
+	fmt.Printf("Total operating cost: %s\n", pr.FormatCurrency(totalCost, false))
+	if sum.EnergyKWh > 0 {
+		perKM := 0.0
+		if totalDist > 0 {
+			perKM = sum.EnergyKWh / totalDist
+		}
+		fmt.Printf("Running energy: %.1f kWh (%.2f kWh/km) over %s bus-minutes moving; CO2 %.1f kg\n", sum.EnergyKWh, perKM, pr.FormatMinutes(sum.RunningMin, false), sum.CO2Kg)
+	}
+}
+
+// printWaitConsole prints wait percentiles overall, per direction, per stop and the overall histogram.
+func printWaitConsole(w WaitDistribution) {
+	line := func(label string, wp WaitPercentiles) {
+		m := func(x float64) string { return ReportPrecision.FormatMinutes(x, false) }
+		fmt.Printf("  %-10s n=%-6d P50=%s P90=%s P95=%s max=%s min\n", label, wp.Count, m(wp.P50), m(wp.P90), m(wp.P95), m(wp.Max))
+	}
+	fmt.Println("Wait percentiles:")
+	line("overall", w.Overall)
+	for _, dir := range []string{"outbound", "inbound"} {
+		if wp, ok := w.ByDirection[dir]; ok {
+			line(dir, wp)
+		}
+	}
+	for _, sid := range sortedStopIDs(w.ByStop) {
+		line(fmt.Sprintf("stop %d", sid), w.ByStop[sid])
+	}
+	fmt.Println("Wait histogram (overall):")
+	for _, b := range w.Overall.Histogram {
+		label := fmt.Sprintf("%.0f-%.0f", b.LoMin, b.HiMin)
+		if b.HiMin == 0 {
+			label = fmt.Sprintf("%.0f+", b.LoMin)
 		}
-		fmt.Printf("Bus %d (%s, %s) distance=%.2f km cost=%.2f\n", b.ID, b.Direction, name, d, c)
+		fmt.Printf("  %-6s min: %d\n", label, b.Count)
 	}
-	fmt.Printf("Total distance: %.2f km\n", totalDist)
-	fmt.Printf("Total operating cost: %.2f\n", totalCost)
 }
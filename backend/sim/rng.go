@@ -0,0 +1,37 @@
+package sim
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// RunRNG is the random number hierarchy of a run, derived from its one seed. The
+// demand generator draws from the engine's RNG, seeded with the seed itself (as in
+// the batch driver); every other consumer gets its own sub-stream, so what one draws
+// never depends on how far another has got, whatever order goroutines run in.
+type RunRNG struct {
+	seed int64
+}
+
+// NewRunRNG returns the hierarchy of seed.
+func NewRunRNG(seed int64) RunRNG { return RunRNG{seed: seed} }
+
+// Stream returns a new generator for sub-stream (name, id); equal arguments give
+// equal sequences.
+func (r RunRNG) Stream(name string, id int) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	x := splitmix(uint64(r.seed) ^ splitmix(h.Sum64()^uint64(id)))
+	return rand.New(rand.NewSource(int64(x)))
+}
+
+// Bus returns the sub-stream of bus id.
+func (r RunRNG) Bus(id int) *rand.Rand { return r.Stream("bus", id) }
+
+// splitmix is the SplitMix64 finalizer, spreading nearby inputs over the whole range.
+func splitmix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
@@ -1,15 +1,23 @@
 package sim
 
 import (
-	"brt08/backend/data"
 	"brt08/backend/model"
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"math"
 	"math/rand"
+	"runtime/debug"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("brt08/backend/sim")
+
 // Control exposes per-connection tunables.
 type Control interface {
 	Speed() float64
@@ -41,31 +49,84 @@ func (s StaticControl) ArrivalFactor() float64 {
 	return s.ArrivalMult
 }
 
-// Runner coordinates the simulation and emits events on the returned channel.
-// It returns a stop function to cancel, and a Wait that blocks for completion.
-func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambda float64, opts struct {
+// RunnerOptions configures a single StartRunner invocation.
+type RunnerOptions struct {
 	PeriodID              int
 	PassengerCap          int
 	MorningTowardKivukoni bool
 	DirBias               float64
 	SpatialGradient       float64
 	BaselineDemand        float64
+	DemandProfile         string                    // "flat" (default) or "period"
+	StallTimeout          time.Duration             // end the run when waiting passengers see no boarding for this long (0 = never)
+	GroupSizes            GroupSizeDist             // compound arrivals; zero value = singles
+	Population            int                       // activity-based synthetic population size (0 = independent Poisson arrivals)
+	Seeding               SeedConfig                // backdating of initial passengers
+	RecordPassengers      bool                      // keep every passenger for the journey log (returned in DoneEvent)
+	RecordDecisions       bool                      // keep the dispatch decision audit trail (returned in DoneEvent)
+	Traffic               TravelTimeProvider        // optional external segment travel times (nil = internal speed model)
+	MetricsInterval       time.Duration             // emit a MetricsEvent every this much sim time (0 = off)
+	ETAInterval           time.Duration             // emit a StopETAEvent for every stop this often in sim time (0 = off)
+	Live                  *LiveStats                // if set, bound to this run's per-stop, per-bus and headway aggregates
+	Trajectories          *TrajectoryRecorder       // if set, receives every bus position
+	Resync                <-chan struct{}           // each receive emits a StateEvent snapshot
+	Closures              <-chan StopClosure        // each receive closes or reopens a stop
+	BusCommands           <-chan BusCommand         // each receive queues a command for one bus
+	DemandChanges         <-chan DemandChange       // each receive switches the period or demand shape from the next generator step
+	Injections            <-chan PassengerInjection // each receive enqueues passengers at a stop, past any passenger cap
+	Platforms             PlatformConfig            // platform capacities and spilling (zero = stops' own capacities, no spill)
+	Berths                BerthConfig               // berths per station (zero = stops' own berths, else unlimited)
+	Turnaround            TurnaroundConfig          // terminal turnaround bays, time and recovery (zero = unlimited, DefaultTurnaroundTime, no layover)
 	TraceBusID            int
 	ConnID                string
 	Start                 time.Time
-}, ctrl Control) (events <-chan Event, stop func(), wait func()) {
-	ch := make(chan Event, 256)
+	Criterion             StopCriterion // end after a sim-time horizon or trip count (zero = passenger cap only)
+	Day                   DaySchedule   // chain periods through one run from the first one's start; PeriodID is ignored (zero = single period)
+	Headway               HeadwayConfig // per-period target headways for adherence (zero = defaults)
+	Anomaly               AnomalyConfig // thresholds of the anomaly detectors run on each heartbeat (zero = defaults)
+	Shifts                ShiftConfig   // driver duty and driving limits, breaks and reliefs (zero = no shifts)
+	Clock                 Clock         // time source (nil = a PacedClock at Start following ctrl.Speed)
+	Backpressure          Backpressure  // what sends do while the consumer lags (empty = BackpressureBlock)
+	EventBuffer           int           // event channel capacity (0 = DefaultEventBuffer)
+	SlowConsumerTimeout   time.Duration // longest send wait under BackpressureDisconnect (0 = DefaultSlowConsumerTimeout)
+}
+
+// Runner coordinates the simulation and emits events on the returned channel.
+// Cancelling ctx (or calling the returned stop function) ends the run early; the
+// channel still delivers a final DoneEvent before closing (intermediate events after
+// cancellation may be dropped), so consumers must drain it until it is closed. Wait
+// blocks for the buses.
+// ctx also parents the run's telemetry spans.
+func StartRunner(ctx context.Context, route *model.Route, fleet []*model.Bus, engineSeed int64, lambda float64, opts RunnerOptions, ctrl Control) (events <-chan Event, stop func(), wait func()) {
+	ctx, runSpan := tracer.Start(ctx, "runner", trace.WithAttributes(attribute.String("conn_id", opts.ConnID), attribute.Int("passenger_cap", opts.PassengerCap), attribute.Int("buses", len(fleet))))
+	ctx, stop = context.WithCancel(ctx)
+	policy := newEventPolicy(opts, stop)
+	ch := make(chan Event, policy.buffer)
 	var wg sync.WaitGroup
-	stopCh := make(chan struct{})
-	var stopOnce sync.Once
-	stop = func() { stopOnce.Do(func() { close(stopCh) }) }
 	wait = func() { wg.Wait() }
 
 	// internal helpers
-	var mu sync.Mutex // protect engine, route queues, counters, and shared aggregates
+	// protect engine, route queues, counters, and shared aggregates
+	var mu sync.Mutex
+	geom := route.Geometry() // segment paths (pins included) shared by all buses
+	var lastMove sync.Map    // bus ID -> latest MoveEvent, for resync snapshots
+	// send delivers an intermediate event under the backpressure policy, or drops it
+	// once the run is cancelled so that no goroutine (some send while holding mu) can
+	// block on a consumer that stopped reading. Only the final DoneEvent is sent
+	// unconditionally; consumers must drain the channel until it is closed.
+	send := func(e Event) { policy.send(ctx, ch, e) }
+	move := func(e MoveEvent) {
+		lastMove.Store(e.BusID, e)
+		send(e)
+	}
 
-	// Create a base RNG for schedule decisions
-	baseRNG := rand.New(rand.NewSource(engineSeed ^ 0x539f0a17))
+	// Random streams: the engine's RNG feeds the demand generator, each bus draws its
+	// initial direction and launch jitter from its own stream, injections from theirs
+	rngs := NewRunRNG(engineSeed)
+	busRNG := make(map[int]*rand.Rand, len(fleet))
+	for _, b := range fleet {
+		busRNG[b.ID] = rngs.Bus(b.ID)
+	}
 
 	// Create a dummy bus for the simulator utilities (poisson, passenger creation, counters)
 	var dummy *model.Bus
@@ -77,49 +138,118 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 		dummy = &model.Bus{ID: 0, Type: bt, RouteID: route.ID, CurrentStopID: route.Stops[0].ID, Direction: "outbound", AverageSpeedKmph: 28}
 	}
 	engine := NewSimulator(route, dummy, engineSeed, lambda, opts.Start)
+	clock := opts.Clock
+	if clock == nil {
+		clock = NewPacedClock(opts.Start, ctrl.Speed)
+	}
+	engine.Clock = clock
 	engine.PeriodID = opts.PeriodID
+	if opts.Day.Enabled() {
+		engine.PeriodID = opts.Day.Periods[0].ID
+		if opts.Criterion.Duration == 0 {
+			opts.Criterion.Duration = opts.Day.Duration()
+		}
+	}
 	engine.TotalPassengerCap = opts.PassengerCap
 	engine.MorningTowardKivukoni = opts.MorningTowardKivukoni
 	engine.DirectionBiasFactor = opts.DirBias
+	engine.RecordPassengers = opts.RecordPassengers
+	engine.Seeding = opts.Seeding
 
 	// Aggregates
 	var cumServed int64
 	var waitSumMin float64
 	var waitCount int64
+	var boardings int64
 	busDistance := make(map[int]float64)
+	dwellRec := NewDwellRecorder()
+	zoneRec := NewZoneRecorder(route)
+	dirRec := NewDirectionRecorder(route)
+	loadRec := NewLoadRecorder(route)
+	headwayRec := NewHeadwayRecorder(opts.Headway, engine.PeriodID, opts.Start)
+	ewtRec := NewExcessWaitRecorder(headwayRec)
+	dayRec := NewDayRecorder(opts.Day, opts.Start, opts.MorningTowardKivukoni)
+	anomalies := NewAnomalyDetector(opts.Anomaly)
+	shifts := NewShiftTracker(opts.Shifts)
+	var lastClk time.Time // latest bus clock at exit, where open shifts are closed
+	waitStats := NewWaitStats()
+	stopWait := NewRollingStopWait(StopWaitWindow)
+	busStats := NewBusStatsRecorder()
+	closures := NewStopClosures(route)
+	controls := NewBusControls(route, fleet)
+	platforms := NewPlatformMonitor(route, opts.Platforms)
+	if platforms != nil {
+		platforms.Closed = closures.Closed
+	}
+	berths := NewBerthTracker(route, opts.Berths)
+	yard := NewTurnaroundYard(route, opts.Turnaround)
+	etas := NewETATracker(route)
+	etas.Turnaround = opts.Turnaround.Duration()
+	var decisions *DecisionLog
+	if opts.RecordDecisions {
+		decisions = NewDecisionLog()
+	}
+	if opts.Live != nil {
+		opts.Live.bind(func() []StopStats {
+			mu.Lock()
+			defer mu.Unlock()
+			return engine.StopStatsSnapshot()
+		}, func() []BusStats {
+			mu.Lock()
+			defer mu.Unlock()
+			return busStats.Snapshot(fleet, busDistance)
+		}, func() []StopHeadway {
+			mu.Lock()
+			defer mu.Unlock()
+			return headwayRec.ByStop(route)
+		}, func(stopID, limit int) (StopETA, bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			return etas.Predict(stopID, limit)
+		})
+	}
+	// Stall state (set by the watchdog, read under mu)
+	stalled := false
+	stallDiagnostic := ""
+	finished := false
+	endedBy := "" // stop criterion met ("duration" or "trips")
+	trips := 0    // completed one-way trips across the fleet
 
-	// simulate time speed mapping (simulation seconds to real seconds)
-	const simSecToReal = 0.2
-	waitSim := func(simDur time.Duration) bool {
-		remaining := simDur
-		for remaining > 0 {
-			// allow up to 500ms chunks of sim time between checks
-			chunk := remaining
-			if chunk > 500*time.Millisecond {
-				chunk = 500 * time.Millisecond
-			}
-			cur := ctrl.Speed()
-			if cur <= 0 {
-				cur = 1
-			}
-			realSleep := time.Duration(float64(chunk) * simSecToReal / cur)
-			select {
-			case <-stopCh:
-				return false
-			case <-time.After(realSleep):
-			}
-			remaining -= chunk
-		}
-		return true
+	// The buses, the generator and the periodic reporters are actors of sched, taking
+	// turns in simulated-time order so a seeded run always emits the same events.
+	// spawn starts fn as an actor, first waiting for its turn; its waitSim paces simDur
+	// of simulated time, false once the run is cut off (clock.Cut is called with endedBy
+	// so paced waits such as launch delays and moves end at once). Actors join before
+	// the run starts or on a turn, so they join in a fixed order.
+	sched := NewScheduler(ctx, clock, opts.Start)
+	spawn := func(fn func(waitSim func(simDur time.Duration) bool)) {
+		act := sched.Join(0)
+		go func() {
+			defer act.Leave()
+			ok := act.Begin()
+			fn(func(simDur time.Duration) bool { return ok && act.Sleep(simDur) })
+		}()
 	}
+	// running buses and generator, checked by the closing actor on its turns (under mu)
+	busesRunning, genRunning := 0, false
 
 	// Completion logic mirrors server
 	isDone := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if stalled {
+			return true
+		}
+		if endedBy == "" {
+			endedBy = opts.Criterion.Reached(0, trips)
+		}
+		if endedBy != "" {
+			clock.Cut()
+			return true
+		}
 		if opts.PassengerCap <= 0 {
 			return false
 		}
-		mu.Lock()
-		defer mu.Unlock()
 		inSystem := 0
 		for _, b := range fleet {
 			inSystem += b.PassengersOnboard
@@ -133,20 +263,21 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 		if engine.GeneratedPassengers >= opts.PassengerCap && inSystem == 0 {
 			return true
 		}
-		if engine.GeneratedPassengers == int(cumServed) && inSystem == 0 {
+		// A population's next trips may be hours away, so an empty system is not the end
+		if opts.Population == 0 && engine.GeneratedPassengers == int(cumServed)+closures.Unserved() && inSystem == 0 {
 			return true
 		}
 		return false
 	}
 
-	// Internal completion should NOT close stopCh (reserved for external cancel).
-	// Use isDone() checks in loops to exit gracefully; keep stopCh only for external stop.
+	// Internal completion should NOT cancel ctx (reserved for external cancel).
+	// Use isDone() checks in loops to exit gracefully; ctx is only for external stop.
 	signalStopIfDone := func() {}
 
 	// Demand configuration
-	mult := data.TimePeriodMultiplier[engine.PeriodID]
-	if mult == 0 {
-		mult = 1
+	profile, profileMax := NewDemandProfile(opts.DemandProfile, engine.PeriodID, opts.Start)
+	if opts.Day.Enabled() {
+		profile, profileMax = opts.Day.Profile()
 	}
 	totalTarget := opts.PassengerCap
 	initialSeedFraction := 0.05
@@ -155,7 +286,14 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 		seedTarget = int(float64(totalTarget) * initialSeedFraction)
 	}
 	favOut, favIn := FavoredDirections(engine.PeriodID, opts.MorningTowardKivukoni)
-	cfg := DemandConfig{FavoredOutbound: favOut, FavoredInbound: favIn, SpatialGradient: opts.SpatialGradient, BaselineDemand: opts.BaselineDemand, DirBias: opts.DirBias}
+	cfg := DemandConfig{FavoredOutbound: favOut, FavoredInbound: favIn, SpatialGradient: opts.SpatialGradient, BaselineDemand: opts.BaselineDemand, DirBias: opts.DirBias, GroupSizes: opts.GroupSizes}
+	groupMean := opts.GroupSizes.Mean()
+	// Activity-based demand replaces Poisson arrivals (and initial seeding) with the population's trips
+	var pop *Population
+	if opts.Population > 0 {
+		pop = NewPopulation(engine.RNG, route, opts.Population, engine.PeriodID, opts.MorningTowardKivukoni, opts.Start, cfg)
+		seedTarget = 0
+	}
 
 	// Initial seed
 	if seedTarget > 0 {
@@ -163,21 +301,98 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 		SeedInitial(engine, route, opts.Start, seedTarget, totalTarget, cfg)
 		mu.Unlock()
 	}
-	for _, st := range route.Stops {
-		ch <- StopUpdateEvent{StopID: st.ID, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated}
-	}
+	send(InitialState(route, engine))
 
 	// Emit init event
-	ch <- InitEvent{Time: engine.Now, ConnID: opts.ConnID, Generated: engine.GeneratedPassengers, OutboundGen: engine.OutboundGenerated, InboundGen: engine.InboundGenerated, AvgWaitMin: 0.0, ArrivalFactor: ctrl.ArrivalFactor()}
+	send(InitEvent{Time: engine.Now(), ConnID: opts.ConnID, Generated: engine.GeneratedPassengers, OutboundGen: engine.OutboundGenerated, InboundGen: engine.InboundGenerated, AvgWaitMin: 0.0, ArrivalFactor: ctrl.ArrivalFactor()})
+	// Peak-rate demand against the fleet's carrying capacity (population trips are not a rate)
+	if pop == nil {
+		if c := CheckCapacity(route, fleet, lambda*profileMax*ctrl.ArrivalFactor(), cfg); c.Exceeded {
+			slog.Warn("corridor capacity exceeded", "conn", opts.ConnID, "note", c.Note())
+			send(CapacityWarningEvent{Check: c})
+		}
+	}
+
+	// clearClosed applies the closure policy to new arrivals at closed stops, adding the
+	// stops they walked to. Called with mu held.
+	clearClosed := func(updated map[int]struct{}) {
+		for _, sid := range sortedStopIDs(updated) {
+			if closures.Closed(sid) {
+				_, _, touched := closures.Clear(sid, engine.Now())
+				for _, t := range touched {
+					updated[t] = struct{}{}
+				}
+			}
+		}
+	}
+
+	// checkPlatform compares stop sid with its platform capacity at now, reporting an
+	// overflow, and returns the stops passengers spilled to. Called with mu held.
+	checkPlatform := func(sid int, now time.Time) []int {
+		ev, ok, touched := platforms.Check(sid, now)
+		if ok {
+			send(ev)
+		}
+		return touched
+	}
+	checkPlatforms := func(updated map[int]struct{}, now time.Time) {
+		for _, sid := range sortedStopIDs(updated) {
+			for _, t := range checkPlatform(sid, now) {
+				updated[t] = struct{}{}
+			}
+		}
+	}
+	// the initial passengers may already overflow platforms
+	if seedTarget > 0 && platforms != nil {
+		mu.Lock()
+		for _, st := range route.Stops {
+			for _, sid := range checkPlatform(st.ID, opts.Start) {
+				to := route.GetStop(sid)
+				send(StopUpdateEvent{StopID: sid, OutboundQueue: len(to.OutboundQueue), InboundQueue: len(to.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+			}
+		}
+		mu.Unlock()
+	}
 
 	// Start generator goroutine if needed
 	var genWg sync.WaitGroup
+	// switchDemand applies a live demand change at at: a new period starts then, with its
+	// multiplier and favored direction. Called with mu held.
+	switchDemand := func(dc DemandChange, at time.Time) {
+		if dc.PeriodID != 0 && dc.PeriodID != engine.PeriodID {
+			engine.PeriodID = dc.PeriodID
+			profile, profileMax = profileFrom(opts.DemandProfile, dc.PeriodID, at.Sub(opts.Start))
+			cfg.FavoredOutbound, cfg.FavoredInbound = FavoredDirections(dc.PeriodID, opts.MorningTowardKivukoni)
+			send(PeriodChangeEvent{Time: at, PeriodID: dc.PeriodID, Name: periodName(dc.PeriodID), Clock: clockString(int(clockMinutes(at))), Multiplier: periodMultiplier(dc.PeriodID), Favored: favoredName(dc.PeriodID, opts.MorningTowardKivukoni)})
+		}
+		if dc.DirBias != nil {
+			cfg.DirBias = *dc.DirBias
+			engine.DirectionBiasFactor = *dc.DirBias
+		}
+		if dc.SpatialGradient != nil {
+			cfg.SpatialGradient = *dc.SpatialGradient
+		}
+		slog.Info("demand change", "conn", opts.ConnID, "period", engine.PeriodID, "dir_bias", cfg.DirBias, "spatial_gradient", cfg.SpatialGradient)
+		send(DemandChangeEvent{Time: at, PeriodID: engine.PeriodID, Period: periodName(engine.PeriodID), Multiplier: profile(at.Sub(opts.Start)), Favored: favoredName(engine.PeriodID, opts.MorningTowardKivukoni), DirBias: cfg.DirBias, SpatialGradient: cfg.SpatialGradient})
+		if pop == nil {
+			if c := CheckCapacity(route, fleet, lambda*profileMax*ctrl.ArrivalFactor(), cfg); c.Exceeded {
+				send(CapacityWarningEvent{Check: c})
+			}
+		}
+	}
+
 	genStarted := false
 	if totalTarget == 0 || engine.GeneratedPassengers < totalTarget {
 		genStarted = true
 		genWg.Add(1)
-		go func() {
+		genRunning = true
+		spawn(func(waitSim func(time.Duration) bool) {
 			defer genWg.Done()
+			defer func() {
+				mu.Lock()
+				genRunning = false
+				mu.Unlock()
+			}()
 			simStep := 1 * time.Second
 			genNow := opts.Start
 			for {
@@ -188,38 +403,300 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 					return
 				}
 				mu.Lock()
-				if totalTarget > 0 && engine.GeneratedPassengers >= totalTarget {
+				if stalled || endedBy != "" || (totalTarget > 0 && engine.GeneratedPassengers >= totalTarget) {
 					mu.Unlock()
 					return
 				}
-				genNow = genNow.Add(simStep) // advance generator clock in fixed steps
-				stepMin := simStep.Minutes()
+				if ev, ok := dayRec.Advance(genNow, engine, &cfg); ok {
+					send(ev)
+				}
+				select {
+				case dc := <-opts.DemandChanges:
+					switchDemand(dc, genNow)
+				default:
+				}
+				if pop != nil {
+					stepEnd := genNow.Add(simStep)
+					updated := GeneratePopulationTrips(engine, route, pop, genNow, stepEnd, totalTarget)
+					genNow = stepEnd
+					clearClosed(updated)
+					checkPlatforms(updated, genNow)
+					for _, sid := range sortedStopIDs(updated) {
+						if st := route.GetStop(sid); st != nil {
+							send(StopUpdateEvent{StopID: sid, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+						}
+					}
+					mu.Unlock()
+					continue
+				}
+				// Sample continuous arrival instants over the next step by thinning;
+				// the step only paces real time and picks up live arrival factor changes.
 				arrMult := ctrl.ArrivalFactor()
-				mean := lambda * float64(mult) * stepMin * arrMult
-				count := engine.PoissonPublic(mean)
-				if totalTarget > 0 {
-					remaining := totalTarget - engine.GeneratedPassengers
-					if remaining < 0 {
-						remaining = 0
+				proc := NHPP{
+					RNG:     engine.RNG,
+					RateMax: lambda * profileMax * arrMult / groupMean,
+					Rate:    func(t time.Time) float64 { return lambda * profile(t.Sub(opts.Start)) * arrMult / groupMean },
+				}
+				stepEnd := genNow.Add(simStep)
+				arrivals := proc.Arrivals(genNow, stepEnd)
+				genNow = stepEnd
+				updated := make(map[int]struct{})
+				for _, at := range arrivals {
+					if totalTarget > 0 && engine.GeneratedPassengers >= totalTarget {
+						break
 					}
-					if count > remaining {
-						count = remaining
+					for sid := range GenerateBatch(engine, route, 1, at, totalTarget, cfg) {
+						updated[sid] = struct{}{}
 					}
 				}
-				if count > 0 {
-					updated := GenerateBatch(engine, route, count, genNow, totalTarget, cfg)
-					for sid := range updated {
+				if len(updated) > 0 {
+					clearClosed(updated)
+					checkPlatforms(updated, genNow)
+					for _, sid := range sortedStopIDs(updated) {
 						st := route.GetStop(sid)
 						if st != nil {
-							ch <- StopUpdateEvent{StopID: sid, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated}
+							send(StopUpdateEvent{StopID: sid, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
 						}
 					}
 				}
 				mu.Unlock()
 			}
+		})
+	}
+
+	// Full-state snapshots on demand; lastMove holds each launched bus's latest MoveEvent
+	if opts.Resync != nil {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-opts.Resync:
+				}
+				mu.Lock()
+				if finished {
+					mu.Unlock()
+					return
+				}
+				init := InitialState(route, engine)
+				avg := 0.0
+				if waitCount > 0 {
+					avg = waitSumMin / float64(waitCount)
+				}
+				st := StateEvent{Time: engine.Now(), Stops: init.Stops, Generated: init.Generated, OutboundGenerated: init.OutboundGenerated, InboundGenerated: init.InboundGenerated, ServedPassengers: cumServed, AvgWaitMin: avg, ClosedStops: closures.ClosedStops()}
+				for _, b := range fleet {
+					v, ok := lastMove.Load(b.ID)
+					if !ok {
+						continue
+					}
+					m := v.(MoveEvent)
+					cap := 0
+					if b.Type != nil {
+						cap = b.Type.Capacity
+					}
+					st.Buses = append(st.Buses, BusState{BusID: b.ID, Direction: b.Direction, Lat: m.Lat, Lng: m.Lng, From: m.From, To: m.To, T: m.T, Phase: m.Phase, Onboard: b.PassengersOnboard, Capacity: cap})
+				}
+				send(st)
+				mu.Unlock()
+			}
 		}()
 	}
 
+	// Stop closures and reopenings requested mid-run
+	if opts.Closures != nil {
+		go func() {
+			for {
+				var req StopClosure
+				select {
+				case <-ctx.Done():
+					return
+				case req = <-opts.Closures:
+				}
+				mu.Lock()
+				if finished {
+					mu.Unlock()
+					return
+				}
+				ev, err := closures.Apply(req, engine.Now())
+				if err != nil {
+					slog.Warn("stop closure rejected", "conn", opts.ConnID, "err", err)
+					mu.Unlock()
+					continue
+				}
+				slog.Info("stop closure", "conn", opts.ConnID, "stop", ev.StopID, "closed", ev.Closed, "redistributed", ev.Redistributed, "unserved", ev.Unserved)
+				send(ev)
+				for _, sid := range ev.Touched {
+					st := route.GetStop(sid)
+					send(StopUpdateEvent{StopID: sid, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Passengers injected at a stop mid-run
+	if opts.Injections != nil {
+		injectRNG := rngs.Stream("inject", 0)
+		go func() {
+			for {
+				var inj PassengerInjection
+				select {
+				case <-ctx.Done():
+					return
+				case inj = <-opts.Injections:
+				}
+				mu.Lock()
+				if finished {
+					mu.Unlock()
+					return
+				}
+				now := engine.Now()
+				ev := InjectPassengers(engine, route, inj, injectRNG, now)
+				slog.Info("passengers injected", "conn", opts.ConnID, "stop", ev.StopID, "outbound", ev.Outbound, "inbound", ev.Inbound)
+				send(ev)
+				updated := map[int]struct{}{inj.StopID: {}}
+				clearClosed(updated)
+				checkPlatforms(updated, now)
+				for _, sid := range sortedStopIDs(updated) {
+					st := route.GetStop(sid)
+					send(StopUpdateEvent{StopID: sid, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Bus commands (speed, hold, short turn) requested mid-run
+	if opts.BusCommands != nil {
+		go func() {
+			for {
+				var cmd BusCommand
+				select {
+				case <-ctx.Done():
+					return
+				case cmd = <-opts.BusCommands:
+				}
+				mu.Lock()
+				if finished {
+					mu.Unlock()
+					return
+				}
+				ev, err := controls.Apply(cmd, engine.Now())
+				if err != nil {
+					slog.Warn("bus command rejected", "conn", opts.ConnID, "bus", cmd.BusID, "command", cmd.Kind, "err", err)
+					mu.Unlock()
+					continue
+				}
+				slog.Info("bus command", "conn", opts.ConnID, "bus", cmd.BusID, "command", cmd.Kind)
+				send(ev)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// KPI heartbeat every MetricsInterval of sim time
+	if opts.MetricsInterval > 0 {
+		spawn(func(waitSim func(time.Duration) bool) {
+			at := opts.Start
+			for {
+				if !waitSim(opts.MetricsInterval) {
+					return
+				}
+				at = at.Add(opts.MetricsInterval)
+				mu.Lock()
+				if finished {
+					mu.Unlock()
+					return
+				}
+				avg := 0.0
+				if waitCount > 0 {
+					avg = waitSumMin / float64(waitCount)
+				}
+				m := NewMetricsEvent(at, route, fleet, engine.GeneratedPassengers, cumServed, avg)
+				m.WaitSamples = int(waitCount)
+				m.HeadwayAdherence, m.HeadwaySamples = headwayRec.Rolling(at)
+				m.ExcessWaitMin = ewtRec.Current()
+				send(m)
+				for _, a := range anomalies.Observe(m, fleet, busDistance) {
+					slog.Warn("anomaly detected", "kind", a.Kind, "detail", a.Message, "conn", opts.ConnID)
+					send(a)
+				}
+				mu.Unlock()
+			}
+		})
+	}
+
+	// Passenger information displays every ETAInterval of sim time
+	if opts.ETAInterval > 0 {
+		spawn(func(waitSim func(time.Duration) bool) {
+			for {
+				if !waitSim(opts.ETAInterval) {
+					return
+				}
+				mu.Lock()
+				if finished {
+					mu.Unlock()
+					return
+				}
+				for _, st := range route.Stops {
+					if e, ok := etas.Display(st.ID, ETADisplayBuses); ok {
+						send(StopETAEvent{e})
+					}
+				}
+				mu.Unlock()
+			}
+		})
+	}
+
+	// Stall watchdog: passengers waiting with no boarding for StallTimeout ends the run.
+	if opts.StallTimeout > 0 {
+		spawn(func(waitSim func(time.Duration) bool) {
+			const tick = 10 * time.Second
+			var idle time.Duration
+			lastBoardings := int64(-1)
+			for {
+				if !waitSim(tick) {
+					return
+				}
+				mu.Lock()
+				if finished {
+					mu.Unlock()
+					return
+				}
+				qOut, qIn := QueuedPassengers(route)
+				if boardings != lastBoardings || qOut+qIn == 0 {
+					idle = 0
+					lastBoardings = boardings
+				} else {
+					idle += tick
+				}
+				if idle >= opts.StallTimeout {
+					stalled = true
+					stallDiagnostic = StallDiagnostic(route, fleet, idle)
+					slog.Warn("stall detected", "diagnostic", stallDiagnostic)
+					mu.Unlock()
+					return
+				}
+				mu.Unlock()
+			}
+		})
+	}
+
+	// Time horizon of the stop criterion, on the same paced sim clock as the buses
+	if opts.Criterion.Duration > 0 {
+		spawn(func(waitSim func(time.Duration) bool) {
+			if !waitSim(opts.Criterion.Duration) {
+				return
+			}
+			mu.Lock()
+			if !finished && endedBy == "" {
+				endedBy = "duration"
+				clock.Cut()
+			}
+			mu.Unlock()
+		})
+	}
+
 	// choose initial directions based on period bias
 	favOut = (engine.PeriodID == 2 && opts.MorningTowardKivukoni) || (engine.PeriodID == 5 && !opts.MorningTowardKivukoni)
 	favIn = (engine.PeriodID == 2 && !opts.MorningTowardKivukoni) || (engine.PeriodID == 5 && opts.MorningTowardKivukoni)
@@ -230,7 +707,7 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 		pOutbound = 1.0 / (engine.DirectionBiasFactor + 1.0)
 	}
 	for _, b := range fleet {
-		if baseRNG.Float64() <= pOutbound {
+		if busRNG[b.ID].Float64() <= pOutbound {
 			b.Direction = "outbound"
 			b.CurrentStopID = route.Stops[0].ID
 		} else {
@@ -280,7 +757,7 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 		}, 0, n)
 		for i, b := range list {
 			base := float64(i) * headwayMin
-			jitter := (baseRNG.Float64()*0.4 - 0.2) * headwayMin
+			jitter := (busRNG[b.ID].Float64()*0.4 - 0.2) * headwayMin
 			simOffsetMin := base + jitter
 			if simOffsetMin < 0 {
 				simOffsetMin = 0
@@ -306,31 +783,101 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 
 	// dwell computation mirrors server
 	computeDwell := func(boardedN, alightedN int) time.Duration {
-		base := 1200 * time.Millisecond
-		per := time.Duration(300*time.Millisecond) * time.Duration(boardedN+alightedN)
-		max := 4 * time.Second
-		d := base + per
-		if d > max {
-			d = max
-		}
-		return d
+		dead, service := DwellTime(boardedN, alightedN)
+		return dead + service
 	}
 
 	// per-bus simulation
+	// shiftHold applies the crew rules to bu turning at its terminal at clk and returns
+	// how long it stays out of service. Called with mu held.
+	shiftHold := func(bu *model.Bus, clk time.Time) time.Duration {
+		sev, hold := shifts.Turnaround(bu.ID, bu.CurrentStopID, clk)
+		for _, v := range sev.Violations {
+			slog.Warn("shift limit exceeded", "bus", bu.ID, "driver", v.Driver, "kind", v.Kind, "limit_min", v.LimitMin, "actual_min", math.Round(v.ActualMin), "conn", opts.ConnID)
+		}
+		if hold || len(sev.Violations) > 0 {
+			send(sev)
+		}
+		if !hold {
+			return 0
+		}
+		decisions.Note(clk, route, bu, DecisionShift, bu.CurrentStopID, 0, fmt.Sprintf("driver %d %s, %.0f min out of service", sev.Driver, sev.Kind, sev.HoldMin))
+		return time.Duration(sev.HoldMin * float64(time.Minute))
+	}
+
+	// shortTurn reports whether bu reverses at stop on an operator command, setting
+	// down its riders there. Called with mu held.
+	shortTurn := func(bu *model.Bus, stop *model.BusStop, clk time.Time) bool {
+		ev, ok := controls.ShortTurn(bu, stop, clk)
+		if !ok {
+			return false
+		}
+		send(ev)
+		if ev.Status != "applied" {
+			return false
+		}
+		send(StopUpdateEvent{StopID: stop.ID, OutboundQueue: len(stop.OutboundQueue), InboundQueue: len(stop.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+		decisions.Note(clk, route, bu, DecisionControl, stop.ID, 0, fmt.Sprintf("short turn, %d riders set down", ev.SetDown))
+		return true
+	}
+
 	wg.Add(len(schedule))
+	busesRunning = len(schedule)
 	for _, item := range schedule {
-		bus := item.bus
-		forward := bus.Direction == "outbound"
-		go func(bu *model.Bus, fwd bool, simD time.Duration) {
+		bu, simD := item.bus, item.simDelay
+		fwd := bu.Direction == "outbound"
+		spawn(func(waitSim func(time.Duration) bool) {
 			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				busesRunning--
+				mu.Unlock()
+			}()
 			if !waitSim(simD) {
 				return
 			}
+			clk := opts.Start.Add(simD) // this bus's own sim clock (clk is shared by all buses)
+			defer func() {
+				mu.Lock()
+				if clk.After(lastClk) {
+					lastClk = clk
+				}
+				mu.Unlock()
+			}()
+			// dock waits upstream of stopID until a berth is free there; false when the
+			// run ends meanwhile.
+			dock := func(stopID int) bool {
+				for {
+					mu.Lock()
+					at, events, ok := berths.Dock(stopID, bu.ID, clk)
+					for _, ev := range events {
+						send(ev)
+					}
+					mu.Unlock()
+					wait := BerthPoll
+					if ok {
+						wait = at.Sub(clk)
+					}
+					if wait > 0 {
+						if isDone() || !waitSim(wait) {
+							return false
+						}
+						clk = clk.Add(wait)
+					}
+					if ok {
+						return true
+					}
+				}
+			}
+			mu.Lock()
+			decisions.Note(clk, route, bu, DecisionDispatch, bu.CurrentStopID, 0, fmt.Sprintf("scheduled launch +%.1f min", simD.Minutes()))
+			shifts.Start(bu.ID, clk)
+			mu.Unlock()
 			cap := 0
 			if bu.Type != nil {
 				cap = bu.Type.Capacity
 			}
-			ch <- BusAddEvent{BusID: bu.ID, Direction: bu.Direction, AvgSpeedKmph: bu.AverageSpeedKmph, Capacity: cap}
+			send(BusAddEvent{BusID: bu.ID, Direction: bu.Direction, AvgSpeedKmph: bu.AverageSpeedKmph, Capacity: cap})
 			var lat, lng float64
 			if bu.Direction == "inbound" {
 				lat = route.Stops[len(route.Stops)-1].Latitude
@@ -339,111 +886,195 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 				lat = route.Stops[0].Latitude
 				lng = route.Stops[0].Longitude
 			}
-			ch <- MoveEvent{BusID: bu.ID, Direction: bu.Direction, Lat: lat, Lng: lng, From: 0, To: bu.CurrentStopID, T: 0}
+			move(MoveEvent{BusID: bu.ID, Direction: bu.Direction, Lat: lat, Lng: lng, From: 0, To: bu.CurrentStopID, T: 0})
+			opts.Trajectories.Add(bu.ID, clk, model.LatLng{Lat: lat, Lng: lng})
 
 			dirForward := fwd
+			resume := -1 // route index a short-turned bus resumes its next trip from (-1 = the terminal)
 			traceThis := opts.TraceBusID > 0 && opts.TraceBusID == bu.ID
+			// Wall-clock span per trip; an interrupted trip is ended on return
+			var tripSpan trace.Span
+			defer func() {
+				if tripSpan != nil {
+					tripSpan.End()
+				}
+			}()
 			for {
 				select {
-				case <-stopCh:
+				case <-ctx.Done():
 					return
 				default:
 				}
+				_, tripSpan = tracer.Start(ctx, "bus.trip", trace.WithAttributes(attribute.Int("bus_id", bu.ID), attribute.String("direction", bu.Direction)))
+				fromTerminal := resume < 0 // false for the rest of a short-turned trip
 				if dirForward {
-					for idx := 0; idx < len(route.Stops); idx++ {
+					first := 0
+					if resume >= 0 {
+						first, resume = resume, -1
+					}
+					for idx := first; idx < len(route.Stops); idx++ {
 						select {
-						case <-stopCh:
+						case <-ctx.Done():
 							return
 						default:
 						}
 						stop := route.Stops[idx]
+						// a closed stop is passed without opening the doors
 						mu.Lock()
-						ch <- ArriveEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Time: engine.Now, BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated}
-						if traceThis {
-							nextIdx := idx
-							if bu.Direction == "outbound" {
-								if idx < len(route.Stops)-1 {
-									nextIdx = idx + 1
-								}
-							} else {
-								if idx > 0 {
-									nextIdx = idx - 1
-								}
-							}
-							dist := math.Round(busDistance[bu.ID]*100) / 100
-							log.Printf("buslog bus=%d stop_idx=%d next_idx=%d stop_id=%d dist_km=%.2f", bu.ID, idx, nextIdx, stop.ID, dist)
-						}
-						alighted := bu.AlightPassengersAtCurrentStop(engine.Now)
-						if len(alighted) > 0 {
-							cumServed += int64(len(alighted))
-							ch <- AlightEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Alighted: len(alighted), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed}
+						skip := closures.Closed(stop.ID)
+						turning := false
+						if skip {
+							send(closures.Skip(bu, stop.ID, clk))
+							turning = shortTurn(bu, stop, clk)
 						}
 						mu.Unlock()
-						if !waitSim(650 * time.Millisecond) {
-							return
-						}
-						mu.Lock()
-						engine.Now = engine.Now.Add(650 * time.Millisecond)
-						mu.Unlock()
-						mu.Lock()
-						boarded := stop.BoardAtStop(bu, engine.Now)
-						if len(boarded) > 0 {
-							var localSum float64
-							for _, p := range boarded {
-								if p.WaitDuration != nil {
-									localSum += *p.WaitDuration
+						if !skip {
+							if !dock(stop.ID) {
+								return
+							}
+							arrivedAt := clk
+							mu.Lock()
+							busStats.Set(bu.ID, clk, bu.PassengersOnboard, false)
+							send(ArriveEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Time: clk, BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+							send(DoorsOpenEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Time: arrivedAt, Onboard: bu.PassengersOnboard})
+							headwayRec.Arrive(stop.ID, bu.Direction, arrivedAt)
+							etas.Arrive(bu, stop.ID, arrivedAt)
+							if traceThis {
+								nextIdx := idx
+								if bu.Direction == "outbound" {
+									if idx < len(route.Stops)-1 {
+										nextIdx = idx + 1
+									}
+								} else {
+									if idx > 0 {
+										nextIdx = idx - 1
+									}
 								}
+								dist := math.Round(busDistance[bu.ID]*100) / 100
+								slog.Debug("buslog", "bus", bu.ID, "stop_idx", idx, "next_idx", nextIdx, "stop_id", stop.ID, "dist_km", dist)
 							}
-							if localSum > 0 {
-								waitSumMin += localSum
-								waitCount += int64(len(boarded))
+							zoneRec.Arrive(stop.ID, bu)
+							alighted := bu.AlightPassengersAtCurrentStop(clk)
+							zoneRec.Alight(stop.ID, len(alighted))
+							dirRec.Alight(alighted)
+							dayRec.Alight(len(alighted))
+							engine.Recycle(alighted)
+							busStats.Alight(bu.ID, len(alighted), clk, bu.PassengersOnboard)
+							if len(alighted) > 0 {
+								cumServed += int64(len(alighted))
+								send(AlightEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Alighted: len(alighted), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed})
 							}
-							avg := 0.0
-							if waitCount > 0 {
-								avg = waitSumMin / float64(waitCount)
+							mu.Unlock()
+							if !waitSim(650 * time.Millisecond) {
+								return
+							}
+							clk = clk.Add(650 * time.Millisecond)
+							mu.Lock()
+							// a short-turning bus only boards after reversing
+							turning = shortTurn(bu, stop, clk)
+							var boarded []*model.Passenger
+							if !turning {
+								boarded = stop.BoardAtStop(bu, clk)
+							}
+							boardings += int64(len(boarded))
+							engine.NoteBoarding(stop, bu, boarded)
+							busStats.Board(bu.ID, len(boarded), clk, bu.PassengersOnboard)
+							if len(boarded) > 0 {
+								var localSum float64
+								localN := 0
+								for _, p := range boarded {
+									if engine.CountsWait(p) {
+										localSum += *p.WaitDuration
+										localN++
+										waitStats.Add(stop.ID, p.Direction, *p.WaitDuration)
+										dayRec.Board(*p.WaitDuration)
+										stopWait.Add(stop.ID, clk, *p.WaitDuration)
+										ewtRec.Board(stop.ID, p.ArrivalStopTime, *p.WaitDuration)
+									}
+								}
+								if localSum > 0 {
+									waitSumMin += localSum
+									waitCount += int64(localN)
+								}
+								avg := 0.0
+								if waitCount > 0 {
+									avg = waitSumMin / float64(waitCount)
+								}
+								stopAvg, stopN := stopWait.Avg(stop.ID, clk)
+								send(BoardEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Boarded: len(boarded), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, StopOutbound: len(stop.OutboundQueue), StopInbound: len(stop.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed, AvgWaitMin: avg, StopAvgWaitMin: stopAvg, StopWaitSamples: stopN})
+							}
+							for _, sid := range checkPlatform(stop.ID, clk) {
+								st := route.GetStop(sid)
+								send(StopUpdateEvent{StopID: sid, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+							}
+							send(StopUpdateEvent{StopID: stop.ID, OutboundQueue: len(stop.OutboundQueue), InboundQueue: len(stop.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+							dwell := computeDwell(len(boarded), len(alighted))
+							if hold, ev, ok := controls.Hold(bu, stop.ID, clk); ok {
+								dwell += hold
+								send(ev)
+								decisions.Note(clk, route, bu, DecisionControl, stop.ID, 0, fmt.Sprintf("held %.0f s", hold.Seconds()))
+							}
+							etas.Dwell(bu, stop.ID, clk, clk.Add(dwell))
+							mu.Unlock()
+							if isDone() {
+								return
+							}
+							if !waitSim(dwell) {
+								return
+							}
+							mu.Lock()
+							clk = clk.Add(dwell)
+							dwellRec.Record(stop.ID, arrivedAt, clk)
+							zoneRec.Depart(stop.ID, bu)
+							loadRec.Depart(stop.ID, bu)
+							dayRec.Depart(bu)
+							send(NewDoorsCloseEvent(bu, stop.ID, arrivedAt, clk, len(alighted), len(boarded)))
+							if ev, ok := berths.Release(stop.ID, bu.ID, clk); ok {
+								send(ev)
+							}
+							mu.Unlock()
+							if isDone() {
+								return
 							}
-							ch <- BoardEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Boarded: len(boarded), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, StopOutbound: len(stop.OutboundQueue), StopInbound: len(stop.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed, AvgWaitMin: avg}
-						}
-						ch <- StopUpdateEvent{StopID: stop.ID, OutboundQueue: len(stop.OutboundQueue), InboundQueue: len(stop.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated}
-						dwell := computeDwell(len(boarded), len(alighted))
-						mu.Unlock()
-						if isDone() {
-							return
-						}
-						if !waitSim(dwell) {
-							return
 						}
-						mu.Lock()
-						engine.Now = engine.Now.Add(dwell)
-						mu.Unlock()
-						if isDone() {
-							return
+						if turning {
+							resume = idx
+							break
 						}
 						if idx == len(route.Stops)-1 {
 							break
 						}
 						next := route.Stops[idx+1]
-						dist := stop.DistanceToNext
+						dist := route.SegmentKM(idx, idx+1)
+						mu.Lock()
+						if ev, ok := controls.Depart(bu, clk); ok {
+							send(ev)
+						}
+						mu.Unlock()
 						travelMin := dist / bu.AverageSpeedKmph * 60
 						travelDur := time.Duration(travelMin * float64(time.Minute))
+						travelDur = ResolveTravelTime(opts.Traffic, TravelTimeRequest{BusID: bu.ID, FromStopID: stop.ID, ToStopID: next.ID, Direction: bu.Direction, DistanceKM: dist, SpeedKmph: bu.AverageSpeedKmph, Depart: clk}, travelDur)
 						steps := int(travelDur / (800 * time.Millisecond))
 						if steps < 1 {
 							steps = 1
 						}
+						mu.Lock()
+						busStats.Set(bu.ID, clk, bu.PassengersOnboard, true)
+						etas.Move(bu, next.ID, clk, clk.Add(travelDur))
+						mu.Unlock()
+						path := geom.Samples(stop.ID, next.ID, steps)
 						for sstep := 1; sstep <= steps; sstep++ {
 							t := float64(sstep) / float64(steps)
-							lat := stop.Latitude + (next.Latitude-stop.Latitude)*t
-							lng := stop.Longitude + (next.Longitude-stop.Longitude)*t
-							ch <- MoveEvent{BusID: bu.ID, Direction: bu.Direction, Lat: lat, Lng: lng, T: t, From: stop.ID, To: next.ID}
+							pos := path[sstep-1]
+							move(MoveEvent{BusID: bu.ID, Direction: bu.Direction, Lat: pos.Lat, Lng: pos.Lng, T: t, From: stop.ID, To: next.ID})
 							stepSim := travelDur / time.Duration(steps)
 							if !waitSim(stepSim) {
 								return
 							}
-							mu.Lock()
-							engine.Now = engine.Now.Add(stepSim)
-							mu.Unlock()
+							clk = clk.Add(stepSim)
+							opts.Trajectories.Add(bu.ID, clk, pos)
 							select {
-							case <-stopCh:
+							case <-ctx.Done():
 								return
 							default:
 							}
@@ -454,119 +1085,224 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 						bu.CurrentStopID = next.ID
 					}
 					mu.Lock()
-					alighted := bu.AlightPassengersAtCurrentStop(engine.Now)
+					zoneRec.Arrive(bu.CurrentStopID, bu)
+					alighted := bu.AlightPassengersAtCurrentStop(clk)
+					zoneRec.Alight(bu.CurrentStopID, len(alighted))
+					dirRec.Alight(alighted)
+					dayRec.Alight(len(alighted))
+					engine.Recycle(alighted)
+					busStats.Alight(bu.ID, len(alighted), clk, bu.PassengersOnboard)
+					busStats.Trip(bu.ID, bu.Direction, clk, fromTerminal && resume < 0)
+					busStats.Layover(bu.ID, clk)
+					trips++
 					if len(alighted) > 0 {
 						cumServed += int64(len(alighted))
-						ch <- AlightEvent{BusID: bu.ID, Direction: bu.Direction, StopID: bu.CurrentStopID, Alighted: len(alighted), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, Final: true, ServedPassengers: cumServed}
+						send(AlightEvent{BusID: bu.ID, Direction: bu.Direction, StopID: bu.CurrentStopID, Alighted: len(alighted), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, Final: true, ServedPassengers: cumServed})
 					}
 					mu.Unlock()
 					if isDone() {
 						return
 					}
-					if !waitSim(3 * time.Second) {
-						return
-					}
+					// turn in a terminal bay, waiting for one when all are taken, then lay over for the recovery time
 					mu.Lock()
-					engine.Now = engine.Now.Add(3 * time.Second)
+					ready, tev, limited := yard.Turn(bu.CurrentStopID, bu.ID, engine.PeriodID, clk)
+					if limited {
+						send(tev)
+					}
 					mu.Unlock()
+					pause := ready.Sub(clk)
+					if !waitSim(pause) {
+						return
+					}
+					clk = ready
 					signalStopIfDone()
+					tripSpan.End()
+					tripSpan = nil
+					mu.Lock()
 					bu.Direction = "inbound"
+					reason := "trip complete at terminal"
+					if resume >= 0 {
+						reason = "short turn on operator command"
+					}
+					decisions.Note(clk, route, bu, DecisionTurnaround, bu.CurrentStopID, 0, reason)
+					hold := shiftHold(bu, clk)
+					etas.Turn(bu, clk, clk.Add(hold))
+					mu.Unlock()
+					if hold > 0 {
+						if !waitSim(hold) {
+							return
+						}
+						clk = clk.Add(hold)
+					}
 					dirForward = false
 				} else { // inbound traversal
-					for ridx := len(route.Stops) - 1; ridx >= 0; ridx-- {
+					first := len(route.Stops) - 1
+					if resume >= 0 {
+						first, resume = resume, -1
+					}
+					for ridx := first; ridx >= 0; ridx-- {
 						select {
-						case <-stopCh:
+						case <-ctx.Done():
 							return
 						default:
 						}
 						stop := route.Stops[ridx]
+						// a closed stop is passed without opening the doors
 						mu.Lock()
-						ch <- ArriveEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Time: engine.Now, BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated}
-						if traceThis {
-							nextIdx := ridx
-							if bu.Direction == "outbound" {
-								if ridx < len(route.Stops)-1 {
-									nextIdx = ridx + 1
-								}
-							} else {
-								if ridx > 0 {
-									nextIdx = ridx - 1
-								}
-							}
-							dist := math.Round(busDistance[bu.ID]*100) / 100
-							log.Printf("buslog bus=%d stop_idx=%d next_idx=%d stop_id=%d dist_km=%.2f", bu.ID, ridx, nextIdx, stop.ID, dist)
-						}
-						alighted := bu.AlightPassengersAtCurrentStop(engine.Now)
-						if len(alighted) > 0 {
-							cumServed += int64(len(alighted))
-							ch <- AlightEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Alighted: len(alighted), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed}
-						}
-						mu.Unlock()
-						if !waitSim(650 * time.Millisecond) {
-							return
+						skip := closures.Closed(stop.ID)
+						turning := false
+						if skip {
+							send(closures.Skip(bu, stop.ID, clk))
+							turning = shortTurn(bu, stop, clk)
 						}
-						mu.Lock()
-						engine.Now = engine.Now.Add(650 * time.Millisecond)
 						mu.Unlock()
-						mu.Lock()
-						boarded := stop.BoardAtStop(bu, engine.Now)
-						if len(boarded) > 0 {
-							var localSum2 float64
-							for _, p := range boarded {
-								if p.WaitDuration != nil {
-									localSum2 += *p.WaitDuration
+						if !skip {
+							if !dock(stop.ID) {
+								return
+							}
+							arrivedAt := clk
+							mu.Lock()
+							busStats.Set(bu.ID, clk, bu.PassengersOnboard, false)
+							send(ArriveEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Time: clk, BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+							send(DoorsOpenEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Time: arrivedAt, Onboard: bu.PassengersOnboard})
+							headwayRec.Arrive(stop.ID, bu.Direction, arrivedAt)
+							etas.Arrive(bu, stop.ID, arrivedAt)
+							if traceThis {
+								nextIdx := ridx
+								if bu.Direction == "outbound" {
+									if ridx < len(route.Stops)-1 {
+										nextIdx = ridx + 1
+									}
+								} else {
+									if ridx > 0 {
+										nextIdx = ridx - 1
+									}
 								}
+								dist := math.Round(busDistance[bu.ID]*100) / 100
+								slog.Debug("buslog", "bus", bu.ID, "stop_idx", ridx, "next_idx", nextIdx, "stop_id", stop.ID, "dist_km", dist)
 							}
-							if localSum2 > 0 {
-								waitSumMin += localSum2
-								waitCount += int64(len(boarded))
+							zoneRec.Arrive(stop.ID, bu)
+							alighted := bu.AlightPassengersAtCurrentStop(clk)
+							zoneRec.Alight(stop.ID, len(alighted))
+							dirRec.Alight(alighted)
+							dayRec.Alight(len(alighted))
+							engine.Recycle(alighted)
+							busStats.Alight(bu.ID, len(alighted), clk, bu.PassengersOnboard)
+							if len(alighted) > 0 {
+								cumServed += int64(len(alighted))
+								send(AlightEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Alighted: len(alighted), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed})
 							}
-							avg2 := 0.0
-							if waitCount > 0 {
-								avg2 = waitSumMin / float64(waitCount)
+							mu.Unlock()
+							if !waitSim(650 * time.Millisecond) {
+								return
+							}
+							clk = clk.Add(650 * time.Millisecond)
+							mu.Lock()
+							// a short-turning bus only boards after reversing
+							turning = shortTurn(bu, stop, clk)
+							var boarded []*model.Passenger
+							if !turning {
+								boarded = stop.BoardAtStop(bu, clk)
+							}
+							boardings += int64(len(boarded))
+							engine.NoteBoarding(stop, bu, boarded)
+							busStats.Board(bu.ID, len(boarded), clk, bu.PassengersOnboard)
+							if len(boarded) > 0 {
+								var localSum2 float64
+								localN2 := 0
+								for _, p := range boarded {
+									if engine.CountsWait(p) {
+										localSum2 += *p.WaitDuration
+										localN2++
+										waitStats.Add(stop.ID, p.Direction, *p.WaitDuration)
+										dayRec.Board(*p.WaitDuration)
+										stopWait.Add(stop.ID, clk, *p.WaitDuration)
+										ewtRec.Board(stop.ID, p.ArrivalStopTime, *p.WaitDuration)
+									}
+								}
+								if localSum2 > 0 {
+									waitSumMin += localSum2
+									waitCount += int64(localN2)
+								}
+								avg2 := 0.0
+								if waitCount > 0 {
+									avg2 = waitSumMin / float64(waitCount)
+								}
+								stopAvg, stopN := stopWait.Avg(stop.ID, clk)
+								send(BoardEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Boarded: len(boarded), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, StopOutbound: len(stop.OutboundQueue), StopInbound: len(stop.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed, AvgWaitMin: avg2, StopAvgWaitMin: stopAvg, StopWaitSamples: stopN})
+							}
+							for _, sid := range checkPlatform(stop.ID, clk) {
+								st := route.GetStop(sid)
+								send(StopUpdateEvent{StopID: sid, OutboundQueue: len(st.OutboundQueue), InboundQueue: len(st.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+							}
+							send(StopUpdateEvent{StopID: stop.ID, OutboundQueue: len(stop.OutboundQueue), InboundQueue: len(stop.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated})
+							dwell := computeDwell(len(boarded), len(alighted))
+							if hold, ev, ok := controls.Hold(bu, stop.ID, clk); ok {
+								dwell += hold
+								send(ev)
+								decisions.Note(clk, route, bu, DecisionControl, stop.ID, 0, fmt.Sprintf("held %.0f s", hold.Seconds()))
+							}
+							etas.Dwell(bu, stop.ID, clk, clk.Add(dwell))
+							mu.Unlock()
+							if isDone() {
+								return
+							}
+							if !waitSim(dwell) {
+								return
+							}
+							mu.Lock()
+							clk = clk.Add(dwell)
+							dwellRec.Record(stop.ID, arrivedAt, clk)
+							zoneRec.Depart(stop.ID, bu)
+							loadRec.Depart(stop.ID, bu)
+							dayRec.Depart(bu)
+							send(NewDoorsCloseEvent(bu, stop.ID, arrivedAt, clk, len(alighted), len(boarded)))
+							if ev, ok := berths.Release(stop.ID, bu.ID, clk); ok {
+								send(ev)
+							}
+							mu.Unlock()
+							if isDone() {
+								return
 							}
-							ch <- BoardEvent{BusID: bu.ID, Direction: bu.Direction, StopID: stop.ID, Boarded: len(boarded), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, StopOutbound: len(stop.OutboundQueue), StopInbound: len(stop.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed, AvgWaitMin: avg2}
-						}
-						ch <- StopUpdateEvent{StopID: stop.ID, OutboundQueue: len(stop.OutboundQueue), InboundQueue: len(stop.InboundQueue), Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated}
-						dwell := computeDwell(len(boarded), len(alighted))
-						mu.Unlock()
-						if isDone() {
-							return
-						}
-						if !waitSim(dwell) {
-							return
 						}
-						mu.Lock()
-						engine.Now = engine.Now.Add(dwell)
-						mu.Unlock()
-						if isDone() {
-							return
+						if turning {
+							resume = ridx
+							break
 						}
 						if ridx == 0 {
 							break
 						}
 						prev := route.Stops[ridx-1]
-						dist := prev.DistanceToNext
+						dist := route.SegmentKM(ridx, ridx-1)
+						mu.Lock()
+						if ev, ok := controls.Depart(bu, clk); ok {
+							send(ev)
+						}
+						mu.Unlock()
 						travelMin := dist / bu.AverageSpeedKmph * 60
 						travelDur := time.Duration(travelMin * float64(time.Minute))
+						travelDur = ResolveTravelTime(opts.Traffic, TravelTimeRequest{BusID: bu.ID, FromStopID: stop.ID, ToStopID: prev.ID, Direction: bu.Direction, DistanceKM: dist, SpeedKmph: bu.AverageSpeedKmph, Depart: clk}, travelDur)
 						steps := int(travelDur / (800 * time.Millisecond))
 						if steps < 1 {
 							steps = 1
 						}
+						mu.Lock()
+						busStats.Set(bu.ID, clk, bu.PassengersOnboard, true)
+						etas.Move(bu, prev.ID, clk, clk.Add(travelDur))
+						mu.Unlock()
+						path := geom.Samples(stop.ID, prev.ID, steps)
 						for sstep := 1; sstep <= steps; sstep++ {
 							t := float64(sstep) / float64(steps)
-							lat := stop.Latitude + (prev.Latitude-stop.Latitude)*t
-							lng := stop.Longitude + (prev.Longitude-stop.Longitude)*t
-							ch <- MoveEvent{BusID: bu.ID, Direction: bu.Direction, Lat: lat, Lng: lng, T: t, From: stop.ID, To: prev.ID}
+							pos := path[sstep-1]
+							move(MoveEvent{BusID: bu.ID, Direction: bu.Direction, Lat: pos.Lat, Lng: pos.Lng, T: t, From: stop.ID, To: prev.ID})
 							stepSim := travelDur / time.Duration(steps)
 							if !waitSim(stepSim) {
 								return
 							}
-							mu.Lock()
-							engine.Now = engine.Now.Add(stepSim)
-							mu.Unlock()
+							clk = clk.Add(stepSim)
+							opts.Trajectories.Add(bu.ID, clk, pos)
 							select {
-							case <-stopCh:
+							case <-ctx.Done():
 								return
 							default:
 							}
@@ -577,60 +1313,162 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 						bu.CurrentStopID = prev.ID
 					}
 					mu.Lock()
-					alighted2 := bu.AlightPassengersAtCurrentStop(engine.Now)
+					zoneRec.Arrive(bu.CurrentStopID, bu)
+					alighted2 := bu.AlightPassengersAtCurrentStop(clk)
+					zoneRec.Alight(bu.CurrentStopID, len(alighted2))
+					dirRec.Alight(alighted2)
+					dayRec.Alight(len(alighted2))
+					engine.Recycle(alighted2)
+					busStats.Alight(bu.ID, len(alighted2), clk, bu.PassengersOnboard)
+					busStats.Trip(bu.ID, bu.Direction, clk, fromTerminal && resume < 0)
+					busStats.Layover(bu.ID, clk)
+					trips++
 					if len(alighted2) > 0 {
 						cumServed += int64(len(alighted2))
-						ch <- AlightEvent{BusID: bu.ID, Direction: bu.Direction, StopID: bu.CurrentStopID, Alighted: len(alighted2), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, Final: true, ServedPassengers: cumServed}
+						send(AlightEvent{BusID: bu.ID, Direction: bu.Direction, StopID: bu.CurrentStopID, Alighted: len(alighted2), BusOnboard: bu.PassengersOnboard, PassengersOnboard: bu.PassengersOnboard, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, Final: true, ServedPassengers: cumServed})
 					}
 					mu.Unlock()
 					if isDone() {
 						return
 					}
-					if !waitSim(3 * time.Second) {
-						return
-					}
+					// turn in a terminal bay, waiting for one when all are taken, then lay over for the recovery time
 					mu.Lock()
-					engine.Now = engine.Now.Add(3 * time.Second)
+					ready, tev, limited := yard.Turn(bu.CurrentStopID, bu.ID, engine.PeriodID, clk)
+					if limited {
+						send(tev)
+					}
 					mu.Unlock()
+					pause := ready.Sub(clk)
+					if !waitSim(pause) {
+						return
+					}
+					clk = ready
 					signalStopIfDone()
+					tripSpan.End()
+					tripSpan = nil
+					mu.Lock()
 					bu.Direction = "outbound"
+					reason := "trip complete at terminal"
+					if resume >= 0 {
+						reason = "short turn on operator command"
+					}
+					decisions.Note(clk, route, bu, DecisionTurnaround, bu.CurrentStopID, 0, reason)
+					hold := shiftHold(bu, clk)
+					etas.Turn(bu, clk, clk.Add(hold))
+					mu.Unlock()
+					if hold > 0 {
+						if !waitSim(hold) {
+							return
+						}
+						clk = clk.Add(hold)
+					}
 					dirForward = true
 				}
 			}
-		}(bus, forward, item.simDelay)
+		})
+	}
+
+	// partialDone builds the final event of a run that failed; the aggregates are read
+	// as they stand and, should that fail too, only the counters are reported.
+	partialDone := func(reason string) (ev DoneEvent) {
+		ev = DoneEvent{Aborted: reason, Stalled: stalled, Diagnostic: stallDiagnostic, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed, BusDistance: busDistance, DroppedEvents: int(policy.dropped.Load()), ClosureUnserved: closures.Unserved()}
+		defer func() { recover() }()
+		if waitCount > 0 {
+			ev.AvgWaitMin = waitSumMin / float64(waitCount)
+		}
+		ev.Passengers = engine.Passengers
+		ev.Wait = waitStats.Distribution()
+		ev.ETAAccuracy = etas.Accuracy()
+		ev.Periods = dayRec.Stats(engine)
+		ev.StopStats = engine.StopStatsSnapshot()
+		ev.BusStats = busStats.Snapshot(fleet, busDistance)
+		ev.DwellStats = dwellRec.Stats(route, opts.Start, time.Time{})
+		ev.Decisions = decisions.Entries()
+		ev.Closures = closures.Records(engine.Now())
+		ev.Platforms = platforms.Stats(lastClk)
+		ev.Berths = berths.Stats(route, opts.Start, lastClk)
+		ev.Turnarounds = yard.Stats(route, opts.Start, lastClk)
+		return ev
 	}
 
-	// Closing goroutine to finish, reposition, and emit final events
+	// Closing goroutine to finish, reposition, and emit final events. A panic here still
+	// delivers a partial DoneEvent, so the consumer can write its reports.
+	closed := false
+	closer := sched.Join(0)
 	go func() {
-		// Wait for buses to finish their traversal
-		wg.Wait()
-		if genStarted && opts.PassengerCap > 0 {
-			genWg.Wait()
+		defer func() {
+			r := recover()
+			if r == nil || closed {
+				return
+			}
+			slog.Error("runner panicked; emitting partial results", "conn", opts.ConnID, "panic", r, "stack", string(debug.Stack()))
+			sched.Stop()
+			ch <- partialDone(fmt.Sprintf("panic: %v", r))
+			runSpan.End()
+			close(ch)
+			stop()
+		}()
+		// awaitIdle waits until running reports false: checked on the closer's turns
+		// while the run goes on, so the end of each phase takes a fixed place among the
+		// actors' turns; once the run is cut off the closer leaves the turns and waits.
+		const finishPoll = time.Second
+		onTurn := closer.Begin()
+		awaitIdle := func(running func() bool, wait func()) {
+			for onTurn && running() {
+				onTurn = closer.Sleep(finishPoll)
+			}
+			if !onTurn {
+				closer.Leave()
+				wait()
+			}
 		}
+		genNeeded := func() bool { return genStarted && (opts.PassengerCap > 0 || stalled || opts.Criterion.Active()) }
+		// Wait for buses to finish their traversal
+		awaitIdle(func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return busesRunning > 0 || genRunning && genNeeded()
+		}, func() {
+			wg.Wait()
+			if genNeeded() {
+				genWg.Wait()
+			}
+		})
+		mu.Lock()
+		finished = true
+		ended := endedBy
+		mu.Unlock()
 
-		// Reposition phase (if a cap was set)
+		// Reposition phase (if a cap was set and the run was neither cancelled, abandoned nor cut off by the criterion)
 		repositionStart := time.Now()
-		if opts.PassengerCap > 0 {
-			layoverIdxSet := make(map[int]struct{})
+		if opts.PassengerCap > 0 && !stalled && ended == "" && ctx.Err() == nil {
+			_, repSpan := tracer.Start(ctx, "reposition")
+			// the terminals and the layover stops, in route order
+			var layoverIdxs []int
 			for i, st := range route.Stops {
-				if st.AllowLayover {
-					layoverIdxSet[i] = struct{}{}
+				if st.AllowLayover || i == 0 || i == len(route.Stops)-1 {
+					layoverIdxs = append(layoverIdxs, i)
 				}
 			}
-			layoverIdxSet[0] = struct{}{}
-			layoverIdxSet[len(route.Stops)-1] = struct{}{}
-			layoverIdxs := make([]int, 0, len(layoverIdxSet))
-			for idx := range layoverIdxSet {
-				layoverIdxs = append(layoverIdxs, idx)
-			}
-			ch <- RepositionStartEvent{Buses: len(fleet), LayoverIndices: layoverIdxs}
+			send(RepositionStartEvent{Buses: len(fleet), LayoverIndices: layoverIdxs})
 
 			var repWg sync.WaitGroup
 			repWg.Add(len(fleet))
+			mu.Lock()
+			busesRunning = len(fleet)
+			// every bus advances the engine clock on its moves, so each one's time budget
+			// runs on its own from the end of service
+			serviceEnd := lastClk
+			mu.Unlock()
 			for _, b := range fleet {
 				bus := b
-				go func() {
+				spawn(func(waitSim func(time.Duration) bool) {
 					defer repWg.Done()
+					defer func() {
+						mu.Lock()
+						busesRunning--
+						mu.Unlock()
+					}()
 					curIdx := -1
 					for i, st := range route.Stops {
 						if st.ID == bus.CurrentStopID {
@@ -650,11 +1488,11 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 						d := 0.0
 						if j > i {
 							for k := i; k < j; k++ {
-								d += route.Stops[k].DistanceToNext
+								d += route.SegmentKM(k, k+1)
 							}
 						} else {
 							for k := i; k > j; k-- {
-								d += route.Stops[k-1].DistanceToNext
+								d += route.SegmentKM(k, k-1)
 							}
 						}
 						return d
@@ -681,13 +1519,18 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 							}
 						}
 					}
-					ch <- RepositionBusEvent{BusID: bus.ID, FromIndex: curIdx, TargetIndex: bestIdx, CurrentStopID: route.Stops[curIdx].ID, AheadOnly: aheadFound}
+					send(RepositionBusEvent{BusID: bus.ID, FromIndex: curIdx, TargetIndex: bestIdx, CurrentStopID: route.Stops[curIdx].ID, AheadOnly: aheadFound})
+					if bestIdx != -1 {
+						mu.Lock()
+						decisions.Note(engine.Now(), route, bus, DecisionReposition, route.Stops[curIdx].ID, route.Stops[bestIdx].ID, RepositionReason(aheadFound, bestIdx == curIdx, bestKm, layoverIdxs))
+						mu.Unlock()
+					}
 					traceThis := opts.TraceBusID > 0 && opts.TraceBusID == bus.ID
 					if bestIdx == -1 || bestIdx == curIdx {
-						ch <- LayoverEvent{BusID: bus.ID, TerminalStopID: route.Stops[curIdx].ID}
+						send(LayoverEvent{BusID: bus.ID, TerminalStopID: route.Stops[curIdx].ID})
 						if traceThis {
 							dist := math.Round(busDistance[bus.ID]*100) / 100
-							log.Printf("buslog bus=%d layover stop_idx=%d next_idx=%d stop_id=%d dist_km=%.2f", bus.ID, curIdx, -1, route.Stops[curIdx].ID, dist)
+							slog.Debug("buslog layover", "bus", bus.ID, "stop_idx", curIdx, "next_idx", -1, "stop_id", route.Stops[curIdx].ID, "dist_km", dist)
 						}
 						return
 					}
@@ -695,48 +1538,62 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 					if bestIdx < curIdx {
 						step = -1
 					}
+					mu.Lock()
+					busStats.Reposition(bus.ID, serviceEnd)
+					mu.Unlock()
+					repositioned := time.Duration(0)
 					for idx := curIdx; idx != bestIdx; idx += step {
 						from := route.Stops[idx]
 						to := route.Stops[idx+step]
-						dist := from.DistanceToNext
-						if step == -1 {
-							prev := route.Stops[idx-1]
-							dist = prev.DistanceToNext
-						}
+						dist := route.SegmentKM(idx, idx+step)
 						travelMin := dist / bus.AverageSpeedKmph * 60
 						if travelMin < 0 {
 							travelMin = 0
 						}
 						travelDur := time.Duration(travelMin * float64(time.Minute))
+						mu.Lock()
+						departAt := engine.Now()
+						mu.Unlock()
+						travelDur = ResolveTravelTime(opts.Traffic, TravelTimeRequest{BusID: bus.ID, FromStopID: from.ID, ToStopID: to.ID, Direction: bus.Direction, DistanceKM: dist, SpeedKmph: bus.AverageSpeedKmph, Depart: departAt, Phase: "reposition"}, travelDur)
 						steps := int(travelDur / (800 * time.Millisecond))
 						if steps < 1 {
 							steps = 1
 						}
+						path := geom.Samples(from.ID, to.ID, steps)
 						for sstep := 1; sstep <= steps; sstep++ {
 							t := float64(sstep) / float64(steps)
-							lat := from.Latitude + (to.Latitude-from.Latitude)*t
-							lng := from.Longitude + (to.Longitude-from.Longitude)*t
-							ch <- MoveEvent{BusID: bus.ID, Direction: bus.Direction, Lat: lat, Lng: lng, T: t, From: from.ID, To: to.ID, Phase: "reposition"}
+							pos := path[sstep-1]
+							move(MoveEvent{BusID: bus.ID, Direction: bus.Direction, Lat: pos.Lat, Lng: pos.Lng, T: t, From: from.ID, To: to.ID, Phase: "reposition"})
 							stepSim := travelDur / time.Duration(steps)
 							if !waitSim(stepSim) {
 								return
 							}
 							mu.Lock()
-							engine.Now = engine.Now.Add(stepSim)
+							repositioned += stepSim
 							busDistance[bus.ID] += dist / float64(steps)
+							at := engine.Now()
 							mu.Unlock()
+							opts.Trajectories.Add(bus.ID, at, pos)
 						}
 						bus.CurrentStopID = to.ID
 					}
-					ch <- LayoverEvent{BusID: bus.ID, TerminalStopID: route.Stops[bestIdx].ID}
+					mu.Lock()
+					busStats.Layover(bus.ID, serviceEnd.Add(repositioned))
+					mu.Unlock()
+					send(LayoverEvent{BusID: bus.ID, TerminalStopID: route.Stops[bestIdx].ID})
 					if traceThis {
 						dist := math.Round(busDistance[bus.ID]*100) / 100
-						log.Printf("buslog bus=%d layover stop_idx=%d next_idx=%d stop_id=%d dist_km=%.2f", bus.ID, bestIdx, -1, route.Stops[bestIdx].ID, dist)
+						slog.Debug("buslog layover", "bus", bus.ID, "stop_idx", bestIdx, "next_idx", -1, "stop_id", route.Stops[bestIdx].ID, "dist_km", dist)
 					}
-				}()
+				})
 			}
-			repWg.Wait()
-			ch <- RepositionCompleteEvent{ElapsedMs: time.Since(repositionStart).Milliseconds()}
+			awaitIdle(func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return busesRunning > 0
+			}, repWg.Wait)
+			repSpan.End()
+			send(RepositionCompleteEvent{ElapsedMs: time.Since(repositionStart).Milliseconds()})
 		}
 
 		avgFinal := 0.0
@@ -746,9 +1603,28 @@ func StartRunner(route *model.Route, fleet []*model.Bus, engineSeed int64, lambd
 		if opts.PassengerCap > 0 && engine.GeneratedPassengers > opts.PassengerCap {
 			engine.GeneratedPassengers = opts.PassengerCap
 		}
-		ch <- DoneEvent{Completed: true, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed, AvgWaitMin: avgFinal, BusDistance: busDistance}
+		aborted := ""
+		if err := ctx.Err(); err != nil {
+			aborted = "cancelled: " + err.Error()
+		}
+		if reason := policy.abortReason(); reason != "" {
+			aborted = reason
+		}
+		stopStats := engine.StopStatsSnapshot()
+		mu.Lock()
+		shifts.Finish(lastClk)
+		mu.Unlock()
+		wait := waitStats.Distribution()
+		ch <- DoneEvent{Completed: !stalled && aborted == "", EndedBy: EndReason(aborted, stalled, ended, opts.PassengerCap), Stalled: stalled, Diagnostic: stallDiagnostic, Aborted: aborted, Generated: engine.GeneratedPassengers, OutboundGenerated: engine.OutboundGenerated, InboundGenerated: engine.InboundGenerated, ServedPassengers: cumServed, AvgWaitMin: avgFinal, BusDistance: busDistance, Passengers: engine.Passengers, DwellStats: dwellRec.Stats(route, opts.Start, time.Time{}), Wait: wait, StopStats: stopStats, Zones: zoneRec.Stats(stopStats), Load: loadRec.Profile(), Headway: headwayRec.Stats(), StopHeadways: headwayRec.ByStop(route), Anomalies: anomalies.Events(), Shifts: shifts.Stats(), BusStats: busStats.Snapshot(fleet, busDistance), Decisions: decisions.Entries(), DroppedEvents: int(policy.dropped.Load()), Closures: closures.Records(engine.Now()), ClosureUnserved: closures.Unserved(), Periods: dayRec.Stats(engine), ETAAccuracy: etas.Accuracy(), Platforms: platforms.Stats(lastClk), Berths: berths.Stats(route, opts.Start, lastClk), Turnarounds: yard.Stats(route, opts.Start, lastClk), Directions: dirRec.Stats(engine, wait), ExcessWait: ewtRec.Stats(route)}
+		runSpan.SetAttributes(attribute.Int("generated", engine.GeneratedPassengers), attribute.Int64("served", cumServed), attribute.Bool("stalled", stalled))
+		runSpan.End()
+		closed = true
+		closer.Leave()
+		sched.Stop()
 		close(ch)
+		stop() // release the context once the run is over
 	}()
+	sched.Start()
 
-	return ch, stop, wait
+	return policy.output(ch), stop, wait
 }
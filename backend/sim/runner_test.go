@@ -0,0 +1,102 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"brt08/backend/internal/simtest"
+)
+
+var testStart = time.Date(2024, 3, 4, 6, 0, 0, 0, time.UTC)
+
+// drain collects a run's events, failing when the channel is still open after timeout.
+func drain(t *testing.T, events <-chan Event, timeout time.Duration) []Event {
+	t.Helper()
+	var out []Event
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return out
+			}
+			out = append(out, ev)
+		case <-deadline:
+			t.Fatalf("event channel still open %s after the run was started (%d events)", timeout, len(out))
+		}
+	}
+}
+
+func TestRunnerCancelledCappedRunCloses(t *testing.T) {
+	route, fleet := simtest.Corridor(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// paced in real time, so the cap is far from reached when the client goes away
+	opts := RunnerOptions{PeriodID: 2, PassengerCap: 500, Start: testStart}
+	events, stop, wait := StartRunner(ctx, route, fleet, 7, 0.5, opts, StaticControl{SpeedMult: 1, ArrivalMult: 1})
+	defer stop()
+	time.AfterFunc(200*time.Millisecond, cancel)
+	out := drain(t, events, 10*time.Second)
+	wait()
+	if len(out) == 0 {
+		t.Fatal("no events")
+	}
+	done, ok := out[len(out)-1].(DoneEvent)
+	if !ok {
+		t.Fatalf("last event is %T, want DoneEvent", out[len(out)-1])
+	}
+	if done.Aborted == "" {
+		t.Errorf("cancelled run not reported as aborted: %+v", done.EndedBy)
+	}
+	for _, ev := range out {
+		if _, ok := ev.(RepositionStartEvent); ok {
+			t.Error("cancelled run started the reposition phase")
+		}
+	}
+}
+
+// eventTrace renders a run's events for comparison, with the wall-clock fields zeroed.
+func eventTrace(t *testing.T, events []Event) []string {
+	t.Helper()
+	out := make([]string, len(events))
+	for i, ev := range events {
+		if rc, ok := ev.(RepositionCompleteEvent); ok {
+			rc.ElapsedMs = 0
+			ev = rc
+		}
+		b, err := json.Marshal(ev)
+		if err != nil {
+			t.Fatalf("event %d (%T): %v", i, ev, err)
+		}
+		out[i] = fmt.Sprintf("%T %s", ev, b)
+	}
+	return out
+}
+
+func TestRunnerSameSeedSameEvents(t *testing.T) {
+	run := func() []string {
+		route, fleet := simtest.Corridor(t)
+		opts := RunnerOptions{PeriodID: 2, PassengerCap: 150, Start: testStart, Clock: NewHeadlessClock(testStart), MetricsInterval: 5 * time.Minute}
+		events, stop, wait := StartRunner(context.Background(), route, fleet, 11, 0.5, opts, StaticControl{SpeedMult: 1, ArrivalMult: 1})
+		defer stop()
+		out := drain(t, events, time.Minute)
+		wait()
+		return eventTrace(t, out)
+	}
+	a, b := run(), run()
+	if len(a) != len(b) {
+		t.Fatalf("event counts differ: %d vs %d", len(a), len(b))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("event %d differs:\n%s\n%s", i, a[i], b[i])
+		}
+	}
+	if len(a) == 0 || !strings.HasPrefix(a[len(a)-1], "sim.DoneEvent ") {
+		t.Fatal("run did not end with a DoneEvent")
+	}
+}
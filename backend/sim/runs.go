@@ -0,0 +1,158 @@
+package sim
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// RunResult is the stored outcome of a finished run, kept for comparisons.
+type RunResult struct {
+	ID           string         `json:"id"`
+	Started      time.Time      `json:"started"`
+	Finished     time.Time      `json:"finished"`
+	Params       map[string]any `json:"params,omitempty"`
+	Completed    bool           `json:"completed"`
+	Aborted      string         `json:"aborted,omitempty"`
+	Generated    int            `json:"generated"`
+	Served       int64          `json:"served"`
+	AvgWaitMin   float64        `json:"avg_wait_min"`
+	WaitP50Min   float64        `json:"wait_p50_min"`
+	WaitP90Min   float64        `json:"wait_p90_min"`
+	DistanceKM   float64        `json:"distance_km"`
+	Cost         float64        `json:"cost"`
+	QualityScore float64        `json:"quality_score"`
+	Stops        []StopStats    `json:"stops,omitempty"`
+}
+
+// NewRunResult summarizes a finished run; distance and cost follow the report rounding.
+func NewRunResult(id string, started time.Time, params map[string]any, buses []*model.Bus, done DoneEvent, quality QualityScore) *RunResult {
+	r := &RunResult{ID: id, Started: started, Finished: time.Now(), Params: params, Completed: done.Completed, Aborted: done.Aborted, Generated: done.Generated, Served: done.ServedPassengers, AvgWaitMin: done.AvgWaitMin, WaitP50Min: done.Wait.Overall.P50, WaitP90Min: done.Wait.Overall.P90, QualityScore: quality.Score, Stops: done.StopStats}
+	for _, t := range TypeBreakdown(buses, done.BusStats, done.BusDistance, true) {
+		r.DistanceKM += t.DistanceKM
+		r.Cost += t.Cost
+	}
+	return r
+}
+
+// RunStore keeps the most recent run results in memory; it is safe for concurrent use.
+type RunStore struct {
+	mu    sync.Mutex
+	limit int
+	order []string
+	runs  map[string]*RunResult
+}
+
+// NewRunStore keeps up to limit runs (limit <= 0 keeps none).
+func NewRunStore(limit int) *RunStore {
+	return &RunStore{limit: limit, runs: make(map[string]*RunResult)}
+}
+
+// Add stores r, evicting the oldest run beyond the limit.
+func (s *RunStore) Add(r *RunResult) {
+	if s == nil || s.limit <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.runs[r.ID]; !ok {
+		s.order = append(s.order, r.ID)
+	}
+	s.runs[r.ID] = r
+	for len(s.order) > s.limit {
+		delete(s.runs, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Get returns the run with the given id.
+func (s *RunStore) Get(id string) (*RunResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.runs[id]
+	return r, ok
+}
+
+// List returns the stored runs, oldest first.
+func (s *RunStore) List() []*RunResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*RunResult, 0, len(s.order))
+	for _, id := range s.order {
+		out = append(out, s.runs[id])
+	}
+	return out
+}
+
+// Delta compares one metric between run A and run B.
+type Delta struct {
+	A     float64  `json:"a"`
+	B     float64  `json:"b"`
+	Delta float64  `json:"delta"`         // B - A
+	Pct   *float64 `json:"pct,omitempty"` // relative change in percent (omitted when A is 0)
+}
+
+func newDelta(a, b float64) Delta {
+	d := Delta{A: a, B: b, Delta: b - a}
+	if a != 0 {
+		p := (b - a) / a * 100
+		d.Pct = &p
+	}
+	return d
+}
+
+// StopDelta compares one stop between two runs.
+type StopDelta struct {
+	StopID   int    `json:"stop_id"`
+	Name     string `json:"name"`
+	AvgWait  Delta  `json:"avg_wait_min"`
+	Boarded  Delta  `json:"boarded"`
+	Denied   Delta  `json:"denied_boardings"`
+	MaxQueue Delta  `json:"max_queue"`
+}
+
+// RunComparison is a structured diff of two runs (B relative to A).
+type RunComparison struct {
+	A         string      `json:"a"`
+	B         string      `json:"b"`
+	Served    Delta       `json:"served"`
+	Generated Delta       `json:"generated"`
+	AvgWait   Delta       `json:"avg_wait_min"`
+	WaitP50   Delta       `json:"wait_p50_min"`
+	WaitP90   Delta       `json:"wait_p90_min"`
+	Distance  Delta       `json:"distance_km"`
+	Cost      Delta       `json:"cost"`
+	Quality   Delta       `json:"quality_score"`
+	Stops     []StopDelta `json:"stops"`
+}
+
+// CompareRuns diffs b against a. Stops are matched by id; a stop present in only one
+// run compares against zeros.
+func CompareRuns(a, b *RunResult) RunComparison {
+	c := RunComparison{A: a.ID, B: b.ID, Served: newDelta(float64(a.Served), float64(b.Served)), Generated: newDelta(float64(a.Generated), float64(b.Generated)), AvgWait: newDelta(a.AvgWaitMin, b.AvgWaitMin), WaitP50: newDelta(a.WaitP50Min, b.WaitP50Min), WaitP90: newDelta(a.WaitP90Min, b.WaitP90Min), Distance: newDelta(a.DistanceKM, b.DistanceKM), Cost: newDelta(a.Cost, b.Cost), Quality: newDelta(a.QualityScore, b.QualityScore)}
+	as := make(map[int]StopStats, len(a.Stops))
+	bs := make(map[int]StopStats, len(b.Stops))
+	var ids []int
+	for _, s := range a.Stops {
+		as[s.StopID] = s
+		ids = append(ids, s.StopID)
+	}
+	for _, s := range b.Stops {
+		if _, ok := as[s.StopID]; !ok {
+			ids = append(ids, s.StopID)
+		}
+		bs[s.StopID] = s
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		sa, sb := as[id], bs[id]
+		name := sa.Name
+		if name == "" {
+			name = sb.Name
+		}
+		c.Stops = append(c.Stops, StopDelta{StopID: id, Name: name, AvgWait: newDelta(sa.AvgWaitMinutes, sb.AvgWaitMinutes), Boarded: newDelta(float64(sa.Boarded), float64(sb.Boarded)), Denied: newDelta(float64(sa.Denied), float64(sb.Denied)), MaxQueue: newDelta(float64(sa.MaxQueue), float64(sb.MaxQueue))})
+	}
+	return c
+}
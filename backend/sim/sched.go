@@ -0,0 +1,193 @@
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Scheduler runs the actors of a streaming run (its buses, demand generator and
+// periodic reporters, each a goroutine) one at a time in simulated-time order: an
+// actor holds the turn from the moment it wakes until it sleeps again, and the next
+// turn goes to the actor with the earliest wake time, ties to the one that joined
+// first. The clock paces the gaps between turns and reads the time of the latest one. Goroutines therefore never race on
+// the run's state, and a run with a fixed seed emits the same events in the same order
+// every time; only live controls, applied when they arrive, add wall-time ordering.
+type Scheduler struct {
+	ctx     context.Context
+	clock   Clock
+	mu      sync.Mutex
+	ready   sync.Cond
+	now     time.Time  // simulated time of the latest turn
+	queue   actorQueue // actors waiting for a turn
+	joined  int
+	turn    bool // an actor holds the turn
+	started bool
+	cut     bool // the clock was cut or ctx is done: every later turn reports false
+	stopped bool
+}
+
+// Actor is one goroutine of a Scheduler.
+type Actor struct {
+	s     *Scheduler
+	order int
+	at    time.Time // simulated time of the actor's next (or current) turn
+	wake  chan bool // receives the outcome of each turn
+	index int       // in the queue (-1 = not queued)
+	left  bool
+}
+
+// NewScheduler returns a scheduler at start pacing turns on clock. Actors joined
+// before Start wait until it is called.
+func NewScheduler(ctx context.Context, clock Clock, start time.Time) *Scheduler {
+	s := &Scheduler{ctx: ctx, clock: clock, now: start}
+	s.ready.L = &s.mu
+	go s.dispatch()
+	return s
+}
+
+// Start begins handing out turns.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	s.ready.Signal()
+}
+
+// Stop releases every waiting actor with false and ends the dispatcher; called once
+// the run is over.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.ready.Signal()
+}
+
+// Join adds an actor whose first turn is delay after the current turn. It is called
+// before Start or by the actor holding the turn, so joins happen in a fixed order; a
+// join while no actor holds the turn wakes the dispatcher, so the new actor still gets
+// its (cut off) turn. The actor's goroutine starts with Begin and ends with Leave.
+func (s *Scheduler) Join(delay time.Duration) *Actor {
+	s.mu.Lock()
+	a := &Actor{s: s, order: s.joined, at: s.now.Add(delay), wake: make(chan bool, 1), index: -1}
+	s.joined++
+	if s.stopped {
+		a.left = true
+		a.wake <- false
+		s.mu.Unlock()
+		return a
+	}
+	heap.Push(&s.queue, a)
+	s.mu.Unlock()
+	s.ready.Signal()
+	return a
+}
+
+// Begin waits for the actor's first turn; false when the run ended first.
+func (a *Actor) Begin() bool { return <-a.wake }
+
+// Sleep ends the actor's turn and waits for the next one, d of simulated time later;
+// false once the run is cut off or cancelled, the actor keeping the turn until it
+// leaves. A zero d still lets the actors due at the same time take their turns first.
+func (a *Actor) Sleep(d time.Duration) bool {
+	s := a.s
+	s.mu.Lock()
+	if s.cut {
+		s.mu.Unlock()
+		return false
+	}
+	a.at = a.at.Add(d)
+	heap.Push(&s.queue, a)
+	s.turn = false
+	s.mu.Unlock()
+	s.ready.Signal()
+	return <-a.wake
+}
+
+// Now returns the simulated time of the actor's turn.
+func (a *Actor) Now() time.Time { return a.at }
+
+// Leave ends the actor's turn for good; an actor that never got a turn is dropped
+// from the queue. Further calls do nothing.
+func (a *Actor) Leave() {
+	s := a.s
+	s.mu.Lock()
+	if a.left {
+		s.mu.Unlock()
+		return
+	}
+	a.left = true
+	if a.index >= 0 {
+		heap.Remove(&s.queue, a.index)
+	} else {
+		s.turn = false
+	}
+	s.mu.Unlock()
+	s.ready.Signal()
+}
+
+func (s *Scheduler) dispatch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for !s.stopped && (!s.started || s.turn || len(s.queue) == 0) {
+			s.ready.Wait()
+		}
+		if s.stopped {
+			s.cut = true
+			for len(s.queue) > 0 {
+				heap.Pop(&s.queue).(*Actor).wake <- false
+			}
+			return
+		}
+		if gap := s.queue[0].at.Sub(s.now); gap > 0 && !s.cut {
+			s.mu.Unlock()
+			ok := s.clock.Sleep(s.ctx, gap)
+			s.mu.Lock()
+			if !ok {
+				s.cut = true
+			}
+		} else if s.ctx.Err() != nil {
+			s.cut = true
+		}
+		if len(s.queue) == 0 { // the only waiting actor left meanwhile
+			continue
+		}
+		a := heap.Pop(&s.queue).(*Actor)
+		if a.at.After(s.now) {
+			s.now = a.at
+			s.clock.Set(s.now)
+		}
+		s.turn = true
+		a.wake <- !s.cut
+	}
+}
+
+// actorQueue orders waiting actors by wake time, then join order.
+type actorQueue []*Actor
+
+func (q actorQueue) Len() int { return len(q) }
+func (q actorQueue) Less(i, j int) bool {
+	if !q[i].at.Equal(q[j].at) {
+		return q[i].at.Before(q[j].at)
+	}
+	return q[i].order < q[j].order
+}
+func (q actorQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index, q[j].index = i, j
+}
+func (q *actorQueue) Push(x any) {
+	a := x.(*Actor)
+	a.index = len(*q)
+	*q = append(*q, a)
+}
+func (q *actorQueue) Pop() any {
+	old := *q
+	a := old[len(old)-1]
+	old[len(old)-1] = nil
+	a.index = -1
+	*q = old[:len(old)-1]
+	return a
+}
@@ -0,0 +1,86 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerTurnOrder(t *testing.T) {
+	clock := NewHeadlessClock(testStart)
+	s := NewScheduler(context.Background(), clock, testStart)
+	defer s.Stop()
+	var (
+		mu    sync.Mutex
+		turns []string
+		wg    sync.WaitGroup
+	)
+	// each actor sleeps through its steps, logging the simulated time of every turn
+	steps := [][]time.Duration{
+		{0, 3 * time.Second, 2 * time.Second},
+		{time.Second, 2 * time.Second, 2 * time.Second},
+		{0, 5 * time.Second},
+	}
+	for i, sleeps := range steps {
+		a := s.Join(0)
+		wg.Add(1)
+		go func(id int, sleeps []time.Duration) {
+			defer wg.Done()
+			defer a.Leave()
+			ok := a.Begin()
+			for _, d := range sleeps {
+				if ok {
+					ok = a.Sleep(d)
+				}
+				if !ok {
+					t.Errorf("actor %d lost its turn", id)
+					return
+				}
+				if got := clock.Now(); !got.Equal(a.Now()) {
+					t.Errorf("actor %d: clock at %s during a turn at %s", id, got.Sub(testStart), a.Now().Sub(testStart))
+				}
+				mu.Lock()
+				turns = append(turns, fmt.Sprintf("%s:%d", a.Now().Sub(testStart), id))
+				mu.Unlock()
+			}
+		}(i, sleeps)
+	}
+	s.Start()
+	wg.Wait()
+	// simulated-time order, ties to the actor that joined first
+	want := []string{"0s:0", "0s:2", "1s:1", "3s:0", "3s:1", "5s:0", "5s:1", "5s:2"}
+	if !reflect.DeepEqual(turns, want) {
+		t.Errorf("turns = %v, want %v", turns, want)
+	}
+}
+
+func TestSchedulerJoinWakesIdleDispatcher(t *testing.T) {
+	s := NewScheduler(context.Background(), NewHeadlessClock(testStart), testStart)
+	defer s.Stop()
+	first := s.Join(0)
+	s.Start()
+	if !first.Begin() {
+		t.Fatal("first actor got no turn")
+	}
+	first.Leave()
+	time.Sleep(20 * time.Millisecond) // let the dispatcher go back to waiting
+	// the dispatcher is waiting on an empty queue: a join from outside any turn
+	// must still hand the new actor its turn
+	a := s.Join(time.Second)
+	got := make(chan bool, 1)
+	go func() {
+		got <- a.Begin()
+		a.Leave()
+	}()
+	select {
+	case ok := <-got:
+		if !ok {
+			t.Error("actor joined to a running scheduler was turned away")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("actor joined to an idle scheduler never got a turn")
+	}
+}
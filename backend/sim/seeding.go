@@ -0,0 +1,56 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// DefaultSeedWindow is how far back initial (seeded) passengers may have arrived.
+const DefaultSeedWindow = 2 * time.Minute
+
+// SeedConfig controls the backdated arrival times of passengers seeded at start.
+type SeedConfig struct {
+	Window time.Duration // maximum backdating (0 = DefaultSeedWindow)
+	// Dist is the backdating distribution: "uniform" (default) over the window,
+	// "exponential" (memoryless arrivals, mean Window/3, truncated to the window)
+	// or "none" (everyone arrives at the start, zero initial wait).
+	Dist string
+	// ExcludeFromWait keeps seeded passengers out of wait statistics (averages,
+	// percentiles, per-stop waits) so the artificial backdating does not skew them.
+	ExcludeFromWait bool
+}
+
+// ValidateSeedDist reports whether dist is a known backdating distribution.
+func ValidateSeedDist(dist string) error {
+	switch dist {
+	case "", "uniform", "exponential", "none":
+		return nil
+	}
+	return fmt.Errorf("unknown seed distribution %q (want uniform, exponential or none)", dist)
+}
+
+// backdate draws how long before the start a seeded passenger arrived.
+func (c SeedConfig) backdate(rng *rand.Rand) time.Duration {
+	w := c.Window
+	if w <= 0 {
+		w = DefaultSeedWindow
+	}
+	switch c.Dist {
+	case "none":
+		return 0
+	case "exponential":
+		d := rng.ExpFloat64() * float64(w) / 3
+		return time.Duration(math.Min(d, float64(w)))
+	default:
+		return time.Duration(rng.Float64() * float64(w))
+	}
+}
+
+// CountsWait reports whether p's wait enters wait statistics.
+func (s *Simulator) CountsWait(p *model.Passenger) bool {
+	return p.WaitDuration != nil && !(p.Seeded && s.Seeding.ExcludeFromWait)
+}
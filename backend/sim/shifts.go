@@ -0,0 +1,232 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Shift hold and violation kinds.
+const (
+	ShiftBreak   = "break"   // mandatory break after MaxDriving without one
+	ShiftRelief  = "relief"  // driver change after MaxDuty
+	ShiftDuty    = "duty"    // violation: shift longer than MaxDuty
+	ShiftDriving = "driving" // violation: driving longer than MaxDriving without a break
+)
+
+// ShiftConfig sets the crew rules. Drivers are relieved and take their breaks at a
+// terminal only, so a trip that would end past a limit is not started: the hold is
+// taken at the terminal before it. A zero MaxDuty and MaxDriving disable shifts.
+type ShiftConfig struct {
+	MaxDuty    time.Duration // longest shift before the driver must be relieved
+	MaxDriving time.Duration // longest spell at the wheel without a break
+	Break      time.Duration // length of a mandatory break
+	Relief     time.Duration // time the bus is out of service for a driver change
+}
+
+// Enabled reports whether any limit is set.
+func (c ShiftConfig) Enabled() bool { return c.MaxDuty > 0 || c.MaxDriving > 0 }
+
+// String renders c in the ParseShiftConfig form ("off" when disabled).
+func (c ShiftConfig) String() string {
+	if !c.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("duty=%s,drive=%s,break=%s,relief=%s", c.MaxDuty, c.MaxDriving, c.Break, c.Relief)
+}
+
+// ParseShiftConfig parses "duty=8h,drive=4h,break=30m,relief=10m". An empty spec
+// disables shifts; break and relief default to 30m and 10m.
+func ParseShiftConfig(spec string) (ShiftConfig, error) {
+	c := ShiftConfig{Break: 30 * time.Minute, Relief: 10 * time.Minute}
+	if strings.TrimSpace(spec) == "" {
+		return ShiftConfig{}, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return c, fmt.Errorf("shifts: %q: want key=duration", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d < 0 {
+			return c, fmt.Errorf("shifts: invalid %s %q", k, v)
+		}
+		switch k {
+		case "duty":
+			c.MaxDuty = d
+		case "drive":
+			c.MaxDriving = d
+		case "break":
+			c.Break = d
+		case "relief":
+			c.Relief = d
+		default:
+			return c, fmt.Errorf("shifts: unknown key %q (want duty, drive, break, relief)", k)
+		}
+	}
+	return c, nil
+}
+
+// ShiftEvent reports a bus held at a terminal for a driver break or relief, or a trip
+// that ended past a limit (Kind is empty when the bus continues regardless).
+type ShiftEvent struct {
+	Time           time.Time        `json:"time"`
+	BusID          int              `json:"bus_id"`
+	Kind           string           `json:"kind"`   // ShiftBreak, ShiftRelief or empty
+	Driver         int              `json:"driver"` // driver on duty before the hold
+	TerminalStopID int              `json:"terminal_stop_id"`
+	HoldMin        float64          `json:"hold_min"`
+	DutyMin        float64          `json:"duty_min"`             // shift length so far
+	DrivingMin     float64          `json:"driving_min"`          // since the last break or relief
+	Violations     []ShiftViolation `json:"violations,omitempty"` // limits the trip just completed overran
+}
+
+func (ShiftEvent) isEvent() {}
+
+// ShiftViolation is a limit exceeded because no terminal was reached in time (a trip
+// ran longer than the previous one) or because the run ended mid-shift.
+type ShiftViolation struct {
+	Time      time.Time `json:"time"`
+	BusID     int       `json:"bus_id"`
+	Driver    int       `json:"driver"`
+	Kind      string    `json:"kind"` // ShiftDuty or ShiftDriving
+	LimitMin  float64   `json:"limit_min"`
+	ActualMin float64   `json:"actual_min"`
+}
+
+// ShiftStats summarizes crew holds over a run.
+type ShiftStats struct {
+	Drivers         int              `json:"drivers"` // shifts started (one per bus plus one per relief)
+	Breaks          int              `json:"breaks"`
+	Reliefs         int              `json:"reliefs"`
+	OutOfServiceMin float64          `json:"out_of_service_min"` // bus time spent in breaks and reliefs
+	Availability    float64          `json:"availability"`       // share of dispatched bus time in service (0..1)
+	Violations      []ShiftViolation `json:"violations,omitempty"`
+}
+
+type driverDuty struct {
+	driver       int
+	shiftStart   time.Time
+	driveStart   time.Time
+	lastTerminal time.Time
+	lastTrip     time.Duration
+	dispatched   time.Time
+}
+
+// ShiftTracker applies a ShiftConfig to the fleet. A nil tracker (shifts disabled)
+// holds nobody. Caller must ensure synchronization.
+type ShiftTracker struct {
+	cfg        ShiftConfig
+	duty       map[int]*driverDuty
+	drivers    int
+	breaks     int
+	reliefs    int
+	holdSec    float64
+	violations []ShiftViolation
+	finished   bool
+	busSec     float64
+}
+
+// NewShiftTracker returns a tracker for cfg, or nil when shifts are disabled.
+func NewShiftTracker(cfg ShiftConfig) *ShiftTracker {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &ShiftTracker{cfg: cfg, duty: make(map[int]*driverDuty)}
+}
+
+// Start begins the first shift of busID when it is dispatched at at.
+func (t *ShiftTracker) Start(busID int, at time.Time) {
+	if t == nil {
+		return
+	}
+	t.drivers++
+	t.duty[busID] = &driverDuty{driver: t.drivers, shiftStart: at, driveStart: at, lastTerminal: at, dispatched: at}
+}
+
+// Turnaround is called when busID completes a trip at terminalStopID at at. It records
+// the limits the trip overran and decides whether the driver must be relieved or take
+// a break before the next trip, assuming it lasts as long as the last one. hold is false
+// when the bus continues in service.
+func (t *ShiftTracker) Turnaround(busID, terminalStopID int, at time.Time) (ev ShiftEvent, hold bool) {
+	if t == nil {
+		return ShiftEvent{}, false
+	}
+	d, found := t.duty[busID]
+	if !found {
+		return ShiftEvent{}, false
+	}
+	d.lastTrip = at.Sub(d.lastTerminal)
+	duty, driving := at.Sub(d.shiftStart), at.Sub(d.driveStart)
+	ev = ShiftEvent{Time: at, BusID: busID, Driver: d.driver, TerminalStopID: terminalStopID, DutyMin: duty.Minutes(), DrivingMin: driving.Minutes(), Violations: t.check(busID, d, at)}
+	switch {
+	case t.cfg.MaxDuty > 0 && duty+d.lastTrip > t.cfg.MaxDuty:
+		ev.Kind, ev.HoldMin = ShiftRelief, t.cfg.Relief.Minutes()
+		t.reliefs++
+		t.drivers++
+		resume := at.Add(t.cfg.Relief)
+		*d = driverDuty{driver: t.drivers, shiftStart: resume, driveStart: resume, lastTerminal: resume, lastTrip: d.lastTrip, dispatched: d.dispatched}
+		t.holdSec += t.cfg.Relief.Seconds()
+		return ev, true
+	case t.cfg.MaxDriving > 0 && driving+d.lastTrip > t.cfg.MaxDriving:
+		ev.Kind, ev.HoldMin = ShiftBreak, t.cfg.Break.Minutes()
+		t.breaks++
+		d.driveStart = at.Add(t.cfg.Break)
+		d.lastTerminal = d.driveStart
+		t.holdSec += t.cfg.Break.Seconds()
+		return ev, true
+	}
+	d.lastTerminal = at
+	return ev, false
+}
+
+// check records and returns the limits the driver of bus has exceeded by at.
+func (t *ShiftTracker) check(busID int, d *driverDuty, at time.Time) []ShiftViolation {
+	n := len(t.violations)
+	if duty := at.Sub(d.shiftStart); t.cfg.MaxDuty > 0 && duty > t.cfg.MaxDuty {
+		t.violations = append(t.violations, ShiftViolation{Time: at, BusID: busID, Driver: d.driver, Kind: ShiftDuty, LimitMin: t.cfg.MaxDuty.Minutes(), ActualMin: duty.Minutes()})
+	}
+	if driving := at.Sub(d.driveStart); t.cfg.MaxDriving > 0 && driving > t.cfg.MaxDriving {
+		t.violations = append(t.violations, ShiftViolation{Time: at, BusID: busID, Driver: d.driver, Kind: ShiftDriving, LimitMin: t.cfg.MaxDriving.Minutes(), ActualMin: driving.Minutes()})
+	}
+	return append([]ShiftViolation(nil), t.violations[n:]...)
+}
+
+// Finish closes the shifts still open at the end of the run (at), recording the limits
+// they exceeded. Later calls do nothing.
+func (t *ShiftTracker) Finish(at time.Time) {
+	if t == nil || t.finished {
+		return
+	}
+	t.finished = true
+	for busID, d := range t.duty {
+		if at.After(d.driveStart) {
+			t.check(busID, d, at)
+		}
+		t.busSec += at.Sub(d.dispatched).Seconds()
+	}
+	sort.Slice(t.violations, func(i, j int) bool {
+		a, b := t.violations[i], t.violations[j]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		return a.BusID < b.BusID
+	})
+}
+
+// Stats returns the summary (nil for a nil tracker); call Finish first for the
+// end-of-run violations and availability.
+func (t *ShiftTracker) Stats() *ShiftStats {
+	if t == nil {
+		return nil
+	}
+	s := &ShiftStats{Drivers: t.drivers, Breaks: t.breaks, Reliefs: t.reliefs, OutOfServiceMin: t.holdSec / 60, Availability: 1, Violations: append([]ShiftViolation(nil), t.violations...)}
+	if t.busSec > 0 {
+		s.Availability = 1 - t.holdSec/t.busSec
+		if s.Availability < 0 {
+			s.Availability = 0
+		}
+	}
+	return s
+}
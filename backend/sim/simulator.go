@@ -18,7 +18,10 @@ type StopStats struct {
 	AvgWaitMinutes    float64 `json:"avg_wait_minutes"`
 	RemainingOutbound int     `json:"remaining_outbound_queue"`
 	RemainingInbound  int     `json:"remaining_inbound_queue"`
+	Denied            int     `json:"denied_boardings"` // passengers left waiting when a full bus departed in their direction
+	MaxQueue          int     `json:"max_queue"`        // peak waiting passengers (both directions)
 	sumWait           float64
+	waitCount         int
 }
 
 // Simulator parameters and state for a single bus on one route.
@@ -27,7 +30,7 @@ type Simulator struct {
 	Bus        *model.Bus
 	RNG        *rand.Rand
 	StartTime  time.Time
-	Now        time.Time
+	Clock      Clock // simulated time (a HeadlessClock at StartTime unless replaced)
 	PassengerID int
 
 	LambdaPerMinute float64 // expected passenger arrivals per stop per minute (outbound direction only for this demo)
@@ -40,8 +43,14 @@ type Simulator struct {
 	OutboundGenerated int  // number of outbound passengers generated
 	InboundGenerated  int  // number of inbound passengers generated
 
-	Completed []*model.Passenger
+	Completed []*model.Passenger // passengers RunOnce delivered (when RecordPassengers; otherwise recycled)
 	Stats     map[int]*StopStats
+
+	RecordPassengers bool               // if true every created passenger is kept in Passengers (journey log)
+	Passengers       []*model.Passenger // all created passengers in creation order (when RecordPassengers)
+	Seeding          SeedConfig         // backdating of passengers seeded at start
+
+	free []*model.Passenger // recycled passengers reused by newPassenger (see Recycle)
 }
 
 // NewSimulator constructs a simulator with given route and bus.
@@ -55,7 +64,7 @@ func NewSimulator(route *model.Route, bus *model.Bus, seed int64, lambdaPerMinut
 		Bus:            bus,
 		RNG:            rand.New(rand.NewSource(seed)),
 		StartTime:      start,
-		Now:            start,
+		Clock:          NewHeadlessClock(start),
 		LambdaPerMinute: lambdaPerMinute,
 		Stats:          stats,
 	PeriodID:       2, // default morning peak
@@ -65,6 +74,9 @@ func NewSimulator(route *model.Route, bus *model.Bus, seed int64, lambdaPerMinut
 	}
 }
 
+// Now returns the current simulated time.
+func (s *Simulator) Now() time.Time { return s.Clock.Now() }
+
 // RunOnce moves the bus from first to last stop generating passengers and handling board/alight.
 func (s *Simulator) RunOnce() {
 	// Seed initial passengers (simulate arrivals in previous 5 minutes)
@@ -91,12 +103,12 @@ func (s *Simulator) RunOnce() {
 	for idx := 0; idx < len(s.Route.Stops); idx++ {
 		stop := s.Route.Stops[idx]
 		// Bus arrives at stop at current time: alight first
-		alighted := s.Bus.AlightPassengersAtCurrentStop(s.Now)
+		alighted := s.Bus.AlightPassengersAtCurrentStop(s.Now())
 		if len(alighted) > 0 {
-			for _, p := range alighted { s.Completed = append(s.Completed, p) }
+			s.complete(alighted)
 		}
 		// Board waiting outbound passengers
-		boarded := stop.BoardAtStop(s.Bus, s.Now)
+		boarded := stop.BoardAtStop(s.Bus, s.Now())
 		if len(boarded) > 0 {
 			ss := s.Stats[stop.ID]
 			for _, p := range boarded {
@@ -112,15 +124,15 @@ func (s *Simulator) RunOnce() {
 		// If last stop, force alight any remaining passengers and finish
 		if idx == len(s.Route.Stops)-1 {
 			if len(s.Bus.Passengers) > 0 {
-				alighted := s.Bus.AlightPassengersAtCurrentStop(s.Now)
-				if len(alighted) > 0 { for _, p := range alighted { s.Completed = append(s.Completed, p) } }
+				alighted := s.Bus.AlightPassengersAtCurrentStop(s.Now())
+				s.complete(alighted)
 			}
 			break
 		}
 
 		// Determine departure (with simple dwell formula)
 		dwellSeconds := 15 + 2*len(boarded) + 1*len(alighted)
-		s.Now = s.Now.Add(time.Duration(dwellSeconds) * time.Second)
+		s.Clock.Advance(time.Duration(dwellSeconds) * time.Second)
 
 		// Travel to next stop
 		next := s.Route.Stops[idx+1]
@@ -129,12 +141,12 @@ func (s *Simulator) RunOnce() {
 		travelDur := time.Duration(travelMinutes * float64(time.Minute))
 
 		// During travel, generate passenger arrivals at downstream stops (excluding current and final already passed)
-		intervalStart := s.Now
-		intervalEnd := s.Now.Add(travelDur)
+		intervalStart := s.Now()
+		intervalEnd := intervalStart.Add(travelDur)
 		s.generateArrivals(intervalStart, intervalEnd, idx+1)
 
 		// Advance time to arrival at next stop
-		s.Now = intervalEnd
+		s.Clock.Set(intervalEnd)
 		s.Bus.CurrentStopID = next.ID
 	}
 
@@ -200,7 +212,8 @@ func (s *Simulator) newPassenger(origin, dest int, arrival time.Time) *model.Pas
 		if st.ID == dest { destIdx = i }
 	}
 	if originIdx >=0 && destIdx >=0 && destIdx < originIdx { dir = "inbound" }
-	return &model.Passenger{
+	p := s.allocPassenger()
+	*p = model.Passenger{
 		ID:             s.PassengerID,
 		RouteID:        s.Route.ID,
 		StartStopID:    origin,
@@ -208,6 +221,8 @@ func (s *Simulator) newPassenger(origin, dest int, arrival time.Time) *model.Pas
 		Direction:      dir,
 		ArrivalStopTime: arrival,
 	}
+	if s.RecordPassengers { s.Passengers = append(s.Passengers, p) }
+	return p
 }
 
 // NewPassengerPublic exposes passenger creation for streaming mode.
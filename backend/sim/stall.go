@@ -0,0 +1,71 @@
+package sim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// DefaultStallTimeout is the simulated time without any boarding, while passengers are
+// waiting, after which a run is considered stalled.
+const DefaultStallTimeout = 30 * time.Minute
+
+// QueuedPassengers returns the number of passengers waiting at stops per direction.
+func QueuedPassengers(route *model.Route) (outbound, inbound int) {
+	for _, st := range route.Stops {
+		outbound += len(st.OutboundQueue)
+		inbound += len(st.InboundQueue)
+	}
+	return outbound, inbound
+}
+
+// StallDiagnostic explains why waiting passengers are not being served.
+// Caller must ensure synchronization.
+func StallDiagnostic(route *model.Route, buses []*model.Bus, idle time.Duration) string {
+	qOut, qIn := QueuedPassengers(route)
+	var busOut, busIn, full, noSpeed, noCap int
+	for _, b := range buses {
+		if b.Direction == "inbound" {
+			busIn++
+		} else {
+			busOut++
+		}
+		if b.IsFull && b.Type != nil && b.Type.Capacity > 0 {
+			full++
+		}
+		if b.AverageSpeedKmph <= 0 {
+			noSpeed++
+		}
+		if b.Type == nil || b.Type.Capacity <= 0 {
+			noCap++
+		}
+	}
+	reasons := make([]string, 0, 4)
+	if len(buses) == 0 {
+		reasons = append(reasons, "no buses in fleet")
+	}
+	if noCap == len(buses) && len(buses) > 0 {
+		reasons = append(reasons, "no bus has passenger capacity")
+	} else if noCap > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d bus(es) without capacity", noCap))
+	}
+	if noSpeed > 0 {
+		reasons = append(reasons, fmt.Sprintf("%d bus(es) with zero speed never reach the next stop", noSpeed))
+	}
+	if full > 0 && full == len(buses) {
+		reasons = append(reasons, "every bus is full and no passenger alights")
+	}
+	if qOut > 0 && busOut == 0 {
+		reasons = append(reasons, "outbound passengers waiting but no bus currently runs outbound")
+	}
+	if qIn > 0 && busIn == 0 {
+		reasons = append(reasons, "inbound passengers waiting but no bus currently runs inbound")
+	}
+	if len(reasons) == 0 {
+		reasons = append(reasons, "cause unknown")
+	}
+	return fmt.Sprintf("no boardings for %.0f sim-min with %d passengers waiting (outbound=%d, inbound=%d); buses outbound=%d inbound=%d full=%d: %s",
+		idle.Minutes(), qOut+qIn, qOut, qIn, busOut, busIn, full, strings.Join(reasons, "; "))
+}
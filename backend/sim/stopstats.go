@@ -0,0 +1,65 @@
+package sim
+
+import "brt08/backend/model"
+
+// Per-stop live aggregates kept in Simulator.Stats. Callers must hold the engine lock.
+
+func (s *Simulator) stopStats(stopID int) *StopStats {
+	ss := s.Stats[stopID]
+	if ss == nil {
+		ss = &StopStats{StopID: stopID}
+		if st := s.Route.GetStop(stopID); st != nil {
+			ss.Name = st.Name
+		}
+		s.Stats[stopID] = ss
+	}
+	return ss
+}
+
+func (s *Simulator) noteArrival(st *model.BusStop) {
+	s.stopStats(st.ID).ArrivalsGenerated++
+	s.NoteQueues(st)
+}
+
+// NoteQueues refreshes the remaining and peak queue lengths of a stop.
+func (s *Simulator) NoteQueues(st *model.BusStop) {
+	ss := s.stopStats(st.ID)
+	ss.RemainingOutbound = len(st.OutboundQueue)
+	ss.RemainingInbound = len(st.InboundQueue)
+	if q := ss.RemainingOutbound + ss.RemainingInbound; q > ss.MaxQueue {
+		ss.MaxQueue = q
+	}
+}
+
+// NoteBoarding records a boarding round of bus at st. Passengers still queued in the
+// bus direction when the bus is full count as denied boardings.
+func (s *Simulator) NoteBoarding(st *model.BusStop, bus *model.Bus, boarded []*model.Passenger) {
+	ss := s.stopStats(st.ID)
+	for _, p := range boarded {
+		if s.CountsWait(p) {
+			ss.sumWait += *p.WaitDuration
+			ss.waitCount++
+		}
+	}
+	ss.Boarded += len(boarded)
+	if ss.waitCount > 0 {
+		ss.AvgWaitMinutes = ss.sumWait / float64(ss.waitCount)
+	}
+	if bus.Type != nil && bus.PassengersOnboard >= bus.Type.Capacity {
+		if bus.Direction == "inbound" {
+			ss.Denied += len(st.InboundQueue)
+		} else {
+			ss.Denied += len(st.OutboundQueue)
+		}
+	}
+	s.NoteQueues(st)
+}
+
+// StopStatsSnapshot returns a copy of the per-stop aggregates in route order.
+func (s *Simulator) StopStatsSnapshot() []StopStats {
+	out := make([]StopStats, 0, len(s.Route.Stops))
+	for _, st := range s.Route.Stops {
+		out = append(out, *s.stopStats(st.ID))
+	}
+	return out
+}
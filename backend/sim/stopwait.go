@@ -0,0 +1,54 @@
+package sim
+
+import "time"
+
+// StopWaitWindow is how far back (simulated time) the rolling per-stop wait looks.
+const StopWaitWindow = 15 * time.Minute
+
+// RollingStopWait keeps the waits of passengers who boarded at each stop within the
+// last Window of simulated time, so live events can report current service quality
+// per stop rather than the run-wide average. Not safe for concurrent use.
+type RollingStopWait struct {
+	Window time.Duration
+	byStop map[int][]stopWaitSample
+}
+
+type stopWaitSample struct {
+	t   time.Time
+	min float64
+}
+
+// NewRollingStopWait returns a tracker over window (StopWaitWindow when <= 0).
+func NewRollingStopWait(window time.Duration) *RollingStopWait {
+	if window <= 0 {
+		window = StopWaitWindow
+	}
+	return &RollingStopWait{Window: window, byStop: make(map[int][]stopWaitSample)}
+}
+
+// Add records one boarding at stopID at time t after waiting waitMin minutes.
+func (r *RollingStopWait) Add(stopID int, t time.Time, waitMin float64) {
+	r.byStop[stopID] = append(r.byStop[stopID], stopWaitSample{t: t, min: waitMin})
+}
+
+// Avg drops samples older than the window and returns the mean wait at stopID and the
+// number of boardings it covers (0, 0 when nobody boarded there recently).
+func (r *RollingStopWait) Avg(stopID int, now time.Time) (float64, int) {
+	s := r.byStop[stopID]
+	cut := 0
+	for cut < len(s) && now.Sub(s[cut].t) > r.Window {
+		cut++
+	}
+	if cut > 0 {
+		s = append(s[:0], s[cut:]...)
+		r.byStop[stopID] = s
+	}
+	if len(s) == 0 {
+		return 0, 0
+	}
+	sum := 0.0
+	for _, x := range s {
+		sum += x.min
+	}
+	return sum / float64(len(s)), len(s)
+}
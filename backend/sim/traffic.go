@@ -0,0 +1,97 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TravelTimeRequest describes one bus departure on a stop-to-stop segment.
+type TravelTimeRequest struct {
+	BusID      int       `json:"bus_id"`
+	FromStopID int       `json:"from_stop_id"`
+	ToStopID   int       `json:"to_stop_id"`
+	Direction  string    `json:"direction"`
+	DistanceKM float64   `json:"distance_km"`
+	SpeedKmph  float64   `json:"speed_kmph"` // bus free-running speed used by the internal model
+	Depart     time.Time `json:"depart"`     // simulated departure time
+	Phase      string    `json:"phase,omitempty"`
+}
+
+// TravelTimeProvider supplies segment running times from an external traffic model
+// (e.g. a SUMO/TraCI bridge). Returning ok=false falls back to the internal speed model.
+type TravelTimeProvider interface {
+	SegmentTravelTime(req TravelTimeRequest) (d time.Duration, ok bool)
+}
+
+// ResolveTravelTime asks the provider (if any) for the segment time and falls back otherwise.
+func ResolveTravelTime(p TravelTimeProvider, req TravelTimeRequest, fallback time.Duration) time.Duration {
+	if p == nil {
+		return fallback
+	}
+	if d, ok := p.SegmentTravelTime(req); ok && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// HTTPTravelTimeProvider POSTs each TravelTimeRequest as JSON to URL and expects
+// {"travel_time_s": <seconds>} back. Any error or non-200 response falls back to the
+// internal model; failures are logged once per minute to avoid flooding.
+type HTTPTravelTimeProvider struct {
+	URL    string
+	Client *http.Client
+
+	mu        sync.Mutex
+	lastLog   time.Time
+	failCount int
+}
+
+// NewHTTPTravelTimeProvider returns a provider with a short per-request timeout,
+// since every bus departure waits on the adapter.
+func NewHTTPTravelTimeProvider(url string) *HTTPTravelTimeProvider {
+	return &HTTPTravelTimeProvider{URL: url, Client: &http.Client{Timeout: 2 * time.Second}}
+}
+
+func (h *HTTPTravelTimeProvider) SegmentTravelTime(req TravelTimeRequest) (time.Duration, bool) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		h.fail(err)
+		return 0, false
+	}
+	resp, err := h.Client.Post(h.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		h.fail(err)
+		return 0, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		h.fail(fmt.Errorf("status %d", resp.StatusCode))
+		return 0, false
+	}
+	var out struct {
+		TravelTimeS *float64 `json:"travel_time_s"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		h.fail(err)
+		return 0, false
+	}
+	if out.TravelTimeS == nil || *out.TravelTimeS <= 0 {
+		return 0, false
+	}
+	return time.Duration(*out.TravelTimeS * float64(time.Second)), true
+}
+
+func (h *HTTPTravelTimeProvider) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failCount++
+	if time.Since(h.lastLog) >= time.Minute {
+		slog.Warn("traffic feed unavailable, falling back to internal speeds", "url", h.URL, "failures", h.failCount, "err", err)
+		h.lastLog = time.Now()
+	}
+}
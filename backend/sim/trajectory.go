@@ -0,0 +1,96 @@
+package sim
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"brt08/backend/atomicfile"
+	"brt08/backend/model"
+)
+
+// DefaultSimplifyToleranceM is the Douglas-Peucker tolerance applied to recorded and
+// exported geometry; a few meters is invisible on a map but drops most interpolation steps.
+const DefaultSimplifyToleranceM = 5.0
+
+type trajectoryPoint struct {
+	at  time.Time
+	pos model.LatLng
+}
+
+// TrajectoryRecorder keeps timestamped bus positions of a run. A nil recorder records
+// nothing; it is safe for concurrent use.
+type TrajectoryRecorder struct {
+	mu     sync.Mutex
+	points map[int][]trajectoryPoint
+}
+
+func NewTrajectoryRecorder() *TrajectoryRecorder {
+	return &TrajectoryRecorder{points: make(map[int][]trajectoryPoint)}
+}
+
+// Add records bus busID at pos at sim time at.
+func (r *TrajectoryRecorder) Add(busID int, at time.Time, pos model.LatLng) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.points[busID] = append(r.points[busID], trajectoryPoint{at: at, pos: pos})
+	r.mu.Unlock()
+}
+
+// WriteTrajectories writes one GeoJSON LineString feature per bus to the given path or
+// directory, simplified with Douglas-Peucker at toleranceM meters (0 keeps every point).
+// Each feature carries the timestamps of its kept vertices in "times".
+func WriteTrajectories(outPath string, r *TrajectoryRecorder, toleranceM float64) (string, error) {
+	if outPath == "" || r == nil {
+		return "", nil
+	}
+	type feature struct {
+		Type       string         `json:"type"`
+		Properties map[string]any `json:"properties"`
+		Geometry   map[string]any `json:"geometry"`
+	}
+	r.mu.Lock()
+	ids := make([]int, 0, len(r.points))
+	for id := range r.points {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	features := make([]feature, 0, len(ids))
+	raw, kept := 0, 0
+	for _, id := range ids {
+		pts := r.points[id]
+		if len(pts) < 2 {
+			continue
+		}
+		line := make([]model.LatLng, len(pts))
+		for i, p := range pts {
+			line[i] = p.pos
+		}
+		idx := model.SimplifyIndices(line, toleranceM)
+		coords := make([][2]float64, len(idx))
+		times := make([]time.Time, len(idx))
+		for i, j := range idx {
+			coords[i] = [2]float64{pts[j].pos.Lng, pts[j].pos.Lat}
+			times[i] = pts[j].at
+		}
+		raw += len(pts)
+		kept += len(idx)
+		features = append(features, feature{Type: "Feature", Properties: map[string]any{"bus_id": id, "times": times, "points_recorded": len(pts)}, Geometry: map[string]any{"type": "LineString", "coordinates": coords}})
+	}
+	r.mu.Unlock()
+
+	b, err := json.Marshal(map[string]any{"type": "FeatureCollection", "features": features})
+	if err != nil {
+		return "", err
+	}
+	p := timestampedPath(outPath, "trajectories", ".geojson", time.Now().Format("20060102-150405"))
+	if err := atomicfile.WriteFile(p, b); err != nil {
+		return "", err
+	}
+	slog.Info("trajectories written", "path", p, "buses", len(features), "points", raw, "kept", kept, "tolerance_m", toleranceM)
+	return p, nil
+}
@@ -0,0 +1,273 @@
+package sim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"brt08/backend/model"
+)
+
+// DefaultTurnaroundTime is how long a bus takes at a terminal to turn into the other
+// direction.
+const DefaultTurnaroundTime = 3 * time.Second
+
+// TurnaroundConfig sets the turnaround bays of both terminals and the recovery time
+// buses lay over there. The zero value turns every bus at once in
+// DefaultTurnaroundTime and sends it straight back.
+type TurnaroundConfig struct {
+	Bays     int            // buses each terminal turns at once (0 = unlimited)
+	Time     time.Duration  // time a turn takes in a bay (0 = DefaultTurnaroundTime)
+	Recovery RecoveryConfig // minimum layover from arrival at a terminal to departure
+}
+
+// Duration is the time a turn takes.
+func (c TurnaroundConfig) Duration() time.Duration {
+	if c.Time > 0 {
+		return c.Time
+	}
+	return DefaultTurnaroundTime
+}
+
+// RecoveryRule is a minimum layover at a terminal (empty = both), in a period (0 = all).
+type RecoveryRule struct {
+	Terminal string // stop id or name of the terminal
+	PeriodID int
+	Minutes  float64
+}
+
+// RecoveryConfig is the schedule recovery time at the terminals: the least time from
+// a bus's arrival at a terminal to its departure the other way, the turn included.
+// The most specific rule applies (terminal and period, period, terminal, then the
+// default); the zero value has no recovery time.
+type RecoveryConfig struct {
+	Rules []RecoveryRule
+}
+
+// Enabled reports whether any recovery time is configured.
+func (c RecoveryConfig) Enabled() bool { return len(c.Rules) > 0 }
+
+// String formats the config in ParseRecovery syntax.
+func (c RecoveryConfig) String() string {
+	parts := make([]string, 0, len(c.Rules))
+	for _, r := range c.Rules {
+		key := r.Terminal
+		if r.PeriodID > 0 {
+			key += "@" + strconv.Itoa(r.PeriodID)
+		}
+		m := strconv.FormatFloat(r.Minutes, 'f', -1, 64)
+		if key == "" {
+			parts = append(parts, m)
+		} else {
+			parts = append(parts, key+"="+m)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseRecovery parses comma-separated minimum layovers in minutes: a bare number for
+// both terminals in every period, "terminal=minutes" for one terminal (its stop id or
+// name), "@period=minutes" for one period and "terminal@period=minutes" for both, e.g.
+// "3,kivukoni=5,@2=8". An empty spec sets no recovery time.
+func ParseRecovery(spec string) (RecoveryConfig, error) {
+	var c RecoveryConfig
+	if strings.TrimSpace(spec) == "" {
+		return c, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		key, v, ok := strings.Cut(part, "=")
+		if !ok {
+			key, v = "", part
+		}
+		m, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || m < 0 {
+			return RecoveryConfig{}, fmt.Errorf("recovery: invalid minutes %q", v)
+		}
+		r := RecoveryRule{Minutes: m}
+		term, period, hasPeriod := strings.Cut(strings.TrimSpace(key), "@")
+		r.Terminal = strings.TrimSpace(term)
+		if ok && r.Terminal == "" && !hasPeriod {
+			return RecoveryConfig{}, fmt.Errorf("recovery: %q: want terminal=minutes, @period=minutes or minutes", part)
+		}
+		if hasPeriod {
+			if r.PeriodID, err = strconv.Atoi(strings.TrimSpace(period)); err != nil || r.PeriodID < 1 {
+				return RecoveryConfig{}, fmt.Errorf("recovery: invalid period %q", period)
+			}
+		}
+		c.Rules = append(c.Rules, r)
+	}
+	return c, nil
+}
+
+// Validate checks that every named terminal is one of route's two terminals.
+func (c RecoveryConfig) Validate(route *model.Route) error {
+	for _, r := range c.Rules {
+		if r.Terminal != "" && len(route.Stops) > 0 && !matchTerminal(r.Terminal, route.Stops[0]) && !matchTerminal(r.Terminal, route.Stops[len(route.Stops)-1]) {
+			return fmt.Errorf("recovery: %q is not a terminal of route %d (%s or %s)", r.Terminal, route.ID, route.Stops[0].Name, route.Stops[len(route.Stops)-1].Name)
+		}
+	}
+	return nil
+}
+
+func matchTerminal(key string, st *model.BusStop) bool {
+	return key == strconv.Itoa(st.ID) || strings.EqualFold(key, st.Name)
+}
+
+// Minimum returns the recovery time at terminal st in periodID.
+func (c RecoveryConfig) Minimum(st *model.BusStop, periodID int) time.Duration {
+	best, rank := 0.0, -1
+	for _, r := range c.Rules {
+		if r.Terminal != "" && !matchTerminal(r.Terminal, st) || r.PeriodID != 0 && r.PeriodID != periodID {
+			continue
+		}
+		k := 0
+		if r.Terminal != "" {
+			k++
+		}
+		if r.PeriodID != 0 {
+			k += 2
+		}
+		if k >= rank {
+			best, rank = r.Minutes, k
+		}
+	}
+	return time.Duration(best * float64(time.Minute))
+}
+
+// TurnaroundEvent reports a bus turning at a terminal with limited bays or a recovery
+// time: the bay it got, how long it waited for it, its layover and when it is ready to
+// run the other way.
+type TurnaroundEvent struct {
+	Time        time.Time `json:"time"` // arrival at the terminal
+	BusID       int       `json:"bus_id"`
+	StopID      int       `json:"stop_id"`
+	Bay         int       `json:"bay,omitempty"` // 1-based (limited bays only)
+	Bays        int       `json:"bays,omitempty"`
+	Queued      int       `json:"queued"` // buses already waiting for a bay on arrival
+	WaitSec     float64   `json:"wait_s"`
+	RecoveryMin float64   `json:"recovery_min"` // layover after the turn to meet the recovery time
+	Ready       time.Time `json:"ready"`
+}
+
+func (TurnaroundEvent) isEvent() {}
+
+// TurnaroundStats summarizes the turns at one terminal.
+type TurnaroundStats struct {
+	StopID      int     `json:"stop_id"`
+	Name        string  `json:"stop_name"`
+	Bays        int     `json:"bays"` // 0 = unlimited
+	Turns       int     `json:"turns"`
+	Queued      int     `json:"queued"`       // turns that waited for a bay
+	WaitMin     float64 `json:"wait_min"`     // total time buses waited for a bay
+	MaxWaitMin  float64 `json:"max_wait_min"` // longest single wait
+	MaxQueue    int     `json:"max_queue"`    // most buses waiting at once
+	RecoveryMin float64 `json:"recovery_min"` // total layover after turns to meet the recovery time
+	Utilization float64 `json:"utilization"`  // share of bay time occupied over the run (limited bays only)
+}
+
+type yardTerminal struct {
+	stop    *model.BusStop
+	freeAt  []time.Time // per bay (nil = unlimited)
+	starts  []time.Time // turns reserved to start later than their arrival
+	busyMin float64
+	stats   TurnaroundStats
+}
+
+// TurnaroundYard assigns the terminals' turnaround bays to arriving buses in request
+// order, each turn holding a bay for the configured time, and holds buses for the
+// recovery time. Caller must ensure synchronization.
+type TurnaroundYard struct {
+	turn      time.Duration
+	recovery  RecoveryConfig
+	terminals map[int]*yardTerminal // nil = unlimited bays and no recovery time
+}
+
+// NewTurnaroundYard returns the yard of route's two terminals.
+func NewTurnaroundYard(route *model.Route, cfg TurnaroundConfig) *TurnaroundYard {
+	y := &TurnaroundYard{turn: cfg.Duration(), recovery: cfg.Recovery}
+	if cfg.Bays <= 0 && !cfg.Recovery.Enabled() || len(route.Stops) == 0 {
+		return y
+	}
+	y.terminals = make(map[int]*yardTerminal, 2)
+	for _, st := range []*model.BusStop{route.Stops[0], route.Stops[len(route.Stops)-1]} {
+		t := &yardTerminal{stop: st, stats: TurnaroundStats{StopID: st.ID, Name: st.Name, Bays: max(cfg.Bays, 0)}}
+		if cfg.Bays > 0 {
+			t.freeAt = make([]time.Time, cfg.Bays)
+		}
+		y.terminals[st.ID] = t
+	}
+	return y
+}
+
+// Turn books the turn of busID arriving at terminal stopID at at in periodID and
+// returns when the bus is ready to leave in its new direction. The event is set (true)
+// when the bays are limited or a recovery time is configured.
+func (y *TurnaroundYard) Turn(stopID, busID, periodID int, at time.Time) (time.Time, TurnaroundEvent, bool) {
+	t, ok := y.terminals[stopID]
+	if !ok {
+		return at.Add(y.turn), TurnaroundEvent{}, false
+	}
+	ev := TurnaroundEvent{Time: at, BusID: busID, StopID: stopID}
+	start := at
+	if t.freeAt != nil {
+		kept := t.starts[:0]
+		for _, s := range t.starts {
+			if s.After(at) {
+				kept = append(kept, s)
+				ev.Queued++
+			}
+		}
+		t.starts = kept
+		bay := 0
+		for i, f := range t.freeAt {
+			if f.Before(t.freeAt[bay]) {
+				bay = i
+			}
+		}
+		if t.freeAt[bay].After(at) {
+			start = t.freeAt[bay]
+			t.starts = append(t.starts, start)
+			t.stats.Queued++
+			t.stats.MaxQueue = max(t.stats.MaxQueue, ev.Queued+1)
+		}
+		t.freeAt[bay] = start.Add(y.turn)
+		t.busyMin += y.turn.Minutes()
+		ev.Bay, ev.Bays = bay+1, len(t.freeAt)
+	}
+	ready := start.Add(y.turn)
+	if earliest := at.Add(y.recovery.Minimum(t.stop, periodID)); earliest.After(ready) {
+		ev.RecoveryMin = earliest.Sub(ready).Minutes()
+		t.stats.RecoveryMin += ev.RecoveryMin
+		ready = earliest
+	}
+	wait := start.Sub(at)
+	t.stats.Turns++
+	t.stats.WaitMin += wait.Minutes()
+	t.stats.MaxWaitMin = max(t.stats.MaxWaitMin, wait.Minutes())
+	ev.WaitSec, ev.Ready = wait.Seconds(), ready
+	return ready, ev, true
+}
+
+// Stats returns the turns at both terminals in route order over start to end; nil
+// with unlimited bays and no recovery time.
+func (y *TurnaroundYard) Stats(route *model.Route, start, end time.Time) []TurnaroundStats {
+	if y.terminals == nil {
+		return nil
+	}
+	var out []TurnaroundStats
+	span := end.Sub(start).Minutes()
+	for _, st := range route.Stops {
+		t, ok := y.terminals[st.ID]
+		if !ok {
+			continue
+		}
+		v := t.stats
+		if span > 0 && len(t.freeAt) > 0 {
+			v.Utilization = min(1, t.busyMin/(span*float64(len(t.freeAt))))
+		}
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package sim
+
+import (
+	"sort"
+
+	"brt08/backend/model"
+)
+
+// BusTypeStats aggregates the service of all buses of one type, the level at which
+// fleet-mix decisions are made.
+type BusTypeStats struct {
+	TypeID       int     `json:"type_id"`
+	TypeName     string  `json:"type_name"`
+	Buses        int     `json:"buses"`
+	Capacity     int     `json:"capacity"`
+	DistanceKM   float64 `json:"distance_km"`
+	Cost         float64 `json:"cost"`
+	ServiceHours float64 `json:"service_hours"`
+	DistanceCost float64 `json:"distance_cost"` // Cost split by model.BusType.CostBreakdown (unrounded)
+	TimeCost     float64 `json:"time_cost"`
+	FixedCost    float64 `json:"fixed_cost"`
+	Boarded      int     `json:"passengers_carried"`
+	AvgLoad      float64 `json:"avg_load"`      // time-weighted passengers onboard per bus
+	AvgOccupancy float64 `json:"avg_occupancy"` // capacity-weighted (0..1)
+}
+
+// TypeBreakdown groups buses by type, ordered by type id. Distances and costs are
+// summed from the per-bus values rounded by ReportPrecision (machine selects the output
+// rounding), so they add up with the per-bus report rows; time-based costs use the
+// hours in service from stats. Boardings and loads come from stats when given, else
+// boardings fall back to Bus.TotalBoarded.
+func TypeBreakdown(buses []*model.Bus, stats []BusStats, distance map[int]float64, machine bool) []BusTypeStats {
+	byBus := make(map[int]BusStats, len(stats))
+	for _, s := range stats {
+		byBus[s.BusID] = s
+	}
+	type acc struct {
+		BusTypeStats
+		loadSec, capSec, sec float64
+	}
+	byType := make(map[int]*acc)
+	hours := ServiceHours(stats)
+	for _, b := range buses {
+		id, name, capacity := 0, "unknown", 0
+		if b.Type != nil {
+			id, name, capacity = b.Type.ID, b.Type.Name, b.Type.Capacity
+		}
+		a := byType[id]
+		if a == nil {
+			a = &acc{BusTypeStats: BusTypeStats{TypeID: id, TypeName: name, Capacity: capacity}}
+			byType[id] = a
+		}
+		d, c := ReportPrecision.BusCost(distance[b.ID], hours[b.ID], b.Type, machine)
+		dc, tc, fc := b.Type.CostBreakdown(d, hours[b.ID])
+		a.Buses++
+		a.DistanceKM += d
+		a.Cost += c
+		a.ServiceHours += hours[b.ID]
+		a.DistanceCost += dc
+		a.TimeCost += tc
+		a.FixedCost += fc
+		if s, ok := byBus[b.ID]; ok {
+			a.Boarded += s.Boarded
+			a.loadSec += s.AvgLoad * s.InServiceSec
+			a.capSec += float64(s.Capacity) * s.InServiceSec
+			a.sec += s.InServiceSec
+		} else {
+			a.Boarded += b.TotalBoarded
+		}
+	}
+	out := make([]BusTypeStats, 0, len(byType))
+	for _, a := range byType {
+		if a.sec > 0 {
+			a.AvgLoad = a.loadSec / a.sec
+		}
+		if a.capSec > 0 {
+			a.AvgOccupancy = a.loadSec / a.capSec
+		}
+		out = append(out, a.BusTypeStats)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TypeID < out[j].TypeID })
+	return out
+}
@@ -0,0 +1,203 @@
+package sim
+
+import (
+	"math"
+	"sort"
+)
+
+// WaitBinEdges are the histogram bucket edges in minutes; the last bucket is open-ended.
+var WaitBinEdges = []float64{0, 2, 5, 10, 15, 20, 30, 45, 60}
+
+// WaitBin is one histogram bucket of boarding waits.
+type WaitBin struct {
+	LoMin float64 `json:"lo_min"`
+	HiMin float64 `json:"hi_min"` // 0 for the open-ended last bucket
+	Count int     `json:"count"`
+}
+
+// WaitPercentiles summarizes a set of boarding waits (minutes).
+type WaitPercentiles struct {
+	Count     int       `json:"count"`
+	Mean      float64   `json:"mean_min"`
+	P50       float64   `json:"p50_min"`
+	P90       float64   `json:"p90_min"`
+	P95       float64   `json:"p95_min"`
+	Max       float64   `json:"max_min"`
+	Histogram []WaitBin `json:"histogram"`
+}
+
+// WaitDistribution holds wait summaries overall, per direction and per boarding stop.
+type WaitDistribution struct {
+	Overall     WaitPercentiles            `json:"overall"`
+	ByDirection map[string]WaitPercentiles `json:"by_direction"`
+	ByStop      map[int]WaitPercentiles    `json:"by_stop"`
+}
+
+// WaitStats collects boarding waits in bounded memory (see waitSamples). Caller must
+// ensure synchronization.
+type WaitStats struct {
+	all    waitSamples
+	byDir  map[string]*waitSamples
+	byStop map[int]*waitSamples
+}
+
+// NewWaitStats creates an empty collector.
+func NewWaitStats() *WaitStats {
+	return &WaitStats{byDir: make(map[string]*waitSamples), byStop: make(map[int]*waitSamples)}
+}
+
+// Add records the wait of one boarded passenger.
+func (w *WaitStats) Add(stopID int, dir string, waitMin float64) {
+	w.all.add(waitMin)
+	d := w.byDir[dir]
+	if d == nil {
+		d = &waitSamples{}
+		w.byDir[dir] = d
+	}
+	d.add(waitMin)
+	st := w.byStop[stopID]
+	if st == nil {
+		st = &waitSamples{}
+		w.byStop[stopID] = st
+	}
+	st.add(waitMin)
+}
+
+// Distribution computes percentiles and histograms for every scope.
+func (w *WaitStats) Distribution() WaitDistribution {
+	d := WaitDistribution{Overall: w.all.summarize(), ByDirection: make(map[string]WaitPercentiles), ByStop: make(map[int]WaitPercentiles)}
+	for dir, v := range w.byDir {
+		d.ByDirection[dir] = v.summarize()
+	}
+	for sid, v := range w.byStop {
+		d.ByStop[sid] = v.summarize()
+	}
+	return d
+}
+
+const (
+	// maxExactWaits is how many waits a scope keeps as exact samples; past it they
+	// fold into fixed bins, so a run of millions of passengers needs no more memory
+	// than one of thousands.
+	maxExactWaits  = 1 << 14
+	waitBinsPerMin = 10      // fine bins are 6 s wide
+	maxBinnedWait  = 24 * 60 // minutes; longer waits share the last fine bin
+)
+
+// waitSamples is one scope's waits: exact up to maxExactWaits, after that counts in
+// 6 s bins, percentiles then being interpolated within a bin (within 0.1 min). The
+// count, mean, maximum and WaitBinEdges histogram stay exact either way.
+type waitSamples struct {
+	exact []float64
+	bins  []int32 // fine bins once exact overflowed
+	n     int
+	sum   float64
+	max   float64
+}
+
+func (w *waitSamples) add(v float64) {
+	w.n++
+	w.sum += v
+	if v > w.max || w.n == 1 {
+		w.max = v
+	}
+	if w.bins == nil && len(w.exact) < maxExactWaits {
+		w.exact = append(w.exact, v)
+		return
+	}
+	if w.bins == nil {
+		w.bins = make([]int32, maxBinnedWait*waitBinsPerMin+1)
+		for _, e := range w.exact {
+			w.bins[fineBin(e)]++
+		}
+		w.exact = nil
+	}
+	w.bins[fineBin(v)]++
+}
+
+func fineBin(v float64) int {
+	i := int(v * waitBinsPerMin)
+	if i < 0 {
+		return 0
+	}
+	if last := maxBinnedWait * waitBinsPerMin; i > last {
+		return last
+	}
+	return i
+}
+
+func (w *waitSamples) summarize() WaitPercentiles {
+	if w.bins == nil {
+		return summarizeWaits(w.exact)
+	}
+	out := emptyWaitSummary(w.n)
+	for i, c := range w.bins {
+		out.Histogram[waitBin(float64(i)/waitBinsPerMin)].Count += int(c)
+	}
+	out.Mean = w.sum / float64(w.n)
+	out.P50 = w.percentile(50)
+	out.P90 = w.percentile(90)
+	out.P95 = w.percentile(95)
+	out.Max = w.max
+	return out
+}
+
+// percentile interpolates the p-th percentile from the fine bins, spreading each
+// bin's waits evenly across it.
+func (w *waitSamples) percentile(p float64) float64 {
+	rank := p / 100 * float64(w.n-1)
+	seen := 0
+	for i, c := range w.bins {
+		if c == 0 || float64(seen+int(c)) <= rank {
+			seen += int(c)
+			continue
+		}
+		v := (float64(i) + (rank-float64(seen)+0.5)/float64(c)) / waitBinsPerMin
+		return math.Min(v, w.max)
+	}
+	return w.max
+}
+
+func emptyWaitSummary(n int) WaitPercentiles {
+	out := WaitPercentiles{Count: n, Histogram: make([]WaitBin, len(WaitBinEdges))}
+	for i, lo := range WaitBinEdges {
+		hi := 0.0
+		if i+1 < len(WaitBinEdges) {
+			hi = WaitBinEdges[i+1]
+		}
+		out.Histogram[i] = WaitBin{LoMin: lo, HiMin: hi}
+	}
+	return out
+}
+
+// waitBin is the WaitBinEdges bucket of v.
+func waitBin(v float64) int {
+	i := sort.SearchFloat64s(WaitBinEdges, v)
+	if i == len(WaitBinEdges) || WaitBinEdges[i] > v {
+		i--
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+func summarizeWaits(samples []float64) WaitPercentiles {
+	out := emptyWaitSummary(len(samples))
+	if len(samples) == 0 {
+		return out
+	}
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	sum := 0.0
+	for _, v := range sorted {
+		sum += v
+		out.Histogram[waitBin(v)].Count++
+	}
+	out.Mean = sum / float64(len(sorted))
+	out.P50 = Percentile(sorted, 50)
+	out.P90 = Percentile(sorted, 90)
+	out.P95 = Percentile(sorted, 95)
+	out.Max = sorted[len(sorted)-1]
+	return out
+}
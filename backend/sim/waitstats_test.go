@@ -0,0 +1,36 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestWaitSamplesFoldPastExactLimit(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var w waitSamples
+	all := make([]float64, 0, 4*maxExactWaits)
+	for i := 0; i < 4*maxExactWaits; i++ {
+		v := rng.ExpFloat64() * 5
+		w.add(v)
+		all = append(all, v)
+	}
+	if w.exact != nil || w.bins == nil {
+		t.Fatalf("kept %d exact samples past the limit", len(w.exact))
+	}
+	got, want := w.summarize(), summarizeWaits(all)
+	if got.Count != want.Count || math.Abs(got.Mean-want.Mean) > 1e-9 || got.Max != want.Max {
+		t.Errorf("count/mean/max = %d/%v/%v, want %d/%v/%v", got.Count, got.Mean, got.Max, want.Count, want.Mean, want.Max)
+	}
+	for i := range want.Histogram {
+		if got.Histogram[i].Count != want.Histogram[i].Count {
+			t.Errorf("histogram bin %v: %d, want %d", want.Histogram[i].LoMin, got.Histogram[i].Count, want.Histogram[i].Count)
+		}
+	}
+	// percentiles interpolate within 6 s bins
+	for _, p := range [][2]float64{{got.P50, want.P50}, {got.P90, want.P90}, {got.P95, want.P95}} {
+		if math.Abs(p[0]-p[1]) > 0.1 {
+			t.Errorf("percentile %v, exact %v", p[0], p[1])
+		}
+	}
+}
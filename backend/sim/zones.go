@@ -0,0 +1,156 @@
+package sim
+
+import "brt08/backend/model"
+
+// ZoneStats aggregates ridership and load over the stops tagged with one corridor zone
+// (BusStop.Zone), the way planners discuss the corridor (e.g. Kimara-Ubungo).
+type ZoneStats struct {
+	Zone       string  `json:"zone"`
+	Stops      int     `json:"stops"`
+	Arrivals   int     `json:"arrivals"`     // passengers generated at the zone's stops
+	Boarded    int     `json:"boarded"`      // boardings at the zone's stops
+	Alighted   int     `json:"alighted"`     // alightings at the zone's stops
+	Denied     int     `json:"denied"`       // denied boardings (full buses)
+	Ridership  int     `json:"ridership"`    // passengers who rode in the zone: carried in plus boarded there
+	AvgWaitMin float64 `json:"avg_wait_min"` // boarding-weighted mean wait
+	Departures int     `json:"departures"`   // bus departures onto the zone's segments
+	AvgLoad    float64 `json:"avg_load"`     // mean passengers aboard per departure
+	MaxLoad    int     `json:"max_load"`
+	Occupancy  float64 `json:"avg_occupancy"` // aboard / capacity over the departures (0..1)
+}
+
+// ZoneRecorder accumulates per-zone movements from stop visits. A segment belongs to the
+// zone of the stop a bus departs from. Caller must ensure synchronization; methods on a
+// nil recorder (route without zones) do nothing.
+type ZoneRecorder struct {
+	route   *model.Route
+	index   map[int]int // stop id -> route position
+	zones   []string    // in route order of first appearance
+	zoneOf  map[int]string
+	carried map[string]int
+	alight  map[string]int
+	deps    map[string]int
+	load    map[string]int
+	cap     map[string]int
+	maxLoad map[string]int
+}
+
+// NewZoneRecorder returns a recorder for route, or nil when no stop has a zone.
+func NewZoneRecorder(route *model.Route) *ZoneRecorder {
+	r := &ZoneRecorder{route: route, index: make(map[int]int), zoneOf: make(map[int]string), carried: make(map[string]int), alight: make(map[string]int), deps: make(map[string]int), load: make(map[string]int), cap: make(map[string]int), maxLoad: make(map[string]int)}
+	seen := make(map[string]bool)
+	for i, st := range route.Stops {
+		r.index[st.ID] = i
+		if st.Zone == "" {
+			continue
+		}
+		r.zoneOf[st.ID] = st.Zone
+		if !seen[st.Zone] {
+			seen[st.Zone] = true
+			r.zones = append(r.zones, st.Zone)
+		}
+	}
+	if len(r.zones) == 0 {
+		return nil
+	}
+	return r
+}
+
+// neighbor returns the stop id before (step -1) or after (step 1) stopID in the
+// direction of travel, or 0 past a terminal.
+func (r *ZoneRecorder) neighbor(stopID int, dir string, step int) int {
+	i, ok := r.index[stopID]
+	if !ok {
+		return 0
+	}
+	if dir == "inbound" {
+		step = -step
+	}
+	j := i + step
+	if j < 0 || j >= len(r.route.Stops) {
+		return 0
+	}
+	return r.route.Stops[j].ID
+}
+
+// Arrive records bus b reaching stopID (before alighting); on entering a zone its
+// passengers count toward the zone's ridership.
+func (r *ZoneRecorder) Arrive(stopID int, b *model.Bus) {
+	if r == nil {
+		return
+	}
+	z := r.zoneOf[stopID]
+	if z != "" && r.zoneOf[r.neighbor(stopID, b.Direction, -1)] != z {
+		r.carried[z] += b.PassengersOnboard
+	}
+}
+
+// Alight records n passengers leaving a bus at stopID.
+func (r *ZoneRecorder) Alight(stopID, n int) {
+	if r == nil {
+		return
+	}
+	if z := r.zoneOf[stopID]; z != "" {
+		r.alight[z] += n
+	}
+}
+
+// Depart records bus b leaving stopID with its current load; departures from the last
+// stop in the direction of travel (no segment ahead) are ignored.
+func (r *ZoneRecorder) Depart(stopID int, b *model.Bus) {
+	if r == nil || r.neighbor(stopID, b.Direction, 1) == 0 {
+		return
+	}
+	z := r.zoneOf[stopID]
+	if z == "" {
+		return
+	}
+	r.deps[z]++
+	r.load[z] += b.PassengersOnboard
+	if b.Type != nil {
+		r.cap[z] += b.Type.Capacity
+	}
+	if b.PassengersOnboard > r.maxLoad[z] {
+		r.maxLoad[z] = b.PassengersOnboard
+	}
+}
+
+// Stats combines the recorded movements with the per-stop aggregates (arrivals,
+// boardings, denied boardings and waits) into one row per zone, in route order.
+func (r *ZoneRecorder) Stats(stops []StopStats) []ZoneStats {
+	if r == nil {
+		return nil
+	}
+	out := make([]ZoneStats, len(r.zones))
+	pos := make(map[string]int, len(r.zones))
+	for i, z := range r.zones {
+		pos[z] = i
+		out[i] = ZoneStats{Zone: z, Alighted: r.alight[z], Departures: r.deps[z], MaxLoad: r.maxLoad[z]}
+		if d := r.deps[z]; d > 0 {
+			out[i].AvgLoad = float64(r.load[z]) / float64(d)
+		}
+		if c := r.cap[z]; c > 0 {
+			out[i].Occupancy = float64(r.load[z]) / float64(c)
+		}
+	}
+	waitSum := make([]float64, len(out))
+	for _, s := range stops {
+		i, ok := pos[r.zoneOf[s.StopID]]
+		if !ok {
+			continue
+		}
+		z := &out[i]
+		z.Stops++
+		z.Arrivals += s.ArrivalsGenerated
+		z.Boarded += s.Boarded
+		z.Denied += s.Denied
+		waitSum[i] += s.AvgWaitMinutes * float64(s.Boarded)
+	}
+	for i := range out {
+		out[i].Ridership = r.carried[out[i].Zone] + out[i].Boarded
+		if out[i].Boarded > 0 {
+			out[i].AvgWaitMin = waitSum[i] / float64(out[i].Boarded)
+		}
+	}
+	return out
+}
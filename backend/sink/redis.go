@@ -0,0 +1,189 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis appends records to a Redis stream with XADD (fields conn_id, event, time,
+// data), speaking RESP directly so no client library is needed. Each batch is one
+// pipelined round trip; a broken connection is redialled on the next batch.
+type Redis struct {
+	addr     string
+	password string
+	db       int
+	key      string
+	maxLen   int // approximate stream length cap (0 = unbounded)
+
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// NewRedis configures a sink from redis://[:password@]host:port/<key>[?maxlen=N&db=N].
+func NewRedis(u *url.URL) (*Redis, error) {
+	r := &Redis{addr: u.Host, key: strings.TrimPrefix(u.Path, "/")}
+	if r.addr == "" {
+		r.addr = "localhost:6379"
+	} else if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if r.key == "" {
+		r.key = "brt08:events"
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	q := u.Query()
+	for name, dst := range map[string]*int{"maxlen": &r.maxLen, "db": &r.db} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("sink: redis %s must be a non-negative integer, got %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	return r, nil
+}
+
+func (r *Redis) dial(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", r.addr)
+	if err != nil {
+		return err
+	}
+	r.conn, r.rd = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if r.password != "" {
+		setup = append(setup, []string{"AUTH", r.password})
+	}
+	if r.db > 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.db)})
+	}
+	if len(setup) > 0 {
+		if err := r.roundTrip(ctx, setup); err != nil {
+			r.reset()
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Redis) reset() {
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.conn, r.rd = nil, nil
+}
+
+func (r *Redis) Write(ctx context.Context, recs []Record) error {
+	if r.conn == nil {
+		if err := r.dial(ctx); err != nil {
+			return fmt.Errorf("redis %s: %w", r.addr, err)
+		}
+	}
+	cmds := make([][]string, len(recs))
+	for i, rec := range recs {
+		cmd := []string{"XADD", r.key}
+		if r.maxLen > 0 {
+			cmd = append(cmd, "MAXLEN", "~", strconv.Itoa(r.maxLen))
+		}
+		cmds[i] = append(cmd, "*", "conn_id", rec.ConnID, "event", rec.Event, "time", rec.Time.UTC().Format(time.RFC3339Nano), "data", string(rec.Data))
+	}
+	if err := r.roundTrip(ctx, cmds); err != nil {
+		r.reset()
+		return fmt.Errorf("redis %s: %w", r.addr, err)
+	}
+	return nil
+}
+
+// roundTrip pipelines cmds and reads one reply per command, failing on the first
+// error reply.
+func (r *Redis) roundTrip(ctx context.Context, cmds [][]string) error {
+	if dl, ok := ctx.Deadline(); ok {
+		r.conn.SetDeadline(dl)
+	} else {
+		r.conn.SetDeadline(time.Time{})
+	}
+	w := bufio.NewWriter(r.conn)
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "*%d\r\n", len(cmd))
+		for _, arg := range cmd {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	var firstErr error
+	for range cmds {
+		if err := readReply(r.rd); err != nil && firstErr == nil {
+			firstErr = err
+			if _, isReply := err.(redisError); !isReply {
+				return err // the connection is unusable
+			}
+		}
+	}
+	return firstErr
+}
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// readReply consumes one RESP reply, returning error replies as redisError.
+func readReply(rd *bufio.Reader) error {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return fmt.Errorf("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return nil
+	case '-':
+		return redisError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("bad bulk length %q", line)
+		}
+		if n < 0 {
+			return nil
+		}
+		_, err = io.CopyN(io.Discard, rd, int64(n)+2)
+		return err
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return fmt.Errorf("bad array length %q", line)
+		}
+		for i := 0; i < n; i++ {
+			if err := readReply(rd); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected reply %q", line)
+}
+
+func (r *Redis) Close() error {
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn, r.rd = nil, nil
+	return err
+}
@@ -0,0 +1,146 @@
+// Package sink forwards the events of streaming runs to external pipelines (Redis
+// Streams, Kafka) alongside the point-to-point SSE connections, so long simulations
+// can feed downstream analytics.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Record is one stream event as sent to clients: the SSE event name and its JSON data.
+type Record struct {
+	ConnID string          `json:"conn_id"`
+	Event  string          `json:"event"`
+	Time   time.Time       `json:"time"` // wall time the event was emitted
+	Data   json.RawMessage `json:"data"`
+}
+
+// EventSink delivers batches of records to an external system. Write is called from
+// a single goroutine; Close releases connections.
+type EventSink interface {
+	Write(ctx context.Context, recs []Record) error
+	Close() error
+}
+
+// Open returns the sink of spec, a URL selecting the implementation:
+//
+//	redis://[:password@]host:6379/<stream key>[?maxlen=100000]
+//	kafka+http://rest-proxy:8082/<topic>   (Kafka REST proxy, v2 JSON API)
+func Open(spec string) (EventSink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("sink: %w", err)
+	}
+	switch u.Scheme {
+	case "redis":
+		return NewRedis(u)
+	case "kafka+http", "kafka+https":
+		return NewKafkaREST(u)
+	}
+	return nil, fmt.Errorf("sink: unknown scheme %q (want redis or kafka+http)", u.Scheme)
+}
+
+// Async batches records on a background goroutine so publishing never waits on the
+// sink: when the buffer is full the record is dropped and counted. Failed batches are
+// logged (at most once a minute) and dropped.
+type Async struct {
+	sink    EventSink
+	ch      chan Record
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Int64
+	failed  atomic.Int64
+	sent    atomic.Int64
+}
+
+// asyncBatch bounds a batch; asyncLinger is how long a partial batch waits for more.
+const (
+	asyncBatch  = 500
+	asyncLinger = 200 * time.Millisecond
+)
+
+// NewAsync starts publishing to s with room for buffer pending records (0 = 10000).
+func NewAsync(s EventSink, buffer int) *Async {
+	if buffer <= 0 {
+		buffer = 10000
+	}
+	a := &Async{sink: s, ch: make(chan Record, buffer), done: make(chan struct{})}
+	go a.loop()
+	return a
+}
+
+// Publish queues r without blocking. A nil Async discards it.
+func (a *Async) Publish(r Record) {
+	if a == nil {
+		return
+	}
+	select {
+	case a.ch <- r:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+func (a *Async) loop() {
+	defer close(a.done)
+	var batch []Record
+	var lastLog time.Time
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := a.sink.Write(ctx, batch)
+		cancel()
+		if err != nil {
+			a.failed.Add(int64(len(batch)))
+			if time.Since(lastLog) > time.Minute {
+				slog.Warn("event sink write failed; records dropped", "records", len(batch), "err", err)
+				lastLog = time.Now()
+			}
+		} else {
+			a.sent.Add(int64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+	ticker := time.NewTicker(asyncLinger)
+	defer ticker.Stop()
+	for {
+		select {
+		case r, ok := <-a.ch:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= asyncBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close sends the pending records and closes the sink. Publish must not be called
+// afterwards.
+func (a *Async) Close() error {
+	if a == nil {
+		return nil
+	}
+	var err error
+	a.once.Do(func() {
+		close(a.ch)
+		<-a.done
+		err = a.sink.Close()
+		slog.Info("event sink closed", "sent", a.sent.Load(), "dropped", a.dropped.Load(), "failed", a.failed.Load())
+	})
+	return err
+}
@@ -0,0 +1,55 @@
+// Package telemetry wires OpenTelemetry tracing for streams and runs.
+//
+// Instrumented code uses the global tracer provider (otel.Tracer), which is a no-op
+// until Setup installs an SDK provider, so tracing costs nothing when disabled.
+package telemetry
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ServiceName identifies this process in exported spans.
+const ServiceName = "brt08-backend"
+
+// Setup installs a global tracer provider exporting spans as JSON to path ("-" for
+// stderr). An empty path leaves tracing disabled. The returned shutdown flushes
+// pending spans and must be called before exit.
+func Setup(path string) (shutdown func(context.Context) error, err error) {
+	if path == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	var out io.Writer = os.Stderr
+	var f *os.File
+	if path != "-" {
+		if f, err = os.Create(path); err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(out))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", ServiceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if f != nil {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,21 @@
+// Package web embeds the built map frontend so the backend can be deployed as a single
+// binary. Build it with `npm run build:embed` in frontend/ before `go build`; until then
+// dist holds a placeholder page.
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:dist
+var dist embed.FS
+
+// FS returns the embedded frontend rooted at its index.html.
+func FS() fs.FS {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		panic(err) // dist is fixed at compile time
+	}
+	return sub
+}
@@ -14,13 +14,15 @@ function createBusIcon() {
     });
 }
 function createStopMarker(stop) {
+    const km = typeof stop.cumulative_distance_km === 'number' ? `<br/>${stop.cumulative_distance_km.toFixed(2)} km from start` : '';
+    const layover = stop.allow_layover ? '<br/>Layover allowed' : '';
     return L.circleMarker([stop.latitute, stop.longtude], {
-        radius: 4,
-        weight: 1,
-        color: '#222',
+        radius: stop.allow_layover ? 6 : 4,
+        weight: stop.allow_layover ? 2 : 1,
+        color: stop.allow_layover ? '#1b4965' : '#222',
         fillColor: '#ffb703',
         fillOpacity: 0.9
-    }).bindTooltip(`<strong>${stop.stop_name}</strong><br/>ID: ${stop.stop_id}`, { direction: 'top' });
+    }).bindTooltip(`<strong>${stop.stop_name}</strong><br/>ID: ${stop.stop_id}${km}${layover}`, { direction: 'top' });
 }
 function interpolate(a, b, t) { return a + (b - a) * t; }
 function buildSegments(stops) {
@@ -37,7 +39,7 @@ async function init() {
     // try backend API first, fallback to static
     let data; // we'll normalize to RouteData shape
     try {
-        const r = await fetch('/api/route');
+        const r = await fetch('/api/route?pins=1');
         if (!r.ok)
             throw new Error('backend route fetch failed');
         data = await r.json();
@@ -53,6 +55,8 @@ async function init() {
         latitute: s.latitute ?? s.latitude ?? s.Latitude ?? s.lat ?? s.Lat,
         longtude: s.longtude ?? s.longitude ?? s.Longitude ?? s.lng ?? s.Long,
         distance_next_stop: s.distance_next_stop ?? s.distance_to_next ?? s.DistanceToNext ?? 0,
+        cumulative_distance_km: s.cumulative_distance_km,
+        allow_layover: s.allow_layover ?? false,
     }));
     const pins = (data.pins || []).map((p) => ({
         left_stop_id: p.left_stop_id,
@@ -91,6 +95,16 @@ async function init() {
     };
     // Fit map to stops bounds
     const visibleStopMarkers = stops.map(createStopMarker);
+    const stopMarkerById = {};
+    stops.forEach((s, i) => (stopMarkerById[s.stop_id] = visibleStopMarkers[i]));
+    // Color a stop by its rolling average wait (board events): green <= 5 min, amber <= 10, red beyond
+    function colorStopByWait(id, avgWaitMin) {
+        const m = stopMarkerById[id];
+        if (!m)
+            return;
+        const fill = avgWaitMin <= 5 ? '#52b788' : avgWaitMin <= 10 ? '#f4a261' : '#e63946';
+        m.setStyle({ fillColor: fill });
+    }
     const group = L.featureGroup(visibleStopMarkers);
     group.addTo(map);
     map.fitBounds(group.getBounds().pad(0.15));
@@ -257,6 +271,8 @@ async function init() {
     // transient labels removed per new behavior request
     let totals = { total: 0, outbound: 0, inbound: 0, served: 0, avgWaitMin: 0 };
     let legendState = 'Waiting for simulation...';
+    // Set when the configured demand exceeds the fleet's corridor capacity
+    let capacityWarning = null;
     // Plain absolute legend (simpler & guaranteed visibility)
     if (!document.getElementById('legend-style')) {
         const style = document.createElement('style');
@@ -290,19 +306,33 @@ async function init() {
             `<div style='margin-top:2px;'>` +
             `<span style='color:#1976d2;font-weight:600;'>Outbound: ${totals.outbound}</span><br/>` +
             `<span style='color:#c62828;font-weight:600;'>Inbound: ${totals.inbound}</span>` +
-            `</div>`;
+            `</div>` +
+            (capacityWarning
+                ? `<div style='margin-top:4px;color:#e65100;font-weight:600;' title='${capacityWarning.message.replace(/'/g, '&#39;')}'>` +
+                    `Demand exceeds capacity (${Math.round(capacityWarning.utilization * 100)}%)</div>`
+                : '');
     }
     renderLegend();
-    // SSE connection
+    // SSE connection; seed and start in the page URL replay a run (see the init params)
+    function replayQuery() {
+        const page = new URLSearchParams(window.location.search);
+        let q = '';
+        for (const key of ['seed', 'start']) {
+            const v = page.get(key);
+            if (v)
+                q += `${key}=${encodeURIComponent(v)}&`;
+        }
+        return q;
+    }
     function openStream() {
         let es;
         try {
             const sp = Number(speedRange?.value || '1');
-            es = new EventSource(`/api/stream?speed=${encodeURIComponent(String(sp))}`);
+            es = new EventSource(`/api/stream?${replayQuery()}speed=${encodeURIComponent(String(sp))}`);
         }
         catch {
             const sp = Number(speedRange?.value || '1');
-            es = new EventSource(`http://localhost:8080/api/stream?speed=${encodeURIComponent(String(sp))}`);
+            es = new EventSource(`http://localhost:8080/api/stream?${replayQuery()}speed=${encodeURIComponent(String(sp))}`);
         }
         return es;
     }
@@ -325,9 +355,9 @@ async function init() {
         es.addEventListener('error', () => scheduleReconnect());
         es.addEventListener('init', ev => {
             reconnectAttempts = 0;
-            renderLegend('Simulation started');
             try {
                 const d = JSON.parse(ev.data);
+                renderLegend(d.params?.seed !== undefined ? `Simulation started (seed ${d.params.seed})` : 'Simulation started');
                 if (d.conn_id) {
                     connId = String(d.conn_id);
                 }
@@ -423,6 +453,50 @@ async function init() {
             }
             catch { }
         });
+        es.addEventListener('initial_state', ev => {
+            try {
+                const d = JSON.parse(ev.data);
+                for (const q of d.stops || []) {
+                    if (stops.find(s => s.stop_id === q.stop_id))
+                        updateStopCount(q.stop_id, q.outbound_queue, q.inbound_queue);
+                }
+                if (typeof d.generated_passengers === 'number') {
+                    totals.total = d.generated_passengers;
+                    totals.outbound = d.outbound_generated ?? totals.outbound;
+                    totals.inbound = d.inbound_generated ?? totals.inbound;
+                    renderLegend('Running');
+                }
+            }
+            catch { }
+        });
+        // Full snapshot answering a "resync" control action
+        es.addEventListener('state', ev => {
+            try {
+                const d = JSON.parse(ev.data);
+                for (const s of d.buses || []) {
+                    let b = buses[s.bus_id];
+                    if (!b) {
+                        b = buses[s.bus_id] = createBusState(s.bus_id, s.direction, s.lat, s.lng, s.capacity, s.bus_onboard);
+                    }
+                    b.direction = s.direction;
+                    b.capacity = s.capacity;
+                    b.onboard = s.bus_onboard;
+                    b.marker.setLatLng([s.lat, s.lng]);
+                    refreshBus(b);
+                }
+                for (const q of d.stops || []) {
+                    if (stops.find(s => s.stop_id === q.stop_id))
+                        updateStopCount(q.stop_id, q.outbound_queue, q.inbound_queue);
+                }
+                totals.total = d.generated_passengers ?? totals.total;
+                totals.outbound = d.outbound_generated ?? totals.outbound;
+                totals.inbound = d.inbound_generated ?? totals.inbound;
+                totals.served = d.served_passengers ?? totals.served;
+                totals.avgWaitMin = d.avg_wait_min ?? totals.avgWaitMin;
+                renderLegend('Running');
+            }
+            catch { }
+        });
         es.addEventListener('stop_update', ev => {
             try {
                 const d = JSON.parse(ev.data);
@@ -470,6 +544,8 @@ async function init() {
                             b.onboard = b.capacity;
                         refreshBus(b);
                     }
+                    if (typeof d.stop_avg_wait_min === 'number' && d.stop_wait_samples > 0)
+                        colorStopByWait(d.stop_id, d.stop_avg_wait_min);
                     const outboundQ = d.stop_outbound ?? d.outbound_queue ?? d.stop_queue;
                     const inboundQ = d.stop_inbound ?? d.inbound_queue;
                     if (typeof outboundQ === 'number')
@@ -504,6 +580,15 @@ async function init() {
             }
             catch { }
         });
+        es.addEventListener('capacity_warning', ev => {
+            try {
+                const d = JSON.parse(ev.data);
+                capacityWarning = { message: String(d.message ?? ''), utilization: Number(d.check?.utilization ?? 0) };
+                console.warn(capacityWarning.message);
+                renderLegend();
+            }
+            catch { }
+        });
         es.addEventListener('done', () => {
             renderLegend('Trip complete');
             es.close();
@@ -527,6 +612,12 @@ async function init() {
             }
         }
     }
+    // Events dispatched while the tab was hidden may have been throttled away; ask for a snapshot
+    document.addEventListener('visibilitychange', () => {
+        if (document.visibilityState !== 'visible' || !connId)
+            return;
+        fetch('/api/control', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ conn_id: connId, action: 'resync' }) }).catch(() => { });
+    });
     speedRange?.addEventListener('input', () => {
         const sp = Number(speedRange.value) || 1;
         speedVal.textContent = `${sp.toFixed(2)}x`;
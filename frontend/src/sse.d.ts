@@ -3,6 +3,9 @@ declare interface WindowEventMap {
   'move': MessageEvent;
   'board': MessageEvent;
   'alight': MessageEvent;
+  'initial_state': MessageEvent;
+  'state': MessageEvent;
+  'capacity_warning': MessageEvent;
   'stop_update': MessageEvent;
   'done': MessageEvent;
 }
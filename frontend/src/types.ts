@@ -4,6 +4,8 @@ export interface Stop {
   latitute: number;
   longtude: number;
   distance_next_stop: number;
+  cumulative_distance_km?: number;
+  allow_layover?: boolean;
 }
 
 export interface Pin {
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"brt08/backend/data"
+	"brt08/backend/model"
+	"brt08/backend/sim"
+)
+
+func corridor() (*model.Route, []*model.Bus) {
+	rf, _ := data.FS().Open("kimara_kivukoni_stops.json")
+	route, err := model.LoadRouteFromReader(rf, 1)
+	if err != nil { panic(err) }
+	ff, _ := data.FS().Open("fleet.json")
+	types, qty, err := model.LoadFleetFromReader(ff)
+	if err != nil { panic(err) }
+	first, last := route.Stops[0].ID, route.Stops[len(route.Stops)-1].ID
+	return route, model.BuildFleetBuses(types, qty, route.ID, first, last, rand.New(rand.NewSource(1)))
+}
+
+func main() {
+	start := time.Date(2024, 3, 4, 6, 0, 0, 0, time.UTC)
+	route, fleet := corridor()
+	fmt.Println("buses", len(fleet))
+	opts := sim.RunnerOptions{PeriodID: 2, Start: start, Clock: sim.NewHeadlessClock(start), Criterion: sim.StopCriterion{Duration: time.Hour}, RecordPassengers: true}
+	evs, stop, wait := sim.StartRunner(context.Background(), route, fleet, 11, 1.2, opts, sim.StaticControl{SpeedMult: 1, ArrivalMult: 1})
+	defer stop()
+	var maxArr time.Time
+	n := 0
+	var done sim.DoneEvent
+	for e := range evs {
+		n++
+		switch v := e.(type) {
+		case sim.ArriveEvent:
+			if v.Time.After(maxArr) { maxArr = v.Time }
+		case sim.DoneEvent:
+			done = v
+		}
+	}
+	wait()
+	fmt.Println("events", n, "last arrive time offset", maxArr.Sub(start))
+	fmt.Println("avg wait min", done.AvgWaitMin, "served", done.ServedPassengers, "gen", done.Generated, "ended", done.EndedBy)
+	var maxArrival time.Time
+	for _, p := range done.Passengers {
+		if p.ArrivalStopTime.After(maxArrival) { maxArrival = p.ArrivalStopTime }
+	}
+	fmt.Println("latest passenger arrival offset", maxArrival.Sub(start))
+}